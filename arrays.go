@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+// strategies for handling fields holding arrays of objects
+const (
+	// arraySummary replaces the array with its length and the sums of
+	// numeric keys
+	arraySummary = "summary"
+	// arrayExplode sends one event per entry, each with the parent's fields
+	arrayExplode = "explode"
+	// arrayFirst flattens the first K entries into numbered fields
+	arrayFirst = "first"
+)
+
+// arrayField is a parsed --array_field
+type arrayField struct {
+	field    string
+	strategy string
+	// sumKeys are the keys to total, for arraySummary
+	sumKeys []string
+	// first is the number of entries to keep, for arrayFirst
+	first int
+}
+
+// parseArrayFields parses --array_field specs of the form
+// field=strategy[:arg]
+func parseArrayFields(specs []string) ([]arrayField, error) {
+	fields := make([]arrayField, 0, len(specs))
+	for _, spec := range specs {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("array_field %q should be of the form field=strategy", spec)
+		}
+		af := arrayField{field: kv[0]}
+		strategy := strings.SplitN(kv[1], ":", 2)
+		af.strategy = strategy[0]
+		var arg string
+		if len(strategy) == 2 {
+			arg = strategy[1]
+		}
+		switch af.strategy {
+		case arraySummary:
+			if arg != "" {
+				af.sumKeys = strings.Split(arg, ",")
+			}
+		case arrayExplode:
+			if arg != "" {
+				return nil, fmt.Errorf("array_field %q: explode takes no argument", spec)
+			}
+		case arrayFirst:
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("array_field %q: first needs a number of entries, eg first:3", spec)
+			}
+			af.first = n
+		default:
+			return nil, fmt.Errorf("array_field %q: unknown strategy %q; use summary, explode or first", spec, af.strategy)
+		}
+		fields = append(fields, af)
+	}
+	return fields, nil
+}
+
+// expandArrays applies the array field strategies to each event read from
+// toBeSent
+func expandArrays(toBeSent chan event.Event, fields []arrayField) chan event.Event {
+	expanded := make(chan event.Event, cap(toBeSent))
+	go func() {
+		defer close(expanded)
+		for ev := range toBeSent {
+			evs := []event.Event{ev}
+			for _, af := range fields {
+				var next []event.Event
+				for _, e := range evs {
+					next = append(next, af.apply(e)...)
+				}
+				evs = next
+			}
+			if ev.Done != nil && len(evs) > 1 {
+				splitDone(ev.Done, evs)
+			}
+			for _, e := range evs {
+				expanded <- e
+			}
+		}
+	}()
+	return expanded
+}
+
+// splitDone gives each of the events exploded from one their own Done, so
+// that the original's is called once all of them are done
+func splitDone(done func(), evs []event.Event) {
+	remaining := int32(len(evs))
+	for i := range evs {
+		var once sync.Once
+		evs[i].Done = func() {
+			once.Do(func() {
+				if atomic.AddInt32(&remaining, -1) == 0 {
+					done()
+				}
+			})
+		}
+	}
+}
+
+// apply handles the field in ev, returning the resulting events
+func (af arrayField) apply(ev event.Event) []event.Event {
+	list, ok := ev.Data[af.field].([]interface{})
+	if !ok {
+		return []event.Event{ev}
+	}
+	delete(ev.Data, af.field)
+	prefix := af.field + "."
+	switch af.strategy {
+	case arraySummary:
+		ev.Data[prefix+"count"] = len(list)
+		for _, key := range af.sumKeys {
+			var sum float64
+			for _, entry := range list {
+				if obj, ok := entry.(map[string]interface{}); ok {
+					if n, ok := toFloat(obj[key]); ok {
+						sum += n
+					}
+				}
+			}
+			ev.Data[prefix+key+"_sum"] = sum
+		}
+	case arrayFirst:
+		ev.Data[prefix+"count"] = len(list)
+		for i, entry := range list {
+			if i >= af.first {
+				break
+			}
+			addEntry(ev.Data, prefix+strconv.Itoa(i), entry)
+		}
+	case arrayExplode:
+		if len(list) == 0 {
+			return []event.Event{ev}
+		}
+		evs := make([]event.Event, 0, len(list))
+		for i, entry := range list {
+			child := ev
+			child.Data = make(map[string]interface{}, len(ev.Data)+2)
+			for k, v := range ev.Data {
+				child.Data[k] = v
+			}
+			child.Data[prefix+"index"] = i
+			child.Data[prefix+"count"] = len(list)
+			addEntry(child.Data, af.field, entry)
+			evs = append(evs, child)
+		}
+		return evs
+	}
+	return []event.Event{ev}
+}
+
+// addEntry adds the keys of an array entry to data, under name. Nested
+// values are encoded as JSON; entries that aren't objects are added as is.
+func addEntry(data map[string]interface{}, name string, entry interface{}) {
+	obj, ok := entry.(map[string]interface{})
+	if !ok {
+		data[name] = entry
+		return
+	}
+	for k, v := range obj {
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			encoded, _ := json.Marshal(v)
+			v = string(encoded)
+		}
+		data[name+"."+k] = v
+	}
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,37 @@
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomicfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	for _, contents := range []string{`{"first":1}`, `{}`} {
+		if err := Write(path, []byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != contents {
+			t.Errorf("expected %q, got %q", contents, got)
+		}
+	}
+	// the temporary files are gone
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("expected only the written file to be left, got %d files", len(files))
+	}
+
+	if err := Write(filepath.Join(dir, "missing", "state.json"), nil); err == nil {
+		t.Error("expected an error writing to a missing directory")
+	}
+}
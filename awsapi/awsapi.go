@@ -0,0 +1,330 @@
+// Package awsapi calls the JSON APIs of AWS services, such as Kinesis, SQS
+// and DynamoDB, and signs requests to the others, without the AWS SDK.
+package awsapi
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Credentials sign requests to AWS
+type Credentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// LoadCredentials finds AWS credentials in the environment, or else in the
+// shared credentials file under profile
+func LoadCredentials(profile string) (Credentials, error) {
+	if key := os.Getenv("AWS_ACCESS_KEY_ID"); key != "" {
+		return Credentials{
+			AccessKey:    key,
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		path = filepath.Join(os.Getenv("HOME"), ".aws", "credentials")
+	}
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+	creds, err := readCredentialsFile(path, profile)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("no AWS credentials in the environment, and %v", err)
+	}
+	return creds, nil
+}
+
+// readCredentialsFile reads the keys for profile from an AWS shared
+// credentials file
+func readCredentialsFile(path string, profile string) (Credentials, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return Credentials{}, err
+	}
+	defer fh.Close()
+	var creds Credentials
+	found := false
+	section := ""
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		found = true
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case "aws_access_key_id":
+			creds.AccessKey = value
+		case "aws_secret_access_key":
+			creds.SecretKey = value
+		case "aws_session_token":
+			creds.SessionToken = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Credentials{}, err
+	}
+	if !found || creds.AccessKey == "" {
+		return Credentials{}, fmt.Errorf("no profile %q in %s", profile, path)
+	}
+	return creds, nil
+}
+
+// Region returns region, or if it's empty, the region set in the
+// environment
+func Region(region string) string {
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	return region
+}
+
+// Client calls the APIs of an AWS service
+type Client struct {
+	service  string
+	region   string
+	endpoint string
+	// target prefixes the API's operation names in X-Amz-Target
+	target      string
+	contentType string
+	creds       Credentials
+	http        *http.Client
+	// download is used for responses that may take a while to read
+	download *http.Client
+	now      func() time.Time
+}
+
+func NewClient(service, region, endpoint, target, contentType string, creds Credentials) *Client {
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com", service, region)
+	}
+	return &Client{
+		service:     service,
+		region:      region,
+		endpoint:    strings.TrimRight(endpoint, "/"),
+		target:      target,
+		contentType: contentType,
+		creds:       creds,
+		http:        &http.Client{Timeout: 30 * time.Second},
+		download: &http.Client{Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: 30 * time.Second,
+		}},
+		now: time.Now,
+	}
+}
+
+// APIError is an error returned by an AWS API
+type APIError struct {
+	Code    string
+	Message string
+	Status  int
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %s (status %d)", e.Code, e.Message, e.Status)
+}
+
+// IsAPIError returns true if err is an AWS error with the given code
+func IsAPIError(err error, code string) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.Code == code
+}
+
+// Call runs operation with in as the request, decoding the response into
+// out
+func (c *Client) Call(operation string, in interface{}, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", c.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", c.contentType)
+	req.Header.Set("X-Amz-Target", c.target+"."+operation)
+	Sign(req, body, c.creds, c.region, c.service, c.now())
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(respBody, &e)
+		if e.Message == "" {
+			// some errors capitalize it
+			var m struct{ Message string }
+			json.Unmarshal(respBody, &m)
+			e.Message = m.Message
+		}
+		// the type may be qualified, as in com.amazonaws.kinesis#Code
+		code := e.Type[strings.LastIndex(e.Type, "#")+1:]
+		if code == "" {
+			code = http.StatusText(resp.StatusCode)
+		}
+		return &APIError{Code: code, Message: e.Message, Status: resp.StatusCode}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return errors.New("failed to decode " + operation + " response: " + err.Error())
+	}
+	return nil
+}
+
+// Get fetches path, which must be escaped, from a REST API such as S3's.
+// The caller must close the response body. Responses other than 200 OK are
+// returned as an APIError.
+func (c *Client) Get(path string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", c.endpoint+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	// S3 needs the payload hash sent as well as signed
+	req.Header.Set("X-Amz-Content-Sha256", emptyHash)
+	Sign(req, nil, c.creds, c.region, c.service, c.now())
+	resp, err := c.download.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var e struct {
+			Code    string
+			Message string
+		}
+		xml.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&e)
+		if e.Code == "" {
+			e.Code = http.StatusText(resp.StatusCode)
+		}
+		return nil, &APIError{Code: e.Code, Message: e.Message, Status: resp.StatusCode}
+	}
+	return resp, nil
+}
+
+// EscapePath escapes each segment of path as AWS expects in signed URLs:
+// everything but letters, digits and -._~ is percent encoded
+func EscapePath(path string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(path); i++ {
+		b := path[i]
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '.', b == '_', b == '~', b == '/':
+			buf.WriteByte(b)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", b)
+		}
+	}
+	return buf.String()
+}
+
+// emptyHash is the SHA-256 of an empty payload
+var emptyHash = hex.EncodeToString(sha256.New().Sum(nil))
+
+// Sign adds AWS Signature Version 4 headers to req, whose body is body.
+// The host and any headers already set are signed.
+func Sign(req *http.Request, body []byte, creds Credentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders bytes.Buffer
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		strings.Replace(req.URL.Query().Encode(), "+", "%20", -1),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
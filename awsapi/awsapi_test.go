@@ -0,0 +1,63 @@
+package awsapi
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	// the get-vanilla case from AWS's Signature Version 4 test suite
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	creds := Credentials{
+		AccessKey: "AKIDEXAMPLE",
+		SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	Sign(req, nil, creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Errorf("got Authorization %q, expected %q", got, expected)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("got X-Amz-Date %q", got)
+	}
+}
+
+func TestReadCredentialsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kinesis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "credentials")
+	ioutil.WriteFile(path, []byte(`# comment
+[default]
+aws_access_key_id = AKIDDEFAULT
+aws_secret_access_key = defaultsecret
+
+[other]
+aws_access_key_id=AKIDOTHER
+aws_secret_access_key=othersecret
+aws_session_token=token
+`), 0644)
+
+	creds, err := readCredentialsFile(path, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Credentials{AccessKey: "AKIDOTHER", SecretKey: "othersecret", SessionToken: "token"}
+	if creds != expected {
+		t.Errorf("got %+v, expected %+v", creds, expected)
+	}
+	if creds, err = readCredentialsFile(path, "default"); err != nil || creds.AccessKey != "AKIDDEFAULT" {
+		t.Errorf("got %+v, %v for the default profile", creds, err)
+	}
+	if _, err = readCredentialsFile(path, "missing"); err == nil {
+		t.Error("expected an error for a missing profile")
+	}
+}
@@ -0,0 +1,52 @@
+package awsapi
+
+import (
+	"encoding/xml"
+	"io"
+	"net/url"
+	"time"
+)
+
+// Object is an object in an S3 listing
+type Object struct {
+	Key          string
+	LastModified time.Time
+	Size         int64
+}
+
+// ListObjects lists up to a thousand of the objects in bucket whose keys
+// start with prefix, in key order, carrying on from a previous listing if
+// token is set. It returns the token for the next page, or "" if there are
+// no more. The client must be for S3, and uses path-style URLs.
+func (c *Client) ListObjects(bucket, prefix, token string) ([]Object, string, error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+	resp, err := c.Get("/" + EscapePath(bucket) + "?" + query.Encode())
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Contents              []Object
+		IsTruncated           bool
+		NextContinuationToken string
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, "", err
+	}
+	if !out.IsTruncated {
+		out.NextContinuationToken = ""
+	}
+	return out.Contents, out.NextContinuationToken, nil
+}
+
+// GetObject fetches an object from S3. The caller must close it.
+func (c *Client) GetObject(bucket, key string) (io.ReadCloser, error) {
+	resp, err := c.Get("/" + EscapePath(bucket+"/"+key))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
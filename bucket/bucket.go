@@ -0,0 +1,307 @@
+// Package bucket reads the objects under prefixes of a cloud storage
+// bucket once, for backfilling the log files that services deliver to S3
+// or Google Cloud Storage. The s3backfill and gcs packages provide the
+// stores, and this package the reading.
+//
+// Objects are read in key order, decompressed if they're gzipped or
+// bzipped, and their lines sent on a channel that's closed once they've all
+// been read. The key of each object read in full is appended to a
+// statefile, and objects listed there are skipped, so running the backfill
+// again after it's been stopped carries on where it left off rather than
+// sending lines twice.
+package bucket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const dateFormat = "2006-01-02"
+
+// Object is an object in a listing
+type Object struct {
+	Key      string
+	Modified time.Time
+}
+
+// Store lists and fetches objects
+type Store interface {
+	// List returns a page of the objects in bucket whose keys start with
+	// prefix, in key order, carrying on from a previous page if token is
+	// set. It returns the token for the next page, or "" if there are no
+	// more.
+	List(bucket, prefix, token string) ([]Object, string, error)
+	// Get returns the contents of an object, which the caller must close
+	Get(bucket, key string) (io.ReadCloser, error)
+}
+
+// Location is a bucket and prefix whose objects to read
+type Location struct {
+	Bucket string
+	Prefix string
+}
+
+// ParseLocation parses a location in the form scheme://bucket/prefix
+func ParseLocation(location, scheme string) (Location, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != scheme || u.Host == "" {
+		return Location{}, fmt.Errorf("location %q must be in the form %s://bucket/prefix", location, scheme)
+	}
+	return Location{Bucket: u.Host, Prefix: strings.TrimPrefix(u.Path, "/")}, nil
+}
+
+// ParseDate parses a date in the form YYYY-MM-DD. An empty date is zero.
+func ParseDate(date string) (time.Time, error) {
+	if date == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(dateFormat, date)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("dates must be in the form YYYY-MM-DD, not %q", date)
+	}
+	return t, nil
+}
+
+type Config struct {
+	Store     Store
+	Locations []Location
+	// StateFile lists the objects already read
+	StateFile string
+	// Start and End, if set, bound the dates of the objects read, inclusive
+	Start time.Time
+	End   time.Time
+}
+
+// backfill reads the objects in its locations
+type backfill struct {
+	conf  Config
+	state *state
+	lines chan string
+	abort <-chan struct{}
+}
+
+// GetEntries starts reading the objects in conf.Locations and returns a
+// channel on which their lines are sent. The channel is closed once they've
+// all been read, or abort is closed.
+func GetEntries(conf Config, abort <-chan struct{}) (chan string, error) {
+	st, err := openState(conf.StateFile)
+	if err != nil {
+		return nil, err
+	}
+	b := &backfill{
+		conf:  conf,
+		state: st,
+		lines: make(chan string),
+		abort: abort,
+	}
+	go b.run()
+	return b.lines, nil
+}
+
+// run reads the objects in each location in turn
+func (b *backfill) run() {
+	defer close(b.lines)
+	defer b.state.close()
+	read := 0
+	for _, loc := range b.conf.Locations {
+		n, ok := b.readLocation(loc)
+		read += n
+		if !ok {
+			return
+		}
+	}
+	logrus.WithField("objects", read).Info("Finished bucket backfill")
+}
+
+// readLocation reads the objects in loc not read before, returning how
+// many it read and false if abort was closed first
+func (b *backfill) readLocation(loc Location) (int, bool) {
+	logger := logrus.WithFields(logrus.Fields{"bucket": loc.Bucket, "prefix": loc.Prefix})
+	read := 0
+	token := ""
+	for {
+		objects, next, err := b.conf.Store.List(loc.Bucket, loc.Prefix, token)
+		if err != nil {
+			logger.WithField("err", err).Error("Failed to list objects, skipping the rest of the prefix")
+			return read, true
+		}
+		for _, obj := range objects {
+			// skip the empty objects consoles make for folders
+			if strings.HasSuffix(obj.Key, "/") || !b.inRange(obj) || b.state.has(loc.Bucket, obj.Key) {
+				continue
+			}
+			ok, err := b.readObject(loc.Bucket, obj.Key)
+			if !ok {
+				return read, false
+			}
+			if err != nil {
+				// it'll be tried again next time
+				logger.WithFields(logrus.Fields{
+					"key": obj.Key,
+					"err": err,
+				}).Warn("Failed to read object")
+				continue
+			}
+			read++
+			if err := b.state.add(loc.Bucket, obj.Key); err != nil {
+				logger.WithFields(logrus.Fields{
+					"key": obj.Key,
+					"err": err,
+				}).Warn("Failed to record object as read in the statefile")
+			}
+		}
+		if next == "" {
+			return read, true
+		}
+		token = next
+	}
+}
+
+// readObject sends the lines of an object. It returns false if abort was
+// closed first.
+func (b *backfill) readObject(bucket, key string) (bool, error) {
+	body, err := b.conf.Store.Get(bucket, key)
+	if err != nil {
+		return true, err
+	}
+	defer body.Close()
+	r, err := Decompress(body)
+	if err != nil {
+		return true, err
+	}
+	return SendLines(r, b.lines, b.abort)
+}
+
+// Decompress returns a reader of r's contents, decompressed if they're
+// gzipped or bzipped
+func Decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(3)
+	switch {
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(head, []byte("BZh")):
+		return bzip2.NewReader(br), nil
+	}
+	return br, nil
+}
+
+// SendLines sends the lines read from an object on lines, reading them
+// whole however long they are, as some services deliver a file as a single
+// JSON document. It returns false if abort was closed first.
+func SendLines(r io.Reader, lines chan<- string, abort <-chan struct{}) (bool, error) {
+	rd := bufio.NewReader(r)
+	for {
+		line, err := rd.ReadString('\n')
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			select {
+			case lines <- line:
+			case <-abort:
+				return false, nil
+			}
+		}
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return true, err
+		}
+	}
+}
+
+// keyDate finds dates in keys such as .../2017/01/02/... or ...2017-01-02...
+var keyDate = regexp.MustCompile(`(\d{4})[/-](\d{2})[/-](\d{2})`)
+
+// objectDate returns the first valid date in obj's key, or else the day it
+// was last modified
+func objectDate(obj Object) time.Time {
+	for _, m := range keyDate.FindAllStringSubmatch(obj.Key, -1) {
+		if t, err := time.Parse(dateFormat, m[1]+"-"+m[2]+"-"+m[3]); err == nil {
+			return t
+		}
+	}
+	y, m, d := obj.Modified.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// inRange returns true if obj's date is between the start and end dates
+func (b *backfill) inRange(obj Object) bool {
+	date := objectDate(obj)
+	if !b.conf.Start.IsZero() && date.Before(b.conf.Start) {
+		return false
+	}
+	if !b.conf.End.IsZero() && date.After(b.conf.End) {
+		return false
+	}
+	return true
+}
+
+// state records the objects read in full in a file, one JSON encoded
+// bucket/key per line, appending as each is read
+type state struct {
+	lock sync.Mutex
+	file *os.File
+	read map[string]bool
+}
+
+func openState(path string) (*state, error) {
+	fh, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &state{file: fh, read: make(map[string]bool)}
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var name string
+		// a line cut short by a crash isn't an object read
+		if err := json.Unmarshal(scanner.Bytes(), &name); err == nil {
+			s.read[name] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fh.Close()
+		return nil, err
+	}
+	// end a line cut short, so the next is written on its own
+	if info, err := fh.Stat(); err == nil && info.Size() != 0 {
+		last := make([]byte, 1)
+		if _, err := fh.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			fh.Write([]byte{'\n'})
+		}
+	}
+	return s, nil
+}
+
+func (s *state) has(bucket, key string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.read[bucket+"/"+key]
+}
+
+func (s *state) add(bucket, key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	name := bucket + "/" + key
+	s.read[name] = true
+	line, _ := json.Marshal(name)
+	_, err := s.file.Write(append(line, '\n'))
+	return err
+}
+
+func (s *state) close() {
+	s.file.Close()
+}
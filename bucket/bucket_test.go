@@ -0,0 +1,26 @@
+package bucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestObjectDate(t *testing.T) {
+	modified := time.Date(2017, 3, 4, 23, 59, 0, 0, time.UTC)
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{"AWSLogs/123456789012/elasticloadbalancing/us-east-1/2017/01/02/lb.log.gz", "2017-01-02"},
+		{"cloudfront/E2ABCDEF.2017-01-31-05.a1b2c3.gz", "2017-01-31"},
+		// not a date, so the next one is used
+		{"1234/56/78/logs/2016-12-25.log", "2016-12-25"},
+		{"no-date-here.log", "2017-03-04"},
+	}
+	for _, tt := range tests {
+		got := objectDate(Object{Key: tt.key, Modified: modified}).Format(dateFormat)
+		if got != tt.expected {
+			t.Errorf("objectDate(%q) = %s, expected %s", tt.key, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+
+	flag "github.com/jessevdk/go-flags"
+)
+
+// secretOptions are replaced by a hash in configs sent over the control
+// socket or printed, so changes to them show without revealing them
+var secretOptions = map[string]bool{"WriteKey": true, "Pass": true, "HTTPToken": true}
+
+// outputOptions are the options in Application Options and Required Options
+// that change where and how events are sent, rather than what's read or how
+// events are changed
+var outputOptions = map[string]bool{
+	"APIHost":          true,
+	"WriteKey":         true,
+	"Dataset":          true,
+	"NumSenders":       true,
+	"BatchFrequencyMs": true,
+	"BatchSize":        true,
+	"MaxBandwidth":     true,
+	"BackOff":          true,
+}
+
+// configKey identifies an option in an INI config
+type configKey struct {
+	section string
+	name    string
+}
+
+// area returns whether the option configures an input, the rules applied to
+// events, or the output
+func (k configKey) area() string {
+	switch {
+	case outputOptions[k.name]:
+		return "outputs"
+	case k.section == "Application Options":
+		return "rules"
+	}
+	return "inputs"
+}
+
+func (k configKey) String() string {
+	if k.section == "Application Options" || k.section == "Required Options" {
+		return k.name
+	}
+	return k.section + "." + k.name
+}
+
+// configINI writes out the options set in fp, including defaults, as INI
+func configINI(fp *flag.Parser) string {
+	var buf bytes.Buffer
+	flag.NewIniParser(fp).Write(&buf, flag.IniIncludeDefaults)
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if parts := strings.SplitN(line, " = ", 2); len(parts) == 2 && secretOptions[parts[0]] {
+			sum := sha256.Sum256([]byte(parts[1]))
+			line = fmt.Sprintf("%s = sha256:%x", parts[0], sum[:6])
+		}
+		out.WriteString(line + "\n")
+	}
+	return out.String()
+}
+
+// loadConfigINI reads the config file at path the way honeytail would on
+// startup, returning it as written by configINI
+func loadConfigINI(path string) (string, error) {
+	var options GlobalOptions
+	fp := flag.NewParser(&options, flag.None)
+	// parsing no arguments sets the defaults
+	if _, err := fp.ParseArgs(nil); err != nil {
+		return "", err
+	}
+	ini := flag.NewIniParser(fp)
+	ini.ParseAsDefaults = true
+	if err := ini.ParseFile(path); err != nil {
+		return "", err
+	}
+	return configINI(fp), nil
+}
+
+// parseConfigINI reads the values of each option in an INI config. Options
+// that may be given several times have a value per line.
+func parseConfigINI(ini string) map[configKey][]string {
+	values := make(map[configKey][]string)
+	var section string
+	scanner := bufio.NewScanner(strings.NewReader(ini))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "", strings.HasPrefix(line, ";"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = line[1 : len(line)-1]
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		k := configKey{section, strings.TrimSpace(parts[0])}
+		values[k] = append(values[k], strings.TrimSpace(parts[1]))
+	}
+	return values
+}
+
+// diffConfig reports the options that differ between the running and
+// proposed configs, grouped by whether they affect inputs, rules or outputs.
+// Added values are marked +, removed values - and changed values ~.
+func diffConfig(running, proposed string) string {
+	before, after := parseConfigINI(running), parseConfigINI(proposed)
+	keys := make(map[configKey]bool)
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+	changes := make(map[string][]string)
+	for k := range keys {
+		b, a := before[k], after[k]
+		if len(b) == 1 && len(a) == 1 {
+			if b[0] != a[0] {
+				changes[k.area()] = append(changes[k.area()], fmt.Sprintf("~ %s: %s -> %s", k, b[0], a[0]))
+			}
+			continue
+		}
+		// options given several times are compared value by value
+		for _, v := range subtract(b, a) {
+			changes[k.area()] = append(changes[k.area()], fmt.Sprintf("- %s = %s", k, v))
+		}
+		for _, v := range subtract(a, b) {
+			changes[k.area()] = append(changes[k.area()], fmt.Sprintf("+ %s = %s", k, v))
+		}
+	}
+	if len(changes) == 0 {
+		return "no changes\n"
+	}
+	var out bytes.Buffer
+	for _, area := range []string{"inputs", "rules", "outputs"} {
+		if len(changes[area]) == 0 {
+			continue
+		}
+		sort.Slice(changes[area], func(i, j int) bool {
+			return changes[area][i][2:] < changes[area][j][2:]
+		})
+		fmt.Fprintf(&out, "%s:\n", area)
+		for _, c := range changes[area] {
+			fmt.Fprintf(&out, "  %s\n", c)
+		}
+	}
+	return out.String()
+}
+
+// subtract returns the values in a that aren't in b, counting repeats
+func subtract(a, b []string) []string {
+	remaining := make(map[string]int)
+	for _, v := range b {
+		remaining[v]++
+	}
+	var diff []string
+	for _, v := range a {
+		if remaining[v] > 0 {
+			remaining[v]--
+			continue
+		}
+		diff = append(diff, v)
+	}
+	return diff
+}
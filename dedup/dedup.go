@@ -0,0 +1,180 @@
+// Package dedup keeps honeytail from sending the same lines twice when it
+// reads them again after a restart, such as after a crash, before the tail
+// statefile caught up, or when --backfill runs over a file that was being
+// tailed.
+//
+// A hash of each line read is remembered for a short window and saved to a
+// file. For the same window after starting again, lines whose hashes were
+// saved are skipped, each as many times as it was read before. Lines are
+// compared by their contents alone, so identical lines, such as health
+// checks, may be skipped in place of the ones actually read before.
+package dedup
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/atomicfile"
+)
+
+// entry is a line that was read
+type entry struct {
+	Time time.Time
+	Hash uint64
+}
+
+// saved is what's stored in the file
+type saved struct {
+	Lines []entry
+}
+
+// Window remembers the lines read recently, and skips the lines remembered
+// from before a restart
+type Window struct {
+	path   string
+	window time.Duration
+	// until is when to stop checking for lines read before the restart
+	until time.Time
+	now   func() time.Time
+
+	lock sync.Mutex
+	// previous counts the lines read before the restart, by hash
+	previous map[uint64]int
+	// recent are the lines read since starting, oldest first
+	recent []entry
+}
+
+// New loads the lines remembered in the file at path, if there is one, and
+// returns a Window remembering lines read for window
+func New(path string, window time.Duration) (*Window, error) {
+	return newWindow(path, window, time.Now)
+}
+
+func newWindow(path string, window time.Duration, now func() time.Time) (*Window, error) {
+	w := &Window{
+		path:     path,
+		window:   window,
+		until:    now().Add(window),
+		now:      now,
+		previous: make(map[uint64]int),
+	}
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return w, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s saved
+	if err := json.Unmarshal(contents, &s); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"file":  path,
+			"error": err,
+		}).Warn("Failed to read the lines remembered from before restarting; they may be sent twice")
+		return w, nil
+	}
+	for _, e := range s.Lines {
+		w.previous[e.Hash]++
+	}
+	return w, nil
+}
+
+// Filter passes along the lines read from lines, skipping those read before
+// the restart
+func (w *Window) Filter(lines chan string) chan string {
+	filtered := make(chan string)
+	go func() {
+		defer close(filtered)
+		for line := range lines {
+			h := hash(line)
+			if w.duplicate(h) {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("skipping line; read before restarting")
+				continue
+			}
+			filtered <- line
+			w.record(h)
+		}
+	}()
+	return filtered
+}
+
+// duplicate returns true if a line with hash h was read before the restart
+// and hasn't been skipped yet
+func (w *Window) duplicate(h uint64) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if len(w.previous) == 0 {
+		return false
+	}
+	if w.now().After(w.until) {
+		w.previous = map[uint64]int{}
+		return false
+	}
+	n := w.previous[h]
+	switch {
+	case n == 0:
+		return false
+	case n == 1:
+		delete(w.previous, h)
+	default:
+		w.previous[h] = n - 1
+	}
+	return true
+}
+
+// record remembers a line with hash h was read
+func (w *Window) record(h uint64) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.recent = append(w.recent, entry{Time: w.now(), Hash: h})
+}
+
+// Save writes the lines read in the last window to the file, replacing it
+func (w *Window) Save() error {
+	w.lock.Lock()
+	cutoff := w.now().Add(-w.window)
+	i := 0
+	for i < len(w.recent) && w.recent[i].Time.Before(cutoff) {
+		i++
+	}
+	w.recent = w.recent[i:]
+	contents, err := json.Marshal(saved{Lines: w.recent})
+	w.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return atomicfile.Write(w.path, contents)
+}
+
+// SaveEvery saves the lines read every interval until abort is closed
+func (w *Window) SaveEvery(interval time.Duration, abort <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.Save(); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"file":  w.path,
+					"error": err,
+				}).Warn("Failed to save recently read lines")
+			}
+		case <-abort:
+			return
+		}
+	}
+}
+
+func hash(line string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(line))
+	return h.Sum64()
+}
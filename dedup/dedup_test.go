@@ -0,0 +1,97 @@
+package dedup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (f *fakeClock) now() time.Time {
+	return f.t
+}
+
+func filter(w *Window, lines []string) []string {
+	in := make(chan string)
+	go func() {
+		for _, line := range lines {
+			in <- line
+		}
+		close(in)
+	}()
+	var out []string
+	for line := range w.Filter(in) {
+		out = append(out, line)
+	}
+	return out
+}
+
+func TestWindow(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	path := filepath.Join(tmpdir, "honeytail.dedup")
+	clock := &fakeClock{t: time.Date(2010, 6, 21, 15, 4, 5, 0, time.UTC)}
+
+	w, err := newWindow(path, 10*time.Second, clock.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nothing was saved, so nothing is skipped
+	got := filter(w, []string{"old", "a", "b", "a"})
+	if !reflect.DeepEqual(got, []string{"old", "a", "b", "a"}) {
+		t.Errorf("got %v, expected every line", got)
+	}
+	// lines read longer ago than the window aren't saved
+	w.recent[0].Time = clock.t.Add(-time.Minute)
+	if err := w.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// after restarting, the remembered lines are skipped as many times as
+	// they were read
+	w, err = newWindow(path, 10*time.Second, clock.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = filter(w, []string{"old", "a", "a", "a", "b", "c", "b"})
+	if !reflect.DeepEqual(got, []string{"old", "a", "c", "b"}) {
+		t.Errorf("got %v, expected the lines read before restarting to be skipped", got)
+	}
+
+	// once the window has passed, nothing is skipped
+	w, err = newWindow(path, 10*time.Second, clock.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.t = clock.t.Add(11 * time.Second)
+	got = filter(w, []string{"a", "b"})
+	if !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("got %v, expected nothing to be skipped after the window", got)
+	}
+}
+
+func TestCorruptFile(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	path := filepath.Join(tmpdir, "honeytail.dedup")
+	ioutil.WriteFile(path, []byte("not json"), 0600)
+	w, err := New(path, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := filter(w, []string{"a"}); len(got) != 1 {
+		t.Errorf("got %v, expected the line to be passed along", got)
+	}
+}
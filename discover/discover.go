@@ -0,0 +1,150 @@
+// Package discover finds the logs a web server writes, and the format they
+// are written in, by reading its config, so honeytail can be pointed at the
+// server rather than at each of its files.
+package discover
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultNginxConfig is where nginx's config usually lives
+const DefaultNginxConfig = "/etc/nginx/nginx.conf"
+
+// nginxDefaultFormat is the format of access logs that don't name one. It is
+// built in to nginx, so isn't defined in any config file.
+const nginxDefaultFormat = "combined"
+
+// AccessLog is an access log found in a web server's config
+type AccessLog struct {
+	// Path is the file the log is written to
+	Path string
+	// Format is the name of the log format it is written in
+	Format string
+	// FormatFile is the config file defining the format. It is empty for
+	// formats built in to the server.
+	FormatFile string
+}
+
+// directive is a single statement in an nginx config file
+type directive struct {
+	name string
+	args []string
+}
+
+// Nginx returns the access logs written to files by the nginx whose main
+// config file is at path, following include directives. Logs sent to syslog,
+// and logs whose path is built from variables, are skipped, as they can't be
+// tailed. Each path is only returned once, with the format it's first logged
+// with.
+func Nginx(path string) ([]AccessLog, error) {
+	formats := make(map[string]string)
+	var logs []AccessLog
+	seen := make(map[string]bool)
+	// nginx resolves relative includes against the main config's directory
+	root := filepath.Dir(path)
+	var read func(file string, depth int) error
+	read = func(file string, depth int) error {
+		if depth > 16 {
+			return fmt.Errorf("includes nested too deeply at %s", file)
+		}
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		for _, d := range parseNginx(string(contents)) {
+			switch {
+			case d.name == "include" && len(d.args) > 0:
+				pattern := d.args[0]
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(root, pattern)
+				}
+				matches, err := filepath.Glob(pattern)
+				if err != nil {
+					return err
+				}
+				for _, m := range matches {
+					if err := read(m, depth+1); err != nil {
+						return err
+					}
+				}
+			case d.name == "log_format" && len(d.args) > 0:
+				if _, ok := formats[d.args[0]]; !ok {
+					formats[d.args[0]] = file
+				}
+			case d.name == "access_log" && len(d.args) > 0:
+				p := d.args[0]
+				if p == "off" || strings.HasPrefix(p, "syslog:") || strings.Contains(p, "$") || seen[p] {
+					continue
+				}
+				seen[p] = true
+				format := nginxDefaultFormat
+				if len(d.args) > 1 && !strings.Contains(d.args[1], "=") {
+					format = d.args[1]
+				}
+				logs = append(logs, AccessLog{Path: p, Format: format})
+			}
+		}
+		return nil
+	}
+	if err := read(path, 0); err != nil {
+		return nil, err
+	}
+	// formats may be defined after the logs using them, or in another file
+	for i, l := range logs {
+		file, ok := formats[l.Format]
+		if !ok && l.Format != nginxDefaultFormat {
+			return nil, fmt.Errorf("access_log %s uses log_format %s, which isn't defined", l.Path, l.Format)
+		}
+		logs[i].FormatFile = file
+	}
+	return logs, nil
+}
+
+// parseNginx splits an nginx config into its directives, flattening blocks.
+// Comments are dropped and quotes removed from arguments.
+func parseNginx(conf string) []directive {
+	var directives []directive
+	var words []string
+	var word []byte
+	inWord := false
+	end := func() {
+		if inWord {
+			words = append(words, string(word))
+			word = word[:0]
+			inWord = false
+		}
+	}
+	for i := 0; i < len(conf); i++ {
+		c := conf[i]
+		switch {
+		case c == '#':
+			end()
+			for i < len(conf) && conf[i] != '\n' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			inWord = true
+			for i++; i < len(conf) && conf[i] != c; i++ {
+				if conf[i] == '\\' && i+1 < len(conf) {
+					i++
+				}
+				word = append(word, conf[i])
+			}
+		case c == ';' || c == '{' || c == '}':
+			end()
+			if len(words) > 0 {
+				directives = append(directives, directive{name: words[0], args: words[1:]})
+			}
+			words = nil
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			end()
+		default:
+			inWord = true
+			word = append(word, c)
+		}
+	}
+	return directives
+}
@@ -0,0 +1,75 @@
+package discover
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseNginx(t *testing.T) {
+	directives := parseNginx(`
+http {
+    # access_log /commented/out.log;
+    log_format  main '$remote_addr - "$request" '
+                     '$status';
+    server { access_log "/var/log/nginx/a b.log" main buffer=32k; }
+}`)
+	expected := []directive{
+		{name: "http", args: []string{}},
+		{name: "log_format", args: []string{"main", `$remote_addr - "$request" `, "$status"}},
+		{name: "server", args: []string{}},
+		{name: "access_log", args: []string{"/var/log/nginx/a b.log", "main", "buffer=32k"}},
+	}
+	if !reflect.DeepEqual(directives, expected) {
+		t.Errorf("got %#v, expected %#v", directives, expected)
+	}
+}
+
+func TestNginx(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "conf.d"), 0755)
+	main := filepath.Join(dir, "nginx.conf")
+	site := filepath.Join(dir, "conf.d", "site.conf")
+	ioutil.WriteFile(main, []byte(`
+http {
+    log_format timed '$remote_addr [$time_local] "$request" $status $request_time';
+    access_log /var/log/nginx/access.log;
+    include conf.d/*.conf;
+}`), 0644)
+	ioutil.WriteFile(site, []byte(`
+log_format site '$remote_addr "$request" $status';
+server {
+    access_log /var/log/nginx/site.log site;
+    access_log /var/log/nginx/timed.log timed buffer=16k;
+    access_log /var/log/nginx/$host.log timed;
+    access_log syslog:server=unix:/dev/log;
+    access_log off;
+}`), 0644)
+
+	logs, err := Nginx(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []AccessLog{
+		{Path: "/var/log/nginx/access.log", Format: "combined"},
+		{Path: "/var/log/nginx/site.log", Format: "site", FormatFile: site},
+		{Path: "/var/log/nginx/timed.log", Format: "timed", FormatFile: main},
+	}
+	if !reflect.DeepEqual(logs, expected) {
+		t.Errorf("got %+v, expected %+v", logs, expected)
+	}
+
+	ioutil.WriteFile(site, []byte(`access_log /var/log/nginx/x.log missing;`), 0644)
+	if _, err := Nginx(main); err == nil {
+		t.Error("expected an error for an access log using an undefined format")
+	}
+	if _, err := Nginx(filepath.Join(dir, "nope.conf")); err == nil {
+		t.Error("expected an error for a missing config file")
+	}
+}
@@ -0,0 +1,550 @@
+// Package dockerlogs streams the output of containers from the Docker Engine
+// API, so their logs can be read without finding json-file paths on disk,
+// and whatever logging driver the containers use that keeps logs readable.
+//
+// Containers are chosen by label, and those started later are picked up
+// from the daemon's events. Each container's lines are sent on a channel of
+// their own, alongside fields naming the container, its image and labels,
+// to be added to its events. The time of the last line read from each
+// container is kept in a statefile, so --tail.read_from=last carries on
+// from there when honeytail restarts.
+package dockerlogs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/atomicfile"
+	"github.com/honeycombio/honeytail/httpapi"
+)
+
+type Options struct {
+	Host      string `long:"host" description:"Docker Engine API to connect to, as unix:///path/to/docker.sock or tcp://host:port. Defaults to DOCKER_HOST, or else unix:///var/run/docker.sock"`
+	StateFile string `long:"statefile" description:"File keeping the time of the last line read from each container, for --tail.read_from=last. Defaults to honeytail.docker.state in the system temp directory"`
+}
+
+type Config struct {
+	// Labels choose the containers to read, as key or key=value filters
+	// that must all match. If empty, every container is read.
+	Labels []string
+	// Docker specific options
+	Options Options
+	// ReadFrom is where to start reading the containers running at startup,
+	// as for --tail.read_from: beginning, end or last. Last carries on after
+	// the last line read from a container if it's in the statefile, or
+	// starts at the end. Containers started later are read from the start.
+	ReadFrom string
+	// Stop stops reading once the containers running at startup have had
+	// their output read, rather than following it and watching for more
+	Stop bool
+}
+
+// Container is a container whose output is being read
+type Container struct {
+	// Lines receives each line the container writes, after the stream it
+	// was written to, stdout or stderr, and a space. It's closed when the
+	// container stops.
+	Lines chan string
+	// Fields describe the container, to add to each of its events
+	Fields map[string]interface{}
+}
+
+// StreamPrefixRegex matches the stream at the start of each line, so it's
+// stripped before parsing and added to the event as stream
+const StreamPrefixRegex = `^(?P<stream>\S+) `
+
+var (
+	// saveInterval is how often the statefile is written
+	saveInterval = 5 * time.Second
+	// retryWait is how long to wait after losing the daemon's events
+	retryWait = 10 * time.Second
+)
+
+const defaultHost = "unix:///var/run/docker.sock"
+
+// reader reads the output of the containers matching its labels
+type reader struct {
+	conf       Config
+	endpoint   string
+	http       *http.Client
+	containers chan Container
+	abort      <-chan struct{}
+	ctx        context.Context
+	wg         sync.WaitGroup
+
+	lock sync.Mutex
+	// running are the containers being read, and seen all those read
+	running map[string]bool
+	seen    map[string]bool
+	// lastRead holds when the last line read from each container was
+	// written, and dirty whether it's changed since it was saved
+	lastRead map[string]time.Time
+	dirty    bool
+}
+
+// GetContainers connects to the Docker daemon and returns the channel on
+// which each container matching conf.Labels is sent as its output starts
+// being read. The channel is closed once abort is closed, or once the
+// containers running at startup have been read if conf.Stop is set.
+func GetContainers(conf Config, abort <-chan struct{}) (chan Container, error) {
+	host := conf.Options.Host
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = defaultHost
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse Docker host %q: %v", host, err)
+	}
+	transport := &http.Transport{}
+	endpoint := "http://" + u.Host
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport.Dial = func(_, _ string) (net.Conn, error) {
+			return net.Dial("unix", socket)
+		}
+		endpoint = "http://docker"
+	case "tcp", "http":
+	default:
+		return nil, fmt.Errorf("Docker host %q must be unix:// or tcp://", host)
+	}
+	if conf.Options.StateFile == "" {
+		conf.Options.StateFile = filepath.Join(os.TempDir(), "honeytail.docker.state")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-abort
+		cancel()
+	}()
+	r := &reader{
+		conf:       conf,
+		endpoint:   endpoint,
+		http:       &http.Client{Transport: transport},
+		containers: make(chan Container),
+		abort:      abort,
+		ctx:        ctx,
+		running:    make(map[string]bool),
+		seen:       make(map[string]bool),
+		lastRead:   readState(conf.Options.StateFile),
+	}
+	// check the daemon can be reached now, so a missing socket or lack of
+	// permission to use it is reported at startup
+	resp, err := r.get("/_ping", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	go r.run()
+	return r.containers, nil
+}
+
+// get sends a GET request for path with query to the Docker API. The
+// caller must close the response body.
+func (r *reader) get(path string, query url.Values) (*http.Response, error) {
+	return httpapi.Get(r.ctx, r.http, "Docker", r.endpoint+path+"?"+query.Encode(), nil)
+}
+
+// filters returns the filters query parameter choosing the containers to
+// read, with extra filters added
+func (r *reader) filters(extra map[string][]string) url.Values {
+	filters := map[string][]string{}
+	if len(r.conf.Labels) != 0 {
+		filters["label"] = r.conf.Labels
+	}
+	for k, v := range extra {
+		filters[k] = v
+	}
+	encoded, _ := json.Marshal(filters)
+	return url.Values{"filters": {string(encoded)}}
+}
+
+// run reads the containers running now and, unless conf.Stop is set, those
+// started later, until abort is closed
+func (r *reader) run() {
+	defer close(r.containers)
+	defer r.saveState()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(saveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.saveState()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// watch for containers starting before listing those already running,
+	// so none started in between are missed
+	var started chan startEvent
+	if !r.conf.Stop {
+		started = make(chan startEvent)
+		go r.watch(started, time.Now())
+	}
+	if err := r.startRunning(); err != nil {
+		logrus.WithFields(logrus.Fields{"err": err}).Error(
+			"Failed to list Docker containers")
+	}
+	if r.conf.Stop {
+		r.wg.Wait()
+		return
+	}
+	for {
+		select {
+		case ev := <-started:
+			r.start(ev.id, ev.time)
+		case <-r.abort:
+			r.wg.Wait()
+			return
+		}
+	}
+}
+
+// startRunning starts reading the containers running now
+func (r *reader) startRunning() error {
+	resp, err := r.get("/containers/json", r.filters(nil))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var listed []struct {
+		ID string `json:"Id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, c := range listed {
+		var since time.Time
+		switch r.conf.ReadFrom {
+		case "beginning":
+		case "last":
+			r.lock.Lock()
+			last, ok := r.lastRead[c.ID]
+			r.lock.Unlock()
+			if ok {
+				// lines written at the same time as the last one read
+				// would be read again
+				since = last.Add(time.Nanosecond)
+			} else {
+				since = now
+			}
+		default:
+			since = now
+		}
+		r.start(c.ID, since)
+	}
+	return nil
+}
+
+// startEvent tells of a container starting
+type startEvent struct {
+	id   string
+	time time.Time
+}
+
+// watch sends the containers that start from since on, until abort is
+// closed. If the daemon's events are lost, they're picked up again from
+// the last one seen.
+func (r *reader) watch(started chan<- startEvent, since time.Time) {
+	query := r.filters(map[string][]string{
+		"type":  {"container"},
+		"event": {"start"},
+	})
+	for {
+		query.Set("since", formatTime(since))
+		err := func() error {
+			resp, err := r.get("/events", query)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			dec := json.NewDecoder(resp.Body)
+			for {
+				var ev struct {
+					ID       string `json:"id"`
+					TimeNano int64  `json:"timeNano"`
+					Actor    struct {
+						ID string
+					}
+				}
+				if err := dec.Decode(&ev); err != nil {
+					return err
+				}
+				if ev.ID == "" {
+					ev.ID = ev.Actor.ID
+				}
+				at := time.Unix(0, ev.TimeNano)
+				since = at.Add(time.Nanosecond)
+				select {
+				case started <- startEvent{ev.ID, at}:
+				case <-r.abort:
+					return nil
+				}
+			}
+		}()
+		select {
+		case <-r.abort:
+			return
+		default:
+		}
+		logrus.WithFields(logrus.Fields{"err": err}).Warn(
+			"Lost Docker events, watching for containers starting again")
+		select {
+		case <-time.After(retryWait):
+		case <-r.abort:
+			return
+		}
+	}
+}
+
+// start sends container id, and starts reading its output from since
+func (r *reader) start(id string, since time.Time) {
+	r.lock.Lock()
+	if r.running[id] {
+		r.lock.Unlock()
+		return
+	}
+	r.running[id] = true
+	r.seen[id] = true
+	r.lock.Unlock()
+
+	logger := logrus.WithFields(logrus.Fields{"container_id": id})
+	resp, err := r.get("/containers/"+url.PathEscape(id)+"/json", nil)
+	if err != nil {
+		logger.WithFields(logrus.Fields{"err": err}).Warn("Failed to inspect Docker container")
+		r.stopped(id)
+		return
+	}
+	var inspected struct {
+		ID     string `json:"Id"`
+		Name   string
+		Config struct {
+			Image  string
+			Labels map[string]string
+			Tty    bool
+		}
+	}
+	err = json.NewDecoder(resp.Body).Decode(&inspected)
+	resp.Body.Close()
+	if err != nil {
+		logger.WithFields(logrus.Fields{"err": err}).Warn("Failed to inspect Docker container")
+		r.stopped(id)
+		return
+	}
+	c := Container{
+		Lines: make(chan string),
+		Fields: map[string]interface{}{
+			"container_id":    inspected.ID,
+			"container_name":  strings.TrimPrefix(inspected.Name, "/"),
+			"container_image": inspected.Config.Image,
+		},
+	}
+	for k, v := range inspected.Config.Labels {
+		c.Fields["container_label."+k] = v
+	}
+	select {
+	case r.containers <- c:
+	case <-r.abort:
+		r.stopped(id)
+		return
+	}
+	logger.WithFields(logrus.Fields{"container_name": c.Fields["container_name"]}).Info(
+		"Reading Docker container output")
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer r.stopped(id)
+		defer close(c.Lines)
+		if err := r.read(id, since, inspected.Config.Tty, c.Lines); err != nil {
+			logger.WithFields(logrus.Fields{"err": err}).Warn("Stopped reading Docker container output")
+		}
+	}()
+}
+
+// stopped marks container id as no longer being read
+func (r *reader) stopped(id string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.running, id)
+}
+
+// read sends the lines container id writes from since on, until it stops
+// or abort is closed, or until it's caught up if conf.Stop is set
+func (r *reader) read(id string, since time.Time, tty bool, lines chan<- string) error {
+	query := url.Values{
+		"stdout":     {"1"},
+		"stderr":     {"1"},
+		"timestamps": {"1"},
+	}
+	if !r.conf.Stop {
+		query.Set("follow", "1")
+	}
+	if !since.IsZero() {
+		query.Set("since", formatTime(since))
+	}
+	resp, err := r.get("/containers/"+url.PathEscape(id)+"/logs", query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	err = splitStreams(resp.Body, tty, func(stream, line string) bool {
+		// each line starts with when it was written
+		if i := strings.IndexByte(line, ' '); i > 0 {
+			if at, err := time.Parse(time.RFC3339Nano, line[:i]); err == nil {
+				line = line[i+1:]
+				r.lock.Lock()
+				r.lastRead[id] = at
+				r.dirty = true
+				r.lock.Unlock()
+			}
+		}
+		select {
+		case lines <- stream + " " + line:
+			return true
+		case <-r.abort:
+			return false
+		}
+	})
+	select {
+	case <-r.abort:
+		// the request was cancelled
+		return nil
+	default:
+	}
+	return err
+}
+
+// splitStreams calls send with each line of a container's output, and the
+// stream it was written to, until it returns false. The output of
+// containers without a TTY comes in frames, each with a header giving its
+// stream and length; with a TTY, it's all stdout.
+func splitStreams(body io.Reader, tty bool, send func(stream, line string) bool) error {
+	if tty {
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if !send("stdout", strings.TrimSuffix(scanner.Text(), "\r")) {
+				return nil
+			}
+		}
+		return scanner.Err()
+	}
+	// lines may be split across frames
+	partial := map[byte][]byte{}
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(body, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		frame := make([]byte, binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.ReadFull(body, frame); err != nil {
+			return err
+		}
+		var stream string
+		switch header[0] {
+		case 1:
+			stream = "stdout"
+		case 2:
+			stream = "stderr"
+		default:
+			continue
+		}
+		buf := append(partial[header[0]], frame...)
+		for {
+			i := bytes.IndexByte(buf, '\n')
+			if i < 0 {
+				break
+			}
+			if !send(stream, string(buf[:i])) {
+				return nil
+			}
+			buf = buf[i+1:]
+		}
+		partial[header[0]] = append([]byte(nil), buf...)
+	}
+}
+
+// formatTime formats t as the Docker API takes times, in seconds since the
+// epoch with a fraction
+func formatTime(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10) + "." + fmt.Sprintf("%09d", t.Nanosecond())
+}
+
+// readState returns the times of the last lines read from each container
+func readState(path string) map[string]time.Time {
+	state := make(map[string]time.Time)
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.WithFields(logrus.Fields{
+				"file": path,
+				"err":  err,
+			}).Warn("Failed to read the Docker statefile")
+		}
+		return state
+	}
+	if err := json.Unmarshal(contents, &state); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"file": path,
+			"err":  err,
+		}).Warn("Failed to read the Docker statefile")
+	}
+	return state
+}
+
+// saveState writes the times of the last lines read from each container to
+// the statefile, if they've changed, dropping those of containers that
+// haven't been seen since honeytail started, which have been removed or
+// will be read from when they start again
+func (r *reader) saveState() {
+	r.lock.Lock()
+	if !r.dirty {
+		r.lock.Unlock()
+		return
+	}
+	for id := range r.lastRead {
+		if !r.seen[id] {
+			delete(r.lastRead, id)
+		}
+	}
+	contents, err := json.Marshal(r.lastRead)
+	r.dirty = false
+	r.lock.Unlock()
+	if err == nil {
+		err = atomicfile.Write(r.conf.Options.StateFile, contents)
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"file": r.conf.Options.StateFile,
+			"err":  err,
+		}).Warn("Failed to save the Docker statefile")
+	}
+}
@@ -0,0 +1,203 @@
+package dockerlogs
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// frame returns a frame of the multiplexed output of a container without a
+// TTY
+func frame(stream byte, data string) []byte {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+	return append(header, data...)
+}
+
+// fakeDocker serves containers from a Docker daemon listening on a socket
+// in dir. started, if set, is sent as a start event.
+func fakeDocker(t *testing.T, dir string, started string, written time.Time) *httptest.Server {
+	stamp := func(d time.Duration) string {
+		return written.Add(d).Format(time.RFC3339Nano)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_ping", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "OK")
+	})
+	mux.HandleFunc("/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		var filters map[string][]string
+		json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters)
+		if !reflect.DeepEqual(filters["label"], []string{"logs=honeycomb"}) {
+			t.Errorf("listed containers with filters %v", filters)
+		}
+		if started != "" {
+			fmt.Fprint(w, `[]`)
+			return
+		}
+		fmt.Fprint(w, `[{"Id":"abc","Names":["/web"]}]`)
+	})
+	mux.HandleFunc("/containers/abc/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Id":"abc","Name":"/web","Config":{"Image":"nginx:1.13","Labels":{"logs":"honeycomb","app":"shop"},"Tty":false}}`)
+	})
+	mux.HandleFunc("/containers/abc/logs", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("follow") != "" || r.URL.Query().Get("timestamps") != "1" {
+			t.Errorf("got logs query %v", r.URL.Query())
+		}
+		w.Write(frame(1, stamp(0)+" GET / 200\n"))
+		w.Write(frame(2, stamp(time.Second)+" upstream "))
+		w.Write(frame(1, stamp(2*time.Second)+" GET /cart 200\n"))
+		w.Write(frame(2, "timed out\n"))
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"status":"start","id":%q,"Type":"container","Action":"start","timeNano":1483326245000000000}`+"\n", started)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	mux.HandleFunc("/containers/def/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Id":"def","Name":"/worker","Config":{"Image":"worker","Tty":true}}`)
+	})
+	mux.HandleFunc("/containers/def/logs", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("follow") != "1" || r.URL.Query().Get("since") != "1483326245.000000000" {
+			t.Errorf("got logs query %v", r.URL.Query())
+		}
+		fmt.Fprint(w, "2017-01-02T03:04:05.5Z starting\r\n")
+	})
+	server := httptest.NewUnstartedServer(mux)
+	l, err := net.Listen("unix", filepath.Join(dir, "docker.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Listener = l
+	server.Start()
+	return server
+}
+
+func readAll(t *testing.T, containers chan Container) ([]map[string]interface{}, []string) {
+	var fields []map[string]interface{}
+	var lines []string
+	for {
+		select {
+		case c, ok := <-containers:
+			if !ok {
+				return fields, lines
+			}
+			fields = append(fields, c.Fields)
+			for line := range c.Lines {
+				lines = append(lines, line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out reading containers")
+		}
+	}
+}
+
+func TestGetContainers(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	dir, err := ioutil.TempDir("", "dockerlogs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	written := time.Date(2017, 1, 2, 3, 4, 5, 1, time.UTC)
+	server := fakeDocker(t, dir, "", written)
+	defer server.Close()
+
+	stateFile := filepath.Join(dir, "state")
+	containers, err := GetContainers(Config{
+		Labels: []string{"logs=honeycomb"},
+		Options: Options{
+			Host:      "unix://" + filepath.Join(dir, "docker.sock"),
+			StateFile: stateFile,
+		},
+		ReadFrom: "beginning",
+		Stop:     true,
+	}, make(chan struct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, lines := readAll(t, containers)
+	expectedFields := []map[string]interface{}{{
+		"container_id":         "abc",
+		"container_name":       "web",
+		"container_image":      "nginx:1.13",
+		"container_label.logs": "honeycomb",
+		"container_label.app":  "shop",
+	}}
+	if !reflect.DeepEqual(fields, expectedFields) {
+		t.Errorf("got fields %v, expected %v", fields, expectedFields)
+	}
+	expectedLines := []string{
+		"stdout GET / 200",
+		"stdout GET /cart 200",
+		"stderr upstream timed out",
+	}
+	if !reflect.DeepEqual(lines, expectedLines) {
+		t.Errorf("got lines %q, expected %q", lines, expectedLines)
+	}
+	state := readState(stateFile)
+	// the stderr line was read last, so reading again starts after it,
+	// sending the /cart line again rather than losing it
+	if last := state["abc"]; !last.Equal(written.Add(time.Second)) {
+		t.Errorf("got last read time %v, expected that of the last line read", last)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	dir, err := ioutil.TempDir("", "dockerlogs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := fakeDocker(t, dir, "def", time.Now())
+	defer server.Close()
+
+	abort := make(chan struct{})
+	containers, err := GetContainers(Config{
+		Labels: []string{"logs=honeycomb"},
+		Options: Options{
+			Host:      "unix://" + filepath.Join(dir, "docker.sock"),
+			StateFile: filepath.Join(dir, "state"),
+		},
+		ReadFrom: "end",
+	}, abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case c := <-containers:
+		if c.Fields["container_name"] != "worker" {
+			t.Errorf("got container %v, expected worker", c.Fields)
+		}
+		var lines []string
+		for line := range c.Lines {
+			lines = append(lines, line)
+		}
+		if !reflect.DeepEqual(lines, []string{"stdout starting"}) {
+			t.Errorf("got lines %q from a container with a TTY", lines)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the container started")
+	}
+	close(abort)
+	select {
+	case _, ok := <-containers:
+		if ok {
+			t.Error("got another container")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("containers not closed after aborting")
+	}
+}
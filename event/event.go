@@ -16,4 +16,14 @@ type Event struct {
 	// Data is a map[string]interface{} containing key/value pairs for all the
 	// metrics to submit in this event
 	Data map[string]interface{}
+	// WriteKey and Dataset, if set, override the write key and dataset
+	// honeytail was started with. Used when proxying events from other
+	// clients.
+	WriteKey string
+	Dataset  string
+	// Done, if set, is called once the event has been handed to libhoney,
+	// dropped by sampling or held by maintenance mode, so inputs can
+	// acknowledge what they read only once it's on its way. It may be
+	// called more than once, as when an event is resent.
+	Done func() `json:"-"`
 }
@@ -0,0 +1,107 @@
+package fieldcrypt
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"math/big"
+)
+
+// ff1 is the FF1 format-preserving encryption mode from NIST SP 800-38G. It
+// enciphers a string of numerals in some radix to another string of the same
+// length and radix, so it's a one-to-one mapping for each tweak.
+type ff1 struct {
+	block cipher.Block
+}
+
+// encrypt enciphers x, a string of at least two numerals each less than radix
+func (f ff1) encrypt(tweak []byte, radix int, x []byte) []byte {
+	n := len(x)
+	u := n / 2
+	v := n - u
+	bigRadix := big.NewInt(int64(radix))
+	modU := new(big.Int).Exp(bigRadix, big.NewInt(int64(u)), nil)
+	modV := new(big.Int).Exp(bigRadix, big.NewInt(int64(v)), nil)
+	// b is the number of bytes needed to hold a number of v numerals
+	b := (new(big.Int).Sub(modV, big.NewInt(1)).BitLen() + 7) / 8
+	d := 4*((b+3)/4) + 4
+
+	p := []byte{1, 2, 1, 0, 0, 0, 10, byte(u), 0, 0, 0, 0, 0, 0, 0, 0}
+	p[3], p[4], p[5] = byte(radix>>16), byte(radix>>8), byte(radix)
+	binary.BigEndian.PutUint32(p[8:], uint32(n))
+	binary.BigEndian.PutUint32(p[12:], uint32(len(tweak)))
+
+	pad := ((-len(tweak)-b-1)%16 + 16) % 16
+	q := make([]byte, len(tweak)+pad+1+b)
+	copy(q, tweak)
+
+	numA, numB := num(radix, x[:u]), num(radix, x[u:])
+	r := make([]byte, 16)
+	s := make([]byte, (d+15)/16*16)
+	for i := 0; i < 10; i++ {
+		q[len(q)-b-1] = byte(i)
+		bBytes := numB.Bytes()
+		for k := len(q) - b; k < len(q)-len(bBytes); k++ {
+			q[k] = 0
+		}
+		copy(q[len(q)-len(bBytes):], bBytes)
+		f.prf(r, p, q)
+		copy(s, r)
+		for j := 1; j*16 < d; j++ {
+			block := s[j*16 : (j+1)*16]
+			copy(block, r)
+			for k, jb := 15, j; jb != 0; k, jb = k-1, jb>>8 {
+				block[k] ^= byte(jb)
+			}
+			f.block.Encrypt(block, block)
+		}
+		c := new(big.Int).SetBytes(s[:d])
+		c.Add(c, numA)
+		if i%2 == 0 {
+			c.Mod(c, modU)
+		} else {
+			c.Mod(c, modV)
+		}
+		numA, numB = numB, c
+	}
+	return append(str(radix, u, numA), str(radix, v, numB)...)
+}
+
+// prf is the CBC-MAC of p followed by q, which together are a whole number
+// of blocks, written to out
+func (f ff1) prf(out, p, q []byte) {
+	for i := range out {
+		out[i] = 0
+	}
+	for _, data := range [][]byte{p, q} {
+		for i := 0; i < len(data); i += 16 {
+			for k := 0; k < 16; k++ {
+				out[k] ^= data[i+k]
+			}
+			f.block.Encrypt(out, out)
+		}
+	}
+}
+
+// num returns the number the numerals in x represent, most significant first
+func num(radix int, x []byte) *big.Int {
+	n := new(big.Int)
+	bigRadix := big.NewInt(int64(radix))
+	for _, c := range x {
+		n.Mul(n, bigRadix)
+		n.Add(n, big.NewInt(int64(c)))
+	}
+	return n
+}
+
+// str returns n as m numerals, most significant first
+func str(radix, m int, n *big.Int) []byte {
+	out := make([]byte, m)
+	n = new(big.Int).Set(n)
+	bigRadix := big.NewInt(int64(radix))
+	digit := new(big.Int)
+	for i := m - 1; i >= 0; i-- {
+		n.DivMod(n, bigRadix, digit)
+		out[i] = byte(digit.Int64())
+	}
+	return out
+}
@@ -0,0 +1,45 @@
+package fieldcrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+)
+
+// test vectors from the NIST FF1 samples
+func TestFF1(t *testing.T) {
+	key, _ := hex.DecodeString("2B7E151628AED2A6ABF7158809CF4F3C")
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := ff1{block: block}
+	numerals := func(s string) []byte {
+		out := make([]byte, len(s))
+		for i, c := range s {
+			switch {
+			case c >= '0' && c <= '9':
+				out[i] = byte(c - '0')
+			default:
+				out[i] = byte(c-'a') + 10
+			}
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		tweak  string
+		radix  int
+		pt, ct string
+	}{
+		{"", 10, "0123456789", "2433477484"},
+		{"39383736353433323130", 10, "0123456789", "6124200773"},
+		{"3737373770717273373737", 36, "0123456789abcdefghi", "a9tv40mll9kdu509eum"},
+	} {
+		tweak, _ := hex.DecodeString(tc.tweak)
+		ct := f.encrypt(tweak, tc.radix, numerals(tc.pt))
+		if !bytes.Equal(ct, numerals(tc.ct)) {
+			t.Errorf("enciphering %s with tweak %q got %v, expected %v", tc.pt, tc.tweak, ct, numerals(tc.ct))
+		}
+	}
+}
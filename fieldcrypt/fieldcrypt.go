@@ -0,0 +1,180 @@
+// Package fieldcrypt protects the values of sensitive fields.
+//
+// An Encrypter envelope-encrypts values so they can be sent to Honeycomb but
+// only read by whoever holds the matching private key.
+//
+// Each value gets a fresh AES-256-GCM key, which is itself encrypted with an
+// RSA public key using OAEP. The result is a single string:
+//
+//	enc1:<key id>:<base64 of wrapped key, nonce and ciphertext>
+//
+// The key id is derived from the public key, so it's possible to tell which
+// key a value needs without being able to decrypt it.
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	prefix = "enc1"
+	// keyIDLen is the number of bytes of the public key hash in a key id
+	keyIDLen  = 8
+	aesKeyLen = 32
+)
+
+// Encrypter encrypts values with a public key
+type Encrypter struct {
+	pub   *rsa.PublicKey
+	keyID string
+}
+
+// Decrypter decrypts values produced by an Encrypter using the private key
+type Decrypter struct {
+	priv  *rsa.PrivateKey
+	keyID string
+}
+
+// NewEncrypter reads a PEM encoded RSA public key, as written by
+// openssl rsa -pubout, from path
+func NewEncrypter(path string) (*Encrypter, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key in %s: %s", path, err)
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key in %s is not an RSA key", path)
+	}
+	return &Encrypter{pub: pub, keyID: keyID(pub)}, nil
+}
+
+// NewDecrypter reads a PEM encoded RSA private key from path
+func NewDecrypter(path string) (*Decrypter, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	var key interface{}
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key in %s: %s", path, err)
+	}
+	priv, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key in %s is not an RSA key", path)
+	}
+	return &Decrypter{priv: priv, keyID: keyID(&priv.PublicKey)}, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	return block, nil
+}
+
+// keyID is the start of the SHA-256 hash of the DER encoded public key
+func keyID(pub *rsa.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		// can't happen for an RSA key
+		panic(err)
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:keyIDLen])
+}
+
+// KeyID identifies the key values are encrypted with
+func (e *Encrypter) KeyID() string {
+	return e.keyID
+}
+
+// Encrypt returns the encrypted form of plaintext
+func (e *Encrypter) Encrypt(plaintext []byte) (string, error) {
+	dataKey := make([]byte, aesKeyLen)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, e.pub, dataKey, []byte(e.keyID))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	blob := append(wrapped, nonce...)
+	blob = gcm.Seal(blob, nonce, plaintext, nil)
+	return prefix + ":" + e.keyID + ":" + base64.StdEncoding.EncodeToString(blob), nil
+}
+
+// Decrypt reverses Encrypt. It fails if value was encrypted with a different
+// key.
+func (d *Decrypter) Decrypt(value string) ([]byte, error) {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) != 3 || parts[0] != prefix {
+		return nil, errors.New("not an encrypted value")
+	}
+	if parts[1] != d.keyID {
+		return nil, fmt.Errorf("value was encrypted with key %s, not %s", parts[1], d.keyID)
+	}
+	blob, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	wrappedLen := (d.priv.PublicKey.N.BitLen() + 7) / 8
+	if len(blob) < wrappedLen {
+		return nil, errors.New("encrypted value is truncated")
+	}
+	dataKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, d.priv, blob[:wrappedLen], []byte(d.keyID))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	blob = blob[wrappedLen:]
+	if len(blob) < gcm.NonceSize() {
+		return nil, errors.New("encrypted value is truncated")
+	}
+	return gcm.Open(nil, blob[:gcm.NonceSize()], blob[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,114 @@
+package fieldcrypt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeKeys writes a new key pair to dir and returns the paths to the public
+// and private keys
+func writeKeys(t *testing.T, dir, name string) (string, string) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubPath := filepath.Join(dir, name+".pub.pem")
+	privPath := filepath.Join(dir, name+".pem")
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+	privPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+	if err := ioutil.WriteFile(pubPath, pubPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(privPath, privPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return pubPath, privPath
+}
+
+func TestRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fieldcrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pubPath, privPath := writeKeys(t, dir, "team")
+	_, otherPrivPath := writeKeys(t, dir, "other")
+
+	enc, err := NewEncrypter(pubPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := NewDecrypter(privPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := enc.Encrypt([]byte("alice@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(value, "enc1:"+enc.KeyID()+":") {
+		t.Errorf("encrypted value %q doesn't carry the key id %s", value, enc.KeyID())
+	}
+	if strings.Contains(value, "alice") {
+		t.Errorf("encrypted value %q contains the plaintext", value)
+	}
+	again, _ := enc.Encrypt([]byte("alice@example.com"))
+	if again == value {
+		t.Error("encrypting the same value twice should give different results")
+	}
+
+	plain, err := dec.Decrypt(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plain) != "alice@example.com" {
+		t.Errorf("decrypted %q", plain)
+	}
+
+	other, err := NewDecrypter(otherPrivPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Decrypt(value); err == nil {
+		t.Error("expected error decrypting with the wrong key")
+	}
+	for _, bad := range []string{"alice@example.com", "enc1:" + enc.KeyID() + ":AAAA", "enc1:" + enc.KeyID() + ":!!"} {
+		if _, err := dec.Decrypt(bad); err == nil {
+			t.Errorf("expected error decrypting %q", bad)
+		}
+	}
+}
+
+func TestBadKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fieldcrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pubPath, privPath := writeKeys(t, dir, "team")
+	if _, err := NewEncrypter(privPath); err == nil {
+		t.Error("expected error using a private key to encrypt")
+	}
+	if _, err := NewDecrypter(pubPath); err == nil {
+		t.Error("expected error using a public key to decrypt")
+	}
+	notPEM := filepath.Join(dir, "junk")
+	ioutil.WriteFile(notPEM, []byte("junk"), 0600)
+	if _, err := NewEncrypter(notPEM); err == nil {
+		t.Error("expected error reading a file without PEM data")
+	}
+	if _, err := NewEncrypter(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected error reading a missing key")
+	}
+}
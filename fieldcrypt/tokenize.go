@@ -0,0 +1,113 @@
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// Tokenizer replaces identifiers with pseudonyms that keep their format:
+// letters stay letters of the same case, digits stay digits and everything
+// else is left alone, so an email address still looks like an email address.
+// The same value always gets the same token for a given key, so tokenized
+// fields can still be joined across datasets, but the original value can't
+// be recovered without the key.
+//
+// Tokens are enciphered with FF1 rather than hashed, so no two values share a
+// token. Short values have few possible tokens, though: a single digit can
+// only become one of ten others.
+type Tokenizer struct {
+	key []byte
+	ff1 ff1
+}
+
+// tokenClasses are the runs of characters that are each enciphered within
+// themselves
+var tokenClasses = []struct {
+	first, radix byte
+}{{'0', 10}, {'A', 26}, {'a', 26}}
+
+// NewTokenizer returns a Tokenizer using the secret key
+func NewTokenizer(key []byte) (*Tokenizer, error) {
+	if len(key) == 0 {
+		return nil, errors.New("tokenize key is empty")
+	}
+	// the AES key is derived from the secret, so it can be any length
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("tokenize"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return &Tokenizer{key: key, ff1: ff1{block: block}}, nil
+}
+
+// Tokenize returns the token for value
+func (t *Tokenizer) Tokenize(value string) string {
+	out := []byte(value)
+	// each class is enciphered in turn, tweaked by the rest of the value as
+	// it stands, so a change anywhere changes the whole token. Every step can
+	// be undone with the key, so the token is still one-to-one. The second
+	// pass makes the first classes depend on the later ones too.
+	for pass := 0; pass < 2; pass++ {
+		for ci, class := range tokenClasses {
+			tweak := make([]byte, len(out), len(out)+2)
+			copy(tweak, out)
+			var idx []int
+			for i, c := range out {
+				if c >= class.first && c < class.first+class.radix {
+					idx = append(idx, i)
+					tweak[i] = 0
+				}
+			}
+			if len(idx) == 0 {
+				continue
+			}
+			tweak = append(tweak, byte(pass), byte(ci))
+			x := make([]byte, len(idx))
+			for j, i := range idx {
+				x[j] = out[i] - class.first
+			}
+			y := t.encipher(tweak, class.radix, x)
+			for j, i := range idx {
+				out[i] = class.first + y[j]
+			}
+		}
+	}
+	return string(out)
+}
+
+// encipher maps the numerals in x to others of the same radix
+func (t *Tokenizer) encipher(tweak []byte, radix byte, x []byte) []byte {
+	if len(x) > 1 {
+		return t.ff1.encrypt(tweak, int(radix), x)
+	}
+	// FF1 needs at least two numerals, so a single one is mapped through a
+	// shuffle of its class chosen by the key and tweak
+	perm := make([]byte, radix)
+	for i := range perm {
+		perm[i] = byte(i)
+	}
+	stream := t.keystream(tweak, len(perm))
+	for i := len(perm) - 1; i > 0; i-- {
+		j := int(stream[i]) % (i + 1)
+		perm[i], perm[j] = perm[j], perm[i]
+	}
+	return []byte{perm[x[0]]}
+}
+
+// keystream returns n bytes derived from the key and value
+func (t *Tokenizer) keystream(value []byte, n int) []byte {
+	stream := make([]byte, 0, n+sha256.Size)
+	var counter [4]byte
+	for i := uint32(0); len(stream) < n; i++ {
+		mac := hmac.New(sha256.New, t.key)
+		binary.BigEndian.PutUint32(counter[:], i)
+		mac.Write(counter[:])
+		mac.Write(value)
+		stream = mac.Sum(stream)
+	}
+	return stream[:n]
+}
@@ -0,0 +1,78 @@
+package fieldcrypt
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestTokenize(t *testing.T) {
+	tok, err := NewTokenizer([]byte("s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := "Alice.Smith+42@example.com"
+	token := tok.Tokenize(value)
+	if token == value {
+		t.Fatal("token is the same as the value")
+	}
+	if len(token) != len(value) {
+		t.Fatalf("token %q is a different length to %q", token, value)
+	}
+	for i, c := range value {
+		tc := rune(token[i])
+		switch {
+		case unicode.IsLower(c):
+			if !unicode.IsLower(tc) {
+				t.Errorf("character %d of %q should be lower case", i, token)
+			}
+		case unicode.IsUpper(c):
+			if !unicode.IsUpper(tc) {
+				t.Errorf("character %d of %q should be upper case", i, token)
+			}
+		case unicode.IsDigit(c):
+			if !unicode.IsDigit(tc) {
+				t.Errorf("character %d of %q should be a digit", i, token)
+			}
+		default:
+			if c != tc {
+				t.Errorf("character %d of %q should be left alone", i, token)
+			}
+		}
+	}
+	if tok.Tokenize(value) != token {
+		t.Error("tokenizing should be deterministic")
+	}
+	other, _ := NewTokenizer([]byte("other"))
+	if other.Tokenize(value) == token {
+		t.Error("a different key should give a different token")
+	}
+	// values longer than a single hash still get tokenized throughout
+	long := strings.Repeat("a", 100)
+	if strings.HasSuffix(tok.Tokenize(long), strings.Repeat("a", 40)) {
+		t.Error("long values should be tokenized to the end")
+	}
+	if _, err := NewTokenizer(nil); err == nil {
+		t.Error("expected error for empty key")
+	}
+}
+
+func TestTokenizeIsOneToOne(t *testing.T) {
+	tok, _ := NewTokenizer([]byte("s3cret"))
+	var values []string
+	for i := 0; i < 100000; i++ {
+		values = append(values, fmt.Sprintf("%05d", i))
+	}
+	for c := 'a'; c <= 'z'; c++ {
+		values = append(values, string(c), "x"+string(c), "user-"+string(c)+"1")
+	}
+	seen := make(map[string]string)
+	for _, v := range values {
+		token := tok.Tokenize(v)
+		if prev, ok := seen[token]; ok {
+			t.Fatalf("%q and %q both tokenized to %q", prev, v, token)
+		}
+		seen[token] = v
+	}
+}
@@ -0,0 +1,222 @@
+// Package gcpapi authorizes requests to Google Cloud APIs, such as Cloud
+// Storage and Pub/Sub, without the Google Cloud client libraries.
+package gcpapi
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultTokenURI = "https://oauth2.googleapis.com/token"
+
+// metadataTokenURL gives the token of the service account attached to the
+// GCE instance, GKE pod or Cloud Run service we're running on
+var metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// credentialsFile holds the fields of the service account keys downloaded
+// from the console, and of the user credentials gcloud writes for
+// application default credentials
+type credentialsFile struct {
+	Type string `json:"type"`
+
+	// service_account
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+
+	// authorized_user
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// TokenSource gets OAuth access tokens, reusing each until it's about to
+// expire
+type TokenSource struct {
+	// fetch gets a new token and how long it lasts
+	fetch func() (string, time.Duration, error)
+
+	lock    sync.Mutex
+	current string
+	expires time.Time
+}
+
+// Token returns an access token to send as a bearer token
+func (t *TokenSource) Token() (string, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.current != "" && time.Now().Add(time.Minute).Before(t.expires) {
+		return t.current, nil
+	}
+	token, lifetime, err := t.fetch()
+	if err != nil {
+		return "", err
+	}
+	t.current = token
+	t.expires = time.Now().Add(lifetime)
+	return token, nil
+}
+
+// NewTokenSource finds credentials in path, or GOOGLE_APPLICATION_CREDENTIALS
+// if it's empty, and otherwise uses those of the metadata server. Tokens for
+// service accounts are limited to scope; those from the metadata server and
+// for users have the scopes they were granted.
+func NewTokenSource(path, scope string, client *http.Client) (*TokenSource, error) {
+	if path == "" {
+		path = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if path == "" {
+		return &TokenSource{fetch: func() (string, time.Duration, error) {
+			req, err := http.NewRequest("GET", metadataTokenURL, nil)
+			if err != nil {
+				return "", 0, err
+			}
+			req.Header.Set("Metadata-Flavor", "Google")
+			return fetchToken(client, req)
+		}}, nil
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var creds credentialsFile
+	if err := json.Unmarshal(contents, &creds); err != nil {
+		return nil, fmt.Errorf("reading GCP credentials from %s: %v", path, err)
+	}
+	switch creds.Type {
+	case "service_account":
+		key, err := parsePrivateKey(creds.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("reading GCP credentials from %s: %v", path, err)
+		}
+		if creds.TokenURI == "" {
+			creds.TokenURI = defaultTokenURI
+		}
+		return &TokenSource{fetch: func() (string, time.Duration, error) {
+			assertion, err := signJWT(creds, key, scope, time.Now())
+			if err != nil {
+				return "", 0, err
+			}
+			return postToken(client, creds.TokenURI, url.Values{
+				"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+				"assertion":  {assertion},
+			})
+		}}, nil
+	case "authorized_user":
+		return &TokenSource{fetch: func() (string, time.Duration, error) {
+			return postToken(client, defaultTokenURI, url.Values{
+				"grant_type":    {"refresh_token"},
+				"client_id":     {creds.ClientID},
+				"client_secret": {creds.ClientSecret},
+				"refresh_token": {creds.RefreshToken},
+			})
+		}}, nil
+	}
+	return nil, fmt.Errorf("GCP credentials in %s are of unsupported type %q", path, creds.Type)
+}
+
+func parsePrivateKey(keyPEM string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New("no PEM private key found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return key, nil
+}
+
+// signJWT returns the assertion a service account exchanges for an access
+// token
+func signJWT(creds credentialsFile, key *rsa.PrivateKey, scope string, now time.Time) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": creds.PrivateKeyID})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   creds.ClientEmail,
+		"scope": scope,
+		"aud":   creds.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func postToken(client *http.Client, tokenURI string, form url.Values) (string, time.Duration, error) {
+	req, err := http.NewRequest("POST", tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return fetchToken(client, req)
+}
+
+// fetchToken sends a request for an access token and decodes the response
+func fetchToken(client *http.Client, req *http.Request) (string, time.Duration, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("getting GCP access token: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("getting GCP access token: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return "", 0, fmt.Errorf("getting GCP access token: %v", err)
+	}
+	if out.AccessToken == "" {
+		return "", 0, errors.New("getting GCP access token: none returned")
+	}
+	return out.AccessToken, time.Duration(out.ExpiresIn) * time.Second, nil
+}
+
+// ResponseError returns the error in resp, a failed response from a Google
+// Cloud JSON API, and closes its body
+func ResponseError(resp *http.Response) error {
+	defer resp.Body.Close()
+	var e struct {
+		Error struct {
+			Message string
+		}
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	json.Unmarshal(body, &e)
+	return fmt.Errorf("%s: %s", resp.Status, e.Error.Message)
+}
@@ -0,0 +1,31 @@
+package gcpapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestMetadataToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"from-metadata","expires_in":3599,"token_type":"Bearer"}`)
+	}))
+	defer server.Close()
+	defer func(u string) { metadataTokenURL = u }(metadataTokenURL)
+	metadataTokenURL = server.URL
+	os.Unsetenv("GOOGLE_APPLICATION_CREDENTIALS")
+
+	tokens, err := NewTokenSource("", "", http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := tokens.Token(); err != nil || token != "from-metadata" {
+		t.Errorf("got token %q, %v, expected from-metadata", token, err)
+	}
+}
@@ -0,0 +1,209 @@
+// Package gcs reads the log files already in a Google Cloud Storage bucket,
+// for backfilling logs exported there by Cloud Logging sinks and other GCP
+// services.
+//
+// Like the s3backfill package, gcs provides a channel on which lines are
+// sent as strings, to be handed to the configured parser. The objects under
+// each prefix given are read once by the bucket package, which keeps track
+// of those already read so a rerun skips them. Objects stored gzipped, with
+// Content-Encoding: gzip, are fetched as stored and decompressed here, as
+// are gzipped files stored as they are.
+//
+// Requests are authorized with a service account key, gcloud's application
+// default credentials, or failing those, the service account of the
+// instance honeytail runs on.
+package gcs
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/honeycombio/honeytail/bucket"
+	"github.com/honeycombio/honeytail/gcpapi"
+	"github.com/honeycombio/honeytail/tail"
+)
+
+type Options struct {
+	CredentialsFile string `long:"credentials_file" description:"Service account key, in JSON, to authorize requests with. Defaults to GOOGLE_APPLICATION_CREDENTIALS, or else the service account of the instance honeytail runs on"`
+	Endpoint        string `long:"endpoint" description:"URL of the Cloud Storage API, to use an emulator" default:"https://storage.googleapis.com"`
+	StateFile       string `long:"statefile" description:"File listing the objects already read, which are skipped. Defaults to honeytail.gcsbackfill.state in the system temp directory"`
+	Start           string `long:"start" description:"Only read objects from this date (YYYY-MM-DD) on. An object's date is the first date in its name, as in .../2017/01/02/... or ...2017-01-02..., or else when it was last updated"`
+	End             string `long:"end" description:"Only read objects up to and including this date (YYYY-MM-DD)"`
+}
+
+type Config struct {
+	// URLs are where to read objects from, in the form gs://bucket/prefix
+	URLs []string
+	// GCS backfill specific options
+	Options Options
+}
+
+// readOnlyScope is the OAuth scope asked for, which is enough to list and
+// read objects
+const readOnlyScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// store lists and fetches objects with the Cloud Storage JSON API
+type store struct {
+	endpoint string
+	tokens   *gcpapi.TokenSource
+	http     *http.Client
+}
+
+// get fetches path with query from the Cloud Storage API with a current
+// token, returning the response if it's 200 OK and closing it if not. The
+// caller must close the body of a response that's returned.
+func (s *store) get(path string, query url.Values) (*http.Response, error) {
+	token, err := s.tokens.Token()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", s.endpoint+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	// ask for objects stored gzipped as they are, rather than having them
+	// decompressed on the way
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, gcpapi.ResponseError(resp)
+	}
+	return resp, nil
+}
+
+func (s *store) List(bucketName, prefix, token string) ([]bucket.Object, string, error) {
+	query := url.Values{
+		"prefix": {prefix},
+		"fields": {"items(name,updated),nextPageToken"},
+	}
+	if token != "" {
+		query.Set("pageToken", token)
+	}
+	resp, err := s.get("/storage/v1/b/"+url.PathEscape(bucketName)+"/o", query)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	var body io.Reader = resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, "", err
+		}
+		body = gz
+	}
+	var out struct {
+		Items []struct {
+			Name    string
+			Updated time.Time
+		}
+		NextPageToken string
+	}
+	if err := json.NewDecoder(body).Decode(&out); err != nil {
+		return nil, "", err
+	}
+	objects := make([]bucket.Object, 0, len(out.Items))
+	for _, item := range out.Items {
+		objects = append(objects, bucket.Object{Key: item.Name, Modified: item.Updated})
+	}
+	return objects, out.NextPageToken, nil
+}
+
+func (s *store) Get(bucketName, key string) (io.ReadCloser, error) {
+	resp, err := s.get("/storage/v1/b/"+url.PathEscape(bucketName)+"/o/"+url.PathEscape(key),
+		url.Values{"alt": {"media"}})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		return resp.Body, nil
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return gzipBody{gz, resp.Body}, nil
+}
+
+// gzipBody reads a response with Content-Encoding: gzip, closing the
+// response when it's closed
+type gzipBody struct {
+	io.Reader
+	io.Closer
+}
+
+// GetSampledEntries wraps GetEntries and returns a list of channels that
+// provide sampled entries
+func GetSampledEntries(conf Config, sampleRate uint, abort <-chan struct{}) ([]chan string, error) {
+	linesChans, err := GetEntries(conf, abort)
+	if err != nil {
+		return nil, err
+	}
+	return tail.SampleAll(linesChans, sampleRate), nil
+}
+
+// GetEntries starts reading the objects under conf.URLs and returns a
+// channel on which their lines are sent. The channel is closed once they've
+// all been read, or abort is closed.
+func GetEntries(conf Config, abort <-chan struct{}) ([]chan string, error) {
+	bc := bucket.Config{StateFile: conf.Options.StateFile}
+	for _, u := range conf.URLs {
+		loc, err := bucket.ParseLocation(u, "gs")
+		if err != nil {
+			return nil, err
+		}
+		bc.Locations = append(bc.Locations, loc)
+	}
+	if len(bc.Locations) == 0 {
+		return nil, errors.New("no GCS locations to backfill from")
+	}
+	var err error
+	if bc.Start, err = bucket.ParseDate(conf.Options.Start); err != nil {
+		return nil, err
+	}
+	if bc.End, err = bucket.ParseDate(conf.Options.End); err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ResponseHeaderTimeout: 30 * time.Second,
+	}}
+	tokens, err := gcpapi.NewTokenSource(conf.Options.CredentialsFile, readOnlyScope, &http.Client{Timeout: 30 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	// get a token now, so bad credentials are reported at startup
+	if _, err := tokens.Token(); err != nil {
+		return nil, err
+	}
+	endpoint := conf.Options.Endpoint
+	if endpoint == "" {
+		endpoint = "https://storage.googleapis.com"
+	}
+	bc.Store = &store{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		tokens:   tokens,
+		http:     client,
+	}
+	if bc.StateFile == "" {
+		bc.StateFile = filepath.Join(os.TempDir(), "honeytail.gcsbackfill.state")
+	}
+	lines, err := bucket.GetEntries(bc, abort)
+	if err != nil {
+		return nil, err
+	}
+	return []chan string{lines}, nil
+}
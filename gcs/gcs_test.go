@@ -0,0 +1,192 @@
+package gcs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// fakeGCS serves a token endpoint that checks service account assertions,
+// and a bucket of objects, listed a page of two at a time
+type fakeGCS struct {
+	key     *rsa.PublicKey
+	objects []fakeObject
+}
+
+type fakeObject struct {
+	name    string
+	updated string
+	data    []byte
+	// gzipped objects are stored with Content-Encoding: gzip
+	gzipped bool
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		r.ParseForm()
+		parts := strings.Split(r.PostForm.Get("assertion"), ".")
+		if len(parts) != 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(f.key, crypto.SHA256, hash[:], sig); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_grant"}`)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"let-me-in","expires_in":3600,"token_type":"Bearer"}`)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer let-me-in" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":{"code":401,"message":"Invalid Credentials"}}`)
+		return
+	}
+	if r.URL.EscapedPath() == "/storage/v1/b/logs/o" {
+		var items []map[string]string
+		for _, obj := range f.objects {
+			if strings.HasPrefix(obj.name, r.URL.Query().Get("prefix")) {
+				items = append(items, map[string]string{"name": obj.name, "updated": obj.updated})
+			}
+		}
+		out := map[string]interface{}{}
+		if r.URL.Query().Get("pageToken") == "" && len(items) > 2 {
+			out["items"] = items[:2]
+			out["nextPageToken"] = "page2"
+		} else if r.URL.Query().Get("pageToken") == "page2" {
+			out["items"] = items[2:]
+		} else {
+			out["items"] = items
+		}
+		json.NewEncoder(w).Encode(out)
+		return
+	}
+	for _, obj := range f.objects {
+		if r.URL.EscapedPath() != "/storage/v1/b/logs/o/"+strings.Replace(obj.name, "/", "%2F", -1) {
+			continue
+		}
+		if r.URL.Query().Get("alt") != "media" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !obj.gzipped {
+			w.Write(obj.data)
+			return
+		}
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			// decompressive transcoding
+			gz, _ := gzip.NewReader(bytes.NewReader(obj.data))
+			data, _ := ioutil.ReadAll(gz)
+			w.Write(data)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(obj.data)
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprint(w, `{"error":{"code":404,"message":"No such object"}}`)
+}
+
+func gzipped(s string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(s))
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestGetEntries(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeGCS{
+		key: &key.PublicKey,
+		objects: []fakeObject{
+			{"stdout/2017/01/01/00:00:00_00:59:59_S0.json", "2017-01-01T01:00:00Z", []byte("too early"), false},
+			{"stdout/2017/01/02/00:00:00_00:59:59_S0.json", "2017-01-02T01:00:00Z", []byte(`{"a":1}` + "\n" + `{"a":2}` + "\n"), false},
+			{"stdout/2017/01/02/transcoded.json", "2017-01-02T02:00:00Z", gzipped(`{"a":3}` + "\n"), true},
+			{"stdout/2017/01/02/file.json.gz", "2017-01-02T03:00:00Z", gzipped(`{"a":4}`), false},
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "gcs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	creds, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "honeytail@project.iam.gserviceaccount.com",
+		"private_key":  string(keyPEM),
+		"token_uri":    server.URL + "/token",
+	})
+	credsPath := filepath.Join(dir, "key.json")
+	ioutil.WriteFile(credsPath, creds, 0600)
+
+	conf := Config{
+		URLs: []string{"gs://logs/stdout/"},
+		Options: Options{
+			CredentialsFile: credsPath,
+			Endpoint:        server.URL,
+			StateFile:       filepath.Join(dir, "state"),
+			Start:           "2017-01-02",
+		},
+	}
+	read := func() []string {
+		chans, err := GetEntries(conf, make(chan struct{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		for line := range chans[0] {
+			lines = append(lines, line)
+		}
+		return lines
+	}
+	expected := []string{`{"a":1}`, `{"a":2}`, `{"a":3}`, `{"a":4}`}
+	if lines := read(); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("read %q, expected %q", lines, expected)
+	}
+	if lines := read(); len(lines) != 0 {
+		t.Errorf("read %q on rerunning, expected nothing", lines)
+	}
+
+	// credentials for a key the token endpoint doesn't know
+	other, _ := rsa.GenerateKey(rand.Reader, 1024)
+	otherPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(other)})
+	creds, _ = json.Marshal(map[string]string{
+		"type":        "service_account",
+		"private_key": string(otherPEM),
+		"token_uri":   server.URL + "/token",
+	})
+	ioutil.WriteFile(credsPath, creds, 0600)
+	if _, err := GetEntries(conf, make(chan struct{})); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("got %v for unknown credentials, expected invalid_grant", err)
+	}
+}
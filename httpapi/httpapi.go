@@ -0,0 +1,56 @@
+// Package httpapi reads from the JSON HTTP APIs, such as Docker's and
+// Kubernetes', that honeytail talks to without their client libraries.
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// maxErrorBody is the most of an error response read for its message
+const maxErrorBody = 64 * 1024
+
+// Error is an error response from an API
+type Error struct {
+	// API names the API in the error message, eg "Docker"
+	API     string
+	Code    int
+	Status  string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.API + " API " + e.Status + ": " + e.Message
+}
+
+// Get sends a GET request for url to api with header, returning the
+// response if it's 200 OK. Otherwise it returns an *Error with the message
+// from the JSON body of the response. The caller must close the response
+// body.
+func Get(ctx context.Context, client *http.Client, api string, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %v", api, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var status struct {
+			Message string
+		}
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		json.Unmarshal(body, &status)
+		return nil, &Error{api, resp.StatusCode, resp.Status, status.Message}
+	}
+	return resp, nil
+}
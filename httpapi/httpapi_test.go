@@ -0,0 +1,46 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"message":"no token"}`)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer token")
+	resp, err := Get(context.Background(), server.Client(), "Test", server.URL, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Errorf("expected body ok, got %q", body)
+	}
+
+	_, err = Get(context.Background(), server.Client(), "Test", server.URL, nil)
+	e, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("expected an *Error, got %v", err)
+	}
+	if e.Code != http.StatusUnauthorized || e.Message != "no token" {
+		t.Errorf("unexpected error %+v", e)
+	}
+	if e.Error() != "Test API 401 Unauthorized: no token" {
+		t.Errorf("unexpected error message %q", e.Error())
+	}
+}
@@ -0,0 +1,346 @@
+// Package journald follows the systemd journal, for services that log to it
+// rather than to files, without needing journalctl's output piped in.
+//
+// Entries are read from journalctl's JSON output, which gives every field
+// of each entry, so no cgo bindings to libsystemd are needed. The cursor of
+// the last entry sent is saved to a file, and reading carries on after it
+// when honeytail restarts, so entries written while it was stopped aren't
+// lost and those already sent aren't sent again. The first time, entries
+// from previous boots can be read before following new ones, with the cursor
+// kept in the same way so nothing is read twice.
+package journald
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/atomicfile"
+	"github.com/honeycombio/honeytail/event"
+)
+
+type Options struct {
+	Boots       uint     `long:"boots" description:"When there's no saved position to carry on from, start reading --journald at the first entry of this many boots before the current one, rather than at the end, then follow new entries as usual. If the journal doesn't go back that far, it's read from the start. Not used with --tail.read_from=beginning, which reads every boot" default:"0"`
+	Identifiers []string `long:"identifier" description:"Only read --journald entries with this syslog identifier (SYSLOG_IDENTIFIER), as journalctl --identifier does. May be specified multiple times"`
+	Priority    string   `long:"priority" description:"Only read --journald entries of this priority or more important, eg err or 3, or in a range such as warning..err, as journalctl --priority does"`
+}
+
+type Config struct {
+	// Units are the systemd units whose entries to read. Glob patterns, such
+	// as nginx*, are matched by journalctl.
+	Units []string
+	// CursorFile is where the cursor of the last entry read is kept
+	CursorFile string
+	// ReadFrom is where to start reading when there's no cursor to carry on
+	// from, as for --tail.read_from: beginning, end or last. Last carries on
+	// after the saved cursor if there is one, or starts at the end.
+	ReadFrom string
+	// Stop stops once the entries in the journal have been read, rather than
+	// waiting for more
+	Stop bool
+	// SampleRate keeps 1/SampleRate of the entries
+	SampleRate uint
+	// Journald specific options
+	Options Options
+}
+
+// journalctl is the command entries are read from, replaced in tests
+var journalctl = "journalctl"
+
+// GetEvents starts reading the journal and returns the channel on which an
+// event is sent for each entry. The channel is closed once abort is closed,
+// or once the entries have been read if conf.Stop is set.
+func GetEvents(conf Config, abort <-chan struct{}) (chan event.Event, error) {
+	if conf.SampleRate == 0 {
+		conf.SampleRate = 1
+	}
+	if len(conf.Units) == 0 {
+		return nil, errors.New("no journald units to read")
+	}
+	cursor := readCursor(conf.CursorFile)
+	var start string
+	if conf.Options.Boots > 0 && !readsAll(conf) && !resumes(conf, cursor) {
+		var err error
+		if start, err = bootCursor(conf.Options.Boots); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"boots": conf.Options.Boots,
+				"err":   err,
+			}).Info("Couldn't find the earliest boot to read; reading the journal from the start")
+		}
+	}
+	cmd := exec.Command(journalctl, args(conf, cursor, start)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	logrus.WithField("units", conf.Units).Info("Reading entries from the systemd journal")
+
+	// reading stops when journalctl is killed
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-abort:
+			cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+	events := make(chan event.Event)
+	go func() {
+		defer close(events)
+		cursor := read(stdout, conf, events, abort)
+		close(done)
+		if err := cmd.Wait(); err != nil && !aborted(abort) {
+			logrus.WithFields(logrus.Fields{
+				"err":    err,
+				"output": strings.TrimSpace(stderr.String()),
+			}).Warn("journalctl failed")
+		}
+		saveCursor(conf.CursorFile, cursor)
+	}()
+	return events, nil
+}
+
+// args returns the arguments to journalctl to read the entries conf asks
+// for, carrying on after cursor if it's set. Otherwise, if conf asks for
+// previous boots, reading starts at bootCursor or the start of the journal.
+func args(conf Config, cursor, bootCursor string) []string {
+	args := []string{"--output=json", "--all", "--no-pager"}
+	if !conf.Stop {
+		args = append(args, "--follow")
+	}
+	switch {
+	case readsAll(conf):
+	case resumes(conf, cursor):
+		args = append(args, "--after-cursor="+cursor)
+	case conf.Options.Boots > 0:
+		if bootCursor != "" {
+			args = append(args, "--cursor="+bootCursor)
+		}
+	default:
+		// only entries written from now on
+		args = append(args, "--lines=0")
+	}
+	for _, unit := range conf.Units {
+		args = append(args, "--unit="+unit)
+	}
+	for _, identifier := range conf.Options.Identifiers {
+		args = append(args, "--identifier="+identifier)
+	}
+	if conf.Options.Priority != "" {
+		args = append(args, "--priority="+conf.Options.Priority)
+	}
+	return args
+}
+
+// readsAll returns true if conf asks for the whole journal
+func readsAll(conf Config) bool {
+	return conf.ReadFrom == "beginning" || conf.ReadFrom == "start"
+}
+
+// resumes returns true if conf asks to carry on after the saved cursor
+func resumes(conf Config, cursor string) bool {
+	return conf.ReadFrom == "last" && cursor != ""
+}
+
+// bootCursor returns the cursor of the first entry of the boot this many
+// before the current one. It's an error if the journal doesn't go back that
+// far.
+func bootCursor(boots uint) (string, error) {
+	cmd := exec.Command(journalctl, "--output=json", "--all", "--no-pager",
+		"--boot=-"+strconv.FormatUint(uint64(boots), 10))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	// only the first entry is needed, so journalctl is killed once it's read
+	line, _ := bufio.NewReader(stdout).ReadBytes('\n')
+	cmd.Process.Kill()
+	cmd.Wait()
+	if len(bytes.TrimSpace(line)) == 0 {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.New(msg)
+		}
+		return "", errors.New("no entries in that boot")
+	}
+	_, cursor, err := parseEntry(line)
+	return cursor, err
+}
+
+// read sends an event for each entry journalctl writes to r, until it's
+// done or abort is closed, saving the cursor of the last entry sent every
+// second. It returns that cursor.
+func read(r io.Reader, conf Config, events chan<- event.Event, abort <-chan struct{}) string {
+	var cursor, saved string
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	input := bufio.NewReader(r)
+	for {
+		line, err := input.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) != 0 {
+			ev, entryCursor, perr := parseEntry(line)
+			if perr != nil {
+				logrus.WithFields(logrus.Fields{
+					"line": string(line),
+					"err":  perr,
+				}).Debug("skipping journal entry that couldn't be parsed")
+			} else if conf.SampleRate > 1 && rand.Intn(int(conf.SampleRate)) != 0 {
+				// dropped entries count as read
+				cursor = entryCursor
+			} else {
+				ev.SampleRate = int(conf.SampleRate)
+				select {
+				case events <- ev:
+					cursor = entryCursor
+				case <-abort:
+					return cursor
+				}
+			}
+		}
+		if err != nil {
+			return cursor
+		}
+		select {
+		case <-ticker.C:
+			if cursor != saved {
+				saveCursor(conf.CursorFile, cursor)
+				saved = cursor
+			}
+		default:
+		}
+	}
+}
+
+// numericFields are the journal's fields that always hold numbers
+var numericFields = map[string]bool{
+	"priority":        true,
+	"syslog_facility": true,
+	"syslog_pid":      true,
+	"errno":           true,
+	"_pid":            true,
+	"_uid":            true,
+	"_gid":            true,
+	"_audit_session":  true,
+	"_audit_loginuid": true,
+}
+
+// parseEntry turns a journal entry, as written by journalctl --output=json,
+// into an event, and returns its cursor. Fields are named as in the
+// journal, in lower case; the ones starting with __, which journalctl adds
+// to say where the entry is, are left out. Fields holding binary data,
+// which journalctl writes as arrays of bytes, are sent as strings.
+func parseEntry(line []byte) (event.Event, string, error) {
+	var entry map[string]interface{}
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return event.Event{}, "", err
+	}
+	cursor, _ := entry["__CURSOR"].(string)
+	if cursor == "" {
+		return event.Event{}, "", errors.New("journal entry has no cursor")
+	}
+	ev := event.Event{
+		Timestamp: time.Now().UTC(),
+		Data:      make(map[string]interface{}, len(entry)),
+	}
+	if usec, err := strconv.ParseInt(stringValue(entry["__REALTIME_TIMESTAMP"]), 10, 64); err == nil {
+		ev.Timestamp = time.Unix(usec/1e6, (usec%1e6)*1e3).UTC()
+	}
+	for k, v := range entry {
+		if strings.HasPrefix(k, "__") {
+			continue
+		}
+		k = strings.ToLower(k)
+		if bytesValue, ok := v.([]interface{}); ok {
+			if s, ok := binaryString(bytesValue); ok {
+				v = s
+			}
+		}
+		if s, ok := v.(string); ok && numericFields[k] {
+			if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				v = n
+			}
+		}
+		ev.Data[k] = v
+	}
+	return ev, cursor, nil
+}
+
+// stringValue returns v if it's a string, or ""
+func stringValue(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+// binaryString returns the string made of the bytes in v, if v holds
+// nothing but numbers that fit in a byte. Fields with several values are
+// also written as arrays, but of strings.
+func binaryString(v []interface{}) (string, bool) {
+	b := make([]byte, 0, len(v))
+	for _, x := range v {
+		n, ok := x.(float64)
+		if !ok || n < 0 || n > 255 || n != float64(int(n)) {
+			return "", false
+		}
+		b = append(b, byte(n))
+	}
+	return string(b), true
+}
+
+// readCursor returns the cursor saved in path, or "" if there's none
+func readCursor(path string) string {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.WithFields(logrus.Fields{
+				"file": path,
+				"err":  err,
+			}).Warn("Failed to read the saved journal cursor")
+		}
+		return ""
+	}
+	return strings.TrimSpace(string(contents))
+}
+
+// saveCursor writes cursor to path, unless it's empty because nothing's
+// been read
+func saveCursor(path string, cursor string) {
+	if cursor == "" {
+		return
+	}
+	if err := atomicfile.Write(path, []byte(cursor+"\n")); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"file": path,
+			"err":  err,
+		}).Warn("Failed to save the journal cursor")
+	}
+}
+
+// aborted returns true if abort has been closed
+func aborted(abort <-chan struct{}) bool {
+	select {
+	case <-abort:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,233 @@
+package journald
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+func TestArgs(t *testing.T) {
+	units := []string{"nginx.service", "app*"}
+	tests := []struct {
+		conf     Config
+		cursor   string
+		expected []string
+	}{
+		{
+			Config{Units: units, ReadFrom: "last"},
+			"s=abc;i=1",
+			[]string{"--output=json", "--all", "--no-pager", "--follow", "--after-cursor=s=abc;i=1", "--unit=nginx.service", "--unit=app*"},
+		},
+		// with nothing saved, last starts at the end
+		{
+			Config{Units: units[:1], ReadFrom: "last"},
+			"",
+			[]string{"--output=json", "--all", "--no-pager", "--follow", "--lines=0", "--unit=nginx.service"},
+		},
+		{
+			Config{Units: units[:1], ReadFrom: "end"},
+			"s=abc;i=1",
+			[]string{"--output=json", "--all", "--no-pager", "--follow", "--lines=0", "--unit=nginx.service"},
+		},
+		// as for --backfill
+		{
+			Config{Units: units[:1], ReadFrom: "beginning", Stop: true},
+			"s=abc;i=1",
+			[]string{"--output=json", "--all", "--no-pager", "--unit=nginx.service"},
+		},
+		// previous boots are read when there's nothing to carry on from
+		{
+			Config{Units: units[:1], ReadFrom: "last", Options: Options{Boots: 2}},
+			"",
+			[]string{"--output=json", "--all", "--no-pager", "--follow", "--cursor=s=boot;i=1", "--unit=nginx.service"},
+		},
+		{
+			Config{Units: units[:1], ReadFrom: "last", Options: Options{Boots: 2}},
+			"s=abc;i=1",
+			[]string{"--output=json", "--all", "--no-pager", "--follow", "--after-cursor=s=abc;i=1", "--unit=nginx.service"},
+		},
+		{
+			Config{Units: units[:1], ReadFrom: "end", Options: Options{Identifiers: []string{"nginx", "sshd"}, Priority: "warning..err"}},
+			"",
+			[]string{"--output=json", "--all", "--no-pager", "--follow", "--lines=0", "--unit=nginx.service", "--identifier=nginx", "--identifier=sshd", "--priority=warning..err"},
+		},
+	}
+	for _, tt := range tests {
+		if got := args(tt.conf, tt.cursor, "s=boot;i=1"); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("args(%+v, %q) = %q, expected %q", tt.conf, tt.cursor, got, tt.expected)
+		}
+	}
+	// the whole journal if it doesn't go back as many boots as asked
+	conf := Config{Units: units[:1], Stop: true, Options: Options{Boots: 5}}
+	expected := []string{"--output=json", "--all", "--no-pager", "--unit=nginx.service"}
+	if got := args(conf, "", ""); !reflect.DeepEqual(got, expected) {
+		t.Errorf("args without a boot cursor = %q, expected %q", got, expected)
+	}
+}
+
+func TestParseEntry(t *testing.T) {
+	line := `{"__CURSOR":"s=abc;i=2","__REALTIME_TIMESTAMP":"1277132645123456","__MONOTONIC_TIMESTAMP":"123","_BOOT_ID":"b1","MESSAGE":"GET / 200","PRIORITY":"6","_PID":"1234","_SYSTEMD_UNIT":"nginx.service","SYSLOG_IDENTIFIER":"nginx","BINARY":[104,105,10],"MULTI":["a","b"]}`
+	ev, cursor, err := parseEntry([]byte(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor != "s=abc;i=2" {
+		t.Errorf("got cursor %q", cursor)
+	}
+	expected := event.Event{
+		Timestamp: time.Date(2010, 6, 21, 15, 4, 5, 123456000, time.UTC),
+		Data: map[string]interface{}{
+			"_boot_id":          "b1",
+			"message":           "GET / 200",
+			"priority":          int64(6),
+			"_pid":              int64(1234),
+			"_systemd_unit":     "nginx.service",
+			"syslog_identifier": "nginx",
+			"binary":            "hi\n",
+			"multi":             []interface{}{"a", "b"},
+		},
+	}
+	if !reflect.DeepEqual(ev, expected) {
+		t.Errorf("got event %+v, expected %+v", ev, expected)
+	}
+
+	if _, _, err := parseEntry([]byte(`{"MESSAGE":"no cursor"}`)); err == nil {
+		t.Error("expected an error for an entry without a cursor")
+	}
+	if _, _, err := parseEntry([]byte(`-- No entries --`)); err == nil {
+		t.Error("expected an error for a line that isn't JSON")
+	}
+}
+
+func TestRead(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "journald")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	conf := Config{CursorFile: filepath.Join(tmpdir, "cursor"), SampleRate: 1}
+
+	input := strings.Join([]string{
+		`{"__CURSOR":"c1","MESSAGE":"one"}`,
+		`not json`,
+		`{"__CURSOR":"c2","MESSAGE":"two"}`,
+		``,
+	}, "\n")
+	events := make(chan event.Event, 10)
+	abort := make(chan struct{})
+	if cursor := read(strings.NewReader(input), conf, events, abort); cursor != "c2" {
+		t.Errorf("got cursor %q, expected c2", cursor)
+	}
+	close(events)
+	var messages []interface{}
+	for ev := range events {
+		messages = append(messages, ev.Data["message"])
+	}
+	if !reflect.DeepEqual(messages, []interface{}{"one", "two"}) {
+		t.Errorf("got messages %v", messages)
+	}
+
+	// entries not sent before aborting aren't counted as read
+	close(abort)
+	blocked := make(chan event.Event)
+	if cursor := read(strings.NewReader(input), conf, blocked, abort); cursor != "" {
+		t.Errorf("got cursor %q after aborting, expected none", cursor)
+	}
+}
+
+func TestGetEvents(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "journald")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	// a journalctl that writes its arguments to a file and two entries
+	script := filepath.Join(tmpdir, "journalctl")
+	argsFile := filepath.Join(tmpdir, "args")
+	body := "#!/bin/sh\necho \"$@\" > " + argsFile + "\n" +
+		`echo '{"__CURSOR":"c1","MESSAGE":"one"}'` + "\n" +
+		`echo '{"__CURSOR":"c2","MESSAGE":"two"}'` + "\n"
+	if err := ioutil.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(orig string) { journalctl = orig }(journalctl)
+	journalctl = script
+
+	conf := Config{
+		Units:      []string{"app.service"},
+		CursorFile: filepath.Join(tmpdir, "cursor"),
+		ReadFrom:   "last",
+		Stop:       true,
+	}
+	ioutil.WriteFile(conf.CursorFile, []byte("c0\n"), 0644)
+	events, err := GetEvents(conf, make(chan struct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for range events {
+		count++
+	}
+	if count != 2 {
+		t.Errorf("got %d events, expected 2", count)
+	}
+	if got, _ := ioutil.ReadFile(argsFile); string(got) != "--output=json --all --no-pager --after-cursor=c0 --unit=app.service\n" {
+		t.Errorf("journalctl run with %q", got)
+	}
+	if got := readCursor(conf.CursorFile); got != "c2" {
+		t.Errorf("saved cursor %q, expected c2", got)
+	}
+}
+
+func TestGetEventsBoots(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "journald")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	// a journalctl that has two boots before the current one
+	script := filepath.Join(tmpdir, "journalctl")
+	argsFile := filepath.Join(tmpdir, "args")
+	body := "#!/bin/sh\n" +
+		"case \"$*\" in\n" +
+		"*--boot=-2*) echo '{\"__CURSOR\":\"b2\",\"MESSAGE\":\"booting\"}'; exit ;;\n" +
+		"*--boot=*) echo 'Data from the specified boot is not available' >&2; exit 1 ;;\n" +
+		"esac\n" +
+		"echo \"$@\" > " + argsFile + "\n" +
+		`echo '{"__CURSOR":"c1","MESSAGE":"one"}'` + "\n"
+	if err := ioutil.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(orig string) { journalctl = orig }(journalctl)
+	journalctl = script
+
+	for _, tt := range []struct {
+		boots    uint
+		expected string
+	}{
+		{2, "--output=json --all --no-pager --cursor=b2 --unit=app.service\n"},
+		{3, "--output=json --all --no-pager --unit=app.service\n"},
+	} {
+		conf := Config{
+			Units:      []string{"app.service"},
+			CursorFile: filepath.Join(tmpdir, "cursor"),
+			Stop:       true,
+			Options:    Options{Boots: tt.boots},
+		}
+		events, err := GetEvents(conf, make(chan struct{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range events {
+		}
+		if got, _ := ioutil.ReadFile(argsFile); string(got) != tt.expected {
+			t.Errorf("with %d boots journalctl run with %q, expected %q", tt.boots, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package kinesis
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/honeycombio/honeytail/atomicfile"
+	"github.com/honeycombio/honeytail/awsapi"
+)
+
+// shardEnd is saved as the position of shards that have been read to the
+// end after a reshard, so they aren't read again
+const shardEnd = "SHARD_END"
+
+// store saves the position reached in each shard
+type store interface {
+	// load returns the sequence number saved for shard, or "" if none is
+	load(shard string) (string, error)
+	// save writes the sequence numbers given, by shard
+	save(positions map[string]string) error
+}
+
+// checkpoints keeps the position reached in each shard, saving changes to
+// a store when asked
+type checkpoints struct {
+	store store
+
+	lock      sync.Mutex
+	positions map[string]string
+	dirty     map[string]bool
+}
+
+func newCheckpoints(s store) *checkpoints {
+	return &checkpoints{
+		store:     s,
+		positions: make(map[string]string),
+		dirty:     make(map[string]bool),
+	}
+}
+
+// get returns the sequence number last read in shard, or "" if it hasn't
+// been read before
+func (c *checkpoints) get(shard string) (string, error) {
+	c.lock.Lock()
+	seq, ok := c.positions[shard]
+	c.lock.Unlock()
+	if ok {
+		return seq, nil
+	}
+	seq, err := c.store.load(shard)
+	if err != nil {
+		return "", err
+	}
+	c.lock.Lock()
+	if _, ok := c.positions[shard]; !ok {
+		c.positions[shard] = seq
+	}
+	c.lock.Unlock()
+	return seq, nil
+}
+
+// set records seq as the sequence number last read in shard
+func (c *checkpoints) set(shard string, seq string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.positions[shard] = seq
+	c.dirty[shard] = true
+}
+
+// save writes the positions changed since the last save to the store
+func (c *checkpoints) save() error {
+	c.lock.Lock()
+	changed := make(map[string]string, len(c.dirty))
+	for shard := range c.dirty {
+		changed[shard] = c.positions[shard]
+	}
+	c.dirty = make(map[string]bool)
+	c.lock.Unlock()
+	if len(changed) == 0 {
+		return nil
+	}
+	if err := c.store.save(changed); err != nil {
+		// try again next time
+		c.lock.Lock()
+		for shard := range changed {
+			c.dirty[shard] = true
+		}
+		c.lock.Unlock()
+		return err
+	}
+	return nil
+}
+
+// fileStore keeps the positions of a stream's shards in a local JSON file
+type fileStore struct {
+	path      string
+	positions map[string]string
+}
+
+// newFileStore reads the positions saved in the file at path, if it exists
+func newFileStore(path string) (*fileStore, error) {
+	s := &fileStore{path: path, positions: make(map[string]string)}
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(contents, &s.positions); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileStore) load(shard string) (string, error) {
+	return s.positions[shard], nil
+}
+
+func (s *fileStore) save(positions map[string]string) error {
+	for shard, seq := range positions {
+		s.positions[shard] = seq
+	}
+	contents, err := json.Marshal(s.positions)
+	if err != nil {
+		return err
+	}
+	return atomicfile.Write(s.path, contents)
+}
+
+// dynamoStore keeps the positions of a stream's shards in a DynamoDB table
+// whose partition key is a string named shard_id. Items are keyed by the
+// stream and shard, so streams can share a table.
+type dynamoStore struct {
+	client *awsapi.Client
+	table  string
+	stream string
+}
+
+// dynamoItem is an item in a DynamoDB request or response, with string
+// attributes
+type dynamoItem map[string]map[string]string
+
+func (s *dynamoStore) key(shard string) dynamoItem {
+	return dynamoItem{"shard_id": {"S": s.stream + "/" + shard}}
+}
+
+func (s *dynamoStore) load(shard string) (string, error) {
+	var out struct {
+		Item dynamoItem
+	}
+	err := s.client.Call("GetItem", map[string]interface{}{
+		"TableName":      s.table,
+		"Key":            s.key(shard),
+		"ConsistentRead": true,
+	}, &out)
+	if err != nil {
+		return "", err
+	}
+	return out.Item["sequence_number"]["S"], nil
+}
+
+func (s *dynamoStore) save(positions map[string]string) error {
+	for shard, seq := range positions {
+		item := s.key(shard)
+		item["sequence_number"] = map[string]string{"S": seq}
+		err := s.client.Call("PutItem", map[string]interface{}{
+			"TableName": s.table,
+			"Item":      item,
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package kinesis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+)
+
+// gzipMagic starts gzipped records, such as those from CloudWatch Logs
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// cloudWatchRecord is what a CloudWatch Logs subscription filter writes to
+// a stream, gzipped. Each record holds a batch of log events.
+type cloudWatchRecord struct {
+	MessageType string `json:"messageType"`
+	LogGroup    string `json:"logGroup"`
+	LogStream   string `json:"logStream"`
+	LogEvents   []struct {
+		ID        string `json:"id"`
+		Timestamp int64  `json:"timestamp"`
+		Message   string `json:"message"`
+	} `json:"logEvents"`
+}
+
+// recordLines returns the lines in a record's data. Gzipped records are
+// decompressed, and those from CloudWatch Logs subscriptions are unwrapped
+// into the messages they carry; their control messages, sent to check the
+// stream can be written to, have none. Other JSON records are a line each,
+// and anything else is split on newlines.
+func recordLines(data []byte) []string {
+	if bytes.HasPrefix(data, gzipMagic) {
+		if gz, err := gzip.NewReader(bytes.NewReader(data)); err == nil {
+			if unzipped, err := ioutil.ReadAll(gz); err == nil {
+				data = unzipped
+			}
+		}
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) != 0 && trimmed[0] == '{' {
+		var cw cloudWatchRecord
+		if err := json.Unmarshal(trimmed, &cw); err == nil && cw.MessageType != "" {
+			if cw.MessageType != "DATA_MESSAGE" {
+				return nil
+			}
+			lines := make([]string, 0, len(cw.LogEvents))
+			for _, ev := range cw.LogEvents {
+				lines = append(lines, strings.TrimRight(ev.Message, "\r\n"))
+			}
+			return lines
+		}
+		// other JSON documents are one line, however they're laid out
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, trimmed); err == nil {
+			return []string{buf.String()}
+		}
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimRight(line, "\r"); strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
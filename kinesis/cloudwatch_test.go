@@ -0,0 +1,41 @@
+package kinesis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"testing"
+)
+
+func gzipped(s string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(s))
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestRecordLines(t *testing.T) {
+	tests := []struct {
+		data     []byte
+		expected []string
+	}{
+		{[]byte("plain line"), []string{"plain line"}},
+		{[]byte("first\r\n\nsecond\n"), []string{"first", "second"}},
+		{[]byte(`{ "a": 1,
+  "b": "two" }`), []string{`{"a":1,"b":"two"}`}},
+		{[]byte(`{"a":1}` + "\n" + `{"a":2}`), []string{`{"a":1}`, `{"a":2}`}},
+		{gzipped("zipped\nlines"), []string{"zipped", "lines"}},
+		{gzipped(`{"messageType":"DATA_MESSAGE","logGroup":"/app","logStream":"i-123",` +
+			`"logEvents":[{"id":"1","timestamp":1500000000000,"message":"GET / 200\n"},` +
+			`{"id":"2","timestamp":1500000000001,"message":"{\"level\":\"info\"}"}]}`),
+			[]string{"GET / 200", `{"level":"info"}`}},
+		{gzipped(`{"messageType":"CONTROL_MESSAGE","logGroup":"","logStream":"","logEvents":[]}`), nil},
+		{[]byte{}, nil},
+	}
+	for _, tt := range tests {
+		if got := recordLines(tt.data); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("recordLines(%q) = %q, expected %q", tt.data, got, tt.expected)
+		}
+	}
+}
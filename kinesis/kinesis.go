@@ -0,0 +1,412 @@
+// Package kinesis reads records from an AWS Kinesis Data Stream.
+//
+// Like the tail and listen packages, kinesis provides a channel on which
+// lines are sent as strings, to be handed to the configured parser. Every
+// shard of the stream is read, following reshards: a shard's children are
+// read once it has been read to its end. The position reached in each shard
+// is checkpointed to a local statefile, or to a DynamoDB table so it
+// survives the host, and reading carries on from it after a restart.
+//
+// Records written by CloudWatch Logs subscription filters are gzipped
+// batches of log events; they're decompressed and each event's message is
+// sent as a line.
+package kinesis
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/awsapi"
+	"github.com/honeycombio/honeytail/tail"
+)
+
+type Options struct {
+	Region           string `long:"region" description:"AWS region of the stream. Defaults to the AWS_REGION or AWS_DEFAULT_REGION environment variable"`
+	Profile          string `long:"profile" description:"Profile in the AWS shared credentials file to use when AWS_ACCESS_KEY_ID isn't set. Defaults to AWS_PROFILE, or else default"`
+	Endpoint         string `long:"endpoint" description:"URL of the Kinesis API, to use a VPC endpoint or a local emulator"`
+	StateFile        string `long:"statefile" description:"File in which to keep the position reached in each shard. Defaults to honeytail.kinesis.<stream>.state in the system temp directory. Not used if --kinesis.dynamodb_table is set"`
+	DynamoDBTable    string `long:"dynamodb_table" description:"DynamoDB table in which to keep the position reached in each shard instead of a statefile, so another host can carry on from it. The table's partition key must be a string named shard_id"`
+	DynamoDBEndpoint string `long:"dynamodb_endpoint" description:"URL of the DynamoDB API, to use a VPC endpoint or a local emulator"`
+}
+
+type Config struct {
+	// Stream is the name of the stream to read
+	Stream string
+	// Kinesis specific options
+	Options Options
+	// ReadFrom is where to start reading shards without a checkpoint, as for
+	// --tail.read_from: beginning starts at the oldest record kept, and end
+	// and last at the newest
+	ReadFrom string
+	// Stop stops once every shard has been read up to its newest record,
+	// rather than waiting for more
+	Stop bool
+}
+
+var (
+	// checkpointInterval is how often positions are saved
+	checkpointInterval = 5 * time.Second
+	// listInterval is how often the stream's shards are listed, to find
+	// those added by a reshard
+	listInterval = time.Minute
+	// idleWait is how long to wait after GetRecords returns nothing new.
+	// busyWait keeps readers under the limit of five calls per second per
+	// shard. retryWait is the longest to wait after an error.
+	idleWait  = time.Second
+	busyWait  = 200 * time.Millisecond
+	retryWait = 10 * time.Second
+)
+
+// shard is a shard in a ListShards response
+type shard struct {
+	ShardId               string
+	ParentShardId         string
+	AdjacentParentShardId string
+	SequenceNumberRange   struct {
+		StartingSequenceNumber string
+		EndingSequenceNumber   string
+	}
+}
+
+// closed returns true if the shard has been split or merged, so no more
+// records will be written to it
+func (s shard) closed() bool {
+	return s.SequenceNumberRange.EndingSequenceNumber != ""
+}
+
+// record is a record in a GetRecords response. Its data is base64 encoded,
+// which the JSON decoder undoes.
+type record struct {
+	Data           []byte
+	SequenceNumber string
+}
+
+// consumer reads the shards of a stream
+type consumer struct {
+	conf        Config
+	kinesis     *awsapi.Client
+	checkpoints *checkpoints
+	lines       chan string
+	abort       <-chan struct{}
+}
+
+// GetSampledEntries wraps GetEntries and returns a list of channels that
+// provide sampled entries
+func GetSampledEntries(conf Config, sampleRate uint, abort <-chan struct{}) ([]chan string, error) {
+	linesChans, err := GetEntries(conf, abort)
+	if err != nil {
+		return nil, err
+	}
+	return tail.SampleAll(linesChans, sampleRate), nil
+}
+
+// GetEntries starts reading the stream and returns a channel on which its
+// lines are sent. The channel is closed once abort is closed, or once the
+// stream has been read if conf.Stop is set.
+func GetEntries(conf Config, abort <-chan struct{}) ([]chan string, error) {
+	if conf.Stream == "" {
+		return nil, errors.New("no kinesis stream to read")
+	}
+	region := awsapi.Region(conf.Options.Region)
+	if region == "" {
+		return nil, errors.New("no AWS region given for the kinesis stream; set --kinesis.region or AWS_REGION")
+	}
+	creds, err := awsapi.LoadCredentials(conf.Options.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	var s store
+	if conf.Options.DynamoDBTable != "" {
+		s = &dynamoStore{
+			client: awsapi.NewClient("dynamodb", region, conf.Options.DynamoDBEndpoint,
+				"DynamoDB_20120810", "application/x-amz-json-1.0", creds),
+			table:  conf.Options.DynamoDBTable,
+			stream: conf.Stream,
+		}
+	} else {
+		path := conf.Options.StateFile
+		if path == "" {
+			path = filepath.Join(os.TempDir(), "honeytail.kinesis."+conf.Stream+".state")
+		}
+		if s, err = newFileStore(path); err != nil {
+			return nil, err
+		}
+	}
+
+	c := &consumer{
+		conf: conf,
+		kinesis: awsapi.NewClient("kinesis", region, conf.Options.Endpoint,
+			"Kinesis_20131202", "application/x-amz-json-1.1", creds),
+		checkpoints: newCheckpoints(s),
+		lines:       make(chan string),
+		abort:       abort,
+	}
+	// list the shards now, so a missing stream or bad credentials are
+	// reported at startup
+	shards, err := c.listShards()
+	if err != nil {
+		return nil, err
+	}
+	go c.run(shards)
+	return []chan string{c.lines}, nil
+}
+
+// listShards returns all the stream's shards, open and closed
+func (c *consumer) listShards() ([]shard, error) {
+	var shards []shard
+	in := map[string]interface{}{"StreamName": c.conf.Stream}
+	for {
+		var out struct {
+			Shards    []shard
+			NextToken string
+		}
+		if err := c.kinesis.Call("ListShards", in, &out); err != nil {
+			return nil, err
+		}
+		shards = append(shards, out.Shards...)
+		if out.NextToken == "" {
+			return shards, nil
+		}
+		// the stream name mustn't be given along with a token
+		in = map[string]interface{}{"NextToken": out.NextToken}
+	}
+}
+
+// run reads shards as they become ready, until abort is closed or, with
+// Stop set, there are none left to read
+func (c *consumer) run(shards []shard) {
+	defer close(c.lines)
+	running := make(map[string]bool)
+	// done holds the shards this run has stopped reading, and skipped the
+	// closed shards it passed over without reading
+	done := make(map[string]bool)
+	skipped := make(map[string]bool)
+	// the ID of each shard is sent on stopped when its reader stops
+	stopped := make(chan string)
+	saveTicker := time.NewTicker(checkpointInterval)
+	defer saveTicker.Stop()
+	listTicker := time.NewTicker(listInterval)
+	defer listTicker.Stop()
+
+	for {
+		c.startReady(shards, running, done, skipped, stopped)
+		if c.conf.Stop && len(running) == 0 {
+			break
+		}
+		select {
+		case <-c.abort:
+			for len(running) != 0 {
+				delete(running, <-stopped)
+			}
+			c.save()
+			return
+		case id := <-stopped:
+			delete(running, id)
+			done[id] = true
+		case <-saveTicker.C:
+			c.save()
+		case <-listTicker.C:
+			if c.conf.Stop {
+				continue
+			}
+			listed, err := c.listShards()
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"stream": c.conf.Stream,
+					"err":    err,
+				}).Warn("Failed to list kinesis shards, trying again later")
+				continue
+			}
+			shards = listed
+		}
+	}
+	c.save()
+}
+
+// startReady starts reading the shards not yet read whose parents, if any,
+// have been read to their end or are no longer kept. A shard without a
+// checkpoint starts at its oldest record if a parent was read, as its
+// records follow on from the parent's.
+func (c *consumer) startReady(shards []shard, running, done, skipped map[string]bool, stopped chan<- string) {
+	listed := make(map[string]bool, len(shards))
+	for _, s := range shards {
+		listed[s.ShardId] = true
+	}
+	for _, s := range shards {
+		if running[s.ShardId] || done[s.ShardId] {
+			continue
+		}
+		seq, err := c.checkpoints.get(s.ShardId)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"shard": s.ShardId,
+				"err":   err,
+			}).Warn("Failed to read kinesis checkpoint, trying again later")
+			continue
+		}
+		if seq == shardEnd {
+			continue
+		}
+		ready := true
+		followsParent := false
+		for _, parent := range []string{s.ParentShardId, s.AdjacentParentShardId} {
+			if parent == "" || !listed[parent] {
+				continue
+			}
+			parentSeq, err := c.checkpoints.get(parent)
+			if err != nil || parentSeq != shardEnd {
+				ready = false
+			} else if !skipped[parent] {
+				followsParent = true
+			}
+		}
+		if !ready {
+			continue
+		}
+
+		startAt := "TRIM_HORIZON"
+		if seq == "" && c.conf.ReadFrom != "beginning" && !followsParent {
+			// start at the newest record. There'll be no more in a
+			// closed shard, so it's skipped altogether.
+			if s.closed() {
+				c.checkpoints.set(s.ShardId, shardEnd)
+				done[s.ShardId] = true
+				skipped[s.ShardId] = true
+				continue
+			}
+			startAt = "LATEST"
+		}
+		running[s.ShardId] = true
+		go func(id, startAt string) {
+			c.readShard(id, startAt)
+			stopped <- id
+		}(s.ShardId, startAt)
+	}
+}
+
+// shardIterator returns an iterator that carries on after the shard's
+// checkpoint, or starts at startAt if it hasn't one
+func (c *consumer) shardIterator(id, startAt string) (string, error) {
+	seq, err := c.checkpoints.get(id)
+	if err != nil {
+		return "", err
+	}
+	in := map[string]interface{}{
+		"StreamName":        c.conf.Stream,
+		"ShardId":           id,
+		"ShardIteratorType": startAt,
+	}
+	if seq != "" {
+		in["ShardIteratorType"] = "AFTER_SEQUENCE_NUMBER"
+		in["StartingSequenceNumber"] = seq
+	}
+	var out struct {
+		ShardIterator string
+	}
+	if err := c.kinesis.Call("GetShardIterator", in, &out); err != nil {
+		return "", err
+	}
+	return out.ShardIterator, nil
+}
+
+// readShard sends the lines of the shard's records until abort is closed,
+// the shard has been read to its end, or with Stop set, there are no newer
+// records.
+func (c *consumer) readShard(id, startAt string) {
+	logger := logrus.WithFields(logrus.Fields{"stream": c.conf.Stream, "shard": id})
+	backoff := busyWait
+	iterator := ""
+	for {
+		if iterator == "" {
+			var err error
+			if iterator, err = c.shardIterator(id, startAt); err != nil {
+				logger.WithField("err", err).Warn("Failed to get kinesis shard iterator, trying again")
+				if !c.wait(retryWait) {
+					return
+				}
+				continue
+			}
+		}
+		var out struct {
+			Records            []record
+			NextShardIterator  string
+			MillisBehindLatest int64
+		}
+		err := c.kinesis.Call("GetRecords", map[string]interface{}{
+			"ShardIterator": iterator,
+		}, &out)
+		switch {
+		case awsapi.IsAPIError(err, "ExpiredIteratorException"):
+			iterator = ""
+			continue
+		case awsapi.IsAPIError(err, "ProvisionedThroughputExceededException"):
+			// other readers of the stream are taking its throughput
+			if backoff *= 2; backoff > retryWait {
+				backoff = retryWait
+			}
+			if !c.wait(backoff) {
+				return
+			}
+			continue
+		case err != nil:
+			logger.WithField("err", err).Warn("Failed to get kinesis records, trying again")
+			if !c.wait(retryWait) {
+				return
+			}
+			continue
+		}
+		backoff = busyWait
+
+		for _, rec := range out.Records {
+			for _, line := range recordLines(rec.Data) {
+				select {
+				case c.lines <- line:
+				case <-c.abort:
+					return
+				}
+			}
+			c.checkpoints.set(id, rec.SequenceNumber)
+		}
+		if out.NextShardIterator == "" {
+			logger.Info("Finished reading kinesis shard closed by a reshard")
+			c.checkpoints.set(id, shardEnd)
+			return
+		}
+		iterator = out.NextShardIterator
+		caughtUp := len(out.Records) == 0 && out.MillisBehindLatest == 0
+		if caughtUp && c.conf.Stop {
+			return
+		}
+		wait := busyWait
+		if len(out.Records) == 0 {
+			wait = idleWait
+		}
+		if !c.wait(wait) {
+			return
+		}
+	}
+}
+
+// wait waits for d, returning false if abort is closed first
+func (c *consumer) wait(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-c.abort:
+		return false
+	}
+}
+
+func (c *consumer) save() {
+	if err := c.checkpoints.save(); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"stream": c.conf.Stream,
+			"err":    err,
+		}).Warn("Failed to save kinesis checkpoints")
+	}
+}
@@ -0,0 +1,186 @@
+package kinesis
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/awsapi"
+)
+
+// fakeStream serves the parts of the Kinesis API the consumer uses. Shard
+// iterators are the shard's ID and the index of the next record, and
+// sequence numbers the shard's ID and the record's index.
+type fakeStream struct {
+	shards  []shard
+	records map[string][][]byte
+
+	lock sync.Mutex
+	// iteratorTypes records the iterator type asked for, by shard
+	iteratorTypes map[string]string
+}
+
+func (f *fakeStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDTEST/") {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"__type":"UnrecognizedClientException","message":"bad signature"}`)
+		return
+	}
+	var in map[string]string
+	json.NewDecoder(r.Body).Decode(&in)
+	var out interface{}
+	switch r.Header.Get("X-Amz-Target") {
+	case "Kinesis_20131202.ListShards":
+		if in["StreamName"] != "test" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type":"com.amazonaws.kinesis#ResourceNotFoundException","message":"no stream"}`)
+			return
+		}
+		out = map[string]interface{}{"Shards": f.shards}
+	case "Kinesis_20131202.GetShardIterator":
+		id := in["ShardId"]
+		f.lock.Lock()
+		f.iteratorTypes[id] = in["ShardIteratorType"]
+		f.lock.Unlock()
+		next := 0
+		switch in["ShardIteratorType"] {
+		case "LATEST":
+			next = len(f.records[id])
+		case "AFTER_SEQUENCE_NUMBER":
+			seq := strings.TrimPrefix(in["StartingSequenceNumber"], id+"/")
+			n, _ := strconv.Atoi(seq)
+			next = n + 1
+		}
+		out = map[string]string{"ShardIterator": id + ":" + strconv.Itoa(next)}
+	case "Kinesis_20131202.GetRecords":
+		parts := strings.SplitN(in["ShardIterator"], ":", 2)
+		id := parts[0]
+		next, _ := strconv.Atoi(parts[1])
+		var records []record
+		for i := next; i < len(f.records[id]); i++ {
+			records = append(records, record{
+				Data:           f.records[id][i],
+				SequenceNumber: id + "/" + strconv.Itoa(i),
+			})
+		}
+		nextIterator := id + ":" + strconv.Itoa(len(f.records[id]))
+		for _, s := range f.shards {
+			if s.ShardId == id && s.closed() && len(records) == 0 {
+				nextIterator = ""
+			}
+		}
+		out = map[string]interface{}{
+			"Records":            records,
+			"NextShardIterator":  nextIterator,
+			"MillisBehindLatest": 0,
+		}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"__type":"UnknownOperationException"}`)
+		return
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+func TestGetEntries(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	defer func(idle, busy time.Duration) { idleWait, busyWait = idle, busy }(idleWait, busyWait)
+	idleWait, busyWait = time.Millisecond, time.Millisecond
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	// shard-0 was split into shard-1 and shard-2
+	shards := make([]shard, 3)
+	for i := range shards {
+		shards[i].ShardId = "shard-" + strconv.Itoa(i)
+		if i != 0 {
+			shards[i].ParentShardId = "shard-0"
+		}
+	}
+	shards[0].SequenceNumberRange.EndingSequenceNumber = "shard-0/1"
+	fake := &fakeStream{
+		shards: shards,
+		records: map[string][][]byte{
+			"shard-0": {[]byte("before split"), []byte("also before split")},
+			"shard-1": {gzipped(`{"messageType":"DATA_MESSAGE","logEvents":[{"message":"from cloudwatch"}]}`)},
+			"shard-2": {},
+		},
+		iteratorTypes: make(map[string]string),
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "kinesis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := Config{
+		Stream: "test",
+		Options: Options{
+			Region:    "us-east-1",
+			Endpoint:  server.URL,
+			StateFile: filepath.Join(dir, "state"),
+		},
+		ReadFrom: "beginning",
+		Stop:     true,
+	}
+	read := func() []string {
+		chans, err := GetEntries(conf, make(chan struct{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		for line := range chans[0] {
+			lines = append(lines, line)
+		}
+		return lines
+	}
+
+	// the parent's records come before its children's
+	expected := []string{"before split", "also before split", "from cloudwatch"}
+	if lines := read(); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("read %q, expected %q", lines, expected)
+	}
+	contents, _ := ioutil.ReadFile(conf.Options.StateFile)
+	var state map[string]string
+	json.Unmarshal(contents, &state)
+	if expectedState := map[string]string{"shard-0": shardEnd, "shard-1": "shard-1/0"}; !reflect.DeepEqual(state, expectedState) {
+		t.Errorf("saved state %q, expected %q", state, expectedState)
+	}
+
+	// a restart carries on from the checkpoints
+	fake.records["shard-1"] = append(fake.records["shard-1"], []byte("after restart"))
+	fake.records["shard-2"] = append(fake.records["shard-2"], []byte("new shard"))
+	conf.ReadFrom = "last"
+	fake.iteratorTypes = make(map[string]string)
+	lines := read()
+	if expected := []string{"after restart", "new shard"}; len(lines) != 2 ||
+		!(reflect.DeepEqual(lines, expected) || reflect.DeepEqual(lines, []string{expected[1], expected[0]})) {
+		t.Errorf("read %q after restarting, expected %q", lines, expected)
+	}
+	// shard-2 has no checkpoint, but its parent was read to its end
+	expectedTypes := map[string]string{"shard-1": "AFTER_SEQUENCE_NUMBER", "shard-2": "TRIM_HORIZON"}
+	if !reflect.DeepEqual(fake.iteratorTypes, expectedTypes) {
+		t.Errorf("got iterator types %q, expected %q", fake.iteratorTypes, expectedTypes)
+	}
+
+	conf.Stream = "missing"
+	if _, err := GetEntries(conf, make(chan struct{})); !awsapi.IsAPIError(err, "ResourceNotFoundException") {
+		t.Errorf("got %v for a missing stream, expected ResourceNotFoundException", err)
+	}
+}
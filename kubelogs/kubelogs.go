@@ -0,0 +1,613 @@
+// Package kubelogs streams the logs of Kubernetes pods through the API
+// server, so they can be read from anywhere with access to the cluster
+// rather than from each node's /var/log/containers.
+//
+// Pods are chosen by namespace and label selector, and watched so that
+// pods created later, and containers restarted, have their logs read too.
+// Each container's lines are sent on a channel of their own, alongside
+// fields describing the pod, to be added to its events. The time of the
+// last line read from each container is kept in a statefile, so
+// --tail.read_from=last carries on from there when honeytail restarts.
+package kubelogs
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/atomicfile"
+	"github.com/honeycombio/honeytail/httpapi"
+)
+
+type Options struct {
+	Selector  string `long:"selector" description:"Label selector choosing the pods whose logs to read, eg app=web,tier!=canary. Defaults to every pod in the namespace"`
+	APIServer string `long:"api_server" description:"URL of the Kubernetes API server. Defaults to the one honeytail runs in the cluster of, from KUBERNETES_SERVICE_HOST. Use http://127.0.0.1:8001 with kubectl proxy to read from outside the cluster"`
+	TokenFile string `long:"token_file" description:"File holding the bearer token to authorize requests with. Defaults to the pod's service account token, if there is one"`
+	CAFile    string `long:"ca_file" description:"CA certificate to verify the API server with. Defaults to the pod's service account CA, if there is one"`
+	StateFile string `long:"statefile" description:"File keeping the time of the last line read from each container, for --tail.read_from=last. Defaults to honeytail.kubernetes.state in the system temp directory"`
+}
+
+type Config struct {
+	// Namespace holds the pods to read, or all namespaces if it's *
+	Namespace string
+	// Kubernetes specific options
+	Options Options
+	// ReadFrom is where to start reading the containers running at startup,
+	// as for --tail.read_from: beginning, end or last. Last carries on after
+	// the last line read from a container if it's in the statefile, or
+	// starts at the end. Containers started later are read from the start.
+	ReadFrom string
+	// Stop stops reading once the containers running at startup have had
+	// their logs read, rather than following them and watching for more
+	Stop bool
+}
+
+// Container is a container whose logs are being read
+type Container struct {
+	// Lines receives each line the container logs. It's closed when the
+	// container stops.
+	Lines chan string
+	// Fields describe the container and its pod, to add to each of its
+	// events
+	Fields map[string]interface{}
+}
+
+var (
+	// saveInterval is how often the statefile is written
+	saveInterval = 5 * time.Second
+	// retryWait is how long to wait after failing to list or watch pods,
+	// or losing a container's logs
+	retryWait = 10 * time.Second
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// pod holds the fields of a pod that are used
+type pod struct {
+	Metadata struct {
+		Name      string
+		Namespace string
+		UID       string
+		Labels    map[string]string
+	}
+	Spec struct {
+		NodeName string
+	}
+	Status struct {
+		ContainerStatuses []containerStatus
+	}
+}
+
+type containerStatus struct {
+	Name        string
+	Image       string
+	ContainerID string
+	State       struct {
+		Running *struct{}
+	}
+}
+
+// reader reads the logs of the pods matching its namespace and selector
+type reader struct {
+	conf       Config
+	endpoint   string
+	tokenFile  string
+	http       *http.Client
+	containers chan Container
+	abort      <-chan struct{}
+	ctx        context.Context
+	wg         sync.WaitGroup
+
+	lock sync.Mutex
+	// streaming are the IDs of the containers whose logs are being read,
+	// and finished those read until they stopped
+	streaming map[string]bool
+	finished  map[string]bool
+	// lastRead holds when the last line read from each container was
+	// logged, and dirty whether it's changed since it was saved
+	lastRead map[string]time.Time
+	dirty    bool
+}
+
+// GetContainers connects to the API server and returns the channel on
+// which each container of the pods chosen is sent as its logs start being
+// read. The channel is closed once abort is closed, or once the containers
+// running at startup have been read if conf.Stop is set.
+func GetContainers(conf Config, abort <-chan struct{}) (chan Container, error) {
+	if conf.Namespace == "" {
+		return nil, errors.New("no Kubernetes namespace given; use * for all of them")
+	}
+	opts := conf.Options
+	if opts.APIServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("not running in a Kubernetes cluster; set --kubernetes.api_server")
+		}
+		if strings.Contains(host, ":") {
+			// IPv6
+			host = "[" + host + "]"
+		}
+		opts.APIServer = "https://" + host + ":" + port
+	}
+	if opts.TokenFile == "" {
+		if _, err := os.Stat(filepath.Join(serviceAccountDir, "token")); err == nil {
+			opts.TokenFile = filepath.Join(serviceAccountDir, "token")
+		}
+	}
+	if opts.CAFile == "" {
+		if _, err := os.Stat(filepath.Join(serviceAccountDir, "ca.crt")); err == nil {
+			opts.CAFile = filepath.Join(serviceAccountDir, "ca.crt")
+		}
+	}
+	if opts.StateFile == "" {
+		opts.StateFile = filepath.Join(os.TempDir(), "honeytail.kubernetes.state")
+	}
+	conf.Options = opts
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if opts.CAFile != "" {
+		ca, err := ioutil.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no CA certificates found in %s", opts.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-abort
+		cancel()
+	}()
+	r := &reader{
+		conf:       conf,
+		endpoint:   strings.TrimRight(opts.APIServer, "/"),
+		tokenFile:  opts.TokenFile,
+		http:       &http.Client{Transport: transport},
+		containers: make(chan Container),
+		abort:      abort,
+		ctx:        ctx,
+		streaming:  make(map[string]bool),
+		finished:   make(map[string]bool),
+		lastRead:   readState(opts.StateFile),
+	}
+	// list the pods now, so a bad selector or lack of permission to read
+	// them is reported at startup
+	pods, version, err := r.list()
+	if err != nil {
+		return nil, err
+	}
+	go r.run(pods, version)
+	return r.containers, nil
+}
+
+// get sends a GET request for path with query to the API server, with the
+// service account token if there is one. The caller must close the response
+// body.
+func (r *reader) get(path string, query url.Values) (*http.Response, error) {
+	header := http.Header{}
+	if r.tokenFile != "" {
+		// service account tokens are rotated, so read it every time
+		token, err := ioutil.ReadFile(r.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	return httpapi.Get(r.ctx, r.http, "Kubernetes", r.endpoint+path+"?"+query.Encode(), header)
+}
+
+// podsPath is the path of the pods chosen
+func (r *reader) podsPath() string {
+	if r.conf.Namespace == "*" {
+		return "/api/v1/pods"
+	}
+	return "/api/v1/namespaces/" + url.PathEscape(r.conf.Namespace) + "/pods"
+}
+
+// list returns the pods chosen, and the resource version to watch them
+// from
+func (r *reader) list() ([]pod, string, error) {
+	query := url.Values{}
+	if r.conf.Options.Selector != "" {
+		query.Set("labelSelector", r.conf.Options.Selector)
+	}
+	resp, err := r.get(r.podsPath(), query)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	var list struct {
+		Metadata struct {
+			ResourceVersion string
+		}
+		Items []pod
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, "", err
+	}
+	return list.Items, list.Metadata.ResourceVersion, nil
+}
+
+// run reads the containers of pods, listed at version, and unless
+// conf.Stop is set, watches for more until abort is closed
+func (r *reader) run(pods []pod, version string) {
+	defer close(r.containers)
+	defer r.saveState()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(saveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.saveState()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	r.startListed(pods, true)
+	if r.conf.Stop {
+		r.wg.Wait()
+		return
+	}
+	for {
+		err := r.watch(version)
+		select {
+		case <-r.abort:
+			r.wg.Wait()
+			return
+		default:
+		}
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"err": err}).Warn(
+				"Failed to watch Kubernetes pods, listing them again")
+			select {
+			case <-time.After(retryWait):
+			case <-r.abort:
+				r.wg.Wait()
+				return
+			}
+		}
+		// pick up anything missed while not watching
+		var err2 error
+		pods, version, err2 = r.list()
+		if err2 != nil {
+			logrus.WithFields(logrus.Fields{"err": err2}).Warn(
+				"Failed to list Kubernetes pods")
+			continue
+		}
+		r.startListed(pods, false)
+	}
+}
+
+// startListed starts reading the running containers of pods, forgetting
+// about stopped containers that are no longer listed. Those running at
+// startup are read from where conf.ReadFrom says.
+func (r *reader) startListed(pods []pod, startup bool) {
+	listed := make(map[string]bool)
+	for _, p := range pods {
+		for _, cs := range p.Status.ContainerStatuses {
+			listed[cs.ContainerID] = true
+		}
+	}
+	r.lock.Lock()
+	for id := range r.finished {
+		if !listed[id] {
+			delete(r.finished, id)
+		}
+	}
+	r.lock.Unlock()
+	for _, p := range pods {
+		r.startPod(p, startup)
+	}
+}
+
+// watch starts reading the containers of pods as they start, from version
+// on, until the watch ends or abort is closed
+func (r *reader) watch(version string) error {
+	query := url.Values{
+		"watch":           {"1"},
+		"resourceVersion": {version},
+	}
+	if r.conf.Options.Selector != "" {
+		query.Set("labelSelector", r.conf.Options.Selector)
+	}
+	resp, err := r.get(r.podsPath(), query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev struct {
+			Type   string
+			Object json.RawMessage
+		}
+		if err := dec.Decode(&ev); err != nil {
+			if err == io.EOF {
+				// the server ends watches after a while
+				return nil
+			}
+			return err
+		}
+		switch ev.Type {
+		case "ADDED", "MODIFIED":
+			var p pod
+			if err := json.Unmarshal(ev.Object, &p); err != nil {
+				return err
+			}
+			r.startPod(p, false)
+		case "ERROR":
+			var status struct {
+				Message string
+			}
+			json.Unmarshal(ev.Object, &status)
+			return errors.New(status.Message)
+		}
+	}
+}
+
+// startPod starts reading the running containers of p that aren't being
+// read already
+func (r *reader) startPod(p pod, startup bool) {
+	for _, cs := range p.Status.ContainerStatuses {
+		if cs.State.Running == nil || cs.ContainerID == "" {
+			continue
+		}
+		r.lock.Lock()
+		if r.streaming[cs.ContainerID] || r.finished[cs.ContainerID] {
+			r.lock.Unlock()
+			continue
+		}
+		r.streaming[cs.ContainerID] = true
+		last, haveLast := r.lastRead[cs.ContainerID]
+		r.lock.Unlock()
+
+		// containers that start later are read from their first line
+		from := position{}
+		if startup {
+			switch r.conf.ReadFrom {
+			case "beginning":
+			case "last":
+				if haveLast {
+					from.after = last
+				} else {
+					from.end = true
+				}
+			default:
+				from.end = true
+			}
+		}
+		c := Container{
+			Lines: make(chan string),
+			Fields: map[string]interface{}{
+				"pod_name":        p.Metadata.Name,
+				"pod_namespace":   p.Metadata.Namespace,
+				"pod_uid":         p.Metadata.UID,
+				"pod_node":        p.Spec.NodeName,
+				"container_name":  cs.Name,
+				"container_image": cs.Image,
+			},
+		}
+		for k, v := range p.Metadata.Labels {
+			c.Fields["pod_label."+k] = v
+		}
+		select {
+		case r.containers <- c:
+		case <-r.abort:
+			return
+		}
+		logrus.WithFields(logrus.Fields{
+			"pod":       p.Metadata.Namespace + "/" + p.Metadata.Name,
+			"container": cs.Name,
+		}).Info("Reading Kubernetes container logs")
+		r.wg.Add(1)
+		go func(p pod, cs containerStatus) {
+			defer r.wg.Done()
+			defer close(c.Lines)
+			r.read(p, cs, from, c.Lines)
+			r.lock.Lock()
+			delete(r.streaming, cs.ContainerID)
+			r.finished[cs.ContainerID] = true
+			r.lock.Unlock()
+		}(p, cs)
+	}
+}
+
+// position is where to start reading a container's logs
+type position struct {
+	// end starts with the lines logged from now on
+	end bool
+	// after starts with the lines logged after this time
+	after time.Time
+}
+
+// read sends the lines container cs of p logs from where, reconnecting if
+// the stream ends while it's still running, until it stops or abort is
+// closed, or until it's caught up if conf.Stop is set
+func (r *reader) read(p pod, cs containerStatus, from position, lines chan<- string) {
+	logger := logrus.WithFields(logrus.Fields{
+		"pod":       p.Metadata.Namespace + "/" + p.Metadata.Name,
+		"container": cs.Name,
+	})
+	started := time.Now()
+	for {
+		err := r.stream(p, cs, from, lines)
+		select {
+		case <-r.abort:
+			return
+		default:
+		}
+		if r.conf.Stop {
+			if err != nil {
+				logger.WithFields(logrus.Fields{"err": err}).Warn("Failed to read Kubernetes container logs")
+			}
+			return
+		}
+		// the stream ends when the container stops, but also when the
+		// connection to the kubelet is lost
+		running, rerr := r.stillRunning(p, cs.ContainerID)
+		if rerr == nil && !running {
+			return
+		}
+		logger.WithFields(logrus.Fields{"err": err}).Warn(
+			"Lost Kubernetes container logs, reading them again")
+		select {
+		case <-time.After(retryWait):
+		case <-r.abort:
+			return
+		}
+		// carry on after the last line read
+		r.lock.Lock()
+		if last, ok := r.lastRead[cs.ContainerID]; ok && last.After(from.after) {
+			from.after = last
+		} else if from.end {
+			from.after = started
+		}
+		r.lock.Unlock()
+		from.end = false
+	}
+}
+
+// stream sends the lines container cs of p has logged since from, until
+// the stream ends
+func (r *reader) stream(p pod, cs containerStatus, from position, lines chan<- string) error {
+	query := url.Values{
+		"container":  {cs.Name},
+		"timestamps": {"true"},
+	}
+	if !r.conf.Stop {
+		query.Set("follow", "true")
+	}
+	if from.end {
+		query.Set("tailLines", "0")
+	} else if !from.after.IsZero() {
+		// sinceTime is only to the second, so lines from earlier in that
+		// second are skipped below
+		query.Set("sinceTime", from.after.UTC().Truncate(time.Second).Format(time.RFC3339))
+	}
+	resp, err := r.get("/api/v1/namespaces/"+url.PathEscape(p.Metadata.Namespace)+
+		"/pods/"+url.PathEscape(p.Metadata.Name)+"/log", query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// each line starts with when it was logged
+		if i := strings.IndexByte(line, ' '); i > 0 {
+			if at, err := time.Parse(time.RFC3339Nano, line[:i]); err == nil {
+				if !from.after.IsZero() && !at.After(from.after) {
+					continue
+				}
+				line = line[i+1:]
+				r.lock.Lock()
+				r.lastRead[cs.ContainerID] = at
+				r.dirty = true
+				r.lock.Unlock()
+			}
+		}
+		select {
+		case lines <- line:
+		case <-r.abort:
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// stillRunning returns whether container id of p is still running
+func (r *reader) stillRunning(p pod, id string) (bool, error) {
+	resp, err := r.get("/api/v1/namespaces/"+url.PathEscape(p.Metadata.Namespace)+
+		"/pods/"+url.PathEscape(p.Metadata.Name), nil)
+	if e, ok := err.(*httpapi.Error); ok && e.Code == http.StatusNotFound {
+		// the pod's been deleted
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	var current pod
+	if err := json.NewDecoder(resp.Body).Decode(&current); err != nil {
+		return false, err
+	}
+	for _, cs := range current.Status.ContainerStatuses {
+		if cs.ContainerID == id {
+			return cs.State.Running != nil, nil
+		}
+	}
+	return false, nil
+}
+
+// readState returns the times of the last lines read from each container
+func readState(path string) map[string]time.Time {
+	state := make(map[string]time.Time)
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.WithFields(logrus.Fields{
+				"file": path,
+				"err":  err,
+			}).Warn("Failed to read the Kubernetes statefile")
+		}
+		return state
+	}
+	if err := json.Unmarshal(contents, &state); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"file": path,
+			"err":  err,
+		}).Warn("Failed to read the Kubernetes statefile")
+	}
+	return state
+}
+
+// saveState writes the times of the last lines read from each container to
+// the statefile, if they've changed, dropping those of containers that
+// haven't been seen since honeytail started, which have stopped for good
+func (r *reader) saveState() {
+	r.lock.Lock()
+	if !r.dirty {
+		r.lock.Unlock()
+		return
+	}
+	for id := range r.lastRead {
+		if !r.streaming[id] && !r.finished[id] {
+			delete(r.lastRead, id)
+		}
+	}
+	contents, err := json.Marshal(r.lastRead)
+	r.dirty = false
+	r.lock.Unlock()
+	if err == nil {
+		err = atomicfile.Write(r.conf.Options.StateFile, contents)
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"file": r.conf.Options.StateFile,
+			"err":  err,
+		}).Warn("Failed to save the Kubernetes statefile")
+	}
+}
@@ -0,0 +1,226 @@
+package kubelogs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// fakeCluster serves pods in the shop namespace, whose containers log a
+// line or two each. Pods in watched are sent to watches; those not in
+// running have stopped.
+type fakeCluster struct {
+	t       *testing.T
+	lock    sync.Mutex
+	listed  []string
+	watched []string
+	running map[string]bool
+	queries []string
+}
+
+func podJSON(name, containerID string, running bool) map[string]interface{} {
+	state := map[string]interface{}{"terminated": map[string]interface{}{}}
+	if running {
+		state = map[string]interface{}{"running": map[string]interface{}{}}
+	}
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "shop",
+			"uid":       "uid-" + name,
+			"labels":    map[string]string{"app": "shop"},
+		},
+		"spec": map[string]interface{}{"nodeName": "node-1"},
+		"status": map[string]interface{}{
+			"containerStatuses": []map[string]interface{}{{
+				"name":        "web",
+				"image":       "shop:1.2",
+				"containerID": containerID,
+				"state":       state,
+			}},
+		},
+	}
+}
+
+func (f *fakeCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer let-me-in" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"kind":"Status","message":"Unauthorized"}`)
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	switch r.URL.Path {
+	case "/api/v1/namespaces/shop/pods":
+		if r.URL.Query().Get("labelSelector") != "app=shop" {
+			f.t.Errorf("listed pods with selector %q", r.URL.Query().Get("labelSelector"))
+		}
+		if r.URL.Query().Get("watch") == "1" {
+			for _, name := range f.watched {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"type":   "ADDED",
+					"object": podJSON(name, "docker://"+name, true),
+				})
+			}
+			f.watched = nil
+			w.(http.Flusher).Flush()
+			f.lock.Unlock()
+			<-r.Context().Done()
+			f.lock.Lock()
+			return
+		}
+		var items []interface{}
+		for _, name := range f.listed {
+			items = append(items, podJSON(name, "docker://"+name, f.running[name]))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"metadata": map[string]string{"resourceVersion": "42"},
+			"items":    items,
+		})
+	case "/api/v1/namespaces/shop/pods/api/log", "/api/v1/namespaces/shop/pods/worker/log":
+		f.queries = append(f.queries, r.URL.RawQuery)
+		fmt.Fprint(w, "2017-01-02T03:04:05.000000001Z started\n")
+		fmt.Fprint(w, "2017-01-02T03:04:06.000000002Z GET /cart 200\n")
+	case "/api/v1/namespaces/shop/pods/worker":
+		json.NewEncoder(w).Encode(podJSON("worker", "docker://worker", false))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"kind":"Status","message":"not found"}`)
+	}
+}
+
+func setup(t *testing.T, f *fakeCluster) (string, Options, func()) {
+	logrus.SetOutput(ioutil.Discard)
+	dir, err := ioutil.TempDir("", "kubelogs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenFile := filepath.Join(dir, "token")
+	ioutil.WriteFile(tokenFile, []byte("let-me-in\n"), 0600)
+	server := httptest.NewServer(f)
+	return dir, Options{
+		Selector:  "app=shop",
+		APIServer: server.URL,
+		TokenFile: tokenFile,
+		StateFile: filepath.Join(dir, "state"),
+	}, func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestGetContainers(t *testing.T) {
+	f := &fakeCluster{t: t, listed: []string{"api"}, running: map[string]bool{"api": true}}
+	_, opts, cleanup := setup(t, f)
+	defer cleanup()
+
+	// carry on after the first line read last time
+	ioutil.WriteFile(opts.StateFile, []byte(`{"docker://api":"2017-01-02T03:04:05.000000001Z"}`), 0600)
+	containers, err := GetContainers(Config{
+		Namespace: "shop",
+		Options:   opts,
+		ReadFrom:  "last",
+		Stop:      true,
+	}, make(chan struct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []Container
+	var lines []string
+	for c := range containers {
+		got = append(got, c)
+		for line := range c.Lines {
+			lines = append(lines, line)
+		}
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d containers, expected 1", len(got))
+	}
+	expectedFields := map[string]interface{}{
+		"pod_name":        "api",
+		"pod_namespace":   "shop",
+		"pod_uid":         "uid-api",
+		"pod_node":        "node-1",
+		"container_name":  "web",
+		"container_image": "shop:1.2",
+		"pod_label.app":   "shop",
+	}
+	if !reflect.DeepEqual(got[0].Fields, expectedFields) {
+		t.Errorf("got fields %v, expected %v", got[0].Fields, expectedFields)
+	}
+	if !reflect.DeepEqual(lines, []string{"GET /cart 200"}) {
+		t.Errorf("got lines %q, expected those after the last read", lines)
+	}
+	if expected := "container=web&sinceTime=2017-01-02T03%3A04%3A05Z&timestamps=true"; f.queries[0] != expected {
+		t.Errorf("got log query %s, expected %s", f.queries[0], expected)
+	}
+	state := readState(opts.StateFile)
+	if last := state["docker://api"]; !last.Equal(time.Date(2017, 1, 2, 3, 4, 6, 2, time.UTC)) {
+		t.Errorf("got last read time %v, expected that of the last line", last)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	f := &fakeCluster{t: t, watched: []string{"worker"}}
+	_, opts, cleanup := setup(t, f)
+	defer cleanup()
+
+	abort := make(chan struct{})
+	containers, err := GetContainers(Config{
+		Namespace: "shop",
+		Options:   opts,
+		ReadFrom:  "end",
+	}, abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case c := <-containers:
+		if c.Fields["pod_name"] != "worker" {
+			t.Errorf("got container %v, expected worker's", c.Fields)
+		}
+		var lines []string
+		for line := range c.Lines {
+			lines = append(lines, line)
+		}
+		// containers started later are read from the start, until they
+		// stop
+		if !reflect.DeepEqual(lines, []string{"started", "GET /cart 200"}) {
+			t.Errorf("got lines %q", lines)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the pod created")
+	}
+	close(abort)
+	select {
+	case _, ok := <-containers:
+		if ok {
+			t.Error("got another container")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("containers not closed after aborting")
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if expected := "container=web&follow=true&timestamps=true"; f.queries[0] != expected {
+		t.Errorf("got log query %s, expected %s", f.queries[0], expected)
+	}
+}
+
+func TestNotInCluster(t *testing.T) {
+	os.Unsetenv("KUBERNETES_SERVICE_HOST")
+	_, err := GetContainers(Config{Namespace: "shop"}, nil)
+	if err == nil {
+		t.Error("expected an error with no API server to connect to")
+	}
+}
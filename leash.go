@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
@@ -19,19 +24,91 @@ import (
 	"github.com/honeycombio/libhoney-go"
 	"github.com/honeycombio/urlshaper"
 
+	"github.com/honeycombio/honeytail/dedup"
+	"github.com/honeycombio/honeytail/dockerlogs"
 	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/fieldcrypt"
+	"github.com/honeycombio/honeytail/gcs"
+	"github.com/honeycombio/honeytail/journald"
+	"github.com/honeycombio/honeytail/kinesis"
+	"github.com/honeycombio/honeytail/kubelogs"
+	"github.com/honeycombio/honeytail/listen"
+	"github.com/honeycombio/honeytail/maintenance"
 	"github.com/honeycombio/honeytail/parsers"
 	"github.com/honeycombio/honeytail/parsers/arangodb"
+	"github.com/honeycombio/honeytail/parsers/auditd"
+	"github.com/honeycombio/honeytail/parsers/authlog"
+	"github.com/honeycombio/honeytail/parsers/awselb"
+	"github.com/honeycombio/honeytail/parsers/cdc"
+	"github.com/honeycombio/honeytail/parsers/cef"
+	"github.com/honeycombio/honeytail/parsers/ceph"
+	"github.com/honeycombio/honeytail/parsers/clickhouse"
+	"github.com/honeycombio/honeytail/parsers/cloudfront"
+	"github.com/honeycombio/honeytail/parsers/cloudtrail"
+	"github.com/honeycombio/honeytail/parsers/cri"
+	"github.com/honeycombio/honeytail/parsers/dns"
+	"github.com/honeycombio/honeytail/parsers/docker"
+	"github.com/honeycombio/honeytail/parsers/exim"
+	"github.com/honeycombio/honeytail/parsers/gcplog"
+	"github.com/honeycombio/honeytail/parsers/gelf"
+	"github.com/honeycombio/honeytail/parsers/heroku"
 	"github.com/honeycombio/honeytail/parsers/htjson"
+	"github.com/honeycombio/honeytail/parsers/iis"
+	"github.com/honeycombio/honeytail/parsers/influx"
+	"github.com/honeycombio/honeytail/parsers/jvmgc"
+	"github.com/honeycombio/honeytail/parsers/k8saudit"
+	"github.com/honeycombio/honeytail/parsers/kafka"
 	"github.com/honeycombio/honeytail/parsers/keyval"
+	"github.com/honeycombio/honeytail/parsers/klog"
+	"github.com/honeycombio/honeytail/parsers/leef"
+	"github.com/honeycombio/honeytail/parsers/log4j"
 	"github.com/honeycombio/honeytail/parsers/mongodb"
+	"github.com/honeycombio/honeytail/parsers/multiline"
 	"github.com/honeycombio/honeytail/parsers/mysql"
+	"github.com/honeycombio/honeytail/parsers/mysqlaudit"
+	"github.com/honeycombio/honeytail/parsers/mysqlgeneral"
 	"github.com/honeycombio/honeytail/parsers/nginx"
+	"github.com/honeycombio/honeytail/parsers/nginxerror"
+	"github.com/honeycombio/honeytail/parsers/otlp"
+	"github.com/honeycombio/honeytail/parsers/pgbouncer"
+	"github.com/honeycombio/honeytail/parsers/pgcsvlog"
+	"github.com/honeycombio/honeytail/parsers/phpfpm"
+	"github.com/honeycombio/honeytail/parsers/postfix"
+	"github.com/honeycombio/honeytail/parsers/prometheus"
+	"github.com/honeycombio/honeytail/parsers/python"
+	"github.com/honeycombio/honeytail/parsers/rabbitmq"
+	"github.com/honeycombio/honeytail/parsers/rails"
+	"github.com/honeycombio/honeytail/parsers/s3"
+	"github.com/honeycombio/honeytail/parsers/squid"
+	"github.com/honeycombio/honeytail/parsers/statsd"
+	"github.com/honeycombio/honeytail/parsers/varnish"
+	"github.com/honeycombio/honeytail/parsers/vpcflow"
+	"github.com/honeycombio/honeytail/parsers/winevent"
+	"github.com/honeycombio/honeytail/parsers/zeek"
+	"github.com/honeycombio/honeytail/poll"
+	"github.com/honeycombio/honeytail/proxy"
+	"github.com/honeycombio/honeytail/pubsub"
+	"github.com/honeycombio/honeytail/s3backfill"
+	"github.com/honeycombio/honeytail/schedule"
+	"github.com/honeycombio/honeytail/session"
+	"github.com/honeycombio/honeytail/sqs"
+	"github.com/honeycombio/honeytail/sshtail"
 	"github.com/honeycombio/honeytail/tail"
+	"github.com/honeycombio/honeytail/throttle"
+	"github.com/honeycombio/honeytail/topk"
 )
 
+// tokenizeKeyEnv is the environment variable holding the --tokenize_field key
+// when --tokenize_key_file isn't set
+const tokenizeKeyEnv = "HONEYTAIL_TOKENIZE_KEY"
+
 // actually go and be leashy
 func run(options GlobalOptions) {
+	// remember recent logs for support bundles
+	logs := newRecentLogs(200)
+	if options.ControlSocket != "" {
+		logrus.AddHook(logs)
+	}
 	logrus.Info("Starting honeytail")
 
 	stats := newResponseStats()
@@ -59,6 +136,14 @@ func run(options GlobalOptions) {
 		// and block instead of sleeping inside sendToLibHoney.
 		PendingWorkCapacity: 20 * options.NumSenders,
 	}
+	if options.MaxBandwidth != "" {
+		rate, err := throttle.ParseBandwidth(options.MaxBandwidth)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"err": err}).Fatal(
+				"Error occurred while parsing max_bandwidth")
+		}
+		libhConfig.Transport = throttle.NewTransport(nil, rate)
+	}
 	if err := libhoney.Init(libhConfig); err != nil {
 		logrus.WithFields(logrus.Fields{"err": err}).Fatal(
 			"Error occured while spinning up Transimission")
@@ -74,20 +159,339 @@ func run(options GlobalOptions) {
 
 	// get our lines channel from which to read log lines
 	var linesChans []chan string
-	var err error
-	tc := tail.Config{
-		Paths:   options.Reqs.LogFiles,
-		Type:    tail.RotateStyleSyslog,
-		Options: options.Tail,
-	}
-	if options.TailSample {
-		linesChans, err = tail.GetSampledEntries(tc, options.SampleRate, abort)
-	} else {
-		linesChans, err = tail.GetEntries(tc, abort)
-	}
+	// the prefix to strip from the lines of some of linesChans, by index,
+	// in place of prefixRegex
+	linePrefixes := make(map[int]*parsers.ExtRegexp)
+	var dedupWindow *dedup.Window
+	var sched schedule.Schedule
+	limits, err := newBackfillLimits(options)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{"err": err}).Fatal(
-			"Error occurred while trying to tail logfile")
+			"Error occurred while parsing the backfill rate limits")
+	}
+	// files that match a glob pattern once we've started tailing are sent on
+	// newFiles
+	var newFiles chan chan string
+	if len(options.Reqs.LogFiles) != 0 || len(options.Reqs.Dirs) != 0 {
+		var err error
+		newFiles = make(chan chan string)
+		tc := tail.Config{
+			Paths:       options.Reqs.LogFiles,
+			Dirs:        options.Reqs.Dirs,
+			Recursive:   options.Recursive,
+			Exclude:     options.Exclude,
+			IgnoreOlder: time.Duration(options.IgnoreOlder) * time.Hour,
+			MaxFileSize: int64(options.MaxFileSize) * 1024 * 1024,
+			Type:        tail.RotateStyleSyslog,
+			Options:     options.Tail,
+			NewFiles:    newFiles,
+		}
+		switch options.Reqs.ParserName {
+		case "zeek", "iis", "cloudfront":
+			// the columns of each line are named by the headers at the
+			// start of its file
+			tc.HeaderPrefix = "#"
+		}
+		if options.TailSample {
+			linesChans, err = tail.GetSampledEntries(tc, options.SampleRate, abort)
+		} else {
+			linesChans, err = tail.GetEntries(tc, abort)
+		}
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"err": err}).Fatal(
+				"Error occurred while trying to tail logfile")
+		}
+		// hold off reading files outside the backfill windows
+		if len(options.BackfillWindows) != 0 {
+			sched, err = schedule.New(options.BackfillWindows)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{"err": err}).Fatal(
+					"Error occurred while parsing backfill windows")
+			}
+		}
+		// skip lines sent before a restart if they're read again
+		if options.DedupWindow != 0 {
+			path := options.DedupFile
+			if path == "" {
+				path = filepath.Join(os.TempDir(), "honeytail.dedup")
+			}
+			dedupWindow, err = dedup.New(path, time.Duration(options.DedupWindow)*time.Second)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{"err": err}).Fatal(
+					"Error occurred while reading the lines remembered from before restarting")
+			}
+			go dedupWindow.SaveEvery(time.Second, abort)
+		}
+		for i, lines := range linesChans {
+			linesChans[i] = filterFileLines(lines, sched, dedupWindow, limits, abort)
+		}
+	}
+	// and add one more channel for each address we're listening on
+	if len(options.Reqs.Listen) != 0 {
+		var listenChans []chan string
+		var err error
+		lc := listen.Config{
+			Addrs:   options.Reqs.Listen,
+			Options: options.Listen,
+		}
+		if options.TailSample {
+			listenChans, err = listen.GetSampledEntries(lc, options.SampleRate, abort)
+		} else {
+			listenChans, err = listen.GetEntries(lc, abort)
+		}
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"err": err}).Fatal(
+				"Error occurred while trying to start listener")
+		}
+		// lines from listeners that add the sender's address start with it,
+		// ahead of any prefix the sender put there
+		for i, addr := range lc.Addrs {
+			if listen.AttachesPeer(addr) {
+				linePrefixes[len(linesChans)+i] = &parsers.ExtRegexp{regexp.MustCompile(
+					listen.PeerPrefixRegex + strings.TrimPrefix(options.PrefixRegex, "^"))}
+			}
+		}
+		linesChans = append(linesChans, listenChans...)
+	}
+	// and one for the Kinesis stream we're reading, if any
+	if options.Reqs.Kinesis != "" {
+		var kinesisChans []chan string
+		var err error
+		kc := kinesis.Config{
+			Stream:   options.Reqs.Kinesis,
+			Options:  options.Kinesis,
+			ReadFrom: options.Tail.ReadFrom,
+			Stop:     options.Tail.Stop,
+		}
+		if options.TailSample {
+			kinesisChans, err = kinesis.GetSampledEntries(kc, options.SampleRate, abort)
+		} else {
+			kinesisChans, err = kinesis.GetEntries(kc, abort)
+		}
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"err": err}).Fatal(
+				"Error occurred while trying to read the kinesis stream")
+		}
+		linesChans = append(linesChans, kinesisChans...)
+	}
+	// and one for the objects an SQS queue tells us of, if any
+	if options.Reqs.SQS != "" {
+		var sqsChans []chan string
+		var err error
+		sc := sqs.Config{
+			Queue:   options.Reqs.SQS,
+			Options: options.SQS,
+		}
+		if options.TailSample {
+			sqsChans, err = sqs.GetSampledEntries(sc, options.SampleRate, abort)
+		} else {
+			sqsChans, err = sqs.GetEntries(sc, abort)
+		}
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"err": err}).Fatal(
+				"Error occurred while trying to read the SQS queue")
+		}
+		linesChans = append(linesChans, sqsChans...)
+	}
+	// and one for the S3 locations we're backfilling from, if any
+	if len(options.Reqs.S3Backfill) != 0 {
+		var s3Chans []chan string
+		var err error
+		bc := s3backfill.Config{
+			URLs:    options.Reqs.S3Backfill,
+			Options: options.S3Backfill,
+		}
+		if options.TailSample {
+			s3Chans, err = s3backfill.GetSampledEntries(bc, options.SampleRate, abort)
+		} else {
+			s3Chans, err = s3backfill.GetEntries(bc, abort)
+		}
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"err": err}).Fatal(
+				"Error occurred while trying to start the S3 backfill")
+		}
+		for i, lines := range s3Chans {
+			s3Chans[i] = limits.limit(lines, abort)
+		}
+		linesChans = append(linesChans, s3Chans...)
+	}
+	// and one for the GCS locations we're backfilling from, if any
+	if len(options.Reqs.GCSBackfill) != 0 {
+		var gcsChans []chan string
+		var err error
+		gc := gcs.Config{
+			URLs:    options.Reqs.GCSBackfill,
+			Options: options.GCSBackfill,
+		}
+		if options.TailSample {
+			gcsChans, err = gcs.GetSampledEntries(gc, options.SampleRate, abort)
+		} else {
+			gcsChans, err = gcs.GetEntries(gc, abort)
+		}
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"err": err}).Fatal(
+				"Error occurred while trying to start the GCS backfill")
+		}
+		for i, lines := range gcsChans {
+			gcsChans[i] = limits.limit(lines, abort)
+		}
+		linesChans = append(linesChans, gcsChans...)
+	}
+	// and one for each remote file we're following over SSH, if any
+	if len(options.Reqs.SSH) != 0 {
+		var sshChans []chan string
+		var err error
+		sc := sshtail.Config{
+			Targets:  options.Reqs.SSH,
+			Options:  options.SSH,
+			ReadFrom: options.Tail.ReadFrom,
+			Stop:     options.Tail.Stop,
+		}
+		if options.TailSample {
+			sshChans, err = sshtail.GetSampledEntries(sc, options.SampleRate, abort)
+		} else {
+			sshChans, err = sshtail.GetEntries(sc, abort)
+		}
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"err": err}).Fatal(
+				"Error occurred while trying to follow remote files over SSH")
+		}
+		linesChans = append(linesChans, sshChans...)
+	}
+	// containers whose output we read from the Docker daemon are sent on
+	// dockerContainers as they're found
+	var dockerContainers chan dockerlogs.Container
+	if len(options.Reqs.DockerAPI) != 0 {
+		var err error
+		var labels []string
+		for _, label := range options.Reqs.DockerAPI {
+			// * reads every container
+			if label != "*" {
+				labels = append(labels, label)
+			}
+		}
+		dc := dockerlogs.Config{
+			Labels:   labels,
+			Options:  options.DockerAPI,
+			ReadFrom: options.Tail.ReadFrom,
+			Stop:     options.Tail.Stop,
+		}
+		dockerContainers, err = dockerlogs.GetContainers(dc, abort)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"err": err}).Fatal(
+				"Error occurred while trying to read Docker container output")
+		}
+	}
+	// as are the containers of Kubernetes pods whose logs we read
+	var kubeContainers chan kubelogs.Container
+	if options.Reqs.Kubernetes != "" {
+		var err error
+		kc := kubelogs.Config{
+			Namespace: options.Reqs.Kubernetes,
+			Options:   options.Kubernetes,
+			ReadFrom:  options.Tail.ReadFrom,
+			Stop:      options.Tail.Stop,
+		}
+		kubeContainers, err = kubelogs.GetContainers(kc, abort)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"err": err}).Fatal(
+				"Error occurred while trying to read Kubernetes pod logs")
+		}
+	}
+	// and accept events from Honeycomb SDKs if we're acting as a proxy
+	var proxyEvents chan event.Event
+	if options.Reqs.Proxy != "" {
+		var err error
+		pc := proxy.Config{
+			Addr:       options.Reqs.Proxy,
+			SampleRate: options.SampleRate,
+			RateLimit:  options.Listen.RateLimitConfig(),
+			ReusePort:  options.Listen.ReusePort,
+		}
+		proxyEvents, err = proxy.GetEvents(pc, abort)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"err": err}).Fatal(
+				"Error occurred while trying to start proxy")
+		}
+	}
+
+	// and read statement statistics from a database if we've been asked to
+	var pollEvents chan event.Event
+	if options.Reqs.Poll != "" {
+		var err error
+		pc := poll.Config{
+			Addr:       options.Reqs.Poll,
+			Interval:   time.Duration(options.PollInterval) * time.Second,
+			SampleRate: options.SampleRate,
+		}
+		pollEvents, err = poll.GetEvents(pc, abort)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"err": err}).Fatal(
+				"Error occurred while trying to poll the database")
+		}
+	}
+
+	// and read entries from the systemd journal
+	var journalEvents chan event.Event
+	if len(options.Reqs.Journald) != 0 {
+		var err error
+		cursorFile := options.JournaldCursorFile
+		if cursorFile == "" {
+			cursorFile = filepath.Join(os.TempDir(), "honeytail.journald.cursor")
+		}
+		jc := journald.Config{
+			Units:      options.Reqs.Journald,
+			CursorFile: cursorFile,
+			ReadFrom:   options.Tail.ReadFrom,
+			Stop:       options.Tail.Stop,
+			SampleRate: options.SampleRate,
+			Options:    options.Journal,
+		}
+		journalEvents, err = journald.GetEvents(jc, abort)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"err": err}).Fatal(
+				"Error occurred while trying to read the journal")
+		}
+	}
+
+	// and pull log entries from a Pub/Sub subscription, parsing them as
+	// they arrive so each message is acknowledged once its events are sent
+	var subscriber *pubsub.Subscriber
+	if options.Reqs.PubSub != "" {
+		parser, opts := getParserAndOptions(options)
+		if parser == nil {
+			logrus.WithFields(logrus.Fields{"parser": options.Reqs.ParserName}).Fatal(
+				"Parser not found. Use --list to show valid parsers")
+		}
+		if err := parser.Init(opts); err != nil {
+			logrus.WithFields(logrus.Fields{"parser": options.Reqs.ParserName, "err": err}).Fatal(
+				"err initializing parser module")
+		}
+		// lines are dropped before parsing as they would be when tailing
+		sampleRate := options.PreSampleRate
+		if options.TailSample {
+			sampleRate *= options.SampleRate
+		}
+		psc := pubsub.Config{
+			Subscription: options.Reqs.PubSub,
+			Options:      options.PubSub,
+			Parser:       parser,
+			PrefixRegex:  prefixRegex,
+			SampleRate:   sampleRate,
+		}
+		var err error
+		subscriber, err = pubsub.Subscribe(psc, abort)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"err": err}).Fatal(
+				"Error occurred while trying to subscribe to Pub/Sub")
+		}
+	}
+
+	// drop lines before parsing if we've been asked to
+	if options.PreSampleRate > 1 {
+		for i, lines := range linesChans {
+			linesChans[i] = preSample(lines, options.PreSampleRate)
+		}
 	}
 
 	// set up our signal handler, now that we know how many files we're tailing,
@@ -108,10 +512,62 @@ func run(options GlobalOptions) {
 		}
 	}()
 
+	// sessions are tracked across all the inputs
+	sessions := session.New(session.Config{
+		KeyFields: options.SessionKey,
+		Gap:       time.Duration(options.SessionGap) * time.Second,
+		PathField: options.SessionPathField,
+		Dataset:   options.SessionDataset,
+	})
+	// as are the most frequent values of fields
+	topK := topk.New(topk.Config{
+		Fields:  options.TopKFields,
+		K:       int(options.TopK),
+		Window:  time.Duration(options.TopKWindow) * time.Second,
+		Dataset: options.TopKDataset,
+	})
+	// and maintenance mode holds events from all of them
+	var maint *maintenance.Controller
+	if options.ControlSocket != "" {
+		spool := options.MaintenanceSpool
+		if spool == "" {
+			spool = filepath.Join(os.TempDir(), "honeytail.maintenance.spool")
+		}
+		maint = maintenance.New(spool, func(start, end time.Time) error {
+			return createMarker(options, start, end)
+		})
+		maint.Handle("config", func([]string) string {
+			return runningConfig
+		})
+		maint.Handle("logs", func([]string) string {
+			return logs.String()
+		})
+		maint.Handle("stats", func([]string) string {
+			return stats.snapshot()
+		})
+		l, err := maintenance.Listen(options.ControlSocket, maint)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"err": err}).Fatal(
+				"Error occurred while trying to listen on the control socket")
+		}
+		defer l.Close()
+	}
+
+	// events from before --since are skipped
+	var since time.Time
+	if options.Since != "" {
+		var err error
+		since, err = parseSince(options.Since, time.Now())
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"err": err}).Fatal(
+				"Error occurred while parsing since")
+		}
+	}
+
 	// for each channel we got back from tail.GetEntries, spin up a parser.
 	parsersWG := sync.WaitGroup{}
 	responsesWG := sync.WaitGroup{}
-	for _, lines := range linesChans {
+	startParser := func(lines chan string, prefixRegex *parsers.ExtRegexp, fields map[string]interface{}) {
 		// get our parser
 		parser, opts := getParserAndOptions(options)
 		if parser == nil {
@@ -127,44 +583,14 @@ func run(options GlobalOptions) {
 
 		// create a channel for sending events into libhoney
 		toBeSent := make(chan event.Event, options.NumSenders)
-		doneSending := make(chan bool)
-
-		// two channels to handle backing off when rate limited and resending failed
-		// send attempts that are recoverable
-		toBeResent := make(chan event.Event, 2*options.NumSenders)
-		// time in milliseconds to delay the send
-		delaySending := make(chan int, 2*options.NumSenders)
-
-		// apply any filters to the events before they get sent
-		modifiedToBeSent := modifyEventContents(toBeSent, options)
-
-		realToBeSent := make(chan event.Event, 10*options.NumSenders)
-		go func() {
-			wg := sync.WaitGroup{}
-			for i := uint(0); i < options.NumSenders; i++ {
-				wg.Add(1)
-				go func() {
-					for ev := range modifiedToBeSent {
-						realToBeSent <- ev
-					}
-					wg.Done()
-				}()
-			}
-			wg.Wait()
-			close(realToBeSent)
-		}()
-
-		// start up the sender. all sources are either sampled when tailing or in-
-		// parser, so always tell libhoney events are pre-sampled
-		go sendToLibhoney(realToBeSent, toBeResent, delaySending, doneSending)
-
-		// start a goroutine that reads from responses and logs.
-		responses := libhoney.Responses()
-		responsesWG.Add(1)
-		go func() {
-			handleResponses(responses, stats, toBeResent, delaySending, options)
-			responsesWG.Done()
-		}()
+		parsed := toBeSent
+		if !since.IsZero() {
+			parsed = skipBefore(parsed, since)
+		}
+		if fields != nil {
+			parsed = addFields(parsed, fields)
+		}
+		doneSending := startSending(parsed, stats, &responsesWG, sessions, topK, maint, options)
 
 		parsersWG.Add(1)
 		go func(plines chan string) {
@@ -177,7 +603,118 @@ func run(options GlobalOptions) {
 			parsersWG.Done()
 		}(lines)
 	}
+	for i, lines := range linesChans {
+		if prefix, ok := linePrefixes[i]; ok {
+			startParser(lines, prefix, nil)
+		} else {
+			startParser(lines, prefixRegex, nil)
+		}
+	}
+	// and for each file found while tailing
+	if newFiles != nil {
+		parsersWG.Add(1)
+		go func() {
+			for lines := range newFiles {
+				lines = filterFileLines(lines, sched, dedupWindow, limits, abort)
+				if options.PreSampleRate > 1 {
+					lines = preSample(lines, options.PreSampleRate)
+				}
+				startParser(lines, prefixRegex, nil)
+			}
+			parsersWG.Done()
+		}()
+	}
+	// and for each container, whose lines start with the stream they were
+	// written to, and whose events are described by its fields
+	if dockerContainers != nil {
+		containerPrefix := &parsers.ExtRegexp{regexp.MustCompile(
+			dockerlogs.StreamPrefixRegex + strings.TrimPrefix(options.PrefixRegex, "^"))}
+		parsersWG.Add(1)
+		go func() {
+			for c := range dockerContainers {
+				lines := c.Lines
+				if options.TailSample {
+					lines = preSample(lines, options.SampleRate)
+				}
+				if options.PreSampleRate > 1 {
+					lines = preSample(lines, options.PreSampleRate)
+				}
+				startParser(lines, containerPrefix, c.Fields)
+			}
+			parsersWG.Done()
+		}()
+	}
+	// and for each container of a Kubernetes pod, whose events are
+	// described by its pod's fields
+	if kubeContainers != nil {
+		parsersWG.Add(1)
+		go func() {
+			for c := range kubeContainers {
+				lines := c.Lines
+				if options.TailSample {
+					lines = preSample(lines, options.SampleRate)
+				}
+				if options.PreSampleRate > 1 {
+					lines = preSample(lines, options.PreSampleRate)
+				}
+				startParser(lines, prefixRegex, c.Fields)
+			}
+			parsersWG.Done()
+		}()
+	}
+	// events arriving at the proxy have already been parsed, so they go
+	// straight to the sending pipeline
+	if proxyEvents != nil {
+		// proxied events don't pass through presampling
+		proxyOptions := options
+		proxyOptions.PreSampleRate = 1
+		doneSending := startSending(proxyEvents, stats, &responsesWG, sessions, topK, maint, proxyOptions)
+		parsersWG.Add(1)
+		go func() {
+			<-doneSending
+			parsersWG.Done()
+		}()
+	}
+	// as do events from polling a database
+	if pollEvents != nil {
+		pollOptions := options
+		pollOptions.PreSampleRate = 1
+		doneSending := startSending(pollEvents, stats, &responsesWG, sessions, topK, maint, pollOptions)
+		parsersWG.Add(1)
+		go func() {
+			<-doneSending
+			parsersWG.Done()
+		}()
+	}
+	// and journal entries
+	if journalEvents != nil {
+		journalOptions := options
+		journalOptions.PreSampleRate = 1
+		doneSending := startSending(journalEvents, stats, &responsesWG, sessions, topK, maint, journalOptions)
+		parsersWG.Add(1)
+		go func() {
+			<-doneSending
+			parsersWG.Done()
+		}()
+	}
+	// and Pub/Sub messages, once parsed, acknowledging the last of them
+	// when they've all been handed to libhoney
+	if subscriber != nil {
+		doneSending := startSending(subscriber.Events, stats, &responsesWG, sessions, topK, maint, options)
+		parsersWG.Add(1)
+		go func() {
+			<-doneSending
+			subscriber.Close()
+			parsersWG.Done()
+		}()
+	}
 	parsersWG.Wait()
+	if dedupWindow != nil {
+		if err := dedupWindow.Save(); err != nil {
+			logrus.WithFields(logrus.Fields{"err": err}).Warn(
+				"Failed to save recently read lines")
+		}
+	}
 	// tell libhoney to finish up sending events
 	libhoney.Close()
 	// print out what we've done one last time
@@ -189,6 +726,231 @@ func run(options GlobalOptions) {
 	logrus.Info("Honeytail is all done, goodbye!")
 }
 
+// addFields adds fields to each event read from toBeSent that doesn't
+// already have them
+func addFields(toBeSent chan event.Event, fields map[string]interface{}) chan event.Event {
+	withFields := make(chan event.Event, cap(toBeSent))
+	go func() {
+		defer close(withFields)
+		for ev := range toBeSent {
+			for k, v := range fields {
+				if _, ok := ev.Data[k]; !ok {
+					ev.Data[k] = v
+				}
+			}
+			withFields <- ev
+		}
+	}()
+	return withFields
+}
+
+// parseSince parses --since, either a duration before now such as 2h or
+// 3d, or a time as RFC3339, with or without its zone, or a date
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if strings.HasSuffix(s, "d") {
+		if days, err := strconv.ParseUint(strings.TrimSuffix(s, "d"), 10, 32); err == nil {
+			return now.AddDate(0, 0, -int(days)), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("since %q should be a time before now", s)
+		}
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("since %q should be a duration such as 2h or 3d, or a time such as 2006-01-02T15:04:05Z", s)
+}
+
+// skipBefore passes on the events read from toBeSent timestamped at or
+// after since
+func skipBefore(toBeSent chan event.Event, since time.Time) chan event.Event {
+	recent := make(chan event.Event, cap(toBeSent))
+	go func() {
+		defer close(recent)
+		for ev := range toBeSent {
+			if !ev.Timestamp.Before(since) {
+				recent <- ev
+			}
+		}
+	}()
+	return recent
+}
+
+// preSample keeps 1 / rate of the lines read from lines, before they are
+// parsed. The sample rate of the resulting events is adjusted in
+// modifyEventContents.
+func preSample(lines chan string, rate uint) chan string {
+	sampled := make(chan string)
+	go func() {
+		defer close(sampled)
+		for line := range lines {
+			if rand.Intn(int(rate)) == 0 {
+				sampled <- line
+			}
+		}
+	}()
+	return sampled
+}
+
+// filterFileLines holds off reading lines from a file outside the backfill
+// windows, paces them to the backfill rate limits, and skips those sent
+// before a restart
+func filterFileLines(lines chan string, sched schedule.Schedule, dedupWindow *dedup.Window, limits backfillLimits, abort chan struct{}) chan string {
+	if len(sched) != 0 {
+		lines = scheduleLines(lines, sched, abort)
+	}
+	lines = limits.limit(lines, abort)
+	if dedupWindow != nil {
+		lines = dedupWindow.Filter(lines)
+	}
+	return lines
+}
+
+// backfillLimits are how fast each file or backfill location may be read,
+// and all of them together
+type backfillLimits struct {
+	perInput *throttle.Rate
+	total    *throttle.LineLimiter
+}
+
+// newBackfillLimits returns the limits set by --backfill_rate_limit and
+// --backfill_total_rate_limit
+func newBackfillLimits(options GlobalOptions) (backfillLimits, error) {
+	var limits backfillLimits
+	if options.BackfillRateLimit != "" {
+		rate, err := throttle.ParseRate(options.BackfillRateLimit)
+		if err != nil {
+			return limits, err
+		}
+		limits.perInput = &rate
+	}
+	if options.BackfillTotalRateLimit != "" {
+		rate, err := throttle.ParseRate(options.BackfillTotalRateLimit)
+		if err != nil {
+			return limits, err
+		}
+		limits.total = throttle.NewLineLimiter(rate)
+	}
+	return limits, nil
+}
+
+// limit paces the lines read from a single input, slowing reading it to
+// match
+func (b backfillLimits) limit(lines chan string, abort chan struct{}) chan string {
+	var limiters []*throttle.LineLimiter
+	if b.perInput != nil {
+		limiters = append(limiters, throttle.NewLineLimiter(*b.perInput))
+	}
+	if b.total != nil {
+		limiters = append(limiters, b.total)
+	}
+	if len(limiters) == 0 {
+		return lines
+	}
+	return throttle.Lines(lines, limiters, abort)
+}
+
+// schedulePollInterval is how often paused inputs check whether they may
+// resume
+const schedulePollInterval = 30 * time.Second
+
+// scheduleLines passes lines through only while sched is open, pausing
+// reading from lines (and so the tailer behind it) while it's closed.
+func scheduleLines(lines chan string, sched schedule.Schedule, abort chan struct{}) chan string {
+	scheduled := make(chan string)
+	go func() {
+		defer close(scheduled)
+		for line := range lines {
+			if !sched.Open(time.Now()) {
+				logrus.Info("Outside the backfill windows, pausing")
+				for !sched.Open(time.Now()) {
+					select {
+					case <-time.After(schedulePollInterval):
+					case <-abort:
+						// let the tailer finish up without us
+						go func() {
+							for range lines {
+							}
+						}()
+						return
+					}
+				}
+				logrus.Info("Inside a backfill window, resuming")
+			}
+			scheduled <- line
+		}
+	}()
+	return scheduled
+}
+
+// startSending applies any filters to the events read from toBeSent and
+// hands them to libhoney, along with handling the responses. The returned
+// channel receives a value once toBeSent has been closed and drained.
+func startSending(toBeSent chan event.Event, stats *responseStats,
+	responsesWG *sync.WaitGroup, sessions *session.Sessionizer, topK *topk.Tracker,
+	maint *maintenance.Controller, options GlobalOptions) chan bool {
+	doneSending := make(chan bool)
+
+	// two channels to handle backing off when rate limited and resending failed
+	// send attempts that are recoverable
+	toBeResent := make(chan event.Event, 2*options.NumSenders)
+	// time in milliseconds to delay the send
+	delaySending := make(chan int, 2*options.NumSenders)
+
+	// deal with arrays first, so exploded events get filtered too
+	if len(options.ArrayFields) != 0 {
+		arrayFields, err := parseArrayFields(options.ArrayFields)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"err": err}).Fatal(
+				"Error occurred while parsing array_field")
+		}
+		toBeSent = expandArrays(toBeSent, arrayFields)
+	}
+
+	// apply any filters to the events before they get sent
+	modifiedToBeSent := modifyEventContents(toBeSent, options)
+	// and summarize sessions, once the events look as they'll be sent
+	modifiedToBeSent = session.Sessionize(modifiedToBeSent, sessions)
+	modifiedToBeSent = topk.Track(modifiedToBeSent, topK)
+
+	realToBeSent := make(chan event.Event, 10*options.NumSenders)
+	go func() {
+		wg := sync.WaitGroup{}
+		for i := uint(0); i < options.NumSenders; i++ {
+			wg.Add(1)
+			go func() {
+				for ev := range modifiedToBeSent {
+					realToBeSent <- ev
+				}
+				wg.Done()
+			}()
+		}
+		wg.Wait()
+		close(realToBeSent)
+	}()
+
+	// start up the sender. all sources are either sampled when tailing or in-
+	// parser, so always tell libhoney events are pre-sampled
+	go sendToLibhoney(maintenance.Hold(realToBeSent, maint), toBeResent, delaySending, doneSending)
+
+	// start a goroutine that reads from responses and logs.
+	responses := libhoney.Responses()
+	responsesWG.Add(1)
+	go func() {
+		handleResponses(responses, stats, toBeResent, delaySending, options)
+		responsesWG.Done()
+	}()
+	return doneSending
+}
+
 // getParserOptions takes a parser name and the global options struct
 // it returns the options group for the specified parser
 func getParserAndOptions(options GlobalOptions) (parsers.Parser, interface{}) {
@@ -220,6 +982,222 @@ func getParserAndOptions(options GlobalOptions) (parsers.Parser, interface{}) {
 	case "arangodb":
 		parser = &arangodb.Parser{}
 		opts = &options.ArangoDB
+	case "winevent":
+		parser = &winevent.Parser{
+			SampleRate: int(options.SampleRate),
+		}
+		opts = &options.WinEvent
+		opts.(*winevent.Options).NumParsers = int(options.NumSenders)
+	case "cef":
+		parser = &cef.Parser{}
+		opts = &options.CEF
+		opts.(*cef.Options).NumParsers = int(options.NumSenders)
+	case "leef":
+		parser = &leef.Parser{}
+		opts = &options.LEEF
+		opts.(*leef.Options).NumParsers = int(options.NumSenders)
+	case "gelf":
+		parser = &gelf.Parser{}
+		opts = &options.GELF
+		opts.(*gelf.Options).NumParsers = int(options.NumSenders)
+	case "docker":
+		dockerParser := &docker.Parser{}
+		if options.Docker.InnerParser != "" {
+			innerOptions := options
+			innerOptions.Reqs.ParserName = options.Docker.InnerParser
+			dockerParser.Inner, dockerParser.InnerOptions = getParserAndOptions(innerOptions)
+		}
+		parser = dockerParser
+		opts = &options.Docker
+		opts.(*docker.Options).NumParsers = int(options.NumSenders)
+	case "cri":
+		criParser := &cri.Parser{}
+		if options.CRI.InnerParser != "" {
+			innerOptions := options
+			innerOptions.Reqs.ParserName = options.CRI.InnerParser
+			criParser.Inner, criParser.InnerOptions = getParserAndOptions(innerOptions)
+		}
+		parser = criParser
+		opts = &options.CRI
+		opts.(*cri.Options).NumParsers = int(options.NumSenders)
+	case "k8saudit":
+		parser = &k8saudit.Parser{}
+		opts = &options.K8sAudit
+		opts.(*k8saudit.Options).NumParsers = int(options.NumSenders)
+	case "klog", "glog":
+		parser = &klog.Parser{}
+		opts = &options.Klog
+		opts.(*klog.Options).NumParsers = int(options.NumSenders)
+	case "awselb", "alb", "elb":
+		parser = &awselb.Parser{}
+		opts = &options.AWSELB
+		opts.(*awselb.Options).NumParsers = int(options.NumSenders)
+	case "cloudfront":
+		parser = &cloudfront.Parser{
+			SampleRate: int(options.SampleRate),
+		}
+		opts = &options.CloudFront
+		opts.(*cloudfront.Options).NumParsers = int(options.NumSenders)
+	case "s3":
+		parser = &s3.Parser{}
+		opts = &options.S3
+		opts.(*s3.Options).NumParsers = int(options.NumSenders)
+	case "vpcflow":
+		parser = &vpcflow.Parser{}
+		opts = &options.VPCFlow
+		opts.(*vpcflow.Options).NumParsers = int(options.NumSenders)
+	case "cloudtrail":
+		parser = &cloudtrail.Parser{}
+		opts = &options.CloudTrail
+		opts.(*cloudtrail.Options).NumParsers = int(options.NumSenders)
+	case "gcplog":
+		parser = &gcplog.Parser{}
+		opts = &options.GCPLog
+		opts.(*gcplog.Options).NumParsers = int(options.NumSenders)
+	case "heroku":
+		parser = &heroku.Parser{}
+		opts = &options.Heroku
+		opts.(*heroku.Options).NumParsers = int(options.NumSenders)
+	case "rails":
+		parser = &rails.Parser{
+			SampleRate: int(options.SampleRate),
+		}
+		opts = &options.Rails
+	case "python":
+		parser = &python.Parser{
+			SampleRate: int(options.SampleRate),
+		}
+		opts = &options.Python
+	case "log4j":
+		parser = &log4j.Parser{
+			SampleRate: int(options.SampleRate),
+		}
+		opts = &options.Log4j
+	case "multiline":
+		multilineParser := &multiline.Parser{
+			SampleRate: int(options.SampleRate),
+		}
+		if options.Multiline.InnerParser != "" {
+			innerOptions := options
+			innerOptions.Reqs.ParserName = options.Multiline.InnerParser
+			multilineParser.Inner, multilineParser.InnerOptions = getParserAndOptions(innerOptions)
+		}
+		parser = multilineParser
+		opts = &options.Multiline
+		opts.(*multiline.Options).NumParsers = int(options.NumSenders)
+	case "postfix":
+		parser = &postfix.Parser{
+			SampleRate: int(options.SampleRate),
+		}
+		opts = &options.Postfix
+	case "cdc":
+		parser = &cdc.Parser{}
+		opts = &options.CDC
+		opts.(*cdc.Options).NumParsers = int(options.NumSenders)
+	case "authlog":
+		parser = &authlog.Parser{}
+		opts = &options.AuthLog
+		opts.(*authlog.Options).NumParsers = int(options.NumSenders)
+	case "auditd":
+		parser = &auditd.Parser{
+			SampleRate: int(options.SampleRate),
+		}
+		opts = &options.Auditd
+	case "varnish":
+		parser = &varnish.Parser{}
+		opts = &options.Varnish
+		opts.(*varnish.Options).NumParsers = int(options.NumSenders)
+	case "squid":
+		parser = &squid.Parser{}
+		opts = &options.Squid
+		opts.(*squid.Options).NumParsers = int(options.NumSenders)
+	case "iis":
+		parser = &iis.Parser{
+			SampleRate: int(options.SampleRate),
+		}
+		opts = &options.IIS
+		opts.(*iis.Options).NumParsers = int(options.NumSenders)
+	case "pgcsvlog":
+		parser = &pgcsvlog.Parser{
+			SampleRate: int(options.SampleRate),
+		}
+		opts = &options.PgCSVLog
+		opts.(*pgcsvlog.Options).NumParsers = int(options.NumSenders)
+	case "pgbouncer":
+		parser = &pgbouncer.Parser{}
+		opts = &options.PgBouncer
+		opts.(*pgbouncer.Options).NumParsers = int(options.NumSenders)
+	case "mysqlaudit":
+		parser = &mysqlaudit.Parser{
+			SampleRate: int(options.SampleRate),
+		}
+		opts = &options.MySQLAudit
+		opts.(*mysqlaudit.Options).NumParsers = int(options.NumSenders)
+	case "clickhouse":
+		parser = &clickhouse.Parser{}
+		opts = &options.ClickHouse
+		opts.(*clickhouse.Options).NumParsers = int(options.NumSenders)
+	case "rabbitmq":
+		parser = &rabbitmq.Parser{
+			SampleRate: int(options.SampleRate),
+		}
+		opts = &options.RabbitMQ
+	case "kafka":
+		parser = &kafka.Parser{}
+		opts = &options.Kafka
+		opts.(*kafka.Options).NumParsers = int(options.NumSenders)
+	case "statsd":
+		parser = &statsd.Parser{}
+		opts = &options.Statsd
+		opts.(*statsd.Options).NumParsers = int(options.NumSenders)
+	case "influx":
+		parser = &influx.Parser{}
+		opts = &options.Influx
+		opts.(*influx.Options).NumParsers = int(options.NumSenders)
+	case "prometheus":
+		parser = &prometheus.Parser{}
+		opts = &options.Prometheus
+		opts.(*prometheus.Options).NumParsers = int(options.NumSenders)
+	case "otlp":
+		parser = &otlp.Parser{}
+		opts = &options.OTLP
+		opts.(*otlp.Options).NumParsers = int(options.NumSenders)
+	case "jvmgc":
+		parser = &jvmgc.Parser{}
+		opts = &options.JVMGC
+		opts.(*jvmgc.Options).NumParsers = int(options.NumSenders)
+	case "phpfpm":
+		parser = &phpfpm.Parser{
+			SampleRate: int(options.SampleRate),
+		}
+		opts = &options.PHPFPM
+	case "nginxerror":
+		parser = &nginxerror.Parser{}
+		opts = &options.NginxError
+		opts.(*nginxerror.Options).NumParsers = int(options.NumSenders)
+	case "mysqlgeneral":
+		parser = &mysqlgeneral.Parser{
+			SampleRate: int(options.SampleRate),
+		}
+		opts = &options.MySQLGeneral
+	case "dns":
+		parser = &dns.Parser{}
+		opts = &options.DNS
+		opts.(*dns.Options).NumParsers = int(options.NumSenders)
+	case "exim":
+		parser = &exim.Parser{}
+		opts = &options.Exim
+		opts.(*exim.Options).NumParsers = int(options.NumSenders)
+	case "ceph":
+		parser = &ceph.Parser{}
+		opts = &options.Ceph
+		opts.(*ceph.Options).NumParsers = int(options.NumSenders)
+	case "zeek":
+		parser = &zeek.Parser{
+			SampleRate: int(options.SampleRate),
+		}
+		opts = &options.Zeek
+		opts.(*zeek.Options).NumParsers = int(options.NumSenders)
 	}
 	parser, _ = parser.(parsers.Parser)
 	return parser, opts
@@ -257,6 +1235,28 @@ func modifyEventContents(toBeSent chan event.Event, options GlobalOptions) chan
 			shaper.pr.Patterns = append(shaper.pr.Patterns, &pat)
 		}
 	}
+	// load the key used to encrypt fields
+	var encrypter *fieldcrypt.Encrypter
+	if len(options.EncryptFields) != 0 {
+		var err error
+		encrypter, err = fieldcrypt.NewEncrypter(options.EncryptKey)
+		if err != nil {
+			logrus.WithField("encrypt_key", options.EncryptKey).WithError(err).Fatal(
+				"Failed to load the key to encrypt fields with.")
+		}
+	}
+	// load the key used to tokenize fields
+	var tokenizer *fieldcrypt.Tokenizer
+	if len(options.TokenizeFields) != 0 {
+		key, err := getTokenizeKey(options.TokenizeKeyFile)
+		if err == nil {
+			tokenizer, err = fieldcrypt.NewTokenizer(key)
+		}
+		if err != nil {
+			logrus.WithField("tokenize_key_file", options.TokenizeKeyFile).WithError(err).Fatal(
+				"Failed to load the key to tokenize fields with.")
+		}
+	}
 	// initialize the dynamic sampler
 	var sampler dynsampler.Sampler
 	if len(options.DynSample) != 0 {
@@ -289,6 +1289,26 @@ func modifyEventContents(toBeSent chan event.Event, options GlobalOptions) chan
 							ev.Data[field] = fmt.Sprintf("%x", newVal)
 						}
 					}
+					// do tokenizing
+					for _, field := range options.TokenizeFields {
+						if val, ok := ev.Data[field]; ok {
+							ev.Data[field] = tokenizer.Tokenize(fmt.Sprintf("%v", val))
+						}
+					}
+					// do encrypting
+					for _, field := range options.EncryptFields {
+						if val, ok := ev.Data[field]; ok {
+							newVal, err := encrypter.Encrypt([]byte(fmt.Sprintf("%v", val)))
+							if err != nil {
+								// never send the plaintext
+								logrus.WithField("field", field).WithError(err).Error(
+									"Failed to encrypt field, dropping it")
+								delete(ev.Data, field)
+								continue
+							}
+							ev.Data[field] = newVal
+						}
+					}
 					// do adding
 					for k, v := range parsedAddFields {
 						ev.Data[k] = v
@@ -297,18 +1317,28 @@ func modifyEventContents(toBeSent chan event.Event, options GlobalOptions) chan
 					for _, field := range options.RequestShape {
 						shaper.requestShape(field, &ev, options)
 					}
-					// do dynsampling last so it can use request shaped fields
+					// do dynsampling last so it can use request shaped fields.
+					// sources that sampled the event themselves (like the
+					// proxy) have already set its sample rate
 					if sampler == nil {
-						ev.SampleRate = int(options.SampleRate)
+						if ev.SampleRate == 0 {
+							ev.SampleRate = int(options.SampleRate)
+						}
 					} else {
 						key := makeDynsampleKey(&ev, options)
 						sr := sampler.GetSampleRate(key)
 						if rand.Intn(sr) != 0 {
 							ev.SampleRate = -1
+						} else if ev.SampleRate > 1 {
+							ev.SampleRate *= sr
 						} else {
 							ev.SampleRate = sr
 						}
 					}
+					// account for lines dropped before parsing
+					if ev.SampleRate > 0 && options.PreSampleRate > 1 {
+						ev.SampleRate *= int(options.PreSampleRate)
+					}
 					newSent <- ev
 				}
 				wg.Done()
@@ -320,6 +1350,20 @@ func modifyEventContents(toBeSent chan event.Event, options GlobalOptions) chan
 	return newSent
 }
 
+// getTokenizeKey reads the --tokenize_field key from path, or from the
+// environment if path is empty. Surrounding whitespace is ignored.
+func getTokenizeKey(path string) ([]byte, error) {
+	key := os.Getenv(tokenizeKeyEnv)
+	if path != "" {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key = string(contents)
+	}
+	return []byte(strings.TrimSpace(key)), nil
+}
+
 // makeDynsampleKey pulls in all the values necessary from the event to create a
 // key for dynamic sampling
 func makeDynsampleKey(ev *event.Event, options GlobalOptions) string {
@@ -450,6 +1494,35 @@ func sendToLibhoney(toBeSent chan event.Event, toBeResent chan event.Event,
 	}
 }
 
+// createMarker adds a marker to the dataset covering maintenance mode, so
+// the gap in the graphs is explained
+func createMarker(options GlobalOptions, start, end time.Time) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"message":    "honeytail maintenance mode",
+		"type":       "maintenance",
+		"start_time": start.Unix(),
+		"end_time":   end.Unix(),
+	})
+	url := fmt.Sprintf("%s/1/markers/%s", strings.TrimSuffix(options.APIHost, "/"), options.Reqs.Dataset)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", libhoney.UserAgentAddition)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Add("X-Honeycomb-Team", options.Reqs.WriteKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
 // sendEvent does the actual handoff to libhoney
 func sendEvent(ev event.Event) {
 	if ev.SampleRate == -1 {
@@ -457,12 +1530,21 @@ func sendEvent(ev event.Event) {
 		logrus.WithFields(logrus.Fields{
 			"event": ev,
 		}).Debug("droppped event due to sampling")
+		if ev.Done != nil {
+			ev.Done()
+		}
 		return
 	}
 	libhEv := libhoney.NewEvent()
 	libhEv.Metadata = ev
 	libhEv.Timestamp = ev.Timestamp
 	libhEv.SampleRate = uint(ev.SampleRate)
+	if ev.WriteKey != "" {
+		libhEv.WriteKey = ev.WriteKey
+	}
+	if ev.Dataset != "" {
+		libhEv.Dataset = ev.Dataset
+	}
 	if err := libhEv.Add(ev.Data); err != nil {
 		logrus.WithFields(logrus.Fields{
 			"event": ev,
@@ -475,6 +1557,9 @@ func sendEvent(ev event.Event) {
 			"error": err,
 		}).Error("Unexpected error event to libhoney send")
 	}
+	if ev.Done != nil {
+		ev.Done()
+	}
 }
 
 // handleResponses reads from the response queue, logging a summary and debug
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -17,11 +23,15 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Sirupsen/logrus"
+	flag "github.com/jessevdk/go-flags"
 	"golang.org/x/sys/unix"
 
 	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/fieldcrypt"
+	"github.com/honeycombio/honeytail/schedule"
 	"github.com/honeycombio/honeytail/tail"
 )
 
@@ -250,6 +260,418 @@ func TestScrubField(t *testing.T) {
 	testContains(t, ts.rsp.reqBody, `{"format":"json","name":"e564b4081d7a9ea4b00dada53bdae70c99b87b6fce869f0c3dd4d2bfa1e53e1c"}`)
 }
 
+func TestEncryptField(t *testing.T) {
+	opts := defaultOptions
+	ts := &testSetup{}
+	ts.start(t, &opts)
+	defer ts.close()
+	priv, err := rsa.GenerateKey(crand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, _ := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	pubPath := ts.tmpdir + "/key.pub.pem"
+	privPath := ts.tmpdir + "/key.pem"
+	ioutil.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0600)
+	ioutil.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)}), 0600)
+
+	logFileName := ts.tmpdir + "/encrypt.log"
+	fh, _ := os.Create(logFileName)
+	defer fh.Close()
+	fmt.Fprintf(fh, `{"format":"json","email":"alice@example.com"}`)
+	opts.Reqs.LogFiles = []string{logFileName}
+	opts.EncryptFields = []string{"email"}
+	opts.EncryptKey = pubPath
+	run(opts)
+	testEquals(t, ts.rsp.reqCounter, 1)
+	if strings.Contains(ts.rsp.reqBody, "alice") {
+		t.Fatalf("request body %s contains the plaintext", ts.rsp.reqBody)
+	}
+	var body []struct {
+		Data map[string]string
+	}
+	if err := json.Unmarshal([]byte(ts.rsp.reqBody), &body); err != nil || len(body) != 1 {
+		t.Fatalf("unexpected request body %s: %v", ts.rsp.reqBody, err)
+	}
+	out := &bytes.Buffer{}
+	if err := decryptValues(privPath, strings.NewReader(body[0].Data["email"]+"\n"), out); err != nil {
+		t.Fatal(err)
+	}
+	testEquals(t, out.String(), "alice@example.com\n")
+}
+
+func TestTokenizeField(t *testing.T) {
+	opts := defaultOptions
+	ts := &testSetup{}
+	ts.start(t, &opts)
+	defer ts.close()
+	keyFileName := ts.tmpdir + "/tokenize.key"
+	ioutil.WriteFile(keyFileName, []byte("s3cret\n"), 0600)
+	logFileName := ts.tmpdir + "/tokenize.log"
+	fh, _ := os.Create(logFileName)
+	defer fh.Close()
+	fmt.Fprintf(fh, `{"format":"json","user_id":1234}`)
+	opts.Reqs.LogFiles = []string{logFileName}
+	opts.TokenizeFields = []string{"user_id"}
+	opts.TokenizeKeyFile = keyFileName
+	run(opts)
+	testEquals(t, ts.rsp.reqCounter, 1)
+	tok, _ := fieldcrypt.NewTokenizer([]byte("s3cret"))
+	testContains(t, ts.rsp.reqBody, `{"format":"json","user_id":"`+tok.Tokenize("1234")+`"}`)
+
+	// the key can come from the environment too
+	os.Setenv(tokenizeKeyEnv, "s3cret")
+	defer os.Unsetenv(tokenizeKeyEnv)
+	opts.TokenizeKeyFile = ""
+	run(opts)
+	testEquals(t, ts.rsp.reqCounter, 2)
+	testContains(t, ts.rsp.reqBody, `{"format":"json","user_id":"`+tok.Tokenize("1234")+`"}`)
+}
+
+func TestParseArrayFields(t *testing.T) {
+	fields, err := parseArrayFields([]string{"items=summary:qty,price", "tags=explode", "spans=first:2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []arrayField{
+		{field: "items", strategy: arraySummary, sumKeys: []string{"qty", "price"}},
+		{field: "tags", strategy: arrayExplode},
+		{field: "spans", strategy: arrayFirst, first: 2},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("got %+v, expected %+v", fields, expected)
+	}
+	for _, bad := range []string{"items", "=summary", "items=shred", "items=first", "items=first:0", "items=explode:2"} {
+		if _, err := parseArrayFields([]string{bad}); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestArrayFields(t *testing.T) {
+	items := func() map[string]interface{} {
+		return map[string]interface{}{
+			"order": "a1",
+			"items": []interface{}{
+				map[string]interface{}{"sku": "x", "qty": float64(2)},
+				map[string]interface{}{"sku": "y", "qty": float64(3), "opts": map[string]interface{}{"gift": true}},
+			},
+		}
+	}
+	testCases := []struct {
+		spec     string
+		expected []map[string]interface{}
+	}{
+		{
+			spec: "items=summary:qty",
+			expected: []map[string]interface{}{
+				{"order": "a1", "items.count": 2, "items.qty_sum": float64(5)},
+			},
+		},
+		{
+			spec: "items=first:1",
+			expected: []map[string]interface{}{
+				{"order": "a1", "items.count": 2, "items.0.sku": "x", "items.0.qty": float64(2)},
+			},
+		},
+		{
+			spec: "items=explode",
+			expected: []map[string]interface{}{
+				{"order": "a1", "items.count": 2, "items.index": 0, "items.sku": "x", "items.qty": float64(2)},
+				{"order": "a1", "items.count": 2, "items.index": 1, "items.sku": "y", "items.qty": float64(3), "items.opts": `{"gift":true}`},
+			},
+		},
+		{
+			// fields that aren't arrays are left alone
+			spec: "order=explode",
+			expected: []map[string]interface{}{
+				items(),
+			},
+		},
+	}
+	for _, tc := range testCases {
+		fields, err := parseArrayFields([]string{tc.spec})
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := make(chan event.Event, 1)
+		in <- event.Event{Data: items()}
+		close(in)
+		var got []map[string]interface{}
+		for ev := range expandArrays(in, fields) {
+			got = append(got, ev.Data)
+		}
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%s: got %+v, expected %+v", tc.spec, got, tc.expected)
+		}
+	}
+
+	// an exploded event is done once all its children are
+	fields, _ := parseArrayFields([]string{"items=explode"})
+	done := 0
+	in := make(chan event.Event, 1)
+	in <- event.Event{Data: items(), Done: func() { done++ }}
+	close(in)
+	var children []event.Event
+	for ev := range expandArrays(in, fields) {
+		children = append(children, ev)
+	}
+	children[0].Done()
+	children[0].Done()
+	if done != 0 {
+		t.Error("event done before all its children were")
+	}
+	children[1].Done()
+	if done != 1 {
+		t.Errorf("event done %d times once its children were, expected once", done)
+	}
+}
+
+func TestAddFields(t *testing.T) {
+	in := make(chan event.Event, 2)
+	in <- event.Event{Data: map[string]interface{}{"msg": "started"}}
+	in <- event.Event{Data: map[string]interface{}{"msg": "renamed", "container_name": "api"}}
+	close(in)
+	var got []map[string]interface{}
+	for ev := range addFields(in, map[string]interface{}{"container_name": "web", "container_image": "nginx"}) {
+		got = append(got, ev.Data)
+	}
+	expected := []map[string]interface{}{
+		{"msg": "started", "container_name": "web", "container_image": "nginx"},
+		// fields the event already has are left alone
+		{"msg": "renamed", "container_name": "api", "container_image": "nginx"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+}
+
+func TestSessionKey(t *testing.T) {
+	opts := defaultOptions
+	ts := &testSetup{}
+	ts.start(t, &opts)
+	defer ts.close()
+	logFileName := ts.tmpdir + "/session.log"
+	fh, _ := os.Create(logFileName)
+	defer fh.Close()
+	fmt.Fprintf(fh, `{"user_id":"alice","request_path":"/","time":"2019-01-07T12:00:00Z"}
+{"user_id":"alice","request_path":"/cart","time":"2019-01-07T12:01:00Z"}`)
+	opts.Reqs.LogFiles = []string{logFileName}
+	opts.SessionKey = []string{"user_id"}
+	opts.SessionGap = 1800
+	opts.SessionPathField = "request_path"
+	run(opts)
+	testContains(t, ts.rsp.reqBody, `"session.distinct_paths":2,"session.duration_sec":60,"session.entry_path":"/","session.event_count":2,"session.exit_path":"/cart","user_id":"alice"`)
+}
+
+func TestTopKField(t *testing.T) {
+	opts := defaultOptions
+	ts := &testSetup{}
+	ts.start(t, &opts)
+	defer ts.close()
+	logFileName := ts.tmpdir + "/topk.log"
+	fh, _ := os.Create(logFileName)
+	defer fh.Close()
+	fmt.Fprintf(fh, `{"client_ip":"10.0.0.1","time":"2019-01-07T12:00:00Z"}
+{"client_ip":"10.0.0.1","time":"2019-01-07T12:00:10Z"}
+{"client_ip":"10.0.0.2","time":"2019-01-07T12:00:20Z"}`)
+	opts.Reqs.LogFiles = []string{logFileName}
+	opts.TopKFields = []string{"client_ip"}
+	opts.TopK = 1
+	opts.TopKWindow = 60
+	run(opts)
+	testContains(t, ts.rsp.reqBody, `"client_ip":"10.0.0.1","topk.count":2,"topk.error":0,"topk.field":"client_ip","topk.rank":1,"topk.total":3,"topk.window_sec":60`)
+}
+
+func TestCreateMarker(t *testing.T) {
+	opts := defaultOptions
+	ts := &testSetup{}
+	ts.start(t, &opts)
+	defer ts.close()
+	start := time.Unix(1546862400, 0)
+	if err := createMarker(opts, start, start.Add(30*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	testEquals(t, ts.rsp.req.URL.Path, "/1/markers/pika")
+	testEquals(t, ts.rsp.req.Header.Get("X-Honeycomb-Team"), "abcabc123123")
+	testContains(t, ts.rsp.reqBody, `"end_time":1546864200`)
+	testContains(t, ts.rsp.reqBody, `"start_time":1546862400`)
+	ts.rsp.responseCode = 400
+	if err := createMarker(opts, start, start.Add(30*time.Minute)); err == nil {
+		t.Error("expected an error when the marker is rejected")
+	}
+}
+
+func TestDiffConfig(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	running := filepath.Join(tmpdir, "running.conf")
+	ioutil.WriteFile(running, []byte(`[Required Options]
+ParserName = json
+WriteKey = abc
+LogFiles = /var/log/a.log
+LogFiles = /var/log/b.log
+Dataset = web
+
+[Application Options]
+DropFields = password
+`), 0600)
+	proposed := filepath.Join(tmpdir, "proposed.conf")
+	ioutil.WriteFile(proposed, []byte(`[Required Options]
+ParserName = json
+WriteKey = def
+LogFiles = /var/log/a.log
+LogFiles = /var/log/c.log
+Dataset = web
+
+[Application Options]
+SampleRate = 10
+
+[Tail Options]
+ReadFrom = end
+`), 0600)
+	before, err := loadConfigINI(running)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := loadConfigINI(proposed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testEquals(t, diffConfig(before, before), "no changes\n")
+	testEquals(t, diffConfig(before, after), `inputs:
+  - LogFiles = /var/log/b.log
+  + LogFiles = /var/log/c.log
+  ~ Tail Options.ReadFrom: last -> end
+rules:
+  - DropFields = password
+  ~ SampleRate: 1 -> 10
+outputs:
+  ~ WriteKey: sha256:ba7816bf8f01 -> sha256:cb8379ac2098
+`)
+	if strings.Contains(after, "def") {
+		t.Error("expected the write key to be hidden")
+	}
+}
+
+func TestConfigHidesSecrets(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	path := filepath.Join(tmpdir, "honeytail.conf")
+	ioutil.WriteFile(path, []byte(`[Required Options]
+ParserName = json
+WriteKey = abc
+Dataset = web
+
+[Listen Options]
+HTTPToken = bearer-secret
+`), 0600)
+	config, err := loadConfigINI(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"abc", "bearer-secret"} {
+		if strings.Contains(config, secret) {
+			t.Errorf("expected %q to be hidden in %s", secret, config)
+		}
+	}
+	sum := sha256.Sum256([]byte("bearer-secret"))
+	if hashed := fmt.Sprintf("HTTPToken = sha256:%x\n", sum[:6]); !strings.Contains(config, hashed) {
+		t.Errorf("expected %q in %s", hashed, config)
+	}
+}
+
+func TestDiscoverLogs(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	conf := filepath.Join(tmpdir, "nginx.conf")
+	ioutil.WriteFile(conf, []byte(`
+http {
+    log_format timed '$remote_addr "$request" $status $request_time';
+    access_log /var/log/nginx/a.log timed;
+    access_log /var/log/nginx/b.log timed;
+    access_log /var/log/nginx/c.log;
+}`), 0600)
+
+	opts := GlobalOptions{Discover: "nginx"}
+	opts.Nginx.ConfigFile = flag.Filename(conf)
+	if err := discoverLogs(&opts); err == nil || !strings.Contains(err.Error(), "timed, combined") {
+		t.Errorf("expected an error naming both formats, got %v", err)
+	}
+
+	opts.Nginx.LogFormatName = "timed"
+	if err := discoverLogs(&opts); err != nil {
+		t.Fatal(err)
+	}
+	testEquals(t, opts.Reqs.ParserName, "nginx")
+	testEquals(t, string(opts.Nginx.ConfigFile), conf)
+	testEquals(t, strings.Join(opts.Reqs.LogFiles, ","), "/var/log/nginx/a.log,/var/log/nginx/b.log")
+
+	opts = GlobalOptions{Discover: "apache"}
+	if err := discoverLogs(&opts); err == nil {
+		t.Error("expected an error discovering an unsupported server")
+	}
+}
+
+func TestSupportBundle(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	logFile := filepath.Join(tmpdir, "app.log")
+	ioutil.WriteFile(logFile, []byte("one\ntwo\nthree\n"), 0600)
+	ioutil.WriteFile(filepath.Join(tmpdir, "app.leash.state"), []byte(`{"INode":1,"Offset":4}`), 0600)
+
+	opts := GlobalOptions{SupportBundleLines: 2}
+	opts.Reqs.LogFiles = []string{logFile}
+	opts.Tail.StateFile = tmpdir
+	bundle := filepath.Join(tmpdir, "bundle.tar.gz")
+	config := "[Required Options]\nWriteKey = sha256:abc\nPoll = mysql://user:secret@db:3306\n"
+	if err := writeSupportBundle(bundle, opts, config); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	files := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, _ := ioutil.ReadAll(tr)
+		files[strings.TrimPrefix(hdr.Name, "honeytail-support/")] = string(contents)
+	}
+	input := "inputs/" + strings.TrimPrefix(filepath.ToSlash(logFile), "/")
+	testEquals(t, files[input+".sample"], "two\nthree\n")
+	testEquals(t, files[input+".state"], filepath.Join(tmpdir, "app.leash.state")+"\n"+`{"INode":1,"Offset":4}`)
+	testEquals(t, files["config.ini"], "[Required Options]\nWriteKey = sha256:abc\nPoll = mysql://user:*@db:3306\n")
+	if !strings.HasPrefix(files["version.txt"], "honeytail ") {
+		t.Errorf("expected the version in the bundle, got %q", files["version.txt"])
+	}
+}
+
 func TestAddField(t *testing.T) {
 	opts := defaultOptions
 	ts := &testSetup{}
@@ -429,6 +851,161 @@ func TestSampleRate(t *testing.T) {
 	testContains(t, ts.rsp.reqBody, `{"format":"json49"},"samplerate":3,`)
 }
 
+func TestPreSampleRate(t *testing.T) {
+	opts := defaultOptions
+	ts := &testSetup{}
+	ts.start(t, &opts)
+	defer ts.close()
+	rand.Seed(1)
+	sampleLogFile := ts.tmpdir + "/presample.log"
+	logfh, _ := os.Create(sampleLogFile)
+	defer logfh.Close()
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(logfh, `{"format":"json%d"}`+"\n", i)
+	}
+	opts.Reqs.LogFiles = []string{sampleLogFile}
+	opts.PreSampleRate = 3
+
+	run(opts)
+	// lines are dropped before parsing and the rate is recorded on the rest
+	if ts.rsp.evtCounter == 0 || ts.rsp.evtCounter >= 50 {
+		t.Errorf("expected presampling to drop some of 50 lines, sent %d", ts.rsp.evtCounter)
+	}
+	testContains(t, ts.rsp.reqBody, `"samplerate":3,`)
+	ts.rsp.reset()
+
+	// tail sampling stacks with presampling
+	opts.SampleRate = 2
+	opts.TailSample = true
+	run(opts)
+	testContains(t, ts.rsp.reqBody, `"samplerate":6,`)
+}
+
+func TestScheduleLines(t *testing.T) {
+	open, _ := schedule.New([]string{"* * * * *"})
+	abort := make(chan struct{})
+	lines := make(chan string)
+	go func() {
+		lines <- "one"
+		close(lines)
+	}()
+	scheduled := scheduleLines(lines, open, abort)
+	testEquals(t, <-scheduled, "one")
+	if _, ok := <-scheduled; ok {
+		t.Error("expected scheduled lines to be closed")
+	}
+
+	// Feb 30th never comes, so this never opens
+	closed, _ := schedule.New([]string{"* * 30 feb *"})
+	lines = make(chan string)
+	go func() {
+		lines <- "two"
+		lines <- "three"
+		close(lines)
+	}()
+	scheduled = scheduleLines(lines, closed, abort)
+	select {
+	case line := <-scheduled:
+		t.Fatalf("got line %q outside the window", line)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(abort)
+	select {
+	case _, ok := <-scheduled:
+		if ok {
+			t.Error("got line after abort")
+		}
+	case <-time.After(time.Second):
+		t.Error("scheduled lines weren't closed on abort")
+	}
+}
+
+func TestBackfillLimits(t *testing.T) {
+	limits, err := newBackfillLimits(GlobalOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make(chan string)
+	if limits.limit(lines, nil) != lines {
+		t.Error("expected lines to be passed through unlimited")
+	}
+	if _, err := newBackfillLimits(GlobalOptions{BackfillRateLimit: "fast"}); err == nil {
+		t.Error("expected error for an invalid backfill_rate_limit")
+	}
+
+	limits, err = newBackfillLimits(GlobalOptions{
+		BackfillRateLimit:      "1000 lines/s",
+		BackfillTotalRateLimit: "2 lines/s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the total is shared between inputs, so the second's over it
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		lines := make(chan string)
+		go func() {
+			lines <- "one"
+			lines <- "two"
+			close(lines)
+		}()
+		limited := limits.limit(lines, nil)
+		testEquals(t, <-limited, "one")
+		testEquals(t, <-limited, "two")
+	}
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Errorf("read 4 lines in %s at 2 a second", elapsed)
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2017, 3, 10, 12, 0, 0, 0, time.UTC)
+	tss := []struct {
+		since    string
+		expected time.Time
+	}{
+		{"2h", now.Add(-2 * time.Hour)},
+		{"90m", now.Add(-90 * time.Minute)},
+		{"3d", time.Date(2017, 3, 7, 12, 0, 0, 0, time.UTC)},
+		{"2017-03-01T08:30:00Z", time.Date(2017, 3, 1, 8, 30, 0, 0, time.UTC)},
+		{"2017-03-01T08:30:00-05:00", time.Date(2017, 3, 1, 13, 30, 0, 0, time.UTC)},
+		{"2017-03-01", time.Date(2017, 3, 1, 0, 0, 0, 0, time.Local)},
+	}
+	for _, ts := range tss {
+		got, err := parseSince(ts.since, now)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", ts.since, err)
+			continue
+		}
+		if !got.Equal(ts.expected) {
+			t.Errorf("parsing %q: got %s, expected %s", ts.since, got, ts.expected)
+		}
+	}
+	for _, bad := range []string{"", "yesterday", "-2h", "d", "03/01/2017"} {
+		if _, err := parseSince(bad, now); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestSkipBefore(t *testing.T) {
+	since := time.Date(2017, 3, 10, 12, 0, 0, 0, time.UTC)
+	events := make(chan event.Event)
+	go func() {
+		for _, offset := range []time.Duration{-time.Second, 0, time.Hour, -time.Hour} {
+			events <- event.Event{Timestamp: since.Add(offset)}
+		}
+		close(events)
+	}()
+	var got []time.Time
+	for ev := range skipBefore(events, since) {
+		got = append(got, ev.Timestamp)
+	}
+	if len(got) != 2 || !got[0].Equal(since) || !got[1].Equal(since.Add(time.Hour)) {
+		t.Errorf("got events at %v, expected only those from %s on", got, since)
+	}
+}
+
 func TestReadFromOffset(t *testing.T) {
 	opts := defaultOptions
 	ts := &testSetup{}
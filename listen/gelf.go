@@ -0,0 +1,133 @@
+package listen
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// chunked GELF messages start with these magic bytes
+	gelfChunkMagic0 = 0x1e
+	gelfChunkMagic1 = 0x0f
+	gelfHeaderLen   = 12
+	gelfMaxChunks   = 128
+	// incomplete messages are discarded after this long, per the GELF spec
+	gelfChunkTimeout = 5 * time.Second
+	// compressed messages are dropped if they inflate past this size
+	gelfMaxMessageSize = 8 << 20
+)
+
+// gelfMessage collects the chunks of a single chunked GELF message
+type gelfMessage struct {
+	chunks   [][]byte
+	received int
+	first    time.Time
+}
+
+// gelfAssembler reassembles chunked GELF messages
+type gelfAssembler struct {
+	pending map[string]*gelfMessage
+	now     func() time.Time
+}
+
+func newGELFHandler() packetHandler {
+	a := &gelfAssembler{
+		pending: make(map[string]*gelfMessage),
+		now:     time.Now,
+	}
+	return func(pkt []byte, peer *net.UDPAddr, conn *net.UDPConn) []string {
+		payload, err := a.add(pkt)
+		if err == nil && payload != nil {
+			payload, err = decompressGELF(payload)
+		}
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"peer":  peer.String(),
+				"error": err,
+			}).Debug("skipping packet; failed to decode GELF message")
+			return nil
+		}
+		if payload == nil {
+			// waiting on more chunks
+			return nil
+		}
+		return []string{string(bytes.TrimSpace(payload))}
+	}
+}
+
+// add accepts a datagram and returns the complete message if one is ready
+func (a *gelfAssembler) add(pkt []byte) ([]byte, error) {
+	if len(pkt) < 2 || pkt[0] != gelfChunkMagic0 || pkt[1] != gelfChunkMagic1 {
+		// not chunked
+		return pkt, nil
+	}
+	if len(pkt) < gelfHeaderLen {
+		return nil, errors.New("GELF chunk header is truncated")
+	}
+	now := a.now()
+	for id, msg := range a.pending {
+		if now.Sub(msg.first) > gelfChunkTimeout {
+			logrus.WithField("message_id", []byte(id)).Debug("discarding incomplete chunked GELF message")
+			delete(a.pending, id)
+		}
+	}
+
+	id := string(pkt[2:10])
+	seq, count := int(pkt[10]), int(pkt[11])
+	if count == 0 || count > gelfMaxChunks || seq >= count {
+		return nil, errors.New("GELF chunk has an invalid sequence number or count")
+	}
+	msg, ok := a.pending[id]
+	if !ok {
+		msg = &gelfMessage{chunks: make([][]byte, count), first: now}
+		a.pending[id] = msg
+	}
+	if len(msg.chunks) != count {
+		delete(a.pending, id)
+		return nil, errors.New("GELF chunks disagree on the chunk count")
+	}
+	if msg.chunks[seq] == nil {
+		msg.chunks[seq] = pkt[gelfHeaderLen:]
+		msg.received++
+	}
+	if msg.received < count {
+		return nil, nil
+	}
+	delete(a.pending, id)
+	return bytes.Join(msg.chunks, nil), nil
+}
+
+// decompressGELF inflates gzip or zlib compressed payloads; anything else is
+// assumed to be plain JSON
+func decompressGELF(payload []byte) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch {
+	case len(payload) >= 2 && payload[0] == 0x1f && payload[1] == 0x8b:
+		r, err = gzip.NewReader(bytes.NewReader(payload))
+	case len(payload) >= 2 && payload[0] == 0x78:
+		r, err = zlib.NewReader(bytes.NewReader(payload))
+	default:
+		return payload, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	msg, err := ioutil.ReadAll(io.LimitReader(r, gelfMaxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(msg) > gelfMaxMessageSize {
+		return nil, errors.New("GELF message is too large once decompressed")
+	}
+	return msg, nil
+}
@@ -0,0 +1,97 @@
+package listen
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"net"
+	"testing"
+	"time"
+)
+
+func gelfChunk(id string, seq, count byte, data []byte) []byte {
+	return append(append([]byte{gelfChunkMagic0, gelfChunkMagic1}, []byte(id)...), append([]byte{seq, count}, data...)...)
+}
+
+func TestGELFChunking(t *testing.T) {
+	now := time.Now()
+	a := &gelfAssembler{pending: map[string]*gelfMessage{}, now: func() time.Time { return now }}
+	msg := []byte(`{"short_message":"hello"}`)
+
+	// plain messages pass straight through
+	if out, err := a.add(msg); err != nil || !bytes.Equal(out, msg) {
+		t.Errorf("unexpected result for unchunked message: %s %v", out, err)
+	}
+
+	// chunks may arrive out of order and be duplicated
+	if out, _ := a.add(gelfChunk("abcdefgh", 1, 2, msg[10:])); out != nil {
+		t.Errorf("got message before all chunks arrived: %s", out)
+	}
+	if out, _ := a.add(gelfChunk("abcdefgh", 1, 2, msg[10:])); out != nil {
+		t.Errorf("got message before all chunks arrived: %s", out)
+	}
+	out, err := a.add(gelfChunk("abcdefgh", 0, 2, msg[:10]))
+	if err != nil || !bytes.Equal(out, msg) {
+		t.Errorf("reassembled %q (%v), expected %q", out, err, msg)
+	}
+	if len(a.pending) != 0 {
+		t.Errorf("completed message should have been removed from pending")
+	}
+
+	// incomplete messages expire
+	a.add(gelfChunk("12345678", 0, 2, msg[:10]))
+	now = now.Add(10 * time.Second)
+	a.add(gelfChunk("87654321", 0, 2, msg[:10]))
+	if _, ok := a.pending["12345678"]; ok {
+		t.Error("expired message was not discarded")
+	}
+
+	for _, bad := range [][]byte{
+		{gelfChunkMagic0, gelfChunkMagic1, 1, 2},
+		gelfChunk("abcdefgh", 3, 2, nil),
+		gelfChunk("abcdefgh", 0, 0, nil),
+	} {
+		if _, err := a.add(bad); err == nil {
+			t.Errorf("expected error for chunk %x", bad)
+		}
+	}
+}
+
+func TestGELFHandlerDecompresses(t *testing.T) {
+	msg := []byte(`{"short_message":"hello"}`)
+	var gz, zl bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(msg)
+	w.Close()
+	zw := zlib.NewWriter(&zl)
+	zw.Write(msg)
+	zw.Close()
+
+	handle := newGELFHandler()
+	peer := &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}
+	for _, pkt := range [][]byte{msg, gz.Bytes(), zl.Bytes()} {
+		lines := handle(pkt, peer, nil)
+		if len(lines) != 1 || lines[0] != string(msg) {
+			t.Errorf("handler returned %q, expected %q", lines, msg)
+		}
+	}
+	// a compressed message split over chunks
+	compressed := gz.Bytes()
+	if lines := handle(gelfChunk("zzzzzzzz", 0, 2, compressed[:5]), peer, nil); lines != nil {
+		t.Errorf("unexpected lines for partial message: %q", lines)
+	}
+	lines := handle(gelfChunk("zzzzzzzz", 1, 2, compressed[5:]), peer, nil)
+	if len(lines) != 1 || lines[0] != string(msg) {
+		t.Errorf("handler returned %q for chunked message, expected %q", lines, msg)
+	}
+}
+
+func TestGELFDecompressLimit(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(bytes.Repeat([]byte{'a'}, gelfMaxMessageSize+1))
+	w.Close()
+	if _, err := decompressGELF(gz.Bytes()); err == nil {
+		t.Error("expected error for a message inflating past the limit")
+	}
+}
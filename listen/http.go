@@ -0,0 +1,155 @@
+package listen
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/ratelimit"
+)
+
+// maxHTTPBody is the largest request body the http input accepts
+const maxHTTPBody = 5 * 1024 * 1024
+
+// httpInput accepts lines POSTed by apps and scripts
+type httpInput struct {
+	token   string
+	limiter *ratelimit.Limiter
+	lines   chan string
+	abort   <-chan struct{}
+}
+
+// listenHTTP serves the http input on l, sending each line POSTed down the
+// returned channel. The channel is closed once abort is closed and all
+// in-flight requests have finished.
+func listenHTTP(l net.Listener, token string, limiter *ratelimit.Limiter, abort <-chan struct{}) chan string {
+	logrus.WithField("address", l.Addr()).Info("Listening for lines over HTTP")
+
+	h := &httpInput{
+		token:   token,
+		limiter: limiter,
+		lines:   make(chan string),
+		abort:   abort,
+	}
+	srv := &http.Server{Handler: h}
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			logrus.WithError(err).Warn("Error serving HTTP input requests, shutting it down")
+		}
+	}()
+	go func() {
+		<-abort
+		// Shutdown waits for handlers to return, so nobody is left sending on
+		// the channel when we close it
+		srv.Shutdown(context.Background())
+		close(h.lines)
+	}()
+	return h.lines
+}
+
+// ServeHTTP accepts a POST of newline-delimited lines, or of a JSON array
+// whose entries are each a line. Entries that are strings are sent as they
+// are; others are sent as JSON, for --parser=json.
+func (h *httpInput) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "missing or invalid token", http.StatusUnauthorized)
+		return
+	}
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	// the whole request is turned away, so it can be retried as it is
+	if ok, _ := h.limiter.Allow(client); !ok {
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+		return
+	}
+	body, err := readHTTPBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, line := range splitHTTPBody(body) {
+		select {
+		case h.lines <- line:
+		case <-h.abort:
+			http.Error(w, "honeytail is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// authorized returns true if no token is needed, or the request has it as
+// a bearer token
+func (h *httpInput) authorized(r *http.Request) bool {
+	if h.token == "" {
+		return true
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	given := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(h.token)) == 1
+}
+
+// readHTTPBody reads the (possibly gzipped) request body
+func readHTTPBody(r *http.Request) ([]byte, error) {
+	var body io.Reader = http.MaxBytesReader(nil, r.Body, maxHTTPBody)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		// the decompressed body is limited too
+		body = io.LimitReader(gz, maxHTTPBody)
+	}
+	return ioutil.ReadAll(body)
+}
+
+// splitHTTPBody returns the lines in body: the entries of a JSON array, or
+// else its non-blank lines
+func splitHTTPBody(body []byte) []string {
+	var lines []string
+	if trimmed := bytes.TrimSpace(body); len(trimmed) != 0 && trimmed[0] == '[' {
+		var entries []json.RawMessage
+		if err := json.Unmarshal(trimmed, &entries); err == nil {
+			for _, entry := range entries {
+				var s string
+				if json.Unmarshal(entry, &s) == nil {
+					lines = append(lines, s)
+					continue
+				}
+				var buf bytes.Buffer
+				json.Compact(&buf, entry)
+				lines = append(lines, buf.String())
+			}
+			return lines
+		}
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(nil, maxHTTPBody)
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), "\r"); strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,101 @@
+package listen
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestSplitHTTPBody(t *testing.T) {
+	tests := []struct {
+		body     string
+		expected []string
+	}{
+		{"first\r\n\nsecond\nthird", []string{"first", "second", "third"}},
+		{`{"a":1}` + "\n" + `{"a":2}` + "\n", []string{`{"a":1}`, `{"a":2}`}},
+		{` [ {"a": 1}, "plain line", [1, 2] ] `, []string{`{"a":1}`, "plain line", "[1,2]"}},
+		// not a JSON array after all
+		{"[INFO] started\n[INFO] ready", []string{"[INFO] started", "[INFO] ready"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := splitHTTPBody([]byte(tt.body)); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("splitHTTPBody(%q) = %q, expected %q", tt.body, got, tt.expected)
+		}
+	}
+}
+
+func TestHTTPInput(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	abort := make(chan struct{})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := listenHTTP(l, "s3cret", nil, abort)
+	url := "http://" + l.Addr().String() + "/logs"
+
+	var received []string
+	done := make(chan struct{})
+	go func() {
+		for line := range lines {
+			received = append(received, line)
+		}
+		close(done)
+	}()
+	post := func(token string, gzipped bool, body string) int {
+		var buf bytes.Buffer
+		if gzipped {
+			gz := gzip.NewWriter(&buf)
+			gz.Write([]byte(body))
+			gz.Close()
+		} else {
+			buf.WriteString(body)
+		}
+		req, _ := http.NewRequest("POST", url, &buf)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if gzipped {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := post("s3cret", false, "one\ntwo\n"); code != http.StatusAccepted {
+		t.Errorf("got status %d, expected %d", code, http.StatusAccepted)
+	}
+	if code := post("s3cret", true, `["three"]`); code != http.StatusAccepted {
+		t.Errorf("got status %d for a gzipped body, expected %d", code, http.StatusAccepted)
+	}
+	for _, token := range []string{"", "wrong"} {
+		if code := post(token, false, "dropped"); code != http.StatusUnauthorized {
+			t.Errorf("got status %d with token %q, expected %d", code, token, http.StatusUnauthorized)
+		}
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("got status %d for a GET, expected %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+
+	close(abort)
+	<-done
+	if expected := []string{"one", "two", "three"}; !reflect.DeepEqual(received, expected) {
+		t.Errorf("received %q, expected %q", received, expected)
+	}
+}
@@ -0,0 +1,258 @@
+// Package listen implements network listeners for honeytail.
+//
+// Like the tail package, listen provides channels on which received data is
+// sent as string messages. Each --listen address gets its own channel, and
+// each message on the channel is handed to the configured parser as a line.
+package listen
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/ratelimit"
+	"github.com/honeycombio/honeytail/tail"
+)
+
+// maxDatagramSize is big enough for any UDP payload
+const maxDatagramSize = 65536
+
+type ListenOptions struct {
+	MIBDirs             []string `long:"mib_dir" description:"Directory containing MIB files used to translate OIDs in SNMP traps into names. May be specified multiple times"`
+	StatsdFlushInterval uint     `long:"statsd_flush_interval" description:"How frequently, in seconds, to send aggregated statsd metrics. 0 sends every metric as its own event" default:"10"`
+
+	RateLimit           float64 `long:"rate_limit" description:"Maximum number of messages per second to accept from any one sender. For UDP inputs a message is a datagram; for syslog-tcp it is a syslog message; for tcp it is a line; for http it is a request; for --proxy it is an event. Senders to a unix socket share one limit, with each line a message. 0 means no limit" default:"0"`
+	RateBurst           int     `long:"rate_burst" description:"Number of messages a sender may send at once before --listen.rate_limit applies" default:"100"`
+	OverLimit           string  `long:"over_limit" description:"What to do with messages from a sender over its rate limit: drop or sample. Both periodically log how much was dropped. sample is only supported by --proxy, as the other inputs can't record the sample rate of what they keep" default:"drop"`
+	ReusePort           bool    `long:"reuse_port" description:"Set SO_REUSEPORT on listening sockets, including --proxy, so several honeytail processes can share a port and the kernel spreads traffic between them"`
+	OverLimitSampleRate int     `long:"over_limit_samplerate" description:"When --listen.over_limit=sample, keep 1 / N of the events over the limit sent to --proxy, adjusting their sample rate to match" default:"10"`
+
+	MaxConns    int    `long:"max_conns" description:"Maximum number of connections the tcp and unix inputs may each have open at once. 0 means no limit" default:"0"`
+	MaxLine     int    `long:"max_line" description:"Longest line, or record, in bytes, accepted by the tcp and unix inputs. Connections sending longer lines are closed" default:"1048576"`
+	IdleTimeout int    `long:"idle_timeout" description:"Seconds a connection to the tcp and unix inputs may go without sending a line before it's closed. 0 means no limit" default:"0"`
+	SocketMode  string `long:"socket_mode" description:"Permissions, in octal, of the sockets created by the unix and unixgram inputs" default:"0666"`
+
+	RecordDelimiter      string `long:"record_delimiter" description:"String that separates the records sent to the tcp, unix and unixgram inputs, for senders that don't send one record per line. Escapes such as \\0 and \\x1e are interpreted"`
+	RecordDelimiterRegex string `long:"record_delimiter_regex" description:"Regular expression matching the separator between the records sent to the tcp, unix and unixgram inputs, for senders that don't send one record per line"`
+
+	HTTPToken string `long:"http_token" description:"Token that requests to the http and https inputs must send as 'Authorization: Bearer <token>'. If unset, any request is accepted"`
+
+	TLSCert     string `long:"tls_cert" description:"PEM file holding the certificate, and any intermediates, to present to syslog-tls senders and https clients"`
+	TLSKey      string `long:"tls_key" description:"PEM file holding the private key for --listen.tls_cert"`
+	TLSClientCA string `long:"tls_client_ca" description:"PEM file of CA certificates. If set, syslog-tls senders and https clients must present a client certificate signed by one of them"`
+}
+
+// RateLimitConfig returns the per-sender rate limit settings
+func (o ListenOptions) RateLimitConfig() ratelimit.Config {
+	return ratelimit.Config{
+		Rate:       o.RateLimit,
+		Burst:      o.RateBurst,
+		Policy:     o.OverLimit,
+		SampleRate: o.OverLimitSampleRate,
+	}
+}
+
+type Config struct {
+	// Addrs are the addresses to listen on, in the form scheme://host:port,
+	// or unix:///path and unixgram:///path for unix domain sockets
+	Addrs []string
+	// Listener specific options
+	Options ListenOptions
+}
+
+// packetHandler turns a single datagram into zero or more lines. conn is
+// provided so that handlers can reply to protocols that expect it.
+type packetHandler func(pkt []byte, peer *net.UDPAddr, conn *net.UDPConn) []string
+
+// GetSampledEntries wraps GetEntries and returns a list of channels that
+// provide sampled entries
+func GetSampledEntries(conf Config, sampleRate uint, abort <-chan struct{}) ([]chan string, error) {
+	linesChans, err := GetEntries(conf, abort)
+	if err != nil {
+		return nil, err
+	}
+	return tail.SampleAll(linesChans, sampleRate), nil
+}
+
+// GetEntries starts a listener for each configured address and returns one
+// channel per listener. Channels are closed once abort is closed.
+func GetEntries(conf Config, abort <-chan struct{}) ([]chan string, error) {
+	if len(conf.Addrs) == 0 {
+		return nil, errors.New("no addresses to listen on")
+	}
+	// lines carry no sample rate, so anything kept by sampling over the
+	// limit would be counted as a single message
+	if conf.Options.OverLimit == ratelimit.PolicySample {
+		return nil, errors.New("over limit policy sample isn't supported by --listen inputs")
+	}
+	linesChans := make([]chan string, 0, len(conf.Addrs))
+	limits, err := conf.Options.lineLimits()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range conf.Addrs {
+		limiter, err := ratelimit.New(addr, conf.Options.RateLimitConfig())
+		if err != nil {
+			return nil, err
+		}
+		if network, path, ok := unixPath(addr); ok {
+			lines, err := listenUnix(network, path, conf.Options.SocketMode, limits, limiter, abort)
+			if err != nil {
+				return nil, err
+			}
+			linesChans = append(linesChans, lines)
+			continue
+		}
+		scheme, hostPort, err := splitAddr(addr)
+		if err != nil {
+			return nil, err
+		}
+		// syslog and http are taken over TCP, with or without TLS
+		switch scheme {
+		case "tcp":
+			l, err := ListenTCP(hostPort, conf.Options.ReusePort)
+			if err != nil {
+				return nil, err
+			}
+			logrus.WithField("address", l.Addr()).Info("Listening for TCP connections")
+			linesChans = append(linesChans, listenLines(l, limits, tcpSender, limiter, abort))
+			continue
+		case "syslog-tcp", "syslog-tls", "http", "https":
+			var tlsConfig *tls.Config
+			if scheme == "syslog-tls" || scheme == "https" {
+				if tlsConfig, err = conf.Options.tlsConfig(); err != nil {
+					return nil, err
+				}
+			}
+			l, err := ListenTCP(hostPort, conf.Options.ReusePort)
+			if err != nil {
+				return nil, err
+			}
+			if tlsConfig != nil {
+				l = tls.NewListener(l, tlsConfig)
+			}
+			if scheme == "http" || scheme == "https" {
+				linesChans = append(linesChans, listenHTTP(l, conf.Options.HTTPToken, limiter, abort))
+			} else {
+				linesChans = append(linesChans, listenSyslogTCP(l, limiter, abort))
+			}
+			continue
+		}
+		conn, err := listenPacket(hostPort, conf.Options.ReusePort)
+		if err != nil {
+			return nil, err
+		}
+		var lines chan string
+		switch scheme {
+		case "snmp-trap":
+			mibs, err := loadMIBs(conf.Options.MIBDirs)
+			if err != nil {
+				return nil, err
+			}
+			lines, err = listenUDP(conn, limitHandler(newTrapHandler(mibs), limiter), abort)
+			if err != nil {
+				return nil, err
+			}
+		case "netflow":
+			lines, err = listenUDP(conn, limitHandler(newNetflowHandler(), limiter), abort)
+			if err != nil {
+				return nil, err
+			}
+		case "gelf":
+			lines, err = listenUDP(conn, limitHandler(newGELFHandler(), limiter), abort)
+			if err != nil {
+				return nil, err
+			}
+		case "statsd":
+			interval := time.Duration(conf.Options.StatsdFlushInterval) * time.Second
+			lines, err = listenStatsd(conn, interval, limiter, abort)
+			if err != nil {
+				return nil, err
+			}
+		case "sflow":
+			lines, err = listenUDP(conn, limitHandler(newSflowHandler(), limiter), abort)
+			if err != nil {
+				return nil, err
+			}
+		case "syslog-udp":
+			lines, err = listenUDP(conn, limitHandler(newSyslogHandler(), limiter), abort)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			conn.Close()
+			return nil, fmt.Errorf("unknown listener type %q in --listen=%s", scheme, addr)
+		}
+		linesChans = append(linesChans, lines)
+	}
+	return linesChans, nil
+}
+
+// limitHandler drops datagrams from senders over their rate limit before
+// they are decoded
+func limitHandler(handle packetHandler, limiter *ratelimit.Limiter) packetHandler {
+	if limiter == nil {
+		return handle
+	}
+	return func(pkt []byte, peer *net.UDPAddr, conn *net.UDPConn) []string {
+		if ok, _ := limiter.Allow(peer.IP.String()); !ok {
+			return nil
+		}
+		return handle(pkt, peer, conn)
+	}
+}
+
+// splitAddr breaks a scheme://host:port listen address into its parts
+func splitAddr(addr string) (string, string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", "", fmt.Errorf("listen address %q should be of the form scheme://host:port", addr)
+	}
+	return u.Scheme, u.Host, nil
+}
+
+// listenUDP hands each datagram received on conn to handle, sending the
+// resulting lines down the returned channel.
+func listenUDP(conn *net.UDPConn, handle packetHandler, abort <-chan struct{}) (chan string, error) {
+	logrus.WithField("address", conn.LocalAddr()).Info("Listening for UDP packets")
+
+	lines := make(chan string)
+	go func() {
+		// unblock ReadFromUDP when we're asked to stop
+		<-abort
+		conn.Close()
+	}()
+	go func() {
+		defer close(lines)
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, peer, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				select {
+				case <-abort:
+				default:
+					logrus.WithError(err).Warn("Error reading from UDP listener, shutting it down")
+				}
+				return
+			}
+			pkt := make([]byte, n)
+			copy(pkt, buf[:n])
+			for _, line := range handle(pkt, peer, conn) {
+				select {
+				case lines <- line:
+				case <-abort:
+					return
+				}
+			}
+		}
+	}()
+	return lines, nil
+}
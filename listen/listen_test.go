@@ -0,0 +1,155 @@
+package listen
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/ratelimit"
+)
+
+func TestSplitAddr(t *testing.T) {
+	scheme, hostPort, err := splitAddr("snmp-trap://0.0.0.0:162")
+	if err != nil || scheme != "snmp-trap" || hostPort != "0.0.0.0:162" {
+		t.Errorf("unexpected result %q %q %v", scheme, hostPort, err)
+	}
+	for _, bad := range []string{"0.0.0.0:162", "udp://", "%%"} {
+		if _, _, err := splitAddr(bad); err == nil {
+			t.Errorf("expected error splitting %q", bad)
+		}
+	}
+}
+
+func TestGetEntriesUnknownScheme(t *testing.T) {
+	_, err := GetEntries(Config{Addrs: []string{"carrier-pigeon://localhost:1"}}, nil)
+	if err == nil {
+		t.Error("expected error for unknown listener type")
+	}
+}
+
+func TestGetEntriesRejectsOverLimitSample(t *testing.T) {
+	conf := Config{
+		Addrs:   []string{"tcp://127.0.0.1:0"},
+		Options: ListenOptions{RateLimit: 1, OverLimit: "sample", OverLimitSampleRate: 10},
+	}
+	if _, err := GetEntries(conf, nil); err == nil {
+		t.Error("expected error for over limit sampling, which can't be recorded")
+	}
+}
+
+func TestAbortClosesListener(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	abort := make(chan struct{})
+	conf := Config{Addrs: []string{"snmp-trap://127.0.0.1:0"}}
+	chans, err := GetEntries(conf, abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chans) != 1 {
+		t.Fatalf("expected 1 channel, got %d", len(chans))
+	}
+	close(abort)
+	checkLinesChanClosed(t, chans[0])
+}
+
+func TestInformIsAcknowledged(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	abort := make(chan struct{})
+	defer close(abort)
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := server.LocalAddr().String()
+	lines, err := listenUDP(server, newTrapHandler(nil), abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	inform := v2Trap(pduInform)
+	if _, err := client.Write(inform); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case line := <-lines:
+		if line == "" {
+			t.Error("got empty line for inform")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for trap line")
+	}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp := make([]byte, len(inform)+10)
+	n, err := client.Read(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(inform) {
+		t.Fatalf("response length %d, expected %d", n, len(inform))
+	}
+	trap, err := decodeTrapPDU(resp[:n])
+	if err != nil || trap != pduGetResponse {
+		t.Errorf("expected GetResponse PDU, got 0x%x (%v)", trap, err)
+	}
+}
+
+// decodeTrapPDU returns the PDU tag of an SNMP message
+func decodeTrapPDU(pkt []byte) (byte, error) {
+	msg, _, err := readTLV(pkt)
+	if err != nil {
+		return 0, err
+	}
+	_, rest, err := readTLV(msg.value)
+	if err != nil {
+		return 0, err
+	}
+	_, rest, err = readTLV(rest)
+	if err != nil {
+		return 0, err
+	}
+	return rest[0], nil
+}
+
+func checkLinesChanClosed(t *testing.T, actual chan string) {
+	// this will block if actual never gets closed
+	for {
+		select {
+		case _, ok := <-actual:
+			if !ok {
+				return
+			}
+		case <-time.After(1 * time.Second):
+			t.Error("channel read timed out; channel not closed")
+			return
+		}
+	}
+}
+
+func TestLimitHandler(t *testing.T) {
+	limiter, err := ratelimit.New("test", ratelimit.Config{Rate: 1, Burst: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle := limitHandler(func(pkt []byte, peer *net.UDPAddr, conn *net.UDPConn) []string {
+		return []string{string(pkt)}
+	}, limiter)
+	noisy := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1000}
+	quiet := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 1000}
+	var got int
+	for i := 0; i < 10; i++ {
+		got += len(handle([]byte("x"), noisy, nil))
+	}
+	if got != 2 {
+		t.Errorf("expected the burst of 2 datagrams to get through, got %d", got)
+	}
+	if len(handle([]byte("x"), quiet, nil)) != 1 {
+		t.Error("another sender shouldn't be limited")
+	}
+}
@@ -0,0 +1,170 @@
+package listen
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// mibTree maps numeric OIDs to the names declared for them in MIB files
+type mibTree struct {
+	names map[string]string
+}
+
+// baseOIDs are the roots every MIB builds on, plus the handful of objects
+// that show up in every v2c trap, so that traps are readable even when no
+// MIB files are provided.
+var baseOIDs = map[string]string{
+	"iso":                   "1",
+	"org":                   "1.3",
+	"dod":                   "1.3.6",
+	"internet":              "1.3.6.1",
+	"directory":             "1.3.6.1.1",
+	"mgmt":                  "1.3.6.1.2",
+	"mib-2":                 "1.3.6.1.2.1",
+	"system":                "1.3.6.1.2.1.1",
+	"sysUpTime":             "1.3.6.1.2.1.1.3",
+	"interfaces":            "1.3.6.1.2.1.2",
+	"ifIndex":               "1.3.6.1.2.1.2.2.1.1",
+	"ifDescr":               "1.3.6.1.2.1.2.2.1.2",
+	"ifAdminStatus":         "1.3.6.1.2.1.2.2.1.7",
+	"ifOperStatus":          "1.3.6.1.2.1.2.2.1.8",
+	"experimental":          "1.3.6.1.3",
+	"private":               "1.3.6.1.4",
+	"enterprises":           "1.3.6.1.4.1",
+	"security":              "1.3.6.1.5",
+	"snmpV2":                "1.3.6.1.6",
+	"snmpModules":           "1.3.6.1.6.3",
+	"snmpTrapOID":           "1.3.6.1.6.3.1.1.4.1",
+	"coldStart":             "1.3.6.1.6.3.1.1.5.1",
+	"warmStart":             "1.3.6.1.6.3.1.1.5.2",
+	"linkDown":              "1.3.6.1.6.3.1.1.5.3",
+	"linkUp":                "1.3.6.1.6.3.1.1.5.4",
+	"authenticationFailure": "1.3.6.1.6.3.1.1.5.5",
+}
+
+var (
+	// matches `name <MACRO> ... ::= { parent 1 }` assignments
+	reMIBAssignment = regexp.MustCompile(`(?s)([a-zA-Z][\w-]*)\s+(?:OBJECT-TYPE|OBJECT\s+IDENTIFIER|OBJECT-IDENTITY|MODULE-IDENTITY|NOTIFICATION-TYPE|OBJECT-GROUP|NOTIFICATION-GROUP|MODULE-COMPLIANCE)\b.*?::=\s*\{([^}]*)\}`)
+	reMIBComment    = regexp.MustCompile(`--[^\n]*`)
+	// a component of an OID value is either a number or name(number)
+	reMIBComponent = regexp.MustCompile(`^(?:[a-zA-Z][\w-]*\()?(\d+)\)?$`)
+)
+
+// loadMIBs reads every file in dirs and collects the OID assignments in them
+func loadMIBs(dirs []string) (*mibTree, error) {
+	// name -> {parent, suffix} as declared, resolved once everything is read
+	declared := map[string][]string{}
+	for _, dir := range dirs {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range files {
+			if fi.IsDir() {
+				continue
+			}
+			content, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+			if err != nil {
+				if os.IsPermission(err) {
+					logrus.WithField("file", fi.Name()).Warn("skipping unreadable MIB file")
+					continue
+				}
+				return nil, err
+			}
+			parseMIB(string(content), declared)
+		}
+	}
+
+	resolved := make(map[string]string, len(baseOIDs)+len(declared))
+	for name, oid := range baseOIDs {
+		resolved[name] = oid
+	}
+	var resolve func(name string, depth int) (string, bool)
+	resolve = func(name string, depth int) (string, bool) {
+		if oid, ok := resolved[name]; ok {
+			return oid, true
+		}
+		parts, ok := declared[name]
+		if !ok || depth > 64 {
+			return "", false
+		}
+		oid := strings.Join(parts[1:], ".")
+		if parts[0] != "" {
+			parent, ok := resolve(parts[0], depth+1)
+			if !ok {
+				return "", false
+			}
+			oid = parent + "." + oid
+		}
+		resolved[name] = oid
+		return oid, true
+	}
+	for name := range declared {
+		if _, ok := resolve(name, 0); !ok {
+			logrus.WithField("name", name).Debug("unable to resolve MIB object to an OID")
+		}
+	}
+
+	tree := &mibTree{names: make(map[string]string, len(resolved))}
+	for name, oid := range resolved {
+		tree.names[oid] = name
+	}
+	return tree, nil
+}
+
+// parseMIB adds the OID assignments found in a MIB module to declared
+func parseMIB(content string, declared map[string][]string) {
+	content = reMIBComment.ReplaceAllString(content, "")
+	for _, m := range reMIBAssignment.FindAllStringSubmatch(content, -1) {
+		tokens := strings.Fields(m[2])
+		if len(tokens) < 2 {
+			continue
+		}
+		parts := []string{tokens[0]}
+		if mg := reMIBComponent.FindStringSubmatch(tokens[0]); mg != nil {
+			// a value starting with a number, eg { 1 3 6 }, is rooted at nothing
+			parts = []string{"", mg[1]}
+		}
+		ok := true
+		for _, tok := range tokens[1:] {
+			mg := reMIBComponent.FindStringSubmatch(tok)
+			if mg == nil {
+				ok = false
+				break
+			}
+			parts = append(parts, mg[1])
+		}
+		if ok {
+			declared[m[1]] = parts
+		}
+	}
+}
+
+// name translates a numeric OID into the most specific name known for it,
+// with any trailing index left numeric (eg ifIndex.2). Unknown OIDs are
+// returned unchanged.
+func (t *mibTree) name(oid string) string {
+	if t == nil {
+		return oid
+	}
+	for prefix := oid; prefix != ""; {
+		if name, ok := t.names[prefix]; ok {
+			return name + strings.TrimPrefix(oid, prefix)
+		}
+		idx := strings.LastIndexByte(prefix, '.')
+		if idx == -1 {
+			break
+		}
+		if _, err := strconv.Atoi(prefix[idx+1:]); err != nil {
+			break
+		}
+		prefix = prefix[:idx]
+	}
+	return oid
+}
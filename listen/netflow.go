@@ -0,0 +1,366 @@
+package listen
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Field names shared by the NetFlow, IPFIX and sFlow decoders
+const (
+	flowTypeKey        = "flow_type"
+	srcAddrKey         = "src_addr"
+	dstAddrKey         = "dst_addr"
+	srcPortKey         = "src_port"
+	dstPortKey         = "dst_port"
+	protocolKey        = "protocol"
+	bytesKey           = "bytes"
+	packetsKey         = "packets"
+	durationKey        = "duration_ms"
+	exporterKey        = "exporter"
+	inputInterfaceKey  = "input_interface"
+	outputInterfaceKey = "output_interface"
+	tcpFlagsKey        = "tcp_flags"
+)
+
+// ipfixFields names the information elements we know about. NetFlow v9
+// field types below 128 are the same as the IPFIX ones.
+// https://www.iana.org/assignments/ipfix/ipfix.xhtml
+var ipfixFields = map[uint16]string{
+	1:   bytesKey,
+	2:   packetsKey,
+	4:   protocolKey,
+	5:   "tos",
+	6:   tcpFlagsKey,
+	7:   srcPortKey,
+	8:   srcAddrKey,
+	9:   "src_mask",
+	10:  inputInterfaceKey,
+	11:  dstPortKey,
+	12:  dstAddrKey,
+	13:  "dst_mask",
+	14:  outputInterfaceKey,
+	15:  "next_hop",
+	16:  "src_as",
+	17:  "dst_as",
+	21:  "last_switched",
+	22:  "first_switched",
+	27:  srcAddrKey,
+	28:  dstAddrKey,
+	58:  "vlan_id",
+	61:  "direction",
+	62:  "next_hop",
+	85:  bytesKey,
+	86:  packetsKey,
+	136: "flow_end_reason",
+	150: "flow_start_seconds",
+	151: "flow_end_seconds",
+	152: "flow_start_milliseconds",
+	153: "flow_end_milliseconds",
+}
+
+// addressFields are decoded as IP addresses rather than integers
+var addressFields = map[uint16]bool{8: true, 12: true, 15: true, 27: true, 28: true, 62: true}
+
+type templateField struct {
+	id         uint16
+	length     uint16
+	enterprise bool
+}
+
+// netflowDecoder decodes NetFlow v5, v9 and IPFIX datagrams. It caches the
+// templates announced by v9 and IPFIX exporters so that later data records
+// can be decoded.
+type netflowDecoder struct {
+	templates map[string][]templateField
+}
+
+func newNetflowHandler() packetHandler {
+	d := &netflowDecoder{templates: make(map[string][]templateField)}
+	return func(pkt []byte, peer *net.UDPAddr, conn *net.UDPConn) []string {
+		flows, err := d.decode(pkt, peer.IP.String())
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"peer":  peer.String(),
+				"error": err,
+			}).Debug("skipping packet; failed to decode flow datagram")
+		}
+		return flowsToLines(flows)
+	}
+}
+
+// flowsToLines encodes each flow as a JSON line
+func flowsToLines(flows []map[string]interface{}) []string {
+	lines := make([]string, 0, len(flows))
+	for _, flow := range flows {
+		line, err := json.Marshal(flow)
+		if err != nil {
+			logrus.WithError(err).Debug("failed to encode flow")
+			continue
+		}
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+func (d *netflowDecoder) decode(pkt []byte, exporter string) ([]map[string]interface{}, error) {
+	if len(pkt) < 2 {
+		return nil, errors.New("datagram too short")
+	}
+	switch version := binary.BigEndian.Uint16(pkt); version {
+	case 5:
+		return decodeNetflowV5(pkt, exporter)
+	case 9:
+		return d.decodeNetflowV9(pkt, exporter)
+	case 10:
+		return d.decodeIPFIX(pkt, exporter)
+	default:
+		return nil, fmt.Errorf("unsupported NetFlow version %d", version)
+	}
+}
+
+func decodeNetflowV5(pkt []byte, exporter string) ([]map[string]interface{}, error) {
+	const headerLen, recordLen = 24, 48
+	if len(pkt) < headerLen {
+		return nil, errors.New("NetFlow v5 header too short")
+	}
+	count := int(binary.BigEndian.Uint16(pkt[2:]))
+	sysUptime := binary.BigEndian.Uint32(pkt[4:])
+	exportTime := time.Unix(int64(binary.BigEndian.Uint32(pkt[8:])), int64(binary.BigEndian.Uint32(pkt[12:])))
+	if len(pkt) < headerLen+count*recordLen {
+		return nil, errors.New("NetFlow v5 datagram shorter than its record count")
+	}
+	flows := make([]map[string]interface{}, 0, count)
+	for i := 0; i < count; i++ {
+		r := pkt[headerLen+i*recordLen:]
+		first := binary.BigEndian.Uint32(r[24:])
+		last := binary.BigEndian.Uint32(r[28:])
+		flows = append(flows, map[string]interface{}{
+			"timestamp":        uptimeToTime(exportTime, sysUptime, first).Format(time.RFC3339Nano),
+			flowTypeKey:        "netflow_v5",
+			exporterKey:        exporter,
+			srcAddrKey:         net.IP(r[0:4]).String(),
+			dstAddrKey:         net.IP(r[4:8]).String(),
+			"next_hop":         net.IP(r[8:12]).String(),
+			inputInterfaceKey:  int64(binary.BigEndian.Uint16(r[12:])),
+			outputInterfaceKey: int64(binary.BigEndian.Uint16(r[14:])),
+			packetsKey:         int64(binary.BigEndian.Uint32(r[16:])),
+			bytesKey:           int64(binary.BigEndian.Uint32(r[20:])),
+			durationKey:        int64(last - first),
+			srcPortKey:         int64(binary.BigEndian.Uint16(r[32:])),
+			dstPortKey:         int64(binary.BigEndian.Uint16(r[34:])),
+			tcpFlagsKey:        int64(r[37]),
+			protocolKey:        int64(r[38]),
+			"tos":              int64(r[39]),
+			"src_as":           int64(binary.BigEndian.Uint16(r[40:])),
+			"dst_as":           int64(binary.BigEndian.Uint16(r[42:])),
+			"src_mask":         int64(r[44]),
+			"dst_mask":         int64(r[45]),
+		})
+	}
+	return flows, nil
+}
+
+// uptimeToTime converts a router uptime in milliseconds into wall clock time
+// using the export time and uptime from the datagram header
+func uptimeToTime(exportTime time.Time, sysUptime, uptime uint32) time.Time {
+	return exportTime.Add(-time.Duration(int64(sysUptime)-int64(uptime)) * time.Millisecond).UTC()
+}
+
+func (d *netflowDecoder) decodeNetflowV9(pkt []byte, exporter string) ([]map[string]interface{}, error) {
+	const headerLen = 20
+	if len(pkt) < headerLen {
+		return nil, errors.New("NetFlow v9 header too short")
+	}
+	sysUptime := binary.BigEndian.Uint32(pkt[4:])
+	exportTime := time.Unix(int64(binary.BigEndian.Uint32(pkt[8:])), 0)
+	sourceID := binary.BigEndian.Uint32(pkt[16:])
+	return d.decodeSets(pkt[headerLen:], exporter, sourceID, exportTime, 9, func(flow map[string]interface{}) {
+		first, fok := flow["first_switched"].(int64)
+		last, lok := flow["last_switched"].(int64)
+		if fok && lok {
+			flow["timestamp"] = uptimeToTime(exportTime, sysUptime, uint32(first)).Format(time.RFC3339Nano)
+			flow[durationKey] = last - first
+		}
+		flow[flowTypeKey] = "netflow_v9"
+	})
+}
+
+func (d *netflowDecoder) decodeIPFIX(pkt []byte, exporter string) ([]map[string]interface{}, error) {
+	const headerLen = 16
+	if len(pkt) < headerLen {
+		return nil, errors.New("IPFIX header too short")
+	}
+	msgLen := int(binary.BigEndian.Uint16(pkt[2:]))
+	if msgLen < headerLen || msgLen > len(pkt) {
+		return nil, errors.New("IPFIX message length is invalid")
+	}
+	exportTime := time.Unix(int64(binary.BigEndian.Uint32(pkt[4:])), 0)
+	domainID := binary.BigEndian.Uint32(pkt[12:])
+	return d.decodeSets(pkt[headerLen:msgLen], exporter, domainID, exportTime, 10, func(flow map[string]interface{}) {
+		start, sok := flow["flow_start_milliseconds"].(int64)
+		end, eok := flow["flow_end_milliseconds"].(int64)
+		if sok && eok {
+			flow["timestamp"] = time.Unix(0, start*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano)
+			flow[durationKey] = end - start
+		} else if start, ok := flow["flow_start_seconds"].(int64); ok {
+			flow["timestamp"] = time.Unix(start, 0).UTC().Format(time.RFC3339Nano)
+			if end, ok := flow["flow_end_seconds"].(int64); ok {
+				flow[durationKey] = (end - start) * 1000
+			}
+		}
+		flow[flowTypeKey] = "ipfix"
+	})
+}
+
+// decodeSets walks the (flow)sets of a v9 or IPFIX message, caching
+// templates and decoding data records with them. finish is called on each
+// decoded flow to fill in version specific fields.
+func (d *netflowDecoder) decodeSets(b []byte, exporter string, domain uint32, exportTime time.Time,
+	version int, finish func(map[string]interface{})) ([]map[string]interface{}, error) {
+	templateSetID, optionsSetID := uint16(0), uint16(1)
+	if version == 10 {
+		templateSetID, optionsSetID = 2, 3
+	}
+	var flows []map[string]interface{}
+	for len(b) >= 4 {
+		setID := binary.BigEndian.Uint16(b)
+		setLen := int(binary.BigEndian.Uint16(b[2:]))
+		if setLen < 4 || setLen > len(b) {
+			return flows, errors.New("flow set length is invalid")
+		}
+		body := b[4:setLen]
+		b = b[setLen:]
+		switch {
+		case setID == templateSetID:
+			if err := d.readTemplates(body, exporter, domain, version); err != nil {
+				return flows, err
+			}
+		case setID == optionsSetID:
+			// options templates describe exporter metadata, not flows
+		case setID >= 256:
+			key := templateKey(exporter, domain, setID)
+			tmpl, ok := d.templates[key]
+			if !ok {
+				logrus.WithFields(logrus.Fields{
+					"exporter":    exporter,
+					"template_id": setID,
+				}).Debug("skipping data set for template we haven't seen yet")
+				continue
+			}
+			for {
+				flow, n := decodeRecord(body, tmpl)
+				if n == 0 {
+					break
+				}
+				body = body[n:]
+				flow["timestamp"] = exportTime.UTC().Format(time.RFC3339Nano)
+				flow[exporterKey] = exporter
+				finish(flow)
+				flows = append(flows, flow)
+			}
+		}
+	}
+	return flows, nil
+}
+
+func templateKey(exporter string, domain uint32, id uint16) string {
+	return fmt.Sprintf("%s/%d/%d", exporter, domain, id)
+}
+
+func (d *netflowDecoder) readTemplates(b []byte, exporter string, domain uint32, version int) error {
+	for len(b) >= 4 {
+		id := binary.BigEndian.Uint16(b)
+		count := int(binary.BigEndian.Uint16(b[2:]))
+		b = b[4:]
+		fields := make([]templateField, 0, count)
+		for i := 0; i < count; i++ {
+			if len(b) < 4 {
+				return errors.New("template is truncated")
+			}
+			f := templateField{
+				id:     binary.BigEndian.Uint16(b),
+				length: binary.BigEndian.Uint16(b[2:]),
+			}
+			b = b[4:]
+			if version == 10 && f.id&0x8000 != 0 {
+				// enterprise specific element; skip the enterprise number
+				if len(b) < 4 {
+					return errors.New("template is truncated")
+				}
+				f.id &^= 0x8000
+				f.enterprise = true
+				b = b[4:]
+			}
+			fields = append(fields, f)
+		}
+		d.templates[templateKey(exporter, domain, id)] = fields
+	}
+	return nil
+}
+
+// decodeRecord decodes one data record using tmpl and returns the number of
+// bytes consumed, or 0 if there isn't a full record left (ie. padding).
+func decodeRecord(b []byte, tmpl []templateField) (map[string]interface{}, int) {
+	if len(b) == 0 {
+		return nil, 0
+	}
+	flow := make(map[string]interface{}, len(tmpl))
+	offset := 0
+	for _, f := range tmpl {
+		length := int(f.length)
+		if length == 0xffff {
+			// IPFIX variable length element
+			if offset >= len(b) {
+				return nil, 0
+			}
+			length = int(b[offset])
+			offset++
+			if length == 0xff {
+				if offset+2 > len(b) {
+					return nil, 0
+				}
+				length = int(binary.BigEndian.Uint16(b[offset:]))
+				offset += 2
+			}
+		}
+		if offset+length > len(b) {
+			return nil, 0
+		}
+		val := b[offset : offset+length]
+		offset += length
+
+		name, known := ipfixFields[f.id]
+		if f.enterprise || !known {
+			name = fmt.Sprintf("field_%d", f.id)
+			if f.enterprise {
+				name = "enterprise_" + name
+			}
+		}
+		switch {
+		case f.length == 0xffff:
+			// variable length elements are strings or opaque blobs
+			flow[name] = berValue{tag: tagOctetString, value: val}.toInterface()
+		case addressFields[f.id] && !f.enterprise && (length == 4 || length == 16):
+			flow[name] = net.IP(val).String()
+		case length <= 8:
+			var n uint64
+			for _, c := range val {
+				n = n<<8 | uint64(c)
+			}
+			flow[name] = int64(n)
+		default:
+			flow[name] = hex.EncodeToString(val)
+		}
+	}
+	if offset == 0 {
+		return nil, 0
+	}
+	return flow, offset
+}
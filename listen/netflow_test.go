@@ -0,0 +1,179 @@
+package listen
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// be builds big endian test packets out of a mix of field sizes
+type be []byte
+
+func (b be) u8(v uint8) be   { return append(b, v) }
+func (b be) u16(v uint16) be { return append(b, byte(v>>8), byte(v)) }
+func (b be) u32(v uint32) be {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+func (b be) raw(v ...byte) be { return append(b, v...) }
+
+func TestNetflowV5(t *testing.T) {
+	pkt := be{}.u16(5).u16(1).u32(10000).u32(1497291707).u32(0).u32(1).u8(0).u8(0).u16(0)
+	pkt = pkt.raw(10, 0, 0, 1).raw(10, 0, 0, 2).raw(0, 0, 0, 0).
+		u16(1).u16(2).u32(10).u32(1500).u32(8000).u32(9500).
+		u16(12345).u16(443).u8(0).u8(0x12).u8(6).u8(0).
+		u16(100).u16(200).u8(24).u8(16).u16(0)
+	d := &netflowDecoder{templates: map[string][]templateField{}}
+	flows, err := d.decode(pkt, "192.168.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows) != 1 {
+		t.Fatalf("expected 1 flow, got %d", len(flows))
+	}
+	expected := map[string]interface{}{
+		"timestamp":        time.Unix(1497291705, 0).UTC().Format(time.RFC3339Nano),
+		"flow_type":        "netflow_v5",
+		"exporter":         "192.168.0.1",
+		"src_addr":         "10.0.0.1",
+		"dst_addr":         "10.0.0.2",
+		"next_hop":         "0.0.0.0",
+		"input_interface":  int64(1),
+		"output_interface": int64(2),
+		"packets":          int64(10),
+		"bytes":            int64(1500),
+		"duration_ms":      int64(1500),
+		"src_port":         int64(12345),
+		"dst_port":         int64(443),
+		"tcp_flags":        int64(0x12),
+		"protocol":         int64(6),
+		"tos":              int64(0),
+		"src_as":           int64(100),
+		"dst_as":           int64(200),
+		"src_mask":         int64(24),
+		"dst_mask":         int64(16),
+	}
+	if !reflect.DeepEqual(flows[0], expected) {
+		t.Errorf("got %+v, expected %+v", flows[0], expected)
+	}
+	if _, err := d.decode(pkt[:30], "x"); err == nil {
+		t.Error("expected error for truncated v5 datagram")
+	}
+}
+
+func TestNetflowV9Templates(t *testing.T) {
+	d := &netflowDecoder{templates: map[string][]templateField{}}
+	header := be{}.u16(9).u16(1).u32(10000).u32(1497291707).u32(1).u32(42)
+	data := be{}.u16(256).u16(4+14+2). // includes 2 bytes of padding
+						raw(10, 0, 0, 1).raw(10, 0, 0, 2).u16(53).u32(9000).raw(0, 0)
+	// data before the template can't be decoded
+	flows, err := d.decode(append(append(be{}, header...), data...), "192.168.0.1")
+	if err != nil || len(flows) != 0 {
+		t.Fatalf("expected no flows before template, got %v %v", flows, err)
+	}
+	tmpl := be{}.u16(0).u16(4 + 4 + 4*4).u16(256).u16(4).
+		u16(8).u16(4).u16(12).u16(4).u16(11).u16(2).u16(22).u16(4)
+	pkt := append(append(append(be{}, header...), tmpl...), data...)
+	flows, err = d.decode(pkt, "192.168.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows) != 1 {
+		t.Fatalf("expected 1 flow, got %d", len(flows))
+	}
+	f := flows[0]
+	if f["src_addr"] != "10.0.0.1" || f["dst_addr"] != "10.0.0.2" || f["dst_port"] != int64(53) ||
+		f["first_switched"] != int64(9000) || f["flow_type"] != "netflow_v9" {
+		t.Errorf("unexpected flow %+v", f)
+	}
+	// a different exporter with the same source id doesn't share templates
+	if flows, _ := d.decode(append(append(be{}, header...), data...), "192.168.0.2"); len(flows) != 0 {
+		t.Errorf("templates leaked between exporters: %v", flows)
+	}
+}
+
+func TestIPFIX(t *testing.T) {
+	d := &netflowDecoder{templates: map[string][]templateField{}}
+	tmpl := be{}.u16(2).u16(4 + 4 + 4*4 + 4).u16(300).u16(4).
+		u16(27).u16(16).
+		u16(152).u16(8).
+		u16(153).u16(8).
+		u16(0x8000 | 1).u16(0xffff).u32(9999) // enterprise specific, variable length
+	data := be{}.u16(300).u16(4+16+8+8+1+3).
+		raw(0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1).
+		raw(0, 0, 1, 0x5c, 0x9d, 0xc1, 0x1f, 0x38).
+		raw(0, 0, 1, 0x5c, 0x9d, 0xc1, 0x23, 0x20).
+		u8(3).raw('a', 'b', 'c')
+	body := append(append(be{}, tmpl...), data...)
+	header := be{}.u16(10).u16(uint16(16 + len(body))).u32(1497291707).u32(1).u32(7)
+	flows, err := d.decode(append(header, body...), "192.168.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows) != 1 {
+		t.Fatalf("expected 1 flow, got %d", len(flows))
+	}
+	f := flows[0]
+	start := int64(binary.BigEndian.Uint64([]byte{0, 0, 1, 0x5c, 0x9d, 0xc1, 0x1f, 0x38}))
+	expectedTS := time.Unix(0, start*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano)
+	if f["src_addr"] != "2001:db8::1" || f["duration_ms"] != int64(1000) || f["timestamp"] != expectedTS ||
+		f["enterprise_field_1"] != "abc" || f["flow_type"] != "ipfix" {
+		t.Errorf("unexpected flow %+v", f)
+	}
+}
+
+func TestSflow(t *testing.T) {
+	// ethernet + IPv4 + TCP header
+	frame := be{}.raw(1, 2, 3, 4, 5, 6).raw(7, 8, 9, 10, 11, 12).u16(etherTypeIPv4).
+		u8(0x45).u8(0).u16(60).u32(0).u8(64).u8(6).u16(0).
+		raw(10, 1, 1, 1).raw(10, 2, 2, 2).
+		u16(5555).u16(80).u32(0).u32(0).u8(0x50).u8(0x02).u16(0)
+	headerLen := len(frame)
+	for len(frame)%4 != 0 {
+		frame = frame.u8(0)
+	}
+	record := be{}.u32(sflowRawPacketHeader).u32(uint32(16 + len(frame))).
+		u32(sflowHeaderEthernet).u32(1514).u32(4).u32(uint32(headerLen))
+	record = append(record, frame...)
+	sample := be{}.u32(1).u32(3).u32(512).u32(1000).u32(0).u32(5).u32(6).u32(1)
+	sample = append(sample, record...)
+	counters := be{}.u32(2).u32(4).u32(0)
+	pkt := be{}.u32(5).u32(1).raw(192, 168, 0, 9).u32(0).u32(1).u32(1000).u32(2)
+	pkt = append(append(pkt, counters...), be{}.u32(sflowFlowSample).u32(uint32(len(sample)))...)
+	pkt = append(pkt, sample...)
+
+	flows, err := decodeSflow(pkt, "192.168.0.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows) != 1 {
+		t.Fatalf("expected 1 flow, got %d", len(flows))
+	}
+	f := flows[0]
+	delete(f, "timestamp")
+	expected := map[string]interface{}{
+		"flow_type":        "sflow",
+		"exporter":         "192.168.0.9",
+		"agent_address":    "192.168.0.9",
+		"sampling_rate":    int64(512),
+		"input_interface":  int64(5),
+		"output_interface": int64(6),
+		"bytes":            int64(1514),
+		"packets":          int64(1),
+		"src_mac":          "07:08:09:0a:0b:0c",
+		"dst_mac":          "01:02:03:04:05:06",
+		"src_addr":         "10.1.1.1",
+		"dst_addr":         "10.2.2.2",
+		"tos":              int64(0),
+		"protocol":         int64(6),
+		"src_port":         int64(5555),
+		"dst_port":         int64(80),
+		"tcp_flags":        int64(2),
+	}
+	if !reflect.DeepEqual(f, expected) {
+		t.Errorf("got %+v, expected %+v", f, expected)
+	}
+	if _, err := decodeSflow(pkt[:len(pkt)-8], "x"); err == nil {
+		t.Error("expected error decoding truncated sFlow datagram")
+	}
+}
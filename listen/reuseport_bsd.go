@@ -0,0 +1,8 @@
+//go:build darwin || freebsd
+// +build darwin freebsd
+
+package listen
+
+import "golang.org/x/sys/unix"
+
+const soReusePort = unix.SO_REUSEPORT
@@ -0,0 +1,5 @@
+package listen
+
+// soReusePort is SO_REUSEPORT, which the vendored x/sys/unix doesn't define
+// for linux. It has this value on every architecture we build for.
+const soReusePort = 0xf
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package listen
+
+import (
+	"net"
+	"os"
+)
+
+func reusePortSocket(typ socketType, ip net.IP, port int, zone string) (*os.File, error) {
+	return nil, errReusePortUnsupported
+}
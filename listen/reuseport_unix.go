@@ -0,0 +1,88 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package listen
+
+import (
+	"net"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// reusePortSocket creates a bound socket with SO_REUSEPORT set. The socket
+// is dual-stack if ip is unspecified and not an IPv4 address.
+func reusePortSocket(typ socketType, ip net.IP, port int, zone string) (*os.File, error) {
+	family := unix.AF_INET6
+	if ip4 := ip.To4(); ip4 != nil {
+		family = unix.AF_INET
+	}
+	sotype := unix.SOCK_DGRAM
+	if typ == socketTCP {
+		sotype = unix.SOCK_STREAM
+	}
+	fd, err := unix.Socket(family, sotype, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := setupReusePortSocket(fd, family, typ, ip, port, zone); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), fileName(ip, port)), nil
+}
+
+func setupReusePortSocket(fd, family int, typ socketType, ip net.IP, port int, zone string) error {
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+		return os.NewSyscallError("setsockopt", err)
+	}
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, soReusePort, 1); err != nil {
+		return os.NewSyscallError("setsockopt", err)
+	}
+	var sa unix.Sockaddr
+	if family == unix.AF_INET {
+		sa4 := &unix.SockaddrInet4{Port: port}
+		copy(sa4.Addr[:], ip.To4())
+		sa = sa4
+	} else {
+		// accept IPv4 too when listening on all addresses
+		v6only := 1
+		if ip == nil || ip.IsUnspecified() {
+			v6only = 0
+		}
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_V6ONLY, v6only); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+		sa6 := &unix.SockaddrInet6{Port: port}
+		if ip != nil {
+			copy(sa6.Addr[:], ip.To16())
+		}
+		if zone != "" {
+			ifi, err := net.InterfaceByName(zone)
+			if err != nil {
+				return err
+			}
+			sa6.ZoneId = uint32(ifi.Index)
+		}
+		sa = sa6
+	}
+	if err := unix.Bind(fd, sa); err != nil {
+		return os.NewSyscallError("bind", err)
+	}
+	if typ == socketTCP {
+		if err := unix.Listen(fd, unix.SOMAXCONN); err != nil {
+			return os.NewSyscallError("listen", err)
+		}
+	}
+	return nil
+}
+
+// fileName names the socket's *os.File, for error messages
+func fileName(ip net.IP, port int) string {
+	host := ""
+	if ip != nil {
+		host = ip.String()
+	}
+	return "listener " + net.JoinHostPort(host, strconv.Itoa(port))
+}
@@ -0,0 +1,222 @@
+package listen
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// sFlow v5 structure formats we decode
+// https://sflow.org/sflow_version_5.txt
+const (
+	sflowFlowSample         = 1
+	sflowExpandedFlowSample = 3
+	sflowRawPacketHeader    = 1
+	sflowHeaderEthernet     = 1
+	sflowHeaderIPv4         = 11
+	sflowHeaderIPv6         = 12
+
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+)
+
+func newSflowHandler() packetHandler {
+	return func(pkt []byte, peer *net.UDPAddr, conn *net.UDPConn) []string {
+		flows, err := decodeSflow(pkt, peer.IP.String())
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"peer":  peer.String(),
+				"error": err,
+			}).Debug("skipping packet; failed to decode sFlow datagram")
+		}
+		return flowsToLines(flows)
+	}
+}
+
+// xdrReader reads the big endian 32 bit words sFlow is made of
+type xdrReader struct {
+	b   []byte
+	err error
+}
+
+func (r *xdrReader) uint32() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.b) < 4 {
+		r.err = errors.New("sFlow datagram is truncated")
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+// bytes reads n bytes, skipping the padding out to a 4 byte boundary
+func (r *xdrReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	padded := (n + 3) &^ 3
+	if n < 0 || len(r.b) < padded {
+		r.err = errors.New("sFlow datagram is truncated")
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[padded:]
+	return v
+}
+
+func decodeSflow(pkt []byte, exporter string) ([]map[string]interface{}, error) {
+	r := &xdrReader{b: pkt}
+	if version := r.uint32(); r.err == nil && version != 5 {
+		return nil, fmt.Errorf("unsupported sFlow version %d", version)
+	}
+	var agent net.IP
+	switch r.uint32() {
+	case 1:
+		agent = net.IP(r.bytes(4))
+	case 2:
+		agent = net.IP(r.bytes(16))
+	default:
+		if r.err == nil {
+			return nil, errors.New("unknown sFlow agent address type")
+		}
+	}
+	r.uint32() // sub agent id
+	r.uint32() // sequence number
+	r.uint32() // uptime
+	numSamples := int(r.uint32())
+	if r.err != nil {
+		return nil, r.err
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	var flows []map[string]interface{}
+	for i := 0; i < numSamples && r.err == nil; i++ {
+		format := r.uint32() & 0xfff // the top 20 bits are the enterprise
+		sample := &xdrReader{b: r.bytes(int(r.uint32()))}
+		if r.err != nil {
+			break
+		}
+		if format != sflowFlowSample && format != sflowExpandedFlowSample {
+			// counter samples and other structures aren't flows
+			continue
+		}
+		sample.uint32() // sequence number
+		if format == sflowExpandedFlowSample {
+			sample.uint32() // source id type
+		}
+		sample.uint32() // source id index
+		samplingRate := sample.uint32()
+		sample.uint32() // sample pool
+		sample.uint32() // drops
+		var input, output uint32
+		if format == sflowExpandedFlowSample {
+			sample.uint32()
+			input = sample.uint32()
+			sample.uint32()
+			output = sample.uint32()
+		} else {
+			input = sample.uint32() & 0x3fffffff
+			output = sample.uint32() & 0x3fffffff
+		}
+		numRecords := int(sample.uint32())
+		for j := 0; j < numRecords && sample.err == nil; j++ {
+			recFormat := sample.uint32() & 0xfff
+			rec := &xdrReader{b: sample.bytes(int(sample.uint32()))}
+			if sample.err != nil || recFormat != sflowRawPacketHeader {
+				continue
+			}
+			proto := rec.uint32()
+			frameLen := rec.uint32()
+			rec.uint32() // bytes stripped
+			header := rec.bytes(int(rec.uint32()))
+			if rec.err != nil {
+				continue
+			}
+			flow := map[string]interface{}{
+				"timestamp":        now,
+				flowTypeKey:        "sflow",
+				exporterKey:        exporter,
+				"agent_address":    agent.String(),
+				"sampling_rate":    int64(samplingRate),
+				inputInterfaceKey:  int64(input),
+				outputInterfaceKey: int64(output),
+				bytesKey:           int64(frameLen),
+				packetsKey:         int64(1),
+			}
+			decodePacketHeader(proto, header, flow)
+			flows = append(flows, flow)
+		}
+	}
+	return flows, r.err
+}
+
+// decodePacketHeader pulls addresses, protocol and ports out of the sampled
+// packet header
+func decodePacketHeader(proto uint32, b []byte, flow map[string]interface{}) {
+	var etherType uint16
+	switch proto {
+	case sflowHeaderEthernet:
+		if len(b) < 14 {
+			return
+		}
+		flow["src_mac"] = net.HardwareAddr(b[6:12]).String()
+		flow["dst_mac"] = net.HardwareAddr(b[0:6]).String()
+		etherType = binary.BigEndian.Uint16(b[12:])
+		b = b[14:]
+		if etherType == etherTypeVLAN && len(b) >= 4 {
+			flow["vlan_id"] = int64(binary.BigEndian.Uint16(b) & 0x0fff)
+			etherType = binary.BigEndian.Uint16(b[2:])
+			b = b[4:]
+		}
+	case sflowHeaderIPv4:
+		etherType = etherTypeIPv4
+	case sflowHeaderIPv6:
+		etherType = etherTypeIPv6
+	default:
+		return
+	}
+
+	var transport []byte
+	var ipProto byte
+	switch etherType {
+	case etherTypeIPv4:
+		if len(b) < 20 {
+			return
+		}
+		ihl := int(b[0]&0x0f) * 4
+		ipProto = b[9]
+		flow[srcAddrKey] = net.IP(b[12:16]).String()
+		flow[dstAddrKey] = net.IP(b[16:20]).String()
+		flow["tos"] = int64(b[1])
+		if len(b) >= ihl {
+			transport = b[ihl:]
+		}
+	case etherTypeIPv6:
+		if len(b) < 40 {
+			return
+		}
+		ipProto = b[6]
+		flow[srcAddrKey] = net.IP(b[8:24]).String()
+		flow[dstAddrKey] = net.IP(b[24:40]).String()
+		transport = b[40:]
+	default:
+		return
+	}
+	flow[protocolKey] = int64(ipProto)
+	// TCP and UDP both start with the source and destination ports
+	if (ipProto == 6 || ipProto == 17) && len(transport) >= 4 {
+		flow[srcPortKey] = int64(binary.BigEndian.Uint16(transport))
+		flow[dstPortKey] = int64(binary.BigEndian.Uint16(transport[2:]))
+		if ipProto == 6 && len(transport) >= 14 {
+			flow[tcpFlagsKey] = int64(transport[13])
+		}
+	}
+}
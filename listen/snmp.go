@@ -0,0 +1,364 @@
+package listen
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// BER tags used by SNMP
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagOID         = 0x06
+	tagSequence    = 0x30
+	tagIPAddress   = 0x40
+	tagCounter32   = 0x41
+	tagGauge32     = 0x42
+	tagTimeTicks   = 0x43
+	tagOpaque      = 0x44
+	tagCounter64   = 0x46
+	tagNoSuchObj   = 0x80
+	tagNoSuchInst  = 0x81
+	tagEndOfView   = 0x82
+
+	pduGetResponse = 0xa2
+	pduTrapV1      = 0xa4
+	pduInform      = 0xa6
+	pduTrapV2      = 0xa7
+)
+
+// well known varbinds in v2c traps that are promoted to top level fields
+const (
+	sysUpTimeOID   = "1.3.6.1.2.1.1.3.0"
+	snmpTrapOIDOID = "1.3.6.1.6.3.1.1.4.1.0"
+)
+
+// snmpVersions maps the version field on the wire to its common name
+var snmpVersions = map[int64]string{0: "1", 1: "2c", 3: "3"}
+
+// genericTraps names the generic-trap values of SNMPv1 traps
+var genericTraps = []string{
+	"coldStart",
+	"warmStart",
+	"linkDown",
+	"linkUp",
+	"authenticationFailure",
+	"egpNeighborLoss",
+	"enterpriseSpecific",
+}
+
+// berValue is a single decoded tag-length-value
+type berValue struct {
+	tag   byte
+	value []byte
+}
+
+// readTLV reads one BER element off the front of b and returns it along with
+// whatever follows it.
+func readTLV(b []byte) (berValue, []byte, error) {
+	if len(b) < 2 {
+		return berValue{}, nil, errors.New("truncated BER element")
+	}
+	tag := b[0]
+	length := int(b[1])
+	b = b[2:]
+	if length&0x80 != 0 {
+		numBytes := length & 0x7f
+		// no packet is longer than 4 bytes of length can say, and more
+		// would overflow length; on 32 bit platforms even 4 can, leaving it
+		// negative, which is caught below
+		if numBytes == 0 || numBytes > 4 || len(b) < numBytes {
+			return berValue{}, nil, errors.New("invalid BER length")
+		}
+		length = 0
+		for _, c := range b[:numBytes] {
+			length = length<<8 | int(c)
+		}
+		b = b[numBytes:]
+	}
+	if length < 0 || length > len(b) {
+		return berValue{}, nil, errors.New("BER length exceeds packet size")
+	}
+	return berValue{tag: tag, value: b[:length]}, b[length:], nil
+}
+
+func (v berValue) int() int64 {
+	var i int64
+	for n, c := range v.value {
+		if n == 0 && c&0x80 != 0 {
+			// negative number; sign extend
+			i = -1
+		}
+		i = i<<8 | int64(c)
+	}
+	return i
+}
+
+func (v berValue) uint() uint64 {
+	var i uint64
+	for _, c := range v.value {
+		i = i<<8 | uint64(c)
+	}
+	return i
+}
+
+func (v berValue) oid() string {
+	if len(v.value) == 0 {
+		return ""
+	}
+	parts := []string{
+		strconv.Itoa(int(v.value[0]) / 40),
+		strconv.Itoa(int(v.value[0]) % 40),
+	}
+	var n uint64
+	for _, c := range v.value[1:] {
+		n = n<<7 | uint64(c&0x7f)
+		if c&0x80 == 0 {
+			parts = append(parts, strconv.FormatUint(n, 10))
+			n = 0
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// toInterface converts a varbind value into something suitable for an event
+func (v berValue) toInterface() interface{} {
+	switch v.tag {
+	case tagInteger:
+		return v.int()
+	case tagCounter32, tagGauge32, tagTimeTicks, tagCounter64:
+		return int64(v.uint())
+	case tagOctetString, tagOpaque:
+		if utf8.Valid(v.value) && isPrintable(v.value) {
+			return string(v.value)
+		}
+		return hex.EncodeToString(v.value)
+	case tagOID:
+		return v.oid()
+	case tagIPAddress:
+		return net.IP(v.value).String()
+	case tagNull, tagNoSuchObj, tagNoSuchInst, tagEndOfView:
+		return nil
+	}
+	return hex.EncodeToString(v.value)
+}
+
+func isPrintable(b []byte) bool {
+	for _, c := range b {
+		if c < 0x20 && c != '\t' && c != '\n' && c != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+// readExpected reads a single element and expects it to have the given tag
+func readExpected(b []byte, tag byte) (berValue, []byte, error) {
+	v, rest, err := readTLV(b)
+	if err != nil {
+		return v, nil, err
+	}
+	if v.tag != tag {
+		return v, nil, fmt.Errorf("expected BER tag 0x%x, got 0x%x", tag, v.tag)
+	}
+	return v, rest, nil
+}
+
+// trap is the decoded content of an SNMPv1 or v2c trap or inform
+type trap struct {
+	version   int64
+	community string
+	pduType   byte
+	fields    map[string]interface{}
+	varbinds  [][2]berValue
+}
+
+// decodeTrap decodes an SNMP message containing a trap or inform PDU.
+func decodeTrap(pkt []byte) (*trap, error) {
+	msg, _, err := readExpected(pkt, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	version, rest, err := readExpected(msg.value, tagInteger)
+	if err != nil {
+		return nil, err
+	}
+	t := &trap{version: version.int(), fields: make(map[string]interface{})}
+	if t.version == 3 {
+		return nil, errors.New("SNMPv3 traps are not supported")
+	}
+	community, rest, err := readExpected(rest, tagOctetString)
+	if err != nil {
+		return nil, err
+	}
+	t.community = string(community.value)
+	pdu, _, err := readTLV(rest)
+	if err != nil {
+		return nil, err
+	}
+	t.pduType = pdu.tag
+	body := pdu.value
+
+	switch pdu.tag {
+	case pduTrapV1:
+		enterprise, body, err := readExpected(body, tagOID)
+		if err != nil {
+			return nil, err
+		}
+		agent, body, err := readExpected(body, tagIPAddress)
+		if err != nil {
+			return nil, err
+		}
+		generic, body, err := readExpected(body, tagInteger)
+		if err != nil {
+			return nil, err
+		}
+		specific, body, err := readExpected(body, tagInteger)
+		if err != nil {
+			return nil, err
+		}
+		uptime, body, err := readExpected(body, tagTimeTicks)
+		if err != nil {
+			return nil, err
+		}
+		t.fields["enterprise"] = enterprise.oid()
+		t.fields["agent_address"] = net.IP(agent.value).String()
+		if g := generic.int(); g >= 0 && int(g) < len(genericTraps) {
+			t.fields["generic_trap"] = genericTraps[g]
+		}
+		t.fields["specific_trap"] = specific.int()
+		t.fields["uptime"] = int64(uptime.uint())
+		if err := t.readVarbinds(body); err != nil {
+			return nil, err
+		}
+	case pduTrapV2, pduInform:
+		reqID, body, err := readExpected(body, tagInteger)
+		if err != nil {
+			return nil, err
+		}
+		// skip error-status and error-index
+		for i := 0; i < 2; i++ {
+			if _, body, err = readExpected(body, tagInteger); err != nil {
+				return nil, err
+			}
+		}
+		t.fields["request_id"] = reqID.int()
+		if err := t.readVarbinds(body); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unexpected SNMP PDU type 0x%x", pdu.tag)
+	}
+	return t, nil
+}
+
+func (t *trap) readVarbinds(b []byte) error {
+	list, _, err := readExpected(b, tagSequence)
+	if err != nil {
+		return err
+	}
+	rest := list.value
+	for len(rest) > 0 {
+		var vb berValue
+		if vb, rest, err = readExpected(rest, tagSequence); err != nil {
+			return err
+		}
+		name, valBytes, err := readExpected(vb.value, tagOID)
+		if err != nil {
+			return err
+		}
+		val, _, err := readTLV(valBytes)
+		if err != nil {
+			return err
+		}
+		t.varbinds = append(t.varbinds, [2]berValue{name, val})
+	}
+	return nil
+}
+
+// newTrapHandler returns a packetHandler that converts SNMP traps into JSON
+// lines, using mibs to name the OIDs it finds.
+func newTrapHandler(mibs *mibTree) packetHandler {
+	return func(pkt []byte, peer *net.UDPAddr, conn *net.UDPConn) []string {
+		t, err := decodeTrap(pkt)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"peer":  peer.String(),
+				"error": err,
+			}).Debug("skipping packet; failed to decode SNMP trap")
+			return nil
+		}
+		if t.pduType == pduInform {
+			// informs must be acknowledged with a response carrying the same
+			// request id and varbinds; only the PDU tag differs.
+			if err := ackInform(pkt, peer, conn); err != nil {
+				logrus.WithError(err).Debug("failed to acknowledge SNMP inform")
+			}
+		}
+		data := t.fields
+		data["timestamp"] = time.Now().UTC().Format(time.RFC3339Nano)
+		data["peer_address"] = peer.IP.String()
+		data["snmp_version"] = snmpVersions[t.version]
+		data["community"] = t.community
+		for _, vb := range t.varbinds {
+			oid := vb[0].oid()
+			val := vb[1].toInterface()
+			switch oid {
+			case sysUpTimeOID:
+				data["uptime"] = val
+				continue
+			case snmpTrapOIDOID:
+				if s, ok := val.(string); ok {
+					data["trap_oid"] = s
+					data["trap_name"] = mibs.name(s)
+				}
+				continue
+			}
+			data[mibs.name(oid)] = val
+		}
+		if ent, ok := data["enterprise"].(string); ok {
+			data["enterprise_name"] = mibs.name(ent)
+		}
+		line, err := json.Marshal(data)
+		if err != nil {
+			logrus.WithError(err).Debug("failed to encode SNMP trap")
+			return nil
+		}
+		return []string{string(line)}
+	}
+}
+
+// ackInform sends a GetResponse PDU back to the sender of an inform
+func ackInform(pkt []byte, peer *net.UDPAddr, conn *net.UDPConn) error {
+	// walk to the PDU tag; its position is after the message header,
+	// version and community
+	msg, _, err := readTLV(pkt)
+	if err != nil {
+		return err
+	}
+	_, rest, err := readTLV(msg.value)
+	if err != nil {
+		return err
+	}
+	_, rest, err = readTLV(rest)
+	if err != nil {
+		return err
+	}
+	resp := make([]byte, len(pkt))
+	copy(resp, pkt)
+	// rest shares pkt's backing array, so the difference in capacity is the
+	// offset of the PDU tag
+	resp[cap(pkt)-cap(rest)] = pduGetResponse
+	_, err = conn.WriteToUDP(resp, peer)
+	return err
+}
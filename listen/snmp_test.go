@@ -0,0 +1,205 @@
+package listen
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// tlv encodes a BER element for building test packets
+func tlv(tag byte, contents ...[]byte) []byte {
+	var body []byte
+	for _, c := range contents {
+		body = append(body, c...)
+	}
+	out := []byte{tag}
+	if l := len(body); l < 0x80 {
+		out = append(out, byte(l))
+	} else {
+		out = append(out, 0x82, byte(l>>8), byte(l))
+	}
+	return append(out, body...)
+}
+
+func berInt(i int64) []byte {
+	b := []byte{byte(i)}
+	for i >>= 8; i != 0 && i != -1; i >>= 8 {
+		b = append([]byte{byte(i)}, b...)
+	}
+	return b
+}
+
+func berOID(oid string) []byte {
+	parts := strings.Split(oid, ".")
+	nums := make([]uint64, len(parts))
+	for i, p := range parts {
+		nums[i], _ = strconv.ParseUint(p, 10, 64)
+	}
+	b := []byte{byte(nums[0]*40 + nums[1])}
+	for _, n := range nums[2:] {
+		enc := []byte{byte(n & 0x7f)}
+		for n >>= 7; n > 0; n >>= 7 {
+			enc = append([]byte{byte(n&0x7f | 0x80)}, enc...)
+		}
+		b = append(b, enc...)
+	}
+	return b
+}
+
+func varbind(oid string, tag byte, val []byte) []byte {
+	return tlv(tagSequence, tlv(tagOID, berOID(oid)), tlv(tag, val))
+}
+
+func v2Trap(pduType byte) []byte {
+	return tlv(tagSequence,
+		tlv(tagInteger, berInt(1)),
+		tlv(tagOctetString, []byte("public")),
+		tlv(pduType,
+			tlv(tagInteger, berInt(1234)),
+			tlv(tagInteger, berInt(0)),
+			tlv(tagInteger, berInt(0)),
+			tlv(tagSequence,
+				varbind(sysUpTimeOID, tagTimeTicks, berInt(4500)),
+				varbind(snmpTrapOIDOID, tagOID, berOID("1.3.6.1.6.3.1.1.5.3")),
+				varbind("1.3.6.1.2.1.2.2.1.1.2", tagInteger, berInt(2)),
+				varbind("1.3.6.1.4.1.9999.1.1.0", tagOctetString, []byte("eth0 down")),
+				varbind("1.3.6.1.4.1.9999.1.2.0", tagCounter64, berInt(300000)),
+				varbind("1.3.6.1.4.1.9999.1.3.0", tagOctetString, []byte{0x00, 0x1b, 0x2c}),
+			),
+		),
+	)
+}
+
+func TestDecodeTrapV1(t *testing.T) {
+	pkt := tlv(tagSequence,
+		tlv(tagInteger, berInt(0)),
+		tlv(tagOctetString, []byte("private")),
+		tlv(pduTrapV1,
+			tlv(tagOID, berOID("1.3.6.1.4.1.9999")),
+			tlv(tagIPAddress, []byte{10, 0, 0, 1}),
+			tlv(tagInteger, berInt(6)),
+			tlv(tagInteger, berInt(17)),
+			tlv(tagTimeTicks, berInt(1000)),
+			tlv(tagSequence,
+				varbind("1.3.6.1.4.1.9999.1.1.0", tagInteger, berInt(-5)),
+			),
+		),
+	)
+	trap, err := decodeTrap(pkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"enterprise":    "1.3.6.1.4.1.9999",
+		"agent_address": "10.0.0.1",
+		"generic_trap":  "enterpriseSpecific",
+		"specific_trap": int64(17),
+		"uptime":        int64(1000),
+	}
+	if !reflect.DeepEqual(trap.fields, expected) {
+		t.Errorf("got fields %+v, expected %+v", trap.fields, expected)
+	}
+	if trap.community != "private" || trap.version != 0 {
+		t.Errorf("unexpected community %q / version %d", trap.community, trap.version)
+	}
+	if len(trap.varbinds) != 1 || trap.varbinds[0][1].toInterface() != int64(-5) {
+		t.Errorf("unexpected varbinds %+v", trap.varbinds)
+	}
+}
+
+func TestDecodeTrapErrors(t *testing.T) {
+	for _, pkt := range [][]byte{
+		{},
+		{0x30, 0x05, 0x02},
+		// lengths that don't fit, or go negative on 32 bit platforms
+		{0x30, 0x85, 0x01, 0x00, 0x00, 0x00, 0x00, 0x02},
+		{0x30, 0x84, 0xff, 0xff, 0xff, 0xff, 0x02},
+		tlv(tagSequence, tlv(tagInteger, berInt(3))),
+		tlv(tagSequence, tlv(tagInteger, berInt(1)), tlv(tagOctetString, []byte("c")), tlv(0xa0)),
+	} {
+		if _, err := decodeTrap(pkt); err == nil {
+			t.Errorf("expected error decoding %x", pkt)
+		}
+	}
+}
+
+func TestTrapHandler(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	mib := `
+EXAMPLE-MIB DEFINITIONS ::= BEGIN
+-- a comment mentioning ::= { nothing 1 }
+example MODULE-IDENTITY
+    LAST-UPDATED "201701010000Z"
+    ::= { enterprises 9999 }
+exampleObjects OBJECT IDENTIFIER ::= { example 1 }
+exampleMessage OBJECT-TYPE
+    SYNTAX DisplayString
+    MAX-ACCESS accessible-for-notify
+    STATUS current
+    ::= { exampleObjects 1 }
+exampleBytes OBJECT-TYPE
+    SYNTAX Counter64
+    ::= { exampleObjects 2 }
+END
+`
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "EXAMPLE-MIB.txt"), []byte(mib), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mibs, err := loadMIBs([]string{tmpdir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle := newTrapHandler(mibs)
+	lines := handle(v2Trap(pduTrapV2), &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 162}, nil)
+	if len(lines) != 1 {
+		t.Fatalf("expected one line, got %d", len(lines))
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &data); err != nil {
+		t.Fatal(err)
+	}
+	delete(data, "timestamp")
+	expected := map[string]interface{}{
+		"peer_address":       "192.168.1.1",
+		"snmp_version":       "2c",
+		"community":          "public",
+		"request_id":         float64(1234),
+		"uptime":             float64(4500),
+		"trap_oid":           "1.3.6.1.6.3.1.1.5.3",
+		"trap_name":          "linkDown",
+		"ifIndex.2":          float64(2),
+		"exampleMessage.0":   "eth0 down",
+		"exampleBytes.0":     float64(300000),
+		"exampleObjects.3.0": "001b2c",
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("got %+v, expected %+v", data, expected)
+	}
+}
+
+func TestMIBName(t *testing.T) {
+	var nilTree *mibTree
+	if nilTree.name("1.2.3") != "1.2.3" {
+		t.Error("nil mib tree should return OIDs unchanged")
+	}
+	tree := &mibTree{names: map[string]string{"1.3.6.1": "internet"}}
+	for oid, expected := range map[string]string{
+		"1.3.6.1":     "internet",
+		"1.3.6.1.4.1": "internet.4.1",
+		"1.3.6":       "1.3.6",
+	} {
+		if actual := tree.name(oid); actual != expected {
+			t.Errorf("name(%s) = %s, expected %s", oid, actual, expected)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package listen
+
+import (
+	"errors"
+	"net"
+)
+
+// errReusePortUnsupported is returned when SO_REUSEPORT is asked for on a
+// platform that doesn't have it
+var errReusePortUnsupported = errors.New("SO_REUSEPORT is not supported on this platform")
+
+// listenPacket binds a UDP socket to addr. Listening on [::] or with no host
+// accepts both IPv4 and IPv6 traffic; 0.0.0.0 only IPv4. With reusePort set,
+// other processes may bind the same address and the kernel spreads
+// datagrams between them.
+func listenPacket(addr string, reusePort bool) (*net.UDPConn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if !reusePort {
+		return net.ListenUDP("udp", udpAddr)
+	}
+	f, err := reusePortSocket(socketUDP, udpAddr.IP, udpAddr.Port, udpAddr.Zone)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	conn, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// ListenTCP binds a TCP listener to addr, setting SO_REUSEPORT if asked to.
+// It follows the same rules for dual-stack listening as the UDP inputs.
+func ListenTCP(addr string, reusePort bool) (net.Listener, error) {
+	if !reusePort {
+		return net.Listen("tcp", addr)
+	}
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	f, err := reusePortSocket(socketTCP, tcpAddr.IP, tcpAddr.Port, tcpAddr.Zone)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+type socketType int
+
+const (
+	socketUDP socketType = iota
+	socketTCP
+)
@@ -0,0 +1,97 @@
+package listen
+
+import (
+	"net"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestListenPacketReusePort(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" && runtime.GOOS != "freebsd" {
+		t.Skip("SO_REUSEPORT not supported")
+	}
+	first, err := listenPacket("127.0.0.1:0", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	addr := first.LocalAddr().String()
+	second, err := listenPacket(addr, true)
+	if err != nil {
+		t.Fatalf("second listener with SO_REUSEPORT failed: %s", err)
+	}
+	second.Close()
+	if third, err := listenPacket(addr, false); err == nil {
+		third.Close()
+		t.Error("listener without SO_REUSEPORT should fail to bind a used port")
+	}
+
+	ln, err := ListenTCP("127.0.0.1:0", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	ln2, err := ListenTCP(ln.Addr().String(), true)
+	if err != nil {
+		t.Fatalf("second TCP listener with SO_REUSEPORT failed: %s", err)
+	}
+	ln2.Close()
+}
+
+func TestListenPacketDualStack(t *testing.T) {
+	for _, reuse := range []bool{false, true} {
+		conn, err := listenPacket("[::]:0", reuse)
+		if err != nil {
+			t.Skipf("no IPv6 support: %s", err)
+		}
+		port := conn.LocalAddr().(*net.UDPAddr).Port
+		client, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: port})
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Write([]byte("hello"))
+		client.Close()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		buf := make([]byte, 10)
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil || string(buf[:n]) != "hello" {
+			t.Errorf("reuse=%v: IPv4 datagram not received on dual-stack socket: %q %v", reuse, buf[:n], err)
+		}
+		conn.Close()
+	}
+}
+
+func TestListenTCPDualStack(t *testing.T) {
+	for _, reuse := range []bool{false, true} {
+		ln, err := ListenTCP("[::]:0", reuse)
+		if err != nil {
+			t.Skipf("no IPv6 support: %s", err)
+		}
+		port := ln.Addr().(*net.TCPAddr).Port
+		accepted := make(chan error, 1)
+		go func() {
+			conn, err := ln.Accept()
+			if err == nil {
+				conn.Close()
+			}
+			accepted <- err
+		}()
+		client, err := net.DialTimeout("tcp4", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), 2*time.Second)
+		if err != nil {
+			t.Errorf("reuse=%v: IPv4 connection refused by dual-stack listener: %v", reuse, err)
+		} else {
+			client.Close()
+			select {
+			case err := <-accepted:
+				if err != nil {
+					t.Errorf("reuse=%v: accepting IPv4 connection: %v", reuse, err)
+				}
+			case <-time.After(2 * time.Second):
+				t.Errorf("reuse=%v: IPv4 connection not accepted", reuse)
+			}
+		}
+		ln.Close()
+	}
+}
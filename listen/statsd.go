@@ -0,0 +1,348 @@
+package listen
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/ratelimit"
+)
+
+// Field names for events built from statsd metrics
+const (
+	statsdNameKey       = "name"
+	statsdValueKey      = "value"
+	statsdTypeKey       = "type"
+	statsdSampleRateKey = "sample_rate"
+	statsdCountKey      = "count"
+	statsdTagPrefix     = "tag."
+)
+
+// statsdTypes maps the statsd type suffix to the name we put on the event
+var statsdTypes = map[string]string{
+	"c":  "counter",
+	"g":  "gauge",
+	"ms": "timer",
+	"h":  "histogram",
+	"d":  "distribution",
+	"s":  "set",
+}
+
+// statsdMetric is a single parsed statsd (or DogStatsD) metric
+type statsdMetric struct {
+	name  string
+	value float64
+	// raw is the unparsed value, used for set members and gauge deltas
+	raw        string
+	mtype      string
+	sampleRate float64
+	tags       map[string]string
+}
+
+// parseStatsdPacket parses every metric in a datagram. Lines that fail to
+// parse are logged and skipped rather than failing the whole packet.
+func parseStatsdPacket(pkt []byte) []statsdMetric {
+	var metrics []statsdMetric
+	for _, line := range strings.Split(string(pkt), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		parsed, err := parseStatsdLine(line)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"line":  line,
+				"error": err,
+			}).Debug("skipping line; failed to parse statsd metric")
+			continue
+		}
+		metrics = append(metrics, parsed...)
+	}
+	return metrics
+}
+
+// parseStatsdLine parses a line of the form
+// name:value[:value...]|type[|@sample_rate][|#tag:val,tag]
+func parseStatsdLine(line string) ([]statsdMetric, error) {
+	if strings.HasPrefix(line, "_e{") || strings.HasPrefix(line, "_sc|") {
+		return nil, errors.New("DogStatsD events and service checks are not supported")
+	}
+	colon := strings.Index(line, ":")
+	if colon <= 0 {
+		return nil, errors.New("metric has no name")
+	}
+	name := line[:colon]
+	parts := strings.Split(line[colon+1:], "|")
+	if len(parts) < 2 {
+		return nil, errors.New("metric has no type")
+	}
+	mtype, ok := statsdTypes[parts[1]]
+	if !ok {
+		return nil, fmt.Errorf("unknown metric type %q", parts[1])
+	}
+	sampleRate := 1.0
+	var tags map[string]string
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return nil, fmt.Errorf("invalid sample rate %q", part)
+			}
+			sampleRate = rate
+		case strings.HasPrefix(part, "#"):
+			tags = parseStatsdTags(part[1:])
+		}
+	}
+
+	// some clients pack several values for the same metric into one line
+	var metrics []statsdMetric
+	for _, raw := range strings.Split(parts[0], ":") {
+		m := statsdMetric{
+			name:       name,
+			raw:        raw,
+			mtype:      mtype,
+			sampleRate: sampleRate,
+			tags:       tags,
+		}
+		if mtype != "set" {
+			value, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", raw)
+			}
+			m.value = value
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
+// parseStatsdTags parses DogStatsD tags. Tags without a value are recorded
+// as "true".
+func parseStatsdTags(s string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(s, ",") {
+		if tag == "" {
+			continue
+		}
+		if i := strings.Index(tag, ":"); i > 0 {
+			tags[tag[:i]] = tag[i+1:]
+		} else {
+			tags[tag] = "true"
+		}
+	}
+	return tags
+}
+
+// fields returns the event fields common to the raw and aggregated forms
+func (m *statsdMetric) fields() map[string]interface{} {
+	data := map[string]interface{}{
+		statsdNameKey: m.name,
+		statsdTypeKey: m.mtype,
+	}
+	for k, v := range m.tags {
+		data[statsdTagPrefix+k] = v
+	}
+	return data
+}
+
+// key identifies the series a metric belongs to for aggregation
+func (m *statsdMetric) key() string {
+	tags := make([]string, 0, len(m.tags))
+	for k, v := range m.tags {
+		tags = append(tags, k+":"+v)
+	}
+	sort.Strings(tags)
+	return m.name + "|" + m.mtype + "|" + strings.Join(tags, ",")
+}
+
+// newStatsdHandler returns a handler that turns each metric into its own event
+func newStatsdHandler() packetHandler {
+	return func(pkt []byte, peer *net.UDPAddr, conn *net.UDPConn) []string {
+		now := time.Now().UTC().Format(time.RFC3339Nano)
+		metrics := parseStatsdPacket(pkt)
+		lines := make([]string, 0, len(metrics))
+		for _, m := range metrics {
+			data := m.fields()
+			if m.mtype == "set" {
+				data[statsdValueKey] = m.raw
+			} else {
+				data[statsdValueKey] = m.value
+			}
+			if m.sampleRate != 1 {
+				data[statsdSampleRateKey] = m.sampleRate
+			}
+			data["timestamp"] = now
+			line, err := json.Marshal(data)
+			if err != nil {
+				logrus.WithError(err).Debug("failed to encode statsd metric")
+				continue
+			}
+			lines = append(lines, string(line))
+		}
+		return lines
+	}
+}
+
+// statsdSeries accumulates the metrics for one series between flushes
+type statsdSeries struct {
+	metric  statsdMetric
+	count   int
+	sum     float64
+	gauge   float64
+	values  []float64
+	members map[string]struct{}
+}
+
+// statsdAggregator pre-aggregates metrics so that only one event per series
+// is sent each flush interval
+type statsdAggregator struct {
+	series map[string]*statsdSeries
+	// gauges keep their value across flushes so that deltas apply correctly
+	gauges map[string]float64
+}
+
+func newStatsdAggregator() *statsdAggregator {
+	return &statsdAggregator{
+		series: make(map[string]*statsdSeries),
+		gauges: make(map[string]float64),
+	}
+}
+
+func (a *statsdAggregator) add(m statsdMetric) {
+	key := m.key()
+	s, ok := a.series[key]
+	if !ok {
+		s = &statsdSeries{metric: m}
+		a.series[key] = s
+	}
+	s.count++
+	switch m.mtype {
+	case "counter":
+		s.sum += m.value / m.sampleRate
+	case "gauge":
+		if strings.HasPrefix(m.raw, "+") || strings.HasPrefix(m.raw, "-") {
+			a.gauges[key] += m.value
+		} else {
+			a.gauges[key] = m.value
+		}
+		s.gauge = a.gauges[key]
+	case "set":
+		if s.members == nil {
+			s.members = make(map[string]struct{})
+		}
+		s.members[m.raw] = struct{}{}
+	default:
+		s.sum += m.value
+		s.values = append(s.values, m.value)
+	}
+}
+
+// flush returns a JSON line per series seen since the last flush and resets
+// the aggregator
+func (a *statsdAggregator) flush(now time.Time) []string {
+	keys := make([]string, 0, len(a.series))
+	for k := range a.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	lines := make([]string, 0, len(keys))
+	for _, k := range keys {
+		s := a.series[k]
+		data := s.metric.fields()
+		data[statsdCountKey] = s.count
+		switch s.metric.mtype {
+		case "counter":
+			data[statsdValueKey] = s.sum
+		case "gauge":
+			data[statsdValueKey] = s.gauge
+		case "set":
+			data[statsdValueKey] = len(s.members)
+		default:
+			sort.Float64s(s.values)
+			data["sum"] = s.sum
+			data["min"] = s.values[0]
+			data["max"] = s.values[len(s.values)-1]
+			data["avg"] = s.sum / float64(len(s.values))
+			data["p50"] = percentile(s.values, 0.5)
+			data["p95"] = percentile(s.values, 0.95)
+			data["p99"] = percentile(s.values, 0.99)
+		}
+		data["timestamp"] = now.UTC().Format(time.RFC3339Nano)
+		line, err := json.Marshal(data)
+		if err != nil {
+			logrus.WithError(err).Debug("failed to encode statsd metric")
+			continue
+		}
+		lines = append(lines, string(line))
+	}
+	a.series = make(map[string]*statsdSeries)
+	return lines
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// listenStatsd listens for statsd metrics on conn. With a zero interval every
+// metric becomes an event; otherwise metrics are aggregated and one event per
+// series is sent each interval.
+func listenStatsd(conn *net.UDPConn, interval time.Duration, limiter *ratelimit.Limiter, abort <-chan struct{}) (chan string, error) {
+	if interval == 0 {
+		return listenUDP(conn, limitHandler(newStatsdHandler(), limiter), abort)
+	}
+	agg := newStatsdAggregator()
+	metrics := make(chan statsdMetric)
+	raw, err := listenUDP(conn, limitHandler(func(pkt []byte, peer *net.UDPAddr, conn *net.UDPConn) []string {
+		for _, m := range parseStatsdPacket(pkt) {
+			select {
+			case metrics <- m:
+			case <-abort:
+				return nil
+			}
+		}
+		return nil
+	}, limiter), abort)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case m := <-metrics:
+				agg.add(m)
+			case now := <-ticker.C:
+				for _, line := range agg.flush(now) {
+					select {
+					case lines <- line:
+					case <-abort:
+						return
+					}
+				}
+			case _, ok := <-raw:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+	return lines, nil
+}
@@ -0,0 +1,121 @@
+package listen
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseStatsdLine(t *testing.T) {
+	tlms := []struct {
+		line     string
+		expected []statsdMetric
+	}{
+		{
+			line:     "page.views:1|c",
+			expected: []statsdMetric{{name: "page.views", value: 1, raw: "1", mtype: "counter", sampleRate: 1}},
+		},
+		{
+			line: "api.latency:32.5|ms|@0.5|#env:prod,canary",
+			expected: []statsdMetric{{name: "api.latency", value: 32.5, raw: "32.5", mtype: "timer", sampleRate: 0.5,
+				tags: map[string]string{"env": "prod", "canary": "true"}}},
+		},
+		{
+			line:     "users:alice|s",
+			expected: []statsdMetric{{name: "users", raw: "alice", mtype: "set", sampleRate: 1}},
+		},
+		{
+			line: "queue:1:2|h",
+			expected: []statsdMetric{
+				{name: "queue", value: 1, raw: "1", mtype: "histogram", sampleRate: 1},
+				{name: "queue", value: 2, raw: "2", mtype: "histogram", sampleRate: 1},
+			},
+		},
+	}
+	for _, tlm := range tlms {
+		resp, err := parseStatsdLine(tlm.line)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tlm.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(resp, tlm.expected) {
+			t.Errorf("parsing %q got %+v, expected %+v", tlm.line, resp, tlm.expected)
+		}
+	}
+
+	for _, bad := range []string{"nometric", "name:1", "name:1|x", "name:abc|c", "name:1|c|@2", "_e{5,4}:title|text"} {
+		if _, err := parseStatsdLine(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestStatsdHandler(t *testing.T) {
+	lines := newStatsdHandler()([]byte("hits:2|c|@0.1|#region:us\nbogus\n"), &net.UDPAddr{}, nil)
+	if len(lines) != 1 {
+		t.Fatalf("expected one line, got %q", lines)
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &data); err != nil {
+		t.Fatal(err)
+	}
+	delete(data, "timestamp")
+	expected := map[string]interface{}{
+		"name":        "hits",
+		"type":        "counter",
+		"value":       float64(2),
+		"sample_rate": 0.1,
+		"tag.region":  "us",
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("got %+v, expected %+v", data, expected)
+	}
+}
+
+func TestStatsdAggregator(t *testing.T) {
+	agg := newStatsdAggregator()
+	for _, m := range parseStatsdPacket([]byte("hits:1|c|@0.5\nhits:1|c\ntemp:10|g\ntemp:-3|g\nusers:a|s\nusers:b|s\nusers:a|s\nlat:10|ms\nlat:30|ms\nlat:20|ms")) {
+		agg.add(m)
+	}
+	now := time.Date(2010, 6, 21, 15, 4, 5, 0, time.UTC)
+	lines := agg.flush(now)
+	got := make(map[string]map[string]interface{})
+	for _, line := range lines {
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &data); err != nil {
+			t.Fatal(err)
+		}
+		if data["timestamp"] != "2010-06-21T15:04:05Z" {
+			t.Errorf("unexpected timestamp %v", data["timestamp"])
+		}
+		got[data["name"].(string)] = data
+	}
+	checks := map[string]map[string]interface{}{
+		"hits":  {"value": float64(3), "count": float64(2)},
+		"temp":  {"value": float64(7), "count": float64(2)},
+		"users": {"value": float64(2), "count": float64(3)},
+		"lat":   {"count": float64(3), "sum": float64(60), "min": float64(10), "max": float64(30), "avg": float64(20), "p50": float64(20), "p99": float64(30)},
+	}
+	for name, fields := range checks {
+		for k, v := range fields {
+			if got[name][k] != v {
+				t.Errorf("%s.%s = %v, expected %v", name, k, got[name][k], v)
+			}
+		}
+	}
+
+	// series are reset after a flush but gauges keep their value
+	if lines := agg.flush(now); len(lines) != 0 {
+		t.Errorf("expected no lines after flush, got %q", lines)
+	}
+	m, _ := parseStatsdLine("temp:+1|g")
+	agg.add(m[0])
+	lines = agg.flush(now)
+	var data map[string]interface{}
+	json.Unmarshal([]byte(lines[0]), &data)
+	if data["value"] != float64(8) {
+		t.Errorf("gauge delta applied to %v, expected 8", data["value"])
+	}
+}
@@ -0,0 +1,228 @@
+package listen
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/ratelimit"
+)
+
+// PeerPrefixRegex matches the address of the sender that inputs such as
+// syslog put at the start of each line, so it can be stripped before
+// parsing and added to the event as peer_address
+const PeerPrefixRegex = `^(?P<peer_address>\S+) `
+
+// AttachesPeer returns true if the lines from the --listen address addr
+// start with the sender's address, to be matched by PeerPrefixRegex
+func AttachesPeer(addr string) bool {
+	scheme, _, err := splitAddr(addr)
+	return err == nil && (scheme == "syslog-udp" || scheme == "syslog-tcp" || scheme == "syslog-tls")
+}
+
+// syslogMaxMessage is the longest syslog message accepted over TCP. Octet
+// counts claiming more are taken as a framing error.
+const syslogMaxMessage = 1024 * 1024
+
+// syslogIdleTimeout is how long a TCP sender may go without sending before
+// its connection is closed
+const syslogIdleTimeout = 10 * time.Minute
+
+// syslogHandshakeTimeout is how long a syslog-tls sender has to complete
+// the TLS handshake
+const syslogHandshakeTimeout = 30 * time.Second
+
+// peerLine returns the line for a syslog message from ip, or "" if the
+// message is empty. Trailing newlines and NULs, which some senders add, are
+// removed.
+func peerLine(ip net.IP, msg []byte) string {
+	msg = bytes.TrimRight(msg, "\r\n\x00")
+	if len(msg) == 0 {
+		return ""
+	}
+	return ip.String() + " " + string(msg)
+}
+
+// newSyslogHandler returns a handler for syslog over UDP, where each
+// datagram holds one message (RFC 5426)
+func newSyslogHandler() packetHandler {
+	return func(pkt []byte, peer *net.UDPAddr, conn *net.UDPConn) []string {
+		if line := peerLine(peer.IP, pkt); line != "" {
+			return []string{line}
+		}
+		return nil
+	}
+}
+
+// listenSyslogTCP accepts syslog connections on l, sending a line for each
+// message received. Messages are framed either by octet counting or by
+// newlines (RFC 6587); senders may use either, message by message. l may
+// be a TLS listener (RFC 5425), which only uses octet counting.
+func listenSyslogTCP(l net.Listener, limiter *ratelimit.Limiter, abort <-chan struct{}) chan string {
+	logrus.WithField("address", l.Addr()).Info("Listening for syslog connections")
+
+	lines := make(chan string)
+	var lock sync.Mutex
+	conns := make(map[net.Conn]bool)
+	go func() {
+		// unblock Accept and the connections' reads when we're asked to stop
+		<-abort
+		l.Close()
+		lock.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		lock.Unlock()
+	}()
+	go func() {
+		var wg sync.WaitGroup
+		defer func() {
+			wg.Wait()
+			close(lines)
+		}()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				select {
+				case <-abort:
+				default:
+					logrus.WithError(err).Warn("Error accepting syslog connection, shutting the listener down")
+				}
+				return
+			}
+			lock.Lock()
+			select {
+			case <-abort:
+				conn.Close()
+				lock.Unlock()
+				return
+			default:
+			}
+			conns[conn] = true
+			lock.Unlock()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				readSyslogConn(conn, limiter, lines, abort)
+				lock.Lock()
+				delete(conns, conn)
+				lock.Unlock()
+				conn.Close()
+			}()
+		}
+	}()
+	return lines
+}
+
+// readSyslogConn sends a line for each message read from conn until it's
+// closed or a message can't be framed
+func readSyslogConn(conn net.Conn, limiter *ratelimit.Limiter, lines chan<- string, abort <-chan struct{}) {
+	ip := net.IPv4zero
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		ip = addr.IP
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// handshake up front, so senders turned away are reported
+		conn.SetDeadline(time.Now().Add(syslogHandshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			select {
+			case <-abort:
+			default:
+				logrus.WithFields(logrus.Fields{
+					"peer": conn.RemoteAddr(),
+					"err":  err,
+				}).Warn("TLS handshake with syslog sender failed")
+			}
+			return
+		}
+		conn.SetDeadline(time.Time{})
+	}
+	input := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(syslogIdleTimeout))
+		msg, err := readSyslogMessage(input)
+		if len(msg) != 0 {
+			if ok, _ := limiter.Allow(ip.String()); ok {
+				if line := peerLine(ip, msg); line != "" {
+					select {
+					case lines <- line:
+					case <-abort:
+						return
+					}
+				}
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				select {
+				case <-abort:
+				default:
+					logrus.WithFields(logrus.Fields{
+						"peer": conn.RemoteAddr(),
+						"err":  err,
+					}).Debug("closing syslog connection")
+				}
+			}
+			return
+		}
+	}
+}
+
+// readSyslogMessage reads the next message from r. An octet counted
+// message starts with its length and a space; anything else runs to the
+// next newline.
+func readSyslogMessage(r *bufio.Reader) ([]byte, error) {
+	// skip blank lines between messages
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] != '\n' && b[0] != '\r' {
+			break
+		}
+		r.ReadByte()
+	}
+	if b, _ := r.Peek(1); b[0] < '1' || b[0] > '9' {
+		msg, err := r.ReadBytes('\n')
+		if err == io.EOF && len(msg) != 0 {
+			// the last message needn't end with a newline
+			err = nil
+		}
+		return msg, err
+	}
+	count, err := r.ReadString(' ')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(count, " "))
+	if err != nil {
+		// not a count after all, so a message without a priority that
+		// runs to the next newline
+		rest, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			err = nil
+		}
+		return append([]byte(count), rest...), err
+	}
+	if n > syslogMaxMessage {
+		return nil, errTooLong
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// errTooLong is returned when an octet count is more than syslogMaxMessage
+var errTooLong = errors.New("syslog message is too long")
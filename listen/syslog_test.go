@@ -0,0 +1,100 @@
+package listen
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestReadSyslogMessage(t *testing.T) {
+	input := "<34>1 2010-06-21T15:04:05Z host app - - - newline framed\n" +
+		"\r\n" +
+		"27 <34>1 octet\ncounted message" +
+		"2024-06-21 not a count\n" +
+		"<34>unterminated"
+	r := bufio.NewReader(strings.NewReader(input))
+	var msgs []string
+	for {
+		msg, err := readSyslogMessage(r)
+		if len(msg) != 0 {
+			msgs = append(msgs, string(msg))
+		}
+		if err != nil {
+			if err != io.EOF {
+				t.Errorf("unexpected error %v", err)
+			}
+			break
+		}
+	}
+	expected := []string{
+		"<34>1 2010-06-21T15:04:05Z host app - - - newline framed\n",
+		"<34>1 octet\ncounted message",
+		"2024-06-21 not a count\n",
+		"<34>unterminated",
+	}
+	if !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("got messages %q, expected %q", msgs, expected)
+	}
+
+	r = bufio.NewReader(strings.NewReader("9999999 <34>too long"))
+	if _, err := readSyslogMessage(r); err != errTooLong {
+		t.Errorf("got error %v, expected %v", err, errTooLong)
+	}
+}
+
+func TestSyslogHandler(t *testing.T) {
+	handle := newSyslogHandler()
+	peer := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5514}
+	lines := handle([]byte("<13>Jun 21 15:04:05 router sshd: login\n\x00"), peer, nil)
+	if !reflect.DeepEqual(lines, []string{"10.0.0.1 <13>Jun 21 15:04:05 router sshd: login"}) {
+		t.Errorf("got lines %q", lines)
+	}
+	if lines := handle([]byte("\n"), peer, nil); len(lines) != 0 {
+		t.Errorf("got lines %q for an empty message", lines)
+	}
+}
+
+func TestSyslogTCP(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	abort := make(chan struct{})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := listenSyslogTCP(l, nil, abort)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(conn, "<13>first\n10 <13>second")
+	for _, expected := range []string{"127.0.0.1 <13>first", "127.0.0.1 <13>second"} {
+		if line := <-lines; line != expected {
+			t.Errorf("got line %q, expected %q", line, expected)
+		}
+	}
+
+	// connections still open are closed on abort
+	close(abort)
+	checkLinesChanClosed(t, lines)
+	conn.Close()
+}
+
+func TestAttachesPeer(t *testing.T) {
+	for addr, expected := range map[string]bool{
+		"syslog-udp://0.0.0.0:5514": true,
+		"syslog-tcp://0.0.0.0:5514": true,
+		"gelf://0.0.0.0:12201":      false,
+		"%%":                        false,
+	} {
+		if got := AttachesPeer(addr); got != expected {
+			t.Errorf("AttachesPeer(%q) = %v, expected %v", addr, got, expected)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package listen
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/ratelimit"
+	"github.com/honeycombio/honeytail/records"
+)
+
+// lineLimits bound the connections taken by the tcp and unix inputs
+type lineLimits struct {
+	// maxConns is how many connections may be open at once; 0 is no limit
+	maxConns int
+	// maxLine is the longest line accepted, bufio.MaxScanTokenSize if 0.
+	// Connections sending longer lines are closed.
+	maxLine int
+	// idleTimeout is how long a connection may go without sending before
+	// it's closed; 0 is no limit
+	idleTimeout time.Duration
+	// split splits what's read into records, if they're separated by
+	// something other than newlines
+	split bufio.SplitFunc
+}
+
+// lineLimits returns the connection limits and record delimiter set in o
+func (o ListenOptions) lineLimits() (lineLimits, error) {
+	split, err := records.SplitFunc("listen.record", o.RecordDelimiter, o.RecordDelimiterRegex)
+	if err != nil {
+		return lineLimits{}, err
+	}
+	return lineLimits{
+		maxConns:    o.MaxConns,
+		maxLine:     o.MaxLine,
+		idleTimeout: time.Duration(o.IdleTimeout) * time.Second,
+		split:       split,
+	}, nil
+}
+
+// listenLines accepts connections on l, sending a line for each
+// newline-delimited line read from them, as written by nc and the like, or
+// each record if limits has a delimiter.
+// sender names the sender of a connection for rate limiting.
+func listenLines(l net.Listener, limits lineLimits, sender func(net.Conn) string, limiter *ratelimit.Limiter, abort <-chan struct{}) chan string {
+	lines := make(chan string)
+	var lock sync.Mutex
+	conns := make(map[net.Conn]bool)
+	go func() {
+		// unblock Accept and the connections' reads when we're asked to stop
+		<-abort
+		l.Close()
+		lock.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		lock.Unlock()
+	}()
+	go func() {
+		var wg sync.WaitGroup
+		defer func() {
+			wg.Wait()
+			close(lines)
+		}()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				select {
+				case <-abort:
+				default:
+					logrus.WithError(err).Warn("Error accepting connection, shutting the listener down")
+				}
+				return
+			}
+			lock.Lock()
+			select {
+			case <-abort:
+				conn.Close()
+				lock.Unlock()
+				return
+			default:
+			}
+			if limits.maxConns > 0 && len(conns) >= limits.maxConns {
+				lock.Unlock()
+				logrus.WithFields(logrus.Fields{
+					"address": l.Addr(),
+					"peer":    conn.RemoteAddr(),
+				}).Warn("Too many connections open, turning a new one away")
+				conn.Close()
+				continue
+			}
+			conns[conn] = true
+			lock.Unlock()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				readLines(conn, limits, sender(conn), limiter, lines, abort)
+				lock.Lock()
+				delete(conns, conn)
+				lock.Unlock()
+				conn.Close()
+			}()
+		}
+	}()
+	return lines
+}
+
+// readLines sends each line, or record, read from conn until it's closed,
+// goes idle or sends a line that's too long
+func readLines(conn net.Conn, limits lineLimits, sender string, limiter *ratelimit.Limiter, lines chan<- string, abort <-chan struct{}) {
+	scanner := bufio.NewScanner(conn)
+	send := sendLine
+	if limits.split != nil {
+		scanner.Split(limits.split)
+		send = sendRecord
+	}
+	if limits.maxLine > 0 {
+		// the buffer's capacity counts towards the limit too
+		size := 4096
+		if limits.maxLine < size {
+			size = limits.maxLine
+		}
+		scanner.Buffer(make([]byte, 0, size), limits.maxLine)
+	}
+	for {
+		if limits.idleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(limits.idleTimeout))
+		}
+		if !scanner.Scan() {
+			break
+		}
+		if !send(scanner.Bytes(), sender, limiter, lines, abort) {
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		select {
+		case <-abort:
+		default:
+			logrus.WithFields(logrus.Fields{
+				"peer": sender,
+				"err":  err,
+			}).Debug("closing connection")
+		}
+	}
+}
+
+// sendLine sends line unless it's empty or sender is over the rate limit,
+// returning false if aborted first
+func sendLine(line []byte, sender string, limiter *ratelimit.Limiter, lines chan<- string, abort <-chan struct{}) bool {
+	line = bytes.TrimRight(line, "\r\x00")
+	if len(line) == 0 {
+		return true
+	}
+	return sendAllowed(line, sender, limiter, lines, abort)
+}
+
+// sendRecord sends record, whitespace and all, unless it's only whitespace
+// or sender is over the rate limit, returning false if aborted first
+func sendRecord(record []byte, sender string, limiter *ratelimit.Limiter, lines chan<- string, abort <-chan struct{}) bool {
+	if len(bytes.TrimSpace(record)) == 0 {
+		return true
+	}
+	return sendAllowed(record, sender, limiter, lines, abort)
+}
+
+// sendAllowed sends line unless sender is over the rate limit, returning
+// false if aborted first
+func sendAllowed(line []byte, sender string, limiter *ratelimit.Limiter, lines chan<- string, abort <-chan struct{}) bool {
+	if ok, _ := limiter.Allow(sender); !ok {
+		return true
+	}
+	select {
+	case lines <- string(line):
+		return true
+	case <-abort:
+		return false
+	}
+}
+
+// tcpSender names the sender of a tcp connection by its IP address
+func tcpSender(conn net.Conn) string {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return conn.RemoteAddr().String()
+}
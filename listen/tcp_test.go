@@ -0,0 +1,126 @@
+package listen
+
+import (
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestListenTCPLines(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	abort := make(chan struct{})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := lineLimits{maxConns: 1, maxLine: 16}
+	lines := listenLines(l, limits, tcpSender, nil, abort)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("first\r\n\nsecond\n"))
+	expectLine(t, lines, "first")
+	expectLine(t, lines, "second")
+
+	// over the connection limit
+	second, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := second.Read(make([]byte, 1)); err == nil {
+		t.Error("expected a second connection to be closed")
+	}
+	second.Close()
+
+	// a line that's too long closes the connection, making room for the
+	// next
+	conn.Write([]byte(strings.Repeat("x", 32) + "\n"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("expected the connection sending a long line to be closed")
+	}
+	conn.Close()
+	third, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the last line needn't end with a newline
+	third.Write([]byte("third"))
+	third.Close()
+	expectLine(t, lines, "third")
+
+	close(abort)
+	checkLinesChanClosed(t, lines)
+}
+
+func TestGetEntriesTCP(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	abort := make(chan struct{})
+	chans, err := GetEntries(Config{Addrs: []string{"tcp://127.0.0.1:0"}}, abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chans) != 1 {
+		t.Fatalf("expected 1 channel, got %d", len(chans))
+	}
+	close(abort)
+	checkLinesChanClosed(t, chans[0])
+}
+
+func TestIdleTimeout(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	abort := make(chan struct{})
+	defer close(abort)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := listenLines(l, lineLimits{idleTimeout: 100 * time.Millisecond}, tcpSender, nil, abort)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("expected an idle connection to be closed")
+	}
+	select {
+	case line := <-lines:
+		t.Errorf("got unexpected line %q", line)
+	default:
+	}
+}
+
+func TestListenTCPRecords(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	abort := make(chan struct{})
+	defer close(abort)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits, err := ListenOptions{RecordDelimiter: `\0`, MaxLine: 64}.lineLimits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := listenLines(l, limits, tcpSender, nil, abort)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// each record is sent once its delimiter arrives, with no newline after
+	// it and the newlines within it kept
+	conn.Write([]byte("first\nrecord\x00 \x00"))
+	expectLine(t, lines, "first\nrecord")
+	conn.Write([]byte("second \x00"))
+	expectLine(t, lines, "second ")
+}
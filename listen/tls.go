@@ -0,0 +1,39 @@
+package listen
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// tlsConfig returns the server TLS config for syslog-tls and https
+// listeners. Client certificates are required, and checked against
+// TLSClientCA, if it's set.
+func (o ListenOptions) tlsConfig() (*tls.Config, error) {
+	if o.TLSCert == "" || o.TLSKey == "" {
+		return nil, errors.New("syslog-tls and https need --listen.tls_cert and --listen.tls_key")
+	}
+	cert, err := tls.LoadX509KeyPair(o.TLSCert, o.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %v", err)
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if o.TLSClientCA != "" {
+		pem, err := ioutil.ReadFile(o.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("reading TLS client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in TLS client CA %s", o.TLSClientCA)
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
@@ -0,0 +1,157 @@
+package listen
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// testCert is a certificate and key signed by parent, or self-signed if
+// parent is nil
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write saves the certificate and key as PEM files in dir
+func (c *testCert) write(t *testing.T, dir string, name string) (string, string) {
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestSyslogTLS(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	tmpdir, err := ioutil.TempDir("", "listen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ca := newTestCert(t, "ca", nil, true)
+	caFile, _ := ca.write(t, tmpdir, "ca")
+	server := newTestCert(t, "server", ca, false)
+	certFile, keyFile := server.write(t, tmpdir, "server")
+	client := newTestCert(t, "client", ca, false)
+	stranger := newTestCert(t, "stranger", nil, false)
+
+	opts := ListenOptions{TLSCert: certFile, TLSKey: keyFile}
+	if _, err := (ListenOptions{TLSCert: certFile}).tlsConfig(); err == nil {
+		t.Error("expected an error without a key")
+	}
+	opts.TLSClientCA = filepath.Join(tmpdir, "missing.crt")
+	if _, err := opts.tlsConfig(); err == nil {
+		t.Error("expected an error with a missing client CA")
+	}
+	opts.TLSClientCA = caFile
+	serverConfig, err := opts.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	abort := make(chan struct{})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := listenSyslogTCP(tls.NewListener(l, serverConfig), nil, abort)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	dial := func(cert *testCert) (*tls.Conn, error) {
+		conf := &tls.Config{RootCAs: roots}
+		if cert != nil {
+			conf.Certificates = []tls.Certificate{cert.tlsCertificate()}
+		}
+		conn, err := tls.Dial("tcp", l.Addr().String(), conf)
+		if err != nil {
+			return nil, err
+		}
+		// the server only checks the client's certificate once the
+		// handshake's done on its side, so make a round trip
+		io.WriteString(conn, "15 <13>from client")
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return conn, nil
+		}
+		conn.Close()
+		return nil, err
+	}
+
+	conn, err := dial(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line := <-lines; line != "127.0.0.1 <13>from client" {
+		t.Errorf("got line %q", line)
+	}
+	conn.Close()
+
+	// senders without a certificate signed by the CA are turned away
+	for _, cert := range []*testCert{nil, stranger} {
+		if conn, err := dial(cert); err == nil {
+			conn.Close()
+			t.Error("expected the connection to be refused")
+		}
+	}
+
+	close(abort)
+	checkLinesChanClosed(t, lines)
+}
@@ -0,0 +1,140 @@
+package listen
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/ratelimit"
+)
+
+// unixPath returns the network and path of a unix or unixgram --listen
+// address, such as unix:///run/honeytail.sock, and false for any other
+func unixPath(addr string) (string, string, bool) {
+	u, err := url.Parse(addr)
+	if err != nil || (u.Scheme != "unix" && u.Scheme != "unixgram") {
+		return "", "", false
+	}
+	// unix://run/honeytail.sock is taken as a relative path
+	return u.Scheme, u.Host + u.Path, true
+}
+
+// listenUnix creates a unix domain socket at path, with its permissions set
+// to mode, and sends a line for each line written to it. unix sockets are
+// read as streams of newline-delimited lines, with connections limited as
+// for tcp; each unixgram datagram holds one or more lines. Either may hold
+// records separated by the delimiter in limits instead. The socket is removed once abort is closed.
+//
+// Senders have no address to tell them apart, so they share one rate
+// limit.
+func listenUnix(network, path, mode string, limits lineLimits, limiter *ratelimit.Limiter, abort <-chan struct{}) (chan string, error) {
+	if path == "" {
+		return nil, fmt.Errorf("%s listen address has no path", network)
+	}
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --listen.socket_mode %q, expected an octal mode such as 0666", mode)
+	}
+	if err := removeStaleSocket(network, path); err != nil {
+		return nil, err
+	}
+	var lines chan string
+	if network == "unixgram" {
+		conn, err := net.ListenUnixgram(network, &net.UnixAddr{Name: path, Net: network})
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+			conn.Close()
+			os.Remove(path)
+			return nil, err
+		}
+		lines = listenUnixgram(conn, path, limits.split, limiter, abort)
+	} else {
+		l, err := net.ListenUnix(network, &net.UnixAddr{Name: path, Net: network})
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+			// closing the listener removes the socket
+			l.Close()
+			return nil, err
+		}
+		sender := func(net.Conn) string { return path }
+		lines = listenLines(l, limits, sender, limiter, abort)
+	}
+	logrus.WithFields(logrus.Fields{
+		"path": path,
+		"type": network,
+	}).Info("Listening on unix socket")
+	return lines, nil
+}
+
+// removeStaleSocket removes a socket left at path by a listener that's no
+// longer running, so it can be bound again. A socket still in use, or a
+// file that isn't a socket, is left alone.
+func removeStaleSocket(network, path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial(network, path); err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s is in use by another listener", path)
+	}
+	return os.Remove(path)
+}
+
+// listenUnixgram sends the lines in each datagram received on conn, or the
+// records separated by split if it's set
+func listenUnixgram(conn *net.UnixConn, path string, split bufio.SplitFunc, limiter *ratelimit.Limiter, abort <-chan struct{}) chan string {
+	lines := make(chan string)
+	go func() {
+		// unblock ReadFrom when we're asked to stop
+		<-abort
+		conn.Close()
+	}()
+	go func() {
+		defer close(lines)
+		// unlike a listener, the conn doesn't remove its socket when closed
+		defer os.Remove(path)
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				select {
+				case <-abort:
+				default:
+					logrus.WithError(err).Warn("Error reading from unix socket, shutting it down")
+				}
+				return
+			}
+			if split != nil {
+				scanner := bufio.NewScanner(bytes.NewReader(buf[:n]))
+				scanner.Buffer(make([]byte, 0, n+1), n+1)
+				scanner.Split(split)
+				for scanner.Scan() {
+					if !sendRecord(scanner.Bytes(), path, limiter, lines, abort) {
+						return
+					}
+				}
+				continue
+			}
+			for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+				if !sendLine(line, path, limiter, lines, abort) {
+					return
+				}
+			}
+		}
+	}()
+	return lines
+}
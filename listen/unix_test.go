@@ -0,0 +1,125 @@
+package listen
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestUnixPath(t *testing.T) {
+	tests := []struct {
+		addr    string
+		network string
+		path    string
+		ok      bool
+	}{
+		{"unix:///run/honeytail.sock", "unix", "/run/honeytail.sock", true},
+		{"unixgram:///run/honeytail.sock", "unixgram", "/run/honeytail.sock", true},
+		{"unix://honeytail.sock", "unix", "honeytail.sock", true},
+		{"syslog-udp://0.0.0.0:514", "", "", false},
+	}
+	for _, tt := range tests {
+		network, path, ok := unixPath(tt.addr)
+		if network != tt.network || path != tt.path || ok != tt.ok {
+			t.Errorf("unixPath(%q) = %q, %q, %v", tt.addr, network, path, ok)
+		}
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	dir, err := ioutil.TempDir("", "listen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stream := filepath.Join(dir, "stream.sock")
+	dgram := filepath.Join(dir, "dgram.sock")
+	// left behind by a listener that's gone
+	stale, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: dgram, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.Close()
+
+	abort := make(chan struct{})
+	chans, err := GetEntries(Config{
+		Addrs:   []string{"unix://" + stream, "unixgram://" + dgram},
+		Options: ListenOptions{SocketMode: "0620"},
+	}, abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0620 {
+		t.Errorf("got socket mode %o, expected 0620", info.Mode().Perm())
+	}
+	if _, err := GetEntries(Config{
+		Addrs:   []string{"unix://" + stream},
+		Options: ListenOptions{SocketMode: "0666"},
+	}, nil); err == nil {
+		t.Error("expected an error listening on a socket in use")
+	}
+
+	conn, err := net.Dial("unix", stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("first\n\nsecond\r\n"))
+	expectLine(t, chans[0], "first")
+	expectLine(t, chans[0], "second")
+	conn.Close()
+
+	conn, err = net.Dial("unixgram", dgram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("one\ntwo\n"))
+	expectLine(t, chans[1], "one")
+	expectLine(t, chans[1], "two")
+	conn.Close()
+
+	close(abort)
+	for _, ch := range chans {
+		checkLinesChanClosed(t, ch)
+	}
+	for _, path := range []string{stream, dgram} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed on abort", path)
+		}
+	}
+}
+
+func TestListenUnixNotSocket(t *testing.T) {
+	f, err := ioutil.TempFile("", "listen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if _, err := GetEntries(Config{
+		Addrs:   []string{"unix://" + f.Name()},
+		Options: ListenOptions{SocketMode: "0666"},
+	}, nil); err == nil {
+		t.Error("expected an error replacing a file that isn't a socket")
+	}
+}
+
+func expectLine(t *testing.T, lines chan string, expected string) {
+	select {
+	case line := <-lines:
+		if line != expected {
+			t.Errorf("got line %q, expected %q", line, expected)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("timed out waiting for line %q", expected)
+	}
+}
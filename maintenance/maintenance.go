@@ -0,0 +1,336 @@
+// Package maintenance pauses sending events for planned network work,
+// without losing any.
+//
+// While in maintenance mode, files are still tailed and their offsets still
+// advance, but events are appended to a spool file on disk instead of being
+// sent. Once maintenance mode ends, by timing out or being ended early, the
+// spool is replayed. Maintenance mode is controlled through a unix socket,
+// so it can be toggled on a running honeytail.
+package maintenance
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+// replaySuffix is added to the spool's path while it's being replayed, so
+// events held in the meantime start a new spool
+const replaySuffix = ".replay"
+
+// Controller tracks whether maintenance mode is on, and holds events while
+// it is
+type Controller struct {
+	spoolPath string
+	// marker is called when maintenance mode starts, to annotate the graphs
+	marker func(start, end time.Time) error
+
+	lock  sync.Mutex
+	until time.Time
+	spool *os.File
+	// pending is set while there may be events in the spool
+	pending bool
+	// replaying is set while one of the pipelines replays the spool
+	replaying bool
+	// commands are the control commands registered with Handle
+	commands map[string]func(args []string) string
+	now      func() time.Time
+}
+
+// New returns a Controller that holds events in the file at spoolPath. marker,
+// if not nil, is called each time maintenance mode starts.
+func New(spoolPath string, marker func(start, end time.Time) error) *Controller {
+	return &Controller{
+		spoolPath: spoolPath,
+		marker:    marker,
+		// there may be a spool left over from last time
+		pending:  true,
+		commands: make(map[string]func(args []string) string),
+		now:      time.Now,
+	}
+}
+
+// Handle registers another control command. handle is called with the
+// command's arguments and returns the reply.
+func (c *Controller) Handle(name string, handle func(args []string) string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.commands[name] = handle
+}
+
+// Start turns on maintenance mode for d. The returned error is from creating
+// the marker; maintenance mode is on regardless.
+func (c *Controller) Start(d time.Duration) error {
+	c.lock.Lock()
+	start := c.now()
+	until := start.Add(d)
+	c.until = until
+	c.lock.Unlock()
+	logrus.WithFields(logrus.Fields{"until": until}).Info("Entering maintenance mode; holding events on disk")
+	if c.marker == nil {
+		return nil
+	}
+	return c.marker(start, until)
+}
+
+// End turns off maintenance mode early
+func (c *Controller) End() {
+	c.lock.Lock()
+	c.until = time.Time{}
+	c.lock.Unlock()
+	logrus.Info("Leaving maintenance mode")
+}
+
+// Until returns when maintenance mode ends, and whether it's on
+func (c *Controller) Until() (time.Time, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.until, c.now().Before(c.until)
+}
+
+// hold appends ev to the spool, or returns false if maintenance mode is off
+func (c *Controller) hold(ev event.Event) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.now().Before(c.until) {
+		return false, nil
+	}
+	if c.spool == nil {
+		f, err := os.OpenFile(c.spoolPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return true, err
+		}
+		c.spool = f
+	}
+	c.pending = true
+	encoded, err := json.Marshal(ev)
+	if err != nil {
+		return true, err
+	}
+	_, err = c.spool.Write(append(encoded, '\n'))
+	return true, err
+}
+
+// closeSpool closes the spool file, if it's open
+func (c *Controller) closeSpool() {
+	if c.spool != nil {
+		c.spool.Close()
+		c.spool = nil
+	}
+}
+
+// replay sends the events in the spool to out and removes it, unless
+// maintenance mode is on or another pipeline is already replaying it.
+// Replaying is left to a single pipeline so events aren't sent twice.
+func (c *Controller) replay(out chan<- event.Event) {
+	c.lock.Lock()
+	if !c.pending || c.replaying || c.now().Before(c.until) {
+		c.lock.Unlock()
+		return
+	}
+	c.closeSpool()
+	replayPath := c.spoolPath + replaySuffix
+	// a replay file is left behind if honeytail stopped part way through
+	// replaying; finish it before starting on the spool
+	if _, err := os.Stat(replayPath); os.IsNotExist(err) {
+		if err := os.Rename(c.spoolPath, replayPath); err != nil {
+			if os.IsNotExist(err) {
+				c.pending = false
+			}
+			c.lock.Unlock()
+			if !os.IsNotExist(err) {
+				logrus.WithFields(logrus.Fields{"err": err, "spool": c.spoolPath}).Error("Failed to replay maintenance spool")
+			}
+			return
+		}
+	}
+	c.replaying = true
+	c.pending = false
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		c.replaying = false
+		c.lock.Unlock()
+	}()
+	f, err := os.Open(replayPath)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"err": err, "spool": replayPath}).Error("Failed to replay maintenance spool")
+		return
+	}
+	defer f.Close()
+	var count int
+	dec := json.NewDecoder(f)
+	// keep integers as integers
+	dec.UseNumber()
+	for {
+		var ev event.Event
+		if err := dec.Decode(&ev); err != nil {
+			if err != io.EOF {
+				logrus.WithFields(logrus.Fields{"err": err, "spool": replayPath}).Error("Skipping the rest of a damaged maintenance spool")
+			}
+			break
+		}
+		out <- ev
+		count++
+	}
+	os.Remove(replayPath)
+	logrus.WithFields(logrus.Fields{"events": count}).Info("Replayed events held during maintenance mode")
+}
+
+// Hold passes through the events read from in, holding them in the spool
+// while maintenance mode is on and replaying the spool once it ends. Several
+// inputs may share a Controller. Events still held when in is closed stay in
+// the spool, and are replayed next time honeytail starts.
+func Hold(in chan event.Event, c *Controller) chan event.Event {
+	if c == nil {
+		return in
+	}
+	out := make(chan event.Event, cap(in))
+	go func() {
+		defer close(out)
+		// check every second whether maintenance mode has ended
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		// pick up anything left over from last time
+		c.replay(out)
+		for {
+			select {
+			case ev, ok := <-in:
+				if !ok {
+					c.replay(out)
+					c.lock.Lock()
+					c.closeSpool()
+					c.lock.Unlock()
+					if _, err := os.Stat(c.spoolPath); err == nil {
+						logrus.WithFields(logrus.Fields{"spool": c.spoolPath}).Warn("Events held during maintenance mode will be sent when honeytail next starts")
+					}
+					return
+				}
+				held, err := c.hold(ev)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{"err": err, "spool": c.spoolPath}).Error("Failed to hold event during maintenance mode; dropping it")
+				}
+				if !held {
+					// send anything held first, to keep events in order
+					c.replay(out)
+					out <- ev
+				} else if err == nil && ev.Done != nil {
+					// it's safe in the spool
+					ev.Done()
+				}
+			case <-ticker.C:
+				c.replay(out)
+			}
+		}
+	}()
+	return out
+}
+
+// Command runs a control command, returning the reply. The built in
+// commands are "maintenance <minutes>", "resume" and "status".
+func (c *Controller) Command(cmd string) string {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return "error: empty command"
+	}
+	switch fields[0] {
+	case "maintenance":
+		if len(fields) != 2 {
+			return "error: usage: maintenance <minutes>"
+		}
+		minutes, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil || minutes == 0 {
+			return fmt.Sprintf("error: invalid number of minutes %q", fields[1])
+		}
+		err = c.Start(time.Duration(minutes) * time.Minute)
+		until, _ := c.Until()
+		if err != nil {
+			return fmt.Sprintf("maintenance mode until %s, but creating the marker failed: %s", until.Format(time.RFC3339), err)
+		}
+		return fmt.Sprintf("maintenance mode until %s", until.Format(time.RFC3339))
+	case "resume":
+		c.End()
+		return "maintenance mode ended"
+	case "status":
+		if until, on := c.Until(); on {
+			return fmt.Sprintf("maintenance mode until %s", until.Format(time.RFC3339))
+		}
+		return "not in maintenance mode"
+	}
+	c.lock.Lock()
+	handle, ok := c.commands[fields[0]]
+	c.lock.Unlock()
+	if ok {
+		return handle(fields[1:])
+	}
+	return fmt.Sprintf("error: unknown command %q", fields[0])
+}
+
+// Listen accepts control commands, one per connection, on the unix socket at
+// path. The reply is written and the connection closed. Close the returned
+// listener to stop.
+func Listen(path string, c *Controller) (net.Listener, error) {
+	// a socket left behind by a previous run would stop us listening
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// replies may include the configuration, so only we may connect
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(10 * time.Second))
+				cmd, err := bufio.NewReader(conn).ReadString('\n')
+				if err != nil && err != io.EOF {
+					return
+				}
+				fmt.Fprintln(conn, c.Command(cmd))
+			}(conn)
+		}
+	}()
+	return l, nil
+}
+
+// Send sends a control command to the honeytail listening on the unix socket
+// at path, returning its reply
+func Send(path, cmd string) (string, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if _, err := fmt.Fprintln(conn, cmd); err != nil {
+		return "", err
+	}
+	reply, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(reply)), nil
+}
@@ -0,0 +1,147 @@
+package maintenance
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+var start = time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)
+
+func TestHold(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maintenance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var markers [][2]time.Time
+	c := New(filepath.Join(dir, "spool"), func(s, e time.Time) error {
+		markers = append(markers, [2]time.Time{s, e})
+		return nil
+	})
+	wall := start
+	c.now = func() time.Time { return wall }
+
+	in := make(chan event.Event)
+	out := Hold(in, c)
+
+	in <- event.Event{Timestamp: start, Data: map[string]interface{}{"n": 1}}
+	if ev := <-out; ev.Data["n"] != 1 {
+		t.Errorf("expected the event to pass straight through, got %+v", ev)
+	}
+
+	if reply := c.Command("maintenance 10"); reply != "maintenance mode until 2019-01-07T12:10:00Z" {
+		t.Errorf("unexpected reply %q", reply)
+	}
+	if !reflect.DeepEqual(markers, [][2]time.Time{{start, start.Add(10 * time.Minute)}}) {
+		t.Errorf("unexpected markers %+v", markers)
+	}
+	in <- event.Event{Timestamp: start, SampleRate: 4, Data: map[string]interface{}{"n": 2, "s": "held"}}
+	select {
+	case ev := <-out:
+		t.Fatalf("expected the event to be held, got %+v", ev)
+	case <-time.After(10 * time.Millisecond):
+	}
+	if _, err := os.Stat(c.spoolPath); err != nil {
+		t.Fatalf("expected the event to be spooled: %s", err)
+	}
+
+	// once maintenance mode ends the spool is replayed
+	if reply := c.Command("resume"); reply != "maintenance mode ended" {
+		t.Errorf("unexpected reply %q", reply)
+	}
+	in <- event.Event{Timestamp: start, Data: map[string]interface{}{"n": 3}}
+	held := <-out
+	expected := event.Event{Timestamp: start, SampleRate: 4, Data: map[string]interface{}{"n": json.Number("2"), "s": "held"}}
+	if !reflect.DeepEqual(held, expected) {
+		t.Errorf("got replayed event %+v, expected %+v", held, expected)
+	}
+	if ev := <-out; ev.Data["n"] != 3 {
+		t.Errorf("expected the new event after the replayed one, got %+v", ev)
+	}
+	close(in)
+	if _, ok := <-out; ok {
+		t.Error("expected out to be closed")
+	}
+	if _, err := os.Stat(c.spoolPath); !os.IsNotExist(err) {
+		t.Errorf("expected the spool to be removed, got %v", err)
+	}
+}
+
+func TestLeftoverSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maintenance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spool := filepath.Join(dir, "spool")
+	ioutil.WriteFile(spool, []byte(`{"Timestamp":"2019-01-07T12:00:00Z","Data":{"n":1}}`+"\n"), 0600)
+
+	in := make(chan event.Event)
+	out := Hold(in, New(spool, nil))
+	if ev := <-out; ev.Data["n"] != json.Number("1") {
+		t.Errorf("expected the leftover event to be replayed, got %+v", ev)
+	}
+	close(in)
+	<-out
+}
+
+func TestCommand(t *testing.T) {
+	c := New("unused", nil)
+	c.now = func() time.Time { return start }
+	for cmd, reply := range map[string]string{
+		"":               "error: empty command",
+		"maintenance":    "error: usage: maintenance <minutes>",
+		"maintenance 0":  `error: invalid number of minutes "0"`,
+		"maintenance 1h": `error: invalid number of minutes "1h"`,
+		"reboot":         `error: unknown command "reboot"`,
+		"status":         "not in maintenance mode",
+	} {
+		if got := c.Command(cmd); got != reply {
+			t.Errorf("got %q for %q, expected %q", got, cmd, reply)
+		}
+	}
+	c.Command("maintenance 5")
+	if got := c.Command("status"); got != "maintenance mode until 2019-01-07T12:05:00Z" {
+		t.Errorf("unexpected status %q", got)
+	}
+}
+
+func TestListen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maintenance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "control.sock")
+	l, err := Listen(sock, New(filepath.Join(dir, "spool"), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	reply, err := Send(sock, "maintenance 30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(reply, "maintenance mode until ") {
+		t.Errorf("unexpected reply %q", reply)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	c := New("unused", nil)
+	c.Handle("echo", func(args []string) string {
+		return strings.Join(args, "\n")
+	})
+	if reply := c.Command("echo a b"); reply != "a\nb" {
+		t.Errorf("unexpected reply %q", reply)
+	}
+}
@@ -0,0 +1,356 @@
+// Package auditd parses the Linux audit log, as written by auditd to
+// /var/log/audit/audit.log, eg
+//
+//	type=SYSCALL msg=audit(1364481363.243:24287): arch=c000003e syscall=2 success=no exit=-13 ...
+//
+// The kernel logs each event as several records sharing the audit id after
+// the timestamp, ending with an EOE record, so records are joined into one
+// event per id. The first record's fields are added as they are, and later
+// records' fields are prefixed with their lowercased type, and their item
+// number if they have one, eg path.0.name. Strings the kernel doesn't trust
+// are logged hex-encoded if they contain spaces or control characters; these
+// are decoded.
+package auditd
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	typeKey = "type"
+	idKey   = "audit_id"
+	nodeKey = "node"
+
+	// endOfEvent is the type of the record ending a multi-record event
+	endOfEvent = "EOE"
+	// maxPending is the most events waiting for their remaining records.
+	// The kernel's records for an event are written close together, so once
+	// this many newer events have started, the oldest is sent.
+	maxPending = 16
+)
+
+// headerRegex matches the start of each record
+var headerRegex = regexp.MustCompile(`^(?:node=(\S+) )?type=(\S+) msg=audit\((\d+)\.(\d+):(\d+)\): ?(.*)$`)
+
+// execveArg matches the fields holding the arguments of an EXECVE record
+var execveArg = regexp.MustCompile(`^a(\d+)$`)
+
+// encodedKeys are logged hex-encoded when their value contains characters
+// that could be mistaken for the log's own syntax
+var encodedKeys = map[string]bool{
+	"acct":      true,
+	"cmd":       true,
+	"comm":      true,
+	"cwd":       true,
+	"data":      true,
+	"dir":       true,
+	"exe":       true,
+	"file":      true,
+	"grp":       true,
+	"key":       true,
+	"name":      true,
+	"ocomm":     true,
+	"path":      true,
+	"proctitle": true,
+	"watch":     true,
+}
+
+type Options struct {
+	FlushTimeoutMs uint `long:"flush_timeout_ms" description:"Send events still waiting for records once no lines have arrived for this long" default:"1000"`
+}
+
+type Parser struct {
+	// set SampleRate to cause the parser to drop events after their records
+	// are joined, before they're sent
+	SampleRate int
+
+	conf Options
+}
+
+// record is a single line of the audit log
+type record struct {
+	node   string
+	typ    string
+	ts     time.Time
+	id     int
+	fields map[string]interface{}
+	// userSpace is set for records logged by programs rather than the
+	// kernel, which put their fields in a quoted msg. These stand alone.
+	userSpace bool
+}
+
+// auditEvent is an event being assembled from its records
+type auditEvent struct {
+	ts           time.Time
+	data         map[string]interface{}
+	prefixFields map[string]string
+	// seen counts the records of each type, to keep repeated types apart
+	seen map[string]int
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	return nil
+}
+
+// parseRecord parses a record's header and fields
+func parseRecord(line string) (*record, error) {
+	match := headerRegex.FindStringSubmatch(line)
+	if match == nil {
+		return nil, errors.New("line is not an audit record")
+	}
+	sec, _ := strconv.ParseInt(match[3], 10, 64)
+	ms, _ := strconv.ParseInt(match[4], 10, 64)
+	id, err := strconv.Atoi(match[5])
+	if err != nil {
+		return nil, err
+	}
+	body := match[6]
+	// the enriched log format adds the interpreted values of ids after a
+	// group separator; the raw values are kept
+	if i := strings.IndexByte(body, '\x1d'); i >= 0 {
+		body = body[:i]
+	}
+	r := &record{
+		node:   match[1],
+		typ:    match[2],
+		ts:     time.Unix(sec, ms*int64(time.Millisecond)).UTC(),
+		id:     id,
+		fields: make(map[string]interface{}),
+	}
+	r.userSpace = parseFields(body, r.typ, r.fields)
+	return r, nil
+}
+
+// parseFields adds the key=value pairs in s to fields. Values may be double
+// quoted; user space records put their own fields in a single quoted msg,
+// which is parsed in turn. It returns true if there was one.
+func parseFields(s, typ string, fields map[string]interface{}) bool {
+	userSpace := false
+	for {
+		s = strings.TrimLeft(s, " ")
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return userSpace
+		}
+		key := s[:eq]
+		if sp := strings.IndexByte(key, ' '); sp >= 0 {
+			// a word without a value; skip it
+			s = s[sp:]
+			continue
+		}
+		s = s[eq+1:]
+		var val string
+		quote := byte(0)
+		if len(s) > 0 && (s[0] == '"' || s[0] == '\'') {
+			quote = s[0]
+			end := strings.IndexByte(s[1:], quote)
+			if end < 0 {
+				end = len(s) - 1
+			}
+			val = s[1 : end+1]
+			s = s[min(end+2, len(s)):]
+		} else {
+			end := strings.IndexByte(s, ' ')
+			if end < 0 {
+				end = len(s)
+			}
+			val = s[:end]
+			s = s[end:]
+		}
+		switch {
+		case quote == '\'':
+			parseFields(val, typ, fields)
+			userSpace = true
+		case quote == '"':
+			fields[key] = val
+		case encodedKeys[key] || typ == "EXECVE" && execveArg.MatchString(key):
+			fields[key] = decode(val)
+		default:
+			if n, err := strconv.Atoi(val); err == nil {
+				fields[key] = n
+			} else {
+				fields[key] = val
+			}
+		}
+	}
+}
+
+// decode decodes a hex-encoded string. Unencoded values, such as (null),
+// are returned as they are. The NULs separating a process title's arguments
+// are replaced with spaces.
+func decode(s string) string {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return s
+	}
+	return strings.TrimRight(strings.Replace(string(b), "\x00", " ", -1), " ")
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// add adds a record's fields to the event
+func (e *auditEvent) add(r *record) {
+	if len(e.seen) == 0 {
+		e.data[typeKey] = r.typ
+		for k, v := range r.fields {
+			e.data[k] = v
+		}
+		e.seen[r.typ]++
+		return
+	}
+	prefix := strings.ToLower(r.typ) + "."
+	if item, ok := r.fields["item"].(int); ok {
+		prefix += strconv.Itoa(item) + "."
+	} else if n := e.seen[r.typ]; n > 0 {
+		prefix += strconv.Itoa(n) + "."
+	}
+	e.seen[r.typ]++
+	for k, v := range r.fields {
+		if k == "item" {
+			continue
+		}
+		e.data[prefix+k] = v
+	}
+	if r.typ == "EXECVE" {
+		e.data[prefix+"command"] = command(r.fields)
+	}
+}
+
+// command joins an EXECVE record's arguments
+func command(fields map[string]interface{}) string {
+	argc, _ := fields["argc"].(int)
+	args := make([]string, 0, argc)
+	for i := 0; i < argc; i++ {
+		arg, ok := fields["a"+strconv.Itoa(i)]
+		if !ok {
+			break
+		}
+		args = append(args, arg.(string))
+	}
+	return strings.Join(args, " ")
+}
+
+// ProcessLines joins records into events in a single goroutine, as an
+// event's records must all reach the same place. For the same reason,
+// events are sampled here rather than while tailing.
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	pending := make(map[int]*auditEvent)
+	// order holds the ids of pending events, oldest first
+	var order []int
+	flush := func(id int) {
+		e, ok := pending[id]
+		if !ok {
+			return
+		}
+		delete(pending, id)
+		for i, o := range order {
+			if o == id {
+				order = append(order[:i], order[i+1:]...)
+				break
+			}
+		}
+		// if sampling is disabled or sampler says keep, pass along this event.
+		if p.SampleRate > 1 && rand.Intn(p.SampleRate) != 0 {
+			return
+		}
+		// merge the prefix fields and the event contents
+		for k, v := range e.prefixFields {
+			e.data[k] = v
+		}
+		send <- event.Event{
+			Timestamp:  e.ts,
+			SampleRate: p.SampleRate,
+			Data:       e.data,
+		}
+	}
+	flushAll := func() {
+		for len(order) > 0 {
+			flush(order[0])
+		}
+	}
+	timeout := time.Duration(p.conf.FlushTimeoutMs) * time.Millisecond
+	timer := time.NewTimer(timeout)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				flushAll()
+				timer.Stop()
+				logrus.Debug("lines channel is closed, ending auditd processor")
+				return
+			}
+			logrus.WithFields(logrus.Fields{
+				"line": line,
+			}).Debug("Attempting to process audit log line")
+
+			// take care of any headers on the line
+			var prefixFields map[string]string
+			if prefixRegex != nil {
+				var prefix string
+				prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+				line = strings.TrimPrefix(line, prefix)
+			}
+
+			r, err := parseRecord(line)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"line":  line,
+					"error": err,
+				}).Debug("skipping line; failed to parse.")
+			} else if r.typ == endOfEvent {
+				flush(r.id)
+			} else {
+				e, ok := pending[r.id]
+				if !ok {
+					e = &auditEvent{
+						ts: r.ts,
+						data: map[string]interface{}{
+							idKey: r.id,
+						},
+						prefixFields: prefixFields,
+						seen:         make(map[string]int),
+					}
+					if r.node != "" {
+						e.data[nodeKey] = r.node
+					}
+					pending[r.id] = e
+					order = append(order, r.id)
+				}
+				e.add(r)
+				if r.userSpace {
+					flush(r.id)
+				}
+				for len(order) > maxPending {
+					flush(order[0])
+				}
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(timeout)
+		case <-timer.C:
+			flushAll()
+			timer.Reset(timeout)
+		}
+	}
+}
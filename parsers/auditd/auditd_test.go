@@ -0,0 +1,146 @@
+package auditd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+func processLines(p *Parser, lines []string, prefixRegex *parsers.ExtRegexp) []event.Event {
+	linesChan := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		for _, line := range lines {
+			linesChan <- line
+		}
+		close(linesChan)
+	}()
+	go func() {
+		p.ProcessLines(linesChan, send, prefixRegex)
+		close(send)
+	}()
+	var events []event.Event
+	for ev := range send {
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestParseRecord(t *testing.T) {
+	r, err := parseRecord(`node=web1 type=SYSCALL msg=audit(1364481363.243:24287): arch=c000003e syscall=2 success=no exit=-13 a0=7fffd19c5592 comm="cat" exe=2F7573722F62696E2F636174 key=(null)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &record{
+		node: "web1",
+		typ:  "SYSCALL",
+		ts:   time.Date(2013, 3, 28, 14, 36, 3, 243000000, time.UTC),
+		id:   24287,
+		fields: map[string]interface{}{
+			"arch":    "c000003e",
+			"syscall": 2,
+			"success": "no",
+			"exit":    -13,
+			"a0":      "7fffd19c5592",
+			"comm":    "cat",
+			"exe":     "/usr/bin/cat",
+			"key":     "(null)",
+		},
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("got %+v, expected %+v", r, expected)
+	}
+
+	r, err = parseRecord(`type=USER_LOGIN msg=audit(1364481363.5:24290): pid=1234 uid=0 auid=1000 ses=3 msg='op=login acct="alice" exe="/usr/sbin/sshd" hostname=? addr=192.0.2.1 terminal=ssh res=success'` + "\x1dUID=\"root\"")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedFields := map[string]interface{}{
+		"pid":      1234,
+		"uid":      0,
+		"auid":     1000,
+		"ses":      3,
+		"op":       "login",
+		"acct":     "alice",
+		"exe":      "/usr/sbin/sshd",
+		"hostname": "?",
+		"addr":     "192.0.2.1",
+		"terminal": "ssh",
+		"res":      "success",
+	}
+	if !r.userSpace || !reflect.DeepEqual(r.fields, expectedFields) {
+		t.Errorf("got %+v, expected user space fields %+v", r, expectedFields)
+	}
+
+	if _, err := parseRecord("not an audit record"); err == nil {
+		t.Error("expected error parsing a line that isn't an audit record")
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{}
+	p.Init(&Options{FlushTimeoutMs: 60000})
+	events := processLines(p, []string{
+		`type=SYSCALL msg=audit(1364481363.243:24287): syscall=59 success=yes exit=0 comm="ls" exe="/usr/bin/ls"`,
+		`type=USER_CMD msg=audit(1364481363.244:24288): pid=99 msg='cmd=6C73202D6C exe="/usr/bin/sudo" res=success'`,
+		`type=EXECVE msg=audit(1364481363.243:24287): argc=3 a0="ls" a1="-l" a2=2F746D702F6D7920646972`,
+		`type=CWD msg=audit(1364481363.243:24287): cwd="/root"`,
+		`type=PATH msg=audit(1364481363.243:24287): item=0 name="/usr/bin/ls" inode=1234`,
+		`type=PATH msg=audit(1364481363.243:24287): item=1 name=(null) inode=5678`,
+		`type=PROCTITLE msg=audit(1364481363.243:24287): proctitle=6C73002D6C`,
+		`type=EOE msg=audit(1364481363.243:24287): `,
+		`type=SYSCALL msg=audit(1364481364.000:24289): syscall=2 success=no`,
+		"garbage",
+	}, nil)
+	expected := []event.Event{
+		{
+			Timestamp: time.Date(2013, 3, 28, 14, 36, 3, 244000000, time.UTC),
+			Data: map[string]interface{}{
+				"audit_id": 24288,
+				"type":     "USER_CMD",
+				"pid":      99,
+				"cmd":      "ls -l",
+				"exe":      "/usr/bin/sudo",
+				"res":      "success",
+			},
+		},
+		{
+			Timestamp: time.Date(2013, 3, 28, 14, 36, 3, 243000000, time.UTC),
+			Data: map[string]interface{}{
+				"audit_id":            24287,
+				"type":                "SYSCALL",
+				"syscall":             59,
+				"success":             "yes",
+				"exit":                0,
+				"comm":                "ls",
+				"exe":                 "/usr/bin/ls",
+				"execve.argc":         3,
+				"execve.a0":           "ls",
+				"execve.a1":           "-l",
+				"execve.a2":           "/tmp/my dir",
+				"execve.command":      "ls -l /tmp/my dir",
+				"cwd.cwd":             "/root",
+				"path.0.name":         "/usr/bin/ls",
+				"path.0.inode":        1234,
+				"path.1.name":         "(null)",
+				"path.1.inode":        5678,
+				"proctitle.proctitle": "ls -l",
+			},
+		},
+		{
+			Timestamp: time.Date(2013, 3, 28, 14, 36, 4, 0, time.UTC),
+			Data: map[string]interface{}{
+				"audit_id": 24289,
+				"type":     "SYSCALL",
+				"syscall":  2,
+				"success":  "no",
+			},
+		},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("got events %+v, expected %+v", events, expected)
+	}
+}
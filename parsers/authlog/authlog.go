@@ -0,0 +1,351 @@
+// Package authlog parses the Linux authentication log (auth.log, or secure
+// on Red Hat), as written by sshd, sudo and PAM through syslog.
+//
+// Logins, failed attempts, sudo invocations and PAM sessions get user,
+// source_ip, source_port, auth_method and outcome fields, and an action
+// saying what happened. Other lines keep their text as the message field.
+package authlog
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	hostnameKey   = "hostname"
+	processKey    = "process"
+	pidKey        = "pid"
+	messageKey    = "message"
+	actionKey     = "action"
+	outcomeKey    = "outcome"
+	userKey       = "user"
+	sourceIPKey   = "source_ip"
+	sourcePortKey = "source_port"
+	methodKey     = "auth_method"
+
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+
+	// syslog timestamps have no year
+	timeLayout = "Jan _2 15:04:05"
+	// timestamps further than this into the future are taken to be from the
+	// year before, eg a December line read in January
+	maxFutureSkew = 7 * 24 * time.Hour
+)
+
+// lineRegex matches the syslog header, with either a traditional or an
+// RFC3339 timestamp, and the process that wrote the line
+var lineRegex = regexp.MustCompile(`^(\w{3} [ \d]\d \d{2}:\d{2}:\d{2}|\d{4}-\d{2}-\d{2}T\S+) (\S+) ([^\[:\s]+)(?:\[(\d+)\])?: (.*)$`)
+
+var (
+	// sshdAuth matches sshd's accepted and failed authentication attempts,
+	// eg "Accepted publickey for alice from 192.0.2.1 port 51234 ssh2: RSA
+	// SHA256:..."
+	sshdAuth = regexp.MustCompile(`^(Accepted|Failed) (\S+) for (invalid user )?(\S*) from (\S+) port (\d+)(?: (\w+))?(?:: (\S+) (\S+))?`)
+	// sshdInvalidUser matches sshd refusing a user that doesn't exist
+	sshdInvalidUser = regexp.MustCompile(`^Invalid user (\S*) from (\S+)(?: port (\d+))?`)
+	// sshdDisconnect matches the end of a connection, before or after
+	// authenticating
+	sshdDisconnect = regexp.MustCompile(`^(?:Disconnected from|Connection closed by|Received disconnect from)(?: (?:invalid|authenticating))?(?: user (\S+))? (\S+) port (\d+)`)
+	// pamMessage matches a PAM module's message, eg "pam_unix(sshd:session):
+	// session opened for user alice by (uid=0)"
+	pamMessage = regexp.MustCompile(`^(pam_\w+)\(([^:)]+):(\w+)\): (.*)$`)
+	// pamSession matches a session opening or closing, with newer versions
+	// of PAM adding the uid after the user
+	pamSession = regexp.MustCompile(`^session (opened|closed) for user (\S+?)(?:\(uid=(\d+)\))?(?: by (\S*)\(uid=(\d+)\))?$`)
+	// pamAuthFailure matches PAM's authentication failure, with the details
+	// as key=value pairs
+	pamAuthFailure = regexp.MustCompile(`^authentication failure; (.*)$`)
+	// keyVal matches a key=value pair in a PAM message
+	keyVal = regexp.MustCompile(`(\w+)=(\S*)`)
+)
+
+type Options struct {
+	TimeZone string `long:"timezone" description:"IANA name of the time zone the log was written in, eg America/Los_Angeles. Defaults to the local time zone"`
+
+	NumParsers int `hidden:"true" description:"number of authlog parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, time.Time, error)
+}
+
+type AuthLineParser struct {
+	loc   *time.Location
+	nower Nower
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	loc := time.Local
+	if p.conf.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(p.conf.TimeZone); err != nil {
+			return err
+		}
+	}
+	p.lineParser = &AuthLineParser{loc: loc, nower: p.nower}
+	return nil
+}
+
+// ParseLine parses the syslog header and hands the message to the parser for
+// the program that wrote it
+func (a *AuthLineParser) ParseLine(line string) (map[string]interface{}, time.Time, error) {
+	match := lineRegex.FindStringSubmatch(line)
+	if match == nil {
+		return nil, time.Time{}, errors.New("line is not a syslog line")
+	}
+	ts, err := a.parseTime(match[1])
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	parsed := map[string]interface{}{
+		hostnameKey: match[2],
+		processKey:  match[3],
+	}
+	if pid, err := strconv.Atoi(match[4]); err == nil {
+		parsed[pidKey] = pid
+	}
+	msg := match[5]
+	var ok bool
+	switch {
+	case strings.HasPrefix(msg, "pam_"):
+		ok = parsePAM(msg, parsed)
+	case match[3] == "sshd":
+		ok = parseSSHD(msg, parsed)
+	case match[3] == "sudo":
+		ok = parseSudo(msg, parsed)
+	}
+	if !ok {
+		parsed[messageKey] = msg
+	}
+	return parsed, ts, nil
+}
+
+// parseSSHD recognizes sshd's authentication attempts and disconnections
+func parseSSHD(msg string, parsed map[string]interface{}) bool {
+	if m := sshdAuth.FindStringSubmatch(msg); m != nil {
+		parsed[actionKey] = "login"
+		parsed[outcomeKey] = outcomeSuccess
+		if m[1] == "Failed" {
+			parsed[outcomeKey] = outcomeFailure
+		}
+		parsed[methodKey] = m[2]
+		if m[3] != "" {
+			parsed["invalid_user"] = true
+		}
+		parsed[userKey] = m[4]
+		addSource(m[5], m[6], parsed)
+		if m[7] != "" {
+			parsed["protocol"] = m[7]
+		}
+		if m[8] != "" {
+			parsed["key_type"] = m[8]
+			parsed["key_fingerprint"] = m[9]
+		}
+		return true
+	}
+	if m := sshdInvalidUser.FindStringSubmatch(msg); m != nil {
+		parsed[actionKey] = "invalid_user"
+		parsed[outcomeKey] = outcomeFailure
+		parsed["invalid_user"] = true
+		parsed[userKey] = m[1]
+		addSource(m[2], m[3], parsed)
+		return true
+	}
+	if m := sshdDisconnect.FindStringSubmatch(msg); m != nil {
+		parsed[actionKey] = "disconnect"
+		if m[1] != "" {
+			parsed[userKey] = m[1]
+		}
+		addSource(m[2], m[3], parsed)
+		if strings.HasSuffix(msg, "[preauth]") {
+			parsed["preauth"] = true
+		}
+		return true
+	}
+	return false
+}
+
+// parseSudo recognizes sudo's log of each command, eg "alice : TTY=pts/0 ;
+// PWD=/home/alice ; USER=root ; COMMAND=/bin/ls". Refusals put the reason
+// before the TTY, eg "alice : user NOT in sudoers ; TTY=...".
+func parseSudo(msg string, parsed map[string]interface{}) bool {
+	parts := strings.Split(msg, " ; ")
+	head := strings.SplitN(parts[0], " : ", 2)
+	if len(head) != 2 || len(parts) < 2 {
+		return false
+	}
+	parsed[actionKey] = "sudo"
+	parsed[userKey] = strings.TrimSpace(head[0])
+	parsed[outcomeKey] = outcomeSuccess
+	fields := append([]string{head[1]}, parts[1:]...)
+	if !strings.Contains(head[1], "=") {
+		parsed[outcomeKey] = outcomeFailure
+		parsed["reason"] = head[1]
+		fields = parts[1:]
+	}
+	for _, f := range fields {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "TTY":
+			parsed["tty"] = kv[1]
+		case "PWD":
+			parsed["pwd"] = kv[1]
+		case "USER":
+			parsed["target_user"] = kv[1]
+		case "COMMAND":
+			// the command is last and may contain ;
+			i := strings.Index(msg, "COMMAND=")
+			parsed["command"] = msg[i+len("COMMAND="):]
+		}
+	}
+	return true
+}
+
+// parsePAM recognizes session and authentication failure messages from PAM
+// modules
+func parsePAM(msg string, parsed map[string]interface{}) bool {
+	m := pamMessage.FindStringSubmatch(msg)
+	if m == nil {
+		return false
+	}
+	parsed["pam_module"] = m[1]
+	parsed["pam_service"] = m[2]
+	parsed["pam_type"] = m[3]
+	rest := m[4]
+	if s := pamSession.FindStringSubmatch(rest); s != nil {
+		parsed[actionKey] = "session_" + s[1]
+		parsed[userKey] = s[2]
+		if uid, err := strconv.Atoi(s[3]); err == nil {
+			parsed["uid"] = uid
+		}
+		if s[4] != "" {
+			parsed["by_user"] = s[4]
+		}
+		if uid, err := strconv.Atoi(s[5]); err == nil {
+			parsed["by_uid"] = uid
+		}
+		return true
+	}
+	if f := pamAuthFailure.FindStringSubmatch(rest); f != nil {
+		parsed[actionKey] = "auth_failure"
+		parsed[outcomeKey] = outcomeFailure
+		for _, kv := range keyVal.FindAllStringSubmatch(f[1], -1) {
+			if kv[2] == "" {
+				continue
+			}
+			switch kv[1] {
+			case "user":
+				parsed[userKey] = kv[2]
+			case "rhost":
+				parsed[sourceIPKey] = kv[2]
+			default:
+				parsed[kv[1]] = kv[2]
+			}
+		}
+		return true
+	}
+	parsed[messageKey] = rest
+	return true
+}
+
+// addSource adds the address and port a connection came from
+func addSource(ip, port string, parsed map[string]interface{}) {
+	parsed[sourceIPKey] = ip
+	if n, err := strconv.Atoi(port); err == nil {
+		parsed[sourcePortKey] = n
+	}
+}
+
+// parseTime parses either timestamp format, filling in the year missing from
+// traditional syslog timestamps. The current year is assumed unless that
+// puts the line well into the future, in which case it must have been
+// written last year.
+func (a *AuthLineParser) parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t.UTC(), nil
+	}
+	now := a.nower.Now().In(a.loc)
+	t, err := time.ParseInLocation(timeLayout, s, a.loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	ts := time.Date(now.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, a.loc)
+	if ts.Sub(now) > maxFutureSkew {
+		ts = ts.AddDate(-1, 0, 0)
+	}
+	return ts.UTC(), nil
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process auth log line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, ts, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp: ts,
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending authlog processor")
+}
@@ -0,0 +1,193 @@
+package authlog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+func TestParseLine(t *testing.T) {
+	p := &AuthLineParser{loc: time.UTC, nower: &FakeNower{}}
+	tlms := []struct {
+		line     string
+		expected map[string]interface{}
+		ts       time.Time
+	}{
+		{
+			line: "Jun 21 10:00:00 web1 sshd[1234]: Accepted publickey for alice from 192.0.2.1 port 51234 ssh2: RSA SHA256:abcdef",
+			expected: map[string]interface{}{
+				"hostname":        "web1",
+				"process":         "sshd",
+				"pid":             1234,
+				"action":          "login",
+				"outcome":         "success",
+				"auth_method":     "publickey",
+				"user":            "alice",
+				"source_ip":       "192.0.2.1",
+				"source_port":     51234,
+				"protocol":        "ssh2",
+				"key_type":        "RSA",
+				"key_fingerprint": "SHA256:abcdef",
+			},
+			ts: time.Date(2010, 6, 21, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			line: "Dec 31 23:59:59 web1 sshd[1235]: Failed password for invalid user bob from 192.0.2.2 port 4242 ssh2",
+			expected: map[string]interface{}{
+				"hostname":     "web1",
+				"process":      "sshd",
+				"pid":          1235,
+				"action":       "login",
+				"outcome":      "failure",
+				"auth_method":  "password",
+				"invalid_user": true,
+				"user":         "bob",
+				"source_ip":    "192.0.2.2",
+				"source_port":  4242,
+				"protocol":     "ssh2",
+			},
+			ts: time.Date(2009, 12, 31, 23, 59, 59, 0, time.UTC),
+		},
+		{
+			line: "2010-06-21T10:00:01.5+02:00 web1 sshd[1235]: Invalid user bob from 192.0.2.2 port 4242",
+			expected: map[string]interface{}{
+				"hostname":     "web1",
+				"process":      "sshd",
+				"pid":          1235,
+				"action":       "invalid_user",
+				"outcome":      "failure",
+				"invalid_user": true,
+				"user":         "bob",
+				"source_ip":    "192.0.2.2",
+				"source_port":  4242,
+			},
+			ts: time.Date(2010, 6, 21, 8, 0, 1, 500000000, time.UTC),
+		},
+		{
+			line: "Jun 21 10:00:02 web1 sshd[1236]: Connection closed by authenticating user root 192.0.2.3 port 22 [preauth]",
+			expected: map[string]interface{}{
+				"hostname":    "web1",
+				"process":     "sshd",
+				"pid":         1236,
+				"action":      "disconnect",
+				"user":        "root",
+				"source_ip":   "192.0.2.3",
+				"source_port": 22,
+				"preauth":     true,
+			},
+			ts: time.Date(2010, 6, 21, 10, 0, 2, 0, time.UTC),
+		},
+		{
+			line: "Jun 21 10:00:03 web1 sudo:    alice : TTY=pts/0 ; PWD=/home/alice ; USER=root ; COMMAND=/bin/sh -c echo a ; echo b",
+			expected: map[string]interface{}{
+				"hostname":    "web1",
+				"process":     "sudo",
+				"action":      "sudo",
+				"outcome":     "success",
+				"user":        "alice",
+				"tty":         "pts/0",
+				"pwd":         "/home/alice",
+				"target_user": "root",
+				"command":     "/bin/sh -c echo a ; echo b",
+			},
+			ts: time.Date(2010, 6, 21, 10, 0, 3, 0, time.UTC),
+		},
+		{
+			line: "Jun 21 10:00:04 web1 sudo:      bob : user NOT in sudoers ; TTY=pts/1 ; PWD=/tmp ; USER=root ; COMMAND=/usr/bin/id",
+			expected: map[string]interface{}{
+				"hostname":    "web1",
+				"process":     "sudo",
+				"action":      "sudo",
+				"outcome":     "failure",
+				"reason":      "user NOT in sudoers",
+				"user":        "bob",
+				"tty":         "pts/1",
+				"pwd":         "/tmp",
+				"target_user": "root",
+				"command":     "/usr/bin/id",
+			},
+			ts: time.Date(2010, 6, 21, 10, 0, 4, 0, time.UTC),
+		},
+		{
+			line: "Jun 21 10:00:05 web1 sshd[1234]: pam_unix(sshd:session): session opened for user alice(uid=1000) by (uid=0)",
+			expected: map[string]interface{}{
+				"hostname":    "web1",
+				"process":     "sshd",
+				"pid":         1234,
+				"pam_module":  "pam_unix",
+				"pam_service": "sshd",
+				"pam_type":    "session",
+				"action":      "session_opened",
+				"user":        "alice",
+				"uid":         1000,
+				"by_uid":      0,
+			},
+			ts: time.Date(2010, 6, 21, 10, 0, 5, 0, time.UTC),
+		},
+		{
+			line: "Jun 21 10:00:06 web1 sudo: pam_unix(sudo:session): session closed for user root",
+			expected: map[string]interface{}{
+				"hostname":    "web1",
+				"process":     "sudo",
+				"pam_module":  "pam_unix",
+				"pam_service": "sudo",
+				"pam_type":    "session",
+				"action":      "session_closed",
+				"user":        "root",
+			},
+			ts: time.Date(2010, 6, 21, 10, 0, 6, 0, time.UTC),
+		},
+		{
+			line: "Jun 21 10:00:07 web1 sshd[1237]: pam_unix(sshd:auth): authentication failure; logname= uid=0 euid=0 tty=ssh ruser= rhost=192.0.2.4  user=root",
+			expected: map[string]interface{}{
+				"hostname":    "web1",
+				"process":     "sshd",
+				"pid":         1237,
+				"pam_module":  "pam_unix",
+				"pam_service": "sshd",
+				"pam_type":    "auth",
+				"action":      "auth_failure",
+				"outcome":     "failure",
+				"uid":         "0",
+				"euid":        "0",
+				"tty":         "ssh",
+				"source_ip":   "192.0.2.4",
+				"user":        "root",
+			},
+			ts: time.Date(2010, 6, 21, 10, 0, 7, 0, time.UTC),
+		},
+		{
+			line: "Jun 21 10:00:08 web1 systemd-logind[500]: New session 12 of user alice.",
+			expected: map[string]interface{}{
+				"hostname": "web1",
+				"process":  "systemd-logind",
+				"pid":      500,
+				"message":  "New session 12 of user alice.",
+			},
+			ts: time.Date(2010, 6, 21, 10, 0, 8, 0, time.UTC),
+		},
+	}
+	for _, tlm := range tlms {
+		parsed, ts, err := p.ParseLine(tlm.line)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tlm.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(parsed, tlm.expected) {
+			t.Errorf("parsing %q got %+v, expected %+v", tlm.line, parsed, tlm.expected)
+		}
+		if !ts.Equal(tlm.ts) {
+			t.Errorf("parsing %q got time %s, expected %s", tlm.line, ts, tlm.ts)
+		}
+	}
+	if _, _, err := p.ParseLine("not a syslog line"); err == nil {
+		t.Error("expected error parsing a non-syslog line")
+	}
+}
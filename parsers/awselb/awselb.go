@@ -0,0 +1,271 @@
+// Package awselb parses access logs from AWS Classic Load Balancers (ELB) and
+// Application Load Balancers (ALB).
+//
+// Field names follow the AWS documentation:
+// https://docs.aws.amazon.com/elasticloadbalancing/latest/classic/access-log-collection.html
+// https://docs.aws.amazon.com/elasticloadbalancing/latest/application/load-balancer-access-logs.html
+package awselb
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	timeKey    = "time"
+	requestKey = "request"
+)
+
+// classicFields are the fields of a Classic Load Balancer log line, in order
+var classicFields = []string{
+	"time", "elb", "client", "backend",
+	"request_processing_time", "backend_processing_time", "response_processing_time",
+	"elb_status_code", "backend_status_code", "received_bytes", "sent_bytes",
+	"request", "user_agent", "ssl_cipher", "ssl_protocol",
+}
+
+// albFields are the fields of an Application Load Balancer log line, in
+// order. AWS may append fields in the future; any extras are ignored.
+var albFields = []string{
+	"type", "time", "elb", "client", "target",
+	"request_processing_time", "target_processing_time", "response_processing_time",
+	"elb_status_code", "target_status_code", "received_bytes", "sent_bytes",
+	"request", "user_agent", "ssl_cipher", "ssl_protocol", "target_group_arn",
+	"trace_id", "domain_name", "chosen_cert_arn", "matched_rule_priority",
+	"request_creation_time", "actions_executed", "redirect_url", "error_reason",
+	"target_port_list", "target_status_code_list", "classification",
+	"classification_reason", "conn_trace_id",
+}
+
+// albTypes are the values the first field of an ALB log line can take
+var albTypes = map[string]bool{
+	"http": true, "https": true, "h2": true, "grpcs": true, "ws": true, "wss": true,
+}
+
+// field types, for the fields that aren't strings
+var (
+	floatFields = map[string]bool{
+		"request_processing_time":  true,
+		"backend_processing_time":  true,
+		"target_processing_time":   true,
+		"response_processing_time": true,
+	}
+	intFields = map[string]bool{
+		"elb_status_code":       true,
+		"backend_status_code":   true,
+		"target_status_code":    true,
+		"received_bytes":        true,
+		"sent_bytes":            true,
+		"matched_rule_priority": true,
+	}
+	// address fields are split into _ip and _port
+	addrFields = map[string]bool{
+		"client":  true,
+		"backend": true,
+		"target":  true,
+	}
+)
+
+type Options struct {
+	NumParsers int `hidden:"true" description:"number of awselb parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, error)
+}
+
+type ELBLineParser struct{}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	p.lineParser = &ELBLineParser{}
+	return nil
+}
+
+// ParseLine parses a Classic or Application Load Balancer log line, telling
+// them apart by the leading type field that only ALB logs have
+func (e *ELBLineParser) ParseLine(line string) (map[string]interface{}, error) {
+	tokens, err := tokenize(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("empty line")
+	}
+	names := classicFields
+	if albTypes[tokens[0]] {
+		names = albFields
+	}
+	if len(tokens) < len(classicFields)-2 {
+		// the ssl fields were added to classic logs later, so may be missing
+		return nil, errors.New("too few fields for a load balancer log line")
+	}
+
+	parsed := make(map[string]interface{})
+	for i, tok := range tokens {
+		if i >= len(names) {
+			break
+		}
+		name := names[i]
+		// - and -1 mean the value isn't available
+		if tok == "-" || tok == "" || (tok == "-1" && (floatFields[name] || intFields[name])) {
+			continue
+		}
+		switch {
+		case floatFields[name]:
+			if f, err := strconv.ParseFloat(tok, 64); err == nil {
+				parsed[name] = f
+				continue
+			}
+		case intFields[name]:
+			if n, err := strconv.Atoi(tok); err == nil {
+				parsed[name] = n
+				continue
+			}
+		case addrFields[name]:
+			if host, port, err := net.SplitHostPort(tok); err == nil {
+				parsed[name+"_ip"] = host
+				if p, err := strconv.Atoi(port); err == nil {
+					parsed[name+"_port"] = p
+				}
+				continue
+			}
+		case name == requestKey:
+			parseRequest(tok, parsed)
+		}
+		parsed[name] = tok
+	}
+	return parsed, nil
+}
+
+// parseRequest breaks "GET http://host:80/path?q HTTP/1.1" into its parts
+func parseRequest(req string, parsed map[string]interface{}) {
+	parts := strings.Split(req, " ")
+	if len(parts) != 3 {
+		return
+	}
+	parsed["request_method"] = parts[0]
+	parsed["request_protocol"] = parts[2]
+	u, err := url.Parse(parts[1])
+	if err != nil {
+		return
+	}
+	if u.Host != "" {
+		parsed["request_host"] = u.Hostname()
+	}
+	parsed["request_path"] = u.EscapedPath()
+	if u.RawQuery != "" {
+		parsed["request_query"] = u.RawQuery
+	}
+}
+
+// tokenize splits on spaces, keeping double quoted strings together
+func tokenize(line string) ([]string, error) {
+	var tokens []string
+	line = strings.TrimSpace(line)
+	for len(line) > 0 {
+		if line[0] == '"' {
+			end := 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, errors.New("unterminated quoted field")
+			}
+			tokens = append(tokens, strings.Replace(line[1:end], `\"`, `"`, -1))
+			line = strings.TrimLeft(line[end+1:], " ")
+			continue
+		}
+		end := strings.IndexByte(line, ' ')
+		if end < 0 {
+			end = len(line)
+		}
+		tokens = append(tokens, line[:end])
+		line = strings.TrimLeft(line[end:], " ")
+	}
+	return tokens, nil
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process awselb log line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp: p.getTimestamp(parsedLine),
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending awselb processor")
+}
+
+// getTimestamp uses the time the load balancer sent the response
+func (p *Parser) getTimestamp(m map[string]interface{}) time.Time {
+	if s, ok := m[timeKey].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			delete(m, timeKey)
+			return t.UTC()
+		}
+	}
+	return p.nower.Now()
+}
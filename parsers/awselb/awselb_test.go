@@ -0,0 +1,142 @@
+package awselb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+const (
+	classicLine = `2015-05-13T23:39:43.945958Z my-loadbalancer 192.168.131.39:2817 10.0.0.1:80 0.000073 0.001048 0.000057 200 200 0 29 "GET http://www.example.com:80/ HTTP/1.1" "curl/7.38.0" - -`
+	albLine     = `https 2018-07-02T22:23:00.186641Z app/my-loadbalancer/50dc6c495c0c9188 192.168.131.39:2817 10.0.0.1:80 0.086 0.048 0.037 200 200 0 57 "GET https://www.example.com:443/search?q=\"x\" HTTP/1.1" "curl/7.46.0" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067 "Root=1-58337281-1d84f3d73c47ec4e58577259" "www.example.com" "arn:aws:acm:us-east-2:123456789012:certificate/12345678-1234-1234-1234-123456789012" 1 2018-07-02T22:22:48.364000Z "authenticate,forward" "-" "-" "10.0.0.1:80" "200" "-" "-" TID_1234`
+)
+
+func TestParseLine(t *testing.T) {
+	elp := ELBLineParser{}
+	tlms := []struct {
+		line     string
+		expected map[string]interface{}
+	}{
+		{
+			line: classicLine,
+			expected: map[string]interface{}{
+				"time":                     "2015-05-13T23:39:43.945958Z",
+				"elb":                      "my-loadbalancer",
+				"client_ip":                "192.168.131.39",
+				"client_port":              2817,
+				"backend_ip":               "10.0.0.1",
+				"backend_port":             80,
+				"request_processing_time":  0.000073,
+				"backend_processing_time":  0.001048,
+				"response_processing_time": 0.000057,
+				"elb_status_code":          200,
+				"backend_status_code":      200,
+				"received_bytes":           0,
+				"sent_bytes":               29,
+				"request":                  "GET http://www.example.com:80/ HTTP/1.1",
+				"request_method":           "GET",
+				"request_host":             "www.example.com",
+				"request_path":             "/",
+				"request_protocol":         "HTTP/1.1",
+				"user_agent":               "curl/7.38.0",
+			},
+		},
+		{
+			line: albLine,
+			expected: map[string]interface{}{
+				"type":                     "https",
+				"time":                     "2018-07-02T22:23:00.186641Z",
+				"elb":                      "app/my-loadbalancer/50dc6c495c0c9188",
+				"client_ip":                "192.168.131.39",
+				"client_port":              2817,
+				"target_ip":                "10.0.0.1",
+				"target_port":              80,
+				"request_processing_time":  0.086,
+				"target_processing_time":   0.048,
+				"response_processing_time": 0.037,
+				"elb_status_code":          200,
+				"target_status_code":       200,
+				"received_bytes":           0,
+				"sent_bytes":               57,
+				"request":                  `GET https://www.example.com:443/search?q="x" HTTP/1.1`,
+				"request_method":           "GET",
+				"request_host":             "www.example.com",
+				"request_path":             "/search",
+				"request_query":            `q="x"`,
+				"request_protocol":         "HTTP/1.1",
+				"user_agent":               "curl/7.46.0",
+				"ssl_cipher":               "ECDHE-RSA-AES128-GCM-SHA256",
+				"ssl_protocol":             "TLSv1.2",
+				"target_group_arn":         "arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067",
+				"trace_id":                 "Root=1-58337281-1d84f3d73c47ec4e58577259",
+				"domain_name":              "www.example.com",
+				"chosen_cert_arn":          "arn:aws:acm:us-east-2:123456789012:certificate/12345678-1234-1234-1234-123456789012",
+				"matched_rule_priority":    1,
+				"request_creation_time":    "2018-07-02T22:22:48.364000Z",
+				"actions_executed":         "authenticate,forward",
+				"target_port_list":         "10.0.0.1:80",
+				"target_status_code_list":  "200",
+				"conn_trace_id":            "TID_1234",
+			},
+		},
+	}
+	for _, tlm := range tlms {
+		resp, err := elp.ParseLine(tlm.line)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tlm.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(resp, tlm.expected) {
+			t.Errorf("response %+v didn't match expected %+v", resp, tlm.expected)
+		}
+	}
+
+	// requests that never reached a backend have -1 times and - status codes
+	resp, err := elp.ParseLine(`2015-05-13T23:39:43.945958Z my-loadbalancer 192.168.131.39:2817 - -1 -1 -1 504 - 0 0 "GET http://www.example.com:80/ HTTP/1.1" "curl/7.38.0" - -`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, missing := range []string{"backend_ip", "backend", "request_processing_time", "backend_status_code"} {
+		if _, ok := resp[missing]; ok {
+			t.Errorf("expected %s to be omitted, got %v", missing, resp[missing])
+		}
+	}
+
+	for _, bad := range []string{"", "too few fields", `2015-05-13T23:39:43Z elb 1.2.3.4:1 - 1 1 1 200 200 0 0 "GET / HTTP/1.1`} {
+		if _, err := elp.ParseLine(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{
+		conf:       Options{NumParsers: 1},
+		lineParser: &ELBLineParser{},
+		nower:      &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		lines <- classicLine
+		close(lines)
+	}()
+	go p.ProcessLines(lines, send, nil)
+	ev := <-send
+	expectedTime := time.Date(2015, 5, 13, 23, 39, 43, 945958000, time.UTC)
+	if !ev.Timestamp.Equal(expectedTime) {
+		t.Errorf("timestamp %v didn't match expected %v", ev.Timestamp, expectedTime)
+	}
+	if _, ok := ev.Data["time"]; ok {
+		t.Error("time should have been removed from the event data")
+	}
+}
@@ -0,0 +1,386 @@
+// Package cdc parses the row changes streamed from a database's logical
+// replication, for audit trails that treat the database as the source of
+// truth. This parser is experimental.
+//
+// Rather than speaking each database's replication protocol, it reads the
+// JSON written by the standard replication clients, one change or
+// transaction per line, usually piped to honeytail's stdin:
+//
+//   - Postgres: pg_recvlogical with the wal2json output plugin, in either
+//     format-version 1 (a transaction per line) or 2 (a change per line).
+//     Include -o include-timestamp=1 -o include-pk=1 for timestamps and
+//     primary keys.
+//   - MySQL: Maxwell's daemon with --producer=stdout. Set
+//     output_primary_keys and output_primary_key_columns for primary keys.
+//
+// Each row change becomes an event with its table, op (insert, update or
+// delete), primary key as pk.<column> fields and the names of the changed
+// columns. Column values may hold sensitive data, so only those of the
+// columns listed with --cdc.column are included.
+package cdc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	sourceKey         = "source"
+	tableKey          = "table"
+	opKey             = "op"
+	xidKey            = "xid"
+	changedColumnsKey = "changed_columns"
+	pkPrefix          = "pk."
+	columnPrefix      = "column."
+
+	opInsert = "insert"
+	opUpdate = "update"
+	opDelete = "delete"
+
+	// wal2json writes timestamps like 2019-01-07 12:00:00.123456+00
+	walTimeLayout = "2006-01-02 15:04:05.999999999-07"
+)
+
+// walActions are the wal2json format-version 2 actions for row changes.
+// Others, like B(egin) and C(ommit), are skipped.
+var walActions = map[string]string{"I": opInsert, "U": opUpdate, "D": opDelete}
+
+// walTxn is a transaction written by wal2json format-version 1
+type walTxn struct {
+	Xid       int64  `json:"xid"`
+	Timestamp string `json:"timestamp"`
+	Change    []struct {
+		Kind         string        `json:"kind"`
+		Schema       string        `json:"schema"`
+		Table        string        `json:"table"`
+		ColumnNames  []string      `json:"columnnames"`
+		ColumnValues []interface{} `json:"columnvalues"`
+		OldKeys      struct {
+			KeyNames  []string      `json:"keynames"`
+			KeyValues []interface{} `json:"keyvalues"`
+		} `json:"oldkeys"`
+	} `json:"change"`
+}
+
+// walColumn is a column in wal2json format-version 2
+type walColumn struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+// walAction is a change written by wal2json format-version 2
+type walAction struct {
+	Action    string      `json:"action"`
+	Xid       int64       `json:"xid"`
+	Timestamp string      `json:"timestamp"`
+	Schema    string      `json:"schema"`
+	Table     string      `json:"table"`
+	Columns   []walColumn `json:"columns"`
+	Identity  []walColumn `json:"identity"`
+	PK        []walColumn `json:"pk"`
+}
+
+// maxwellRow is a change written by Maxwell's daemon
+type maxwellRow struct {
+	Database          string                 `json:"database"`
+	Table             string                 `json:"table"`
+	Type              string                 `json:"type"`
+	Ts                int64                  `json:"ts"`
+	Xid               int64                  `json:"xid"`
+	Data              map[string]interface{} `json:"data"`
+	Old               map[string]interface{} `json:"old"`
+	PrimaryKeyColumns []string               `json:"primary_key_columns"`
+}
+
+// change is a row change in any of the formats
+type change struct {
+	source string
+	// namespaceKey is what the database calls the table's namespace:
+	// schema in Postgres, database in MySQL
+	namespaceKey string
+	namespace    string
+	table        string
+	op           string
+	xid          int64
+	timestamp    time.Time
+	// columns are the row's values after an insert or update
+	columns map[string]interface{}
+	// old are what's known of the row's values before an update or delete
+	old map[string]interface{}
+	pk  []string
+}
+
+type Options struct {
+	Columns []string `long:"column" description:"Include the value of this column in events, as column.<name>. Use table.column to include it for one table only. Only the names of other changed columns are sent. May be specified multiple times"`
+
+	NumParsers int `hidden:"true" description:"number of cdc parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) ([]change, error)
+}
+
+type ChangeLineParser struct{}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	p.lineParser = &ChangeLineParser{}
+	return nil
+}
+
+// ParseLine returns the row changes on a line written by wal2json or
+// Maxwell's daemon
+func (c *ChangeLineParser) ParseLine(line string) ([]change, error) {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(line), &keys); err != nil {
+		return nil, err
+	}
+	switch {
+	case keys["change"] != nil:
+		var txn walTxn
+		if err := json.Unmarshal([]byte(line), &txn); err != nil {
+			return nil, err
+		}
+		ts, _ := time.Parse(walTimeLayout, txn.Timestamp)
+		changes := make([]change, 0, len(txn.Change))
+		for _, ch := range txn.Change {
+			if ch.Kind != opInsert && ch.Kind != opUpdate && ch.Kind != opDelete {
+				continue
+			}
+			changes = append(changes, change{
+				source:       "wal2json",
+				namespaceKey: "schema",
+				namespace:    ch.Schema,
+				table:        ch.Table,
+				op:           ch.Kind,
+				xid:          txn.Xid,
+				timestamp:    ts,
+				columns:      zip(ch.ColumnNames, ch.ColumnValues),
+				old:          zip(ch.OldKeys.KeyNames, ch.OldKeys.KeyValues),
+				pk:           ch.OldKeys.KeyNames,
+			})
+		}
+		return changes, nil
+	case keys["action"] != nil:
+		var a walAction
+		if err := json.Unmarshal([]byte(line), &a); err != nil {
+			return nil, err
+		}
+		op, ok := walActions[a.Action]
+		if !ok {
+			return nil, nil
+		}
+		ts, _ := time.Parse(walTimeLayout, a.Timestamp)
+		ch := change{
+			source:       "wal2json",
+			namespaceKey: "schema",
+			namespace:    a.Schema,
+			table:        a.Table,
+			op:           op,
+			xid:          a.Xid,
+			timestamp:    ts,
+			columns:      make(map[string]interface{}, len(a.Columns)),
+			old:          make(map[string]interface{}, len(a.Identity)),
+		}
+		for _, col := range a.Columns {
+			ch.columns[col.Name] = col.Value
+		}
+		for _, col := range a.Identity {
+			ch.old[col.Name] = col.Value
+		}
+		for _, col := range a.PK {
+			ch.pk = append(ch.pk, col.Name)
+		}
+		return []change{ch}, nil
+	case keys["type"] != nil && keys["database"] != nil:
+		var row maxwellRow
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			return nil, err
+		}
+		// bootstrap-insert is a row copied when bootstrapping a table
+		op := strings.TrimPrefix(row.Type, "bootstrap-")
+		if op != opInsert && op != opUpdate && op != opDelete {
+			return nil, nil
+		}
+		ch := change{
+			source:       "maxwell",
+			namespaceKey: "database",
+			namespace:    row.Database,
+			table:        row.Table,
+			op:           op,
+			xid:          row.Xid,
+			timestamp:    time.Unix(row.Ts, 0),
+			columns:      row.Data,
+			old:          row.Old,
+			pk:           row.PrimaryKeyColumns,
+		}
+		if op == opDelete {
+			// the deleted row is written as its data
+			ch.columns, ch.old = nil, row.Data
+		}
+		return []change{ch}, nil
+	}
+	return nil, errors.New("not a wal2json or Maxwell change")
+}
+
+// zip pairs up names and values
+func zip(names []string, values []interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(names))
+	for i, name := range names {
+		if i < len(values) {
+			m[name] = values[i]
+		}
+	}
+	return m
+}
+
+// allowed returns true if the value of column in table may be sent
+func (p *Parser) allowed(table, column string) bool {
+	for _, c := range p.conf.Columns {
+		if c == column || c == table+"."+column {
+			return true
+		}
+	}
+	return false
+}
+
+// toEvent builds the event data for a change
+func (p *Parser) toEvent(ch change) map[string]interface{} {
+	data := map[string]interface{}{
+		sourceKey:       ch.source,
+		ch.namespaceKey: ch.namespace,
+		tableKey:        ch.table,
+		opKey:           ch.op,
+	}
+	if ch.xid != 0 {
+		data[xidKey] = ch.xid
+	}
+	isPK := make(map[string]bool, len(ch.pk))
+	for _, name := range ch.pk {
+		isPK[name] = true
+		if v, ok := ch.columns[name]; ok {
+			data[pkPrefix+name] = v
+		} else if v, ok := ch.old[name]; ok {
+			data[pkPrefix+name] = v
+		}
+	}
+	if changed := changedColumns(ch, isPK); len(changed) != 0 {
+		data[changedColumnsKey] = strings.Join(changed, ",")
+	}
+	// deleted rows only have their old values
+	values := ch.columns
+	if ch.op == opDelete {
+		values = ch.old
+	}
+	for name, v := range values {
+		if p.allowed(ch.table, name) {
+			data[columnPrefix+name] = v
+		}
+	}
+	return data
+}
+
+// changedColumns returns the names of the columns an insert or update set,
+// in order. Updates are compared with the old values when more than the
+// primary key is known of them; otherwise every column is taken to have
+// changed.
+func changedColumns(ch change, isPK map[string]bool) []string {
+	if ch.op == opDelete {
+		return nil
+	}
+	var oldKnown bool
+	for name := range ch.old {
+		if !isPK[name] {
+			oldKnown = true
+			break
+		}
+	}
+	var changed []string
+	for name, v := range ch.columns {
+		if ch.op == opUpdate && oldKnown {
+			old, ok := ch.old[name]
+			if !ok || fmt.Sprintf("%v", old) == fmt.Sprintf("%v", v) {
+				continue
+			}
+		}
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process cdc line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				changes, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				for _, ch := range changes {
+					data := p.toEvent(ch)
+					// merge the prefix fields and the change
+					for k, v := range prefixFields {
+						data[k] = v
+					}
+					ts := ch.timestamp
+					if ts.IsZero() || ts.Unix() == 0 {
+						ts = p.nower.Now()
+					}
+					send <- event.Event{
+						Timestamp: ts.UTC(),
+						Data:      data,
+					}
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending cdc processor")
+}
@@ -0,0 +1,128 @@
+package cdc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+func processLines(p *Parser, lines []string) []event.Event {
+	linesChan := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		for _, line := range lines {
+			linesChan <- line
+		}
+		close(linesChan)
+	}()
+	go func() {
+		p.ProcessLines(linesChan, send, nil)
+		close(send)
+	}()
+	var events []event.Event
+	for ev := range send {
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{}
+	p.Init(&Options{Columns: []string{"status", "users.email"}, NumParsers: 1})
+	p.nower = &FakeNower{}
+	ts := time.Date(2019, 1, 7, 12, 0, 0, 123456000, time.UTC)
+
+	testCases := []struct {
+		name     string
+		line     string
+		expected []event.Event
+	}{
+		{
+			name: "wal2json format-version 1",
+			line: `{"xid":501,"timestamp":"2019-01-07 12:00:00.123456+00","change":[` +
+				`{"kind":"insert","schema":"public","table":"users","columnnames":["id","email","password"],"columntypes":["int4","text","text"],"columnvalues":[7,"a@example.com","hunter2"]},` +
+				`{"kind":"delete","schema":"public","table":"orders","oldkeys":{"keynames":["id"],"keytypes":["int4"],"keyvalues":[3]}}]}`,
+			expected: []event.Event{
+				{Timestamp: ts, Data: map[string]interface{}{
+					"source": "wal2json", "schema": "public", "table": "users", "op": "insert", "xid": int64(501),
+					"changed_columns": "email,id,password",
+					"column.email":    "a@example.com",
+				}},
+				{Timestamp: ts, Data: map[string]interface{}{
+					"source": "wal2json", "schema": "public", "table": "orders", "op": "delete", "xid": int64(501),
+					"pk.id": float64(3),
+				}},
+			},
+		},
+		{
+			// the identity only has the primary key, so every column is
+			// taken to have changed
+			name: "wal2json format-version 2",
+			line: `{"action":"U","timestamp":"2019-01-07 12:00:00.123456+00","schema":"public","table":"orders",` +
+				`"columns":[{"name":"id","type":"integer","value":3},{"name":"status","type":"text","value":"shipped"}],` +
+				`"identity":[{"name":"id","type":"integer","value":3}],"pk":[{"name":"id","type":"integer"}]}`,
+			expected: []event.Event{
+				{Timestamp: ts, Data: map[string]interface{}{
+					"source": "wal2json", "schema": "public", "table": "orders", "op": "update",
+					"pk.id":           float64(3),
+					"changed_columns": "id,status",
+					"column.status":   "shipped",
+				}},
+			},
+		},
+		{
+			name: "wal2json format-version 2 commit",
+			line: `{"action":"C"}`,
+		},
+		{
+			// old holds only the columns that changed
+			name: "maxwell",
+			line: `{"database":"shop","table":"orders","type":"update","ts":1546862400,"xid":940786,` +
+				`"data":{"id":3,"status":"shipped","total":10},"old":{"status":"paid"},"primary_key_columns":["id"]}`,
+			expected: []event.Event{
+				{Timestamp: time.Unix(1546862400, 0).UTC(), Data: map[string]interface{}{
+					"source": "maxwell", "database": "shop", "table": "orders", "op": "update", "xid": int64(940786),
+					"pk.id":           float64(3),
+					"changed_columns": "status",
+					"column.status":   "shipped",
+				}},
+			},
+		},
+		{
+			name: "maxwell ddl",
+			line: `{"database":"shop","table":"orders","type":"table-alter","ts":1546862400}`,
+		},
+		{
+			name: "not a change",
+			line: `{"hello":"world"}`,
+		},
+	}
+	for _, tc := range testCases {
+		events := processLines(p, []string{tc.line})
+		if !reflect.DeepEqual(events, tc.expected) {
+			t.Errorf("%s: got %+v, expected %+v", tc.name, events, tc.expected)
+		}
+	}
+}
+
+func TestMissingTimestamp(t *testing.T) {
+	p := &Parser{}
+	p.Init(&Options{NumParsers: 1})
+	p.nower = &FakeNower{}
+	events := processLines(p, []string{`{"action":"D","schema":"public","table":"t","identity":[{"name":"id","value":1}]}`})
+	if len(events) != 1 || !events[0].Timestamp.Equal((&FakeNower{}).Now()) {
+		t.Errorf("expected the current time, got %+v", events)
+	}
+	if _, ok := events[0].Data["changed_columns"]; ok {
+		t.Errorf("expected no changed columns for a delete, got %+v", events[0].Data)
+	}
+}
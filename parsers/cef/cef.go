@@ -0,0 +1,280 @@
+// Package cef parses ArcSight Common Event Format (CEF) lines, as emitted by
+// many security appliances:
+//
+//	CEF:0|Vendor|Product|1.0|100|Name|5|src=10.0.0.1 dst=10.0.0.2 msg=hello world
+//
+// The pipe-delimited header becomes named fields and the extension's
+// key=value pairs are added as-is.
+package cef
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	cefMarker = "CEF:"
+
+	versionKey       = "cef_version"
+	deviceVendorKey  = "device_vendor"
+	deviceProductKey = "device_product"
+	deviceVersionKey = "device_version"
+	signatureIDKey   = "signature_id"
+	nameKey          = "name"
+	severityKey      = "severity"
+
+	// receiptTimeKey is the extension key CEF uses for the event time
+	receiptTimeKey = "rt"
+)
+
+var headerKeys = []string{
+	versionKey,
+	deviceVendorKey,
+	deviceProductKey,
+	deviceVersionKey,
+	signatureIDKey,
+	nameKey,
+	severityKey,
+}
+
+// timestamp formats allowed by the CEF spec for date fields, in addition to
+// milliseconds since the epoch
+var timestampFormats = []string{
+	"Jan 02 2006 15:04:05.000 MST",
+	"Jan 02 2006 15:04:05.000",
+	"Jan 02 2006 15:04:05 MST",
+	"Jan 02 2006 15:04:05",
+	"Jan 02 15:04:05.000 MST",
+	"Jan 02 15:04:05.000",
+	"Jan 02 15:04:05 MST",
+	"Jan 02 15:04:05",
+}
+
+type Options struct {
+	NumParsers int `hidden:"true" description:"number of cef parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, error)
+}
+
+type CEFLineParser struct{}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	p.lineParser = &CEFLineParser{}
+	return nil
+}
+
+// ParseLine splits a CEF line into its header fields and extension pairs.
+// Anything before the CEF: marker (usually a syslog header) is ignored.
+func (c *CEFLineParser) ParseLine(line string) (map[string]interface{}, error) {
+	start := strings.Index(line, cefMarker)
+	if start == -1 {
+		return nil, errors.New("line does not contain a CEF header")
+	}
+	line = line[start+len(cefMarker):]
+
+	header, extension, err := splitHeader(line)
+	if err != nil {
+		return nil, err
+	}
+	parsed := make(map[string]interface{}, len(header))
+	for i, val := range header {
+		parsed[headerKeys[i]] = val
+	}
+	if sev, err := strconv.ParseInt(header[6], 10, 64); err == nil {
+		parsed[severityKey] = sev
+	}
+	for k, v := range parseExtension(extension) {
+		parsed[k] = typeify(v)
+	}
+	return parsed, nil
+}
+
+// splitHeader separates the seven pipe-delimited header fields from the
+// extension, honoring the \| and \\ escapes allowed in the header.
+func splitHeader(line string) ([]string, string, error) {
+	fields := make([]string, 0, len(headerKeys))
+	var cur []byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && i+1 < len(line) && (line[i+1] == '|' || line[i+1] == '\\'):
+			cur = append(cur, line[i+1])
+			i++
+		case c == '|':
+			fields = append(fields, string(cur))
+			cur = cur[:0]
+			if len(fields) == len(headerKeys) {
+				return fields, line[i+1:], nil
+			}
+		default:
+			cur = append(cur, c)
+		}
+	}
+	return nil, "", errors.New("CEF header has too few fields")
+}
+
+// parseExtension parses the space separated key=value extension. Values may
+// contain unescaped spaces, so a value runs until the last space before the
+// next unescaped '='.
+func parseExtension(ext string) map[string]string {
+	pairs := make(map[string]string)
+	// find the offsets of every key; a key starts after a space (or at the
+	// beginning) and ends at an unescaped '='
+	type keyPos struct {
+		key        string
+		start, end int // start of the key, index of the '='
+	}
+	var keys []keyPos
+	for i := 0; i < len(ext); i++ {
+		if ext[i] != '=' || (i > 0 && ext[i-1] == '\\') {
+			continue
+		}
+		ks := strings.LastIndexByte(ext[:i], ' ') + 1
+		if ks == i {
+			continue
+		}
+		keys = append(keys, keyPos{key: ext[ks:i], start: ks, end: i})
+	}
+	for i, k := range keys {
+		valEnd := len(ext)
+		if i+1 < len(keys) {
+			valEnd = keys[i+1].start
+		}
+		val := strings.TrimSpace(ext[k.end+1 : valEnd])
+		pairs[k.key] = unescapeValue(val)
+	}
+	return pairs
+}
+
+// unescapeValue handles the escapes allowed in extension values
+func unescapeValue(val string) string {
+	if !strings.Contains(val, `\`) {
+		return val
+	}
+	var b []byte
+	for i := 0; i < len(val); i++ {
+		if val[i] == '\\' && i+1 < len(val) {
+			i++
+			switch val[i] {
+			case 'n':
+				b = append(b, '\n')
+			case 'r':
+				b = append(b, '\r')
+			default:
+				b = append(b, val[i])
+			}
+			continue
+		}
+		b = append(b, val[i])
+	}
+	return string(b)
+}
+
+// typeify turns integer extension values (ports, counts, byte totals) into
+// numbers and leaves everything else as a string
+func typeify(val string) interface{} {
+	if i, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return i
+	}
+	return val
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process cef log line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp: p.getTimestamp(parsedLine),
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending cef processor")
+}
+
+// getTimestamp uses the rt (receipt time) extension field if present,
+// which may be either epoch milliseconds or one of the CEF date formats
+func (p *Parser) getTimestamp(m map[string]interface{}) time.Time {
+	now := p.nower.Now()
+	val, ok := m[receiptTimeKey]
+	if !ok {
+		return now
+	}
+	switch rt := val.(type) {
+	case int64:
+		delete(m, receiptTimeKey)
+		return time.Unix(0, rt*int64(time.Millisecond)).UTC()
+	case string:
+		for _, f := range timestampFormats {
+			ts, err := time.Parse(f, rt)
+			if err != nil {
+				continue
+			}
+			if ts.Year() == 0 {
+				// formats without a year get the current one
+				ts = ts.AddDate(now.Year(), 0, 0)
+			}
+			delete(m, receiptTimeKey)
+			return ts
+		}
+	}
+	logrus.WithField("rt", val).Debug("unable to parse CEF receipt time")
+	return now
+}
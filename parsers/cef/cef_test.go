@@ -0,0 +1,133 @@
+package cef
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+type testLineMap struct {
+	input    string
+	expected map[string]interface{}
+}
+
+var tlms = []testLineMap{
+	{ // example from the CEF spec
+		input: `Sep 19 08:26:10 host CEF:0|Security|threatmanager|1.0|100|worm successfully stopped|10|src=10.0.0.1 dst=2.1.2.2 spt=1232`,
+		expected: map[string]interface{}{
+			"cef_version":    "0",
+			"device_vendor":  "Security",
+			"device_product": "threatmanager",
+			"device_version": "1.0",
+			"signature_id":   "100",
+			"name":           "worm successfully stopped",
+			"severity":       int64(10),
+			"src":            "10.0.0.1",
+			"dst":            "2.1.2.2",
+			"spt":            int64(1232),
+		},
+	},
+	{ // escaped pipes in the header, spaces and escapes in the extension
+		input: `CEF:0|security|threatmanager|1.0|100|detected a \| in message|High|msg=detected a \= and a \\ in a message cs1Label=rule name cs1=block all\nsecond line`,
+		expected: map[string]interface{}{
+			"cef_version":    "0",
+			"device_vendor":  "security",
+			"device_product": "threatmanager",
+			"device_version": "1.0",
+			"signature_id":   "100",
+			"name":           "detected a | in message",
+			"severity":       "High",
+			"msg":            `detected a = and a \ in a message`,
+			"cs1Label":       "rule name",
+			"cs1":            "block all\nsecond line",
+		},
+	},
+	{ // no extension at all
+		input: `CEF:1|Vendor|Product|2|login|User logged in|3|`,
+		expected: map[string]interface{}{
+			"cef_version":    "1",
+			"device_vendor":  "Vendor",
+			"device_product": "Product",
+			"device_version": "2",
+			"signature_id":   "login",
+			"name":           "User logged in",
+			"severity":       int64(3),
+		},
+	},
+}
+
+func TestParseLine(t *testing.T) {
+	clp := CEFLineParser{}
+	for _, tlm := range tlms {
+		resp, err := clp.ParseLine(tlm.input)
+		if err != nil {
+			t.Error("clp.ParseLine unexpectedly returned error ", err)
+		}
+		if !reflect.DeepEqual(resp, tlm.expected) {
+			t.Errorf("response %+v didn't match expected %+v", resp, tlm.expected)
+		}
+	}
+	for _, bad := range []string{
+		"just a plain line",
+		"CEF:0|too|few|fields",
+	} {
+		if _, err := clp.ParseLine(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestGetTimestamp(t *testing.T) {
+	p := &Parser{nower: &FakeNower{}}
+	expected := time.Date(2017, time.June, 12, 18, 21, 47, 0, time.UTC)
+	for _, rt := range []interface{}{
+		int64(1497291707000),
+		"Jun 12 2017 18:21:47",
+		"Jun 12 2017 18:21:47 UTC",
+	} {
+		m := map[string]interface{}{"rt": rt}
+		ts := p.getTimestamp(m)
+		if !ts.Equal(expected) {
+			t.Errorf("rt %v parsed as %v, expected %v", rt, ts, expected)
+		}
+		if _, ok := m["rt"]; ok {
+			t.Errorf("rt %v should have been removed from the event", rt)
+		}
+	}
+	m := map[string]interface{}{"rt": "yesterday"}
+	if ts := p.getTimestamp(m); !ts.Equal(p.nower.Now()) {
+		t.Errorf("unparseable rt should fall back to now, got %v", ts)
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{
+		conf:       Options{NumParsers: 1},
+		lineParser: &CEFLineParser{},
+		nower:      &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		lines <- "not cef"
+		lines <- tlms[0].input + " rt=1497291707000"
+		close(lines)
+	}()
+	go p.ProcessLines(lines, send, nil)
+	ev := <-send
+	if !reflect.DeepEqual(ev.Data, tlms[0].expected) {
+		t.Errorf("data %+v didn't match expected %+v", ev.Data, tlms[0].expected)
+	}
+	if ev.Timestamp.Unix() != 1497291707 {
+		t.Errorf("unexpected timestamp %v", ev.Timestamp)
+	}
+}
@@ -0,0 +1,249 @@
+// Package ceph parses the logs of Ceph daemons, such as ceph-osd.3.log, eg
+//
+//	2010-06-21T15:04:05.123+0000 7f3c8a0d2700  0 log_channel(cluster) log [WRN] : slow request 30.123 seconds old, received at 2010-06-21T15:03:35.000+0000: osd_op(client.4123.0:12 1.2 1:4a2b:::rbd_data.1:head [write 0~4096]) currently waiting for sub ops
+//
+// and the cluster log the monitors write, ceph.log, eg
+//
+//	2010-06-21T15:04:05.123456+0000 mon.a (mon.0) 1234 : cluster [WRN] Health check update: 3 slow ops, oldest one blocked for 31 sec, osd.3 has slow ops (SLOW_OPS)
+//
+// Daemon log lines are split into the thread, the debug level, the
+// subsystem and entity, such as osd and osd.3, and the message. Messages to
+// the cluster log have its channel and severity. Warnings about slow
+// requests and ops have the number of them and how long the oldest was
+// blocked, in seconds, along with the op and what it was doing.
+package ceph
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	threadKey    = "thread"
+	levelKey     = "level"
+	subsystemKey = "subsystem"
+	entityKey    = "entity"
+	sequenceKey  = "sequence"
+	channelKey   = "channel"
+	severityKey  = "severity"
+	messageKey   = "message"
+
+	slowRequestAgeKey = "slow_request_age_s"
+	slowRequestsKey   = "slow_requests"
+	slowOpsKey        = "slow_ops"
+	oldestBlockedKey  = "oldest_blocked_s"
+	opKey             = "op"
+	opStateKey        = "op_state"
+
+	// timeLayout is used with a T and zone since Quincy, and a space and no
+	// zone before
+	timeLayout = "2006-01-02 15:04:05.999999"
+)
+
+var (
+	// daemonRegex matches a line of a daemon's log: the time, thread, debug
+	// level and message
+	daemonRegex = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(?:\.\d+)?)([-+]\d{4})? ([0-9a-f]+) +(-?\d+) (.*)$`)
+	// clusterRegex matches a line of the cluster log: the time, the entity
+	// that logged it, its address or rank, the sequence number, channel
+	// and severity, and the message
+	clusterRegex = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(?:\.\d+)?)([-+]\d{4})? (\S+) \S+ (\d+) : (\w+) \[(\w+)\] (.*)$`)
+	// channelRegex matches a message a daemon sent to the cluster log
+	channelRegex = regexp.MustCompile(`^log_channel\((\w+)\) log \[(\w+)\] : (.*)$`)
+	// entityRegex matches the subsystem at the start of a daemon's message,
+	// and the entity if it names one, eg osd.3 or mon.a@0(leader)
+	entityRegex = regexp.MustCompile(`^([a-z_]+)(?:\.(\w+))?`)
+)
+
+// slowRegexes match the warnings about slow requests and ops. Their named
+// groups are the fields they fill in.
+var slowRegexes = []*regexp.Regexp{
+	// slow request 30.123 seconds old, received at ...: osd_op(...) currently waiting for sub ops
+	regexp.MustCompile(`^slow request (?P<slow_request_age_s>[\d.]+) seconds old, received at [^:]+:\d{2}:[\d.]+\S*: (?P<op>.*?)(?: currently (?P<op_state>.*))?$`),
+	// 2 slow requests, 1 included below; oldest blocked for > 32.5 secs
+	regexp.MustCompile(`^(?P<slow_requests>\d+) slow requests, \d+ included below; oldest blocked for > (?P<oldest_blocked_s>[\d.]+) secs$`),
+	// Health check update: 3 slow ops, oldest one blocked for 31 sec, osd.3 has slow ops (SLOW_OPS)
+	regexp.MustCompile(`(?P<slow_ops>\d+) slow ops, oldest one blocked for (?P<oldest_blocked_s>[\d.]+) sec`),
+	// get_health_metrics reporting 4 slow ops, oldest is osd_op(...)
+	regexp.MustCompile(`reporting (?P<slow_ops>\d+) slow ops, oldest is (?P<op>.*)$`),
+}
+
+type Options struct {
+	TimeZone string `long:"timezone" description:"IANA name of the time zone of logs from before Ceph Quincy, which don't include it, eg America/Los_Angeles. Defaults to the local time zone"`
+
+	NumParsers int `hidden:"true" description:"number of ceph parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, time.Time, error)
+}
+
+type CephLineParser struct {
+	loc *time.Location
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	loc := time.Local
+	if p.conf.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(p.conf.TimeZone); err != nil {
+			return err
+		}
+	}
+	p.lineParser = &CephLineParser{loc: loc}
+	return nil
+}
+
+// ParseLine parses a line of a daemon's log or the cluster log
+func (c *CephLineParser) ParseLine(line string) (map[string]interface{}, time.Time, error) {
+	if m := clusterRegex.FindStringSubmatch(line); m != nil {
+		ts, err := c.parseTime(m[1], m[2])
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		parsed := map[string]interface{}{
+			entityKey:   m[3],
+			channelKey:  m[5],
+			severityKey: m[6],
+		}
+		if i := strings.IndexByte(m[3], '.'); i > 0 {
+			parsed[subsystemKey] = m[3][:i]
+		}
+		parsed[sequenceKey], _ = strconv.Atoi(m[4])
+		parseMessage(m[7], parsed)
+		return parsed, ts, nil
+	}
+	m := daemonRegex.FindStringSubmatch(line)
+	if m == nil {
+		return nil, time.Time{}, errors.New("line is not a ceph log line")
+	}
+	ts, err := c.parseTime(m[1], m[2])
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	parsed := map[string]interface{}{
+		threadKey: m[3],
+	}
+	parsed[levelKey], _ = strconv.Atoi(m[4])
+	msg := m[5]
+	if ch := channelRegex.FindStringSubmatch(msg); ch != nil {
+		parsed[channelKey] = ch[1]
+		parsed[severityKey] = ch[2]
+		msg = ch[3]
+	} else if e := entityRegex.FindStringSubmatch(msg); e != nil {
+		parsed[subsystemKey] = e[1]
+		if e[2] != "" {
+			parsed[entityKey] = e[1] + "." + e[2]
+		}
+	}
+	parseMessage(msg, parsed)
+	return parsed, ts, nil
+}
+
+// parseMessage adds the message, and the details of slow requests and ops
+// if it's about them
+func parseMessage(msg string, parsed map[string]interface{}) {
+	parsed[messageKey] = msg
+	for _, re := range slowRegexes {
+		m := re.FindStringSubmatch(msg)
+		if m == nil {
+			continue
+		}
+		for i, name := range re.SubexpNames() {
+			if name == "" || m[i] == "" {
+				continue
+			}
+			switch name {
+			case slowRequestsKey, slowOpsKey:
+				parsed[name], _ = strconv.Atoi(m[i])
+			case slowRequestAgeKey, oldestBlockedKey:
+				parsed[name], _ = strconv.ParseFloat(m[i], 64)
+			default:
+				parsed[name] = m[i]
+			}
+		}
+		return
+	}
+}
+
+// parseTime parses a time with its zone, or in the configured zone for logs
+// without one
+func (c *CephLineParser) parseTime(t, zone string) (time.Time, error) {
+	t = strings.Replace(t, "T", " ", 1)
+	if zone != "" {
+		ts, err := time.Parse(timeLayout+"-0700", t+zone)
+		return ts.UTC(), err
+	}
+	ts, err := time.ParseInLocation(timeLayout, t, c.loc)
+	return ts.UTC(), err
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process ceph log line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, timestamp, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp: timestamp,
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending ceph processor")
+}
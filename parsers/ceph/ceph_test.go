@@ -0,0 +1,100 @@
+package ceph
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testLineMap struct {
+	line     string
+	expected map[string]interface{}
+	ts       time.Time
+}
+
+var tlms = []testLineMap{
+	{
+		line: "2010-06-21T15:04:05.123+0000 7f3c8a0d2700  0 log_channel(cluster) log [WRN] : slow request 30.123 seconds old, received at 2010-06-21T15:03:35.000+0000: osd_op(client.4123.0:12 1.2 1:4a2b:::rbd_data.1:head [write 0~4096]) currently waiting for sub ops",
+		expected: map[string]interface{}{
+			"thread":             "7f3c8a0d2700",
+			"level":              0,
+			"channel":            "cluster",
+			"severity":           "WRN",
+			"message":            "slow request 30.123 seconds old, received at 2010-06-21T15:03:35.000+0000: osd_op(client.4123.0:12 1.2 1:4a2b:::rbd_data.1:head [write 0~4096]) currently waiting for sub ops",
+			"slow_request_age_s": 30.123,
+			"op":                 "osd_op(client.4123.0:12 1.2 1:4a2b:::rbd_data.1:head [write 0~4096])",
+			"op_state":           "waiting for sub ops",
+		},
+		ts: time.Date(2010, 6, 21, 15, 4, 5, 123000000, time.UTC),
+	},
+	{
+		line: "2010-06-21 15:04:06.000000 7f3c8a0d2700  0 log_channel(cluster) log [WRN] : 2 slow requests, 1 included below; oldest blocked for > 32.5 secs",
+		expected: map[string]interface{}{
+			"thread":           "7f3c8a0d2700",
+			"level":            0,
+			"channel":          "cluster",
+			"severity":         "WRN",
+			"message":          "2 slow requests, 1 included below; oldest blocked for > 32.5 secs",
+			"slow_requests":    2,
+			"oldest_blocked_s": 32.5,
+		},
+		ts: time.Date(2010, 6, 21, 15, 4, 6, 0, time.UTC),
+	},
+	{
+		line: "2010-06-21T15:04:07.500+0200 7f3c8a0d2700 -1 osd.3 1234 get_health_metrics reporting 4 slow ops, oldest is osd_op(client.4123.0:13 1.2 [read 0~4096])",
+		expected: map[string]interface{}{
+			"thread":    "7f3c8a0d2700",
+			"level":     -1,
+			"subsystem": "osd",
+			"entity":    "osd.3",
+			"message":   "osd.3 1234 get_health_metrics reporting 4 slow ops, oldest is osd_op(client.4123.0:13 1.2 [read 0~4096])",
+			"slow_ops":  4,
+			"op":        "osd_op(client.4123.0:13 1.2 [read 0~4096])",
+		},
+		ts: time.Date(2010, 6, 21, 13, 4, 7, 500000000, time.UTC),
+	},
+	{
+		line: "2010-06-21T15:04:08.000+0000 7f3c8a0d2700 20 bluestore(/var/lib/ceph/osd/ceph-3) _txc_state_proc txc 0x55 kv_submitted",
+		expected: map[string]interface{}{
+			"thread":    "7f3c8a0d2700",
+			"level":     20,
+			"subsystem": "bluestore",
+			"message":   "bluestore(/var/lib/ceph/osd/ceph-3) _txc_state_proc txc 0x55 kv_submitted",
+		},
+		ts: time.Date(2010, 6, 21, 15, 4, 8, 0, time.UTC),
+	},
+	{
+		line: "2010-06-21T15:04:09.123456+0000 mon.a (mon.0) 1234 : cluster [WRN] Health check update: 3 slow ops, oldest one blocked for 31 sec, osd.3 has slow ops (SLOW_OPS)",
+		expected: map[string]interface{}{
+			"entity":           "mon.a",
+			"subsystem":        "mon",
+			"sequence":         1234,
+			"channel":          "cluster",
+			"severity":         "WRN",
+			"message":          "Health check update: 3 slow ops, oldest one blocked for 31 sec, osd.3 has slow ops (SLOW_OPS)",
+			"slow_ops":         3,
+			"oldest_blocked_s": 31.0,
+		},
+		ts: time.Date(2010, 6, 21, 15, 4, 9, 123456000, time.UTC),
+	},
+}
+
+func TestParseLine(t *testing.T) {
+	p := &CephLineParser{loc: time.UTC}
+	for _, tlm := range tlms {
+		resp, ts, err := p.ParseLine(tlm.line)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tlm.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(resp, tlm.expected) {
+			t.Errorf("response from ParseLine(%q)\n\t%+v\ndid not match expected\n\t%+v", tlm.line, resp, tlm.expected)
+		}
+		if !ts.Equal(tlm.ts) {
+			t.Errorf("timestamp from ParseLine(%q) was %s, expected %s", tlm.line, ts, tlm.ts)
+		}
+	}
+	if _, _, err := p.ParseLine("*** Caught signal (Aborted) **"); err == nil {
+		t.Error("expected an error parsing a line without a time")
+	}
+}
@@ -0,0 +1,355 @@
+// Package clickhouse parses ClickHouse's server text log and rows of its
+// system.query_log table exported with FORMAT JSONEachRow.
+//
+// Text log lines look like
+//
+//	2010.06.21 15:04:05.123456 [ 4242 ] {8f3d6a7e-0f6a-4b8e-9d4b-1f2e3d4c5b6a} <Debug> executeQuery: (from 192.0.2.7:51234) SELECT count() FROM hits
+//	2010.06.21 15:04:05.234567 [ 4242 ] {8f3d6a7e-0f6a-4b8e-9d4b-1f2e3d4c5b6a} <Information> executeQuery: Read 100 rows, 800.00 B in 0.111 sec., 900 rows/sec., 7.03 KiB/sec.
+//	2010.06.21 15:04:05.234567 [ 4242 ] {8f3d6a7e-0f6a-4b8e-9d4b-1f2e3d4c5b6a} <Debug> MemoryTracker: Peak memory usage (for query): 4.00 MiB.
+//
+// Each line becomes an event; the lines about a query share its query_id.
+// The query, the rows and bytes read and how long it took, its peak memory
+// usage and any exception are split out of the lines that log them.
+package clickhouse
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	threadIDKey      = "thread_id"
+	queryIDKey       = "query_id"
+	levelKey         = "level"
+	loggerKey        = "logger"
+	messageKey       = "message"
+	clientKey        = "client"
+	userKey          = "user"
+	queryKey         = "query"
+	readRowsKey      = "read_rows"
+	readBytesKey     = "read_bytes"
+	durationKey      = "query_duration_ms"
+	memoryUsageKey   = "memory_usage"
+	exceptionKey     = "exception"
+	exceptionCodeKey = "exception_code"
+	errorNameKey     = "error_name"
+
+	textTimeLayout = "2006.01.02 15:04:05.999999"
+	rowTimeLayout  = "2006-01-02 15:04:05.999999"
+)
+
+// lineRegex matches a text log line: the time, thread, query id, level and
+// the logger that wrote it
+var lineRegex = regexp.MustCompile(`^(\d{4}\.\d{2}\.\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?) \[ (\d+) \] \{([^}]*)\} <(\w+)> ([^:]+): (.*)$`)
+
+var (
+	// startRegex matches executeQuery's line as a query starts, eg
+	// "(from 192.0.2.7:51234, user: app) (comment: x) SELECT 1 (stage: Complete)"
+	startRegex = regexp.MustCompile(`^\(from ([^,)]*)(?:, user: ([^)]*))?\)(?: \(comment: [^)]*\))? (.*?)(?: \(stage: \w+\))?$`)
+	// readRegex matches executeQuery's line as a query finishes
+	readRegex = regexp.MustCompile(`^Read (\d+) rows?, ([\d.]+ \w*B) in ([\d.]+) sec\.`)
+	// memoryRegex matches MemoryTracker's line after a query
+	memoryRegex = regexp.MustCompile(`^Peak memory usage(?: \(for query\))?: ([\d.]+ \w*B)\.?$`)
+	// exceptionRegex matches an exception, eg
+	// "Code: 60. DB::Exception: Table default.x doesn't exist. (UNKNOWN_TABLE) (version 22.8.1) (from 192.0.2.7:51234) (in query: SELECT * FROM x), Stack trace..."
+	exceptionRegex = regexp.MustCompile(`^Code: (\d+)\. DB::Exception: (.*?)(?: \(([A-Z_]+)\))?(?: \(version [^)]*\))?(?: \(from ([^)]*)\))?(?: \(in query: (.*?)\))?(?:,? Stack trace.*)?$`)
+)
+
+// units are the multipliers for the sizes ClickHouse logs
+var units = map[string]float64{
+	"B":   1,
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+	"TiB": 1 << 40,
+}
+
+// intColumns are the query_log columns holding 64 bit integers, which
+// JSONEachRow quotes by default
+var intColumns = map[string]bool{
+	"query_duration_ms":  true,
+	"read_rows":          true,
+	"read_bytes":         true,
+	"written_rows":       true,
+	"written_bytes":      true,
+	"result_rows":        true,
+	"result_bytes":       true,
+	"memory_usage":       true,
+	"peak_threads_usage": true,
+	"exception_code":     true,
+	"thread_id":          true,
+}
+
+type Options struct {
+	TimeZone string `long:"timezone" description:"IANA name of the server's time zone, eg America/Los_Angeles, which its logs are written in. Defaults to the local time zone"`
+
+	NumParsers int `hidden:"true" description:"number of clickhouse parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, time.Time, error)
+}
+
+type ClickHouseLineParser struct {
+	loc *time.Location
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	loc := time.Local
+	if p.conf.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(p.conf.TimeZone); err != nil {
+			return err
+		}
+	}
+	p.lineParser = &ClickHouseLineParser{loc: loc}
+	return nil
+}
+
+// ParseLine parses either a text log line or an exported query_log row
+func (c *ClickHouseLineParser) ParseLine(line string) (map[string]interface{}, time.Time, error) {
+	if strings.HasPrefix(line, "{") {
+		return c.parseRow(line)
+	}
+	match := lineRegex.FindStringSubmatch(line)
+	if match == nil {
+		return nil, time.Time{}, errors.New("line is not a clickhouse log line")
+	}
+	ts, err := time.ParseInLocation(textTimeLayout, match[1], c.loc)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	parsed := map[string]interface{}{
+		levelKey:  match[4],
+		loggerKey: match[5],
+	}
+	if n, err := strconv.Atoi(match[2]); err == nil {
+		parsed[threadIDKey] = n
+	}
+	if match[3] != "" {
+		parsed[queryIDKey] = match[3]
+	}
+	if !parseMessage(match[5], match[6], parsed) {
+		parsed[messageKey] = match[6]
+	}
+	return parsed, ts.UTC(), nil
+}
+
+// parseMessage splits out the details of a query from the messages that log
+// them, returning false for any other message
+func parseMessage(logger, msg string, parsed map[string]interface{}) bool {
+	if m := exceptionRegex.FindStringSubmatch(msg); m != nil {
+		if n, err := strconv.Atoi(m[1]); err == nil {
+			parsed[exceptionCodeKey] = n
+		}
+		parsed[exceptionKey] = m[2]
+		if m[3] != "" {
+			parsed[errorNameKey] = m[3]
+		}
+		if m[4] != "" {
+			parsed[clientKey] = m[4]
+		}
+		if m[5] != "" {
+			parsed[queryKey] = m[5]
+		}
+		return true
+	}
+	switch logger {
+	case "executeQuery":
+		if m := startRegex.FindStringSubmatch(msg); m != nil {
+			parsed[clientKey] = m[1]
+			if m[2] != "" {
+				parsed[userKey] = m[2]
+			}
+			parsed[queryKey] = m[3]
+			return true
+		}
+		if m := readRegex.FindStringSubmatch(msg); m != nil {
+			if n, err := strconv.ParseInt(m[1], 10, 64); err == nil {
+				parsed[readRowsKey] = n
+			}
+			if n, ok := parseSize(m[2]); ok {
+				parsed[readBytesKey] = n
+			}
+			if secs, err := strconv.ParseFloat(m[3], 64); err == nil {
+				parsed[durationKey] = secs * 1000
+			}
+			return true
+		}
+	case "MemoryTracker":
+		if m := memoryRegex.FindStringSubmatch(msg); m != nil {
+			if n, ok := parseSize(m[1]); ok {
+				parsed[memoryUsageKey] = n
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseSize parses a size as ClickHouse logs it, eg 7.03 KiB, into bytes.
+// Sizes are rounded to two decimal places in the log, so larger ones are
+// only approximate.
+func parseSize(s string) (int64, bool) {
+	parts := strings.SplitN(s, " ", 2)
+	if len(parts) != 2 {
+		return 0, false
+	}
+	mult, ok := units[parts[1]]
+	if !ok {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0, false
+	}
+	return int64(math.Floor(f*mult + 0.5)), true
+}
+
+// parseRow parses a row of system.query_log exported as JSONEachRow. Empty
+// values are left out, arrays such as tables are joined with spaces, and
+// maps such as ProfileEvents are flattened into dotted names.
+func (c *ClickHouseLineParser) parseRow(line string) (map[string]interface{}, time.Time, error) {
+	var row map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(line))
+	dec.UseNumber()
+	if err := dec.Decode(&row); err != nil {
+		return nil, time.Time{}, err
+	}
+	var ts time.Time
+	for _, col := range []string{"event_time_microseconds", "event_time"} {
+		if s, ok := row[col].(string); ok {
+			t, err := time.ParseInLocation(rowTimeLayout, s, c.loc)
+			if err != nil {
+				return nil, time.Time{}, err
+			}
+			ts = t.UTC()
+			break
+		}
+	}
+	if ts.IsZero() {
+		return nil, time.Time{}, errors.New("row has no event_time")
+	}
+	parsed := make(map[string]interface{})
+	for k, v := range row {
+		switch k {
+		case "event_time", "event_time_microseconds", "event_date":
+			continue
+		}
+		addValue(k, v, parsed)
+	}
+	return parsed, ts, nil
+}
+
+// addValue adds a query_log column to parsed, converting its value to one
+// Honeycomb can use
+func addValue(key string, v interface{}, parsed map[string]interface{}) {
+	switch v := v.(type) {
+	case string:
+		if v == "" {
+			return
+		}
+		// ProfileEvents are all counters
+		if intColumns[key] || strings.HasPrefix(key, "ProfileEvents.") {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				parsed[key] = n
+				return
+			}
+		}
+		parsed[key] = v
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			parsed[key] = n
+		} else if f, err := v.Float64(); err == nil {
+			parsed[key] = f
+		}
+	case []interface{}:
+		var vals []string
+		for _, val := range v {
+			if s, ok := val.(string); ok {
+				vals = append(vals, s)
+			}
+		}
+		if len(vals) != 0 {
+			parsed[key] = strings.Join(vals, " ")
+		}
+	case map[string]interface{}:
+		for k, val := range v {
+			addValue(key+"."+k, val, parsed)
+		}
+	case bool:
+		parsed[key] = v
+	}
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process clickhouse log line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, timestamp, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp: timestamp,
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending clickhouse processor")
+}
@@ -0,0 +1,117 @@
+package clickhouse
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLine(t *testing.T) {
+	lp := &ClickHouseLineParser{loc: time.UTC}
+	ts := time.Date(2010, 6, 21, 15, 4, 5, 123456000, time.UTC)
+	const queryID = "8f3d6a7e-0f6a-4b8e-9d4b-1f2e3d4c5b6a"
+	testCases := []struct {
+		input    string
+		expected map[string]interface{}
+	}{
+		{
+			input: "2010.06.21 15:04:05.123456 [ 4242 ] {" + queryID + "} <Debug> executeQuery: (from 192.0.2.7:51234, user: app) SELECT count() FROM hits WHERE id = 1 (stage: Complete)",
+			expected: map[string]interface{}{
+				"thread_id": 4242,
+				"query_id":  queryID,
+				"level":     "Debug",
+				"logger":    "executeQuery",
+				"client":    "192.0.2.7:51234",
+				"user":      "app",
+				"query":     "SELECT count() FROM hits WHERE id = 1",
+			},
+		},
+		{
+			input: "2010.06.21 15:04:05.123456 [ 4242 ] {" + queryID + "} <Information> executeQuery: Read 100 rows, 7.03 KiB in 0.111 sec., 900 rows/sec., 63.33 KiB/sec.",
+			expected: map[string]interface{}{
+				"thread_id":         4242,
+				"query_id":          queryID,
+				"level":             "Information",
+				"logger":            "executeQuery",
+				"read_rows":         int64(100),
+				"read_bytes":        int64(7199),
+				"query_duration_ms": 111.0,
+			},
+		},
+		{
+			input: "2010.06.21 15:04:05.123456 [ 4242 ] {" + queryID + "} <Debug> MemoryTracker: Peak memory usage (for query): 4.00 MiB.",
+			expected: map[string]interface{}{
+				"thread_id":    4242,
+				"query_id":     queryID,
+				"level":        "Debug",
+				"logger":       "MemoryTracker",
+				"memory_usage": int64(4 << 20),
+			},
+		},
+		{
+			input: "2010.06.21 15:04:05.123456 [ 4242 ] {" + queryID + "} <Error> executeQuery: Code: 60. DB::Exception: Table default.x doesn't exist. (UNKNOWN_TABLE) (version 22.8.1.1) (from 192.0.2.7:51234) (in query: SELECT * FROM x), Stack trace (when copying this message, always include the lines below):",
+			expected: map[string]interface{}{
+				"thread_id":      4242,
+				"query_id":       queryID,
+				"level":          "Error",
+				"logger":         "executeQuery",
+				"exception_code": 60,
+				"exception":      "Table default.x doesn't exist.",
+				"error_name":     "UNKNOWN_TABLE",
+				"client":         "192.0.2.7:51234",
+				"query":          "SELECT * FROM x",
+			},
+		},
+		{
+			input: "2010.06.21 15:04:05.123456 [ 1 ] {} <Information> Application: Ready for connections.",
+			expected: map[string]interface{}{
+				"thread_id": 1,
+				"level":     "Information",
+				"logger":    "Application",
+				"message":   "Ready for connections.",
+			},
+		},
+		{
+			// a query_log row, with 64 bit integers quoted
+			input: `{"type":"ExceptionWhileProcessing","event_date":"2010-06-21","event_time":"2010-06-21 15:04:05","event_time_microseconds":"2010-06-21 15:04:05.123456","query_duration_ms":"12","read_rows":"100","read_bytes":"800","memory_usage":"4096","query":"SELECT 1","exception_code":395,"exception":"Value passed to 'throwIf' function is non-zero","databases":["default"],"tables":["default.hits","default.visits"],"user":"app","query_id":"` + queryID + `","is_initial_query":1,"ProfileEvents":{"SelectedRows":"100"},"initial_user":""}`,
+			expected: map[string]interface{}{
+				"type":                       "ExceptionWhileProcessing",
+				"query_duration_ms":          int64(12),
+				"read_rows":                  int64(100),
+				"read_bytes":                 int64(800),
+				"memory_usage":               int64(4096),
+				"query":                      "SELECT 1",
+				"exception_code":             int64(395),
+				"exception":                  "Value passed to 'throwIf' function is non-zero",
+				"databases":                  "default",
+				"tables":                     "default.hits default.visits",
+				"user":                       "app",
+				"query_id":                   queryID,
+				"is_initial_query":           int64(1),
+				"ProfileEvents.SelectedRows": int64(100),
+			},
+		},
+	}
+	for _, tc := range testCases {
+		res, resTs, err := lp.ParseLine(tc.input)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tc.input, err)
+			continue
+		}
+		if !resTs.Equal(ts) {
+			t.Errorf("expected timestamp %s, got %s", ts, resTs)
+		}
+		if !reflect.DeepEqual(res, tc.expected) {
+			t.Errorf("expected\n%v\ngot\n%v", tc.expected, res)
+		}
+	}
+
+	for _, bad := range []string{
+		"0. DB::Exception::Exception() @ 0xa3ef75a in /usr/bin/clickhouse",
+		`{"query":"SELECT 1"}`,
+	} {
+		if _, _, err := lp.ParseLine(bad); err == nil {
+			t.Errorf("expected an error parsing %q", bad)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// Package cloudfront parses AWS CloudFront standard (access) logs.
+//
+// The logs are tab separated, with the columns named by a #Fields: header
+// line at the top of each file. Column names are normalized to lower case
+// with underscores, so cs(User-Agent) becomes cs_user_agent.
+//
+// Lines are sampled after the headers have been taken care of, so sampling
+// never drops the #Fields: header. When a file is read from part way
+// through, the headers at its start are read first.
+// https://docs.aws.amazon.com/AmazonCloudFront/latest/DeveloperGuide/AccessLogs.html
+package cloudfront
+
+import (
+	"errors"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	fieldsHeader = "#Fields:"
+	dateKey      = "date"
+	timeKey      = "time"
+	// the query string is left encoded so its parameters can still be told
+	// apart
+	queryKey = "cs_uri_query"
+)
+
+// defaultFields are the standard log columns, used until a #Fields: header
+// is seen, eg when picking up part way through a file
+var defaultFields = normalizeFields(strings.Fields(`date time x-edge-location
+	sc-bytes c-ip cs-method cs(Host) cs-uri-stem sc-status cs(Referer)
+	cs(User-Agent) cs-uri-query cs(Cookie) x-edge-result-type x-edge-request-id
+	x-host-header cs-protocol cs-bytes time-taken x-forwarded-for ssl-protocol
+	ssl-cipher x-edge-response-result-type cs-protocol-version fle-status
+	fle-encrypted-fields c-port time-to-first-byte x-edge-detailed-result-type
+	sc-content-type sc-content-len sc-range-start sc-range-end`))
+
+var (
+	intFields = map[string]bool{
+		"sc_bytes":       true,
+		"sc_status":      true,
+		"cs_bytes":       true,
+		"c_port":         true,
+		"sc_content_len": true,
+		"sc_range_start": true,
+		"sc_range_end":   true,
+	}
+	floatFields = map[string]bool{
+		"time_taken":         true,
+		"time_to_first_byte": true,
+	}
+)
+
+type Options struct {
+	NumParsers int `hidden:"true" description:"number of cloudfront parsers to spin up"`
+}
+
+type Parser struct {
+	// set SampleRate to cause the parser to drop lines after the headers
+	// before them have been read
+	SampleRate int
+
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string, fields []string) (map[string]interface{}, error)
+}
+
+type CloudFrontLineParser struct{}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	p.lineParser = &CloudFrontLineParser{}
+	return nil
+}
+
+// normalizeFields turns header column names like cs(User-Agent) into
+// cs_user_agent
+func normalizeFields(names []string) []string {
+	replacer := strings.NewReplacer("(", "_", ")", "", "-", "_")
+	fields := make([]string, len(names))
+	for i, name := range names {
+		fields[i] = strings.ToLower(replacer.Replace(name))
+	}
+	return fields
+}
+
+// ParseLine maps the tab separated values in line to the named fields
+func (c *CloudFrontLineParser) ParseLine(line string, fields []string) (map[string]interface{}, error) {
+	values := strings.Split(line, "\t")
+	if len(values) < 2 {
+		return nil, errors.New("line is not tab separated")
+	}
+	parsed := make(map[string]interface{}, len(values))
+	for i, val := range values {
+		if i >= len(fields) {
+			break
+		}
+		name := fields[i]
+		if val == "-" || val == "" {
+			continue
+		}
+		switch {
+		case intFields[name]:
+			if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+				parsed[name] = n
+				continue
+			}
+		case floatFields[name]:
+			if f, err := strconv.ParseFloat(val, 64); err == nil {
+				parsed[name] = f
+				continue
+			}
+		case name == queryKey:
+			parsed[name] = val
+			continue
+		}
+		if decoded, err := url.PathUnescape(val); err == nil {
+			val = decoded
+		}
+		parsed[name] = val
+	}
+	return parsed, nil
+}
+
+// fieldsLine is a line along with the columns in effect when it was read
+type fieldsLine struct {
+	line   string
+	fields []string
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	// headers have to be read in order, so track them here and hand each line
+	// to the workers along with the fields it should be parsed with
+	toParse := make(chan fieldsLine)
+	go func() {
+		fields := defaultFields
+		for line := range lines {
+			logrus.WithFields(logrus.Fields{
+				"line": line,
+			}).Debug("Attempting to process cloudfront log line")
+
+			// take care of any headers on the line
+			var prefix string
+			if prefixRegex != nil {
+				prefix, _ = prefixRegex.FindStringSubmatchMap(line)
+			}
+			content := strings.TrimPrefix(line, prefix)
+			if strings.HasPrefix(content, fieldsHeader) {
+				fields = normalizeFields(strings.Fields(strings.TrimPrefix(content, fieldsHeader)))
+				continue
+			}
+			if strings.HasPrefix(content, "#") {
+				// #Version and any other comments
+				continue
+			}
+			// if sampling is disabled or sampler says keep, pass along this line.
+			if p.SampleRate > 1 && rand.Intn(p.SampleRate) != 0 {
+				continue
+			}
+			toParse <- fieldsLine{line: line, fields: fields}
+		}
+		close(toParse)
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for fl := range toParse {
+				line := fl.line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, err := p.lineParser.ParseLine(line, fl.fields)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp:  p.getTimestamp(parsedLine),
+					SampleRate: p.SampleRate,
+					Data:       parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending cloudfront processor")
+}
+
+// getTimestamp combines the date and time columns, which are in UTC
+func (p *Parser) getTimestamp(m map[string]interface{}) time.Time {
+	date, _ := m[dateKey].(string)
+	tod, _ := m[timeKey].(string)
+	t, err := time.Parse("2006-01-02 15:04:05", date+" "+tod)
+	if err != nil {
+		return p.nower.Now()
+	}
+	delete(m, dateKey)
+	delete(m, timeKey)
+	return t
+}
@@ -0,0 +1,129 @@
+package cloudfront
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+func tsv(fields ...string) string {
+	return strings.Join(fields, "\t")
+}
+
+func TestNormalizeFields(t *testing.T) {
+	got := normalizeFields([]string{"cs(User-Agent)", "x-edge-location", "sc-status", "cs(Host)"})
+	expected := []string{"cs_user_agent", "x_edge_location", "sc_status", "cs_host"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	cfp := CloudFrontLineParser{}
+	fields := normalizeFields([]string{"date", "time", "sc-bytes", "cs-uri-stem", "sc-status", "cs(User-Agent)", "cs-uri-query", "time-taken", "cs(Referer)"})
+	resp, err := cfp.ParseLine(tsv("2019-12-04", "21:02:31", "392", "/index%20page.html", "200", "Mozilla/5.0%20(Windows)", "a=1%26b", "0.001", "-"), fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"date":          "2019-12-04",
+		"time":          "21:02:31",
+		"sc_bytes":      int64(392),
+		"cs_uri_stem":   "/index page.html",
+		"sc_status":     int64(200),
+		"cs_user_agent": "Mozilla/5.0 (Windows)",
+		"cs_uri_query":  "a=1%26b",
+		"time_taken":    0.001,
+	}
+	if !reflect.DeepEqual(resp, expected) {
+		t.Errorf("response %+v didn't match expected %+v", resp, expected)
+	}
+	if _, err := cfp.ParseLine("no tabs here", fields); err == nil {
+		t.Error("expected error parsing line without tabs")
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{
+		conf:       Options{NumParsers: 1},
+		lineParser: &CloudFrontLineParser{},
+		nower:      &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		// without a header the default columns are used
+		lines <- tsv("2019-12-04", "21:02:31", "LAX1", "392")
+		lines <- "#Version: 1.0"
+		lines <- "#Fields: date time sc-status"
+		lines <- tsv("2019-12-05", "01:00:00", "404")
+		close(lines)
+	}()
+	go p.ProcessLines(lines, send, nil)
+
+	expected := []event.Event{
+		{
+			Timestamp: time.Date(2019, 12, 4, 21, 2, 31, 0, time.UTC),
+			Data:      map[string]interface{}{"x_edge_location": "LAX1", "sc_bytes": int64(392)},
+		},
+		{
+			Timestamp: time.Date(2019, 12, 5, 1, 0, 0, 0, time.UTC),
+			Data:      map[string]interface{}{"sc_status": int64(404)},
+		},
+	}
+	for _, exp := range expected {
+		ev := <-send
+		if !reflect.DeepEqual(ev, exp) {
+			t.Errorf("got event %+v, expected %+v", ev, exp)
+		}
+	}
+}
+
+func TestProcessLinesSampled(t *testing.T) {
+	p := &Parser{
+		SampleRate: 10,
+		conf:       Options{NumParsers: 2},
+		lineParser: &CloudFrontLineParser{},
+		nower:      &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		// the header is kept however many lines are dropped, so the lines
+		// after it are parsed with its columns rather than the defaults
+		lines <- "#Version: 1.0"
+		lines <- "#Fields: date time sc-status"
+		for i := 0; i < 1000; i++ {
+			lines <- tsv("2019-12-05", "01:00:00", "404")
+		}
+		close(lines)
+	}()
+	go func() {
+		p.ProcessLines(lines, send, nil)
+		close(send)
+	}()
+
+	var count int
+	for ev := range send {
+		if ev.SampleRate != 10 {
+			t.Errorf("expected sample rate 10, got %d", ev.SampleRate)
+		}
+		if !reflect.DeepEqual(ev.Data, map[string]interface{}{"sc_status": int64(404)}) {
+			t.Errorf("unexpected data %+v", ev.Data)
+		}
+		count++
+	}
+	if count < 30 || count > 300 {
+		t.Errorf("expected about 100 events to be kept, got %d", count)
+	}
+}
@@ -0,0 +1,146 @@
+// Package cloudtrail parses AWS CloudTrail logs.
+//
+// CloudTrail delivers each log file as a single JSON object holding a Records
+// list; each record becomes its own event. Lines holding a single record, as
+// CloudTrail sends to CloudWatch Logs, are accepted too. Nested objects such
+// as userIdentity and requestParameters are flattened into dotted field
+// names, eg userIdentity.arn.
+package cloudtrail
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	recordsKey   = "Records"
+	eventTimeKey = "eventTime"
+)
+
+type Options struct {
+	NumParsers int `hidden:"true" description:"number of cloudtrail parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) ([]map[string]interface{}, error)
+}
+
+type CloudTrailLineParser struct{}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	p.lineParser = &CloudTrailLineParser{}
+	return nil
+}
+
+// ParseLine returns the flattened records in a CloudTrail log file, or the
+// single record on the line
+func (c *CloudTrailLineParser) ParseLine(line string) ([]map[string]interface{}, error) {
+	raw := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(line), &raw); err != nil {
+		return nil, err
+	}
+	var records []interface{}
+	if recs, ok := raw[recordsKey]; ok {
+		if records, ok = recs.([]interface{}); !ok {
+			return nil, errors.New("Records is not a list")
+		}
+	} else if _, ok := raw[eventTimeKey]; ok {
+		records = []interface{}{raw}
+	} else {
+		return nil, errors.New("not a CloudTrail log")
+	}
+
+	parsed := make([]map[string]interface{}, 0, len(records))
+	for _, rec := range records {
+		m, ok := rec.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		flat := make(map[string]interface{})
+		parsers.Flatten("", m, flat)
+		parsed = append(parsed, flat)
+	}
+	return parsed, nil
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process cloudtrail log line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				records, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				for _, record := range records {
+					// merge the prefix fields and the record contents
+					for k, v := range prefixFields {
+						record[k] = v
+					}
+
+					send <- event.Event{
+						Timestamp: p.getTimestamp(record),
+						Data:      record,
+					}
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending cloudtrail processor")
+}
+
+// getTimestamp uses the time the request was made
+func (p *Parser) getTimestamp(m map[string]interface{}) time.Time {
+	if s, ok := m[eventTimeKey].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			delete(m, eventTimeKey)
+			return t.UTC()
+		}
+	}
+	return p.nower.Now()
+}
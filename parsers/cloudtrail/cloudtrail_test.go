@@ -0,0 +1,122 @@
+package cloudtrail
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+const (
+	logFile      = `{"Records":[{"eventVersion":"1.08","userIdentity":{"type":"IAMUser","arn":"arn:aws:iam::123456789012:user/Alice","accountId":"123456789012","sessionContext":{"attributes":{"mfaAuthenticated":"false"}}},"eventTime":"2019-03-05T21:07:58Z","eventSource":"ec2.amazonaws.com","eventName":"StartInstances","awsRegion":"us-east-2","sourceIPAddress":"205.251.233.176","requestParameters":{"instancesSet":{"items":[{"instanceId":"i-ebeaf9e2"}]}},"resources":[{"ARN":"arn:aws:ec2:us-east-2:123456789012:instance/i-ebeaf9e2"}],"readOnly":false},{"eventTime":"2019-03-05T21:08:01Z","eventName":"ConsoleLogin","userIdentity":{"type":"Root"},"additionalEventData":{"MFAUsed":"No"},"tlsDetails":{"tlsVersion":"TLSv1.2"}}]}`
+	singleRecord = `{"eventTime":"2019-03-05T21:09:00.5Z","eventName":"GetObject","requestParameters":{"bucketName":"logs","key":"a.txt"},"eventCategory":"Data"}`
+)
+
+func TestParseLine(t *testing.T) {
+	ctp := CloudTrailLineParser{}
+	testCases := []struct {
+		input    string
+		expected []map[string]interface{}
+	}{
+		{
+			input: logFile,
+			expected: []map[string]interface{}{
+				{
+					"eventVersion":           "1.08",
+					"userIdentity.type":      "IAMUser",
+					"userIdentity.arn":       "arn:aws:iam::123456789012:user/Alice",
+					"userIdentity.accountId": "123456789012",
+					"userIdentity.sessionContext.attributes.mfaAuthenticated": "false",
+					"eventTime":                            "2019-03-05T21:07:58Z",
+					"eventSource":                          "ec2.amazonaws.com",
+					"eventName":                            "StartInstances",
+					"awsRegion":                            "us-east-2",
+					"sourceIPAddress":                      "205.251.233.176",
+					"requestParameters.instancesSet.items": `[{"instanceId":"i-ebeaf9e2"}]`,
+					"resources":                            `[{"ARN":"arn:aws:ec2:us-east-2:123456789012:instance/i-ebeaf9e2"}]`,
+					"readOnly":                             false,
+				},
+				{
+					"eventTime":                   "2019-03-05T21:08:01Z",
+					"eventName":                   "ConsoleLogin",
+					"userIdentity.type":           "Root",
+					"additionalEventData.MFAUsed": "No",
+					"tlsDetails.tlsVersion":       "TLSv1.2",
+				},
+			},
+		},
+		{
+			input: singleRecord,
+			expected: []map[string]interface{}{
+				{
+					"eventTime":                    "2019-03-05T21:09:00.5Z",
+					"eventName":                    "GetObject",
+					"requestParameters.bucketName": "logs",
+					"requestParameters.key":        "a.txt",
+					"eventCategory":                "Data",
+				},
+			},
+		},
+		{
+			input:    `{"Records":[]}`,
+			expected: []map[string]interface{}{},
+		},
+	}
+	for _, tc := range testCases {
+		resp, err := ctp.ParseLine(tc.input)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tc.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(resp, tc.expected) {
+			t.Errorf("response %+v didn't match expected %+v", resp, tc.expected)
+		}
+	}
+	for _, bad := range []string{`{"Records":{}}`, `{"foo":"bar"}`, `not json`} {
+		if _, err := ctp.ParseLine(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{
+		conf:       Options{NumParsers: 1},
+		lineParser: &CloudTrailLineParser{},
+		nower:      &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		lines <- logFile
+		lines <- singleRecord
+		close(lines)
+	}()
+	go p.ProcessLines(lines, send, nil)
+
+	expected := []struct {
+		ts   time.Time
+		name string
+	}{
+		{time.Date(2019, 3, 5, 21, 7, 58, 0, time.UTC), "StartInstances"},
+		{time.Date(2019, 3, 5, 21, 8, 1, 0, time.UTC), "ConsoleLogin"},
+		{time.Date(2019, 3, 5, 21, 9, 0, 500000000, time.UTC), "GetObject"},
+	}
+	for _, exp := range expected {
+		ev := <-send
+		if !ev.Timestamp.Equal(exp.ts) || ev.Data["eventName"] != exp.name {
+			t.Errorf("got event %+v, expected %s at %s", ev, exp.name, exp.ts)
+		}
+		if _, ok := ev.Data["eventTime"]; ok {
+			t.Error("eventTime should have been removed")
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Package cri parses container logs written in the CRI format used by
+// containerd and CRI-O on Kubernetes nodes, optionally handing the
+// container's own log line to another parser.
+//
+// Each line looks like
+//
+//	2024-05-01T10:00:00.000000000Z stdout F actual message
+//
+// where the third field is P for a partial line that continues in the next
+// entry and F for the final (or only) part of a line.
+package cri
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	logKey    = "log"
+	streamKey = "stream"
+	// timeKey carries the entry time through the inner parser so it can be
+	// used as the event's timestamp. It is removed before sending.
+	timeKey = "cri_time"
+
+	partialTag = "P"
+	fullTag    = "F"
+)
+
+// headerRegex matches the header we put in front of each line handed to the
+// inner parser
+const headerRegex = `\[(?P<` + timeKey + `>\S*) (?P<` + streamKey + `>\S*)\] `
+
+type Options struct {
+	InnerParser string `long:"inner_parser" description:"Parser to apply to the container's log line, for example json, keyval or nginx. Options for the inner parser are set with its own flags. If unset, the line is sent as the log field"`
+
+	NumParsers int `hidden:"true" description:"number of cri parsers to spin up"`
+}
+
+type Parser struct {
+	// Inner, if set, parses the log line from each entry. InnerOptions are
+	// passed to its Init.
+	Inner        parsers.Parser
+	InnerOptions interface{}
+
+	conf  Options
+	nower Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+// entry is a single line in the CRI log format
+type entry struct {
+	time   string
+	stream string
+	tag    string
+	log    string
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	if p.Inner != nil {
+		return p.Inner.Init(p.InnerOptions)
+	}
+	return nil
+}
+
+// parseEntry splits a line into its CRI fields
+func parseEntry(line string) (*entry, error) {
+	parts := strings.SplitN(line, " ", 4)
+	if len(parts) < 3 {
+		return nil, errors.New("line is not a CRI log entry")
+	}
+	e := &entry{time: parts[0], stream: parts[1], tag: parts[2]}
+	if len(parts) == 4 {
+		e.log = parts[3]
+	}
+	if e.stream != "stdout" && e.stream != "stderr" {
+		return nil, errors.New("unknown stream " + e.stream)
+	}
+	// the tag may carry more flags after the partial/full one, separated by :
+	if i := strings.Index(e.tag, ":"); i >= 0 {
+		e.tag = e.tag[:i]
+	}
+	if e.tag != partialTag && e.tag != fullTag {
+		return nil, errors.New("unknown log tag " + e.tag)
+	}
+	return e, nil
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	if p.Inner == nil {
+		p.processEntries(lines, prefixRegex, func(prefix string, prefixFields map[string]string, e *entry) {
+			data := map[string]interface{}{
+				logKey:    e.log,
+				streamKey: e.stream,
+			}
+			for k, v := range prefixFields {
+				data[k] = v
+			}
+			send <- event.Event{
+				Timestamp: p.getTimestamp(e.time),
+				Data:      data,
+			}
+		})
+		logrus.Debug("lines channel is closed, ending cri processor")
+		return
+	}
+
+	// the inner parser gets the container's line with the entry fields in a
+	// header that our prefix regex pulls back out, after any prefix the user
+	// asked for
+	innerRegex := `^` + headerRegex
+	if prefixRegex != nil {
+		innerRegex = `^(?:` + strings.TrimPrefix(prefixRegex.String(), "^") + `)` + headerRegex
+	}
+	innerPrefix := &parsers.ExtRegexp{regexp.MustCompile(innerRegex)}
+
+	innerLines := make(chan string)
+	innerEvents := make(chan event.Event)
+	go func() {
+		p.processEntries(lines, prefixRegex, func(prefix string, prefixFields map[string]string, e *entry) {
+			innerLines <- prefix + "[" + e.time + " " + e.stream + "] " + e.log
+		})
+		close(innerLines)
+	}()
+	go func() {
+		p.Inner.ProcessLines(innerLines, innerEvents, innerPrefix)
+		close(innerEvents)
+	}()
+	for ev := range innerEvents {
+		if ts, ok := ev.Data[timeKey].(string); ok {
+			ev.Timestamp = p.getTimestamp(ts)
+			delete(ev.Data, timeKey)
+		}
+		send <- ev
+	}
+	logrus.Debug("lines channel is closed, ending cri processor")
+}
+
+// processEntries parses each line and calls handle with complete entries,
+// along with the prefix matched by prefixRegex and its fields. Partial
+// entries are held until the final part of the line arrives on the same
+// stream; the reassembled entry has the time of its first part.
+func (p *Parser) processEntries(lines <-chan string, prefixRegex *parsers.ExtRegexp,
+	handle func(prefix string, prefixFields map[string]string, e *entry)) {
+	partial := make(map[string]*entry)
+	for line := range lines {
+		logrus.WithFields(logrus.Fields{
+			"line": line,
+		}).Debug("Attempting to process cri log line")
+
+		// take care of any headers on the line
+		var prefix string
+		var prefixFields map[string]string
+		if prefixRegex != nil {
+			prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+			line = strings.TrimPrefix(line, prefix)
+		}
+
+		e, err := parseEntry(line)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"line":  line,
+				"error": err,
+			}).Debug("skipping line; failed to parse.")
+			continue
+		}
+		if started, ok := partial[e.stream]; ok {
+			started.log += e.log
+			e.time, e.log = started.time, started.log
+		}
+		if e.tag == partialTag {
+			partial[e.stream] = e
+			continue
+		}
+		delete(partial, e.stream)
+		handle(prefix, prefixFields, e)
+	}
+}
+
+// getTimestamp parses the RFC3339 time recorded for each entry
+func (p *Parser) getTimestamp(ts string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return p.nower.Now()
+	}
+	return t.UTC()
+}
@@ -0,0 +1,116 @@
+package cri
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+	"github.com/honeycombio/honeytail/parsers/keyval"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+func processLines(p *Parser, lines []string, prefixRegex *parsers.ExtRegexp) []event.Event {
+	linesChan := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		for _, line := range lines {
+			linesChan <- line
+		}
+		close(linesChan)
+	}()
+	go func() {
+		p.ProcessLines(linesChan, send, prefixRegex)
+		close(send)
+	}()
+	var events []event.Event
+	for ev := range send {
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestParseEntry(t *testing.T) {
+	tlms := []struct {
+		line     string
+		expected *entry
+	}{
+		{"2024-05-01T10:00:00.000Z stdout F actual message", &entry{"2024-05-01T10:00:00.000Z", "stdout", "F", "actual message"}},
+		{"2024-05-01T10:00:00.000Z stderr P:extra partial ", &entry{"2024-05-01T10:00:00.000Z", "stderr", "P", "partial "}},
+		{"2024-05-01T10:00:00.000Z stdout F", &entry{"2024-05-01T10:00:00.000Z", "stdout", "F", ""}},
+	}
+	for _, tlm := range tlms {
+		e, err := parseEntry(tlm.line)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tlm.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(e, tlm.expected) {
+			t.Errorf("parsing %q got %+v, expected %+v", tlm.line, e, tlm.expected)
+		}
+	}
+	for _, bad := range []string{"", "just some text", "2024-05-01T10:00:00Z stdin F x", "2024-05-01T10:00:00Z stdout X x"} {
+		if _, err := parseEntry(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{}
+	p.Init(&Options{NumParsers: 1})
+	p.nower = &FakeNower{}
+	events := processLines(p, []string{
+		"2024-05-01T10:00:00.5Z stdout P first ",
+		"2024-05-01T10:00:01Z stderr F an error",
+		"2024-05-01T10:00:02Z stdout P second ",
+		"2024-05-01T10:00:03Z stdout F third",
+		"garbage",
+	}, nil)
+	expected := []event.Event{
+		{
+			Timestamp: time.Date(2024, 5, 1, 10, 0, 1, 0, time.UTC),
+			Data:      map[string]interface{}{"log": "an error", "stream": "stderr"},
+		},
+		{
+			Timestamp: time.Date(2024, 5, 1, 10, 0, 0, 500000000, time.UTC),
+			Data:      map[string]interface{}{"log": "first second third", "stream": "stdout"},
+		},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("got events %+v, expected %+v", events, expected)
+	}
+}
+
+func TestProcessLinesInnerParser(t *testing.T) {
+	p := &Parser{
+		Inner:        &keyval.Parser{},
+		InnerOptions: &keyval.Options{NumParsers: 1},
+	}
+	if err := p.Init(&Options{NumParsers: 1}); err != nil {
+		t.Fatal(err)
+	}
+	p.nower = &FakeNower{}
+	events := processLines(p, []string{
+		"2024-05-01T10:00:00Z stdout P status=200 ",
+		"2024-05-01T10:00:01Z stdout F path=/home",
+	}, nil)
+	expected := []event.Event{{
+		Timestamp: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		Data: map[string]interface{}{
+			"status": 200,
+			"path":   "/home",
+			"stream": "stdout",
+		},
+	}}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("got events %+v, expected %+v", events, expected)
+	}
+}
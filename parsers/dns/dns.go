@@ -0,0 +1,322 @@
+// Package dns parses the query logs of BIND and dnsmasq.
+//
+// BIND logs each query with querylog enabled, either to its own channel:
+//
+//	21-Jun-2010 15:04:05.123 queries: info: client @0x7f1c3c0 192.0.2.7#53412 (example.com): query: example.com IN A +E(0)K (192.0.2.1)
+//
+// or through syslog. The query's flags are split out: whether recursion was
+// desired, and whether it came over TCP, used EDNS, set the DO bit or the
+// CD bit.
+//
+// dnsmasq logs through syslog with log-queries, a line for each query, each
+// time it's forwarded and each answer:
+//
+//	Jun 21 15:04:05 gw dnsmasq[1234]: query[A] example.com from 192.0.2.7
+//	Jun 21 15:04:05 gw dnsmasq[1234]: forwarded example.com to 192.0.2.53
+//	Jun 21 15:04:05 gw dnsmasq[1234]: reply example.com is 93.184.216.34
+//
+// Each becomes an event with an event field (query, forwarded, reply,
+// cached and so on), the qname, and the qtype, client, upstream or answer
+// as the line has them. With log-queries=extra, the id dnsmasq gives each
+// query ties its lines together as query_id.
+package dns
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	hostnameKey         = "hostname"
+	processKey          = "process"
+	pidKey              = "pid"
+	messageKey          = "message"
+	eventKey            = "event"
+	clientIPKey         = "client_ip"
+	clientPortKey       = "client_port"
+	qnameKey            = "qname"
+	qclassKey           = "qclass"
+	qtypeKey            = "qtype"
+	flagsKey            = "flags"
+	recursionDesiredKey = "recursion_desired"
+	tcpKey              = "tcp"
+	ednsKey             = "edns"
+	dnssecOKKey         = "dnssec_ok"
+	checkingDisabledKey = "checking_disabled"
+	serverIPKey         = "server_ip"
+	viewKey             = "view"
+	upstreamKey         = "upstream"
+	answerKey           = "answer"
+	rcodeKey            = "rcode"
+	sourceKey           = "source"
+	queryIDKey          = "query_id"
+
+	bindTimeLayout = "02-Jan-2006 15:04:05.999"
+	// syslog timestamps have no year
+	syslogTimeLayout = "Jan _2 15:04:05"
+	// timestamps further than this into the future are taken to be from the
+	// year before, eg a December line read in January
+	maxFutureSkew = 7 * 24 * time.Hour
+)
+
+var (
+	// bindLineRegex matches a line of a BIND logging channel: the time, then
+	// the message after the category and severity, if they're printed
+	bindLineRegex = regexp.MustCompile(`^(\d{2}-\w{3}-\d{4} \d{2}:\d{2}:\d{2}(?:\.\d+)?) (?:[\w-]+: )*(.*)$`)
+	// syslogRegex matches the syslog header of a line from named or
+	// dnsmasq, with either a traditional or an RFC3339 timestamp
+	syslogRegex = regexp.MustCompile(`^(\w{3} [ \d]\d \d{2}:\d{2}:\d{2}|\d{4}-\d{2}-\d{2}T\S+) (?:(\S+) )?(named|dnsmasq)(?:\[(\d+)\])?: (.*)$`)
+	// bindQueryRegex matches a query in BIND's query log: the client's
+	// address and port, the name it asked about, the view, the query and
+	// its flags, and the address it was sent to
+	bindQueryRegex = regexp.MustCompile(`^(?:[\w-]+: )*client (?:@0x[0-9a-f]+ )?([^#\s]+)#(\d+)(?: \([^)]*\))?: (?:view ([^:]+): )?query: (\S+) (\S+) (\S+) ([-+][A-Z0-9()]*)(?: \(([^)]*)\))?$`)
+	// dnsmasqExtraRegex matches the id and client dnsmasq adds with
+	// log-queries=extra
+	dnsmasqExtraRegex = regexp.MustCompile(`^(\d+) ([^/\s]+)/(\d+) (.*)$`)
+	// dnsmasqQueryRegex matches a query, or a DNSSEC query dnsmasq makes
+	dnsmasqQueryRegex = regexp.MustCompile(`^(query|dnssec-query)\[(\w+)\] (\S+) (?:from|to) (\S+)$`)
+	// dnsmasqForwardedRegex matches a query being forwarded upstream
+	dnsmasqForwardedRegex = regexp.MustCompile(`^forwarded (\S+) to (\S+)$`)
+	// dnsmasqAnswerRegex matches an answer, from upstream, the cache, the
+	// configuration or a hosts file
+	dnsmasqAnswerRegex = regexp.MustCompile(`^(\S+) (\S+) is (.*)$`)
+)
+
+// rcodes are the answers dnsmasq logs instead of an address when there
+// isn't one
+var rcodes = map[string]string{
+	"NXDOMAIN":      "NXDOMAIN",
+	"SERVFAIL":      "SERVFAIL",
+	"REFUSED":       "REFUSED",
+	"NODATA":        "NODATA",
+	"NODATA-IPv4":   "NODATA",
+	"NODATA-IPv6":   "NODATA",
+	"NXDOMAIN-IPv4": "NXDOMAIN",
+	"NXDOMAIN-IPv6": "NXDOMAIN",
+}
+
+type Options struct {
+	TimeZone string `long:"timezone" description:"IANA name of the time zone the log was written in, eg America/Los_Angeles. Defaults to the local time zone"`
+
+	NumParsers int `hidden:"true" description:"number of dns parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, time.Time, error)
+}
+
+type DNSLineParser struct {
+	loc   *time.Location
+	nower Nower
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	loc := time.Local
+	if p.conf.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(p.conf.TimeZone); err != nil {
+			return err
+		}
+	}
+	p.lineParser = &DNSLineParser{loc: loc, nower: p.nower}
+	return nil
+}
+
+// ParseLine parses a line from a BIND logging channel, or from named or
+// dnsmasq through syslog
+func (d *DNSLineParser) ParseLine(line string) (map[string]interface{}, time.Time, error) {
+	parsed := make(map[string]interface{})
+	if m := bindLineRegex.FindStringSubmatch(line); m != nil {
+		ts, err := time.ParseInLocation(bindTimeLayout, m[1], d.loc)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		parseBind(m[2], parsed)
+		return parsed, ts.UTC(), nil
+	}
+	m := syslogRegex.FindStringSubmatch(line)
+	if m == nil {
+		return nil, time.Time{}, errors.New("line is not a BIND or dnsmasq log line")
+	}
+	ts, err := d.parseTime(m[1])
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if m[2] != "" {
+		parsed[hostnameKey] = m[2]
+	}
+	parsed[processKey] = m[3]
+	if pid, err := strconv.Atoi(m[4]); err == nil {
+		parsed[pidKey] = pid
+	}
+	if m[3] == "named" {
+		parseBind(m[5], parsed)
+	} else {
+		parseDnsmasq(m[5], parsed)
+	}
+	return parsed, ts, nil
+}
+
+// parseBind adds the fields of a query to parsed, or the message if it
+// isn't one
+func parseBind(msg string, parsed map[string]interface{}) {
+	m := bindQueryRegex.FindStringSubmatch(msg)
+	if m == nil {
+		parsed[messageKey] = msg
+		return
+	}
+	parsed[eventKey] = "query"
+	parsed[clientIPKey] = m[1]
+	parsed[clientPortKey], _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		parsed[viewKey] = m[3]
+	}
+	parsed[qnameKey] = m[4]
+	parsed[qclassKey] = m[5]
+	parsed[qtypeKey] = m[6]
+	flags := m[7]
+	parsed[flagsKey] = flags
+	parsed[recursionDesiredKey] = flags[0] == '+'
+	parsed[tcpKey] = strings.Contains(flags, "T")
+	parsed[ednsKey] = strings.Contains(flags, "E")
+	parsed[dnssecOKKey] = strings.Contains(flags, "D")
+	parsed[checkingDisabledKey] = strings.Contains(flags, "C")
+	if m[8] != "" {
+		parsed[serverIPKey] = m[8]
+	}
+}
+
+// parseDnsmasq adds the fields of a query, forward or answer to parsed, or
+// the message if it isn't one
+func parseDnsmasq(msg string, parsed map[string]interface{}) {
+	if m := dnsmasqExtraRegex.FindStringSubmatch(msg); m != nil {
+		parsed[queryIDKey], _ = strconv.Atoi(m[1])
+		parsed[clientIPKey] = m[2]
+		parsed[clientPortKey], _ = strconv.Atoi(m[3])
+		msg = m[4]
+	}
+	if m := dnsmasqQueryRegex.FindStringSubmatch(msg); m != nil {
+		parsed[qtypeKey] = m[2]
+		parsed[qnameKey] = m[3]
+		if m[1] == "query" {
+			parsed[eventKey] = "query"
+			parsed[clientIPKey] = m[4]
+		} else {
+			parsed[eventKey] = "dnssec_query"
+			parsed[upstreamKey] = m[4]
+		}
+		return
+	}
+	if m := dnsmasqForwardedRegex.FindStringSubmatch(msg); m != nil {
+		parsed[eventKey] = "forwarded"
+		parsed[qnameKey] = m[1]
+		parsed[upstreamKey] = m[2]
+		return
+	}
+	if m := dnsmasqAnswerRegex.FindStringSubmatch(msg); m != nil {
+		// answers from a hosts file give the file's path
+		if strings.HasPrefix(m[1], "/") {
+			parsed[eventKey] = "local"
+			parsed[sourceKey] = m[1]
+		} else {
+			parsed[eventKey] = m[1]
+		}
+		parsed[qnameKey] = m[2]
+		parsed[answerKey] = m[3]
+		if rcode, ok := rcodes[m[3]]; ok {
+			parsed[rcodeKey] = rcode
+		}
+		return
+	}
+	parsed[messageKey] = msg
+}
+
+// parseTime parses either syslog timestamp format, filling in the year
+// missing from traditional timestamps. The current year is assumed unless
+// that puts the line well into the future, in which case it must have been
+// written last year.
+func (d *DNSLineParser) parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t.UTC(), nil
+	}
+	now := d.nower.Now().In(d.loc)
+	t, err := time.ParseInLocation(syslogTimeLayout, s, d.loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	ts := time.Date(now.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, d.loc)
+	if ts.Sub(now) > maxFutureSkew {
+		ts = ts.AddDate(-1, 0, 0)
+	}
+	return ts.UTC(), nil
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process dns log line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, timestamp, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp: timestamp,
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending dns processor")
+}
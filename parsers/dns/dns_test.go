@@ -0,0 +1,148 @@
+package dns
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	return time.Date(2010, 6, 22, 0, 0, 0, 0, time.UTC)
+}
+
+type testLineMap struct {
+	line     string
+	expected map[string]interface{}
+	ts       time.Time
+}
+
+var tlms = []testLineMap{
+	{
+		line: "21-Jun-2010 15:04:05.123 queries: info: client @0x7f1c3c0 192.0.2.7#53412 (example.com): query: example.com IN A +E(0)K (192.0.2.1)",
+		expected: map[string]interface{}{
+			"event":             "query",
+			"client_ip":         "192.0.2.7",
+			"client_port":       53412,
+			"qname":             "example.com",
+			"qclass":            "IN",
+			"qtype":             "A",
+			"flags":             "+E(0)K",
+			"recursion_desired": true,
+			"tcp":               false,
+			"edns":              true,
+			"dnssec_ok":         false,
+			"checking_disabled": false,
+			"server_ip":         "192.0.2.1",
+		},
+		ts: time.Date(2010, 6, 21, 15, 4, 5, 123000000, time.UTC),
+	},
+	{
+		line: "Jun 21 15:04:06 ns1 named[812]: client 2001:db8::7#40000: view internal: query: mail.example.com IN MX -TDC (2001:db8::1)",
+		expected: map[string]interface{}{
+			"hostname":          "ns1",
+			"process":           "named",
+			"pid":               812,
+			"event":             "query",
+			"client_ip":         "2001:db8::7",
+			"client_port":       40000,
+			"view":              "internal",
+			"qname":             "mail.example.com",
+			"qclass":            "IN",
+			"qtype":             "MX",
+			"flags":             "-TDC",
+			"recursion_desired": false,
+			"tcp":               true,
+			"edns":              false,
+			"dnssec_ok":         true,
+			"checking_disabled": true,
+			"server_ip":         "2001:db8::1",
+		},
+		ts: time.Date(2010, 6, 21, 15, 4, 6, 0, time.UTC),
+	},
+	{
+		line: "Jun 21 15:04:07 gw dnsmasq[1234]: query[AAAA] example.com from 192.0.2.8",
+		expected: map[string]interface{}{
+			"hostname":  "gw",
+			"process":   "dnsmasq",
+			"pid":       1234,
+			"event":     "query",
+			"qtype":     "AAAA",
+			"qname":     "example.com",
+			"client_ip": "192.0.2.8",
+		},
+		ts: time.Date(2010, 6, 21, 15, 4, 7, 0, time.UTC),
+	},
+	{
+		line: "Jun 21 15:04:07 gw dnsmasq[1234]: 17 192.0.2.8/41000 forwarded example.com to 192.0.2.53",
+		expected: map[string]interface{}{
+			"hostname":    "gw",
+			"process":     "dnsmasq",
+			"pid":         1234,
+			"query_id":    17,
+			"client_ip":   "192.0.2.8",
+			"client_port": 41000,
+			"event":       "forwarded",
+			"qname":       "example.com",
+			"upstream":    "192.0.2.53",
+		},
+		ts: time.Date(2010, 6, 21, 15, 4, 7, 0, time.UTC),
+	},
+	{
+		line: "Jun 21 15:04:07 gw dnsmasq[1234]: reply nope.example.com is NXDOMAIN",
+		expected: map[string]interface{}{
+			"hostname": "gw",
+			"process":  "dnsmasq",
+			"pid":      1234,
+			"event":    "reply",
+			"qname":    "nope.example.com",
+			"answer":   "NXDOMAIN",
+			"rcode":    "NXDOMAIN",
+		},
+		ts: time.Date(2010, 6, 21, 15, 4, 7, 0, time.UTC),
+	},
+	{
+		line: "Jun 21 15:04:08 gw dnsmasq[1234]: /etc/hosts printer.lan is 192.0.2.20",
+		expected: map[string]interface{}{
+			"hostname": "gw",
+			"process":  "dnsmasq",
+			"pid":      1234,
+			"event":    "local",
+			"source":   "/etc/hosts",
+			"qname":    "printer.lan",
+			"answer":   "192.0.2.20",
+		},
+		ts: time.Date(2010, 6, 21, 15, 4, 8, 0, time.UTC),
+	},
+	{
+		line: "Jun 21 15:04:09 gw dnsmasq[1234]: read /etc/hosts - 3 addresses",
+		expected: map[string]interface{}{
+			"hostname": "gw",
+			"process":  "dnsmasq",
+			"pid":      1234,
+			"message":  "read /etc/hosts - 3 addresses",
+		},
+		ts: time.Date(2010, 6, 21, 15, 4, 9, 0, time.UTC),
+	},
+}
+
+func TestParseLine(t *testing.T) {
+	p := &DNSLineParser{loc: time.UTC, nower: &FakeNower{}}
+	for _, tlm := range tlms {
+		resp, ts, err := p.ParseLine(tlm.line)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tlm.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(resp, tlm.expected) {
+			t.Errorf("response from ParseLine(%q)\n\t%+v\ndid not match expected\n\t%+v", tlm.line, resp, tlm.expected)
+		}
+		if !ts.Equal(tlm.ts) {
+			t.Errorf("timestamp from ParseLine(%q) was %s, expected %s", tlm.line, ts, tlm.ts)
+		}
+	}
+	if _, _, err := p.ParseLine("Jun 21 15:04:09 gw sshd[99]: Accepted publickey for alice"); err == nil {
+		t.Error("expected an error parsing a line from another program")
+	}
+}
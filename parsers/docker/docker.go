@@ -0,0 +1,180 @@
+// Package docker parses the logs written by Docker's json-file logging driver,
+// optionally handing the container's own log line to another parser.
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	logKey    = "log"
+	streamKey = "stream"
+	// timeKey carries the envelope time through the inner parser so it can
+	// be used as the event's timestamp. It is removed before sending.
+	timeKey = "docker_time"
+)
+
+// envelopeRegex matches the header we put in front of each line handed to
+// the inner parser
+const envelopeRegex = `\[(?P<` + timeKey + `>\S*) (?P<` + streamKey + `>\S*)\] `
+
+type Options struct {
+	InnerParser string `long:"inner_parser" description:"Parser to apply to the container's log line, for example json, keyval or nginx. Options for the inner parser are set with its own flags. If unset, the line is sent as the log field"`
+
+	NumParsers int `hidden:"true" description:"number of docker parsers to spin up"`
+}
+
+type Parser struct {
+	// Inner, if set, parses the log line from each entry. InnerOptions are
+	// passed to its Init.
+	Inner        parsers.Parser
+	InnerOptions interface{}
+
+	conf  Options
+	nower Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+// entry is a single line written by the json-file driver
+type entry struct {
+	Log    string `json:"log"`
+	Stream string `json:"stream"`
+	Time   string `json:"time"`
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	if p.Inner != nil {
+		return p.Inner.Init(p.InnerOptions)
+	}
+	return nil
+}
+
+// parseEntry decodes the json-file envelope
+func parseEntry(line string) (*entry, error) {
+	e := &entry{}
+	if err := json.Unmarshal([]byte(line), e); err != nil {
+		return nil, err
+	}
+	if e.Stream == "" && e.Time == "" {
+		return nil, errors.New("line is not a docker json-file log entry")
+	}
+	return e, nil
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	if p.Inner == nil {
+		p.processEntries(lines, prefixRegex, func(prefix string, prefixFields map[string]string, e *entry, log string) {
+			data := map[string]interface{}{
+				logKey:    log,
+				streamKey: e.Stream,
+			}
+			for k, v := range prefixFields {
+				data[k] = v
+			}
+			send <- event.Event{
+				Timestamp: p.getTimestamp(e.Time),
+				Data:      data,
+			}
+		})
+		logrus.Debug("lines channel is closed, ending docker processor")
+		return
+	}
+
+	// the inner parser gets the container's line with the envelope fields in
+	// a header that our prefix regex pulls back out, after any prefix the
+	// user asked for
+	innerRegex := `^` + envelopeRegex
+	if prefixRegex != nil {
+		innerRegex = `^(?:` + strings.TrimPrefix(prefixRegex.String(), "^") + `)` + envelopeRegex
+	}
+	innerPrefix := &parsers.ExtRegexp{regexp.MustCompile(innerRegex)}
+
+	innerLines := make(chan string)
+	innerEvents := make(chan event.Event)
+	go func() {
+		p.processEntries(lines, prefixRegex, func(prefix string, prefixFields map[string]string, e *entry, log string) {
+			innerLines <- prefix + "[" + e.Time + " " + e.Stream + "] " + log
+		})
+		close(innerLines)
+	}()
+	go func() {
+		p.Inner.ProcessLines(innerLines, innerEvents, innerPrefix)
+		close(innerEvents)
+	}()
+	for ev := range innerEvents {
+		if ts, ok := ev.Data[timeKey].(string); ok {
+			ev.Timestamp = p.getTimestamp(ts)
+			delete(ev.Data, timeKey)
+		}
+		send <- ev
+	}
+	logrus.Debug("lines channel is closed, ending docker processor")
+}
+
+// processEntries unwraps each line and calls handle with the container's
+// log line, along with the prefix matched by prefixRegex and its fields.
+// Lines longer than 16k are split over several entries by docker; they are
+// joined back together before being handled.
+func (p *Parser) processEntries(lines <-chan string, prefixRegex *parsers.ExtRegexp,
+	handle func(prefix string, prefixFields map[string]string, e *entry, log string)) {
+	partial := make(map[string]string)
+	for line := range lines {
+		logrus.WithFields(logrus.Fields{
+			"line": line,
+		}).Debug("Attempting to process docker log line")
+
+		// take care of any headers on the line
+		var prefix string
+		var prefixFields map[string]string
+		if prefixRegex != nil {
+			prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+			line = strings.TrimPrefix(line, prefix)
+		}
+
+		e, err := parseEntry(line)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"line":  line,
+				"error": err,
+			}).Debug("skipping line; failed to parse.")
+			continue
+		}
+		// partial lines don't end in a newline; keep them until the rest arrives
+		if !strings.HasSuffix(e.Log, "\n") {
+			partial[e.Stream] += e.Log
+			continue
+		}
+		log := partial[e.Stream] + strings.TrimSuffix(e.Log, "\n")
+		delete(partial, e.Stream)
+		handle(prefix, prefixFields, e, strings.TrimSuffix(log, "\r"))
+	}
+}
+
+// getTimestamp parses the RFC3339 time docker records for each entry
+func (p *Parser) getTimestamp(ts string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return p.nower.Now()
+	}
+	return t.UTC()
+}
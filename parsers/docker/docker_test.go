@@ -0,0 +1,91 @@
+package docker
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+	"github.com/honeycombio/honeytail/parsers/htjson"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+func processLines(p *Parser, lines []string, prefixRegex *parsers.ExtRegexp) []event.Event {
+	linesChan := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		for _, line := range lines {
+			linesChan <- line
+		}
+		close(linesChan)
+	}()
+	go func() {
+		p.ProcessLines(linesChan, send, prefixRegex)
+		close(send)
+	}()
+	var events []event.Event
+	for ev := range send {
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{}
+	p.Init(&Options{NumParsers: 1})
+	p.nower = &FakeNower{}
+	events := processLines(p, []string{
+		`{"log":"hello world\n","stream":"stdout","time":"2017-04-20T18:05:36.123456789Z"}`,
+		`not json`,
+		`{"log":"first half, ","stream":"stderr","time":"2017-04-20T18:05:37Z"}`,
+		`{"log":"second half\r\n","stream":"stderr","time":"bogus"}`,
+	}, nil)
+	expected := []event.Event{
+		{
+			Timestamp: time.Date(2017, 4, 20, 18, 5, 36, 123456789, time.UTC),
+			Data:      map[string]interface{}{"log": "hello world", "stream": "stdout"},
+		},
+		{
+			Timestamp: p.nower.Now(),
+			Data:      map[string]interface{}{"log": "first half, second half", "stream": "stderr"},
+		},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("got events %+v, expected %+v", events, expected)
+	}
+}
+
+func TestProcessLinesInnerParser(t *testing.T) {
+	p := &Parser{
+		Inner:        &htjson.Parser{},
+		InnerOptions: &htjson.Options{NumParsers: 2},
+	}
+	if err := p.Init(&Options{NumParsers: 1}); err != nil {
+		t.Fatal(err)
+	}
+	p.nower = &FakeNower{}
+	prefix := &parsers.ExtRegexp{regexp.MustCompile(`^(?P<host>\w+): `)}
+	events := processLines(p, []string{
+		`web1: {"log":"{\"status\":200,\"path\":\"/\"}\n","stream":"stdout","time":"2017-04-20T18:05:36Z"}`,
+	}, prefix)
+	expected := []event.Event{{
+		Timestamp: time.Date(2017, 4, 20, 18, 5, 36, 0, time.UTC),
+		Data: map[string]interface{}{
+			"status": float64(200),
+			"path":   "/",
+			"stream": "stdout",
+			"host":   "web1",
+		},
+	}}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("got events %+v, expected %+v", events, expected)
+	}
+}
@@ -0,0 +1,358 @@
+// Package exim parses Exim's main log, eg
+//
+//	2010-06-21 15:04:05 1OQmXw-0001Ab-Cd <= alice@example.com H=mail.example.com [192.0.2.7] P=esmtps S=1234 id=abc@example.com
+//	2010-06-21 15:04:06 1OQmXw-0001Ab-Cd => bob@example.org R=dnslookup T=remote_smtp H=mx.example.org [198.51.100.2] C="250 OK" QT=1s DT=0s
+//	2010-06-21 15:04:06 1OQmXw-0001Ab-Cd Completed
+//
+// The flag following the message id gives the phase: arrival (<=),
+// delivery (=>), additional_delivery (->), cutthrough_delivery (>>),
+// suppressed_delivery (*>), failed_delivery (**) and deferred_delivery
+// (==). The address after it is the sender of arrivals and the recipient of
+// deliveries. The key=value data after that is split into fields, such as
+// host, host_ip, protocol, size, router and transport; the confirmation of
+// a delivery, or the error a delivery failed or was deferred with, is kept
+// as result. Lines that aren't about a message keep their text as message.
+package exim
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	pidKey               = "pid"
+	messageIDKey         = "message_id"
+	phaseKey             = "phase"
+	senderKey            = "sender"
+	recipientKey         = "recipient"
+	originalRecipientKey = "original_recipient"
+	recipientsKey        = "recipients"
+	hostKey              = "host"
+	hostIPKey            = "host_ip"
+	resultKey            = "result"
+	messageKey           = "message"
+
+	completedPhase = "completed"
+
+	timeLayout = "2006-01-02 15:04:05.999"
+)
+
+var (
+	// lineRegex matches a line: the time, with the zone if log_timezone is
+	// set, the pid if log_selector has +pid, and the rest
+	lineRegex = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?)(?: ([-+]\d{4}))?(?: \[(\d+)\])? (.*)$`)
+	// messageIDRegex matches the id of the message a line is about, in the
+	// format before or since Exim 4.97
+	messageIDRegex = regexp.MustCompile(`^([0-9A-Za-z]{6}-[0-9A-Za-z]{6,11}-[0-9A-Za-z]{2,4}) (.*)$`)
+	// flagRegex matches the flag and what follows it
+	flagRegex = regexp.MustCompile(`^(<=|=>|->|>>|\*>|\*\*|==) (.*)$`)
+	// keyRegex matches the key of a key=value pair
+	keyRegex = regexp.MustCompile(`^([A-Za-z]+)=`)
+	// hostRegex matches the value of H=, the host name, the name it gave
+	// in HELO and its address, any of which may be missing
+	hostRegex = regexp.MustCompile(`^(?:([^\s(\[]+) ?)?(?:\(([^)]*)\) ?)?(?:\[([0-9a-fA-F:.]+)\](?::(\d+))?)?$`)
+)
+
+// phases name the phase of each flag
+var phases = map[string]string{
+	"<=": "arrival",
+	"=>": "delivery",
+	"->": "additional_delivery",
+	">>": "cutthrough_delivery",
+	"*>": "suppressed_delivery",
+	"**": "failed_delivery",
+	"==": "deferred_delivery",
+}
+
+// keyNames name the fields of the keys in a line's data. T= is the subject
+// of an arrival, but the transport of a delivery.
+var keyNames = map[string]string{
+	"A":  "authenticator",
+	"CV": "certificate_verified",
+	"DN": "certificate_dn",
+	"DT": "delivery_time_s",
+	"F":  "from",
+	"I":  "interface",
+	"P":  "protocol",
+	"QT": "queue_time_s",
+	"R":  "router",
+	"S":  "size",
+	"T":  "transport",
+	"U":  "user",
+	"X":  "tls_cipher",
+	"id": "header_message_id",
+}
+
+type Options struct {
+	TimeZone string `long:"timezone" description:"IANA name of the time zone Exim logs in, eg America/Los_Angeles, unless log_timezone is set. Defaults to the local time zone"`
+
+	NumParsers int `hidden:"true" description:"number of exim parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, time.Time, error)
+}
+
+type EximLineParser struct {
+	loc *time.Location
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	loc := time.Local
+	if p.conf.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(p.conf.TimeZone); err != nil {
+			return err
+		}
+	}
+	p.lineParser = &EximLineParser{loc: loc}
+	return nil
+}
+
+// ParseLine parses a main log line
+func (e *EximLineParser) ParseLine(line string) (map[string]interface{}, time.Time, error) {
+	m := lineRegex.FindStringSubmatch(line)
+	if m == nil {
+		return nil, time.Time{}, errors.New("line is not an exim main log line")
+	}
+	var ts time.Time
+	var err error
+	if m[2] != "" {
+		ts, err = time.Parse(timeLayout+" -0700", m[1]+" "+m[2])
+	} else {
+		ts, err = time.ParseInLocation(timeLayout, m[1], e.loc)
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	parsed := make(map[string]interface{})
+	if m[3] != "" {
+		parsed[pidKey], _ = strconv.Atoi(m[3])
+	}
+	rest := m[4]
+	id := messageIDRegex.FindStringSubmatch(rest)
+	if id == nil {
+		parsed[messageKey] = rest
+		return parsed, ts.UTC(), nil
+	}
+	parsed[messageIDKey] = id[1]
+	rest = id[2]
+	if rest == "Completed" {
+		parsed[phaseKey] = completedPhase
+		return parsed, ts.UTC(), nil
+	}
+	flag := flagRegex.FindStringSubmatch(rest)
+	if flag == nil {
+		parsed[messageKey] = rest
+		return parsed, ts.UTC(), nil
+	}
+	parsed[phaseKey] = phases[flag[1]]
+	parseData(flag[1], flag[2], parsed)
+	return parsed, ts.UTC(), nil
+}
+
+// parseData parses what follows the flag: the address, any original
+// address, then key=value pairs, and for arrivals the recipients. An error
+// follows the first colon ending a word.
+func parseData(flag, data string, parsed map[string]interface{}) {
+	arrival := flag == "<="
+	first := true
+	for data != "" {
+		data = strings.TrimLeft(data, " ")
+		if data == "" {
+			break
+		}
+		if arrival && strings.HasPrefix(data, "for ") {
+			parsed[recipientsKey] = strings.TrimSpace(data[len("for "):])
+			return
+		}
+		var key, val string
+		if k := keyRegex.FindStringSubmatch(data); k != nil {
+			key = k[1]
+			data = data[len(k[0]):]
+		}
+		val, data = nextValue(data, key == "H")
+		errorFollows := strings.HasSuffix(val, ":") && (data == "" || data[0] == ' ')
+		if errorFollows {
+			val = strings.TrimSuffix(val, ":")
+		}
+		switch {
+		case key == "H":
+			addHost(val, parsed)
+		case key == "T" && arrival:
+			parsed["subject"] = val
+		case key != "":
+			addValue(key, val, parsed)
+		case first && arrival:
+			parsed[senderKey] = val
+		case first:
+			parsed[recipientKey] = val
+		case strings.HasPrefix(val, "<") && strings.HasSuffix(val, ">"):
+			parsed[originalRecipientKey] = strings.Trim(val, "<>")
+		}
+		first = false
+		if errorFollows {
+			parsed[resultKey] = strings.TrimSpace(data)
+			return
+		}
+	}
+}
+
+// nextValue returns the value at the start of data, and the rest. Quoted
+// values are unquoted. A host's value takes in the HELO name and address
+// that follow it.
+func nextValue(data string, host bool) (string, string) {
+	if strings.HasPrefix(data, `"`) {
+		var val []byte
+		for i := 1; i < len(data); i++ {
+			switch {
+			case data[i] == '\\' && i+1 < len(data):
+				i++
+				val = append(val, data[i])
+			case data[i] == '"':
+				// keep a colon directly after the quotes, which starts an
+				// error
+				rest := data[i+1:]
+				if strings.HasPrefix(rest, ":") {
+					return string(val) + ":", rest[1:]
+				}
+				return string(val), rest
+			default:
+				val = append(val, data[i])
+			}
+		}
+		return string(val), ""
+	}
+	end := strings.IndexByte(data, ' ')
+	if end < 0 {
+		return data, ""
+	}
+	val, rest := data[:end], data[end:]
+	for host && !strings.HasSuffix(val, ":") {
+		next := strings.TrimLeft(rest, " ")
+		if !strings.HasPrefix(next, "(") && !strings.HasPrefix(next, "[") {
+			break
+		}
+		end = strings.IndexByte(next, ' ')
+		if end < 0 {
+			return val + " " + next, ""
+		}
+		val, rest = val+" "+next[:end], next[end:]
+	}
+	return val, rest
+}
+
+// addHost adds the host name and address from the value of H=
+func addHost(val string, parsed map[string]interface{}) {
+	m := hostRegex.FindStringSubmatch(val)
+	if m == nil {
+		parsed[hostKey] = val
+		return
+	}
+	switch {
+	case m[1] != "":
+		parsed[hostKey] = m[1]
+	case m[2] != "":
+		parsed[hostKey] = m[2]
+	}
+	if m[3] != "" {
+		parsed[hostIPKey] = m[3]
+	}
+}
+
+// addValue adds a key=value pair, with sizes as numbers and times in
+// seconds
+func addValue(key, val string, parsed map[string]interface{}) {
+	name, ok := keyNames[key]
+	if !ok {
+		name = strings.ToLower(key)
+	}
+	switch key {
+	case "S":
+		if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+			parsed[name] = n
+			return
+		}
+	case "QT", "DT":
+		if d, err := time.ParseDuration(val); err == nil {
+			parsed[name] = d.Seconds()
+			return
+		}
+	case "C":
+		parsed[resultKey] = val
+		return
+	case "F":
+		val = strings.Trim(val, "<>")
+	}
+	parsed[name] = val
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process exim log line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, timestamp, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp: timestamp,
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending exim processor")
+}
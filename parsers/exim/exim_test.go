@@ -0,0 +1,111 @@
+package exim
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testLineMap struct {
+	line     string
+	expected map[string]interface{}
+	ts       time.Time
+}
+
+var tlms = []testLineMap{
+	{
+		line: `2010-06-21 15:04:05 1OQmXw-0001Ab-Cd <= alice@example.com H=mail.example.com (helo.example.com) [192.0.2.7]:51234 P=esmtps X=TLS1.2:ECDHE-RSA-AES256-GCM-SHA384:256 CV=no A=dovecot_plain:alice S=1234 id=abc@example.com T="Quarterly \"numbers\"" for bob@example.org carol@example.org`,
+		expected: map[string]interface{}{
+			"message_id":           "1OQmXw-0001Ab-Cd",
+			"phase":                "arrival",
+			"sender":               "alice@example.com",
+			"host":                 "mail.example.com",
+			"host_ip":              "192.0.2.7",
+			"protocol":             "esmtps",
+			"tls_cipher":           "TLS1.2:ECDHE-RSA-AES256-GCM-SHA384:256",
+			"certificate_verified": "no",
+			"authenticator":        "dovecot_plain:alice",
+			"size":                 int64(1234),
+			"header_message_id":    "abc@example.com",
+			"subject":              `Quarterly "numbers"`,
+			"recipients":           "bob@example.org carol@example.org",
+		},
+		ts: time.Date(2010, 6, 21, 15, 4, 5, 0, time.UTC),
+	},
+	{
+		line: `2010-06-21 15:04:06.250 +0200 [4321] 1OQmXw-0001Ab-Cd => bob@example.org <Bob@Example.org> R=dnslookup T=remote_smtp H=mx.example.org [198.51.100.2] C="250 2.0.0 OK id=1x" QT=1m2s DT=0.5s`,
+		expected: map[string]interface{}{
+			"pid":                4321,
+			"message_id":         "1OQmXw-0001Ab-Cd",
+			"phase":              "delivery",
+			"recipient":          "bob@example.org",
+			"original_recipient": "Bob@Example.org",
+			"router":             "dnslookup",
+			"transport":          "remote_smtp",
+			"host":               "mx.example.org",
+			"host_ip":            "198.51.100.2",
+			"result":             "250 2.0.0 OK id=1x",
+			"queue_time_s":       62.0,
+			"delivery_time_s":    0.5,
+		},
+		ts: time.Date(2010, 6, 21, 13, 4, 6, 250000000, time.UTC),
+	},
+	{
+		line: `2010-06-21 15:04:07 1OQmXw-0001Ab-Cd ** carol@example.org R=dnslookup T=remote_smtp H=mx.example.org [198.51.100.2]: SMTP error from remote mail server after RCPT TO:<carol@example.org>: 550 5.1.1 No such user`,
+		expected: map[string]interface{}{
+			"message_id": "1OQmXw-0001Ab-Cd",
+			"phase":      "failed_delivery",
+			"recipient":  "carol@example.org",
+			"router":     "dnslookup",
+			"transport":  "remote_smtp",
+			"host":       "mx.example.org",
+			"host_ip":    "198.51.100.2",
+			"result":     "SMTP error from remote mail server after RCPT TO:<carol@example.org>: 550 5.1.1 No such user",
+		},
+		ts: time.Date(2010, 6, 21, 15, 4, 7, 0, time.UTC),
+	},
+	{
+		line: `2010-06-21 15:04:08 1OQmXw-0001Ab-Cd == dave@example.net R=dnslookup T=remote_smtp defer (-44): SMTP error from remote mail server after RCPT TO:<dave@example.net>: 451 Try again`,
+		expected: map[string]interface{}{
+			"message_id": "1OQmXw-0001Ab-Cd",
+			"phase":      "deferred_delivery",
+			"recipient":  "dave@example.net",
+			"router":     "dnslookup",
+			"transport":  "remote_smtp",
+			"result":     "SMTP error from remote mail server after RCPT TO:<dave@example.net>: 451 Try again",
+		},
+		ts: time.Date(2010, 6, 21, 15, 4, 8, 0, time.UTC),
+	},
+	{
+		line: `2010-06-21 15:04:09 1OQmXw-0001Ab-Cd Completed`,
+		expected: map[string]interface{}{
+			"message_id": "1OQmXw-0001Ab-Cd",
+			"phase":      "completed",
+		},
+		ts: time.Date(2010, 6, 21, 15, 4, 9, 0, time.UTC),
+	},
+	{
+		line: `2010-06-21 15:04:10 H=(spammer) [203.0.113.9] F=<x@spam.example> rejected RCPT <bob@example.org>: relay not permitted`,
+		expected: map[string]interface{}{
+			"message": "H=(spammer) [203.0.113.9] F=<x@spam.example> rejected RCPT <bob@example.org>: relay not permitted",
+		},
+		ts: time.Date(2010, 6, 21, 15, 4, 10, 0, time.UTC),
+	},
+}
+
+func TestParseLine(t *testing.T) {
+	p := &EximLineParser{loc: time.UTC}
+	for _, tlm := range tlms {
+		resp, ts, err := p.ParseLine(tlm.line)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tlm.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(resp, tlm.expected) {
+			t.Errorf("response from ParseLine(%q)\n\t%+v\ndid not match expected\n\t%+v", tlm.line, resp, tlm.expected)
+		}
+		if !ts.Equal(tlm.ts) {
+			t.Errorf("timestamp from ParseLine(%q) was %s, expected %s", tlm.line, ts, tlm.ts)
+		}
+	}
+}
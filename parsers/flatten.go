@@ -0,0 +1,41 @@
+package parsers
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Flatten copies m into into, joining the keys of nested objects with dots.
+// Lists of strings, like a user's groups, are joined with commas; other
+// lists are encoded as JSON.
+func Flatten(prefix string, m map[string]interface{}, into map[string]interface{}) {
+	for k, v := range m {
+		key := prefix + k
+		switch v := v.(type) {
+		case map[string]interface{}:
+			Flatten(key+".", v, into)
+		case []interface{}:
+			if s, ok := joinStrings(v); ok {
+				into[key] = s
+			} else {
+				encoded, _ := json.Marshal(v)
+				into[key] = string(encoded)
+			}
+		default:
+			into[key] = v
+		}
+	}
+}
+
+// joinStrings comma-joins a list if everything in it is a string
+func joinStrings(l []interface{}) (string, bool) {
+	strs := make([]string, 0, len(l))
+	for _, v := range l {
+		s, ok := v.(string)
+		if !ok {
+			return "", false
+		}
+		strs = append(strs, s)
+	}
+	return strings.Join(strs, ","), true
+}
@@ -0,0 +1,184 @@
+// Package gcplog parses Google Cloud Logging LogEntry records, as exported to
+// Cloud Storage or Pub/Sub, one JSON entry per line.
+//
+// The envelope is unwrapped: jsonPayload fields become top level fields,
+// textPayload becomes message, and resource labels are promoted to the top
+// level alongside resource_type. Other nested objects, such as httpRequest
+// and protoPayload, are flattened into dotted field names.
+// https://cloud.google.com/logging/docs/reference/v2/rest/v2/LogEntry
+package gcplog
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	timestampKey   = "timestamp"
+	jsonPayloadKey = "jsonPayload"
+	textPayloadKey = "textPayload"
+	resourceKey    = "resource"
+	messageKey     = "message"
+	latencyKey     = "httpRequest.latency"
+)
+
+type Options struct {
+	NumParsers int `hidden:"true" description:"number of gcplog parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, error)
+}
+
+type LogEntryLineParser struct{}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	p.lineParser = &LogEntryLineParser{}
+	return nil
+}
+
+// ParseLine unwraps a LogEntry
+func (l *LogEntryLineParser) ParseLine(line string) (map[string]interface{}, error) {
+	raw := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(line), &raw); err != nil {
+		return nil, err
+	}
+	if _, ok := raw[timestampKey]; !ok {
+		if _, ok := raw["insertId"]; !ok {
+			return nil, errors.New("not a LogEntry")
+		}
+	}
+	parsed := make(map[string]interface{})
+
+	// the payload goes first, so its fields win over promoted labels
+	switch payload := raw[jsonPayloadKey].(type) {
+	case map[string]interface{}:
+		parsers.Flatten("", payload, parsed)
+	case nil:
+	default:
+		return nil, errors.New("jsonPayload is not an object")
+	}
+	delete(raw, jsonPayloadKey)
+	if text, ok := raw[textPayloadKey]; ok {
+		parsed[messageKey] = text
+		delete(raw, textPayloadKey)
+	}
+
+	if resource, ok := raw[resourceKey].(map[string]interface{}); ok {
+		delete(raw, resourceKey)
+		if t, ok := resource["type"]; ok {
+			parsed["resource_type"] = t
+		}
+		labels, _ := resource["labels"].(map[string]interface{})
+		for k, v := range labels {
+			if _, ok := parsed[k]; ok {
+				k = "resource.labels." + k
+			}
+			parsed[k] = v
+		}
+	}
+
+	flatten(raw, parsed)
+
+	// latency is a duration string like "0.123s"
+	if latency, ok := parsed[latencyKey].(string); ok {
+		if d, err := strconv.ParseFloat(strings.TrimSuffix(latency, "s"), 64); err == nil {
+			delete(parsed, latencyKey)
+			parsed[latencyKey+"_ms"] = d * 1000
+		}
+	}
+	return parsed, nil
+}
+
+// flatten flattens m into into as parsers.Flatten does, leaving fields
+// already in into alone
+func flatten(m map[string]interface{}, into map[string]interface{}) {
+	flat := make(map[string]interface{})
+	parsers.Flatten("", m, flat)
+	for k, v := range flat {
+		if _, ok := into[k]; !ok {
+			into[k] = v
+		}
+	}
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process gcplog log line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp: p.getTimestamp(parsedLine),
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending gcplog processor")
+}
+
+// getTimestamp uses the time the entry was logged
+func (p *Parser) getTimestamp(m map[string]interface{}) time.Time {
+	if s, ok := m[timestampKey].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			delete(m, timestampKey)
+			return t.UTC()
+		}
+	}
+	return p.nower.Now()
+}
@@ -0,0 +1,107 @@
+package gcplog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+const (
+	jsonEntry = `{"insertId":"1abc","jsonPayload":{"message":"request done","user":{"id":42,"roles":["admin","dev"]},"zone":"mine"},"resource":{"type":"k8s_container","labels":{"project_id":"my-project","zone":"us-central1-a","pod_name":"web-1"}},"timestamp":"2020-05-01T12:00:00.123456Z","severity":"INFO","labels":{"k8s-pod/app":"web"},"httpRequest":{"requestMethod":"GET","status":200,"latency":"0.250s"},"logName":"projects/my-project/logs/stdout"}`
+	textEntry = `{"insertId":"2def","textPayload":"plain old line","resource":{"type":"gce_instance","labels":{"instance_id":"123"}},"timestamp":"2020-05-01T12:00:01Z","severity":"ERROR"}`
+)
+
+func TestParseLine(t *testing.T) {
+	lp := LogEntryLineParser{}
+	testCases := []struct {
+		input    string
+		expected map[string]interface{}
+	}{
+		{
+			input: jsonEntry,
+			expected: map[string]interface{}{
+				"insertId":                  "1abc",
+				"message":                   "request done",
+				"user.id":                   float64(42),
+				"user.roles":                "admin,dev",
+				"zone":                      "mine",
+				"resource.labels.zone":      "us-central1-a",
+				"resource_type":             "k8s_container",
+				"project_id":                "my-project",
+				"pod_name":                  "web-1",
+				"timestamp":                 "2020-05-01T12:00:00.123456Z",
+				"severity":                  "INFO",
+				"labels.k8s-pod/app":        "web",
+				"httpRequest.requestMethod": "GET",
+				"httpRequest.status":        float64(200),
+				"httpRequest.latency_ms":    float64(250),
+				"logName":                   "projects/my-project/logs/stdout",
+			},
+		},
+		{
+			input: textEntry,
+			expected: map[string]interface{}{
+				"insertId":      "2def",
+				"message":       "plain old line",
+				"resource_type": "gce_instance",
+				"instance_id":   "123",
+				"timestamp":     "2020-05-01T12:00:01Z",
+				"severity":      "ERROR",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		resp, err := lp.ParseLine(tc.input)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tc.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(resp, tc.expected) {
+			t.Errorf("response %+v didn't match expected %+v", resp, tc.expected)
+		}
+	}
+	for _, bad := range []string{`{"foo":"bar"}`, `{"insertId":"1","jsonPayload":"str"}`, `nope`} {
+		if _, err := lp.ParseLine(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{
+		conf:       Options{NumParsers: 1},
+		lineParser: &LogEntryLineParser{},
+		nower:      &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		lines <- textEntry
+		close(lines)
+	}()
+	go p.ProcessLines(lines, send, nil)
+
+	expected := event.Event{
+		Timestamp: time.Date(2020, 5, 1, 12, 0, 1, 0, time.UTC),
+		Data: map[string]interface{}{
+			"insertId":      "2def",
+			"message":       "plain old line",
+			"resource_type": "gce_instance",
+			"instance_id":   "123",
+			"severity":      "ERROR",
+		},
+	}
+	ev := <-send
+	if !reflect.DeepEqual(ev, expected) {
+		t.Errorf("got event %+v, expected %+v", ev, expected)
+	}
+}
@@ -0,0 +1,146 @@
+// Package gelf parses Graylog Extended Log Format (GELF) messages, one JSON
+// payload per line. Pair it with --listen gelf://host:port to receive
+// chunked and compressed GELF over UDP.
+package gelf
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	timestampKey = "timestamp"
+	// additional fields are prefixed with an underscore on the wire
+	additionalFieldPrefix = "_"
+	// _id is reserved by the GELF spec and must not be sent
+	reservedIDField = "_id"
+)
+
+type Options struct {
+	KeepUnderscore bool `long:"keep_underscore" description:"Keep the leading underscore on additional field names instead of stripping it"`
+
+	NumParsers int `hidden:"true" description:"number of gelf parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, error)
+}
+
+type GELFLineParser struct {
+	keepUnderscore bool
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	p.lineParser = &GELFLineParser{keepUnderscore: p.conf.KeepUnderscore}
+	return nil
+}
+
+// ParseLine decodes a GELF payload, renaming the _-prefixed additional
+// fields so they sit alongside the standard ones
+func (g *GELFLineParser) ParseLine(line string) (map[string]interface{}, error) {
+	raw := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(line), &raw); err != nil {
+		return nil, err
+	}
+	if _, ok := raw["short_message"]; !ok {
+		return nil, errors.New("GELF message is missing short_message")
+	}
+	parsed := make(map[string]interface{}, len(raw))
+	for k, v := range raw {
+		if k == reservedIDField {
+			continue
+		}
+		if strings.HasPrefix(k, additionalFieldPrefix) && !g.keepUnderscore {
+			name := strings.TrimPrefix(k, additionalFieldPrefix)
+			if _, collides := raw[name]; !collides {
+				k = name
+			}
+		}
+		parsed[k] = v
+	}
+	return parsed, nil
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process gelf log line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp: p.getTimestamp(parsedLine),
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending gelf processor")
+}
+
+// getTimestamp converts the GELF timestamp, seconds since the epoch with
+// optional decimal places, into the event time
+func (p *Parser) getTimestamp(m map[string]interface{}) time.Time {
+	ts, ok := m[timestampKey].(float64)
+	if !ok {
+		return p.nower.Now()
+	}
+	delete(m, timestampKey)
+	secs, frac := math.Modf(ts)
+	// round to the microsecond to avoid float noise in the fraction
+	micros := int64(math.Floor(frac*1e6 + 0.5))
+	return time.Unix(int64(secs), micros*int64(time.Microsecond)).UTC()
+}
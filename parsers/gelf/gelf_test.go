@@ -0,0 +1,78 @@
+package gelf
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+func TestParseLine(t *testing.T) {
+	glp := GELFLineParser{}
+	resp, err := glp.ParseLine(`{"version":"1.1","host":"example.org","short_message":"A short message","level":1,"_user_id":9001,"_some_info":"foo","_id":"dropped","_host":"collides","timestamp":1385053862.3072}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"version":       "1.1",
+		"host":          "example.org",
+		"short_message": "A short message",
+		"level":         float64(1),
+		"user_id":       float64(9001),
+		"some_info":     "foo",
+		"_host":         "collides",
+		"timestamp":     1385053862.3072,
+	}
+	if !reflect.DeepEqual(resp, expected) {
+		t.Errorf("response %+v didn't match expected %+v", resp, expected)
+	}
+
+	keep := GELFLineParser{keepUnderscore: true}
+	resp, _ = keep.ParseLine(`{"short_message":"hi","_user_id":1}`)
+	if _, ok := resp["_user_id"]; !ok {
+		t.Errorf("expected _user_id to be kept, got %+v", resp)
+	}
+
+	for _, bad := range []string{`not json`, `{"host":"no message"}`} {
+		if _, err := glp.ParseLine(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{
+		conf:       Options{NumParsers: 1},
+		lineParser: &GELFLineParser{},
+		nower:      &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		lines <- `{"short_message":"one","timestamp":1385053862.3072}`
+		lines <- `{"short_message":"two"}`
+		close(lines)
+	}()
+	go p.ProcessLines(lines, send, nil)
+
+	ev := <-send
+	expectedTime := time.Unix(1385053862, 307200000).UTC()
+	if !ev.Timestamp.Equal(expectedTime) {
+		t.Errorf("timestamp %v didn't match expected %v", ev.Timestamp, expectedTime)
+	}
+	if _, ok := ev.Data["timestamp"]; ok {
+		t.Error("timestamp should have been removed from the event data")
+	}
+	ev = <-send
+	if !ev.Timestamp.Equal(p.nower.Now()) {
+		t.Errorf("message without a timestamp should use now, got %v", ev.Timestamp)
+	}
+}
@@ -0,0 +1,197 @@
+// Package heroku parses Heroku logs, as shown by heroku logs or sent to a
+// syslog drain by logplex.
+//
+// Every line has a source (heroku or app) and a dyno (router, web.1 and so
+// on). Router lines are logfmt and are broken out into fields; the dyno that
+// served the request is recorded as target_dyno, and the connect and service
+// times become connect_ms and service_ms. Other lines are kept as message.
+// https://devcenter.heroku.com/articles/http-routing#heroku-router-log-format
+package heroku
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kr/logfmt"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	timeKey    = "timestamp"
+	sourceKey  = "source"
+	dynoKey    = "dyno"
+	messageKey = "message"
+	routerDyno = "router"
+)
+
+var (
+	// cliLine is the format shown by heroku logs:
+	// 2012-11-30T06:45:29.123456+00:00 heroku[router]: at=info ...
+	cliLine = regexp.MustCompile(`^(\S+) (\w+)\[([^\]]+)\]: ?(.*)$`)
+	// drainLine is the syslog format sent to drains, optionally with the
+	// octet count logplex frames messages with:
+	// 83 <40>1 2012-11-30T06:45:29+00:00 host app web.3 - State changed
+	drainLine = regexp.MustCompile(`^(?:\d+ )?<\d+>1 (\S+) \S+ (\S+) (\S+) - ?(.*)$`)
+
+	// msFields are router fields with values like 5ms
+	msFields = map[string]bool{
+		"connect": true,
+		"service": true,
+	}
+	intFields = map[string]bool{
+		"status": true,
+		"bytes":  true,
+	}
+)
+
+type Options struct {
+	NumParsers int `hidden:"true" description:"number of heroku parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, error)
+}
+
+type HerokuLineParser struct{}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	p.lineParser = &HerokuLineParser{}
+	return nil
+}
+
+// ParseLine handles the prefix of either format, then the router fields or
+// the message
+func (h *HerokuLineParser) ParseLine(line string) (map[string]interface{}, error) {
+	var match []string
+	if match = cliLine.FindStringSubmatch(line); match == nil {
+		if match = drainLine.FindStringSubmatch(line); match == nil {
+			return nil, errors.New("line is not a heroku log line")
+		}
+	}
+	parsed := map[string]interface{}{
+		timeKey:   match[1],
+		sourceKey: match[2],
+		dynoKey:   match[3],
+	}
+	msg := match[4]
+	if match[3] == routerDyno {
+		if err := parseRouter(msg, parsed); err == nil {
+			return parsed, nil
+		}
+	}
+	parsed[messageKey] = msg
+	return parsed, nil
+}
+
+// parseRouter adds the logfmt fields of a router line to parsed
+func parseRouter(msg string, parsed map[string]interface{}) error {
+	fields := make(map[string]interface{})
+	f := func(key, val []byte) error {
+		k, v := string(key), string(val)
+		switch {
+		case k == dynoKey:
+			// don't clobber the dyno the line came from
+			k = "target_dyno"
+		case msFields[k]:
+			if n, err := strconv.Atoi(strings.TrimSuffix(v, "ms")); err == nil {
+				fields[k+"_ms"] = n
+				return nil
+			}
+		case intFields[k]:
+			if n, err := strconv.Atoi(v); err == nil {
+				fields[k] = n
+				return nil
+			}
+		}
+		fields[k] = v
+		return nil
+	}
+	if err := logfmt.Unmarshal([]byte(msg), logfmt.HandlerFunc(f)); err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return errors.New("no router fields")
+	}
+	for k, v := range fields {
+		parsed[k] = v
+	}
+	return nil
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process heroku log line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp: p.getTimestamp(parsedLine),
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending heroku processor")
+}
+
+func (p *Parser) getTimestamp(m map[string]interface{}) time.Time {
+	if s, ok := m[timeKey].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			delete(m, timeKey)
+			return t.UTC()
+		}
+	}
+	return p.nower.Now()
+}
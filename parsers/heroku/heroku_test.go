@@ -0,0 +1,139 @@
+package heroku
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+const routerLine = `2012-11-30T06:45:29.123456+00:00 heroku[router]: at=info method=GET path="/users?page=2" host=myapp.herokuapp.com request_id=8601b555-6a83-4c12-8269-97c8e32cdb22 fwd="204.204.204.204" dyno=web.1 connect=1ms service=18ms status=200 bytes=13 protocol=https`
+
+func TestParseLine(t *testing.T) {
+	hp := HerokuLineParser{}
+	testCases := []struct {
+		input    string
+		expected map[string]interface{}
+	}{
+		{
+			input: routerLine,
+			expected: map[string]interface{}{
+				"timestamp":   "2012-11-30T06:45:29.123456+00:00",
+				"source":      "heroku",
+				"dyno":        "router",
+				"at":          "info",
+				"method":      "GET",
+				"path":        "/users?page=2",
+				"host":        "myapp.herokuapp.com",
+				"request_id":  "8601b555-6a83-4c12-8269-97c8e32cdb22",
+				"fwd":         "204.204.204.204",
+				"target_dyno": "web.1",
+				"connect_ms":  1,
+				"service_ms":  18,
+				"status":      200,
+				"bytes":       13,
+				"protocol":    "https",
+			},
+		},
+		{
+			input: `2012-11-30T06:45:30+00:00 heroku[router]: at=error code=H12 desc="Request timeout" method=GET path="/" host=myapp.herokuapp.com dyno=web.1 connect=0ms service=30000ms status=503 bytes=0`,
+			expected: map[string]interface{}{
+				"timestamp":   "2012-11-30T06:45:30+00:00",
+				"source":      "heroku",
+				"dyno":        "router",
+				"at":          "error",
+				"code":        "H12",
+				"desc":        "Request timeout",
+				"method":      "GET",
+				"path":        "/",
+				"host":        "myapp.herokuapp.com",
+				"target_dyno": "web.1",
+				"connect_ms":  0,
+				"service_ms":  30000,
+				"status":      503,
+				"bytes":       0,
+			},
+		},
+		{
+			input: `2012-11-30T06:45:31.5+00:00 app[web.1]: Started GET "/" for 1.2.3.4`,
+			expected: map[string]interface{}{
+				"timestamp": "2012-11-30T06:45:31.5+00:00",
+				"source":    "app",
+				"dyno":      "web.1",
+				"message":   `Started GET "/" for 1.2.3.4`,
+			},
+		},
+		{
+			input: `83 <40>1 2012-11-30T06:45:32+00:00 host heroku web.3 - State changed from starting to up`,
+			expected: map[string]interface{}{
+				"timestamp": "2012-11-30T06:45:32+00:00",
+				"source":    "heroku",
+				"dyno":      "web.3",
+				"message":   "State changed from starting to up",
+			},
+		},
+		{
+			input: `<158>1 2012-11-30T06:45:33+00:00 host heroku router - at=info method=POST path="/" connect=2ms service=5ms status=201`,
+			expected: map[string]interface{}{
+				"timestamp":  "2012-11-30T06:45:33+00:00",
+				"source":     "heroku",
+				"dyno":       "router",
+				"at":         "info",
+				"method":     "POST",
+				"path":       "/",
+				"connect_ms": 2,
+				"service_ms": 5,
+				"status":     201,
+			},
+		},
+	}
+	for _, tc := range testCases {
+		resp, err := hp.ParseLine(tc.input)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tc.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(resp, tc.expected) {
+			t.Errorf("response %+v didn't match expected %+v", resp, tc.expected)
+		}
+	}
+	if _, err := hp.ParseLine("just some text"); err == nil {
+		t.Error("expected error parsing a non-heroku line")
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{
+		conf:       Options{NumParsers: 1},
+		lineParser: &HerokuLineParser{},
+		nower:      &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		lines <- `2012-11-30T06:45:31.5+00:00 app[web.1]: hello`
+		close(lines)
+	}()
+	go p.ProcessLines(lines, send, nil)
+
+	expected := event.Event{
+		Timestamp: time.Date(2012, 11, 30, 6, 45, 31, 500000000, time.UTC),
+		Data: map[string]interface{}{
+			"source":  "app",
+			"dyno":    "web.1",
+			"message": "hello",
+		},
+	}
+	ev := <-send
+	if !reflect.DeepEqual(ev, expected) {
+		t.Errorf("got event %+v, expected %+v", ev, expected)
+	}
+}
@@ -0,0 +1,222 @@
+// Package iis parses IIS logs in the W3C extended log file format.
+//
+// The logs are space separated, with the columns named by a #Fields:
+// directive. IIS writes the directive again whenever it starts a new file or
+// the logged fields change, so the columns in effect are tracked as lines
+// are read. Column names are normalized to lower case with underscores, so
+// cs(User-Agent) becomes cs_user_agent.
+//
+// Lines are sampled after the directives have been taken care of, so
+// sampling never drops a #Fields: directive. When a file is read from part
+// way through, the directives at its start are read first.
+// https://learn.microsoft.com/en-us/previous-versions/iis/6.0-sdk/ms525807(v=vs.90)
+package iis
+
+import (
+	"errors"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	fieldsDirective = "#Fields:"
+	dateKey         = "date"
+	timeKey         = "time"
+)
+
+// defaultFields are the columns IIS logs by default, used until a #Fields:
+// directive is seen, eg when picking up part way through a file
+var defaultFields = normalizeFields(strings.Fields(`date time s-ip cs-method
+	cs-uri-stem cs-uri-query s-port cs-username c-ip cs(User-Agent) cs(Referer)
+	sc-status sc-substatus sc-win32-status time-taken`))
+
+var (
+	intFields = map[string]bool{
+		"s_port":          true,
+		"sc_status":       true,
+		"sc_substatus":    true,
+		"sc_win32_status": true,
+		"sc_bytes":        true,
+		"cs_bytes":        true,
+		"time_taken":      true,
+	}
+	// plusEncodedFields have their spaces written as +
+	plusEncodedFields = map[string]bool{
+		"cs_user_agent": true,
+		"cs_cookie":     true,
+	}
+)
+
+type Options struct {
+	NumParsers int `hidden:"true" description:"number of iis parsers to spin up"`
+}
+
+type Parser struct {
+	// set SampleRate to cause the parser to drop lines after the
+	// directives before them have been read
+	SampleRate int
+
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string, fields []string) (map[string]interface{}, error)
+}
+
+type IISLineParser struct{}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	p.lineParser = &IISLineParser{}
+	return nil
+}
+
+// normalizeFields turns directive column names like cs(User-Agent) into
+// cs_user_agent
+func normalizeFields(names []string) []string {
+	replacer := strings.NewReplacer("(", "_", ")", "", "-", "_")
+	fields := make([]string, len(names))
+	for i, name := range names {
+		fields[i] = strings.ToLower(replacer.Replace(name))
+	}
+	return fields
+}
+
+// ParseLine maps the space separated values in line to the named fields
+func (i *IISLineParser) ParseLine(line string, fields []string) (map[string]interface{}, error) {
+	values := strings.Split(line, " ")
+	if len(values) != len(fields) {
+		return nil, errors.New("line doesn't have a value for each field")
+	}
+	parsed := make(map[string]interface{}, len(values))
+	for n, val := range values {
+		name := fields[n]
+		if val == "-" || val == "" {
+			continue
+		}
+		switch {
+		case intFields[name]:
+			if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+				parsed[name] = n
+				continue
+			}
+		case plusEncodedFields[name]:
+			val = strings.Replace(val, "+", " ", -1)
+		}
+		parsed[name] = val
+	}
+	return parsed, nil
+}
+
+// fieldsLine is a line along with the columns in effect when it was read
+type fieldsLine struct {
+	line   string
+	fields []string
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	// directives have to be read in order, so track them here and hand each
+	// line to the workers along with the fields it should be parsed with
+	toParse := make(chan fieldsLine)
+	go func() {
+		fields := defaultFields
+		for line := range lines {
+			logrus.WithFields(logrus.Fields{
+				"line": line,
+			}).Debug("Attempting to process iis log line")
+
+			// take care of any headers on the line
+			var prefix string
+			if prefixRegex != nil {
+				prefix, _ = prefixRegex.FindStringSubmatchMap(line)
+			}
+			content := strings.TrimPrefix(line, prefix)
+			if strings.HasPrefix(content, fieldsDirective) {
+				fields = normalizeFields(strings.Fields(strings.TrimPrefix(content, fieldsDirective)))
+				continue
+			}
+			if strings.HasPrefix(content, "#") {
+				// #Software, #Version, #Date and any other directives
+				continue
+			}
+			// if sampling is disabled or sampler says keep, pass along this line.
+			if p.SampleRate > 1 && rand.Intn(p.SampleRate) != 0 {
+				continue
+			}
+			toParse <- fieldsLine{line: line, fields: fields}
+		}
+		close(toParse)
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for fl := range toParse {
+				line := fl.line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, err := p.lineParser.ParseLine(line, fl.fields)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp:  p.getTimestamp(parsedLine),
+					SampleRate: p.SampleRate,
+					Data:       parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending iis processor")
+}
+
+// getTimestamp combines the date and time columns, which are in UTC
+func (p *Parser) getTimestamp(m map[string]interface{}) time.Time {
+	date, _ := m[dateKey].(string)
+	tod, _ := m[timeKey].(string)
+	t, err := time.Parse("2006-01-02 15:04:05", date+" "+tod)
+	if err != nil {
+		return p.nower.Now()
+	}
+	delete(m, dateKey)
+	delete(m, timeKey)
+	return t
+}
@@ -0,0 +1,153 @@
+package iis
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+func TestParseLine(t *testing.T) {
+	ilp := IISLineParser{}
+	resp, err := ilp.ParseLine("2019-12-04 21:02:31 10.0.0.1 GET /default.aspx q=1 443 - 192.0.2.1 Mozilla/5.0+(Windows+NT+10.0) - 200 0 0 15", defaultFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"date":            "2019-12-04",
+		"time":            "21:02:31",
+		"s_ip":            "10.0.0.1",
+		"cs_method":       "GET",
+		"cs_uri_stem":     "/default.aspx",
+		"cs_uri_query":    "q=1",
+		"s_port":          int64(443),
+		"c_ip":            "192.0.2.1",
+		"cs_user_agent":   "Mozilla/5.0 (Windows NT 10.0)",
+		"sc_status":       int64(200),
+		"sc_substatus":    int64(0),
+		"sc_win32_status": int64(0),
+		"time_taken":      int64(15),
+	}
+	if !reflect.DeepEqual(resp, expected) {
+		t.Errorf("response %+v didn't match expected %+v", resp, expected)
+	}
+	if _, err := ilp.ParseLine("too few values", defaultFields); err == nil {
+		t.Error("expected error parsing a line with too few values")
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{
+		conf:       Options{NumParsers: 1},
+		lineParser: &IISLineParser{},
+		nower:      &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		lines <- "#Software: Microsoft Internet Information Services 10.0"
+		lines <- "#Version: 1.0"
+		lines <- "#Date: 2019-12-04 21:00:00"
+		lines <- "#Fields: date time cs-method cs-uri-stem sc-status time-taken"
+		lines <- "2019-12-04 21:02:31 GET / 200 15"
+		// the fields change part way through the file
+		lines <- "#Fields: date time cs-method cs-uri-stem sc-status sc-bytes time-taken"
+		lines <- "2019-12-04 21:02:32 POST /login 302 512 31"
+		lines <- "no date here"
+		close(lines)
+	}()
+	go func() {
+		p.ProcessLines(lines, send, nil)
+		close(send)
+	}()
+
+	expected := []event.Event{
+		{
+			Timestamp: time.Date(2019, 12, 4, 21, 2, 31, 0, time.UTC),
+			Data: map[string]interface{}{
+				"cs_method":   "GET",
+				"cs_uri_stem": "/",
+				"sc_status":   int64(200),
+				"time_taken":  int64(15),
+			},
+		},
+		{
+			Timestamp: time.Date(2019, 12, 4, 21, 2, 32, 0, time.UTC),
+			Data: map[string]interface{}{
+				"cs_method":   "POST",
+				"cs_uri_stem": "/login",
+				"sc_status":   int64(302),
+				"sc_bytes":    int64(512),
+				"time_taken":  int64(31),
+			},
+		},
+	}
+	var events []event.Event
+	for ev := range send {
+		events = append(events, ev)
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("got events %+v, expected %+v", events, expected)
+	}
+}
+
+func TestProcessLinesSampled(t *testing.T) {
+	p := &Parser{
+		SampleRate: 10,
+		conf:       Options{NumParsers: 2},
+		lineParser: &IISLineParser{},
+		nower:      &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		lines <- "#Fields: date time cs-method cs-uri-stem sc-status time-taken"
+		for i := 0; i < 1000; i++ {
+			lines <- "2019-12-04 21:02:31 GET / 200 15"
+		}
+		// the directive is kept however many lines are dropped, so the
+		// lines after it are parsed with the new columns
+		lines <- "#Fields: date time cs-method cs-uri-stem sc-status sc-bytes time-taken"
+		for i := 0; i < 1000; i++ {
+			lines <- "2019-12-04 21:02:32 POST /login 302 512 31"
+		}
+		close(lines)
+	}()
+	go func() {
+		p.ProcessLines(lines, send, nil)
+		close(send)
+	}()
+
+	counts := map[interface{}]int{}
+	for ev := range send {
+		if ev.SampleRate != 10 {
+			t.Errorf("expected sample rate 10, got %d", ev.SampleRate)
+		}
+		switch ev.Data["cs_method"] {
+		case "GET":
+			if _, ok := ev.Data["sc_bytes"]; ok {
+				t.Errorf("unexpected GET data %+v", ev.Data)
+			}
+		case "POST":
+			if ev.Data["sc_bytes"] != int64(512) {
+				t.Errorf("unexpected POST data %+v", ev.Data)
+			}
+		default:
+			t.Errorf("unexpected data %+v", ev.Data)
+		}
+		counts[ev.Data["cs_method"]]++
+	}
+	for _, method := range []string{"GET", "POST"} {
+		if counts[method] < 30 || counts[method] > 300 {
+			t.Errorf("expected about 100 %s events to be kept, got %d", method, counts[method])
+		}
+	}
+}
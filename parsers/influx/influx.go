@@ -0,0 +1,274 @@
+// Package influx parses the InfluxDB line protocol, eg
+//
+//	cpu,host=web\ 01,region=us-west usage_user=12.5,cores=8i,up=true,model="E5\"v3" 1714578000000000000
+//
+// Each line becomes an event with its measurement, tags and fields. Integer
+// (8i) and unsigned (8u) fields keep their integer types, unsuffixed numbers
+// are floats, and the boolean and quoted string forms are decoded, along
+// with the backslash escapes each part of the line allows. Timestamps are
+// read in the precision set with --influx.precision, nanoseconds by default;
+// lines without one are given the time they were read.
+package influx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	measurementKey = "measurement"
+
+	// the characters that may be escaped in each part of a line
+	measurementEscapes = ", "
+	keyEscapes         = ",= "
+	stringEscapes      = `"\`
+)
+
+// precisions are the durations of a timestamp unit in each precision
+var precisions = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+type Options struct {
+	Precision string `long:"precision" description:"Precision of the timestamps: ns, us, ms or s" default:"ns"`
+
+	NumParsers int `hidden:"true" description:"number of influx parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, time.Time, error)
+}
+
+type InfluxLineParser struct {
+	// precision is the duration of a timestamp unit
+	precision time.Duration
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	precision, ok := precisions[p.conf.Precision]
+	if !ok {
+		return fmt.Errorf("unknown --influx.precision %q; use one of ns, us, ms or s", p.conf.Precision)
+	}
+	p.nower = &RealNower{}
+	p.lineParser = &InfluxLineParser{precision: precision}
+	return nil
+}
+
+// ParseLine parses a line of the form
+// measurement[,tag=val...] field=val[,field=val...] [timestamp]
+// The timestamp is zero if the line has none.
+func (p *InfluxLineParser) ParseLine(line string) (map[string]interface{}, time.Time, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, time.Time{}, errors.New("line is blank or a comment")
+	}
+	keyEnd := indexUnescaped(line, ' ', false)
+	if keyEnd < 0 {
+		return nil, time.Time{}, errors.New("line has no fields")
+	}
+	rest := strings.TrimLeft(line[keyEnd:], " ")
+	fieldsEnd := indexUnescaped(rest, ' ', true)
+	if fieldsEnd < 0 {
+		fieldsEnd = len(rest)
+	}
+
+	keys := splitUnescaped(line[:keyEnd], ',', false)
+	if keys[0] == "" {
+		return nil, time.Time{}, errors.New("line has no measurement")
+	}
+	parsed := map[string]interface{}{
+		measurementKey: unescape(keys[0], measurementEscapes),
+	}
+	for _, tag := range keys[1:] {
+		k, v, err := splitPair(tag, false)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		parsed[unescape(k, keyEscapes)] = unescape(v, keyEscapes)
+	}
+	for _, field := range splitUnescaped(rest[:fieldsEnd], ',', true) {
+		k, v, err := splitPair(field, true)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		val, err := parseFieldValue(v)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("field %s: %s", k, err)
+		}
+		parsed[unescape(k, keyEscapes)] = val
+	}
+
+	var ts time.Time
+	if tsStr := strings.TrimSpace(rest[fieldsEnd:]); tsStr != "" {
+		n, err := strconv.ParseInt(tsStr, 10, 64)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("invalid timestamp %q", tsStr)
+		}
+		ts = fromUnits(n, p.precision)
+	}
+	return parsed, ts, nil
+}
+
+// fromUnits returns the time n units of precision after the epoch
+func fromUnits(n int64, precision time.Duration) time.Time {
+	per := int64(time.Second / precision)
+	return time.Unix(n/per, (n%per)*int64(precision)).UTC()
+}
+
+// parseFieldValue decodes a field value: a quoted string, an integer with an
+// i or u suffix, a boolean, or otherwise a float
+func parseFieldValue(v string) (interface{}, error) {
+	if v == "" {
+		return nil, errors.New("missing value")
+	}
+	if v[0] == '"' {
+		if len(v) < 2 || v[len(v)-1] != '"' {
+			return nil, errors.New("unterminated string")
+		}
+		return unescape(v[1:len(v)-1], stringEscapes), nil
+	}
+	switch v {
+	case "t", "T", "true", "True", "TRUE":
+		return true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return false, nil
+	}
+	switch v[len(v)-1] {
+	case 'i':
+		return strconv.ParseInt(v[:len(v)-1], 10, 64)
+	case 'u':
+		return strconv.ParseUint(v[:len(v)-1], 10, 64)
+	}
+	return strconv.ParseFloat(v, 64)
+}
+
+// splitPair splits a key=value pair at its first unescaped equals sign
+func splitPair(s string, quotes bool) (string, string, error) {
+	i := indexUnescaped(s, '=', quotes)
+	if i <= 0 {
+		return "", "", fmt.Errorf("%q is not a key=value pair", s)
+	}
+	return s[:i], s[i+1:], nil
+}
+
+// indexUnescaped returns the index of the first c in s that isn't escaped
+// with a backslash or, if quotes is set, inside a quoted string, or -1
+func indexUnescaped(s string, c byte, quotes bool) int {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quotes && s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == c:
+			return i
+		}
+	}
+	return -1
+}
+
+// splitUnescaped splits s at each c that indexUnescaped would find
+func splitUnescaped(s string, c byte, quotes bool) []string {
+	var parts []string
+	for {
+		i := indexUnescaped(s, c, quotes)
+		if i < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+1:]
+	}
+}
+
+// unescape removes the backslashes in front of any of chars in s. Other
+// backslashes are left alone, as the line protocol treats them literally.
+func unescape(s string, chars string) string {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(chars, s[i+1]) >= 0 {
+			i++
+		}
+		buf.WriteByte(s[i])
+	}
+	return buf.String()
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process influx line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, timestamp, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				if timestamp.IsZero() {
+					timestamp = p.nower.Now()
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp: timestamp,
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending influx processor")
+}
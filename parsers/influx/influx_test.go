@@ -0,0 +1,108 @@
+package influx
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testLineMap struct {
+	line     string
+	expected map[string]interface{}
+	ts       time.Time
+}
+
+var tlms = []testLineMap{
+	{
+		line: `cpu,host=web01,region=us-west usage_user=12.5,cores=8i,up=true 1714578000000000000`,
+		expected: map[string]interface{}{
+			"measurement": "cpu",
+			"host":        "web01",
+			"region":      "us-west",
+			"usage_user":  12.5,
+			"cores":       int64(8),
+			"up":          true,
+		},
+		ts: time.Date(2024, 5, 1, 15, 40, 0, 0, time.UTC),
+	},
+	{
+		line: `disk\ io,path=/var\,log,mount\=point=a\ b reads=42u,model="E5 \"v3\", c:\\",ok=F 1714578000123456789`,
+		expected: map[string]interface{}{
+			"measurement": "disk io",
+			"path":        "/var,log",
+			"mount=point": "a b",
+			"reads":       uint64(42),
+			"model":       `E5 "v3", c:\`,
+			"ok":          false,
+		},
+		ts: time.Date(2024, 5, 1, 15, 40, 0, 123456789, time.UTC),
+	},
+	{
+		line: `mem free=-1.5e3,msg="a=b c"`,
+		expected: map[string]interface{}{
+			"measurement": "mem",
+			"free":        -1500.0,
+			"msg":         "a=b c",
+		},
+	},
+	{
+		line: `weather,location=C:\temp temperature=82`,
+		expected: map[string]interface{}{
+			"measurement": "weather",
+			"location":    `C:\temp`,
+			"temperature": 82.0,
+		},
+	},
+}
+
+func TestParseLine(t *testing.T) {
+	p := &InfluxLineParser{precision: time.Nanosecond}
+	for _, tlm := range tlms {
+		resp, ts, err := p.ParseLine(tlm.line)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tlm.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(resp, tlm.expected) {
+			t.Errorf("response from ParseLine(%q)\n\t%+v\ndid not match expected\n\t%+v", tlm.line, resp, tlm.expected)
+		}
+		if !ts.Equal(tlm.ts) {
+			t.Errorf("timestamp from ParseLine(%q) was %s, expected %s", tlm.line, ts, tlm.ts)
+		}
+	}
+}
+
+func TestParseLineErrors(t *testing.T) {
+	p := &InfluxLineParser{precision: time.Nanosecond}
+	for _, line := range []string{
+		"",
+		"# a comment",
+		"cpu",
+		"cpu,host value=1",
+		"cpu value=",
+		"cpu value=1x",
+		`cpu value="open`,
+		"cpu value=1i 17145780oops",
+	} {
+		if _, _, err := p.ParseLine(line); err == nil {
+			t.Errorf("expected an error parsing %q", line)
+		}
+	}
+}
+
+func TestPrecision(t *testing.T) {
+	p := &Parser{}
+	if err := p.Init(&Options{Precision: "ms"}); err != nil {
+		t.Fatal(err)
+	}
+	_, ts, err := p.lineParser.ParseLine("cpu value=1 1714578000123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := time.Date(2024, 5, 1, 15, 40, 0, 123000000, time.UTC); !ts.Equal(expected) {
+		t.Errorf("timestamp was %s, expected %s", ts, expected)
+	}
+	if err := p.Init(&Options{Precision: "h"}); err == nil {
+		t.Error("expected an error for an unknown precision")
+	}
+}
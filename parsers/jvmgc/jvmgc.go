@@ -0,0 +1,256 @@
+// Package jvmgc parses the GC logs written by the JVM's unified logging, in
+// Java 9 and later, eg with -Xlog:gc*:file=gc.log
+//
+//	[2010-06-21T15:04:05.123+0000][1.234s][info][gc] GC(5) Pause Young (Normal) (G1 Evacuation Pause) 24M->4M(256M) 12.345ms
+//
+// The decorations in brackets give the level, tags, uptime and, if they
+// were asked for, the time; lines without a time are given the time they
+// were read. Messages about a collection are split into its GC id, the
+// phase, eg Pause Young, the cause, the heap size before and after in MB,
+// and how long it took, as pause_ms for pauses and duration_ms for
+// concurrent phases. Other messages are kept as message.
+package jvmgc
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	levelKey      = "level"
+	tagsKey       = "tags"
+	uptimeKey     = "uptime_s"
+	pidKey        = "pid"
+	tidKey        = "tid"
+	messageKey    = "message"
+	gcIDKey       = "gc_id"
+	phaseKey      = "phase"
+	causeKey      = "cause"
+	pauseKey      = "pause_ms"
+	durationKey   = "duration_ms"
+	heapBeforeKey = "heap_before_mb"
+	heapAfterKey  = "heap_after_mb"
+	heapTotalKey  = "heap_total_mb"
+
+	// timeLayout is the layout of the time and utctime decorations
+	timeLayout = "2006-01-02T15:04:05.000-0700"
+)
+
+var (
+	// lineRegex matches a line: decorations in brackets, then the message
+	lineRegex = regexp.MustCompile(`^((?:\[[^\]]*\])+) ?(.*)$`)
+	// decorationRegex matches each decoration
+	decorationRegex = regexp.MustCompile(`\[([^\]]*)\]`)
+	// gcRegex matches a message about a collection: its id, the phase with
+	// any causes in parentheses, then optionally the heap sizes and how
+	// long it took, eg
+	// GC(5) Pause Young (Normal) (G1 Evacuation Pause) 24M->4M(256M) 12.345ms
+	gcRegex = regexp.MustCompile(`^GC\((\d+)\) +(.*?)(?: (\d+(?:\.\d+)?[KMG])->(\d+(?:\.\d+)?[KMG])\((\d+(?:\.\d+)?[KMG])\))?(?: ([\d.]+)ms)?$`)
+	// causeRegex matches the causes following a phase
+	causeRegex = regexp.MustCompile(`\(((?:[^()]|\(\))*)\)`)
+	// unitRegex matches the numeric decorations, eg 1.234s or 1234ms
+	unitRegex = regexp.MustCompile(`^(\d+(?:\.\d+)?)(s|ms|ns|p|t)$`)
+)
+
+// levels are the unified logging levels
+var levels = map[string]bool{
+	"trace":   true,
+	"debug":   true,
+	"info":    true,
+	"warning": true,
+	"error":   true,
+}
+
+type Options struct {
+	NumParsers int `hidden:"true" description:"number of jvmgc parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+// LineParser parses a line. The timestamp is zero if the line has no time
+// decoration.
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, time.Time, error)
+}
+
+type JVMGCLineParser struct{}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	p.lineParser = &JVMGCLineParser{}
+	return nil
+}
+
+// ParseLine parses a line's decorations and message
+func (j *JVMGCLineParser) ParseLine(line string) (map[string]interface{}, time.Time, error) {
+	m := lineRegex.FindStringSubmatch(line)
+	if m == nil {
+		return nil, time.Time{}, errors.New("line is not a unified logging line")
+	}
+	parsed := make(map[string]interface{})
+	var ts time.Time
+	for _, d := range decorationRegex.FindAllStringSubmatch(m[1], -1) {
+		val := strings.TrimSpace(d[1])
+		if levels[val] {
+			parsed[levelKey] = val
+			continue
+		}
+		if len(val) > 20 && val[4] == '-' && val[10] == 'T' {
+			t, err := time.Parse(timeLayout, val)
+			if err != nil {
+				return nil, time.Time{}, err
+			}
+			ts = t.UTC()
+			continue
+		}
+		u := unitRegex.FindStringSubmatch(val)
+		if u == nil {
+			parsed[tagsKey] = val
+			continue
+		}
+		n, _ := strconv.ParseFloat(u[1], 64)
+		switch u[2] {
+		case "s":
+			parsed[uptimeKey] = n
+		case "p":
+			parsed[pidKey] = int64(n)
+		case "t":
+			parsed[tidKey] = int64(n)
+		case "ms", "ns":
+			// milliseconds or nanoseconds since the epoch, or uptime in them
+			ns, _ := strconv.ParseInt(u[1], 10, 64)
+			if u[2] == "ms" {
+				ns *= int64(time.Millisecond)
+			}
+			// anything after 2001 is a time rather than an uptime
+			if ns > 1e9*int64(time.Second) {
+				ts = time.Unix(0, ns).UTC()
+			} else {
+				parsed[uptimeKey] = float64(ns) / float64(time.Second)
+			}
+		}
+	}
+	parseMessage(m[2], parsed)
+	return parsed, ts, nil
+}
+
+// parseMessage splits a message about a collection into its parts, or
+// keeps it as message if it isn't one
+func parseMessage(msg string, parsed map[string]interface{}) {
+	m := gcRegex.FindStringSubmatch(msg)
+	if m == nil {
+		parsed[messageKey] = msg
+		return
+	}
+	parsed[gcIDKey], _ = strconv.Atoi(m[1])
+	phase := m[2]
+	rest := strings.TrimLeft(msg[len("GC()")+len(m[1]):], " ")
+	hasSizes, hasTime := m[3] != "", m[6] != ""
+	isPause := strings.HasPrefix(phase, "Pause ")
+	// eg GC(5) Using 8 workers of 8 for evacuation, or the size of each
+	// space, GC(5) Metaspace: 1K->1K(1056768K)
+	if hasSizes && !isPause || !hasTime && !isPause && !strings.HasPrefix(phase, "Concurrent ") {
+		parsed[messageKey] = rest
+		return
+	}
+	if causes := causeRegex.FindAllStringSubmatch(phase, -1); causes != nil {
+		parsed[causeKey] = causes[len(causes)-1][1]
+		phase = phase[:strings.Index(phase, "(")]
+	}
+	parsed[phaseKey] = strings.TrimSuffix(strings.TrimSpace(phase), ":")
+	if hasSizes {
+		parsed[heapBeforeKey] = toMB(m[3])
+		parsed[heapAfterKey] = toMB(m[4])
+		parsed[heapTotalKey] = toMB(m[5])
+	}
+	if hasTime {
+		d, _ := strconv.ParseFloat(m[6], 64)
+		if isPause {
+			parsed[pauseKey] = d
+		} else {
+			parsed[durationKey] = d
+		}
+	}
+}
+
+// toMB converts a size such as 512K, 24M or 1.5G to MB
+func toMB(size string) float64 {
+	n, _ := strconv.ParseFloat(size[:len(size)-1], 64)
+	switch size[len(size)-1] {
+	case 'K':
+		return n / 1024
+	case 'G':
+		return n * 1024
+	}
+	return n
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process jvmgc log line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, timestamp, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				if timestamp.IsZero() {
+					timestamp = p.nower.Now()
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp: timestamp,
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending jvmgc processor")
+}
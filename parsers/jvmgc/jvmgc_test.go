@@ -0,0 +1,147 @@
+package jvmgc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	return time.Date(2017, 5, 1, 10, 0, 0, 0, time.UTC)
+}
+
+type testLineMap struct {
+	line     string
+	expected map[string]interface{}
+	ts       time.Time
+}
+
+var tlms = []testLineMap{
+	{
+		line: "[1.234s][info][gc] GC(5) Pause Young (Normal) (G1 Evacuation Pause) 24M->4M(256M) 12.345ms",
+		expected: map[string]interface{}{
+			"uptime_s":       1.234,
+			"level":          "info",
+			"tags":           "gc",
+			"gc_id":          5,
+			"phase":          "Pause Young",
+			"cause":          "G1 Evacuation Pause",
+			"heap_before_mb": 24.0,
+			"heap_after_mb":  4.0,
+			"heap_total_mb":  256.0,
+			"pause_ms":       12.345,
+		},
+	},
+	{
+		line: "[2010-06-21T15:04:05.123+0200][1234p][5678t][info][gc] GC(7) Pause Full (System.gc()) 512K->1.5G(2G) 40.1ms",
+		expected: map[string]interface{}{
+			"pid":            int64(1234),
+			"tid":            int64(5678),
+			"level":          "info",
+			"tags":           "gc",
+			"gc_id":          7,
+			"phase":          "Pause Full",
+			"cause":          "System.gc()",
+			"heap_before_mb": 0.5,
+			"heap_after_mb":  1536.0,
+			"heap_total_mb":  2048.0,
+			"pause_ms":       40.1,
+		},
+		ts: time.Date(2010, 6, 21, 13, 4, 5, 123000000, time.UTC),
+	},
+	{
+		line: "[1276873445123ms][2345ms][info][gc] GC(8) Concurrent Mark Cycle 85.2ms",
+		expected: map[string]interface{}{
+			"uptime_s":    2.345,
+			"level":       "info",
+			"tags":        "gc",
+			"gc_id":       8,
+			"phase":       "Concurrent Mark Cycle",
+			"duration_ms": 85.2,
+		},
+		ts: time.Date(2010, 6, 18, 15, 4, 5, 123000000, time.UTC),
+	},
+	{
+		line: "[3.001s][info][gc,phases] GC(9)   Pre Evacuate Collection Set: 0.1ms",
+		expected: map[string]interface{}{
+			"uptime_s":    3.001,
+			"level":       "info",
+			"tags":        "gc,phases",
+			"gc_id":       9,
+			"phase":       "Pre Evacuate Collection Set",
+			"duration_ms": 0.1,
+		},
+	},
+	{
+		line: "[3.002s][info][gc,metaspace] GC(9) Metaspace: 1K->1K(1056768K)",
+		expected: map[string]interface{}{
+			"uptime_s": 3.002,
+			"level":    "info",
+			"tags":     "gc,metaspace",
+			"gc_id":    9,
+			"message":  "Metaspace: 1K->1K(1056768K)",
+		},
+	},
+	{
+		line: "[0.012s][info][gc] Using G1",
+		expected: map[string]interface{}{
+			"uptime_s": 0.012,
+			"level":    "info",
+			"tags":     "gc",
+			"message":  "Using G1",
+		},
+	},
+}
+
+func TestParseLine(t *testing.T) {
+	p := &JVMGCLineParser{}
+	for _, tlm := range tlms {
+		resp, ts, err := p.ParseLine(tlm.line)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tlm.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(resp, tlm.expected) {
+			t.Errorf("response from ParseLine(%q)\n\t%+v\ndid not match expected\n\t%+v", tlm.line, resp, tlm.expected)
+		}
+		if !ts.Equal(tlm.ts) {
+			t.Errorf("timestamp from ParseLine(%q) was %s, expected %s", tlm.line, ts, tlm.ts)
+		}
+	}
+	if _, _, err := p.ParseLine("Pause Young 12ms"); err == nil {
+		t.Error("expected an error parsing a line without decorations")
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{
+		conf:       Options{NumParsers: 1},
+		lineParser: &JVMGCLineParser{},
+		nower:      &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		lines <- tlms[0].line
+		lines <- "not a gc line"
+		close(lines)
+	}()
+	go func() {
+		p.ProcessLines(lines, send, nil)
+		close(send)
+	}()
+	var evs []event.Event
+	for ev := range send {
+		evs = append(evs, ev)
+	}
+	if len(evs) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(evs))
+	}
+	if !evs[0].Timestamp.Equal((&FakeNower{}).Now()) {
+		t.Errorf("event timestamp %s should have come from the nower", evs[0].Timestamp)
+	}
+}
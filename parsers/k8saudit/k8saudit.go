@@ -0,0 +1,174 @@
+// Package k8saudit parses Kubernetes API server audit logs written by the
+// log backend, one JSON audit event per line.
+package k8saudit
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	requestReceivedKey = "requestReceivedTimestamp"
+	stageKey           = "stageTimestamp"
+	durationKey        = "duration_ms"
+	kindKey            = "kind"
+	auditKind          = "Event"
+)
+
+// bodyKeys hold the full request and response objects, which can be very
+// large and are dropped unless asked for
+var bodyKeys = []string{"requestObject", "responseObject"}
+
+type Options struct {
+	KeepBodies bool `long:"keep_bodies" description:"Keep the requestObject and responseObject fields, encoded as JSON strings. They are dropped by default because they can be very large"`
+
+	NumParsers int `hidden:"true" description:"number of k8saudit parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, error)
+}
+
+type AuditLineParser struct {
+	keepBodies bool
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	p.lineParser = &AuditLineParser{keepBodies: p.conf.KeepBodies}
+	return nil
+}
+
+// ParseLine decodes an audit event and flattens nested objects into dotted
+// field names, eg user.username and objectRef.resource
+func (a *AuditLineParser) ParseLine(line string) (map[string]interface{}, error) {
+	raw := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(line), &raw); err != nil {
+		return nil, err
+	}
+	if kind, ok := raw[kindKey]; ok && kind != auditKind {
+		return nil, errors.New("not an audit event")
+	}
+	if _, ok := raw[stageKey]; !ok {
+		return nil, errors.New("audit event has no stageTimestamp")
+	}
+	parsed := make(map[string]interface{})
+	for _, k := range bodyKeys {
+		body, ok := raw[k]
+		if !ok {
+			continue
+		}
+		delete(raw, k)
+		if a.keepBodies {
+			encoded, _ := json.Marshal(body)
+			parsed[k] = string(encoded)
+		}
+	}
+	parsers.Flatten("", raw, parsed)
+
+	if latency, ok := latency(parsed); ok {
+		parsed[durationKey] = latency
+	}
+	return parsed, nil
+}
+
+// latency computes the time, in milliseconds, between the API server
+// receiving the request and the stage this event records
+func latency(m map[string]interface{}) (float64, bool) {
+	received, err := parseTime(m[requestReceivedKey])
+	if err != nil {
+		return 0, false
+	}
+	stage, err := parseTime(m[stageKey])
+	if err != nil {
+		return 0, false
+	}
+	return float64(stage.Sub(received)) / float64(time.Millisecond), true
+}
+
+func parseTime(v interface{}) (time.Time, error) {
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, errors.New("timestamp is not a string")
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process k8s audit log line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp: p.getTimestamp(parsedLine),
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending k8saudit processor")
+}
+
+// getTimestamp uses the time the API server received the request, so all
+// the stages of a request share a timestamp
+func (p *Parser) getTimestamp(m map[string]interface{}) time.Time {
+	for _, k := range []string{requestReceivedKey, stageKey} {
+		if t, err := parseTime(m[k]); err == nil {
+			return t.UTC()
+		}
+	}
+	return p.nower.Now()
+}
@@ -0,0 +1,85 @@
+package k8saudit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+const auditLine = `{"kind":"Event","apiVersion":"audit.k8s.io/v1","level":"RequestResponse","auditID":"a1b2","stage":"ResponseComplete","requestURI":"/api/v1/namespaces/default/pods/web","verb":"get","user":{"username":"admin","groups":["system:masters","system:authenticated"]},"sourceIPs":["10.0.0.1"],"objectRef":{"resource":"pods","namespace":"default","name":"web","apiVersion":"v1"},"responseStatus":{"metadata":{},"code":200},"requestObject":{"big":"body"},"responseObject":{"kind":"Pod"},"requestReceivedTimestamp":"2024-05-01T10:00:00.000000Z","stageTimestamp":"2024-05-01T10:00:00.012500Z","annotations":{"authorization.k8s.io/decision":"allow"}}`
+
+func TestParseLine(t *testing.T) {
+	alp := AuditLineParser{}
+	resp, err := alp.ParseLine(auditLine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"kind":                     "Event",
+		"apiVersion":               "audit.k8s.io/v1",
+		"level":                    "RequestResponse",
+		"auditID":                  "a1b2",
+		"stage":                    "ResponseComplete",
+		"requestURI":               "/api/v1/namespaces/default/pods/web",
+		"verb":                     "get",
+		"user.username":            "admin",
+		"user.groups":              "system:masters,system:authenticated",
+		"sourceIPs":                "10.0.0.1",
+		"objectRef.resource":       "pods",
+		"objectRef.namespace":      "default",
+		"objectRef.name":           "web",
+		"objectRef.apiVersion":     "v1",
+		"responseStatus.code":      float64(200),
+		"requestReceivedTimestamp": "2024-05-01T10:00:00.000000Z",
+		"stageTimestamp":           "2024-05-01T10:00:00.012500Z",
+		"annotations.authorization.k8s.io/decision": "allow",
+		"duration_ms": 12.5,
+	}
+	if !reflect.DeepEqual(resp, expected) {
+		t.Errorf("response %+v didn't match expected %+v", resp, expected)
+	}
+
+	keep := AuditLineParser{keepBodies: true}
+	resp, _ = keep.ParseLine(auditLine)
+	if resp["requestObject"] != `{"big":"body"}` || resp["responseObject"] != `{"kind":"Pod"}` {
+		t.Errorf("expected bodies to be kept as JSON, got %v and %v", resp["requestObject"], resp["responseObject"])
+	}
+
+	for _, bad := range []string{`not json`, `{"kind":"Policy","stageTimestamp":"2024-05-01T10:00:00Z"}`, `{"verb":"get"}`} {
+		if _, err := alp.ParseLine(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{
+		conf:       Options{NumParsers: 1},
+		lineParser: &AuditLineParser{},
+		nower:      &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		lines <- auditLine
+		close(lines)
+	}()
+	go p.ProcessLines(lines, send, nil)
+	ev := <-send
+	expectedTime := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	if !ev.Timestamp.Equal(expectedTime) {
+		t.Errorf("timestamp %v didn't match expected %v", ev.Timestamp, expectedTime)
+	}
+	if _, ok := ev.Data["requestObject"]; ok {
+		t.Error("requestObject should have been dropped")
+	}
+}
@@ -0,0 +1,423 @@
+// Package kafka parses the logs written by a Kafka broker: its server.log
+// and the other log4j logs, including the request log, and its GC log.
+//
+// Lines in the log4j logs look like
+//
+//	[2010-06-21 15:04:05,123] INFO [KafkaServer id=1] started (kafka.server.KafkaServer)
+//
+// With kafka.request.logger at DEBUG, each completed request is logged with
+// its header and how long it spent in each stage, either as text or, since
+// Kafka 2.8, JSON. Those lines are split into the api key, client id and the
+// latency breakdown, as total_time_ms, request_queue_time_ms and so on.
+//
+// GC log lines in the JVM's unified logging format, eg
+//
+//	[2010-06-21T15:04:05.123+0000][info][gc] GC(12) Pause Young (Normal) (G1 Evacuation Pause) 100M->20M(512M) 12.345ms
+//
+// are split into the kind of pause, its length and the heap size before and
+// after.
+package kafka
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	levelKey   = "level"
+	loggerKey  = "logger"
+	contextKey = "context"
+	messageKey = "message"
+	tagsKey    = "tags"
+
+	apiKeyKey        = "api_key"
+	apiKeyIDKey      = "api_key_id"
+	apiVersionKey    = "api_version"
+	clientIDKey      = "client_id"
+	correlationIDKey = "correlation_id"
+	connectionKey    = "connection"
+	clientAddrKey    = "client_addr"
+	softwareNameKey  = "client_software_name"
+	softwareVerKey   = "client_software_version"
+	gcIDKey          = "gc_id"
+	gcPauseKey       = "gc_pause"
+	pauseKey         = "pause_ms"
+	heapBeforeKey    = "heap_before_mb"
+	heapAfterKey     = "heap_after_mb"
+	heapTotalKey     = "heap_total_mb"
+
+	// completedRequest starts the request log's messages
+	completedRequest = "Completed request:"
+
+	log4jTimeLayout   = "2006-01-02 15:04:05,000"
+	unifiedTimeLayout = "2006-01-02T15:04:05.000-0700"
+)
+
+var (
+	// log4jRegex matches a line of the log4j logs: the time, level, an
+	// optional context in brackets, the message and the logger
+	log4jRegex = regexp.MustCompile(`^\[(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2},\d{3})\] (\w+) (?:\[([^\]]*)\] )?(.*?)(?: \(([\w.$]+)\))?$`)
+	// headerRegex matches the request header in the text request log, eg
+	// RequestHeader(apiKey=FETCH, apiVersion=11, clientId=consumer-1, correlationId=42)
+	headerRegex = regexp.MustCompile(`^RequestHeader\(apiKey=(\w+), apiVersion=(\d+), clientId=([^,]*), correlationId=(-?\d+)`)
+	// timingRegex matches the connection and timings ending a text request
+	// log line, eg from connection a-b-0;totalTime:12.3,requestQueueTime:0.1,...
+	timingRegex = regexp.MustCompile(`from connection (\S+?);(\w+:.*)$`)
+	// clientInfoRegex matches the client's software in the text request log
+	clientInfoRegex = regexp.MustCompile(`^ClientInformation\(softwareName=([^,]*), softwareVersion=([^)]*)\)$`)
+	// unifiedRegex matches a line of the JVM's unified logging: decorations
+	// in brackets, then the message
+	unifiedRegex = regexp.MustCompile(`^((?:\[[^\]]*\])+) ?(.*)$`)
+	// decorationRegex matches each decoration
+	decorationRegex = regexp.MustCompile(`\[([^\]]*)\]`)
+	// pauseRegex matches a GC pause, eg
+	// GC(12) Pause Young (Normal) (G1 Evacuation Pause) 100M->20M(512M) 12.345ms
+	pauseRegex = regexp.MustCompile(`^GC\((\d+)\) (Pause .*?) (\d+)M->(\d+)M\((\d+)M\) ([\d.]+)ms$`)
+	// gcIDRegex matches the GC id starting other GC messages
+	gcIDRegex = regexp.MustCompile(`^GC\((\d+)\) (.*)$`)
+)
+
+// timingNames name the latency fields of the request log, as written in
+// the text format
+var timingNames = map[string]string{
+	"totalTime":              "total_time_ms",
+	"requestQueueTime":       "request_queue_time_ms",
+	"localTime":              "local_time_ms",
+	"remoteTime":             "remote_time_ms",
+	"throttleTime":           "throttle_time_ms",
+	"responseQueueTime":      "response_queue_time_ms",
+	"sendTime":               "send_time_ms",
+	"messageConversionsTime": "message_conversions_time_ms",
+	"temporaryMemoryBytes":   "temporary_memory_bytes",
+	"securityProtocol":       "security_protocol",
+	"principal":              "principal",
+	"listener":               "listener",
+}
+
+// apiKeys name the request types by the ids the JSON request log uses
+var apiKeys = map[int]string{
+	0:  "PRODUCE",
+	1:  "FETCH",
+	2:  "LIST_OFFSETS",
+	3:  "METADATA",
+	4:  "LEADER_AND_ISR",
+	5:  "STOP_REPLICA",
+	6:  "UPDATE_METADATA",
+	7:  "CONTROLLED_SHUTDOWN",
+	8:  "OFFSET_COMMIT",
+	9:  "OFFSET_FETCH",
+	10: "FIND_COORDINATOR",
+	11: "JOIN_GROUP",
+	12: "HEARTBEAT",
+	13: "LEAVE_GROUP",
+	14: "SYNC_GROUP",
+	15: "DESCRIBE_GROUPS",
+	16: "LIST_GROUPS",
+	17: "SASL_HANDSHAKE",
+	18: "API_VERSIONS",
+	19: "CREATE_TOPICS",
+	20: "DELETE_TOPICS",
+	21: "DELETE_RECORDS",
+	22: "INIT_PRODUCER_ID",
+	23: "OFFSET_FOR_LEADER_EPOCH",
+	24: "ADD_PARTITIONS_TO_TXN",
+	25: "ADD_OFFSETS_TO_TXN",
+	26: "END_TXN",
+	27: "WRITE_TXN_MARKERS",
+	28: "TXN_OFFSET_COMMIT",
+	32: "DESCRIBE_CONFIGS",
+	33: "ALTER_CONFIGS",
+	36: "SASL_AUTHENTICATE",
+	37: "CREATE_PARTITIONS",
+	42: "DELETE_GROUPS",
+	60: "DESCRIBE_CLUSTER",
+}
+
+type Options struct {
+	TimeZone string `long:"timezone" description:"IANA name of the time zone the log4j logs are written in, eg America/Los_Angeles. Defaults to the local time zone"`
+
+	NumParsers int `hidden:"true" description:"number of kafka parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, time.Time, error)
+}
+
+type KafkaLineParser struct {
+	loc *time.Location
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	loc := time.Local
+	if p.conf.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(p.conf.TimeZone); err != nil {
+			return err
+		}
+	}
+	p.lineParser = &KafkaLineParser{loc: loc}
+	return nil
+}
+
+// ParseLine parses a line of either a log4j log or the GC log
+func (k *KafkaLineParser) ParseLine(line string) (map[string]interface{}, time.Time, error) {
+	if m := log4jRegex.FindStringSubmatch(line); m != nil {
+		ts, err := time.ParseInLocation(log4jTimeLayout, m[1], k.loc)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		parsed := map[string]interface{}{
+			levelKey: m[2],
+		}
+		if m[3] != "" {
+			parsed[contextKey] = m[3]
+		}
+		if m[5] != "" {
+			parsed[loggerKey] = m[5]
+		}
+		msg := m[4]
+		if !strings.HasPrefix(msg, completedRequest) || !parseRequest(msg[len(completedRequest):], parsed) {
+			parsed[messageKey] = msg
+		}
+		return parsed, ts.UTC(), nil
+	}
+	if m := unifiedRegex.FindStringSubmatch(line); m != nil {
+		return parseGC(m[1], m[2])
+	}
+	return nil, time.Time{}, errors.New("line is not a kafka log line")
+}
+
+// parseRequest parses a request log message in either format into parsed,
+// returning false if it isn't one
+func parseRequest(msg string, parsed map[string]interface{}) bool {
+	if strings.HasPrefix(msg, "{") {
+		return parseJSONRequest(msg, parsed)
+	}
+	header := headerRegex.FindStringSubmatch(msg)
+	timing := timingRegex.FindStringSubmatch(msg)
+	if header == nil || timing == nil {
+		return false
+	}
+	parsed[apiKeyKey] = header[1]
+	if n, err := strconv.Atoi(header[2]); err == nil {
+		parsed[apiVersionKey] = n
+	}
+	if header[3] != "" {
+		parsed[clientIDKey] = header[3]
+	}
+	if n, err := strconv.Atoi(header[4]); err == nil {
+		parsed[correlationIDKey] = n
+	}
+	addConnection(timing[1], parsed)
+	// eg totalTime:12.3,...,principal:User:ANONYMOUS,clientInformation:ClientInformation(softwareName=x, softwareVersion=y)
+	rest := timing[2]
+	if i := strings.Index(rest, ",clientInformation:"); i >= 0 {
+		if ci := clientInfoRegex.FindStringSubmatch(rest[i+len(",clientInformation:"):]); ci != nil {
+			addSoftware(ci[1], ci[2], parsed)
+		}
+		rest = rest[:i]
+	}
+	for _, kv := range strings.Split(rest, ",") {
+		colon := strings.Index(kv, ":")
+		if colon < 0 {
+			continue
+		}
+		addTiming(kv[:colon], kv[colon+1:], parsed)
+	}
+	return true
+}
+
+// parseJSONRequest parses a request logged as JSON into parsed
+func parseJSONRequest(msg string, parsed map[string]interface{}) bool {
+	var req struct {
+		RequestHeader struct {
+			RequestAPIKey     *int   `json:"requestApiKey"`
+			RequestAPIVersion int    `json:"requestApiVersion"`
+			CorrelationID     int    `json:"correlationId"`
+			ClientID          string `json:"clientId"`
+		} `json:"requestHeader"`
+		Connection        string `json:"connection"`
+		ClientInformation struct {
+			SoftwareName    string `json:"softwareName"`
+			SoftwareVersion string `json:"softwareVersion"`
+		} `json:"clientInformation"`
+	}
+	var fields map[string]interface{}
+	if json.Unmarshal([]byte(msg), &req) != nil || json.Unmarshal([]byte(msg), &fields) != nil {
+		return false
+	}
+	if req.RequestHeader.RequestAPIKey == nil {
+		return false
+	}
+	id := *req.RequestHeader.RequestAPIKey
+	parsed[apiKeyIDKey] = id
+	if name, ok := apiKeys[id]; ok {
+		parsed[apiKeyKey] = name
+	}
+	parsed[apiVersionKey] = req.RequestHeader.RequestAPIVersion
+	parsed[correlationIDKey] = req.RequestHeader.CorrelationID
+	if req.RequestHeader.ClientID != "" {
+		parsed[clientIDKey] = req.RequestHeader.ClientID
+	}
+	addConnection(req.Connection, parsed)
+	addSoftware(req.ClientInformation.SoftwareName, req.ClientInformation.SoftwareVersion, parsed)
+	// the timings are named as in the text format, with Ms on the end
+	for k, v := range fields {
+		switch v := v.(type) {
+		case float64:
+			addTiming(strings.TrimSuffix(k, "Ms"), strconv.FormatFloat(v, 'f', -1, 64), parsed)
+		case string:
+			addTiming(k, v, parsed)
+		}
+	}
+	return true
+}
+
+// addTiming adds one of the request log's timings, or the other values
+// that are logged with them
+func addTiming(key, val string, parsed map[string]interface{}) {
+	name, ok := timingNames[key]
+	if !ok {
+		return
+	}
+	if f, err := strconv.ParseFloat(val, 64); err == nil {
+		parsed[name] = f
+		return
+	}
+	parsed[name] = val
+}
+
+// addConnection adds the connection id, made of the broker's address, the
+// client's address and an index, and the client's address from it
+func addConnection(conn string, parsed map[string]interface{}) {
+	if conn == "" {
+		return
+	}
+	parsed[connectionKey] = conn
+	parts := strings.Split(conn, "-")
+	if len(parts) == 3 {
+		parsed[clientAddrKey] = parts[1]
+	}
+}
+
+func addSoftware(name, version string, parsed map[string]interface{}) {
+	if name != "" && name != "unknown" {
+		parsed[softwareNameKey] = name
+	}
+	if version != "" && version != "unknown" {
+		parsed[softwareVerKey] = version
+	}
+}
+
+// parseGC parses a line of the GC log. The decorations include the time and
+// may include the level, uptime and tags; the time is required.
+func parseGC(decorations, msg string) (map[string]interface{}, time.Time, error) {
+	parsed := make(map[string]interface{})
+	var ts time.Time
+	for _, d := range decorationRegex.FindAllStringSubmatch(decorations, -1) {
+		val := strings.TrimSpace(d[1])
+		switch {
+		case ts.IsZero() && len(val) > 20 && val[4] == '-' && val[10] == 'T':
+			t, err := time.Parse(unifiedTimeLayout, val)
+			if err != nil {
+				return nil, time.Time{}, err
+			}
+			ts = t.UTC()
+		case val == "trace", val == "debug", val == "info", val == "warning", val == "error":
+			parsed[levelKey] = val
+		case strings.HasSuffix(val, "s") && strings.Trim(val, "0123456789.s") == "":
+			// uptime, eg 0.123s
+		default:
+			parsed[tagsKey] = val
+		}
+	}
+	if ts.IsZero() {
+		return nil, time.Time{}, errors.New("GC log line has no time decoration")
+	}
+	if m := pauseRegex.FindStringSubmatch(msg); m != nil {
+		parsed[gcIDKey], _ = strconv.Atoi(m[1])
+		parsed[gcPauseKey] = m[2]
+		parsed[heapBeforeKey], _ = strconv.Atoi(m[3])
+		parsed[heapAfterKey], _ = strconv.Atoi(m[4])
+		parsed[heapTotalKey], _ = strconv.Atoi(m[5])
+		parsed[pauseKey], _ = strconv.ParseFloat(m[6], 64)
+		return parsed, ts, nil
+	}
+	if m := gcIDRegex.FindStringSubmatch(msg); m != nil {
+		parsed[gcIDKey], _ = strconv.Atoi(m[1])
+		msg = m[2]
+	}
+	parsed[messageKey] = msg
+	return parsed, ts, nil
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process kafka log line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, timestamp, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp: timestamp,
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending kafka processor")
+}
@@ -0,0 +1,120 @@
+package kafka
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLine(t *testing.T) {
+	lp := &KafkaLineParser{loc: time.UTC}
+	ts := time.Date(2010, 6, 21, 15, 4, 5, 123000000, time.UTC)
+	testCases := []struct {
+		input    string
+		expected map[string]interface{}
+	}{
+		{
+			input: "[2010-06-21 15:04:05,123] INFO [KafkaServer id=1] started (kafka.server.KafkaServer)",
+			expected: map[string]interface{}{
+				"level":   "INFO",
+				"context": "KafkaServer id=1",
+				"message": "started",
+				"logger":  "kafka.server.KafkaServer",
+			},
+		},
+		{
+			input: "[2010-06-21 15:04:05,123] DEBUG Completed request:RequestHeader(apiKey=FETCH, apiVersion=11, clientId=consumer-1, correlationId=42) -- {replica_id=-1,max_wait_time=500},response:{throttle_time_ms=0,error_code=0} from connection 192.0.2.1:9092-192.0.2.7:51234-3;totalTime:512.5,requestQueueTime:0.1,localTime:1.2,remoteTime:510.0,throttleTime:0,responseQueueTime:0.05,sendTime:1.15,securityProtocol:PLAINTEXT,principal:User:ANONYMOUS,listener:PLAINTEXT,clientInformation:ClientInformation(softwareName=apache-kafka-java, softwareVersion=2.6.0) (kafka.request.logger)",
+			expected: map[string]interface{}{
+				"level":                   "DEBUG",
+				"logger":                  "kafka.request.logger",
+				"api_key":                 "FETCH",
+				"api_version":             11,
+				"client_id":               "consumer-1",
+				"correlation_id":          42,
+				"connection":              "192.0.2.1:9092-192.0.2.7:51234-3",
+				"client_addr":             "192.0.2.7:51234",
+				"total_time_ms":           512.5,
+				"request_queue_time_ms":   0.1,
+				"local_time_ms":           1.2,
+				"remote_time_ms":          510.0,
+				"throttle_time_ms":        0.0,
+				"response_queue_time_ms":  0.05,
+				"send_time_ms":            1.15,
+				"security_protocol":       "PLAINTEXT",
+				"principal":               "User:ANONYMOUS",
+				"listener":                "PLAINTEXT",
+				"client_software_name":    "apache-kafka-java",
+				"client_software_version": "2.6.0",
+			},
+		},
+		{
+			input: `[2010-06-21 15:04:05,123] DEBUG Completed request:{"isForwarded":false,"requestHeader":{"requestApiKey":0,"requestApiVersion":9,"correlationId":7,"clientId":"producer-1"},"request":{"acks":-1},"response":{"responses":[]},"connection":"192.0.2.1:9092-192.0.2.8:40000-0","totalTimeMs":3.25,"requestQueueTimeMs":0.05,"localTimeMs":3.0,"remoteTimeMs":0.0,"throttleTimeMs":0,"responseQueueTimeMs":0.1,"sendTimeMs":0.1,"securityProtocol":"SSL","principal":"User:CN=producer","listener":"SSL","clientInformation":{"softwareName":"librdkafka","softwareVersion":"1.9.0"}} (kafka.request.logger)`,
+			expected: map[string]interface{}{
+				"level":                   "DEBUG",
+				"logger":                  "kafka.request.logger",
+				"api_key":                 "PRODUCE",
+				"api_key_id":              0,
+				"api_version":             9,
+				"client_id":               "producer-1",
+				"correlation_id":          7,
+				"connection":              "192.0.2.1:9092-192.0.2.8:40000-0",
+				"client_addr":             "192.0.2.8:40000",
+				"total_time_ms":           3.25,
+				"request_queue_time_ms":   0.05,
+				"local_time_ms":           3.0,
+				"remote_time_ms":          0.0,
+				"throttle_time_ms":        0.0,
+				"response_queue_time_ms":  0.1,
+				"send_time_ms":            0.1,
+				"security_protocol":       "SSL",
+				"principal":               "User:CN=producer",
+				"listener":                "SSL",
+				"client_software_name":    "librdkafka",
+				"client_software_version": "1.9.0",
+			},
+		},
+		{
+			input: "[2010-06-21T15:04:05.123+0000][0.512s][info][gc] GC(12) Pause Young (Normal) (G1 Evacuation Pause) 100M->20M(512M) 12.345ms",
+			expected: map[string]interface{}{
+				"level":          "info",
+				"tags":           "gc",
+				"gc_id":          12,
+				"gc_pause":       "Pause Young (Normal) (G1 Evacuation Pause)",
+				"heap_before_mb": 100,
+				"heap_after_mb":  20,
+				"heap_total_mb":  512,
+				"pause_ms":       12.345,
+			},
+		},
+		{
+			input: "[2010-06-21T17:04:05.123+0200][gc,heap     ] GC(12) Eden regions: 25->0(30)",
+			expected: map[string]interface{}{
+				"tags":    "gc,heap",
+				"gc_id":   12,
+				"message": "Eden regions: 25->0(30)",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		res, resTs, err := lp.ParseLine(tc.input)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tc.input, err)
+			continue
+		}
+		if !resTs.Equal(ts) {
+			t.Errorf("expected timestamp %s, got %s", ts, resTs)
+		}
+		if !reflect.DeepEqual(res, tc.expected) {
+			t.Errorf("expected\n%v\ngot\n%v", tc.expected, res)
+		}
+	}
+
+	for _, bad := range []string{
+		"\tat kafka.server.KafkaApis.handle(KafkaApis.scala:123)",
+		"[0.512s][info][gc] Using G1",
+	} {
+		if _, _, err := lp.ParseLine(bad); err == nil {
+			t.Errorf("expected an error parsing %q", bad)
+		}
+	}
+}
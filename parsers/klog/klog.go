@@ -0,0 +1,167 @@
+// Package klog parses logs in the glog format used by Kubernetes components
+// and many Go services, eg
+//
+//	I0501 10:00:00.123456   12345 file.go:87] message
+package klog
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	severityKey = "severity"
+	pidKey      = "pid"
+	fileKey     = "file"
+	lineKey     = "line"
+	messageKey  = "message"
+
+	// glog timestamps have no year
+	timeLayout = "0102 15:04:05.999999"
+	// timestamps further than this into the future are taken to be from the
+	// year before, eg a December line read in January
+	maxFutureSkew = 7 * 24 * time.Hour
+)
+
+var severities = map[string]string{
+	"I": "INFO",
+	"W": "WARNING",
+	"E": "ERROR",
+	"F": "FATAL",
+}
+
+var lineRegex = regexp.MustCompile(`^([IWEF])(\d{4} \d{2}:\d{2}:\d{2}(?:\.\d+)?)\s+(\d+) ([^:\]]+):(\d+)\] ?(.*)$`)
+
+type Options struct {
+	TimeZone string `long:"timezone" description:"IANA name of the time zone the log was written in, eg America/Los_Angeles. glog writes local time; defaults to the local time zone"`
+
+	NumParsers int `hidden:"true" description:"number of klog parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, time.Time, error)
+}
+
+type KlogLineParser struct {
+	loc   *time.Location
+	nower Nower
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	loc := time.Local
+	if p.conf.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(p.conf.TimeZone); err != nil {
+			return err
+		}
+	}
+	p.lineParser = &KlogLineParser{loc: loc, nower: p.nower}
+	return nil
+}
+
+// ParseLine splits a glog line into its header fields and message
+func (k *KlogLineParser) ParseLine(line string) (map[string]interface{}, time.Time, error) {
+	match := lineRegex.FindStringSubmatch(line)
+	if match == nil {
+		return nil, time.Time{}, errors.New("line is not in glog format")
+	}
+	ts, err := k.parseTime(match[2])
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	pid, _ := strconv.Atoi(match[3])
+	lineNum, _ := strconv.Atoi(match[5])
+	return map[string]interface{}{
+		severityKey: severities[match[1]],
+		pidKey:      pid,
+		fileKey:     match[4],
+		lineKey:     lineNum,
+		messageKey:  match[6],
+	}, ts, nil
+}
+
+// parseTime fills in the year missing from glog timestamps. The current year
+// is assumed unless that puts the line well into the future, in which case
+// it must have been written last year.
+func (k *KlogLineParser) parseTime(s string) (time.Time, error) {
+	now := k.nower.Now().In(k.loc)
+	t, err := time.ParseInLocation(timeLayout, s, k.loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	ts := time.Date(now.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), k.loc)
+	if ts.Sub(now) > maxFutureSkew {
+		ts = ts.AddDate(-1, 0, 0)
+	}
+	return ts.UTC(), nil
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process klog log line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, timestamp, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp: timestamp,
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending klog processor")
+}
@@ -0,0 +1,117 @@
+package klog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct {
+	now string
+}
+
+func (f *FakeNower) Now() time.Time {
+	if f.now == "" {
+		f.now = "2010-06-21T15:04:05Z"
+	}
+	fakeTime, _ := time.Parse(time.RFC3339, f.now)
+	return fakeTime
+}
+
+func TestParseLine(t *testing.T) {
+	klp := KlogLineParser{loc: time.UTC, nower: &FakeNower{}}
+	tlms := []struct {
+		line         string
+		expected     map[string]interface{}
+		expectedTime time.Time
+	}{
+		{
+			line: "I0501 10:00:00.123456   12345 file.go:87] msg with: colons] and brackets",
+			expected: map[string]interface{}{
+				"severity": "INFO",
+				"pid":      12345,
+				"file":     "file.go",
+				"line":     87,
+				"message":  "msg with: colons] and brackets",
+			},
+			expectedTime: time.Date(2010, 5, 1, 10, 0, 0, 123456000, time.UTC),
+		},
+		{
+			line: "E0621 15:04:06.000001 7 pkg/server.go:12] failed",
+			expected: map[string]interface{}{
+				"severity": "ERROR",
+				"pid":      7,
+				"file":     "pkg/server.go",
+				"line":     12,
+				"message":  "failed",
+			},
+			expectedTime: time.Date(2010, 6, 21, 15, 4, 6, 1000, time.UTC),
+		},
+	}
+	for _, tlm := range tlms {
+		resp, ts, err := klp.ParseLine(tlm.line)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tlm.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(resp, tlm.expected) {
+			t.Errorf("response %+v didn't match expected %+v", resp, tlm.expected)
+		}
+		if !ts.Equal(tlm.expectedTime) {
+			t.Errorf("timestamp %v didn't match expected %v", ts, tlm.expectedTime)
+		}
+	}
+
+	for _, bad := range []string{"", "plain text", "X0501 10:00:00.123456 1 a.go:1] bad severity", "I1399 10:00:00.1 1 a.go:1] bad date"} {
+		if _, _, err := klp.ParseLine(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestYearBoundary(t *testing.T) {
+	loc, _ := time.LoadLocation("America/Los_Angeles")
+	klp := KlogLineParser{loc: loc, nower: &FakeNower{now: "2011-01-01T09:00:00Z"}}
+	// it's still New Year's Eve in Los Angeles
+	_, ts, _ := klp.ParseLine("W1231 23:59:59.5 1 a.go:1] late")
+	expected := time.Date(2010, 12, 31, 23, 59, 59, 500000000, loc)
+	if !ts.Equal(expected) {
+		t.Errorf("timestamp %v didn't match expected %v", ts, expected)
+	}
+	// a line from just after midnight is this year
+	_, ts, _ = klp.ParseLine("W0101 00:00:01.000000 1 a.go:1] early")
+	expected = time.Date(2011, 1, 1, 0, 0, 1, 0, loc)
+	if !ts.Equal(expected) {
+		t.Errorf("timestamp %v didn't match expected %v", ts, expected)
+	}
+	// read in January, a December line is from last year
+	klp.nower = &FakeNower{now: "2011-01-02T12:00:00Z"}
+	_, ts, _ = klp.ParseLine("I1230 12:00:00.000000 1 a.go:1] old")
+	expected = time.Date(2010, 12, 30, 12, 0, 0, 0, loc)
+	if !ts.Equal(expected) {
+		t.Errorf("timestamp %v didn't match expected %v", ts, expected)
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	nower := &FakeNower{}
+	p := &Parser{
+		conf:       Options{NumParsers: 1},
+		lineParser: &KlogLineParser{loc: time.UTC, nower: nower},
+		nower:      nower,
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		lines <- "not klog"
+		lines <- "F0601 01:02:03.000000 99 main.go:5] boom"
+		close(lines)
+	}()
+	go p.ProcessLines(lines, send, nil)
+	ev := <-send
+	if ev.Data["severity"] != "FATAL" || !ev.Timestamp.Equal(time.Date(2010, 6, 1, 1, 2, 3, 0, time.UTC)) {
+		t.Errorf("unexpected event %+v", ev)
+	}
+}
@@ -0,0 +1,262 @@
+// Package leef parses IBM QRadar Log Event Extended Format (LEEF) lines.
+//
+// Both LEEF 1.0, whose attributes are always tab separated:
+//
+//	LEEF:1.0|Vendor|Product|1.0|EventID|src=10.0.0.1	dst=10.0.0.2
+//
+// and LEEF 2.0, which declares its own attribute delimiter in an extra
+// header field, are supported:
+//
+//	LEEF:2.0|Vendor|Product|1.0|EventID|^|src=10.0.0.1^dst=10.0.0.2
+package leef
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	leefMarker = "LEEF:"
+
+	versionKey        = "leef_version"
+	vendorKey         = "vendor"
+	productKey        = "product"
+	productVersionKey = "product_version"
+	eventIDKey        = "event_id"
+
+	// attributes LEEF reserves for the event time
+	devTimeKey       = "devTime"
+	devTimeFormatKey = "devTimeFormat"
+)
+
+var headerKeys = []string{versionKey, vendorKey, productKey, productVersionKey, eventIDKey}
+
+// javaToGoLayout maps the SimpleDateFormat tokens used in devTimeFormat to
+// their Go equivalents, longest tokens first so that eg. MMM wins over MM
+var javaToGoLayout = []struct{ java, golang string }{
+	{"yyyy", "2006"},
+	{"yy", "06"},
+	{"MMMM", "January"},
+	{"MMM", "Jan"},
+	{"MM", "01"},
+	{"dd", "02"},
+	{"EEEE", "Monday"},
+	{"EEE", "Mon"},
+	{"HH", "15"},
+	{"hh", "03"},
+	{"mm", "04"},
+	{"ss", "05"},
+	{"SSS", "000"},
+	{"XXX", "Z07:00"},
+	{"a", "PM"},
+	{"z", "MST"},
+	{"Z", "-0700"},
+}
+
+type Options struct {
+	NumParsers int `hidden:"true" description:"number of leef parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, error)
+}
+
+type LEEFLineParser struct{}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	p.lineParser = &LEEFLineParser{}
+	return nil
+}
+
+// ParseLine splits a LEEF line into its header fields and attributes.
+// Anything before the LEEF: marker (usually a syslog header) is ignored.
+func (l *LEEFLineParser) ParseLine(line string) (map[string]interface{}, error) {
+	start := strings.Index(line, leefMarker)
+	if start == -1 {
+		return nil, errors.New("line does not contain a LEEF header")
+	}
+	line = line[start+len(leefMarker):]
+
+	header := strings.SplitN(line, "|", len(headerKeys)+1)
+	if len(header) != len(headerKeys)+1 {
+		return nil, errors.New("LEEF header has too few fields")
+	}
+	parsed := make(map[string]interface{})
+	for i, key := range headerKeys {
+		parsed[key] = header[i]
+	}
+	attrs := header[len(headerKeys)]
+
+	delim := "\t"
+	if strings.HasPrefix(header[0], "2") {
+		// LEEF 2.0 adds the delimiter as an extra header field
+		idx := strings.Index(attrs, "|")
+		if idx == -1 {
+			return nil, errors.New("LEEF 2.0 header is missing the delimiter field")
+		}
+		if d := parseDelimiter(attrs[:idx]); d != "" {
+			delim = d
+		}
+		attrs = attrs[idx+1:]
+	}
+
+	for _, pair := range strings.Split(attrs, delim) {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		key := strings.TrimSpace(kv[0])
+		if i, err := strconv.ParseInt(kv[1], 10, 64); err == nil {
+			parsed[key] = i
+		} else {
+			parsed[key] = kv[1]
+		}
+	}
+	return parsed, nil
+}
+
+// parseDelimiter interprets the LEEF 2.0 delimiter field, which is either a
+// single character or its hex value written as xHH or 0xHH
+func parseDelimiter(field string) string {
+	lower := strings.ToLower(field)
+	if len(field) > 1 && (strings.HasPrefix(lower, "x") || strings.HasPrefix(lower, "0x")) {
+		hex := strings.TrimPrefix(strings.TrimPrefix(lower, "0"), "x")
+		if b, err := strconv.ParseUint(hex, 16, 8); err == nil {
+			return string([]byte{byte(b)})
+		}
+	}
+	return field
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process leef log line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp: p.getTimestamp(parsedLine),
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending leef processor")
+}
+
+// getTimestamp uses devTime, interpreted with devTimeFormat if provided or
+// as epoch milliseconds otherwise.
+func (p *Parser) getTimestamp(m map[string]interface{}) time.Time {
+	val, ok := m[devTimeKey]
+	if !ok {
+		return p.nower.Now()
+	}
+	switch devTime := val.(type) {
+	case int64:
+		delete(m, devTimeKey)
+		return time.Unix(0, devTime*int64(time.Millisecond)).UTC()
+	case string:
+		if format, ok := m[devTimeFormatKey].(string); ok {
+			if ts, err := time.Parse(convertJavaLayout(format), devTime); err == nil {
+				delete(m, devTimeKey)
+				delete(m, devTimeFormatKey)
+				return ts
+			}
+		}
+	}
+	logrus.WithFields(logrus.Fields{
+		"devTime":       val,
+		"devTimeFormat": m[devTimeFormatKey],
+	}).Debug("unable to parse LEEF devTime")
+	return p.nower.Now()
+}
+
+// convertJavaLayout translates a Java SimpleDateFormat pattern into a Go
+// time layout
+func convertJavaLayout(layout string) string {
+	var out []string
+	for len(layout) > 0 {
+		if layout[0] == '\'' {
+			// quoted text is literal; '' is an escaped quote
+			end := strings.IndexByte(layout[1:], '\'')
+			if end == -1 {
+				out = append(out, layout[1:])
+				break
+			}
+			if end == 0 {
+				out = append(out, "'")
+			} else {
+				out = append(out, layout[1:end+1])
+			}
+			layout = layout[end+2:]
+			continue
+		}
+		matched := false
+		for _, tok := range javaToGoLayout {
+			if strings.HasPrefix(layout, tok.java) {
+				out = append(out, tok.golang)
+				layout = layout[len(tok.java):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			out = append(out, layout[:1])
+			layout = layout[1:]
+		}
+	}
+	return strings.Join(out, "")
+}
@@ -0,0 +1,142 @@
+package leef
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+type testLineMap struct {
+	input    string
+	expected map[string]interface{}
+}
+
+var tlms = []testLineMap{
+	{ // LEEF 1.0 with a syslog header and tab delimited attributes
+		input: "Jan 18 11:07:53 host LEEF:1.0|Microsoft|MSExchange|4.0 SP1|15345|src=192.0.2.0\tdst=172.50.123.1\tsev=5\tcat=anomaly\tmsg=this is a message",
+		expected: map[string]interface{}{
+			"leef_version":    "1.0",
+			"vendor":          "Microsoft",
+			"product":         "MSExchange",
+			"product_version": "4.0 SP1",
+			"event_id":        "15345",
+			"src":             "192.0.2.0",
+			"dst":             "172.50.123.1",
+			"sev":             int64(5),
+			"cat":             "anomaly",
+			"msg":             "this is a message",
+		},
+	},
+	{ // LEEF 2.0 with a literal delimiter
+		input: "LEEF:2.0|Lancope|StealthWatch|1.0|41|^|src=10.0.1.8^dst=10.0.0.5^sev=5^srcPort=81^dstPort=21",
+		expected: map[string]interface{}{
+			"leef_version":    "2.0",
+			"vendor":          "Lancope",
+			"product":         "StealthWatch",
+			"product_version": "1.0",
+			"event_id":        "41",
+			"src":             "10.0.1.8",
+			"dst":             "10.0.0.5",
+			"sev":             int64(5),
+			"srcPort":         int64(81),
+			"dstPort":         int64(21),
+		},
+	},
+	{ // LEEF 2.0 with a hex delimiter and values containing '='
+		input: "LEEF:2.0|Vendor|Product|2|login|x7C|usrName=bob|url=/a?b=c",
+		expected: map[string]interface{}{
+			"leef_version":    "2.0",
+			"vendor":          "Vendor",
+			"product":         "Product",
+			"product_version": "2",
+			"event_id":        "login",
+			"usrName":         "bob",
+			"url":             "/a?b=c",
+		},
+	},
+}
+
+func TestParseLine(t *testing.T) {
+	llp := LEEFLineParser{}
+	for _, tlm := range tlms {
+		resp, err := llp.ParseLine(tlm.input)
+		if err != nil {
+			t.Error("llp.ParseLine unexpectedly returned error ", err)
+		}
+		if !reflect.DeepEqual(resp, tlm.expected) {
+			t.Errorf("response %+v didn't match expected %+v", resp, tlm.expected)
+		}
+	}
+	for _, bad := range []string{
+		"CEF:0|not|leef",
+		"LEEF:1.0|too|few",
+		"LEEF:2.0|Vendor|Product|2|login",
+	} {
+		if _, err := llp.ParseLine(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestGetTimestamp(t *testing.T) {
+	p := &Parser{nower: &FakeNower{}}
+	expected := time.Date(2017, time.June, 12, 18, 21, 47, 0, time.UTC)
+	tts := []map[string]interface{}{
+		{"devTime": int64(1497291707000)},
+		{"devTime": "Jun 12 2017 18:21:47", "devTimeFormat": "MMM dd yyyy HH:mm:ss"},
+		{"devTime": "2017-06-12T18:21:47.000+00:00", "devTimeFormat": "yyyy-MM-dd'T'HH:mm:ss.SSSXXX"},
+	}
+	for _, m := range tts {
+		ts := p.getTimestamp(m)
+		if !ts.Equal(expected) {
+			t.Errorf("%v parsed as %v, expected %v", m, ts, expected)
+		}
+	}
+	if ts := p.getTimestamp(map[string]interface{}{"devTime": "whenever"}); !ts.Equal(p.nower.Now()) {
+		t.Errorf("unparseable devTime should fall back to now, got %v", ts)
+	}
+}
+
+func TestConvertJavaLayout(t *testing.T) {
+	for java, expected := range map[string]string{
+		"MMM dd yyyy HH:mm:ss":      "Jan 02 2006 15:04:05",
+		"yyyy-MM-dd HH:mm:ss.SSS Z": "2006-01-02 15:04:05.000 -0700",
+		"dd/MMMM/yy hh:mm a":        "02/January/06 03:04 PM",
+	} {
+		if actual := convertJavaLayout(java); actual != expected {
+			t.Errorf("convertJavaLayout(%q) = %q, expected %q", java, actual, expected)
+		}
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{
+		conf:       Options{NumParsers: 1},
+		lineParser: &LEEFLineParser{},
+		nower:      &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		lines <- "not leef"
+		lines <- tlms[1].input + "^devTime=1497291707000"
+		close(lines)
+	}()
+	go p.ProcessLines(lines, send, nil)
+	ev := <-send
+	if !reflect.DeepEqual(ev.Data, tlms[1].expected) {
+		t.Errorf("data %+v didn't match expected %+v", ev.Data, tlms[1].expected)
+	}
+	if ev.Timestamp.Unix() != 1497291707 {
+		t.Errorf("unexpected timestamp %v", ev.Timestamp)
+	}
+}
@@ -0,0 +1,133 @@
+package log4j
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// datePresets are the named date formats log4j and logback accept in %d{}
+var datePresets = map[string]string{
+	"DEFAULT":  "yyyy-MM-dd HH:mm:ss,SSS",
+	"ISO8601":  "yyyy-MM-dd HH:mm:ss,SSS",
+	"ABSOLUTE": "HH:mm:ss,SSS",
+	"DATE":     "dd MMM yyyy HH:mm:ss,SSS",
+}
+
+// dateFormat is a Java SimpleDateFormat converted for use in Go
+type dateFormat struct {
+	// pattern matches a formatted date
+	pattern string
+	// layout parses a matched date, once it's been normalized
+	layout string
+	// iso8601 is set for the ISO8601 preset, which log4j writes with a T
+	// between the date and time and logback writes with a space
+	iso8601 bool
+	// commaFraction is set when milliseconds follow a comma, which Go can't
+	// parse
+	commaFraction bool
+}
+
+// dateLetters map runs of SimpleDateFormat letters to a Go layout and a
+// regular expression
+var dateLetters = map[string][2]string{
+	"yyyy": {"2006", `\d{4}`},
+	"yy":   {"06", `\d{2}`},
+	"MMMM": {"January", `[A-Za-z]+`},
+	"MMM":  {"Jan", `[A-Za-z]{3}`},
+	"MM":   {"01", `\d{2}`},
+	"M":    {"1", `\d{1,2}`},
+	"dd":   {"02", `\d{2}`},
+	"d":    {"2", `\d{1,2}`},
+	"HH":   {"15", `\d{2}`},
+	"H":    {"15", `\d{1,2}`},
+	"hh":   {"03", `\d{2}`},
+	"h":    {"3", `\d{1,2}`},
+	"mm":   {"04", `\d{2}`},
+	"ss":   {"05", `\d{2}`},
+	"SSS":  {"000", `\d{3}`},
+	"a":    {"PM", `[AP]M`},
+	"EEEE": {"Monday", `[A-Za-z]+`},
+	"EEE":  {"Mon", `[A-Za-z]{3}`},
+	"Z":    {"-0700", `[-+]\d{4}`},
+	"XXX":  {"-07:00", `(?:Z|[-+]\d{2}:\d{2})`},
+	"X":    {"-07", `(?:Z|[-+]\d{2})`},
+	"z":    {"MST", `[A-Z]{3,4}`},
+}
+
+// newDateFormat converts a SimpleDateFormat, or one of the presets
+func newDateFormat(format string) (*dateFormat, error) {
+	df := &dateFormat{}
+	if format == "" {
+		format = "DEFAULT"
+	}
+	if format == "ISO8601" {
+		df.iso8601 = true
+	}
+	if preset, ok := datePresets[format]; ok {
+		format = preset
+	}
+	var layout, pattern string
+	for i := 0; i < len(format); {
+		c := format[i]
+		switch {
+		case c == '\'':
+			// quoted literal text
+			end := strings.IndexByte(format[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote in date format %q", format)
+			}
+			lit := format[i+1 : i+1+end]
+			layout += lit
+			pattern += regexp.QuoteMeta(lit)
+			i += end + 2
+		case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			j := i
+			for j < len(format) && format[j] == c {
+				j++
+			}
+			run := format[i:j]
+			conv, ok := dateLetters[run]
+			if !ok {
+				return nil, fmt.Errorf("unsupported %q in date format %q", run, format)
+			}
+			if run == "SSS" {
+				// Go only parses fractional seconds after a dot
+				switch {
+				case strings.HasSuffix(layout, "."):
+				case strings.HasSuffix(layout, ","):
+					layout = strings.TrimSuffix(layout, ",") + "."
+					df.commaFraction = true
+				default:
+					return nil, fmt.Errorf("milliseconds must follow a . or , in date format %q", format)
+				}
+			}
+			layout += conv[0]
+			pattern += conv[1]
+			i = j
+		default:
+			layout += string(c)
+			pattern += regexp.QuoteMeta(string(c))
+			i++
+		}
+	}
+	if df.iso8601 {
+		pattern = strings.Replace(pattern, ` `, `[T ]`, 1)
+	}
+	df.pattern = pattern
+	df.layout = layout
+	return df, nil
+}
+
+// normalize makes a matched date parseable with the layout
+func (df *dateFormat) normalize(s string) string {
+	if df.iso8601 {
+		s = strings.Replace(s, "T", " ", 1)
+	}
+	if df.commaFraction {
+		if i := strings.LastIndex(s, ","); i >= 0 {
+			s = s[:i] + "." + s[i+1:]
+		}
+	}
+	return s
+}
@@ -0,0 +1,330 @@
+// Package log4j parses logs written by log4j or logback using a
+// PatternLayout.
+//
+// The conversion pattern (eg "%d{ISO8601} [%thread] %-5level %logger{36} -
+// %msg%n") is turned into a regular expression, so each conversion word in
+// the pattern becomes a field, and MDC values written with %X{key} become
+// mdc.key fields. Lines that don't match the pattern, such as exception
+// stack traces, are folded into the stacktrace field of the event before
+// them; an event is sent once the next one starts, or the input ends.
+package log4j
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	timestampKey  = "timestamp"
+	messageKey    = "message"
+	mdcKey        = "mdc"
+	stacktraceKey = "stacktrace"
+	exceptionKey  = "exception"
+)
+
+// conversion is how a conversion word is matched and which field it fills
+type conversion struct {
+	field   string
+	pattern string
+	isInt   bool
+}
+
+// conversions are the conversion words that become fields, by each of their
+// names in log4j and logback
+var conversions = map[string]conversion{}
+
+func init() {
+	for _, c := range []struct {
+		names []string
+		conv  conversion
+	}{
+		{[]string{"p", "le", "level"}, conversion{field: "level", pattern: `[A-Za-z]+`}},
+		{[]string{"t", "thread"}, conversion{field: "thread", pattern: `.*?`}},
+		{[]string{"c", "lo", "logger"}, conversion{field: "logger", pattern: `\S+`}},
+		{[]string{"C", "class"}, conversion{field: "class", pattern: `\S+`}},
+		{[]string{"M", "method"}, conversion{field: "method", pattern: `\S+`}},
+		{[]string{"F", "file"}, conversion{field: "file", pattern: `\S+`}},
+		{[]string{"L", "line"}, conversion{field: "line", pattern: `\d+`, isInt: true}},
+		{[]string{"r", "relative"}, conversion{field: "relative_ms", pattern: `\d+`, isInt: true}},
+		{[]string{"pid", "processId"}, conversion{field: "pid", pattern: `\d+`, isInt: true}},
+		{[]string{"T", "tid", "threadId"}, conversion{field: "thread_id", pattern: `\d+`, isInt: true}},
+		{[]string{"m", "msg", "message"}, conversion{field: messageKey, pattern: `.*?`}},
+	} {
+		for _, name := range c.names {
+			conversions[name] = c.conv
+		}
+	}
+}
+
+// zeroWidth are conversion words that write nothing on the line itself: line
+// separators, and exceptions, which are written on the lines that follow
+var zeroWidth = map[string]bool{
+	"n": true, "ex": true, "exception": true, "throwable": true,
+	"xEx": true, "xException": true, "xThrowable": true,
+	"rEx": true, "rException": true, "rThrowable": true, "nopex": true, "nopexception": true,
+}
+
+// conversionWord matches a %% or a conversion word in a pattern, with its
+// format modifier and any {options}
+var conversionWord = regexp.MustCompile(`%%|%(-?\d*(?:\.-?\d+)?)([a-zA-Z]+)((?:\{[^}]*\})*)`)
+
+// exceptionLine matches the first line of a stack trace, which names the
+// exception
+var exceptionLine = regexp.MustCompile(`^([\w$.]+(?:Exception|Error|Throwable)[\w$]*)(?::|$)`)
+
+type Options struct {
+	Pattern string `long:"pattern" description:"The PatternLayout conversion pattern the logs were written with" default:"%d{ISO8601} [%thread] %-5level %logger{36} - %msg%n"`
+}
+
+type Parser struct {
+	// set SampleRate to cause the parser to drop events after they're
+	// assembled, before they're sent
+	SampleRate int
+
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, error)
+}
+
+// PatternLineParser parses lines written with a PatternLayout
+type PatternLineParser struct {
+	re *regexp.Regexp
+	// fields names the field each group of re fills
+	fields []conversion
+	date   *dateFormat
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	lp, err := NewPatternLineParser(p.conf.Pattern)
+	if err != nil {
+		return err
+	}
+	p.lineParser = lp
+	return nil
+}
+
+// NewPatternLineParser builds a parser for lines written with pattern
+func NewPatternLineParser(pattern string) (*PatternLineParser, error) {
+	lp := &PatternLineParser{}
+	matches := conversionWord.FindAllStringSubmatchIndex(pattern, -1)
+	expr := "^"
+	last := 0
+	seen := make(map[string]bool)
+	for _, m := range matches {
+		expr += regexp.QuoteMeta(pattern[last:m[0]])
+		last = m[1]
+		if m[2] < 0 {
+			expr += "%"
+			continue
+		}
+		modifier, word := pattern[m[2]:m[3]], pattern[m[4]:m[5]]
+		var option string
+		if m[6] < m[7] {
+			// only the first {option} matters; a second on %d is a time zone
+			option = pattern[m[6]+1 : m[6]+strings.IndexByte(pattern[m[6]:], '}')]
+		}
+
+		var conv conversion
+		switch {
+		case zeroWidth[word]:
+			continue
+		case word == "d" || word == "date":
+			if lp.date != nil {
+				expr += `.*?`
+				continue
+			}
+			df, err := newDateFormat(option)
+			if err != nil {
+				return nil, err
+			}
+			lp.date = df
+			conv = conversion{field: timestampKey, pattern: df.pattern}
+		case word == "X" || word == "mdc" || word == "MDC":
+			conv = conversion{field: mdcKey, pattern: `.*?`}
+			if option != "" {
+				conv.field = mdcKey + "." + option
+			}
+		default:
+			var ok bool
+			conv, ok = conversions[word]
+			if !ok {
+				// a conversion word we don't capture, eg %highlight
+				expr += `.*?`
+				continue
+			}
+		}
+		if seen[conv.field] {
+			// the first occurrence is enough to capture it
+			expr += `.*?`
+			continue
+		}
+		seen[conv.field] = true
+		p := conv.pattern
+		// fields with a minimum width are padded with spaces
+		if strings.IndexAny(modifier, "0123456789") >= 0 {
+			p = ` *` + p + ` *`
+		}
+		expr += "(" + p + ")"
+		lp.fields = append(lp.fields, conv)
+	}
+	expr += regexp.QuoteMeta(pattern[last:]) + "$"
+	if len(lp.fields) == 0 {
+		return nil, fmt.Errorf("pattern %q has no conversion words in it", pattern)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("pattern %q: %s", pattern, err)
+	}
+	lp.re = re
+	return lp, nil
+}
+
+// ParseLine returns the fields in a line, or an error if the line doesn't
+// match the pattern
+func (lp *PatternLineParser) ParseLine(line string) (map[string]interface{}, error) {
+	m := lp.re.FindStringSubmatch(line)
+	if m == nil {
+		return nil, errors.New("line doesn't match the pattern")
+	}
+	parsed := make(map[string]interface{})
+	for i, conv := range lp.fields {
+		val := m[i+1]
+		if conv.field != messageKey {
+			// leading space in a message is deliberate
+			val = strings.TrimSpace(val)
+		}
+		switch {
+		case conv.isInt:
+			if n, err := strconv.Atoi(val); err == nil {
+				parsed[conv.field] = n
+				continue
+			}
+		case conv.field == mdcKey:
+			// the whole MDC is written as k1=v1, k2=v2
+			if mdc, ok := splitMDC(val); ok {
+				for k, v := range mdc {
+					parsed[mdcKey+"."+k] = v
+				}
+				continue
+			}
+		}
+		parsed[conv.field] = val
+	}
+	return parsed, nil
+}
+
+// splitMDC splits the k1=v1, k2=v2 written for the whole MDC
+func splitMDC(s string) (map[string]string, bool) {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "{"), "}")
+	if s == "" {
+		return nil, false
+	}
+	mdc := make(map[string]string)
+	for _, kv := range strings.Split(s, ", ") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, false
+		}
+		mdc[parts[0]] = parts[1]
+	}
+	return mdc, true
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	var record map[string]interface{}
+	var continuation []string
+	for line := range lines {
+		logrus.WithFields(logrus.Fields{
+			"line": line,
+		}).Debug("Attempting to process log4j log line")
+
+		// the prefix can only be stripped; it's not possible to attribute the
+		// fields of each line's prefix to the event.
+		if prefixRegex != nil {
+			line = strings.TrimPrefix(line, prefixRegex.FindString(line))
+		}
+		parsed, err := p.lineParser.ParseLine(line)
+		if err != nil {
+			if record == nil {
+				logrus.WithField("line", line).Debug("skipping line; doesn't match the pattern and there's no event to add it to")
+			} else {
+				continuation = append(continuation, line)
+			}
+			continue
+		}
+		if record != nil {
+			p.send(record, continuation, send)
+		}
+		record, continuation = parsed, nil
+	}
+	if record != nil {
+		p.send(record, continuation, send)
+	}
+	logrus.Debug("lines channel is closed, ending log4j processor")
+}
+
+// send folds any continuation lines into the record's stacktrace and sends it
+func (p *Parser) send(record map[string]interface{}, continuation []string, send chan<- event.Event) {
+	// if sampling is disabled or sampler says keep, pass along this record.
+	if p.SampleRate > 1 && rand.Intn(p.SampleRate) != 0 {
+		return
+	}
+	if len(continuation) != 0 {
+		record[stacktraceKey] = strings.Join(continuation, "\n")
+		if m := exceptionLine.FindStringSubmatch(strings.TrimSpace(continuation[0])); m != nil {
+			record[exceptionKey] = m[1]
+		}
+	}
+	send <- event.Event{
+		Timestamp:  p.getTimestamp(record),
+		SampleRate: p.SampleRate,
+		Data:       record,
+	}
+}
+
+// getTimestamp parses the %d field, which is in local time unless the
+// pattern gave a zone. Formats without a date, like ABSOLUTE, are taken to
+// be today.
+func (p *Parser) getTimestamp(m map[string]interface{}) time.Time {
+	s, ok := m[timestampKey].(string)
+	lp, isPattern := p.lineParser.(*PatternLineParser)
+	if !ok || !isPattern || lp.date == nil {
+		return p.nower.Now()
+	}
+	t, err := time.ParseInLocation(lp.date.layout, lp.date.normalize(s), time.Local)
+	if err != nil {
+		return p.nower.Now()
+	}
+	if t.Year() == 0 {
+		now := p.nower.Now().In(time.Local)
+		t = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+	}
+	delete(m, timestampKey)
+	return t.UTC()
+}
@@ -0,0 +1,177 @@
+package log4j
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+func TestParseLine(t *testing.T) {
+	testCases := []struct {
+		pattern  string
+		input    string
+		expected map[string]interface{}
+	}{
+		{
+			pattern: "%d{ISO8601} [%thread] %-5level %logger{36} - %msg%n",
+			input:   "2019-01-07 12:00:00,123 [http-nio-8080-exec-1] INFO  c.e.app.UserController - user logged in: alice",
+			expected: map[string]interface{}{
+				"timestamp": "2019-01-07 12:00:00,123",
+				"thread":    "http-nio-8080-exec-1",
+				"level":     "INFO",
+				"logger":    "c.e.app.UserController",
+				"message":   "user logged in: alice",
+			},
+		},
+		{
+			// MDC keys, a logback date format and a line number
+			pattern: "%date{yyyy-MM-dd'T'HH:mm:ss.SSSXXX} %5p [%X{requestId}] %C{1}.%M:%L %m%n",
+			input:   "2019-01-07T12:00:00.123+01:00  WARN [abc-123] Cache.evict:42 cache is 100% full",
+			expected: map[string]interface{}{
+				"timestamp":     "2019-01-07T12:00:00.123+01:00",
+				"level":         "WARN",
+				"mdc.requestId": "abc-123",
+				"class":         "Cache",
+				"method":        "evict",
+				"line":          42,
+				"message":       "cache is 100% full",
+			},
+		},
+		{
+			// the whole MDC, a literal percent and an uncaptured conversion
+			pattern: "%r %%%highlight{%p} {%X} %c - %m%ex%n",
+			input:   "1500 %ERROR {user=bob, tenant=7} root - failed",
+			expected: map[string]interface{}{
+				"relative_ms": 1500,
+				"mdc.user":    "bob",
+				"mdc.tenant":  "7",
+				"logger":      "root",
+				"message":     "failed",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		lp, err := NewPatternLineParser(tc.pattern)
+		if err != nil {
+			t.Errorf("unexpected error building a parser for %q: %s", tc.pattern, err)
+			continue
+		}
+		resp, err := lp.ParseLine(tc.input)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q with %q: %s", tc.input, tc.pattern, err)
+			continue
+		}
+		if !reflect.DeepEqual(resp, tc.expected) {
+			t.Errorf("response %+v didn't match expected %+v", resp, tc.expected)
+		}
+	}
+	if _, err := NewPatternLineParser("no conversions here%n"); err == nil {
+		t.Error("expected error for a pattern with no conversion words")
+	}
+	if _, err := NewPatternLineParser("%d{yyyy QQ} %m"); err == nil {
+		t.Error("expected error for an unsupported date format")
+	}
+	lp, _ := NewPatternLineParser("%d{ISO8601} %p %m")
+	if _, err := lp.ParseLine("not a log line"); err == nil {
+		t.Error("expected error for a line that doesn't match")
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	lp, _ := NewPatternLineParser("%d{ISO8601} [%thread] %-5level %logger{36} - %msg%n")
+	p := &Parser{
+		lineParser: lp,
+		nower:      &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		lines <- "stray line before the first event"
+		lines <- "2019-01-07T12:00:00,123 [main] ERROR com.example.App - request failed"
+		lines <- "java.lang.IllegalStateException: bad state"
+		lines <- "\tat com.example.App.run(App.java:10)"
+		lines <- "Caused by: java.io.IOException: closed"
+		lines <- "2019-01-07 12:00:01,000 [main] INFO  com.example.App - all better"
+		close(lines)
+	}()
+	go p.ProcessLines(lines, send, nil)
+
+	expected := []event.Event{
+		{
+			Timestamp: time.Date(2019, 1, 7, 12, 0, 0, 123000000, time.Local).UTC(),
+			Data: map[string]interface{}{
+				"thread":     "main",
+				"level":      "ERROR",
+				"logger":     "com.example.App",
+				"message":    "request failed",
+				"stacktrace": "java.lang.IllegalStateException: bad state\n\tat com.example.App.run(App.java:10)\nCaused by: java.io.IOException: closed",
+				"exception":  "java.lang.IllegalStateException",
+			},
+		},
+		{
+			Timestamp: time.Date(2019, 1, 7, 12, 0, 1, 0, time.Local).UTC(),
+			Data: map[string]interface{}{
+				"thread":  "main",
+				"level":   "INFO",
+				"logger":  "com.example.App",
+				"message": "all better",
+			},
+		},
+	}
+	for _, exp := range expected {
+		ev := <-send
+		if !reflect.DeepEqual(ev, exp) {
+			t.Errorf("got event %+v, expected %+v", ev, exp)
+		}
+	}
+}
+
+func TestGetTimestamp(t *testing.T) {
+	testCases := []struct {
+		pattern  string
+		input    string
+		expected time.Time
+	}{
+		{
+			pattern:  "%d{yyyy-MM-dd'T'HH:mm:ss.SSSXXX} %m",
+			input:    "2019-01-07T12:00:00.123+01:00 hi",
+			expected: time.Date(2019, 1, 7, 11, 0, 0, 123000000, time.UTC),
+		},
+		{
+			pattern:  "%d{DATE} %m",
+			input:    "07 Jan 2019 12:00:00,500 hi",
+			expected: time.Date(2019, 1, 7, 12, 0, 0, 500000000, time.Local).UTC(),
+		},
+		{
+			pattern: "%d{ABSOLUTE} %m",
+			input:   "12:00:00,000 hi",
+			expected: func() time.Time {
+				now := (&FakeNower{}).Now().In(time.Local)
+				return time.Date(now.Year(), now.Month(), now.Day(), 12, 0, 0, 0, time.Local).UTC()
+			}(),
+		},
+	}
+	for _, tc := range testCases {
+		lp, err := NewPatternLineParser(tc.pattern)
+		if err != nil {
+			t.Fatalf("unexpected error building a parser for %q: %s", tc.pattern, err)
+		}
+		p := &Parser{lineParser: lp, nower: &FakeNower{}}
+		parsed, err := lp.ParseLine(tc.input)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %s", tc.input, err)
+		}
+		if ts := p.getTimestamp(parsed); !ts.Equal(tc.expected) {
+			t.Errorf("got timestamp %s for %q, expected %s", ts, tc.input, tc.expected)
+		}
+	}
+}
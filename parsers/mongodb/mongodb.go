@@ -1,4 +1,5 @@
-// Package mongodb is a parser for mongodb logs
+// Package mongodb is a parser for mongodb logs, in either the text format
+// or the structured JSON format MongoDB 4.4 and later write
 package mongodb
 
 import (
@@ -21,6 +22,8 @@ const (
 	ctimeTimeFormat        = "Mon Jan _2 15:04:05.000"
 	iso8601UTCTimeFormat   = "2006-01-02T15:04:05.000Z"
 	iso8601LocalTimeFormat = "2006-01-02T15:04:05.000-0700"
+	// structured logs put a colon in the offset
+	iso8601ColonTimeFormat = "2006-01-02T15:04:05.000-07:00"
 
 	timestampFieldName   = "timestamp"
 	namespaceFieldName   = "namespace"
@@ -41,6 +44,7 @@ const (
 
 var timestampFormats = []string{
 	iso8601LocalTimeFormat,
+	iso8601ColonTimeFormat,
 	iso8601UTCTimeFormat,
 	ctimeTimeFormat,
 	ctimeNoMSTimeFormat,
@@ -69,6 +73,9 @@ type MongoLineParser struct {
 }
 
 func (m *MongoLineParser) ParseLogLine(line string) (map[string]interface{}, error) {
+	if isStructured(line) {
+		return parseStructuredLine(line)
+	}
 	return logparser.ParseLogLine(line)
 }
 
@@ -469,3 +469,51 @@ func (f *FakeNower) Now() time.Time {
 	fakeTime, _ := time.Parse(iso8601UTCTimeFormat, "2010-10-02T12:34:56.000Z")
 	return fakeTime
 }
+
+func TestProcessStructuredLines(t *testing.T) {
+	line := `{"t":{"$date":"2020-05-20T19:18:40.604+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn281","msg":"Slow query","attr":{"type":"command","ns":"stocks.trades","appName":"MongoDB Shell","command":{"find":"trades","filter":{"ticker":"MDB"},"$db":"stocks"},"planSummary":"COLLSCAN","keysExamined":0,"docsExamined":2000,"nreturned":10,"reslen":1124,"locks":{"Global":{"acquireCount":{"r":1}}},"storage":{"data":{"bytesRead":4096}},"protocol":"op_msg","durationMillis":112}}`
+	expected := map[string]interface{}{
+		"severity":               "informational",
+		"component":              "COMMAND",
+		"context":                "conn281",
+		"message":                "Slow query",
+		"log_id":                 float64(51803),
+		"operation":              "command",
+		"namespace":              "stocks.trades",
+		"database":               "stocks",
+		"collection":             "trades",
+		"appName":                "MongoDB Shell",
+		"command_type":           "find",
+		"command":                map[string]interface{}{"find": "trades", "filter": map[string]interface{}{"ticker": "MDB"}, "$db": "stocks"},
+		"query":                  map[string]interface{}{"ticker": "MDB"},
+		"normalized_query":       `{ "ticker": 1 }`,
+		"planSummary":            "COLLSCAN",
+		"keysExamined":           float64(0),
+		"docsExamined":           float64(2000),
+		"nreturned":              float64(10),
+		"reslen":                 float64(1124),
+		"global_read_lock":       float64(1),
+		"storage.data.bytesRead": float64(4096),
+		"protocol":               "op_msg",
+		"duration_ms":            float64(112),
+	}
+	m := &Parser{
+		conf:        Options{NumParsers: 1},
+		nower:       &FakeNower{},
+		lineParsers: []LineParser{&MongoLineParser{}},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		lines <- line
+		close(lines)
+	}()
+	go m.ProcessLines(lines, send, nil)
+	ev := <-send
+	if want := time.Date(2020, 5, 20, 19, 18, 40, 604000000, time.UTC); !ev.Timestamp.Equal(want) {
+		t.Errorf("expected timestamp %s, got %s", want, ev.Timestamp)
+	}
+	if !reflect.DeepEqual(ev.Data, expected) {
+		t.Errorf("expected\n%v\ngot\n%v", expected, ev.Data)
+	}
+}
@@ -0,0 +1,295 @@
+// Package multiline groups the lines of multi-line log entries, such as
+// stack traces, into a single event, optionally handing the first line of
+// each entry to another parser.
+//
+// An entry starts with a line matching the start pattern, if one is given,
+// and continues until the next one. Without a start pattern, continuation
+// lines are recognized by their shape: indented lines, Java's "Caused by:"
+// and "... 12 more", exception names on their own line, and Python
+// tracebacks through to the exception that ends them. An entry is sent when
+// the next one starts, when it reaches the line limit, or when no lines have
+// arrived for the flush timeout, so the last entry in a quiet log isn't held
+// forever.
+package multiline
+
+import (
+	"errors"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	messageKey    = "message"
+	stacktraceKey = "stacktrace"
+	truncatedKey  = "multiline_truncated"
+	// idKey carries the entry's id through the inner parser so its
+	// continuation lines can be added to the event. It is removed before
+	// sending.
+	idKey = "multiline_id"
+
+	tracebackHeader = "Traceback (most recent call last):"
+)
+
+// headerRegex matches the header we put in front of each line handed to the
+// inner parser
+const headerRegex = `\[(?P<` + idKey + `>\d+)\] `
+
+var (
+	// exceptionLine matches a line naming a Java exception, which starts a
+	// stack trace
+	exceptionLine = regexp.MustCompile(`^(?:Exception in thread "[^"]*" )?[\w$.]+(?:Exception|Error|Throwable)[\w$]*(?::|$)`)
+	// moreLine matches the line Java uses to elide frames shared with the
+	// enclosing trace
+	moreLine = regexp.MustCompile(`^\.\.\. \d+ more$`)
+	// chainedTraceback are the lines Python puts between chained tracebacks
+	chainedTraceback = []string{
+		"During handling of the above exception, another exception occurred:",
+		"The above exception was the direct cause of the following exception:",
+	}
+)
+
+type Options struct {
+	InnerParser    string `long:"inner_parser" description:"Parser to apply to the first line of each entry, for example json, keyval or nginx. Options for the inner parser are set with its own flags. The rest of the entry is added as the stacktrace field. If unset, the whole entry is sent as the message field"`
+	Start          string `long:"start" description:"Regular expression matching the first line of each entry. If unset, continuation lines are recognized by their shape: indented lines, Java exceptions and Python tracebacks"`
+	MaxLines       int    `long:"max_lines" description:"Maximum number of lines in an entry. Lines past the limit are dropped, and multiline_truncated is set" default:"500"`
+	FlushTimeoutMs uint   `long:"flush_timeout_ms" description:"Send an entry once no lines have arrived for this long, rather than waiting for the next entry to start" default:"1000"`
+
+	NumParsers int `hidden:"true" description:"number of parsers the inner parser spins up"`
+}
+
+type Parser struct {
+	// Inner, if set, parses the first line of each entry. InnerOptions are
+	// passed to its Init.
+	Inner        parsers.Parser
+	InnerOptions interface{}
+	// set SampleRate to cause the parser to drop entries after they're
+	// assembled, before they're sent
+	SampleRate int
+
+	conf  Options
+	start *regexp.Regexp
+	nower Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+// entry is a multi-line log entry being assembled
+type entry struct {
+	prefix       string
+	prefixFields map[string]string
+	first        string
+	rest         []string
+	truncated    bool
+	// inTraceback is set between a Python traceback's header and the
+	// exception that ends it
+	inTraceback bool
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	if p.conf.Start != "" {
+		start, err := regexp.Compile(p.conf.Start)
+		if err != nil {
+			return err
+		}
+		p.start = start
+	}
+	if p.conf.MaxLines < 1 {
+		return errors.New("max_lines must be at least 1")
+	}
+	if p.conf.NumParsers < 1 {
+		p.conf.NumParsers = 1
+	}
+	if p.Inner != nil {
+		return p.Inner.Init(p.InnerOptions)
+	}
+	return nil
+}
+
+// continues returns true if line continues e rather than starting a new entry
+func (p *Parser) continues(e *entry, line string) bool {
+	if p.start != nil {
+		return !p.start.MatchString(line)
+	}
+	if e.inTraceback {
+		// the traceback ends with the exception, the first unindented line
+		if line != "" && line[0] != ' ' && line[0] != '\t' {
+			e.inTraceback = false
+		}
+		return true
+	}
+	switch {
+	case line == "", line[0] == ' ', line[0] == '\t':
+		return true
+	case line == tracebackHeader:
+		e.inTraceback = true
+		return true
+	case strings.HasPrefix(line, "Caused by: "), moreLine.MatchString(line), exceptionLine.MatchString(line):
+		return true
+	}
+	for _, l := range chainedTraceback {
+		if line == l {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	if p.Inner == nil {
+		p.processEntries(lines, prefixRegex, func(e *entry) {
+			data := map[string]interface{}{
+				messageKey: strings.Join(append([]string{e.first}, e.rest...), "\n"),
+			}
+			if e.truncated {
+				data[truncatedKey] = true
+			}
+			for k, v := range e.prefixFields {
+				data[k] = v
+			}
+			send <- event.Event{
+				Timestamp:  p.nower.Now(),
+				SampleRate: p.SampleRate,
+				Data:       data,
+			}
+		})
+		logrus.Debug("lines channel is closed, ending multiline processor")
+		return
+	}
+
+	// the inner parser gets the first line of the entry with the entry's id
+	// in a header that our prefix regex pulls back out, after any prefix the
+	// user asked for
+	innerRegex := `^` + headerRegex
+	if prefixRegex != nil {
+		innerRegex = `^(?:` + strings.TrimPrefix(prefixRegex.String(), "^") + `)` + headerRegex
+	}
+	innerPrefix := &parsers.ExtRegexp{regexp.MustCompile(innerRegex)}
+
+	// pending holds the entries handed to the inner parser, by id
+	pending := make(map[int]*entry)
+	var lock sync.Mutex
+	innerLines := make(chan string)
+	innerEvents := make(chan event.Event)
+	go func() {
+		var id int
+		p.processEntries(lines, prefixRegex, func(e *entry) {
+			id++
+			lock.Lock()
+			pending[id] = e
+			// the inner parser holds at most one line per parser, so an
+			// entry this far behind was dropped because it didn't parse.
+			// The margin covers events on their way back to us.
+			delete(pending, id-2*p.conf.NumParsers-1)
+			lock.Unlock()
+			innerLines <- e.prefix + "[" + strconv.Itoa(id) + "] " + e.first
+		})
+		close(innerLines)
+	}()
+	go func() {
+		p.Inner.ProcessLines(innerLines, innerEvents, innerPrefix)
+		close(innerEvents)
+	}()
+	for ev := range innerEvents {
+		if s, ok := ev.Data[idKey].(string); ok {
+			id, _ := strconv.Atoi(s)
+			lock.Lock()
+			e := pending[id]
+			delete(pending, id)
+			lock.Unlock()
+			if e != nil && len(e.rest) != 0 {
+				ev.Data[stacktraceKey] = strings.Join(e.rest, "\n")
+			}
+			if e != nil && e.truncated {
+				ev.Data[truncatedKey] = true
+			}
+			delete(ev.Data, idKey)
+		}
+		ev.SampleRate = p.SampleRate
+		send <- ev
+	}
+	logrus.Debug("lines channel is closed, ending multiline processor")
+}
+
+// processEntries groups lines into entries and calls handle with each one
+// once it's complete, along with the prefix matched by prefixRegex on its
+// first line and its fields. The prefix is stripped from continuation lines.
+func (p *Parser) processEntries(lines <-chan string, prefixRegex *parsers.ExtRegexp, handle func(e *entry)) {
+	var current *entry
+	flush := func() {
+		if current == nil {
+			return
+		}
+		// if sampling is disabled or sampler says keep, pass along this entry.
+		if p.SampleRate <= 1 || rand.Intn(p.SampleRate) == 0 {
+			handle(current)
+		}
+		current = nil
+	}
+	timeout := time.Duration(p.conf.FlushTimeoutMs) * time.Millisecond
+	timer := time.NewTimer(timeout)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				flush()
+				timer.Stop()
+				return
+			}
+			logrus.WithFields(logrus.Fields{
+				"line": line,
+			}).Debug("Attempting to process multiline log line")
+
+			// take care of any headers on the line
+			var prefix string
+			var prefixFields map[string]string
+			if prefixRegex != nil {
+				prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+				line = strings.TrimPrefix(line, prefix)
+			}
+			if current != nil && p.continues(current, line) {
+				if len(current.rest)+1 < p.conf.MaxLines {
+					current.rest = append(current.rest, line)
+				} else {
+					current.truncated = true
+				}
+			} else {
+				flush()
+				current = &entry{
+					prefix:       prefix,
+					prefixFields: prefixFields,
+					first:        line,
+					inTraceback:  p.start == nil && line == tracebackHeader,
+				}
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(timeout)
+		case <-timer.C:
+			flush()
+			timer.Reset(timeout)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package multiline
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+	"github.com/honeycombio/honeytail/parsers/keyval"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+func processLines(p *Parser, lines []string, prefixRegex *parsers.ExtRegexp) []event.Event {
+	linesChan := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		for _, line := range lines {
+			linesChan <- line
+		}
+		close(linesChan)
+	}()
+	go func() {
+		p.ProcessLines(linesChan, send, prefixRegex)
+		close(send)
+	}()
+	var events []event.Event
+	for ev := range send {
+		events = append(events, ev)
+	}
+	return events
+}
+
+func messages(events []event.Event) []interface{} {
+	var msgs []interface{}
+	for _, ev := range events {
+		msgs = append(msgs, ev.Data["message"])
+	}
+	return msgs
+}
+
+func TestContinuationHeuristics(t *testing.T) {
+	p := &Parser{}
+	if err := p.Init(&Options{MaxLines: 500, FlushTimeoutMs: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	p.nower = &FakeNower{}
+	events := processLines(p, []string{
+		"12:00:00 ERROR request failed",
+		"java.lang.IllegalStateException: bad state",
+		"\tat com.example.App.run(App.java:10)",
+		"Caused by: java.io.IOException: closed",
+		"\t... 12 more",
+		"12:00:01 ERROR python failed",
+		"Traceback (most recent call last):",
+		`  File "app.py", line 1, in <module>`,
+		"ValueError: bad value",
+		"",
+		"During handling of the above exception, another exception occurred:",
+		"",
+		"Traceback (most recent call last):",
+		`  File "app.py", line 3, in <module>`,
+		"KeyError: 'x'",
+		"12:00:02 INFO all better",
+	}, nil)
+	expected := []interface{}{
+		"12:00:00 ERROR request failed\njava.lang.IllegalStateException: bad state\n\tat com.example.App.run(App.java:10)\nCaused by: java.io.IOException: closed\n\t... 12 more",
+		"12:00:01 ERROR python failed\nTraceback (most recent call last):\n  File \"app.py\", line 1, in <module>\nValueError: bad value\n\nDuring handling of the above exception, another exception occurred:\n\nTraceback (most recent call last):\n  File \"app.py\", line 3, in <module>\nKeyError: 'x'",
+		"12:00:02 INFO all better",
+	}
+	if msgs := messages(events); !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("got messages %q, expected %q", msgs, expected)
+	}
+	if events[0].Timestamp != (&FakeNower{}).Now() {
+		t.Errorf("unexpected timestamp %s", events[0].Timestamp)
+	}
+}
+
+func TestStartPatternAndMaxLines(t *testing.T) {
+	p := &Parser{}
+	if err := p.Init(&Options{Start: `^\d{2}:`, MaxLines: 3, FlushTimeoutMs: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	p.nower = &FakeNower{}
+	prefix := &parsers.ExtRegexp{regexp.MustCompile(`^(?P<host>\w+): `)}
+	events := processLines(p, []string{
+		"app1: 12:00:00 first",
+		"app1: unindented continuation",
+		"app1: another",
+		"app1: past the limit",
+		"app1: 12:00:01 second",
+	}, prefix)
+	expected := []event.Event{
+		{
+			Timestamp: (&FakeNower{}).Now(),
+			Data: map[string]interface{}{
+				"message":             "12:00:00 first\nunindented continuation\nanother",
+				"multiline_truncated": true,
+				"host":                "app1",
+			},
+		},
+		{
+			Timestamp: (&FakeNower{}).Now(),
+			Data:      map[string]interface{}{"message": "12:00:01 second", "host": "app1"},
+		},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("got events %+v, expected %+v", events, expected)
+	}
+	if err := (&Parser{}).Init(&Options{Start: "(", MaxLines: 1}); err == nil {
+		t.Error("expected error for a bad start pattern")
+	}
+}
+
+func TestFlushTimeout(t *testing.T) {
+	p := &Parser{}
+	if err := p.Init(&Options{MaxLines: 500, FlushTimeoutMs: 10}); err != nil {
+		t.Fatal(err)
+	}
+	p.nower = &FakeNower{}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go p.ProcessLines(lines, send, nil)
+	lines <- "ERROR failed"
+	lines <- "  at somewhere"
+	select {
+	case ev := <-send:
+		if ev.Data["message"] != "ERROR failed\n  at somewhere" {
+			t.Errorf("unexpected event %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected the entry to be flushed once lines stopped arriving")
+	}
+	close(lines)
+}
+
+func TestProcessLinesInnerParser(t *testing.T) {
+	p := &Parser{
+		Inner:        &keyval.Parser{},
+		InnerOptions: &keyval.Options{NumParsers: 1},
+		SampleRate:   1,
+	}
+	if err := p.Init(&Options{MaxLines: 500, FlushTimeoutMs: 1000, NumParsers: 1}); err != nil {
+		t.Fatal(err)
+	}
+	events := processLines(p, []string{
+		"status=500 path=/home",
+		"java.lang.NullPointerException",
+		"\tat com.example.Home.get(Home.java:5)",
+		"not keyval, so dropped by the inner parser",
+		"status=200 path=/about",
+	}, nil)
+	expected := []map[string]interface{}{
+		{
+			"status":     500,
+			"path":       "/home",
+			"stacktrace": "java.lang.NullPointerException\n\tat com.example.Home.get(Home.java:5)",
+		},
+		{
+			"status": 200,
+			"path":   "/about",
+		},
+	}
+	var data []map[string]interface{}
+	for _, ev := range events {
+		if ev.SampleRate != 1 {
+			t.Errorf("expected sample rate 1, got %d", ev.SampleRate)
+		}
+		data = append(data, ev.Data)
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("got %+v, expected %+v", data, expected)
+	}
+}
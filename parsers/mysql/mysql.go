@@ -112,6 +112,15 @@ var (
 	reQuery            = parsers.ExtRegexp{regexp.MustCompile("^(?P<query>[^#]*).*$")}
 	reUse              = parsers.ExtRegexp{regexp.MustCompile("^(?i)use ")}
 
+	// MariaDB and MySQL before 5.7 write the time as YYMMDD HH:MM:SS
+	reOldTime = parsers.ExtRegexp{regexp.MustCompile("^# Time: (?P<date>[0-9]{6}) +(?P<time>[0-9]{1,2}:[0-9]{2}:[0-9]{2}) *$")}
+	// reKeyVals matches the other comment lines added by Percona Server and
+	// MariaDB, made of Key: value pairs, eg
+	//   # Thread_id: 57  Schema: imdb  Last_errno: 0  Killed: 0
+	//   # Pages_accessed: 3  Pages_read: 0  Pages_updated: 0  Undo_records_added: 0
+	reKeyVals = parsers.ExtRegexp{regexp.MustCompile(`^# +\w+: \S+(?: +\w+: \S+)* *$`)}
+	reKeyVal  = regexp.MustCompile(`(\w+): (\S+)`)
+
 	// if 'flush logs' is run at the mysql prompt (which rds commonly does, apparently) the following shows up in slow query log:
 	//   /usr/local/Cellar/mysql/5.7.12/bin/mysqld, Version: 5.7.12 (Homebrew). started with:
 	//   Tcp port: 3306  Unix socket: /tmp/mysql.sock
@@ -121,7 +130,41 @@ var (
 	reMySQLColumnHeaders = parsers.ExtRegexp{regexp.MustCompile("Time.*Id.*Command.*Argument.*")}
 )
 
-const timeFormat = "2006-01-02T15:04:05.000000"
+const (
+	timeFormat    = "2006-01-02T15:04:05.000000"
+	oldTimeFormat = "060102 15:04:05"
+)
+
+// keyValNames are the event attributes for the keys in the comment lines
+// matched by reKeyVals that have their own regex too, so a key has the same
+// name whichever line it's on. Other keys are lower cased.
+var keyValNames = map[string]string{
+	"Query_time":            queryTimeKey,
+	"Lock_time":             lockTimeKey,
+	"Rows_sent":             rowsSentKey,
+	"Rows_examined":         rowsExaminedKey,
+	"Rows_affected":         rowsAffectedKey,
+	"Schema":                databaseKey,
+	"Bytes_sent":            bytesSentKey,
+	"Tmp_tables":            tmpTablesKey,
+	"Tmp_disk_tables":       tmpDiskTablesKey,
+	"Tmp_table_sizes":       tmpTableSizesKey,
+	"QC_Hit":                queryCacheHitKey,
+	"QC_hit":                queryCacheHitKey,
+	"Full_scan":             fullScanKey,
+	"Full_join":             fullJoinKey,
+	"Tmp_table":             tmpTableKey,
+	"Tmp_table_on_disk":     tmpTableOnDiskKey,
+	"Filesort":              fileSortKey,
+	"Filesort_on_disk":      fileSortOnDiskKey,
+	"Merge_passes":          mergePassesKey,
+	"InnoDB_IO_r_ops":       ioROpsKey,
+	"InnoDB_IO_r_bytes":     ioRBytesKey,
+	"InnoDB_IO_r_wait":      ioRWaitKey,
+	"InnoDB_rec_lock_wait":  recLockWaitKey,
+	"InnoDB_queue_wait":     queueWaitKey,
+	"InnoDB_pages_distinct": pagesDistinctKey,
+}
 
 type Options struct {
 	Host          string `long:"host" description:"MySQL host in the format (address:port)"`
@@ -417,6 +460,8 @@ func (p *Parser) handleEvent(ptp *perThreadParser, rawE []string) (
 		// parse each line and populate the map of attributes
 		if _, mg := reTime.FindStringSubmatchMap(line); mg != nil {
 			timeFromComment, _ = time.Parse(timeFormat, mg["time"])
+		} else if _, mg := reOldTime.FindStringSubmatchMap(line); mg != nil {
+			timeFromComment, _ = time.Parse(oldTimeFormat, mg["date"]+" "+mg["time"])
 		} else if reAdminPing.MatchString(line) {
 			// this event is an administrative ping and we should
 			// ignore the entire event
@@ -446,6 +491,8 @@ func (p *Parser) handleEvent(ptp *perThreadParser, rawE []string) (
 			if rowsAffected, err := strconv.Atoi(mg["rowsAffected"]); err == nil {
 				sq[rowsAffectedKey] = rowsAffected
 			}
+			// Percona and MariaDB may add more, such as Rows_read
+			addKeyVals(line, sq)
 		} else if _, mg := reServStats.FindStringSubmatchMap(line); mg != nil {
 			query = ""
 			if bytesSent, err := strconv.Atoi(mg["bytesSent"]); err == nil {
@@ -495,6 +542,8 @@ func (p *Parser) handleEvent(ptp *perThreadParser, rawE []string) (
 			}
 		} else if _, mg := reInnodbTrx.FindStringSubmatchMap(line); mg != nil {
 			sq[transactionIDKey] = mg["trxId"]
+		} else if reKeyVals.MatchString(line) {
+			addKeyVals(line, sq)
 		} else if match := reUse.FindString(line); match != "" {
 			query = ""
 			db := strings.TrimPrefix(line, match)
@@ -554,6 +603,31 @@ func (p *Parser) handleEvent(ptp *perThreadParser, rawE []string) (
 	return sq, combinedTime
 }
 
+// addKeyVals adds the Key: value pairs in a comment line to sq, as numbers
+// or booleans where they are. Attributes already set aren't replaced.
+func addKeyVals(line string, sq map[string]interface{}) {
+	for _, kv := range reKeyVal.FindAllStringSubmatch(line, -1) {
+		key, val := kv[1], kv[2]
+		if name, ok := keyValNames[key]; ok {
+			key = name
+		} else {
+			key = strings.ToLower(key)
+		}
+		if _, ok := sq[key]; ok {
+			continue
+		}
+		if n, err := strconv.Atoi(val); err == nil {
+			sq[key] = n
+		} else if f, err := strconv.ParseFloat(val, 64); err == nil {
+			sq[key] = f
+		} else if val == "Yes" || val == "No" {
+			sq[key] = val == "Yes"
+		} else {
+			sq[key] = val
+		}
+	}
+}
+
 // custom error to indicate empty query
 type emptyQueryError struct {
 	err string
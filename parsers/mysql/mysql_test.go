@@ -353,6 +353,9 @@ var sqds = []slowQueryData{
 			recLockWaitKey:     0.0,
 			queueWaitKey:       0.0,
 			pagesDistinctKey:   6756,
+			databaseKey:        "our_index",
+			"last_errno":       0,
+			"killed":           0,
 		},
 		timestamp: time.Unix(1473217822, 0),
 	},
@@ -403,6 +406,9 @@ var sqds = []slowQueryData{
 			recLockWaitKey:     4.0,
 			queueWaitKey:       5.0,
 			pagesDistinctKey:   6756,
+			databaseKey:        "our_index",
+			"last_errno":       0,
+			"killed":           0,
 		},
 		timestamp: time.Unix(1473217822, 0),
 	},
@@ -435,6 +441,10 @@ var sqds = []slowQueryData{
 			tmpDiskTablesKey:   0,
 			tmpTableSizesKey:   0,
 			transactionIDKey:   "98CF",
+			"thread_id":        78959,
+			"last_errno":       0,
+			"killed":           0,
+			"rows_read":        12,
 		},
 		timestamp: time.Unix(1364506803, 0),
 	},
@@ -462,6 +472,78 @@ var sqds = []slowQueryData{
 		},
 		timestamp: time.Unix(1476901800, 0),
 	},
+	{ /* 23 */
+		// Percona Server 5.7, without QC_Hit and with rate limiting
+		rawE: []string{
+			"# Time: 2016-04-01T00:31:09.817887Z",
+			"# User@Host: app[app] @ web1 [192.0.2.7]  Id:    57",
+			"# Schema: shop  Last_errno: 0  Killed: 0",
+			"# Query_time: 0.500000  Lock_time: 0.000100  Rows_sent: 1  Rows_examined: 2  Rows_affected: 0",
+			"# Bytes_sent: 52",
+			"# Full_scan: Yes  Full_join: No  Tmp_table: No  Tmp_table_on_disk: No",
+			"# Log_slow_rate_type: query  Log_slow_rate_limit: 10",
+			"SET timestamp=1459470669;",
+			"SELECT * FROM orders;",
+		},
+		sq: map[string]interface{}{
+			userKey:               "app",
+			clientKey:             "web1 [192.0.2.7]",
+			databaseKey:           "shop",
+			"last_errno":          0,
+			"killed":              0,
+			queryTimeKey:          0.5,
+			lockTimeKey:           0.0001,
+			rowsSentKey:           1,
+			rowsExaminedKey:       2,
+			rowsAffectedKey:       0,
+			bytesSentKey:          52,
+			fullScanKey:           true,
+			fullJoinKey:           false,
+			tmpTableKey:           false,
+			tmpTableOnDiskKey:     false,
+			"log_slow_rate_type":  "query",
+			"log_slow_rate_limit": 10,
+			queryKey:              "SELECT * FROM orders",
+			normalizedQueryKey:    "select * from orders",
+			statementKey:          "select",
+			tablesKey:             "orders",
+		},
+		timestamp: t1,
+	},
+	{ /* 24 */
+		// MariaDB 10.6, with its time format and page counts
+		rawE: []string{
+			"# Time: 160401  0:31:09",
+			"# User@Host: app[app] @ localhost []",
+			"# Thread_id: 8  Schema: shop  QC_hit: No",
+			"# Query_time: 0.500000  Lock_time: 0.000100  Rows_sent: 1  Rows_examined: 2",
+			"# Rows_affected: 0  Bytes_sent: 52",
+			"# Pages_accessed: 3  Pages_read: 1  Pages_read_time: 0.0210  Engine_time: 0.0400",
+			"SELECT * FROM orders;",
+		},
+		sq: map[string]interface{}{
+			userKey:            "app",
+			clientKey:          "localhost []",
+			"thread_id":        8,
+			databaseKey:        "shop",
+			queryCacheHitKey:   false,
+			queryTimeKey:       0.5,
+			lockTimeKey:        0.0001,
+			rowsSentKey:        1,
+			rowsExaminedKey:    2,
+			rowsAffectedKey:    0,
+			bytesSentKey:       52,
+			"pages_accessed":   3,
+			"pages_read":       1,
+			"pages_read_time":  0.021,
+			"engine_time":      0.04,
+			queryKey:           "SELECT * FROM orders",
+			normalizedQueryKey: "select * from orders",
+			statementKey:       "select",
+			tablesKey:          "orders",
+		},
+		timestamp: t1.Truncate(time.Second),
+	},
 }
 
 func TestHandleEvent(t *testing.T) {
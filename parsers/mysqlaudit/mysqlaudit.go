@@ -0,0 +1,376 @@
+// Package mysqlaudit parses the logs written by the Percona and MariaDB
+// audit log plugins, in either their JSON or XML encoding.
+//
+// The JSON encoding has one record per line:
+//
+//	{"audit_record":{"name":"Query","record":"4707_2014-08-27T10:43:52","timestamp":"2014-08-27T10:44:19 UTC","command_class":"select","connection_id":"37","status":0,"sqltext":"SELECT 1","user":"root[root] @ localhost []","host":"localhost","os_user":"","ip":"","db":"shop"}}
+//
+// The XML encodings spread each record over several lines, either as the
+// attributes of an AUDIT_RECORD element (the OLD format) or as its child
+// elements (the NEW format). Lines are gathered until the record's element
+// is closed. The surrounding AUDIT element and XML declaration are skipped.
+package mysqlaudit
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/honeycombio/mysqltools/query/normalizer"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	userKey            = "user"
+	privUserKey        = "priv_user"
+	queryKey           = "query"
+	normalizedQueryKey = "normalized_query"
+	statementKey       = "statement"
+	tablesKey          = "tables"
+	timestampKey       = "timestamp"
+
+	recordElement = "AUDIT_RECORD"
+	// maxLines is the most lines gathered into one XML record. A record
+	// that's never closed would otherwise swallow the rest of the log.
+	maxLines = 10000
+)
+
+// timeLayouts are the formats the plugins write timestamps in
+var timeLayouts = []string{
+	"2006-01-02T15:04:05 MST",
+	time.RFC3339,
+}
+
+// keyNames rename the plugins' keys to the names the mysql parser uses
+var keyNames = map[string]string{
+	"sqltext": queryKey,
+	"db":      "database",
+}
+
+// intKeys hold numbers, which the JSON encoding sometimes quotes
+var intKeys = map[string]bool{
+	"connection_id": true,
+	"status":        true,
+	"status_code":   true,
+}
+
+// userRegex splits the user value, eg "app[app] @ web1 [192.0.2.7]", into
+// the user the client logged in as and the account it was matched to
+var userRegex = regexp.MustCompile(`^(\S*)\[(\S*)\] @ \S* \[\S*\]$`)
+
+type Options struct {
+	Normalize bool `long:"normalize" description:"Add normalized_query, with literals replaced, and the tables and statement type of each query"`
+
+	NumParsers int `hidden:"true" description:"number of mysqlaudit parsers to spin up"`
+}
+
+type Parser struct {
+	// set SampleRate to cause the parser to drop records after they're
+	// gathered, before they're parsed
+	SampleRate int
+
+	conf        Options
+	lineParsers []LineParser
+	nower       Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, time.Time, error)
+}
+
+// AuditLineParser parses records. The normalizer keeps state between
+// queries, so each parser needs its own.
+type AuditLineParser struct {
+	normalizer *normalizer.Parser
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	if p.conf.NumParsers < 1 {
+		p.conf.NumParsers = 1
+	}
+	p.lineParsers = make([]LineParser, p.conf.NumParsers)
+	for i := range p.lineParsers {
+		lp := &AuditLineParser{}
+		if p.conf.Normalize {
+			lp.normalizer = &normalizer.Parser{}
+		}
+		p.lineParsers[i] = lp
+	}
+	return nil
+}
+
+// ParseLine parses a complete record in either encoding
+func (a *AuditLineParser) ParseLine(line string) (map[string]interface{}, time.Time, error) {
+	var raw map[string]string
+	var err error
+	if strings.HasPrefix(line, "{") {
+		raw, err = parseJSON(line)
+	} else {
+		raw, err = parseXML(line)
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	ts, err := parseTime(raw[timestampKey])
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	parsed := make(map[string]interface{})
+	for k, v := range raw {
+		if k == timestampKey || v == "" {
+			continue
+		}
+		if name, ok := keyNames[k]; ok {
+			k = name
+		}
+		if intKeys[k] {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				parsed[k] = n
+				continue
+			}
+		}
+		parsed[k] = v
+	}
+	if u, ok := parsed[userKey].(string); ok {
+		if m := userRegex.FindStringSubmatch(u); m != nil {
+			parsed[userKey] = m[1]
+			if m[2] != "" && m[2] != m[1] {
+				parsed[privUserKey] = m[2]
+			}
+		}
+	}
+	if q, ok := parsed[queryKey].(string); ok && a.normalizer != nil {
+		a.normalize(q, parsed)
+	}
+	return parsed, ts, nil
+}
+
+// normalize adds the normalized query, its tables and statement type. The
+// normalizer panics on some statements, such as those with placeholders;
+// they're sent without.
+func (a *AuditLineParser) normalize(q string, parsed map[string]interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.WithField("query", q).Debug("failed to normalize query")
+		}
+	}()
+	parsed[normalizedQueryKey] = a.normalizer.NormalizeQuery(q)
+	if len(a.normalizer.LastTables) > 0 {
+		parsed[tablesKey] = strings.Join(a.normalizer.LastTables, " ")
+	}
+	if a.normalizer.LastStatement != "" {
+		parsed[statementKey] = a.normalizer.LastStatement
+	}
+}
+
+// parseJSON parses a JSON record, returning its keys and values as strings
+func parseJSON(line string) (map[string]string, error) {
+	var wrapper struct {
+		Record map[string]interface{} `json:"audit_record"`
+	}
+	dec := json.NewDecoder(strings.NewReader(line))
+	dec.UseNumber()
+	if err := dec.Decode(&wrapper); err != nil {
+		return nil, err
+	}
+	if wrapper.Record == nil {
+		return nil, errors.New("line has no audit_record")
+	}
+	raw := make(map[string]string, len(wrapper.Record))
+	for k, v := range wrapper.Record {
+		switch v := v.(type) {
+		case string:
+			raw[k] = v
+		case json.Number:
+			raw[k] = v.String()
+		case bool:
+			raw[k] = strconv.FormatBool(v)
+		}
+	}
+	return raw, nil
+}
+
+// parseXML parses an XML record, taking its values from either the record
+// element's attributes or its children. Names are lower cased to match the
+// JSON encoding.
+func parseXML(record string) (map[string]string, error) {
+	dec := xml.NewDecoder(strings.NewReader(record))
+	raw := make(map[string]string)
+	var child string
+	var text []byte
+	inRecord := false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if !inRecord {
+				if tok.Name.Local != recordElement {
+					return nil, errors.New("line is not an audit record")
+				}
+				inRecord = true
+				for _, attr := range tok.Attr {
+					raw[strings.ToLower(attr.Name.Local)] = attr.Value
+				}
+				continue
+			}
+			child, text = strings.ToLower(tok.Name.Local), nil
+		case xml.CharData:
+			if child != "" {
+				text = append(text, tok...)
+			}
+		case xml.EndElement:
+			if tok.Name.Local == recordElement {
+				return raw, nil
+			}
+			if child != "" {
+				raw[child] = string(text)
+				child = ""
+			}
+		}
+	}
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, errors.New("record has no timestamp")
+	}
+	var err error
+	for _, layout := range timeLayouts {
+		var t time.Time
+		if t, err = time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// isWrapper returns true for the lines around the records in an XML log
+func isWrapper(line string) bool {
+	switch strings.TrimSpace(line) {
+	case "", "<AUDIT>", "</AUDIT>":
+		return true
+	}
+	return strings.HasPrefix(strings.TrimSpace(line), "<?xml")
+}
+
+// record is an XML record being gathered
+type record struct {
+	prefixFields map[string]string
+	lines        []string
+}
+
+// ProcessLines gathers the lines of each record in a single goroutine and
+// parses the records in NumParsers more. Records are sampled once they're
+// gathered, as a tail sampler would separate an XML record's lines.
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	records := make(chan *record)
+	wg := sync.WaitGroup{}
+	for i := range p.lineParsers {
+		wg.Add(1)
+		go func(lp LineParser) {
+			for rec := range records {
+				p.send(lp, rec, send)
+			}
+			wg.Done()
+		}(p.lineParsers[i])
+	}
+
+	var current *record
+	for line := range lines {
+		logrus.WithFields(logrus.Fields{
+			"line": line,
+		}).Debug("Attempting to process mysqlaudit log line")
+
+		if current == nil {
+			var prefixFields map[string]string
+			// the prefix can only be on the first line of a record
+			if prefixRegex != nil {
+				var prefix string
+				prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+				line = strings.TrimPrefix(line, prefix)
+			}
+			if isWrapper(line) {
+				continue
+			}
+			current = &record{prefixFields: prefixFields}
+		}
+		current.lines = append(current.lines, line)
+		if !complete(current.lines[0], line) {
+			if len(current.lines) >= maxLines {
+				logrus.WithFields(logrus.Fields{
+					"line": current.lines[0],
+				}).Debug("skipping record; too many lines, it may not be closed")
+				current = nil
+			}
+			continue
+		}
+		// if sampling is disabled or sampler says keep, pass along this record.
+		if p.SampleRate <= 1 || rand.Intn(p.SampleRate) == 0 {
+			records <- current
+		}
+		current = nil
+	}
+	close(records)
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending mysqlaudit processor")
+}
+
+// complete returns true if line ends the record started by first. JSON
+// records are a single line.
+func complete(first, line string) bool {
+	first = strings.TrimSpace(first)
+	if !strings.HasPrefix(first, "<"+recordElement) {
+		return true
+	}
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(first, "<"+recordElement+">") {
+		return strings.HasSuffix(line, "</"+recordElement+">")
+	}
+	return strings.HasSuffix(line, "/>")
+}
+
+// send parses a gathered record and sends it
+func (p *Parser) send(lp LineParser, rec *record, send chan<- event.Event) {
+	text := strings.Join(rec.lines, "\n")
+	parsed, ts, err := lp.ParseLine(text)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"line":  text,
+			"error": err,
+		}).Debug("skipping line; failed to parse.")
+		return
+	}
+	// merge the prefix fields and the parsed record contents
+	for k, v := range rec.prefixFields {
+		parsed[k] = v
+	}
+	send <- event.Event{
+		Timestamp:  ts,
+		SampleRate: p.SampleRate,
+		Data:       parsed,
+	}
+}
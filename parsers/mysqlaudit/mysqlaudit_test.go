@@ -0,0 +1,134 @@
+package mysqlaudit
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/mysqltools/query/normalizer"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+func TestParseLine(t *testing.T) {
+	ts := time.Date(2014, 8, 27, 10, 44, 19, 0, time.UTC)
+	testCases := []struct {
+		input     string
+		normalize bool
+		expected  map[string]interface{}
+	}{
+		{
+			input:     `{"audit_record":{"name":"Query","record":"4707_2014-08-27T10:43:52","timestamp":"2014-08-27T10:44:19 UTC","command_class":"select","connection_id":"37","status":0,"sqltext":"SELECT * FROM orders WHERE id = 42","user":"app[app] @ web1 [192.0.2.7]","host":"web1","os_user":"","ip":"192.0.2.7","db":"shop"}}`,
+			normalize: true,
+			expected: map[string]interface{}{
+				"name":             "Query",
+				"record":           "4707_2014-08-27T10:43:52",
+				"command_class":    "select",
+				"connection_id":    int64(37),
+				"status":           int64(0),
+				"query":            "SELECT * FROM orders WHERE id = 42",
+				"normalized_query": "select * from orders where id = ?",
+				"tables":           "orders",
+				"statement":        "select",
+				"user":             "app",
+				"host":             "web1",
+				"ip":               "192.0.2.7",
+				"database":         "shop",
+			},
+		},
+		{
+			// the OLD XML format, with a proxy user
+			input: "<AUDIT_RECORD\n  NAME=\"Query\"\n  RECORD=\"4708_2014-08-27T10:43:52\"\n  TIMESTAMP=\"2014-08-27T10:44:19 UTC\"\n  COMMAND_CLASS=\"update\"\n  CONNECTION_ID=\"38\"\n  STATUS=\"1146\"\n  SQLTEXT=\"UPDATE t SET note = &quot;rush&quot;\"\n  USER=\"app[proxy] @ localhost []\"\n  HOST=\"localhost\"\n  OS_USER=\"\"\n  IP=\"\"\n  DB=\"\"\n/>",
+			expected: map[string]interface{}{
+				"name":          "Query",
+				"record":        "4708_2014-08-27T10:43:52",
+				"command_class": "update",
+				"connection_id": int64(38),
+				"status":        int64(1146),
+				"query":         `UPDATE t SET note = "rush"`,
+				"user":          "app",
+				"priv_user":     "proxy",
+				"host":          "localhost",
+			},
+		},
+		{
+			// the NEW XML format
+			input: "<AUDIT_RECORD>\n <NAME>Connect</NAME>\n <RECORD>4709_2014-08-27T10:43:52</RECORD>\n <TIMESTAMP>2014-08-27T10:44:19 UTC</TIMESTAMP>\n <CONNECTION_ID>39</CONNECTION_ID>\n <STATUS>0</STATUS>\n <USER>app</USER>\n <PRIV_USER>app</PRIV_USER>\n <HOST>web1</HOST>\n <DB>shop</DB>\n</AUDIT_RECORD>",
+			expected: map[string]interface{}{
+				"name":          "Connect",
+				"record":        "4709_2014-08-27T10:43:52",
+				"connection_id": int64(39),
+				"status":        int64(0),
+				"user":          "app",
+				"priv_user":     "app",
+				"host":          "web1",
+				"database":      "shop",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		lp := &AuditLineParser{}
+		if tc.normalize {
+			lp.normalizer = &normalizer.Parser{}
+		}
+		res, resTs, err := lp.ParseLine(tc.input)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tc.input, err)
+			continue
+		}
+		if !resTs.Equal(ts) {
+			t.Errorf("expected timestamp %s, got %s", ts, resTs)
+		}
+		if !reflect.DeepEqual(res, tc.expected) {
+			t.Errorf("expected\n%v\ngot\n%v", tc.expected, res)
+		}
+	}
+
+	for _, bad := range []string{
+		`{"not_an_audit_record":{}}`,
+		`<OTHER NAME="Query"/>`,
+		`{"audit_record":{"name":"Query"}}`,
+	} {
+		if _, _, err := (&AuditLineParser{}).ParseLine(bad); err == nil {
+			t.Errorf("expected an error parsing %q", bad)
+		}
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{}
+	if err := p.Init(&Options{NumParsers: 2}); err != nil {
+		t.Fatal(err)
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		for _, line := range []string{
+			`<?xml version="1.0" encoding="UTF-8"?>`,
+			`<AUDIT>`,
+			`<AUDIT_RECORD`,
+			`  NAME="Query"`,
+			`  TIMESTAMP="2014-08-27T10:44:19 UTC"`,
+			`  SQLTEXT="SELECT 1"`,
+			`/>`,
+			`<AUDIT_RECORD NAME="Quit" TIMESTAMP="2014-08-27T10:44:20 UTC"/>`,
+			`</AUDIT>`,
+		} {
+			lines <- line
+		}
+		close(lines)
+	}()
+	go func() {
+		p.ProcessLines(lines, send, nil)
+		close(send)
+	}()
+	var names []string
+	for ev := range send {
+		names = append(names, ev.Data["name"].(string))
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"Query", "Quit"}) {
+		t.Errorf("expected a Query and a Quit record, got %v", names)
+	}
+}
@@ -0,0 +1,287 @@
+// Package mysqlgeneral parses MySQL's general query log, which records
+// every statement and connection the server receives. Since MySQL 5.7 each
+// entry starts with its time, eg
+//
+//	2010-06-21T15:04:05.123456Z	   12 Connect	app@10.0.0.5 on shop using TCP/IP
+//	2010-06-21T15:04:05.200000Z	   12 Query	SELECT * FROM orders WHERE id = 42
+//
+// Earlier versions only give the time when it changes:
+//
+//	100621 15:04:05	   12 Connect	app@10.0.0.5 on shop
+//			   12 Query	SELECT * FROM orders WHERE id = 42
+//
+// Statements spanning several lines are gathered until the next entry
+// starts, or no lines have arrived for the flush timeout. Each entry becomes
+// an event with the connection id and command; queries are normalized like
+// the slow query log's, and connections are split into the user, client,
+// database and connection type.
+package mysqlgeneral
+
+import (
+	"errors"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/honeycombio/mysqltools/query/normalizer"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	connectionIDKey    = "connection_id"
+	commandKey         = "command"
+	argumentKey        = "argument"
+	queryKey           = "query"
+	normalizedQueryKey = "normalized_query"
+	statementKey       = "statement"
+	tablesKey          = "tables"
+	commentsKey        = "comments"
+	userKey            = "user"
+	clientKey          = "client"
+	databaseKey        = "database"
+	connectionTypeKey  = "connection_type"
+
+	oldTimeLayout = "060102 15:04:05"
+	// maxLines is the most lines gathered into one entry
+	maxLines = 500
+)
+
+var (
+	// entryRegex matches the start of an entry: the time, or a tab in old
+	// logs when it hasn't changed, the connection id, the command and its
+	// argument
+	entryRegex = regexp.MustCompile(`^(?:(\d{4}-\d{2}-\d{2}T\S+|\d{6}\s+\d{1,2}:\d{2}:\d{2})|\t)\s+(\d+) (\w+(?: \w+)?)(?:\t(.*))?$`)
+	// headerRegex matches the header written when the log is opened
+	headerRegex = regexp.MustCompile(`^(?:\S+, Version: .*started with:$|Tcp port: |Time\s+Id\s+Command\s+Argument$)`)
+	// connectRegex matches the argument of a Connect, eg
+	// app@10.0.0.5 on shop using TCP/IP
+	connectRegex = regexp.MustCompile(`^(\S*)@(\S*) on (\S*)(?: using (.*))?$`)
+)
+
+// queryCommands are the commands whose argument is a statement
+var queryCommands = map[string]bool{
+	"Query":   true,
+	"Prepare": true,
+	"Execute": true,
+}
+
+type Options struct {
+	TimeZone       string `long:"timezone" description:"IANA name of the time zone of the times in logs from before MySQL 5.7, eg America/Los_Angeles. Defaults to the local time zone"`
+	FlushTimeoutMs uint   `long:"flush_timeout_ms" description:"Send an entry once no lines have arrived for this long, rather than waiting for the next entry to start" default:"1000"`
+}
+
+type Parser struct {
+	// set SampleRate to cause the parser to drop entries after they're
+	// gathered, before they're sent
+	SampleRate int
+
+	conf       Options
+	loc        *time.Location
+	normalizer *normalizer.Parser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+// entry is a log entry being gathered
+type entry struct {
+	ts           time.Time
+	connectionID int
+	command      string
+	lines        []string
+	prefixFields map[string]string
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	p.normalizer = &normalizer.Parser{}
+	p.loc = time.Local
+	if p.conf.TimeZone != "" {
+		var err error
+		if p.loc, err = time.LoadLocation(p.conf.TimeZone); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseStart parses the line starting an entry, returning an error if line
+// doesn't start one. Entries in old logs without a time are given last, the
+// time of the entry before.
+func (p *Parser) parseStart(line string, last time.Time) (*entry, error) {
+	m := entryRegex.FindStringSubmatch(line)
+	if m == nil {
+		return nil, errors.New("line doesn't start a general query log entry")
+	}
+	ts := last
+	switch {
+	case strings.Contains(m[1], "T"):
+		t, err := time.Parse(time.RFC3339Nano, m[1])
+		if err != nil {
+			return nil, err
+		}
+		ts = t.UTC()
+	case m[1] != "":
+		// the hour is padded with a space rather than a zero
+		t, err := time.ParseInLocation(oldTimeLayout, strings.Join(strings.Fields(m[1]), " "), p.loc)
+		if err != nil {
+			return nil, err
+		}
+		ts = t.UTC()
+	}
+	if ts.IsZero() {
+		ts = p.nower.Now()
+	}
+	id, _ := strconv.Atoi(m[2])
+	return &entry{
+		ts:           ts,
+		connectionID: id,
+		command:      m[3],
+		lines:        []string{m[4]},
+	}, nil
+}
+
+// parseEntry turns a gathered entry into the event's fields
+func (p *Parser) parseEntry(e *entry) map[string]interface{} {
+	parsed := map[string]interface{}{
+		connectionIDKey: e.connectionID,
+		commandKey:      e.command,
+	}
+	arg := strings.TrimSpace(strings.Join(e.lines, "\n"))
+	switch {
+	case arg == "":
+	case queryCommands[e.command]:
+		parsed[queryKey] = arg
+		p.normalize(arg, parsed)
+	case e.command == "Init DB":
+		parsed[databaseKey] = arg
+	case e.command == "Connect":
+		if m := connectRegex.FindStringSubmatch(arg); m != nil {
+			parsed[userKey] = m[1]
+			parsed[clientKey] = m[2]
+			if m[3] != "" {
+				parsed[databaseKey] = m[3]
+			}
+			if m[4] != "" {
+				parsed[connectionTypeKey] = m[4]
+			}
+			break
+		}
+		parsed[argumentKey] = arg
+	default:
+		parsed[argumentKey] = arg
+	}
+	return parsed
+}
+
+// normalize adds the normalized query, its tables, comments and statement
+// type. The normalizer panics on some statements, such as those with
+// placeholders; they're sent without.
+func (p *Parser) normalize(q string, parsed map[string]interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.WithField("query", q).Debug("failed to normalize query")
+		}
+	}()
+	parsed[normalizedQueryKey] = p.normalizer.NormalizeQuery(q)
+	if len(p.normalizer.LastTables) > 0 {
+		parsed[tablesKey] = strings.Join(p.normalizer.LastTables, " ")
+	}
+	if len(p.normalizer.LastComments) > 0 {
+		parsed[commentsKey] = "/* " + strings.Join(p.normalizer.LastComments, " */ /* ") + " */"
+	}
+	if p.normalizer.LastStatement != "" {
+		parsed[statementKey] = p.normalizer.LastStatement
+	}
+}
+
+// ProcessLines gathers the lines of each entry and parses it in a single
+// goroutine. Entries are sampled here rather than while tailing, which would
+// separate their lines.
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	var current *entry
+	var last time.Time
+	flush := func() {
+		if current == nil {
+			return
+		}
+		// if sampling is disabled or sampler says keep, pass along this entry.
+		if p.SampleRate <= 1 || rand.Intn(p.SampleRate) == 0 {
+			data := p.parseEntry(current)
+			for k, v := range current.prefixFields {
+				data[k] = v
+			}
+			send <- event.Event{
+				Timestamp:  current.ts,
+				SampleRate: p.SampleRate,
+				Data:       data,
+			}
+		}
+		current = nil
+	}
+	timeout := time.Duration(p.conf.FlushTimeoutMs) * time.Millisecond
+	timer := time.NewTimer(timeout)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				flush()
+				timer.Stop()
+				logrus.Debug("lines channel is closed, ending mysqlgeneral processor")
+				return
+			}
+			logrus.WithFields(logrus.Fields{
+				"line": line,
+			}).Debug("Attempting to process mysqlgeneral log line")
+
+			// take care of any headers on the line
+			var prefixFields map[string]string
+			if prefixRegex != nil {
+				var prefix string
+				prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+				line = strings.TrimPrefix(line, prefix)
+			}
+			if headerRegex.MatchString(line) {
+				flush()
+			} else if e, err := p.parseStart(line, last); err == nil {
+				flush()
+				e.prefixFields = prefixFields
+				current = e
+				last = e.ts
+			} else if current != nil {
+				if len(current.lines) < maxLines {
+					current.lines = append(current.lines, line)
+				}
+			} else if strings.TrimSpace(line) != "" {
+				logrus.WithFields(logrus.Fields{
+					"line":  line,
+					"error": err,
+				}).Debug("skipping line; failed to parse.")
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(timeout)
+		case <-timer.C:
+			flush()
+			timer.Reset(timeout)
+		}
+	}
+}
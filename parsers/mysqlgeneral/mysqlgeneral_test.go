@@ -0,0 +1,125 @@
+package mysqlgeneral
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{}
+	if err := p.Init(&Options{TimeZone: "UTC", FlushTimeoutMs: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	input := []string{
+		"/usr/sbin/mysqld, Version: 5.6.51-log (MySQL Community Server (GPL)). started with:",
+		"Tcp port: 3306  Unix socket: /var/run/mysqld/mysqld.sock",
+		"Time                 Id Command    Argument",
+		"100621  9:04:05\t   12 Connect\tapp@10.0.0.5 on shop",
+		"\t\t   12 Init DB\tshop",
+		"\t\t   12 Query\tSELECT *",
+		"FROM orders",
+		"WHERE id = 42",
+		"2010-06-21T15:04:06.123456Z\t   13 Connect\tadmin@localhost on  using Socket",
+		"2010-06-21T15:04:06.200000Z\t   13 Query\tUPDATE orders SET state = 'shipped' WHERE id = 7",
+		"2010-06-21T15:04:07.000000Z\t   13 Quit\t",
+		"2010-06-21T15:04:08.000000Z\t   14 Connect\tAccess denied for user 'bob'@'10.0.0.6' (using password: YES)",
+	}
+	expected := []event.Event{
+		{
+			Timestamp: time.Date(2010, 6, 21, 9, 4, 5, 0, time.UTC),
+			Data: map[string]interface{}{
+				"connection_id": 12,
+				"command":       "Connect",
+				"user":          "app",
+				"client":        "10.0.0.5",
+				"database":      "shop",
+			},
+		},
+		{
+			Timestamp: time.Date(2010, 6, 21, 9, 4, 5, 0, time.UTC),
+			Data: map[string]interface{}{
+				"connection_id": 12,
+				"command":       "Init DB",
+				"database":      "shop",
+			},
+		},
+		{
+			Timestamp: time.Date(2010, 6, 21, 9, 4, 5, 0, time.UTC),
+			Data: map[string]interface{}{
+				"connection_id":    12,
+				"command":          "Query",
+				"query":            "SELECT *\nFROM orders\nWHERE id = 42",
+				"normalized_query": "select * from orders where id = ?",
+				"statement":        "select",
+				"tables":           "orders",
+			},
+		},
+		{
+			Timestamp: time.Date(2010, 6, 21, 15, 4, 6, 123456000, time.UTC),
+			Data: map[string]interface{}{
+				"connection_id":   13,
+				"command":         "Connect",
+				"user":            "admin",
+				"client":          "localhost",
+				"connection_type": "Socket",
+			},
+		},
+		{
+			Timestamp: time.Date(2010, 6, 21, 15, 4, 6, 200000000, time.UTC),
+			Data: map[string]interface{}{
+				"connection_id":    13,
+				"command":          "Query",
+				"query":            "UPDATE orders SET state = 'shipped' WHERE id = 7",
+				"normalized_query": "update orders set state = ? where id = ?",
+				"statement":        "update",
+				"tables":           "orders",
+			},
+		},
+		{
+			Timestamp: time.Date(2010, 6, 21, 15, 4, 7, 0, time.UTC),
+			Data: map[string]interface{}{
+				"connection_id": 13,
+				"command":       "Quit",
+			},
+		},
+		{
+			Timestamp: time.Date(2010, 6, 21, 15, 4, 8, 0, time.UTC),
+			Data: map[string]interface{}{
+				"connection_id": 14,
+				"command":       "Connect",
+				"argument":      "Access denied for user 'bob'@'10.0.0.6' (using password: YES)",
+			},
+		},
+	}
+
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		for _, line := range input {
+			lines <- line
+		}
+		close(lines)
+	}()
+	go func() {
+		p.ProcessLines(lines, send, nil)
+		close(send)
+	}()
+	var got []event.Event
+	for ev := range send {
+		got = append(got, ev)
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d events, got %d: %+v", len(expected), len(got), got)
+	}
+	for i := range expected {
+		if !got[i].Timestamp.Equal(expected[i].Timestamp) {
+			t.Errorf("event %d: expected timestamp %s, got %s", i, expected[i].Timestamp, got[i].Timestamp)
+		}
+		if !reflect.DeepEqual(got[i].Data, expected[i].Data) {
+			t.Errorf("event %d: expected\n%v\ngot\n%v", i, expected[i].Data, got[i].Data)
+		}
+	}
+}
@@ -21,6 +21,27 @@ const (
 	iso8601TimeLayout         = "2006-01-02T15:04:05-07:00"
 )
 
+// upstreamFields are the upstream variables holding a value for each
+// upstream server a request was passed to. nginx joins them with ", ", and
+// with " : " when the request was redirected to another upstream group.
+// Those that are true are also summed into a _total field, so the time or
+// bytes across every attempt can be queried the same way whether there was
+// one or several.
+var upstreamFields = map[string]bool{
+	"upstream_bytes_received":  true,
+	"upstream_bytes_sent":      true,
+	"upstream_connect_time":    true,
+	"upstream_header_time":     true,
+	"upstream_queue_time":      true,
+	"upstream_response_length": true,
+	"upstream_response_time":   true,
+	"upstream_status":          false,
+}
+
+// upstreamSeparators replaces the separator between upstream groups with
+// the one between servers, so both can be split on at once
+var upstreamSeparators = strings.NewReplacer(" : ", ", ")
+
 type Options struct {
 	ConfigFile    flag.Filename `long:"conf" description:"Path to Nginx config file"`
 	LogFormatName string        `long:"format" description:"Log format name to look for in the Nginx config file"`
@@ -132,6 +153,15 @@ func typeifyParsedLine(pl map[string]string) (map[string]interface{}, error) {
 	// try to convert numbers, if possible
 	msi := make(map[string]interface{}, len(pl))
 	for k, v := range pl {
+		if sum, ok := upstreamFields[k]; ok {
+			if values := splitUpstream(v); values != nil {
+				msi[k] = values
+				if sum {
+					msi[k+"_total"] = sumUpstream(values)
+				}
+				continue
+			}
+		}
 		switch {
 		case strings.Contains(v, "."):
 			f, err := strconv.ParseFloat(v, 64)
@@ -151,9 +181,74 @@ func typeifyParsedLine(pl map[string]string) (map[string]interface{}, error) {
 		}
 		msi[k] = v
 	}
+	// a single upstream still gets a total, to match requests with several
+	for k, sum := range upstreamFields {
+		if _, ok := msi[k+"_total"]; !ok && sum {
+			switch v := msi[k].(type) {
+			case int64, float64:
+				msi[k+"_total"] = v
+			}
+		}
+	}
 	return msi, nil
 }
 
+// splitUpstream splits the value of an upstream variable with several
+// values into a []int64, or a []float64 if any of them are fractional. It
+// returns nil if there's only one value, or any aren't numbers. Servers
+// that weren't reached are logged as "-", and are left out.
+func splitUpstream(v string) interface{} {
+	parts := strings.Split(upstreamSeparators.Replace(v), ", ")
+	if len(parts) < 2 {
+		return nil
+	}
+	var ints []int64
+	var floats []float64
+	fractional := strings.Contains(v, ".")
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "-" {
+			continue
+		}
+		if fractional {
+			f, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return nil
+			}
+			floats = append(floats, f)
+		} else {
+			i, err := strconv.ParseInt(part, 10, 64)
+			if err != nil {
+				return nil
+			}
+			ints = append(ints, i)
+		}
+	}
+	if fractional {
+		return floats
+	}
+	return ints
+}
+
+// sumUpstream adds up the values splitUpstream returned
+func sumUpstream(values interface{}) interface{} {
+	switch values := values.(type) {
+	case []int64:
+		var total int64
+		for _, v := range values {
+			total += v
+		}
+		return total
+	case []float64:
+		var total float64
+		for _, v := range values {
+			total += v
+		}
+		return total
+	}
+	return nil
+}
+
 type Nower interface {
 	Now() time.Time
 }
@@ -189,6 +189,35 @@ func TestTypeifyParsedLine(t *testing.T) {
 	}
 }
 
+func TestTypeifyUpstreamFields(t *testing.T) {
+	tc := typeifyTestCase{
+		untyped: map[string]string{
+			"upstream_response_time":   "0.250, 0.500 : 0.125",
+			"upstream_status":          "502, 200",
+			"upstream_response_length": "0, 612",
+			"upstream_connect_time":    "-, 0.001",
+			"upstream_header_time":     "0.005",
+			"upstream_addr":            "10.0.0.1:80, 10.0.0.2:80",
+		},
+		typed: map[string]interface{}{
+			"upstream_response_time":         []float64{0.25, 0.5, 0.125},
+			"upstream_response_time_total":   0.875,
+			"upstream_status":                []int64{502, 200},
+			"upstream_response_length":       []int64{0, 612},
+			"upstream_response_length_total": int64(612),
+			"upstream_connect_time":          []float64{0.001},
+			"upstream_connect_time_total":    0.001,
+			"upstream_header_time":           0.005,
+			"upstream_header_time_total":     0.005,
+			"upstream_addr":                  "10.0.0.1:80, 10.0.0.2:80",
+		},
+	}
+	res, _ := typeifyParsedLine(tc.untyped)
+	if !reflect.DeepEqual(res, tc.typed) {
+		t.Fatalf("Comparison failed. Expected: %v, Actual: %v", tc.typed, res)
+	}
+}
+
 type FakeNower struct{}
 
 func (f *FakeNower) Now() time.Time {
@@ -0,0 +1,186 @@
+// Package nginxerror parses nginx's error log, eg
+//
+//	2010/06/21 15:04:05 [error] 1234#0: *5678 open() "/var/www/favicon.ico" failed (2: No such file or directory), client: 192.0.2.7, server: example.com, request: "GET /favicon.ico HTTP/1.1", host: "example.com"
+//
+// Each line is split into its level, the pid and thread id of the worker,
+// the connection it was about, and the message. The context nginx adds
+// after the message, such as the client, server, request and upstream,
+// becomes a field for each, and a system error in the message, like
+// (2: No such file or directory), is kept as errno and error.
+package nginxerror
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	levelKey        = "level"
+	pidKey          = "pid"
+	tidKey          = "tid"
+	connectionIDKey = "connection_id"
+	messageKey      = "message"
+	errnoKey        = "errno"
+	errorKey        = "error"
+
+	timeLayout = "2006/01/02 15:04:05"
+)
+
+var (
+	// lineRegex matches a line: the time, level, pid#tid, the connection
+	// if there is one, and the message
+	lineRegex = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}) \[(\w+)\] (\d+)#(\d+): (?:\*(\d+) )?(.*)$`)
+	// contextStartRegex matches the start of the context following the
+	// message, which begins with the client or, without one, the server
+	contextStartRegex = regexp.MustCompile(`, (?:client|server): `)
+	// contextRegex matches each part of the context
+	contextRegex = regexp.MustCompile(`, (client|server|login|upstream|request|subrequest|host|referrer|port): `)
+	// errnoRegex matches a system error in the message
+	errnoRegex = regexp.MustCompile(`\((\d+): ([^)]+)\)`)
+)
+
+type Options struct {
+	TimeZone string `long:"timezone" description:"IANA name of the time zone nginx logs in, eg America/Los_Angeles. Defaults to the local time zone"`
+
+	NumParsers int `hidden:"true" description:"number of nginxerror parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, time.Time, error)
+}
+
+type NginxErrorLineParser struct {
+	loc *time.Location
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	loc := time.Local
+	if p.conf.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(p.conf.TimeZone); err != nil {
+			return err
+		}
+	}
+	p.lineParser = &NginxErrorLineParser{loc: loc}
+	return nil
+}
+
+// ParseLine parses an error log line
+func (n *NginxErrorLineParser) ParseLine(line string) (map[string]interface{}, time.Time, error) {
+	m := lineRegex.FindStringSubmatch(line)
+	if m == nil {
+		return nil, time.Time{}, errors.New("line is not an nginx error log line")
+	}
+	ts, err := time.ParseInLocation(timeLayout, m[1], n.loc)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	parsed := map[string]interface{}{
+		levelKey: m[2],
+	}
+	parsed[pidKey], _ = strconv.Atoi(m[3])
+	parsed[tidKey], _ = strconv.Atoi(m[4])
+	if m[5] != "" {
+		parsed[connectionIDKey], _ = strconv.Atoi(m[5])
+	}
+
+	msg := m[6]
+	if loc := contextStartRegex.FindStringIndex(msg); loc != nil {
+		addContext(msg[loc[0]:], parsed)
+		msg = msg[:loc[0]]
+	}
+	if e := errnoRegex.FindStringSubmatch(msg); e != nil {
+		parsed[errnoKey], _ = strconv.Atoi(e[1])
+		parsed[errorKey] = e[2]
+	}
+	parsed[messageKey] = msg
+	return parsed, ts.UTC(), nil
+}
+
+// addContext adds each key: value in the context to parsed, unquoting the
+// quoted values
+func addContext(context string, parsed map[string]interface{}) {
+	locs := contextRegex.FindAllStringSubmatchIndex(context, -1)
+	for i, loc := range locs {
+		end := len(context)
+		if i+1 < len(locs) {
+			end = locs[i+1][0]
+		}
+		key := context[loc[2]:loc[3]]
+		val := context[loc[1]:end]
+		if len(val) >= 2 && val[0] == '"' && val[len(val)-1] == '"' {
+			val = val[1 : len(val)-1]
+		}
+		parsed[key] = val
+	}
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process nginx error log line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, timestamp, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp: timestamp,
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending nginxerror processor")
+}
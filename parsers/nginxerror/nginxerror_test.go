@@ -0,0 +1,82 @@
+package nginxerror
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testLineMap struct {
+	line     string
+	expected map[string]interface{}
+	ts       time.Time
+}
+
+var tlms = []testLineMap{
+	{
+		line: `2010/06/21 15:04:05 [error] 1234#0: *5678 open() "/var/www/favicon.ico" failed (2: No such file or directory), client: 192.0.2.7, server: example.com, request: "GET /favicon.ico HTTP/1.1", host: "example.com", referrer: "https://example.com/"`,
+		expected: map[string]interface{}{
+			"level":         "error",
+			"pid":           1234,
+			"tid":           0,
+			"connection_id": 5678,
+			"message":       `open() "/var/www/favicon.ico" failed (2: No such file or directory)`,
+			"errno":         2,
+			"error":         "No such file or directory",
+			"client":        "192.0.2.7",
+			"server":        "example.com",
+			"request":       "GET /favicon.ico HTTP/1.1",
+			"host":          "example.com",
+			"referrer":      "https://example.com/",
+		},
+		ts: time.Date(2010, 6, 21, 15, 4, 5, 0, time.UTC),
+	},
+	{
+		line: `2010/06/21 15:04:06 [error] 1234#1: *5679 upstream timed out (110: Connection timed out) while reading response header from upstream, client: 192.0.2.8, server: api.example.com, request: "POST /v1/jobs, batch HTTP/1.1", upstream: "http://127.0.0.1:8080/v1/jobs", host: "api.example.com"`,
+		expected: map[string]interface{}{
+			"level":         "error",
+			"pid":           1234,
+			"tid":           1,
+			"connection_id": 5679,
+			"message":       "upstream timed out (110: Connection timed out) while reading response header from upstream",
+			"errno":         110,
+			"error":         "Connection timed out",
+			"client":        "192.0.2.8",
+			"server":        "api.example.com",
+			"request":       "POST /v1/jobs, batch HTTP/1.1",
+			"upstream":      "http://127.0.0.1:8080/v1/jobs",
+			"host":          "api.example.com",
+		},
+		ts: time.Date(2010, 6, 21, 15, 4, 6, 0, time.UTC),
+	},
+	{
+		line: `2010/06/21 15:04:07 [notice] 1#1: signal process started`,
+		expected: map[string]interface{}{
+			"level":   "notice",
+			"pid":     1,
+			"tid":     1,
+			"message": "signal process started",
+		},
+		ts: time.Date(2010, 6, 21, 15, 4, 7, 0, time.UTC),
+	},
+}
+
+func TestParseLine(t *testing.T) {
+	p := &NginxErrorLineParser{loc: time.UTC}
+	for _, tlm := range tlms {
+		resp, ts, err := p.ParseLine(tlm.line)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tlm.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(resp, tlm.expected) {
+			t.Errorf("response from ParseLine(%q)\n\t%+v\ndid not match expected\n\t%+v", tlm.line, resp, tlm.expected)
+		}
+		if !ts.Equal(tlm.ts) {
+			t.Errorf("timestamp from ParseLine(%q) was %s, expected %s", tlm.line, ts, tlm.ts)
+		}
+	}
+	if _, _, err := p.ParseLine(`192.0.2.7 - - [21/Jun/2010:15:04:05 +0000] "GET / HTTP/1.1" 200 612`); err == nil {
+		t.Error("expected an error parsing an access log line")
+	}
+}
@@ -0,0 +1,302 @@
+// Package otlp parses OpenTelemetry log records serialized as OTLP JSON, as
+// written by the collector's file exporter: one export request per line,
+// holding resourceLogs, each with scopeLogs, each with logRecords.
+//
+// Each log record becomes its own event. The attributes of its resource
+// and scope are included, along with the record's own, which take
+// precedence, and the scope's name and version as library.name and
+// library.version. Attributes holding key/value lists are flattened into
+// dotted field names, and arrays are encoded as JSON. The trace and span IDs
+// become trace.trace_id and trace.span_id, as they are on spans.
+//
+// Events are timestamped with the record's timeUnixNano, falling back to
+// when the record was observed if the source didn't set a time.
+package otlp
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	bodyKey           = "body"
+	severityKey       = "severity"
+	severityCodeKey   = "severity_code"
+	traceIDKey        = "trace.trace_id"
+	spanIDKey         = "trace.span_id"
+	flagsKey          = "flags"
+	observedTimeKey   = "observed_time"
+	libraryNameKey    = "library.name"
+	libraryVersionKey = "library.version"
+
+	// timeKey holds the record's time until it's taken for the timestamp
+	timeKey = "time_unix_nano"
+)
+
+type Options struct {
+	NumParsers int `hidden:"true" description:"number of otlp parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) ([]map[string]interface{}, error)
+}
+
+type OTLPLineParser struct{}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	p.lineParser = &OTLPLineParser{}
+	return nil
+}
+
+// The OTLP JSON encoding. 64 bit integers are usually encoded as strings,
+// which json.Number accepts along with plain numbers.
+
+type exportRequest struct {
+	ResourceLogs []resourceLogs `json:"resourceLogs"`
+}
+
+type resourceLogs struct {
+	Resource struct {
+		Attributes []keyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeLogs []scopeLogs `json:"scopeLogs"`
+}
+
+type scopeLogs struct {
+	Scope struct {
+		Name       string     `json:"name"`
+		Version    string     `json:"version"`
+		Attributes []keyValue `json:"attributes"`
+	} `json:"scope"`
+	LogRecords []logRecord `json:"logRecords"`
+}
+
+type logRecord struct {
+	TimeUnixNano         json.Number `json:"timeUnixNano"`
+	ObservedTimeUnixNano json.Number `json:"observedTimeUnixNano"`
+	SeverityNumber       int         `json:"severityNumber"`
+	SeverityText         string      `json:"severityText"`
+	Body                 *anyValue   `json:"body"`
+	Attributes           []keyValue  `json:"attributes"`
+	Flags                int         `json:"flags"`
+	TraceID              string      `json:"traceId"`
+	SpanID               string      `json:"spanId"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string     `json:"stringValue"`
+	BoolValue   *bool       `json:"boolValue"`
+	IntValue    json.Number `json:"intValue"`
+	DoubleValue *float64    `json:"doubleValue"`
+	BytesValue  *string     `json:"bytesValue"`
+	ArrayValue  *struct {
+		Values []anyValue `json:"values"`
+	} `json:"arrayValue"`
+	KvlistValue *struct {
+		Values []keyValue `json:"values"`
+	} `json:"kvlistValue"`
+}
+
+// ParseLine returns the log records in an export request
+func (o *OTLPLineParser) ParseLine(line string) ([]map[string]interface{}, error) {
+	var req exportRequest
+	if err := json.Unmarshal([]byte(line), &req); err != nil {
+		return nil, err
+	}
+	if req.ResourceLogs == nil {
+		return nil, errors.New("not an OTLP logs export request")
+	}
+	var records []map[string]interface{}
+	for _, rl := range req.ResourceLogs {
+		for _, sl := range rl.ScopeLogs {
+			for _, lr := range sl.LogRecords {
+				m := make(map[string]interface{})
+				addAttributes("", rl.Resource.Attributes, m)
+				addAttributes("", sl.Scope.Attributes, m)
+				if sl.Scope.Name != "" {
+					m[libraryNameKey] = sl.Scope.Name
+				}
+				if sl.Scope.Version != "" {
+					m[libraryVersionKey] = sl.Scope.Version
+				}
+				addAttributes("", lr.Attributes, m)
+				addRecord(lr, m)
+				records = append(records, m)
+			}
+		}
+	}
+	return records, nil
+}
+
+// addRecord adds the log record's own fields to m
+func addRecord(lr logRecord, m map[string]interface{}) {
+	if lr.Body != nil {
+		if lr.Body.KvlistValue != nil {
+			addAttributes(bodyKey+".", lr.Body.KvlistValue.Values, m)
+		} else if v := lr.Body.value(); v != nil {
+			m[bodyKey] = v
+		}
+	}
+	if lr.SeverityText != "" {
+		m[severityKey] = lr.SeverityText
+	}
+	if lr.SeverityNumber != 0 {
+		m[severityCodeKey] = lr.SeverityNumber
+	}
+	if lr.TraceID != "" {
+		m[traceIDKey] = lr.TraceID
+	}
+	if lr.SpanID != "" {
+		m[spanIDKey] = lr.SpanID
+	}
+	if lr.Flags != 0 {
+		m[flagsKey] = lr.Flags
+	}
+	if ns, err := strconv.ParseInt(string(lr.ObservedTimeUnixNano), 10, 64); err == nil && ns != 0 {
+		m[observedTimeKey] = time.Unix(0, ns).UTC().Format(time.RFC3339Nano)
+		m[timeKey] = ns
+	}
+	if ns, err := strconv.ParseInt(string(lr.TimeUnixNano), 10, 64); err == nil && ns != 0 {
+		m[timeKey] = ns
+	}
+}
+
+// addAttributes adds attrs to m, with the keys of nested key/value lists
+// joined with dots
+func addAttributes(prefix string, attrs []keyValue, m map[string]interface{}) {
+	for _, kv := range attrs {
+		key := prefix + kv.Key
+		if kv.Value.KvlistValue != nil {
+			addAttributes(key+".", kv.Value.KvlistValue.Values, m)
+			continue
+		}
+		v := kv.Value.value()
+		if v == nil {
+			continue
+		}
+		if l, ok := v.([]interface{}); ok {
+			encoded, _ := json.Marshal(l)
+			v = string(encoded)
+		}
+		m[key] = v
+	}
+}
+
+// value returns the Go value of an AnyValue, or nil if it's empty
+func (a *anyValue) value() interface{} {
+	switch {
+	case a.StringValue != nil:
+		return *a.StringValue
+	case a.BoolValue != nil:
+		return *a.BoolValue
+	case a.IntValue != "":
+		if n, err := strconv.ParseInt(string(a.IntValue), 10, 64); err == nil {
+			return n
+		}
+		return string(a.IntValue)
+	case a.DoubleValue != nil:
+		return *a.DoubleValue
+	case a.BytesValue != nil:
+		// base64 encoded
+		return *a.BytesValue
+	case a.ArrayValue != nil:
+		l := make([]interface{}, 0, len(a.ArrayValue.Values))
+		for i := range a.ArrayValue.Values {
+			l = append(l, a.ArrayValue.Values[i].value())
+		}
+		return l
+	case a.KvlistValue != nil:
+		m := make(map[string]interface{})
+		for i := range a.KvlistValue.Values {
+			m[a.KvlistValue.Values[i].Key] = a.KvlistValue.Values[i].Value.value()
+		}
+		return m
+	}
+	return nil
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process otlp log line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				records, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				for _, record := range records {
+					// merge the prefix fields and the record contents
+					for k, v := range prefixFields {
+						record[k] = v
+					}
+
+					send <- event.Event{
+						Timestamp: p.getTimestamp(record),
+						Data:      record,
+					}
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending otlp processor")
+}
+
+// getTimestamp uses the time the record was made, or else observed
+func (p *Parser) getTimestamp(m map[string]interface{}) time.Time {
+	if ns, ok := m[timeKey].(int64); ok {
+		delete(m, timeKey)
+		return time.Unix(0, ns).UTC()
+	}
+	return p.nower.Now()
+}
@@ -0,0 +1,104 @@
+package otlp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+const exportRequestLine = `{"resourceLogs":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"checkout"}},{"key":"host.name","value":{"stringValue":"web-1"}}]},"scopeLogs":[{"scope":{"name":"checkout.logger","version":"1.2.0"},"logRecords":[{"timeUnixNano":"1714578000123456789","observedTimeUnixNano":"1714578000200000000","severityNumber":17,"severityText":"ERROR","body":{"stringValue":"payment declined"},"attributes":[{"key":"http.status_code","value":{"intValue":"402"}},{"key":"retry","value":{"boolValue":false}},{"key":"ratio","value":{"doubleValue":0.5}},{"key":"tags","value":{"arrayValue":{"values":[{"stringValue":"a"},{"intValue":2}]}}},{"key":"customer","value":{"kvlistValue":{"values":[{"key":"id","value":{"stringValue":"c-42"}}]}}},{"key":"host.name","value":{"stringValue":"override"}}],"traceId":"5b8efff798038103d269b633813fc60c","spanId":"eee19b7ec3c1b174","flags":1},{"observedTimeUnixNano":1714578001000000000,"body":{"kvlistValue":{"values":[{"key":"event","value":{"stringValue":"login"}}]}}}]}]}]}`
+
+func TestParseLine(t *testing.T) {
+	p := OTLPLineParser{}
+	records, err := p.ParseLine(exportRequestLine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]interface{}{
+		{
+			"service.name":     "checkout",
+			"host.name":        "override",
+			"library.name":     "checkout.logger",
+			"library.version":  "1.2.0",
+			"http.status_code": int64(402),
+			"retry":            false,
+			"ratio":            0.5,
+			"tags":             `["a",2]`,
+			"customer.id":      "c-42",
+			"body":             "payment declined",
+			"severity":         "ERROR",
+			"severity_code":    17,
+			"trace.trace_id":   "5b8efff798038103d269b633813fc60c",
+			"trace.span_id":    "eee19b7ec3c1b174",
+			"flags":            1,
+			"observed_time":    "2024-05-01T15:40:00.2Z",
+			"time_unix_nano":   int64(1714578000123456789),
+		},
+		{
+			"service.name":    "checkout",
+			"host.name":       "web-1",
+			"library.name":    "checkout.logger",
+			"library.version": "1.2.0",
+			"body.event":      "login",
+			"observed_time":   "2024-05-01T15:40:01Z",
+			"time_unix_nano":  int64(1714578001000000000),
+		},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("response from ParseLine\n\t%+v\ndid not match expected\n\t%+v", records, expected)
+	}
+
+	for _, bad := range []string{"not json", `{"resourceSpans":[]}`} {
+		if _, err := p.ParseLine(bad); err == nil {
+			t.Errorf("expected an error parsing %q", bad)
+		}
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{
+		conf:       Options{NumParsers: 1},
+		lineParser: &OTLPLineParser{},
+		nower:      &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		lines <- exportRequestLine
+		lines <- `{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"body":{"stringValue":"no time"}}]}]}]}`
+		close(lines)
+	}()
+	go func() {
+		p.ProcessLines(lines, send, nil)
+		close(send)
+	}()
+	var evs []event.Event
+	for ev := range send {
+		evs = append(evs, ev)
+	}
+	if len(evs) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(evs))
+	}
+	expected := []time.Time{
+		time.Date(2024, 5, 1, 15, 40, 0, 123456789, time.UTC),
+		time.Date(2024, 5, 1, 15, 40, 1, 0, time.UTC),
+		(&FakeNower{}).Now(),
+	}
+	for i, ev := range evs {
+		if !ev.Timestamp.Equal(expected[i]) {
+			t.Errorf("event %d timestamp was %s, expected %s", i, ev.Timestamp, expected[i])
+		}
+		if _, ok := ev.Data["time_unix_nano"]; ok {
+			t.Errorf("event %d should not have kept time_unix_nano", i)
+		}
+	}
+}
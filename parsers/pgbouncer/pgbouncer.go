@@ -0,0 +1,287 @@
+// Package pgbouncer parses pgbouncer's log, eg
+//
+//	2010-06-21 15:04:05.123 UTC [4242] LOG C-0x55d5c8e4a0b0: shop/app@192.0.2.7:51234 login attempt: db=shop user=app tls=no
+//	2010-06-21 15:05:00.000 UTC [4242] LOG stats: 12 xacts/s, 34 queries/s, in 5678 B/s, out 9012 B/s, xact 1234 us, query 567 us, wait 89 us
+//
+// Lines about a connection are split into the side of the pool it's on, the
+// database, user and address, and the event: logins, closes with their
+// reason, and new server connections. The periodic stats lines are split
+// into fields named after the columns of SHOW STATS, such as avg_query and
+// avg_recv, so they can be graphed.
+package pgbouncer
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	pidKey         = "pid"
+	levelKey       = "level"
+	messageKey     = "message"
+	sideKey        = "side"
+	connectionKey  = "connection_id"
+	databaseKey    = "database"
+	userKey        = "user"
+	addrKey        = "addr"
+	portKey        = "port"
+	eventKey       = "event"
+	closeReasonKey = "close_reason"
+	ageKey         = "age_s"
+	localAddrKey   = "local_addr"
+	localPortKey   = "local_port"
+
+	timeLayout = "2006-01-02 15:04:05.999 MST"
+)
+
+// lineRegex matches the timestamp, pid and level starting each line
+var lineRegex = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)? \S+) \[(\d+)\] ([A-Z]+) (.*)$`)
+
+// connectionRegex matches the connection a line is about, eg
+// C-0x55d5c8e4a0b0: shop/app@192.0.2.7:51234, or @unix(1234):6432 for a
+// unix socket
+var connectionRegex = regexp.MustCompile(`^([CS])-(0x[0-9a-f]+): ([^/\s]*)/([^@\s]*)@(\S+):(\d+) (.*)$`)
+
+// closeRegex matches the reason a connection was closed and its age
+var closeRegex = regexp.MustCompile(`^closing because: (.*?)(?: \(age=(\d+)s\))?$`)
+
+// serverRegex matches a new server connection and its local address
+var serverRegex = regexp.MustCompile(`^new connection to server(?: \(from (\S+):(\d+)\))?$`)
+
+// statsUnits name the fields for each value in a stats line, by its unit or
+// label. "req/s" is from versions before 1.8, and "wait time" from 1.8
+// to 1.11.
+var statsUnits = map[string]string{
+	"xacts/s":         "avg_xact_count",
+	"queries/s":       "avg_query_count",
+	"req/s":           "avg_req",
+	"client parses/s": "avg_client_parse_count",
+	"server parses/s": "avg_server_parse_count",
+	"binds/s":         "avg_bind_count",
+	"in":              "avg_recv",
+	"out":             "avg_sent",
+	"xact":            "avg_xact_time",
+	"query":           "avg_query",
+	"wait":            "avg_wait_time",
+	"wait time":       "avg_wait_time",
+}
+
+// statRegex matches one value in a stats line, either "12 xacts/s" or
+// "in 5678 B/s"
+var statRegex = regexp.MustCompile(`^(?:(\d+) ([a-z /]+)|([a-z ]+?) (\d+) (?:[bB]/s|us))$`)
+
+type Options struct {
+	TimeZone string `long:"timezone" description:"IANA name of the time zone pgbouncer logs in, eg America/Los_Angeles, used to read zone abbreviations such as PDT. Defaults to the local time zone"`
+
+	NumParsers int `hidden:"true" description:"number of pgbouncer parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, time.Time, error)
+}
+
+type PgBouncerLineParser struct {
+	loc *time.Location
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	loc := time.Local
+	if p.conf.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(p.conf.TimeZone); err != nil {
+			return err
+		}
+	}
+	p.lineParser = &PgBouncerLineParser{loc: loc}
+	return nil
+}
+
+// ParseLine parses a pgbouncer log line. Lines that aren't about a
+// connection or the stats keep their text as the message field.
+func (b *PgBouncerLineParser) ParseLine(line string) (map[string]interface{}, time.Time, error) {
+	match := lineRegex.FindStringSubmatch(line)
+	if match == nil {
+		return nil, time.Time{}, errors.New("line is not a pgbouncer log line")
+	}
+	ts, err := time.ParseInLocation(timeLayout, match[1], b.loc)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	parsed := map[string]interface{}{
+		levelKey: match[3],
+	}
+	if pid, err := strconv.Atoi(match[2]); err == nil {
+		parsed[pidKey] = pid
+	}
+	msg := match[4]
+	if strings.HasPrefix(msg, "stats: ") || strings.HasPrefix(msg, "Stats: ") {
+		if parseStats(msg[len("stats: "):], parsed) {
+			parsed[eventKey] = "stats"
+			return parsed, ts.UTC(), nil
+		}
+	}
+	if c := connectionRegex.FindStringSubmatch(msg); c != nil {
+		parsed[sideKey] = "client"
+		if c[1] == "S" {
+			parsed[sideKey] = "server"
+		}
+		parsed[connectionKey] = c[1] + "-" + c[2]
+		if c[3] != "" && c[3] != "(nodb)" {
+			parsed[databaseKey] = c[3]
+		}
+		if c[4] != "" && c[4] != "(nouser)" {
+			parsed[userKey] = c[4]
+		}
+		parsed[addrKey] = c[5]
+		if port, err := strconv.Atoi(c[6]); err == nil {
+			parsed[portKey] = port
+		}
+		msg = c[7]
+		if parseConnectionEvent(msg, parsed) {
+			return parsed, ts.UTC(), nil
+		}
+	}
+	parsed[messageKey] = msg
+	return parsed, ts.UTC(), nil
+}
+
+// parseConnectionEvent parses the messages logged as connections are made
+// and closed into parsed, returning false for any other message
+func parseConnectionEvent(msg string, parsed map[string]interface{}) bool {
+	if strings.HasPrefix(msg, "login attempt: ") {
+		parsed[eventKey] = "login"
+		// eg db=shop user=app tls=no
+		for _, kv := range strings.Fields(msg[len("login attempt: "):]) {
+			if eq := strings.Index(kv, "="); eq > 0 {
+				switch key := kv[:eq]; key {
+				case "db":
+					parsed[databaseKey] = kv[eq+1:]
+				case "user":
+					parsed[userKey] = kv[eq+1:]
+				default:
+					parsed[key] = kv[eq+1:]
+				}
+			}
+		}
+		return true
+	}
+	if m := closeRegex.FindStringSubmatch(msg); m != nil {
+		parsed[eventKey] = "close"
+		parsed[closeReasonKey] = m[1]
+		if age, err := strconv.Atoi(m[2]); err == nil {
+			parsed[ageKey] = age
+		}
+		return true
+	}
+	if m := serverRegex.FindStringSubmatch(msg); m != nil {
+		parsed[eventKey] = "server_connect"
+		if m[1] != "" {
+			parsed[localAddrKey] = m[1]
+		}
+		if port, err := strconv.Atoi(m[2]); err == nil {
+			parsed[localPortKey] = port
+		}
+		return true
+	}
+	return false
+}
+
+// parseStats parses the comma separated values of a stats line into parsed,
+// returning false if any of them aren't recognized. Older versions leave out
+// the space after some of the commas.
+func parseStats(msg string, parsed map[string]interface{}) bool {
+	stats := make(map[string]interface{})
+	for _, part := range strings.Split(msg, ",") {
+		m := statRegex.FindStringSubmatch(strings.TrimSpace(part))
+		if m == nil {
+			return false
+		}
+		val, label := m[1], m[2]
+		if m[3] != "" {
+			val, label = m[4], m[3]
+		}
+		key, ok := statsUnits[label]
+		if !ok {
+			return false
+		}
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return false
+		}
+		stats[key] = n
+	}
+	for k, v := range stats {
+		parsed[k] = v
+	}
+	return true
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process pgbouncer log line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, timestamp, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp: timestamp,
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending pgbouncer processor")
+}
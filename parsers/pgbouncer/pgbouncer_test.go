@@ -0,0 +1,129 @@
+package pgbouncer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLine(t *testing.T) {
+	lp := &PgBouncerLineParser{loc: time.UTC}
+	ts := time.Date(2010, 6, 21, 15, 4, 5, 123000000, time.UTC)
+	testCases := []struct {
+		input    string
+		expected map[string]interface{}
+	}{
+		{
+			input: "2010-06-21 15:04:05.123 UTC [4242] LOG C-0x55d5c8e4a0b0: shop/app@192.0.2.7:51234 login attempt: db=shop user=app tls=no",
+			expected: map[string]interface{}{
+				"pid":           4242,
+				"level":         "LOG",
+				"side":          "client",
+				"connection_id": "C-0x55d5c8e4a0b0",
+				"database":      "shop",
+				"user":          "app",
+				"addr":          "192.0.2.7",
+				"port":          51234,
+				"event":         "login",
+				"tls":           "no",
+			},
+		},
+		{
+			input: "2010-06-21 15:04:05.123 UTC [4242] LOG C-0x55d5c8e4a0b0: shop/app@192.0.2.7:51234 closing because: client close request (age=3600s)",
+			expected: map[string]interface{}{
+				"pid":           4242,
+				"level":         "LOG",
+				"side":          "client",
+				"connection_id": "C-0x55d5c8e4a0b0",
+				"database":      "shop",
+				"user":          "app",
+				"addr":          "192.0.2.7",
+				"port":          51234,
+				"event":         "close",
+				"close_reason":  "client close request",
+				"age_s":         3600,
+			},
+		},
+		{
+			input: "2010-06-21 15:04:05.123 UTC [4242] LOG S-0x55d5c8e4b1c0: shop/app@unix(1234):5432 new connection to server (from 127.0.0.1:41234)",
+			expected: map[string]interface{}{
+				"pid":           4242,
+				"level":         "LOG",
+				"side":          "server",
+				"connection_id": "S-0x55d5c8e4b1c0",
+				"database":      "shop",
+				"user":          "app",
+				"addr":          "unix(1234)",
+				"port":          5432,
+				"event":         "server_connect",
+				"local_addr":    "127.0.0.1",
+				"local_port":    41234,
+			},
+		},
+		{
+			input: "2010-06-21 15:04:05.123 UTC [4242] WARNING C-0x55d5c8e4a0b0: (nodb)/(nouser)@192.0.2.9:40000 pooler error: no such database: nope",
+			expected: map[string]interface{}{
+				"pid":           4242,
+				"level":         "WARNING",
+				"side":          "client",
+				"connection_id": "C-0x55d5c8e4a0b0",
+				"addr":          "192.0.2.9",
+				"port":          40000,
+				"message":       "pooler error: no such database: nope",
+			},
+		},
+		{
+			input: "2010-06-21 15:04:05.123 UTC [4242] LOG stats: 12 xacts/s, 34 queries/s, in 5678 B/s, out 9012 B/s, xact 1234 us, query 567 us, wait 89 us",
+			expected: map[string]interface{}{
+				"pid":             4242,
+				"level":           "LOG",
+				"event":           "stats",
+				"avg_xact_count":  int64(12),
+				"avg_query_count": int64(34),
+				"avg_recv":        int64(5678),
+				"avg_sent":        int64(9012),
+				"avg_xact_time":   int64(1234),
+				"avg_query":       int64(567),
+				"avg_wait_time":   int64(89),
+			},
+		},
+		{
+			// before 1.8
+			input: "2010-06-21 15:04:05.123 UTC [4242] LOG Stats: 7 req/s, in 100 b/s, out 200 b/s,query 300 us",
+			expected: map[string]interface{}{
+				"pid":       4242,
+				"level":     "LOG",
+				"event":     "stats",
+				"avg_req":   int64(7),
+				"avg_recv":  int64(100),
+				"avg_sent":  int64(200),
+				"avg_query": int64(300),
+			},
+		},
+		{
+			input: "2010-06-21 15:04:05.123 UTC [4242] LOG listening on 0.0.0.0:6432",
+			expected: map[string]interface{}{
+				"pid":     4242,
+				"level":   "LOG",
+				"message": "listening on 0.0.0.0:6432",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		res, resTs, err := lp.ParseLine(tc.input)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tc.input, err)
+			continue
+		}
+		if !resTs.Equal(ts) {
+			t.Errorf("expected timestamp %s, got %s", ts, resTs)
+		}
+		if !reflect.DeepEqual(res, tc.expected) {
+			t.Errorf("expected\n%v\ngot\n%v", tc.expected, res)
+		}
+	}
+
+	if _, _, err := lp.ParseLine("not a pgbouncer line"); err == nil {
+		t.Error("expected an error parsing a line that isn't pgbouncer's")
+	}
+}
@@ -0,0 +1,274 @@
+// Package pgcsvlog parses the logs PostgreSQL writes with
+// log_destination = 'csvlog'.
+//
+// Each record is a row of CSV in a fixed column order, starting with the
+// 23 columns of PostgreSQL 9.0 through 12. Later versions add backend_type,
+// leader_pid and query_id on the end. Quoted fields, most often the
+// statement, may contain newlines, so a record can span many lines; lines
+// are gathered until the quotes balance before the record is parsed.
+//
+// The duration and statement logged by log_min_duration_statement and
+// log_statement are split out of the message into the duration_ms and
+// statement fields.
+package pgcsvlog
+
+import (
+	"encoding/csv"
+	"errors"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	messageKey   = "message"
+	durationKey  = "duration_ms"
+	statementKey = "statement"
+
+	// timeLayout is how log_time and session_start_time are written
+	timeLayout = "2006-01-02 15:04:05.999 MST"
+	// maxLines is the most lines gathered into one record. A stray quote
+	// would otherwise swallow the rest of the log.
+	maxLines = 10000
+)
+
+// columns are the csvlog columns, in order
+var columns = []string{
+	"log_time",
+	"user_name",
+	"database_name",
+	"process_id",
+	"connection_from",
+	"session_id",
+	"session_line_num",
+	"command_tag",
+	"session_start_time",
+	"virtual_transaction_id",
+	"transaction_id",
+	"error_severity",
+	"sql_state_code",
+	messageKey,
+	"detail",
+	"hint",
+	"internal_query",
+	"internal_query_pos",
+	"context",
+	"query",
+	"query_pos",
+	"location",
+	"application_name",
+	// PostgreSQL 13
+	"backend_type",
+	// PostgreSQL 14
+	"leader_pid",
+	"query_id",
+}
+
+// minColumns are the columns every version writes
+const minColumns = 23
+
+// intColumns hold numbers
+var intColumns = map[string]bool{
+	"process_id":         true,
+	"session_line_num":   true,
+	"transaction_id":     true,
+	"internal_query_pos": true,
+	"query_pos":          true,
+	"leader_pid":         true,
+	"query_id":           true,
+}
+
+// messageRegex matches the messages logged by log_min_duration_statement,
+// log_duration and log_statement, eg
+// "duration: 1.234 ms  statement: SELECT 1" or
+// "duration: 0.050 ms  execute <unnamed>: SELECT $1"
+var messageRegex = regexp.MustCompile(`(?s)^(?:duration: ([\d.]+) ms)?(?:(?:^|  )(?:statement|(?:execute|parse|bind) [^:]*): (.*))?$`)
+
+type Options struct {
+	TimeZone string `long:"timezone" description:"IANA name of PostgreSQL's log_timezone, eg America/Los_Angeles, used to read zone abbreviations such as PDT. Defaults to the local time zone"`
+
+	NumParsers int `hidden:"true" description:"number of pgcsvlog parsers to spin up"`
+}
+
+type Parser struct {
+	// set SampleRate to cause the parser to drop records after they're
+	// gathered, before they're parsed
+	SampleRate int
+
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, time.Time, error)
+}
+
+type CSVLogLineParser struct {
+	loc *time.Location
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	loc := time.Local
+	if p.conf.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(p.conf.TimeZone); err != nil {
+			return err
+		}
+	}
+	p.lineParser = &CSVLogLineParser{loc: loc}
+	return nil
+}
+
+// ParseLine parses a complete csvlog record, which may contain newlines.
+// Empty columns are left out.
+func (c *CSVLogLineParser) ParseLine(line string) (map[string]interface{}, time.Time, error) {
+	r := csv.NewReader(strings.NewReader(line))
+	r.FieldsPerRecord = -1
+	values, err := r.Read()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if len(values) < minColumns {
+		return nil, time.Time{}, errors.New("record has too few columns for csvlog")
+	}
+	ts, err := time.ParseInLocation(timeLayout, values[0], c.loc)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	parsed := make(map[string]interface{})
+	for i, val := range values[1:] {
+		if i+1 >= len(columns) {
+			break
+		}
+		name := columns[i+1]
+		if val == "" {
+			continue
+		}
+		if intColumns[name] {
+			if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+				parsed[name] = n
+				continue
+			}
+		}
+		parsed[name] = val
+	}
+	if msg, ok := parsed[messageKey].(string); ok {
+		if m := messageRegex.FindStringSubmatch(msg); m != nil && (m[1] != "" || m[2] != "") {
+			if d, err := strconv.ParseFloat(m[1], 64); err == nil {
+				parsed[durationKey] = d
+			}
+			if m[2] != "" {
+				parsed[statementKey] = m[2]
+			}
+		}
+	}
+	return parsed, ts.UTC(), nil
+}
+
+// record is a csvlog record being gathered
+type record struct {
+	prefixFields map[string]string
+	lines        []string
+	quotes       int
+}
+
+// ProcessLines gathers the lines of each record in a single goroutine and
+// parses the records in NumParsers more. Records are sampled once they're
+// gathered, as a tail sampler would separate a statement's lines.
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	records := make(chan *record)
+	wg := sync.WaitGroup{}
+	numParsers := 1
+	if p.conf.NumParsers > 0 {
+		numParsers = p.conf.NumParsers
+	}
+	for i := 0; i < numParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for rec := range records {
+				p.send(rec, send)
+			}
+			wg.Done()
+		}()
+	}
+
+	var current *record
+	for line := range lines {
+		logrus.WithFields(logrus.Fields{
+			"line": line,
+		}).Debug("Attempting to process pgcsvlog log line")
+
+		if current == nil {
+			current = &record{}
+			// the prefix can only be on the first line of a record
+			if prefixRegex != nil {
+				var prefix string
+				prefix, current.prefixFields = prefixRegex.FindStringSubmatchMap(line)
+				line = strings.TrimPrefix(line, prefix)
+			}
+		}
+		current.lines = append(current.lines, line)
+		current.quotes += strings.Count(line, `"`)
+		if current.quotes%2 != 0 {
+			// inside a quoted field; the record continues on the next line
+			if len(current.lines) >= maxLines {
+				logrus.WithFields(logrus.Fields{
+					"line": current.lines[0],
+				}).Debug("skipping record; too many lines, its quotes may be unbalanced")
+				current = nil
+			}
+			continue
+		}
+		// if sampling is disabled or sampler says keep, pass along this record.
+		if p.SampleRate <= 1 || rand.Intn(p.SampleRate) == 0 {
+			records <- current
+		}
+		current = nil
+	}
+	close(records)
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending pgcsvlog processor")
+}
+
+// send parses a gathered record and sends it
+func (p *Parser) send(rec *record, send chan<- event.Event) {
+	text := strings.Join(rec.lines, "\n")
+	parsed, ts, err := p.lineParser.ParseLine(text)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"line":  text,
+			"error": err,
+		}).Debug("skipping line; failed to parse.")
+		return
+	}
+	// merge the prefix fields and the parsed record contents
+	for k, v := range rec.prefixFields {
+		parsed[k] = v
+	}
+	send <- event.Event{
+		Timestamp:  ts,
+		SampleRate: p.SampleRate,
+		Data:       parsed,
+	}
+}
@@ -0,0 +1,146 @@
+package pgcsvlog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+func TestParseLine(t *testing.T) {
+	// PDT is read as log_timezone's abbreviation
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lp := &CSVLogLineParser{loc: loc}
+	testCases := []struct {
+		input    string
+		ts       time.Time
+		expected map[string]interface{}
+	}{
+		{
+			// PostgreSQL 12, a slow statement spanning lines
+			input: "2010-06-21 15:04:05.123 UTC,\"app\",\"shop\",4242,\"192.0.2.7:51234\",5c1a2b3c.1092,7,\"SELECT\",2010-06-21 15:00:00 UTC,3/45,0,LOG,00000,\"duration: 1502.250 ms  statement: SELECT *\n  FROM orders\n  WHERE note = \"\"rush\"\"\",,,,,,,,,\"psql\"",
+			ts:    time.Date(2010, 6, 21, 15, 4, 5, 123000000, time.UTC),
+			expected: map[string]interface{}{
+				"user_name":              "app",
+				"database_name":          "shop",
+				"process_id":             int64(4242),
+				"connection_from":        "192.0.2.7:51234",
+				"session_id":             "5c1a2b3c.1092",
+				"session_line_num":       int64(7),
+				"command_tag":            "SELECT",
+				"session_start_time":     "2010-06-21 15:00:00 UTC",
+				"virtual_transaction_id": "3/45",
+				"transaction_id":         int64(0),
+				"error_severity":         "LOG",
+				"sql_state_code":         "00000",
+				"message":                "duration: 1502.250 ms  statement: SELECT *\n  FROM orders\n  WHERE note = \"rush\"",
+				"duration_ms":            1502.25,
+				"statement":              "SELECT *\n  FROM orders\n  WHERE note = \"rush\"",
+				"application_name":       "psql",
+			},
+		},
+		{
+			// PostgreSQL 14, an error with the query that caused it
+			input: "2010-06-21 08:04:05.000 PDT,\"app\",\"shop\",4243,\"[local]\",5c1a2b3d.1093,2,\"INSERT\",2010-06-21 08:00:00 PDT,4/12,731,ERROR,23505,\"duplicate key value violates unique constraint \"\"orders_pkey\"\"\",\"Key (id)=(1) already exists.\",,,,,\"INSERT INTO orders VALUES (1)\",,,\"\",\"client backend\",,-4817165219283574214",
+			ts:    time.Date(2010, 6, 21, 15, 4, 5, 0, time.UTC),
+			expected: map[string]interface{}{
+				"user_name":              "app",
+				"database_name":          "shop",
+				"process_id":             int64(4243),
+				"connection_from":        "[local]",
+				"session_id":             "5c1a2b3d.1093",
+				"session_line_num":       int64(2),
+				"command_tag":            "INSERT",
+				"session_start_time":     "2010-06-21 08:00:00 PDT",
+				"virtual_transaction_id": "4/12",
+				"transaction_id":         int64(731),
+				"error_severity":         "ERROR",
+				"sql_state_code":         "23505",
+				"message":                "duplicate key value violates unique constraint \"orders_pkey\"",
+				"detail":                 "Key (id)=(1) already exists.",
+				"query":                  "INSERT INTO orders VALUES (1)",
+				"backend_type":           "client backend",
+				"query_id":               int64(-4817165219283574214),
+			},
+		},
+		{
+			// log_duration without the statement, and a prepared statement
+			input: "2010-06-21 15:04:05 UTC,\"app\",\"shop\",4244,,5c1a2b3e.1094,1,,,,,LOG,00000,\"duration: 0.050 ms  execute <unnamed>: SELECT $1\",\"parameters: $1 = '1'\",,,,,,,,",
+			ts:    time.Date(2010, 6, 21, 15, 4, 5, 0, time.UTC),
+			expected: map[string]interface{}{
+				"user_name":        "app",
+				"database_name":    "shop",
+				"process_id":       int64(4244),
+				"session_id":       "5c1a2b3e.1094",
+				"session_line_num": int64(1),
+				"error_severity":   "LOG",
+				"sql_state_code":   "00000",
+				"message":          "duration: 0.050 ms  execute <unnamed>: SELECT $1",
+				"detail":           "parameters: $1 = '1'",
+				"duration_ms":      0.05,
+				"statement":        "SELECT $1",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		res, ts, err := lp.ParseLine(tc.input)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tc.input, err)
+			continue
+		}
+		if !ts.Equal(tc.ts) {
+			t.Errorf("expected timestamp %s, got %s", tc.ts, ts)
+		}
+		if !reflect.DeepEqual(res, tc.expected) {
+			t.Errorf("expected\n%v\ngot\n%v", tc.expected, res)
+		}
+	}
+
+	for _, bad := range []string{
+		"not a csvlog line",
+		"2010-06-21 15:04:05 UTC,app,shop",
+	} {
+		if _, _, err := lp.ParseLine(bad); err == nil {
+			t.Errorf("expected an error parsing %q", bad)
+		}
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{}
+	if err := p.Init(&Options{TimeZone: "UTC", NumParsers: 2}); err != nil {
+		t.Fatal(err)
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		for _, line := range []string{
+			`2010-06-21 15:04:05 UTC,"app","shop",1,,s.1,1,,,,,LOG,00000,"statement: SELECT 1,`,
+			``,
+			`  2",,,,,,,,,""`,
+			`2010-06-21 15:04:06 UTC,"app","shop",1,,s.1,2,,,,,LOG,00000,"disconnection",,,,,,,,,""`,
+		} {
+			lines <- line
+		}
+		close(lines)
+	}()
+	go func() {
+		p.ProcessLines(lines, send, nil)
+		close(send)
+	}()
+	var statements []string
+	for ev := range send {
+		if s, ok := ev.Data["statement"].(string); ok {
+			statements = append(statements, s)
+		} else if ev.Data["message"] != "disconnection" {
+			t.Errorf("unexpected event %v", ev.Data)
+		}
+	}
+	if !reflect.DeepEqual(statements, []string{"SELECT 1,\n\n  2"}) {
+		t.Errorf("expected the statement's lines to be joined, got %q", statements)
+	}
+}
@@ -0,0 +1,306 @@
+// Package phpfpm parses PHP-FPM's slow log and its error log.
+//
+// Each slow log entry spans several lines, with a stack trace of the
+// request that was running slowly:
+//
+//	[21-Jun-2010 15:04:05]  [pool www] pid 12345
+//	script_filename = /var/www/index.php
+//	[0x00007f3c8a0d2f30] curl_exec() /var/www/lib/http.php:42
+//	[0x00007f3c8a0d2e10] fetch() /var/www/index.php:10
+//
+// and becomes a single event with the pool, pid and script, the function
+// running at the top of the stack, and the whole stack as trace.
+//
+// Error log entries look like
+//
+//	[21-Jun-2010 15:04:05] WARNING: [pool www] child 12345, script '/var/www/index.php' (request: "GET /index.php") executing too slow (5.123 sec), logging
+//
+// and are split into the level, pool and message. Messages about children
+// being slow, timing out, starting and exiting, and what they wrote to
+// stderr, are split further and given an event field, such as slow or
+// exited.
+//
+// Lines are gathered until the next entry starts, a blank line ends a slow
+// log entry, or no lines have arrived for the flush timeout.
+package phpfpm
+
+import (
+	"errors"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	levelKey    = "level"
+	poolKey     = "pool"
+	pidKey      = "pid"
+	scriptKey   = "script"
+	functionKey = "function"
+	traceKey    = "trace"
+	messageKey  = "message"
+	eventKey    = "event"
+
+	// slowRequest is the event of a slow log entry
+	slowRequest = "slow_request"
+
+	timeLayout = "02-Jan-2006 15:04:05.999999999"
+	// maxLines is the most lines gathered into one entry
+	maxLines = 500
+)
+
+var (
+	// slowRegex matches the line starting a slow log entry
+	slowRegex = regexp.MustCompile(`^\[(\d{2}-\w{3}-\d{4} \d{2}:\d{2}:\d{2}(?:\.\d+)?)\]\s+\[pool ([^\]]+)\] pid (\d+)$`)
+	// errorRegex matches an error log entry: the time, level, the pool if
+	// the entry is about one, and the message
+	errorRegex = regexp.MustCompile(`^\[(\d{2}-\w{3}-\d{4} \d{2}:\d{2}:\d{2}(?:\.\d+)?)\] (\w+): (?:\[pool ([^\]]+)\] )?(.*)$`)
+	// scriptRegex matches the script in a slow log entry
+	scriptRegex = regexp.MustCompile(`^script_filename = (.*)$`)
+	// frameRegex matches a frame of a slow log entry's stack trace
+	frameRegex = regexp.MustCompile(`^\[0x[0-9a-f]+\] (\S+)\(\) `)
+)
+
+// messages are the error log messages split into fields, and the name of
+// each event
+var messages = []struct {
+	event string
+	re    *regexp.Regexp
+}{
+	{"slow", regexp.MustCompile(`^child (?P<pid>\d+), script '(?P<script>[^']*)' \(request: "(?P<request>[^"]*)"\) executing too slow \((?P<duration_s>[\d.]+) sec\), logging$`)},
+	{"timeout", regexp.MustCompile(`^child (?P<pid>\d+), script '(?P<script>[^']*)' \(request: "(?P<request>[^"]*)"\) execution timed out \((?P<duration_s>[\d.]+) sec\), terminating$`)},
+	{"started", regexp.MustCompile(`^child (?P<pid>\d+) started$`)},
+	{"exited", regexp.MustCompile(`^child (?P<pid>\d+) exited (?:with code (?P<exit_code>\d+)|on signal (?P<signal>\d+) \((?P<signal_name>\w+)(?: - core dumped)?\)) after (?P<uptime_s>[\d.]+) seconds from start$`)},
+	{"output", regexp.MustCompile(`^child (?P<pid>\d+) said into (?P<stream>stderr|stdout): "(?P<message>(?s:.*?))"(?:, pipe is closed)?$`)},
+	{"max_children_reached", regexp.MustCompile(`^server reached (?:pm\.)?max_children setting \((?P<max_children>\d+)\), consider raising it$`)},
+}
+
+// intFields and floatFields are the message fields that are numbers
+var (
+	intFields   = map[string]bool{pidKey: true, "exit_code": true, "signal": true, "max_children": true}
+	floatFields = map[string]bool{"duration_s": true, "uptime_s": true}
+)
+
+type Options struct {
+	TimeZone       string `long:"timezone" description:"IANA name of the time zone the log was written in, eg America/Los_Angeles. Defaults to the local time zone"`
+	FlushTimeoutMs uint   `long:"flush_timeout_ms" description:"Send an entry once no lines have arrived for this long, rather than waiting for the next entry to start" default:"1000"`
+}
+
+type Parser struct {
+	// set SampleRate to cause the parser to drop entries after they're
+	// gathered, before they're sent
+	SampleRate int
+
+	conf  Options
+	loc   *time.Location
+	nower Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+// entry is a log entry being gathered
+type entry struct {
+	ts           time.Time
+	slow         bool
+	data         map[string]interface{}
+	lines        []string
+	prefixFields map[string]string
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	p.loc = time.Local
+	if p.conf.TimeZone != "" {
+		var err error
+		if p.loc, err = time.LoadLocation(p.conf.TimeZone); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseStart parses the line starting an entry, returning an error if line
+// doesn't start one
+func (p *Parser) parseStart(line string) (*entry, error) {
+	if m := slowRegex.FindStringSubmatch(line); m != nil {
+		ts, err := time.ParseInLocation(timeLayout, m[1], p.loc)
+		if err != nil {
+			return nil, err
+		}
+		pid, _ := strconv.Atoi(m[3])
+		return &entry{
+			ts:   ts.UTC(),
+			slow: true,
+			data: map[string]interface{}{
+				eventKey: slowRequest,
+				poolKey:  m[2],
+				pidKey:   pid,
+			},
+		}, nil
+	}
+	if m := errorRegex.FindStringSubmatch(line); m != nil {
+		ts, err := time.ParseInLocation(timeLayout, m[1], p.loc)
+		if err != nil {
+			return nil, err
+		}
+		e := &entry{
+			ts: ts.UTC(),
+			data: map[string]interface{}{
+				levelKey: strings.ToLower(m[2]),
+			},
+			lines: []string{m[4]},
+		}
+		if m[3] != "" {
+			e.data[poolKey] = m[3]
+		}
+		return e, nil
+	}
+	return nil, errors.New("line doesn't start a php-fpm log entry")
+}
+
+// parseSlow adds the script and stack trace of a slow log entry to its data
+func parseSlow(e *entry) {
+	var trace []string
+	for _, line := range e.lines {
+		if m := scriptRegex.FindStringSubmatch(line); m != nil {
+			e.data[scriptKey] = m[1]
+			continue
+		}
+		if m := frameRegex.FindStringSubmatch(line); m != nil && len(trace) == 0 {
+			e.data[functionKey] = m[1]
+		}
+		trace = append(trace, line)
+	}
+	if len(trace) > 0 {
+		e.data[traceKey] = strings.Join(trace, "\n")
+	}
+}
+
+// parseMessage adds the fields of an error log entry's message to its data
+func parseMessage(e *entry) {
+	msg := strings.TrimSpace(strings.Join(e.lines, "\n"))
+	for _, m := range messages {
+		match := m.re.FindStringSubmatch(msg)
+		if match == nil {
+			continue
+		}
+		e.data[eventKey] = m.event
+		for i, name := range m.re.SubexpNames() {
+			if i == 0 || match[i] == "" {
+				continue
+			}
+			switch {
+			case intFields[name]:
+				if n, err := strconv.Atoi(match[i]); err == nil {
+					e.data[name] = n
+				}
+			case floatFields[name]:
+				if f, err := strconv.ParseFloat(match[i], 64); err == nil {
+					e.data[name] = f
+				}
+			default:
+				e.data[name] = match[i]
+			}
+		}
+		return
+	}
+	e.data[messageKey] = msg
+}
+
+// ProcessLines gathers the lines of each entry and parses it in a single
+// goroutine. Entries are sampled here rather than while tailing, which would
+// separate their lines.
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	var current *entry
+	flush := func() {
+		if current == nil {
+			return
+		}
+		// if sampling is disabled or sampler says keep, pass along this entry.
+		if p.SampleRate <= 1 || rand.Intn(p.SampleRate) == 0 {
+			if current.slow {
+				parseSlow(current)
+			} else {
+				parseMessage(current)
+			}
+			for k, v := range current.prefixFields {
+				current.data[k] = v
+			}
+			send <- event.Event{
+				Timestamp:  current.ts,
+				SampleRate: p.SampleRate,
+				Data:       current.data,
+			}
+		}
+		current = nil
+	}
+	timeout := time.Duration(p.conf.FlushTimeoutMs) * time.Millisecond
+	timer := time.NewTimer(timeout)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				flush()
+				timer.Stop()
+				logrus.Debug("lines channel is closed, ending phpfpm processor")
+				return
+			}
+			logrus.WithFields(logrus.Fields{
+				"line": line,
+			}).Debug("Attempting to process phpfpm log line")
+
+			// take care of any headers on the line
+			var prefixFields map[string]string
+			if prefixRegex != nil {
+				var prefix string
+				prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+				line = strings.TrimPrefix(line, prefix)
+			}
+			if e, err := p.parseStart(line); err == nil {
+				flush()
+				e.prefixFields = prefixFields
+				current = e
+			} else if strings.TrimSpace(line) == "" {
+				// slow log entries are followed by a blank line
+				if current != nil && current.slow {
+					flush()
+				}
+			} else if current != nil {
+				if len(current.lines) < maxLines {
+					current.lines = append(current.lines, line)
+				}
+			} else {
+				logrus.WithFields(logrus.Fields{
+					"line":  line,
+					"error": err,
+				}).Debug("skipping line; failed to parse.")
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(timeout)
+		case <-timer.C:
+			flush()
+			timer.Reset(timeout)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package phpfpm
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{}
+	if err := p.Init(&Options{TimeZone: "UTC", FlushTimeoutMs: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	input := []string{
+		"[21-Jun-2010 15:04:05]  [pool www] pid 12345",
+		"script_filename = /var/www/index.php",
+		"[0x00007f3c8a0d2f30] curl_exec() /var/www/lib/http.php:42",
+		"[0x00007f3c8a0d2e10] fetch() /var/www/index.php:10",
+		"",
+		"[21-Jun-2010 15:04:05] WARNING: [pool www] child 12345, script '/var/www/index.php' (request: \"GET /index.php\") executing too slow (5.123 sec), logging",
+		"[21-Jun-2010 15:04:06] WARNING: [pool www] child 12345, script '/var/www/index.php' (request: \"GET /index.php\") execution timed out (30.001 sec), terminating",
+		"[21-Jun-2010 15:04:06] WARNING: [pool www] child 12345 exited on signal 15 (SIGTERM) after 312.5 seconds from start",
+		"[21-Jun-2010 15:04:06] NOTICE: [pool www] child 12400 started",
+		"[21-Jun-2010 15:04:07] WARNING: [pool www] child 12400 said into stderr: \"PHP message: PHP Warning:  Undefined variable $x in /var/www/index.php on line 3\"",
+		"[21-Jun-2010 15:04:08] WARNING: [pool www] server reached pm.max_children setting (5), consider raising it",
+		"[21-Jun-2010 15:04:09.123456] NOTICE: fpm is running, pid 1",
+	}
+	expected := []event.Event{
+		{
+			Timestamp: time.Date(2010, 6, 21, 15, 4, 5, 0, time.UTC),
+			Data: map[string]interface{}{
+				"event":    "slow_request",
+				"pool":     "www",
+				"pid":      12345,
+				"script":   "/var/www/index.php",
+				"function": "curl_exec",
+				"trace":    "[0x00007f3c8a0d2f30] curl_exec() /var/www/lib/http.php:42\n[0x00007f3c8a0d2e10] fetch() /var/www/index.php:10",
+			},
+		},
+		{
+			Timestamp: time.Date(2010, 6, 21, 15, 4, 5, 0, time.UTC),
+			Data: map[string]interface{}{
+				"level":      "warning",
+				"pool":       "www",
+				"event":      "slow",
+				"pid":        12345,
+				"script":     "/var/www/index.php",
+				"request":    "GET /index.php",
+				"duration_s": 5.123,
+			},
+		},
+		{
+			Timestamp: time.Date(2010, 6, 21, 15, 4, 6, 0, time.UTC),
+			Data: map[string]interface{}{
+				"level":      "warning",
+				"pool":       "www",
+				"event":      "timeout",
+				"pid":        12345,
+				"script":     "/var/www/index.php",
+				"request":    "GET /index.php",
+				"duration_s": 30.001,
+			},
+		},
+		{
+			Timestamp: time.Date(2010, 6, 21, 15, 4, 6, 0, time.UTC),
+			Data: map[string]interface{}{
+				"level":       "warning",
+				"pool":        "www",
+				"event":       "exited",
+				"pid":         12345,
+				"signal":      15,
+				"signal_name": "SIGTERM",
+				"uptime_s":    312.5,
+			},
+		},
+		{
+			Timestamp: time.Date(2010, 6, 21, 15, 4, 6, 0, time.UTC),
+			Data: map[string]interface{}{
+				"level": "notice",
+				"pool":  "www",
+				"event": "started",
+				"pid":   12400,
+			},
+		},
+		{
+			Timestamp: time.Date(2010, 6, 21, 15, 4, 7, 0, time.UTC),
+			Data: map[string]interface{}{
+				"level":   "warning",
+				"pool":    "www",
+				"event":   "output",
+				"pid":     12400,
+				"stream":  "stderr",
+				"message": "PHP message: PHP Warning:  Undefined variable $x in /var/www/index.php on line 3",
+			},
+		},
+		{
+			Timestamp: time.Date(2010, 6, 21, 15, 4, 8, 0, time.UTC),
+			Data: map[string]interface{}{
+				"level":        "warning",
+				"pool":         "www",
+				"event":        "max_children_reached",
+				"max_children": 5,
+			},
+		},
+		{
+			Timestamp: time.Date(2010, 6, 21, 15, 4, 9, 123456000, time.UTC),
+			Data: map[string]interface{}{
+				"level":   "notice",
+				"message": "fpm is running, pid 1",
+			},
+		},
+	}
+
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		for _, line := range input {
+			lines <- line
+		}
+		close(lines)
+	}()
+	go func() {
+		p.ProcessLines(lines, send, nil)
+		close(send)
+	}()
+	var got []event.Event
+	for ev := range send {
+		got = append(got, ev)
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d events, got %d: %+v", len(expected), len(got), got)
+	}
+	for i := range expected {
+		if !got[i].Timestamp.Equal(expected[i].Timestamp) {
+			t.Errorf("event %d: expected timestamp %s, got %s", i, expected[i].Timestamp, got[i].Timestamp)
+		}
+		if !reflect.DeepEqual(got[i].Data, expected[i].Data) {
+			t.Errorf("event %d: expected\n%v\ngot\n%v", i, expected[i].Data, got[i].Data)
+		}
+	}
+}
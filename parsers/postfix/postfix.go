@@ -0,0 +1,384 @@
+// Package postfix parses the mail logs written by Postfix and sendmail
+// through syslog.
+//
+// Each line logged about a message carries its queue id, and the lines for
+// a message are written by several processes, interleaved with the lines
+// for other messages. Every line becomes an event with a queue_id field, so
+// they can be grouped in Honeycomb; in addition, the sender, size and client
+// logged when a message is queued are remembered and added to each of its
+// delivery attempts, so delivery latency can be broken down by sender.
+package postfix
+
+import (
+	"errors"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	hostnameKey = "hostname"
+	processKey  = "process"
+	pidKey      = "pid"
+	queueIDKey  = "queue_id"
+	messageKey  = "message"
+	statusKey   = "status"
+
+	// syslog timestamps have no year
+	timeLayout = "Jan _2 15:04:05"
+	// timestamps further than this into the future are taken to be from the
+	// year before, eg a December line read in January
+	maxFutureSkew = 7 * 24 * time.Hour
+	// maxQueued is the most messages whose details are remembered. Messages
+	// are forgotten once they're removed from the queue, so this is only
+	// reached when the removals are missed.
+	maxQueued = 100000
+)
+
+// lineRegex matches the syslog header, with either a traditional or an
+// RFC3339 timestamp, and the process that wrote the line
+var lineRegex = regexp.MustCompile(`^(\w{3} [ \d]\d \d{2}:\d{2}:\d{2}|\d{4}-\d{2}-\d{2}T\S+) (\S+) ([^\[:\s]+)(?:\[(\d+)\])?: (.*)$`)
+
+// queueIDRegex matches the queue id starting the message, in Postfix's short
+// or long form or sendmail's
+var queueIDRegex = regexp.MustCompile(`^([0-9A-Za-z]{6,20}|NOQUEUE): (.*)$`)
+
+// endpointRegex splits relay and client values, eg mx.example.com[1.2.3.4]:25
+// or sendmail's "mx.example.com [1.2.3.4]"
+var endpointRegex = regexp.MustCompile(`^(\S*?) ?\[([^\]]*)\](?::(\d+))?`)
+
+// keyNames renames sendmail's keys to the Postfix equivalent, and keys with
+// dashes to underscores
+var keyNames = map[string]string{
+	"stat":       statusKey,
+	"msgid":      "message_id",
+	"message-id": "message_id",
+	"nrcpts":     "nrcpt",
+}
+
+// addressKeys hold email addresses
+var addressKeys = map[string]bool{"from": true, "to": true, "orig_to": true}
+
+// queuedKeys are remembered from the lines logged when a message is queued
+// and added to the events for its delivery attempts
+var queuedKeys = []string{"from", "size", "nrcpt", "message_id", "client_host", "client_ip"}
+
+// delayComponents name the parts of Postfix's delays=a/b/c/d
+var delayComponents = []string{"delay_before_qmgr", "delay_in_qmgr", "delay_conn_setup", "delay_transmission"}
+
+type Options struct {
+	TimeZone string `long:"timezone" description:"IANA name of the time zone the log was written in, eg America/Los_Angeles. Defaults to the local time zone"`
+}
+
+type Parser struct {
+	// set SampleRate to cause the parser to drop events after the details
+	// of their message are added, before they're sent
+	SampleRate int
+
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, time.Time, error)
+}
+
+type MailLineParser struct {
+	loc   *time.Location
+	nower Nower
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	loc := time.Local
+	if p.conf.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(p.conf.TimeZone); err != nil {
+			return err
+		}
+	}
+	p.lineParser = &MailLineParser{loc: loc, nower: p.nower}
+	return nil
+}
+
+// ParseLine parses a syslog line written by an MTA into its header fields,
+// queue id and the key=value pairs it logged. Lines that aren't key=value
+// pairs, such as rejections and warnings, are kept as the message field.
+func (m *MailLineParser) ParseLine(line string) (map[string]interface{}, time.Time, error) {
+	match := lineRegex.FindStringSubmatch(line)
+	if match == nil {
+		return nil, time.Time{}, errors.New("line is not a syslog line")
+	}
+	ts, err := m.parseTime(match[1])
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	parsed := map[string]interface{}{
+		hostnameKey: match[2],
+		processKey:  match[3],
+	}
+	if pid, err := strconv.Atoi(match[4]); err == nil {
+		parsed[pidKey] = pid
+	}
+	msg := match[5]
+	if q := queueIDRegex.FindStringSubmatch(msg); q != nil {
+		parsed[queueIDKey] = q[1]
+		msg = q[2]
+	}
+	if !parseKeyVals(msg, parsed) {
+		parsed[messageKey] = msg
+	}
+	return parsed, ts, nil
+}
+
+// parseKeyVals parses the comma separated key=value pairs in msg into
+// parsed, returning false if msg isn't made of them. The status is always
+// last and may include commas in its explanation, so it takes the rest of
+// the line.
+func parseKeyVals(msg string, parsed map[string]interface{}) bool {
+	vals := make(map[string]interface{})
+	for msg != "" {
+		eq := strings.Index(msg, "=")
+		if eq <= 0 || strings.ContainsAny(msg[:eq], " ,") {
+			return false
+		}
+		key := strings.Replace(msg[:eq], "-", "_", -1)
+		if k, ok := keyNames[msg[:eq]]; ok {
+			key = k
+		}
+		msg = msg[eq+1:]
+		var val string
+		switch {
+		case key == statusKey:
+			val, msg = msg, ""
+		default:
+			end := strings.Index(msg, ", ")
+			if end < 0 {
+				end = len(msg)
+			}
+			val, msg = msg[:end], strings.TrimPrefix(msg[end:], ", ")
+		}
+		// addresses are in angle brackets, each of them when sendmail lists
+		// several recipients
+		switch {
+		case addressKeys[key]:
+			val = strings.NewReplacer("<", "", ">", "").Replace(val)
+		case strings.HasPrefix(val, "<") && strings.HasSuffix(val, ">"):
+			val = val[1 : len(val)-1]
+		}
+		addKeyVal(key, val, vals)
+	}
+	if len(vals) == 0 {
+		return false
+	}
+	for k, v := range vals {
+		parsed[k] = v
+	}
+	return true
+}
+
+// addKeyVal adds a key=value pair, splitting and typing the values that
+// describe timing, size and the hosts involved
+func addKeyVal(key, val string, vals map[string]interface{}) {
+	switch key {
+	case statusKey:
+		// eg sent (250 2.0.0 OK)
+		if i := strings.Index(val, " ("); i >= 0 && strings.HasSuffix(val, ")") {
+			vals[statusKey+"_detail"] = val[i+2 : len(val)-1]
+			val = val[:i]
+		}
+		vals[statusKey] = strings.ToLower(val)
+		return
+	case "delay", "xdelay":
+		if d, ok := parseDelay(val); ok {
+			vals[key] = d
+			return
+		}
+	case "delays":
+		parts := strings.Split(val, "/")
+		if len(parts) == len(delayComponents) {
+			for i, part := range parts {
+				if d, err := strconv.ParseFloat(part, 64); err == nil {
+					vals[delayComponents[i]] = d
+				}
+			}
+			return
+		}
+	case "size", "nrcpt", "pri", "class":
+		// eg nrcpt=1 (queue active)
+		if i := strings.Index(val, " "); i >= 0 {
+			val = val[:i]
+		}
+		if n, err := strconv.Atoi(val); err == nil {
+			vals[key] = n
+			return
+		}
+	case "relay", "client":
+		if m := endpointRegex.FindStringSubmatch(val); m != nil {
+			if m[1] != "" {
+				vals[key+"_host"] = m[1]
+			}
+			if m[2] != "" {
+				vals[key+"_ip"] = m[2]
+			}
+			if port, err := strconv.Atoi(m[3]); err == nil {
+				vals[key+"_port"] = port
+			}
+		}
+	}
+	vals[key] = val
+}
+
+// parseDelay parses a delay in seconds, as Postfix writes it, or as
+// sendmail's [days+]HH:MM:SS
+func parseDelay(s string) (float64, bool) {
+	if d, err := strconv.ParseFloat(s, 64); err == nil {
+		return d, true
+	}
+	var days int
+	if i := strings.Index(s, "+"); i >= 0 {
+		n, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return 0, false
+		}
+		days, s = n, s[i+1:]
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	var secs int
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, false
+		}
+		secs = secs*60 + n
+	}
+	return float64(days*86400 + secs), true
+}
+
+// parseTime parses either timestamp format, filling in the year missing from
+// traditional syslog timestamps. The current year is assumed unless that
+// puts the line well into the future, in which case it must have been
+// written last year.
+func (m *MailLineParser) parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t.UTC(), nil
+	}
+	now := m.nower.Now().In(m.loc)
+	t, err := time.ParseInLocation(timeLayout, s, m.loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	ts := time.Date(now.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, m.loc)
+	if ts.Sub(now) > maxFutureSkew {
+		ts = ts.AddDate(-1, 0, 0)
+	}
+	return ts.UTC(), nil
+}
+
+// ProcessLines parses lines in a single goroutine, as the details of each
+// message must be remembered from the lines logged before its deliveries.
+// For the same reason, lines are sampled here rather than while tailing.
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	queued := make(map[string]map[string]interface{})
+	for line := range lines {
+		logrus.WithFields(logrus.Fields{
+			"line": line,
+		}).Debug("Attempting to process mail log line")
+
+		// take care of any headers on the line
+		var prefixFields map[string]string
+		if prefixRegex != nil {
+			var prefix string
+			prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+			line = strings.TrimPrefix(line, prefix)
+		}
+
+		parsedLine, ts, err := p.lineParser.ParseLine(line)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"line":  line,
+				"error": err,
+			}).Debug("skipping line; failed to parse.")
+			continue
+		}
+		remember(queued, parsedLine)
+		// if sampling is disabled or sampler says keep, pass along this event.
+		if p.SampleRate > 1 && rand.Intn(p.SampleRate) != 0 {
+			continue
+		}
+		// merge the prefix fields and the parsed line contents
+		for k, v := range prefixFields {
+			parsedLine[k] = v
+		}
+
+		send <- event.Event{
+			Timestamp:  ts,
+			SampleRate: p.SampleRate,
+			Data:       parsedLine,
+		}
+	}
+	logrus.Debug("lines channel is closed, ending postfix processor")
+}
+
+// remember records the details of a message as it's queued, adds them to
+// its delivery attempts, and forgets them once it's removed from the queue
+func remember(queued map[string]map[string]interface{}, parsed map[string]interface{}) {
+	id, ok := parsed[queueIDKey].(string)
+	if !ok || id == "NOQUEUE" {
+		return
+	}
+	if parsed[messageKey] == "removed" {
+		delete(queued, id)
+		return
+	}
+	details, ok := queued[id]
+	if _, delivery := parsed[statusKey]; delivery {
+		for k, v := range details {
+			if _, ok := parsed[k]; !ok {
+				parsed[k] = v
+			}
+		}
+		return
+	}
+	for _, k := range queuedKeys {
+		v, found := parsed[k]
+		if !found {
+			continue
+		}
+		if !ok {
+			if len(queued) >= maxQueued {
+				// forget an arbitrary message to make room
+				for old := range queued {
+					delete(queued, old)
+					break
+				}
+			}
+			details = make(map[string]interface{})
+			queued[id] = details
+			ok = true
+		}
+		details[k] = v
+	}
+}
@@ -0,0 +1,151 @@
+package postfix
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+func TestParseLine(t *testing.T) {
+	lp := &MailLineParser{loc: time.UTC, nower: &FakeNower{}}
+	testCases := []struct {
+		input    string
+		ts       time.Time
+		expected map[string]interface{}
+	}{
+		{
+			input: "Jun 21 12:00:00 mail postfix/smtp[1234]: 3F1A2B4C5D: to=<bob@example.com>, relay=mx.example.com[192.0.2.1]:25, delay=0.58, delays=0.1/0.01/0.2/0.27, dsn=2.0.0, status=sent (250 2.0.0 Ok: queued as 9C2, thanks)",
+			ts:    time.Date(2010, 6, 21, 12, 0, 0, 0, time.UTC),
+			expected: map[string]interface{}{
+				"hostname":           "mail",
+				"process":            "postfix/smtp",
+				"pid":                1234,
+				"queue_id":           "3F1A2B4C5D",
+				"to":                 "bob@example.com",
+				"relay":              "mx.example.com[192.0.2.1]:25",
+				"relay_host":         "mx.example.com",
+				"relay_ip":           "192.0.2.1",
+				"relay_port":         25,
+				"delay":              0.58,
+				"delay_before_qmgr":  0.1,
+				"delay_in_qmgr":      0.01,
+				"delay_conn_setup":   0.2,
+				"delay_transmission": 0.27,
+				"dsn":                "2.0.0",
+				"status":             "sent",
+				"status_detail":      "250 2.0.0 Ok: queued as 9C2, thanks",
+			},
+		},
+		{
+			input: "2010-06-21T12:00:01.5+02:00 mail postfix/smtpd[99]: NOQUEUE: reject: RCPT from unknown[192.0.2.9]: 554 5.7.1 Relay access denied",
+			ts:    time.Date(2010, 6, 21, 10, 0, 1, 500000000, time.UTC),
+			expected: map[string]interface{}{
+				"hostname": "mail",
+				"process":  "postfix/smtpd",
+				"pid":      99,
+				"queue_id": "NOQUEUE",
+				"message":  "reject: RCPT from unknown[192.0.2.9]: 554 5.7.1 Relay access denied",
+			},
+		},
+		{
+			// sendmail, with its key names and delay format. December read
+			// in June is last year's.
+			input: "Dec  1 09:00:00 relay sendmail[42]: x07C0AbC012345: to=<a@example.com>,<b@example.com>, ctladdr=<root@relay> (0/0), delay=1+00:00:03, xdelay=00:00:01, mailer=esmtp, pri=120, relay=mx.example.com. [192.0.2.2], dsn=2.0.0, stat=Sent (OK id=1)",
+			ts:    time.Date(2009, 12, 1, 9, 0, 0, 0, time.UTC),
+			expected: map[string]interface{}{
+				"hostname":      "relay",
+				"process":       "sendmail",
+				"pid":           42,
+				"queue_id":      "x07C0AbC012345",
+				"to":            "a@example.com,b@example.com",
+				"ctladdr":       "<root@relay> (0/0)",
+				"delay":         float64(86403),
+				"xdelay":        float64(1),
+				"mailer":        "esmtp",
+				"pri":           120,
+				"relay":         "mx.example.com. [192.0.2.2]",
+				"relay_host":    "mx.example.com.",
+				"relay_ip":      "192.0.2.2",
+				"dsn":           "2.0.0",
+				"status":        "sent",
+				"status_detail": "OK id=1",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		resp, ts, err := lp.ParseLine(tc.input)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tc.input, err)
+			continue
+		}
+		if !ts.Equal(tc.ts) {
+			t.Errorf("got timestamp %s, expected %s", ts, tc.ts)
+		}
+		if !reflect.DeepEqual(resp, tc.expected) {
+			t.Errorf("response %+v didn't match expected %+v", resp, tc.expected)
+		}
+	}
+	if _, _, err := lp.ParseLine("not a syslog line"); err == nil {
+		t.Error("expected error for a line that isn't syslog")
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{}
+	if err := p.Init(&Options{TimeZone: "UTC"}); err != nil {
+		t.Fatal(err)
+	}
+	p.nower = &FakeNower{}
+	p.lineParser = &MailLineParser{loc: time.UTC, nower: p.nower}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		for _, l := range []string{
+			"Jun 21 12:00:00 mail postfix/smtpd[1]: 3F1A2B4C5D: client=client.example.com[192.0.2.5]",
+			"Jun 21 12:00:00 mail postfix/qmgr[2]: 3F1A2B4C5D: from=<alice@example.com>, size=2048, nrcpt=1 (queue active)",
+			"Jun 21 12:00:01 mail postfix/smtp[3]: 3F1A2B4C5D: to=<bob@example.com>, relay=none, delay=1, status=deferred (connection timed out)",
+			"Jun 21 12:00:02 mail postfix/qmgr[2]: 3F1A2B4C5D: removed",
+			"Jun 21 12:00:03 mail postfix/smtp[3]: 3F1A2B4C5D: to=<bob@example.com>, status=sent (250 ok)",
+		} {
+			lines <- l
+		}
+		close(lines)
+	}()
+	go func() {
+		p.ProcessLines(lines, send, nil)
+		close(send)
+	}()
+	var events []event.Event
+	for ev := range send {
+		events = append(events, ev)
+	}
+	if len(events) != 5 {
+		t.Fatalf("expected 5 events, got %+v", events)
+	}
+	deferred := events[2].Data
+	for k, v := range map[string]interface{}{
+		"from":        "alice@example.com",
+		"size":        2048,
+		"nrcpt":       1,
+		"client_host": "client.example.com",
+		"client_ip":   "192.0.2.5",
+		"status":      "deferred",
+	} {
+		if !reflect.DeepEqual(deferred[k], v) {
+			t.Errorf("expected %s=%v on the delivery event, got %v", k, v, deferred[k])
+		}
+	}
+	// once the message is removed its details are forgotten
+	if _, ok := events[4].Data["from"]; ok {
+		t.Errorf("expected no sender after removal, got %+v", events[4].Data)
+	}
+}
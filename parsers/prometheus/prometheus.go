@@ -0,0 +1,289 @@
+// Package prometheus parses the Prometheus text exposition format, as
+// scraped from a /metrics endpoint or written to node_exporter's textfile
+// directory, eg
+//
+//	# TYPE http_requests_total counter
+//	http_requests_total{method="post",code="200"} 1027 1714578000000
+//
+// Each sample becomes an event with the metric's name and value, its type
+// from the # TYPE line for its family if there was one, and a field for each
+// label. Labels that would replace those fields are prefixed with label_.
+// Samples without a timestamp are given the time they were read.
+//
+// Samples whose value is NaN or infinite are skipped, as they can't be sent.
+package prometheus
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	nameKey  = "name"
+	valueKey = "value"
+	typeKey  = "type"
+
+	// labelPrefix is put in front of labels that would replace the fields
+	// above
+	labelPrefix = "label_"
+)
+
+// familySuffixes are added to a family's name for some of its samples, eg
+// the buckets of a histogram
+var familySuffixes = []string{"_bucket", "_sum", "_count", "_total", "_created", "_info"}
+
+type Options struct {
+	NumParsers int `hidden:"true" description:"number of prometheus parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+// LineParser parses a sample line. The timestamp is zero if the sample has
+// none.
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, time.Time, error)
+}
+
+type PrometheusLineParser struct{}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	p.lineParser = &PrometheusLineParser{}
+	return nil
+}
+
+// ParseLine parses a sample of the form
+// name[{label="value",...}] value [timestamp]
+func (s *PrometheusLineParser) ParseLine(line string) (map[string]interface{}, time.Time, error) {
+	line = strings.TrimSpace(line)
+	nameEnd := strings.IndexAny(line, "{ \t")
+	if nameEnd <= 0 {
+		return nil, time.Time{}, errors.New("line is not a prometheus sample")
+	}
+	parsed := map[string]interface{}{
+		nameKey: line[:nameEnd],
+	}
+	rest := line[nameEnd:]
+	if rest[0] == '{' {
+		var err error
+		rest, err = parseLabels(rest[1:], parsed)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+	// drop any OpenMetrics exemplar
+	if i := strings.Index(rest, " # "); i >= 0 {
+		rest = rest[:i]
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, time.Time{}, errors.New("sample should have a value and an optional timestamp")
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, time.Time{}, errors.New("sample value is not a finite number")
+	}
+	parsed[valueKey] = value
+
+	var ts time.Time
+	if len(fields) == 2 {
+		if ts, err = parseTimestamp(fields[1]); err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+	return parsed, ts, nil
+}
+
+// parseTimestamp parses a sample's timestamp, milliseconds since the epoch
+// in the Prometheus format, or fractional seconds in OpenMetrics
+func parseTimestamp(s string) (time.Time, error) {
+	if strings.Contains(s, ".") {
+		sec, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		whole, frac := math.Modf(sec)
+		return time.Unix(int64(whole), int64(math.Floor(frac*1000+0.5))*int64(time.Millisecond)).UTC(), nil
+	}
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond)).UTC(), nil
+}
+
+// parseLabels adds the labels in s, which follows the opening brace, to
+// parsed, and returns what follows the closing brace
+func parseLabels(s string, parsed map[string]interface{}) (string, error) {
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if strings.HasPrefix(s, "}") {
+			return s[1:], nil
+		}
+		eq := strings.Index(s, "=")
+		if eq <= 0 {
+			return "", errors.New("label has no value")
+		}
+		name := strings.TrimSpace(s[:eq])
+		s = strings.TrimLeft(s[eq+1:], " \t")
+		if !strings.HasPrefix(s, `"`) {
+			return "", errors.New("label value " + name + " is not quoted")
+		}
+		var value bytes.Buffer
+		i := 1
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(s[i])
+				}
+				continue
+			}
+			value.WriteByte(s[i])
+		}
+		if i == len(s) {
+			return "", errors.New("label value " + name + " is unterminated")
+		}
+		if _, ok := parsed[name]; ok || name == valueKey || name == typeKey {
+			name = labelPrefix + name
+		}
+		parsed[name] = value.String()
+		s = strings.TrimLeft(s[i+1:], " \t")
+		if strings.HasPrefix(s, ",") {
+			s = s[1:]
+		}
+	}
+}
+
+// typedLine is a sample line along with the type of its family
+type typedLine struct {
+	line  string
+	mtype string
+}
+
+// familyType returns the type of the family the named metric belongs to,
+// if there was a # TYPE line for it
+func familyType(types map[string]string, name string) string {
+	if t, ok := types[name]; ok {
+		return t
+	}
+	for _, suffix := range familySuffixes {
+		if strings.HasSuffix(name, suffix) {
+			if t, ok := types[strings.TrimSuffix(name, suffix)]; ok {
+				return t
+			}
+		}
+	}
+	return ""
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	// # TYPE lines have to be read in order, so track them here and hand each
+	// sample to the workers along with its family's type
+	toParse := make(chan typedLine)
+	go func() {
+		types := make(map[string]string)
+		for line := range lines {
+			logrus.WithFields(logrus.Fields{
+				"line": line,
+			}).Debug("Attempting to process prometheus line")
+
+			// take care of any headers on the line
+			var prefix string
+			if prefixRegex != nil {
+				prefix, _ = prefixRegex.FindStringSubmatchMap(line)
+			}
+			content := strings.TrimSpace(strings.TrimPrefix(line, prefix))
+			if content == "" {
+				continue
+			}
+			if content[0] == '#' {
+				// eg # TYPE http_requests_total counter
+				if f := strings.Fields(content); len(f) == 4 && f[1] == "TYPE" {
+					types[f[2]] = f[3]
+				}
+				continue
+			}
+			name := content
+			if i := strings.IndexAny(content, "{ \t"); i >= 0 {
+				name = content[:i]
+			}
+			toParse <- typedLine{line: line, mtype: familyType(types, name)}
+		}
+		close(toParse)
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for tl := range toParse {
+				line := tl.line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, timestamp, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				if tl.mtype != "" {
+					parsedLine[typeKey] = tl.mtype
+				}
+				if timestamp.IsZero() {
+					timestamp = p.nower.Now()
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp: timestamp,
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending prometheus processor")
+}
@@ -0,0 +1,144 @@
+package prometheus
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	return time.Date(2017, 5, 1, 10, 0, 0, 0, time.UTC)
+}
+
+type testLineMap struct {
+	line     string
+	expected map[string]interface{}
+	ts       time.Time
+}
+
+var tlms = []testLineMap{
+	{
+		line: `http_requests_total{method="post",code="200"} 1027 1714578000000`,
+		expected: map[string]interface{}{
+			"name":   "http_requests_total",
+			"method": "post",
+			"code":   "200",
+			"value":  1027.0,
+		},
+		ts: time.Date(2024, 5, 1, 15, 40, 0, 0, time.UTC),
+	},
+	{
+		line: `node_load1 0.21`,
+		expected: map[string]interface{}{
+			"name":  "node_load1",
+			"value": 0.21,
+		},
+	},
+	{
+		line: `msdos_file_access_time_seconds{path="C:\\DIR\\FILE.TXT",error="Cannot find file:\n\"FILE.TXT\"",} 1.458255915e9`,
+		expected: map[string]interface{}{
+			"name":  "msdos_file_access_time_seconds",
+			"path":  `C:\DIR\FILE.TXT`,
+			"error": "Cannot find file:\n\"FILE.TXT\"",
+			"value": 1.458255915e9,
+		},
+	},
+	{
+		line: `container_cpu_seconds{name="web",type="app"} 4.5`,
+		expected: map[string]interface{}{
+			"name":       "container_cpu_seconds",
+			"label_name": "web",
+			"label_type": "app",
+			"value":      4.5,
+		},
+	},
+	{
+		line: `rpc_duration_seconds_bucket{le="+Inf"} 17 1714578000.5 # {trace_id="oHg5SJYRHA0"} 0.67 1714577999.9`,
+		expected: map[string]interface{}{
+			"name":  "rpc_duration_seconds_bucket",
+			"le":    "+Inf",
+			"value": 17.0,
+		},
+		ts: time.Date(2024, 5, 1, 15, 40, 0, 500000000, time.UTC),
+	},
+}
+
+func TestParseLine(t *testing.T) {
+	p := &PrometheusLineParser{}
+	for _, tlm := range tlms {
+		resp, ts, err := p.ParseLine(tlm.line)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tlm.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(resp, tlm.expected) {
+			t.Errorf("response from ParseLine(%q)\n\t%+v\ndid not match expected\n\t%+v", tlm.line, resp, tlm.expected)
+		}
+		if !ts.Equal(tlm.ts) {
+			t.Errorf("timestamp from ParseLine(%q) was %s, expected %s", tlm.line, ts, tlm.ts)
+		}
+	}
+}
+
+func TestParseLineErrors(t *testing.T) {
+	p := &PrometheusLineParser{}
+	for _, line := range []string{
+		"",
+		"no_value",
+		`bad_label{a=b} 1`,
+		`open_label{a="b} 1`,
+		"not_a_number abc",
+		"rpc_duration_seconds{quantile=\"0.5\"} NaN",
+		"too_many 1 2 3",
+	} {
+		if _, _, err := p.ParseLine(line); err == nil {
+			t.Errorf("expected an error parsing %q", line)
+		}
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{
+		conf:       Options{NumParsers: 1},
+		lineParser: &PrometheusLineParser{},
+		nower:      &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		for _, line := range []string{
+			"# HELP rpc_duration_seconds RPC latency",
+			"# TYPE rpc_duration_seconds histogram",
+			`rpc_duration_seconds_bucket{le="0.1"} 8`,
+			"rpc_duration_seconds_sum 1.7",
+			"",
+			"up 1",
+		} {
+			lines <- line
+		}
+		close(lines)
+	}()
+	go func() {
+		p.ProcessLines(lines, send, nil)
+		close(send)
+	}()
+	var evs []event.Event
+	for ev := range send {
+		evs = append(evs, ev)
+	}
+	if len(evs) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(evs))
+	}
+	for i, expected := range []interface{}{"histogram", "histogram", nil} {
+		if evs[i].Data["type"] != expected {
+			t.Errorf("event %d had type %v, expected %v", i, evs[i].Data["type"], expected)
+		}
+		if !evs[i].Timestamp.Equal((&FakeNower{}).Now()) {
+			t.Errorf("event %d timestamp %s should have come from the nower", i, evs[i].Timestamp)
+		}
+	}
+}
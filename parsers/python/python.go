@@ -0,0 +1,274 @@
+// Package python parses logs written by Python's logging module.
+//
+// The format string given to logging (or a preset, such as Django's verbose
+// formatter) is turned into a regular expression, so each LogRecord attribute
+// in the format becomes a field. Both %-style and {}-style formats are
+// supported. Lines that don't match the format, such as tracebacks, are
+// folded into the message of the record before them; a record is sent once
+// the next one starts, or the input ends.
+package python
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	asctimeKey      = "asctime"
+	messageKey      = "message"
+	exceptionKey    = "exception"
+	tracebackHeader = "Traceback (most recent call last):"
+)
+
+// presets are commonly used formats that may be given by name
+var presets = map[string]string{
+	// logging.basicConfig's default
+	"basic": "%(levelname)s:%(name)s:%(message)s",
+	// the verbose and simple formatters from Django's logging docs
+	"django":        "{levelname} {asctime} {module} {process:d} {thread:d} {message}",
+	"django_simple": "{levelname} {message}",
+}
+
+// attrPatterns match the values of LogRecord attributes. Attributes not
+// listed match a run of non-space characters.
+var attrPatterns = map[string]string{
+	"asctime":         `\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(?:[,.]\d+)?`,
+	"levelname":       `[A-Z]+`,
+	"levelno":         `\d+`,
+	"lineno":          `\d+`,
+	"process":         `\d+`,
+	"thread":          `\d+`,
+	"created":         `\d+(?:\.\d+)?`,
+	"msecs":           `\d+(?:\.\d+)?`,
+	"relativeCreated": `\d+(?:\.\d+)?`,
+}
+
+// intAttrs and floatAttrs are the numeric attributes
+var (
+	intAttrs   = map[string]bool{"levelno": true, "lineno": true, "process": true, "thread": true}
+	floatAttrs = map[string]bool{"created": true, "msecs": true, "relativeCreated": true}
+)
+
+// placeholder matches a %-style or {}-style attribute in a format, along
+// with escaped % and braces
+var placeholder = regexp.MustCompile(`%%|%\((\w+)\)([-#0 +]*\d*(?:\.\d+)?)[sdfrxXeEgGi]|\{\{|\}\}|\{(\w+)(?::([^}]*))?\}`)
+
+type Options struct {
+	Format string `long:"format" description:"The format string given to Python's logging, in %-style or {}-style, or one of the presets basic, django or django_simple" default:"%(asctime)s %(levelname)s %(name)s %(message)s"`
+}
+
+type Parser struct {
+	// set SampleRate to cause the parser to drop records after they're
+	// assembled, before they're sent
+	SampleRate int
+
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, error)
+}
+
+// FormatLineParser parses lines written with a logging format string
+type FormatLineParser struct {
+	re *regexp.Regexp
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	lp, err := NewFormatLineParser(p.conf.Format)
+	if err != nil {
+		return err
+	}
+	p.lineParser = lp
+	return nil
+}
+
+// NewFormatLineParser builds a parser for lines written with format
+func NewFormatLineParser(format string) (*FormatLineParser, error) {
+	if preset, ok := presets[format]; ok {
+		format = preset
+	}
+	matches := placeholder.FindAllStringSubmatchIndex(format, -1)
+	expr := "^"
+	last := 0
+	seen := make(map[string]bool)
+	for i, m := range matches {
+		expr += regexp.QuoteMeta(format[last:m[0]])
+		last = m[1]
+		var attr, spec string
+		switch {
+		case m[2] >= 0:
+			attr, spec = format[m[2]:m[3]], format[m[4]:m[5]]
+		case m[6] >= 0:
+			attr = format[m[6]:m[7]]
+			if m[8] >= 0 {
+				spec = format[m[8]:m[9]]
+			}
+		default:
+			// an escaped %, { or }
+			expr += regexp.QuoteMeta(format[m[0]+1 : m[1]])
+			continue
+		}
+		if seen[attr] {
+			// the first occurrence is enough to capture it
+			expr += `.*?`
+			continue
+		}
+		seen[attr] = true
+		pattern, ok := attrPatterns[attr]
+		switch {
+		case attr == messageKey && i == len(matches)-1 && last == len(format):
+			pattern = `.*`
+		case attr == messageKey:
+			pattern = `.*?`
+		case !ok:
+			pattern = `\S+`
+		}
+		// fields with a width are padded with spaces
+		if strings.IndexAny(spec, "0123456789") >= 0 {
+			pattern = ` *` + pattern + ` *`
+		}
+		expr += fmt.Sprintf(`(?P<%s>%s)`, attr, pattern)
+	}
+	expr += regexp.QuoteMeta(format[last:]) + "$"
+	if len(seen) == 0 {
+		return nil, fmt.Errorf("format %q has no LogRecord attributes in it", format)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("format %q: %s", format, err)
+	}
+	return &FormatLineParser{re: re}, nil
+}
+
+// ParseLine returns the attributes in a line, or an error if the line doesn't
+// match the format
+func (f *FormatLineParser) ParseLine(line string) (map[string]interface{}, error) {
+	m := f.re.FindStringSubmatch(line)
+	if m == nil {
+		return nil, errors.New("line doesn't match the logging format")
+	}
+	parsed := make(map[string]interface{})
+	for i, name := range f.re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		val := strings.TrimSpace(m[i])
+		if name == messageKey {
+			// leading space in a message is deliberate
+			val = m[i]
+		}
+		switch {
+		case intAttrs[name]:
+			if n, err := strconv.Atoi(val); err == nil {
+				parsed[name] = n
+				continue
+			}
+		case floatAttrs[name]:
+			if n, err := strconv.ParseFloat(val, 64); err == nil {
+				parsed[name] = n
+				continue
+			}
+		}
+		parsed[name] = val
+	}
+	return parsed, nil
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	var record map[string]interface{}
+	var continuation []string
+	for line := range lines {
+		logrus.WithFields(logrus.Fields{
+			"line": line,
+		}).Debug("Attempting to process python log line")
+
+		// the prefix can only be stripped; it's not possible to attribute the
+		// fields of each line's prefix to the event.
+		if prefixRegex != nil {
+			line = strings.TrimPrefix(line, prefixRegex.FindString(line))
+		}
+		parsed, err := p.lineParser.ParseLine(line)
+		if err != nil {
+			if record == nil {
+				logrus.WithField("line", line).Debug("skipping line; doesn't match the format and there's no record to add it to")
+			} else {
+				continuation = append(continuation, line)
+			}
+			continue
+		}
+		if record != nil {
+			p.send(record, continuation, send)
+		}
+		record, continuation = parsed, nil
+	}
+	if record != nil {
+		p.send(record, continuation, send)
+	}
+	logrus.Debug("lines channel is closed, ending python processor")
+}
+
+// send folds any continuation lines into the record's message and sends it
+func (p *Parser) send(record map[string]interface{}, continuation []string, send chan<- event.Event) {
+	// if sampling is disabled or sampler says keep, pass along this record.
+	if p.SampleRate > 1 && rand.Intn(p.SampleRate) != 0 {
+		return
+	}
+	if len(continuation) != 0 {
+		msg, _ := record[messageKey].(string)
+		record[messageKey] = strings.Join(append([]string{msg}, continuation...), "\n")
+		// the last line of a traceback names the exception
+		for _, l := range continuation {
+			if strings.TrimSpace(l) == tracebackHeader {
+				record[exceptionKey] = strings.TrimSpace(continuation[len(continuation)-1])
+				break
+			}
+		}
+	}
+	send <- event.Event{
+		Timestamp:  p.getTimestamp(record),
+		SampleRate: p.SampleRate,
+		Data:       record,
+	}
+}
+
+// getTimestamp uses asctime, which is in local time unless logging was
+// configured otherwise
+func (p *Parser) getTimestamp(m map[string]interface{}) time.Time {
+	if s, ok := m[asctimeKey].(string); ok {
+		s = strings.Replace(strings.Replace(s, ",", ".", 1), "T", " ", 1)
+		if t, err := time.ParseInLocation("2006-01-02 15:04:05.999999999", s, time.Local); err == nil {
+			delete(m, asctimeKey)
+			return t.UTC()
+		}
+	}
+	if created, ok := m["created"].(float64); ok {
+		sec := int64(created)
+		return time.Unix(sec, int64((created-float64(sec))*1e9)).UTC()
+	}
+	return p.nower.Now()
+}
@@ -0,0 +1,145 @@
+package python
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+func TestParseLine(t *testing.T) {
+	testCases := []struct {
+		format   string
+		input    string
+		expected map[string]interface{}
+	}{
+		{
+			format: "%(asctime)s %(levelname)s %(name)s %(message)s",
+			input:  "2019-01-07 12:00:00,123 INFO myapp.views user logged in: alice",
+			expected: map[string]interface{}{
+				"asctime":   "2019-01-07 12:00:00,123",
+				"levelname": "INFO",
+				"name":      "myapp.views",
+				"message":   "user logged in: alice",
+			},
+		},
+		{
+			format: "basic",
+			input:  "WARNING:root:disk almost full",
+			expected: map[string]interface{}{
+				"levelname": "WARNING",
+				"name":      "root",
+				"message":   "disk almost full",
+			},
+		},
+		{
+			format: "django",
+			input:  "ERROR 2019-01-07 12:00:00,123 views 4321 140231 Internal Server Error: /",
+			expected: map[string]interface{}{
+				"levelname": "ERROR",
+				"asctime":   "2019-01-07 12:00:00,123",
+				"module":    "views",
+				"process":   4321,
+				"thread":    140231,
+				"message":   "Internal Server Error: /",
+			},
+		},
+		{
+			// padded fields, a literal percent and text after the message
+			format: "[%(levelname)-8s] %(filename)s:%(lineno)d 100%% %(message)s (%(threadName)s)",
+			input:  "[INFO    ] app.py:42 100% starting up (MainThread)",
+			expected: map[string]interface{}{
+				"levelname":  "INFO",
+				"filename":   "app.py",
+				"lineno":     42,
+				"message":    "starting up",
+				"threadName": "MainThread",
+			},
+		},
+		{
+			format: "{created:f} {levelname:>8} {message}",
+			input:  "1546862400.5     INFO {not a field}",
+			expected: map[string]interface{}{
+				"created":   1546862400.5,
+				"levelname": "INFO",
+				"message":   "{not a field}",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		lp, err := NewFormatLineParser(tc.format)
+		if err != nil {
+			t.Errorf("unexpected error building a parser for %q: %s", tc.format, err)
+			continue
+		}
+		resp, err := lp.ParseLine(tc.input)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q with %q: %s", tc.input, tc.format, err)
+			continue
+		}
+		if !reflect.DeepEqual(resp, tc.expected) {
+			t.Errorf("response %+v didn't match expected %+v", resp, tc.expected)
+		}
+	}
+	if _, err := NewFormatLineParser("no attributes here"); err == nil {
+		t.Error("expected error for a format with no attributes")
+	}
+	lp, _ := NewFormatLineParser("basic")
+	if _, err := lp.ParseLine("not a log line"); err == nil {
+		t.Error("expected error for a line that doesn't match")
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	lp, _ := NewFormatLineParser("%(asctime)s %(levelname)s %(name)s %(message)s")
+	p := &Parser{
+		lineParser: lp,
+		nower:      &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		lines <- "stray line before the first record"
+		lines <- "2019-01-07 12:00:00,123 ERROR myapp request failed"
+		lines <- "Traceback (most recent call last):"
+		lines <- `  File "app.py", line 1, in <module>`
+		lines <- "ValueError: bad value"
+		lines <- "2019-01-07 12:00:01,000 INFO myapp all better"
+		close(lines)
+	}()
+	go p.ProcessLines(lines, send, nil)
+
+	expected := []event.Event{
+		{
+			Timestamp: time.Date(2019, 1, 7, 12, 0, 0, 123000000, time.Local).UTC(),
+			Data: map[string]interface{}{
+				"levelname": "ERROR",
+				"name":      "myapp",
+				"message":   "request failed\nTraceback (most recent call last):\n  File \"app.py\", line 1, in <module>\nValueError: bad value",
+				"exception": "ValueError: bad value",
+			},
+		},
+		{
+			Timestamp: time.Date(2019, 1, 7, 12, 0, 1, 0, time.Local).UTC(),
+			Data: map[string]interface{}{
+				"levelname": "INFO",
+				"name":      "myapp",
+				"message":   "all better",
+			},
+		},
+	}
+	for _, exp := range expected {
+		ev := <-send
+		if !reflect.DeepEqual(ev, exp) {
+			t.Errorf("got event %+v, expected %+v", ev, exp)
+		}
+	}
+}
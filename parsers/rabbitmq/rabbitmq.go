@@ -0,0 +1,278 @@
+// Package rabbitmq parses RabbitMQ's log, in the report format written
+// before 3.7:
+//
+//	=INFO REPORT==== 21-Jun-2010::15:04:05 ===
+//	accepting AMQP connection <0.1234.0> (192.0.2.7:51234 -> 192.0.2.1:5672)
+//
+// or the single line format written since:
+//
+//	2010-06-21 15:04:05.123 [info] <0.1234.0> accepting AMQP connection <0.1234.0> (192.0.2.7:51234 -> 192.0.2.1:5672)
+//
+// Either may continue over the following lines, eg with the reason a
+// connection was closed, so lines are gathered until the next entry starts
+// or no lines have arrived for the flush timeout.
+//
+// Entries about connections, channels and nodes become events with an
+// event field, such as connection_accepted or channel_error, the connection
+// and the addresses at each end, the user and vhost, and the reason given.
+// Other entries keep their text as the message field.
+package rabbitmq
+
+import (
+	"errors"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	levelKey      = "level"
+	pidKey        = "pid"
+	messageKey    = "message"
+	eventKey      = "event"
+	connectionKey = "connection"
+	clientKey     = "client"
+	serverKey     = "server"
+	vhostKey      = "vhost"
+	userKey       = "user"
+	channelKey    = "channel"
+
+	reportTimeLayout = "2-Jan-2006::15:04:05"
+	lineTimeLayout   = "2006-01-02 15:04:05.999999"
+	// maxLines is the most lines gathered into one entry
+	maxLines = 500
+)
+
+var (
+	// reportRegex matches the header of an entry in the report format
+	reportRegex = regexp.MustCompile(`^=(\w+) REPORT==== (\S+) ===$`)
+	// lineRegex matches the start of an entry in the single line format.
+	// Since 3.9 the time has an offset.
+	lineRegex = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?)([+-]\d{2}:\d{2}|Z)? \[(\w+)\] (<[\d.]+>) ?(.*)$`)
+)
+
+// connectionDetails matches the way a connection is described, eg
+// <0.1234.0> (192.0.2.7:51234 -> 192.0.2.1:5672, vhost: '/', user: 'guest'),
+// sometimes followed by the connection's state
+const connectionDetails = `(<[\d.]+>) \((\S+) -> ([^,)]+)(?:, vhost: '([^']*)', user: '([^']*)')?(?:, [^)]*)?\)`
+
+// messages are the entries turned into events, and the name of each event
+var messages = []struct {
+	event string
+	re    *regexp.Regexp
+}{
+	{"connection_accepted", regexp.MustCompile(`^accepting AMQP connection ` + connectionDetails + `$`)},
+	{"connection_authenticated", regexp.MustCompile(`^connection ` + connectionDetails + `: user '(?P<user>[^']*)' authenticated and granted access to vhost '(?P<vhost>[^']*)'$`)},
+	{"connection_client_name", regexp.MustCompile(`^Connection ` + connectionDetails + ` has a client-provided name: (?P<client_name>.*)$`)},
+	{"connection_closed", regexp.MustCompile(`^(?:closing AMQP connection|Error on AMQP connection) ` + connectionDetails + `(?:[:,]\s*(?P<reason>(?s:.*)))?$`)},
+	{"channel_error", regexp.MustCompile(`^Channel error on connection ` + connectionDetails + `, channel (?P<channel>\d+):\s*(?P<reason>(?s:.*))$`)},
+	{"node_down", regexp.MustCompile(`^(?:rabbit on node|node) '?(?P<node>[^'\s]+@[^'\s]+)'? down(?:: (?P<reason>(?s:.*)))?$`)},
+	{"node_up", regexp.MustCompile(`^(?:rabbit on node|node) '?(?P<node>[^'\s]+@[^'\s]+)'? up$`)},
+}
+
+type Options struct {
+	TimeZone       string `long:"timezone" description:"IANA name of the time zone the log was written in, eg America/Los_Angeles. Defaults to the local time zone"`
+	FlushTimeoutMs uint   `long:"flush_timeout_ms" description:"Send an entry once no lines have arrived for this long, rather than waiting for the next entry to start" default:"1000"`
+}
+
+type Parser struct {
+	// set SampleRate to cause the parser to drop entries after they're
+	// gathered, before they're sent
+	SampleRate int
+
+	conf  Options
+	loc   *time.Location
+	nower Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+// entry is a log entry being gathered
+type entry struct {
+	ts           time.Time
+	data         map[string]interface{}
+	lines        []string
+	prefixFields map[string]string
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	p.loc = time.Local
+	if p.conf.TimeZone != "" {
+		var err error
+		if p.loc, err = time.LoadLocation(p.conf.TimeZone); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseStart parses the line starting an entry, returning an error if line
+// doesn't start one
+func (p *Parser) parseStart(line string) (*entry, error) {
+	if m := reportRegex.FindStringSubmatch(line); m != nil {
+		ts, err := time.ParseInLocation(reportTimeLayout, m[2], p.loc)
+		if err != nil {
+			return nil, err
+		}
+		return &entry{
+			ts:   ts.UTC(),
+			data: map[string]interface{}{levelKey: strings.ToLower(m[1])},
+		}, nil
+	}
+	if m := lineRegex.FindStringSubmatch(line); m != nil {
+		var ts time.Time
+		var err error
+		if m[2] != "" {
+			ts, err = time.Parse(lineTimeLayout+"Z07:00", m[1]+m[2])
+		} else {
+			ts, err = time.ParseInLocation(lineTimeLayout, m[1], p.loc)
+		}
+		if err != nil {
+			return nil, err
+		}
+		e := &entry{
+			ts: ts.UTC(),
+			data: map[string]interface{}{
+				levelKey: m[3],
+				pidKey:   m[4],
+			},
+		}
+		if m[5] != "" {
+			e.lines = append(e.lines, m[5])
+		}
+		return e, nil
+	}
+	return nil, errors.New("line doesn't start a rabbitmq log entry")
+}
+
+// parseMessage adds the fields of the entry's message to its data
+func parseMessage(e *entry) {
+	msg := strings.TrimSpace(strings.Join(e.lines, "\n"))
+	for _, m := range messages {
+		match := m.re.FindStringSubmatch(msg)
+		if match == nil {
+			continue
+		}
+		e.data[eventKey] = m.event
+		for i, name := range m.re.SubexpNames() {
+			val := strings.TrimSpace(match[i])
+			if i == 0 || val == "" {
+				continue
+			}
+			switch {
+			case name != "":
+			case i == 1:
+				name = connectionKey
+			case i == 2:
+				name = clientKey
+			case i == 3:
+				name = serverKey
+			case i == 4:
+				name = vhostKey
+			case i == 5:
+				name = userKey
+			default:
+				continue
+			}
+			if name == channelKey {
+				if n, err := strconv.Atoi(val); err == nil {
+					e.data[name] = n
+					continue
+				}
+			}
+			e.data[name] = val
+		}
+		return
+	}
+	e.data[messageKey] = msg
+}
+
+// ProcessLines gathers the lines of each entry and parses it in a single
+// goroutine. Entries are sampled here rather than while tailing, which would
+// separate their lines.
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	var current *entry
+	flush := func() {
+		if current == nil {
+			return
+		}
+		// if sampling is disabled or sampler says keep, pass along this entry.
+		if p.SampleRate <= 1 || rand.Intn(p.SampleRate) == 0 {
+			parseMessage(current)
+			for k, v := range current.prefixFields {
+				current.data[k] = v
+			}
+			send <- event.Event{
+				Timestamp:  current.ts,
+				SampleRate: p.SampleRate,
+				Data:       current.data,
+			}
+		}
+		current = nil
+	}
+	timeout := time.Duration(p.conf.FlushTimeoutMs) * time.Millisecond
+	timer := time.NewTimer(timeout)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				flush()
+				timer.Stop()
+				logrus.Debug("lines channel is closed, ending rabbitmq processor")
+				return
+			}
+			logrus.WithFields(logrus.Fields{
+				"line": line,
+			}).Debug("Attempting to process rabbitmq log line")
+
+			// take care of any headers on the line
+			var prefixFields map[string]string
+			if prefixRegex != nil {
+				var prefix string
+				prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+				line = strings.TrimPrefix(line, prefix)
+			}
+			if e, err := p.parseStart(line); err == nil {
+				flush()
+				e.prefixFields = prefixFields
+				current = e
+			} else if current != nil {
+				if len(current.lines) < maxLines {
+					current.lines = append(current.lines, line)
+				}
+			} else if strings.TrimSpace(line) != "" {
+				logrus.WithFields(logrus.Fields{
+					"line":  line,
+					"error": err,
+				}).Debug("skipping line; failed to parse.")
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(timeout)
+		case <-timer.C:
+			flush()
+			timer.Reset(timeout)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package rabbitmq
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{}
+	if err := p.Init(&Options{TimeZone: "UTC", FlushTimeoutMs: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	input := []string{
+		"=INFO REPORT==== 21-Jun-2010::15:04:05 ===",
+		"accepting AMQP connection <0.1234.0> (192.0.2.7:51234 -> 192.0.2.1:5672)",
+		"",
+		"=ERROR REPORT==== 21-Jun-2010::15:04:06 ===",
+		"closing AMQP connection <0.1234.0> (192.0.2.7:51234 -> 192.0.2.1:5672):",
+		"{handshake_timeout,frame_header}",
+		"",
+		"2010-06-21 15:04:07.123 [info] <0.1300.0> connection <0.1300.0> (192.0.2.7:51235 -> 192.0.2.1:5672): user 'app' authenticated and granted access to vhost '/'",
+		"2010-06-21 15:04:08.123456+02:00 [error] <0.1310.0> Channel error on connection <0.1300.0> (192.0.2.7:51235 -> 192.0.2.1:5672, vhost: '/', user: 'app'), channel 1:",
+		"operation queue.declare caused a channel exception not_found: no queue 'jobs' in vhost '/'",
+		"2010-06-21 15:04:09.000 [warning] <0.1300.0> closing AMQP connection <0.1300.0> (192.0.2.7:51235 -> 192.0.2.1:5672, vhost: '/', user: 'app'):",
+		"client unexpectedly closed TCP connection",
+		"2010-06-21 15:04:10.000 [info] <0.200.0> rabbit on node 'rabbit@mq2' down",
+		"2010-06-21 15:04:11.000 [info] <0.200.0> Server startup complete; 3 plugins started.",
+	}
+	expected := []event.Event{
+		{
+			Timestamp: time.Date(2010, 6, 21, 15, 4, 5, 0, time.UTC),
+			Data: map[string]interface{}{
+				"level":      "info",
+				"event":      "connection_accepted",
+				"connection": "<0.1234.0>",
+				"client":     "192.0.2.7:51234",
+				"server":     "192.0.2.1:5672",
+			},
+		},
+		{
+			Timestamp: time.Date(2010, 6, 21, 15, 4, 6, 0, time.UTC),
+			Data: map[string]interface{}{
+				"level":      "error",
+				"event":      "connection_closed",
+				"connection": "<0.1234.0>",
+				"client":     "192.0.2.7:51234",
+				"server":     "192.0.2.1:5672",
+				"reason":     "{handshake_timeout,frame_header}",
+			},
+		},
+		{
+			Timestamp: time.Date(2010, 6, 21, 15, 4, 7, 123000000, time.UTC),
+			Data: map[string]interface{}{
+				"level":      "info",
+				"pid":        "<0.1300.0>",
+				"event":      "connection_authenticated",
+				"connection": "<0.1300.0>",
+				"client":     "192.0.2.7:51235",
+				"server":     "192.0.2.1:5672",
+				"user":       "app",
+				"vhost":      "/",
+			},
+		},
+		{
+			Timestamp: time.Date(2010, 6, 21, 13, 4, 8, 123456000, time.UTC),
+			Data: map[string]interface{}{
+				"level":      "error",
+				"pid":        "<0.1310.0>",
+				"event":      "channel_error",
+				"connection": "<0.1300.0>",
+				"client":     "192.0.2.7:51235",
+				"server":     "192.0.2.1:5672",
+				"user":       "app",
+				"vhost":      "/",
+				"channel":    1,
+				"reason":     "operation queue.declare caused a channel exception not_found: no queue 'jobs' in vhost '/'",
+			},
+		},
+		{
+			Timestamp: time.Date(2010, 6, 21, 15, 4, 9, 0, time.UTC),
+			Data: map[string]interface{}{
+				"level":      "warning",
+				"pid":        "<0.1300.0>",
+				"event":      "connection_closed",
+				"connection": "<0.1300.0>",
+				"client":     "192.0.2.7:51235",
+				"server":     "192.0.2.1:5672",
+				"user":       "app",
+				"vhost":      "/",
+				"reason":     "client unexpectedly closed TCP connection",
+			},
+		},
+		{
+			Timestamp: time.Date(2010, 6, 21, 15, 4, 10, 0, time.UTC),
+			Data: map[string]interface{}{
+				"level": "info",
+				"pid":   "<0.200.0>",
+				"event": "node_down",
+				"node":  "rabbit@mq2",
+			},
+		},
+		{
+			Timestamp: time.Date(2010, 6, 21, 15, 4, 11, 0, time.UTC),
+			Data: map[string]interface{}{
+				"level":   "info",
+				"pid":     "<0.200.0>",
+				"message": "Server startup complete; 3 plugins started.",
+			},
+		},
+	}
+
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		for _, line := range input {
+			lines <- line
+		}
+		close(lines)
+	}()
+	go func() {
+		p.ProcessLines(lines, send, nil)
+		close(send)
+	}()
+	var got []event.Event
+	for ev := range send {
+		got = append(got, ev)
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d events, got %d: %+v", len(expected), len(got), got)
+	}
+	for i := range expected {
+		if !got[i].Timestamp.Equal(expected[i].Timestamp) {
+			t.Errorf("event %d: expected timestamp %s, got %s", i, expected[i].Timestamp, got[i].Timestamp)
+		}
+		if !reflect.DeepEqual(got[i].Data, expected[i].Data) {
+			t.Errorf("event %d: expected\n%v\ngot\n%v", i, expected[i].Data, got[i].Data)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Package rails parses Rails production logs, assembling the Started,
+// Processing and Completed lines logged for each request into one event.
+//
+// Lines are matched up by their tags when the app uses tagged logging (eg
+// config.log_tags = [:request_id]), otherwise by the process id in the
+// Logger prefix, otherwise by adjacency. Lines the parser doesn't recognize,
+// such as rendered templates and SQL, are ignored.
+package rails
+
+import (
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	startedTimeFormat = "2006-01-02 15:04:05 -0700"
+	loggerTimeFormat  = "2006-01-02T15:04:05.999999"
+)
+
+var (
+	// loggerPrefix is the prefix added by Ruby's Logger:
+	// I, [2019-01-07T12:00:00.123456 #1234]  INFO -- : message
+	loggerPrefix = regexp.MustCompile(`^[DIWEFA], \[(\S+) #(\d+)\]\s+\w+ -- [^:]*: ?`)
+	// tag is a single tag added by tagged logging
+	tag = regexp.MustCompile(`^\[([^\]]*)\] ?`)
+	// requestIDTag looks like the ids Rails generates for requests
+	requestIDTag = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+	started    = regexp.MustCompile(`^Started (\S+) "([^"]*)" for (\S+) at (.+)$`)
+	processing = regexp.MustCompile(`^Processing by (\S+)#(\S+) as (\S+)`)
+	parameters = regexp.MustCompile(`^\s*Parameters: (.*)$`)
+	completed  = regexp.MustCompile(`^Completed (\d+) (.*?) ?in (\d+(?:\.\d+)?)ms(?: \((.*)\))?`)
+	// breakdown is one part of the timing breakdown on the Completed line,
+	// eg Views: 20.1ms or Allocations: 1234
+	breakdown = regexp.MustCompile(`^(\w+): (\d+(?:\.\d+)?)(ms)?$`)
+
+	// breakdownFields names the parts of the timing breakdown
+	breakdownFields = map[string]string{
+		"Views":        "view_ms",
+		"ActiveRecord": "db_ms",
+		"Allocations":  "allocations",
+	}
+)
+
+type Options struct{}
+
+type Parser struct {
+	// set SampleRate to cause the parser to drop requests after they're
+	// assembled, before they're sent
+	SampleRate int
+
+	conf  Options
+	nower Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	return nil
+}
+
+// request is a request being assembled
+type request struct {
+	timestamp time.Time
+	data      map[string]interface{}
+}
+
+// line is a log line with its Logger prefix and tags removed
+type line struct {
+	key     string
+	message string
+	// time and pid come from the Logger prefix, if there is one
+	time time.Time
+	pid  int
+	tags []string
+}
+
+// splitLine strips the Logger prefix and tags from a line
+func splitLine(raw string) line {
+	var l line
+	if m := loggerPrefix.FindStringSubmatch(raw); m != nil {
+		l.time, _ = time.Parse(loggerTimeFormat, m[1])
+		l.pid, _ = strconv.Atoi(m[2])
+		raw = raw[len(m[0]):]
+	}
+	for {
+		m := tag.FindStringSubmatch(raw)
+		if m == nil {
+			break
+		}
+		l.tags = append(l.tags, m[1])
+		raw = raw[len(m[0]):]
+	}
+	l.message = raw
+	switch {
+	case len(l.tags) != 0:
+		l.key = strings.Join(l.tags, ",")
+	case l.pid != 0:
+		l.key = strconv.Itoa(l.pid)
+	}
+	return l
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	pending := make(map[string]*request)
+	for raw := range lines {
+		logrus.WithFields(logrus.Fields{
+			"line": raw,
+		}).Debug("Attempting to process rails log line")
+
+		// the prefix can only be stripped; it's not possible to attribute the
+		// fields of each line's prefix to the event.
+		if prefixRegex != nil {
+			raw = strings.TrimPrefix(raw, prefixRegex.FindString(raw))
+		}
+		l := splitLine(raw)
+		req := pending[l.key]
+
+		if m := started.FindStringSubmatch(l.message); m != nil {
+			if req != nil {
+				// the last request for this key never completed
+				p.send(req, send)
+			}
+			req = &request{data: map[string]interface{}{
+				"method":    m[1],
+				"path":      m[2],
+				"client_ip": m[3],
+			}}
+			if t, err := time.Parse(startedTimeFormat, m[4]); err == nil {
+				req.timestamp = t
+			} else {
+				req.timestamp = l.time
+			}
+			if l.pid != 0 {
+				req.data["pid"] = l.pid
+			}
+			if len(l.tags) != 0 {
+				req.data["tags"] = strings.Join(l.tags, ",")
+				for _, t := range l.tags {
+					if requestIDTag.MatchString(t) {
+						req.data["request_id"] = t
+						break
+					}
+				}
+			}
+			pending[l.key] = req
+			continue
+		}
+		if req == nil {
+			logrus.WithField("line", raw).Debug("skipping line; not part of a request")
+			continue
+		}
+		if m := processing.FindStringSubmatch(l.message); m != nil {
+			req.data["controller"] = m[1]
+			req.data["action"] = m[2]
+			req.data["format"] = m[3]
+		} else if m := parameters.FindStringSubmatch(l.message); m != nil {
+			req.data["params"] = m[1]
+		} else if m := completed.FindStringSubmatch(l.message); m != nil {
+			req.data["status"], _ = strconv.Atoi(m[1])
+			if m[2] != "" {
+				req.data["status_text"] = m[2]
+			}
+			req.data["duration_ms"], _ = strconv.ParseFloat(m[3], 64)
+			addBreakdown(m[4], req.data)
+			p.send(req, send)
+			delete(pending, l.key)
+		}
+	}
+	// send whatever we have of requests that never completed
+	for _, req := range pending {
+		p.send(req, send)
+	}
+	logrus.Debug("lines channel is closed, ending rails processor")
+}
+
+// addBreakdown adds the timing breakdown of a Completed line, like
+// Views: 20.1ms | ActiveRecord: 3.2ms
+func addBreakdown(s string, data map[string]interface{}) {
+	for _, part := range strings.Split(s, "|") {
+		m := breakdown.FindStringSubmatch(strings.TrimSpace(part))
+		if m == nil {
+			continue
+		}
+		name, ok := breakdownFields[m[1]]
+		if !ok {
+			name = strings.ToLower(m[1])
+			if m[3] != "" {
+				name += "_ms"
+			}
+		}
+		if n, err := strconv.ParseFloat(m[2], 64); err == nil {
+			data[name] = n
+		}
+	}
+}
+
+func (p *Parser) send(req *request, send chan<- event.Event) {
+	// if sampling is disabled or sampler says keep, pass along this request.
+	if p.SampleRate > 1 && rand.Intn(p.SampleRate) != 0 {
+		return
+	}
+	ts := req.timestamp
+	if ts.IsZero() {
+		ts = p.nower.Now()
+	}
+	send <- event.Event{
+		Timestamp:  ts.UTC(),
+		SampleRate: p.SampleRate,
+		Data:       req.data,
+	}
+}
@@ -0,0 +1,158 @@
+package rails
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+func processLines(t *testing.T, p *Parser, input []string) []event.Event {
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		for _, l := range input {
+			lines <- l
+		}
+		close(lines)
+	}()
+	done := make(chan struct{})
+	go func() {
+		p.ProcessLines(lines, send, nil)
+		close(done)
+	}()
+	var evs []event.Event
+	for {
+		select {
+		case ev := <-send:
+			evs = append(evs, ev)
+		case <-done:
+			return evs
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for events")
+		}
+	}
+}
+
+func TestSplitLine(t *testing.T) {
+	l := splitLine("I, [2019-01-07T12:00:00.123456 #1234]  INFO -- : [www] [0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0] Started GET \"/\" for 1.2.3.4 at 2019-01-07 12:00:00 +0000")
+	expected := line{
+		key:     "www,0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0",
+		message: `Started GET "/" for 1.2.3.4 at 2019-01-07 12:00:00 +0000`,
+		time:    time.Date(2019, 1, 7, 12, 0, 0, 123456000, time.UTC),
+		pid:     1234,
+		tags:    []string{"www", "0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0"},
+	}
+	if !reflect.DeepEqual(l, expected) {
+		t.Errorf("got %+v, expected %+v", l, expected)
+	}
+	l = splitLine("Completed 200 OK in 5ms")
+	if l.key != "" || l.message != "Completed 200 OK in 5ms" {
+		t.Errorf("unexpected split of an untagged line %+v", l)
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{nower: &FakeNower{}}
+	evs := processLines(t, p, []string{
+		`Started GET "/users?page=2" for 127.0.0.1 at 2019-01-07 12:00:00 +0000`,
+		`Processing by UsersController#index as HTML`,
+		`  Parameters: {"page"=>"2"}`,
+		`  Rendered users/index.html.erb within layouts/application (5.2ms)`,
+		`Completed 200 OK in 25ms (Views: 20.1ms | ActiveRecord: 3.2ms | Allocations: 1234)`,
+		``,
+		`Started POST "/login" for 10.0.0.1 at 2019-01-07 12:00:01 +0000`,
+		`Processing by SessionsController#create as JSON`,
+		`Completed 500 Internal Server Error in 12.5ms (ActiveRecord: 1.0ms)`,
+		`NoMethodError (undefined method 'name' for nil):`,
+	})
+	expected := []event.Event{
+		{
+			Timestamp: time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC),
+			Data: map[string]interface{}{
+				"method":      "GET",
+				"path":        "/users?page=2",
+				"client_ip":   "127.0.0.1",
+				"controller":  "UsersController",
+				"action":      "index",
+				"format":      "HTML",
+				"params":      `{"page"=>"2"}`,
+				"status":      200,
+				"status_text": "OK",
+				"duration_ms": 25.0,
+				"view_ms":     20.1,
+				"db_ms":       3.2,
+				"allocations": 1234.0,
+			},
+		},
+		{
+			Timestamp: time.Date(2019, 1, 7, 12, 0, 1, 0, time.UTC),
+			Data: map[string]interface{}{
+				"method":      "POST",
+				"path":        "/login",
+				"client_ip":   "10.0.0.1",
+				"controller":  "SessionsController",
+				"action":      "create",
+				"format":      "JSON",
+				"status":      500,
+				"status_text": "Internal Server Error",
+				"duration_ms": 12.5,
+				"db_ms":       1.0,
+			},
+		},
+	}
+	if !reflect.DeepEqual(evs, expected) {
+		t.Errorf("got events %+v, expected %+v", evs, expected)
+	}
+}
+
+func TestInterleavedRequests(t *testing.T) {
+	p := &Parser{nower: &FakeNower{}}
+	evs := processLines(t, p, []string{
+		`I, [2019-01-07T12:00:00.000000 #10]  INFO -- : [aaaaaaaa-0000-0000-0000-000000000001] Started GET "/a" for 1.1.1.1 at 2019-01-07 12:00:00 +0000`,
+		`I, [2019-01-07T12:00:00.100000 #11]  INFO -- : [aaaaaaaa-0000-0000-0000-000000000002] Started GET "/b" for 2.2.2.2 at 2019-01-07 12:00:00 +0000`,
+		`I, [2019-01-07T12:00:00.200000 #11]  INFO -- : [aaaaaaaa-0000-0000-0000-000000000002] Processing by BController#show as HTML`,
+		`I, [2019-01-07T12:00:00.300000 #10]  INFO -- : [aaaaaaaa-0000-0000-0000-000000000001] Processing by AController#show as HTML`,
+		`I, [2019-01-07T12:00:00.400000 #10]  INFO -- : [aaaaaaaa-0000-0000-0000-000000000001] Completed 404 Not Found in 3ms`,
+		// this one never completes, but is sent when the input ends
+		`I, [2019-01-07T12:00:00.500000 #12]  INFO -- : Started GET "/c" for 3.3.3.3 at 2019-01-07 12:00:00 +0000`,
+		`I, [2019-01-07T12:00:00.600000 #11]  INFO -- : [aaaaaaaa-0000-0000-0000-000000000002] Completed 200 OK in 8ms`,
+	})
+	if len(evs) != 3 {
+		t.Fatalf("expected 3 events, got %+v", evs)
+	}
+	sort.Slice(evs, func(i, j int) bool {
+		return evs[i].Data["path"].(string) < evs[j].Data["path"].(string)
+	})
+	if evs[0].Data["controller"] != "AController" || evs[0].Data["status"] != 404 ||
+		evs[0].Data["request_id"] != "aaaaaaaa-0000-0000-0000-000000000001" || evs[0].Data["pid"] != 10 {
+		t.Errorf("unexpected first request %+v", evs[0].Data)
+	}
+	if evs[1].Data["controller"] != "BController" || evs[1].Data["status"] != 200 {
+		t.Errorf("unexpected second request %+v", evs[1].Data)
+	}
+	if _, ok := evs[2].Data["status"]; ok || evs[2].Data["pid"] != 12 {
+		t.Errorf("unexpected incomplete request %+v", evs[2].Data)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	p := &Parser{nower: &FakeNower{}, SampleRate: 1000000}
+	var input []string
+	for i := 0; i < 10; i++ {
+		input = append(input, `Started GET "/" for 1.1.1.1 at 2019-01-07 12:00:00 +0000`, `Completed 200 OK in 1ms`)
+	}
+	// with a sample rate this high, nothing should make it through
+	if evs := processLines(t, p, input); len(evs) > 1 {
+		t.Errorf("expected requests to be sampled, got %d", len(evs))
+	}
+}
@@ -0,0 +1,229 @@
+// Package s3 parses Amazon S3 server access logs.
+//
+// Field names follow the AWS documentation:
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/LogFormat.html
+package s3
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	timeKey       = "time"
+	keyKey        = "key"
+	requestURIKey = "request_uri"
+	timeFormat    = "02/Jan/2006:15:04:05 -0700"
+	// minFields is the number of fields up to and including turnaround_time,
+	// which every version of the format has
+	minFields = 15
+)
+
+// fields are the fields of an access log line, in order. AWS appends new
+// fields from time to time; any extras are ignored.
+var fields = []string{
+	"bucket_owner", "bucket", "time", "remote_ip", "requester", "request_id",
+	"operation", "key", "request_uri", "http_status", "error_code",
+	"bytes_sent", "object_size", "total_time", "turnaround_time", "referer",
+	"user_agent", "version_id", "host_id", "signature_version", "cipher_suite",
+	"authentication_type", "host_header", "tls_version", "access_point_arn",
+	"acl_required",
+}
+
+// intFields are the fields that aren't strings. total_time and
+// turnaround_time are in milliseconds.
+var intFields = map[string]bool{
+	"http_status":     true,
+	"bytes_sent":      true,
+	"object_size":     true,
+	"total_time":      true,
+	"turnaround_time": true,
+}
+
+type Options struct {
+	NumParsers int `hidden:"true" description:"number of s3 parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, error)
+}
+
+type S3LineParser struct{}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	p.lineParser = &S3LineParser{}
+	return nil
+}
+
+// ParseLine parses an S3 server access log line
+func (s *S3LineParser) ParseLine(line string) (map[string]interface{}, error) {
+	tokens, err := tokenize(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) < minFields {
+		return nil, errors.New("too few fields for an s3 access log line")
+	}
+
+	parsed := make(map[string]interface{})
+	for i, tok := range tokens {
+		if i >= len(fields) {
+			break
+		}
+		name := fields[i]
+		// - means the value isn't available
+		if tok == "-" || tok == "" {
+			continue
+		}
+		switch {
+		case intFields[name]:
+			if n, err := strconv.Atoi(tok); err == nil {
+				parsed[name] = n
+				continue
+			}
+		case name == keyKey:
+			// keys are URL encoded in the log
+			if k, err := url.QueryUnescape(tok); err == nil {
+				tok = k
+			}
+		case name == requestURIKey:
+			parseRequest(tok, parsed)
+		}
+		parsed[name] = tok
+	}
+	return parsed, nil
+}
+
+// parseRequest breaks "GET /bucket/key?q HTTP/1.1" into its parts
+func parseRequest(req string, parsed map[string]interface{}) {
+	parts := strings.Split(req, " ")
+	if len(parts) != 3 {
+		return
+	}
+	parsed["request_method"] = parts[0]
+	parsed["request_protocol"] = parts[2]
+	u, err := url.Parse(parts[1])
+	if err != nil {
+		return
+	}
+	parsed["request_path"] = u.EscapedPath()
+	if u.RawQuery != "" {
+		parsed["request_query"] = u.RawQuery
+	}
+}
+
+// tokenize splits on spaces, keeping double quoted and bracketed strings
+// together
+func tokenize(line string) ([]string, error) {
+	var tokens []string
+	line = strings.TrimSpace(line)
+	for len(line) > 0 {
+		if line[0] == '"' || line[0] == '[' {
+			closer := byte('"')
+			if line[0] == '[' {
+				closer = ']'
+			}
+			end := 1
+			for end < len(line) && line[end] != closer {
+				if line[end] == '\\' && closer == '"' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, errors.New("unterminated quoted field")
+			}
+			tokens = append(tokens, strings.Replace(line[1:end], `\"`, `"`, -1))
+			line = strings.TrimLeft(line[end+1:], " ")
+			continue
+		}
+		end := strings.IndexByte(line, ' ')
+		if end < 0 {
+			end = len(line)
+		}
+		tokens = append(tokens, line[:end])
+		line = strings.TrimLeft(line[end:], " ")
+	}
+	return tokens, nil
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process s3 log line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp: p.getTimestamp(parsedLine),
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending s3 processor")
+}
+
+// getTimestamp uses the time S3 received the request
+func (p *Parser) getTimestamp(m map[string]interface{}) time.Time {
+	if s, ok := m[timeKey].(string); ok {
+		if t, err := time.Parse(timeFormat, s); err == nil {
+			delete(m, timeKey)
+			return t.UTC()
+		}
+	}
+	return p.nower.Now()
+}
@@ -0,0 +1,125 @@
+package s3
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+const (
+	getLine = `79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be awsexamplebucket1 [06/Feb/2019:00:00:38 +0000] 192.0.2.3 79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be 3E57427F3EXAMPLE REST.GET.OBJECT photos/my%20puppy.jpg "GET /awsexamplebucket1/photos/my%20puppy.jpg?versionId=1 HTTP/1.1" 200 - 113 113 16 15 "-" "S3Console/0.4" - s9lzHYrFp76ZVxRcpX9+5cjAnEH2ROuNkd2BHfIa6UkFVdtjf5mKR3/eTPFvsiP/XV/VLi31234= SigV4 ECDHE-RSA-AES128-GCM-SHA256 AuthHeader awsexamplebucket1.s3.us-west-1.amazonaws.com TLSV1.2`
+	// an old style line without the trailing fields
+	oldLine = `79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be awsexamplebucket1 [06/Feb/2019:00:00:38 +0000] 192.0.2.3 - 891CE47D2EXAMPLE REST.GET.LOGGING_STATUS - "GET /awsexamplebucket1?logging HTTP/1.1" 403 AccessDenied 243 - 7 -`
+)
+
+func TestParseLine(t *testing.T) {
+	s3p := S3LineParser{}
+	testCases := []struct {
+		input    string
+		expected map[string]interface{}
+	}{
+		{
+			input: getLine,
+			expected: map[string]interface{}{
+				"bucket_owner":        "79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be",
+				"bucket":              "awsexamplebucket1",
+				"time":                "06/Feb/2019:00:00:38 +0000",
+				"remote_ip":           "192.0.2.3",
+				"requester":           "79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be",
+				"request_id":          "3E57427F3EXAMPLE",
+				"operation":           "REST.GET.OBJECT",
+				"key":                 "photos/my puppy.jpg",
+				"request_uri":         "GET /awsexamplebucket1/photos/my%20puppy.jpg?versionId=1 HTTP/1.1",
+				"request_method":      "GET",
+				"request_path":        "/awsexamplebucket1/photos/my%20puppy.jpg",
+				"request_query":       "versionId=1",
+				"request_protocol":    "HTTP/1.1",
+				"http_status":         200,
+				"bytes_sent":          113,
+				"object_size":         113,
+				"total_time":          16,
+				"turnaround_time":     15,
+				"user_agent":          "S3Console/0.4",
+				"host_id":             "s9lzHYrFp76ZVxRcpX9+5cjAnEH2ROuNkd2BHfIa6UkFVdtjf5mKR3/eTPFvsiP/XV/VLi31234=",
+				"signature_version":   "SigV4",
+				"cipher_suite":        "ECDHE-RSA-AES128-GCM-SHA256",
+				"authentication_type": "AuthHeader",
+				"host_header":         "awsexamplebucket1.s3.us-west-1.amazonaws.com",
+				"tls_version":         "TLSV1.2",
+			},
+		},
+		{
+			input: oldLine,
+			expected: map[string]interface{}{
+				"bucket_owner":     "79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be",
+				"bucket":           "awsexamplebucket1",
+				"time":             "06/Feb/2019:00:00:38 +0000",
+				"remote_ip":        "192.0.2.3",
+				"request_id":       "891CE47D2EXAMPLE",
+				"operation":        "REST.GET.LOGGING_STATUS",
+				"request_uri":      "GET /awsexamplebucket1?logging HTTP/1.1",
+				"request_method":   "GET",
+				"request_path":     "/awsexamplebucket1",
+				"request_query":    "logging",
+				"request_protocol": "HTTP/1.1",
+				"http_status":      403,
+				"error_code":       "AccessDenied",
+				"bytes_sent":       243,
+				"total_time":       7,
+			},
+		},
+	}
+	for _, tc := range testCases {
+		resp, err := s3p.ParseLine(tc.input)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tc.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(resp, tc.expected) {
+			t.Errorf("response %+v didn't match expected %+v", resp, tc.expected)
+		}
+	}
+
+	for _, bad := range []string{"", "owner bucket [06/Feb/2019:00:00:38 +0000", "too few fields"} {
+		if _, err := s3p.ParseLine(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{
+		conf:       Options{NumParsers: 1},
+		lineParser: &S3LineParser{},
+		nower:      &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		lines <- oldLine
+		lines <- "not an access log line"
+		close(lines)
+	}()
+	go p.ProcessLines(lines, send, nil)
+
+	ev := <-send
+	expected := time.Date(2019, 2, 6, 0, 0, 38, 0, time.UTC)
+	if !ev.Timestamp.Equal(expected) {
+		t.Errorf("got timestamp %v, expected %v", ev.Timestamp, expected)
+	}
+	if _, ok := ev.Data["time"]; ok {
+		t.Error("time field should have been removed")
+	}
+	if ev.Data["operation"] != "REST.GET.LOGGING_STATUS" {
+		t.Errorf("unexpected data %+v", ev.Data)
+	}
+}
@@ -0,0 +1,165 @@
+// Package squid parses Squid's native access log format, eg
+//
+//	1286536308.779    180 192.0.2.24 TCP_MISS/200 411 GET http://example.com/ - HIER_DIRECT/198.51.100.7 text/html
+//
+// The result code is split into the cache result (TCP_MISS) and the HTTP
+// status (200), and the hierarchy code into how the request was forwarded
+// (HIER_DIRECT) and the peer it was forwarded to.
+package squid
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	durationKey    = "duration_ms"
+	clientKey      = "client_ip"
+	cacheResultKey = "cache_result"
+	cacheHitKey    = "cache_hit"
+	statusKey      = "status"
+	bytesKey       = "bytes"
+	methodKey      = "method"
+	urlKey         = "url"
+	userKey        = "user"
+	hierarchyKey   = "hierarchy"
+	peerKey        = "peer"
+	contentTypeKey = "content_type"
+
+	// numFields are the fields in the native format; squid.conf may add more
+	// after them with a custom logformat
+	numFields = 10
+)
+
+type Options struct {
+	NumParsers int `hidden:"true" description:"number of squid parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, time.Time, error)
+}
+
+type SquidLineParser struct{}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	p.lineParser = &SquidLineParser{}
+	return nil
+}
+
+// ParseLine splits a native format line into its fields
+func (s *SquidLineParser) ParseLine(line string) (map[string]interface{}, time.Time, error) {
+	fields := strings.Fields(line)
+	if len(fields) < numFields {
+		return nil, time.Time{}, errors.New("line has too few fields for squid's native format")
+	}
+	epoch, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	sec, frac := math.Modf(epoch)
+	ts := time.Unix(int64(sec), int64(math.Floor(frac*1000+0.5))*int64(time.Millisecond)).UTC()
+
+	parsed := map[string]interface{}{
+		clientKey: fields[2],
+		methodKey: fields[5],
+		urlKey:    fields[6],
+	}
+	if n, err := strconv.Atoi(fields[1]); err == nil {
+		parsed[durationKey] = n
+	}
+	// eg TCP_MISS/200, or TCP_DENIED/403
+	result := strings.SplitN(fields[3], "/", 2)
+	parsed[cacheResultKey] = result[0]
+	parsed[cacheHitKey] = strings.Contains(result[0], "HIT")
+	if len(result) == 2 {
+		if n, err := strconv.Atoi(result[1]); err == nil {
+			parsed[statusKey] = n
+		}
+	}
+	if n, err := strconv.Atoi(fields[4]); err == nil {
+		parsed[bytesKey] = n
+	}
+	if fields[7] != "-" {
+		parsed[userKey] = fields[7]
+	}
+	// eg HIER_DIRECT/198.51.100.7, or HIER_NONE/-
+	hier := strings.SplitN(fields[8], "/", 2)
+	parsed[hierarchyKey] = hier[0]
+	if len(hier) == 2 && hier[1] != "-" {
+		parsed[peerKey] = hier[1]
+	}
+	if fields[9] != "-" {
+		parsed[contentTypeKey] = fields[9]
+	}
+	return parsed, ts, nil
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process squid log line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, timestamp, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp: timestamp,
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending squid processor")
+}
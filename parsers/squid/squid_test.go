@@ -0,0 +1,83 @@
+package squid
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLine(t *testing.T) {
+	p := &SquidLineParser{}
+	tlms := []struct {
+		line     string
+		expected map[string]interface{}
+		ts       time.Time
+	}{
+		{
+			line: "1286536308.779    180 192.0.2.24 TCP_MISS/200 411 GET http://example.com/ - HIER_DIRECT/198.51.100.7 text/html",
+			expected: map[string]interface{}{
+				"duration_ms":  180,
+				"client_ip":    "192.0.2.24",
+				"cache_result": "TCP_MISS",
+				"cache_hit":    false,
+				"status":       200,
+				"bytes":        411,
+				"method":       "GET",
+				"url":          "http://example.com/",
+				"hierarchy":    "HIER_DIRECT",
+				"peer":         "198.51.100.7",
+				"content_type": "text/html",
+			},
+			ts: time.Date(2010, 10, 8, 11, 11, 48, 779000000, time.UTC),
+		},
+		{
+			line: "1286536309.001      0 192.0.2.25 TCP_MEM_HIT/304 250 GET http://example.com/logo.png alice HIER_NONE/- -",
+			expected: map[string]interface{}{
+				"duration_ms":  0,
+				"client_ip":    "192.0.2.25",
+				"cache_result": "TCP_MEM_HIT",
+				"cache_hit":    true,
+				"status":       304,
+				"bytes":        250,
+				"method":       "GET",
+				"url":          "http://example.com/logo.png",
+				"user":         "alice",
+				"hierarchy":    "HIER_NONE",
+			},
+			ts: time.Date(2010, 10, 8, 11, 11, 49, 1000000, time.UTC),
+		},
+		{
+			line: "1286536310.5 5 192.0.2.26 TAG_NONE/000 0 CONNECT example.com:443 - HIER_NONE/- - extra",
+			expected: map[string]interface{}{
+				"duration_ms":  5,
+				"client_ip":    "192.0.2.26",
+				"cache_result": "TAG_NONE",
+				"cache_hit":    false,
+				"status":       0,
+				"bytes":        0,
+				"method":       "CONNECT",
+				"url":          "example.com:443",
+				"hierarchy":    "HIER_NONE",
+			},
+			ts: time.Date(2010, 10, 8, 11, 11, 50, 500000000, time.UTC),
+		},
+	}
+	for _, tlm := range tlms {
+		parsed, ts, err := p.ParseLine(tlm.line)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tlm.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(parsed, tlm.expected) {
+			t.Errorf("parsing %q got %+v, expected %+v", tlm.line, parsed, tlm.expected)
+		}
+		if !ts.Equal(tlm.ts) {
+			t.Errorf("parsing %q got time %s, expected %s", tlm.line, ts, tlm.ts)
+		}
+	}
+	for _, bad := range []string{"", "too few fields", "notatime 180 192.0.2.24 TCP_MISS/200 411 GET http://example.com/ - HIER_DIRECT/- text/html"} {
+		if _, _, err := p.ParseLine(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
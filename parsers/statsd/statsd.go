@@ -0,0 +1,203 @@
+// Package statsd parses metrics in the statsd line protocol, including the
+// DogStatsD extensions, eg
+//
+//	api.requests:1|c|@0.1|#env:prod,route:/users
+//
+// Each metric becomes an event with its name, value, type and sample rate,
+// and a tag.<name> field for each tag, the same fields --listen=statsd://
+// sends. Tags without a value are set to "true". Lines carrying several
+// values, as DogStatsD allows, become an event per value.
+//
+// This is for statsd lines that arrive some other way than the statsd
+// listener, eg written to a file or piped in on STDIN.
+package statsd
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	nameKey        = "name"
+	valueKey       = "value"
+	typeKey        = "type"
+	sampleRateKey  = "sample_rate"
+	containerIDKey = "container_id"
+	tagPrefix      = "tag."
+)
+
+// types name the metric types
+var types = map[string]string{
+	"c":  "counter",
+	"g":  "gauge",
+	"ms": "timer",
+	"h":  "histogram",
+	"s":  "set",
+	"d":  "distribution",
+}
+
+type Options struct {
+	NumParsers int `hidden:"true" description:"number of statsd parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+// LineParser parses a line into a metric for each of its values, and the
+// time it was sent if the line carries one
+type LineParser interface {
+	ParseLine(line string) ([]map[string]interface{}, time.Time, error)
+}
+
+type StatsdLineParser struct{}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	p.lineParser = &StatsdLineParser{}
+	return nil
+}
+
+// ParseLine parses a metric. DogStatsD events and service checks aren't
+// metrics, and are rejected.
+func (s *StatsdLineParser) ParseLine(line string) ([]map[string]interface{}, time.Time, error) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "_e{") || strings.HasPrefix(line, "_sc|") {
+		return nil, time.Time{}, errors.New("line is a DogStatsD event or service check, not a metric")
+	}
+	sections := strings.Split(line, "|")
+	colon := strings.Index(sections[0], ":")
+	if len(sections) < 2 || colon <= 0 {
+		return nil, time.Time{}, errors.New("line is not a statsd metric")
+	}
+	name, values := sections[0][:colon], strings.Split(sections[0][colon+1:], ":")
+	typ, ok := types[sections[1]]
+	if !ok {
+		return nil, time.Time{}, errors.New("unknown statsd metric type " + sections[1])
+	}
+	common := map[string]interface{}{
+		nameKey: name,
+		typeKey: typ,
+	}
+	var ts time.Time
+	for _, section := range sections[2:] {
+		switch {
+		case strings.HasPrefix(section, "@"):
+			r, err := strconv.ParseFloat(section[1:], 64)
+			if err != nil || r <= 0 || r > 1 {
+				return nil, time.Time{}, errors.New("invalid sample rate " + section)
+			}
+			if r != 1 {
+				common[sampleRateKey] = r
+			}
+		case strings.HasPrefix(section, "#"):
+			addTags(section[1:], common)
+		case strings.HasPrefix(section, "c:"):
+			common[containerIDKey] = section[2:]
+		case strings.HasPrefix(section, "T"):
+			if sec, err := strconv.ParseInt(section[1:], 10, 64); err == nil {
+				ts = time.Unix(sec, 0).UTC()
+			}
+		}
+	}
+	metrics := make([]map[string]interface{}, 0, len(values))
+	for _, v := range values {
+		metric := make(map[string]interface{}, len(common)+1)
+		for k, val := range common {
+			metric[k] = val
+		}
+		if typ == "set" {
+			metric[valueKey] = v
+		} else {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, time.Time{}, err
+			}
+			metric[valueKey] = f
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, ts, nil
+}
+
+// addTags adds the comma separated tags to metric
+func addTags(tags string, metric map[string]interface{}) {
+	for _, tag := range strings.Split(tags, ",") {
+		if tag == "" {
+			continue
+		}
+		if i := strings.Index(tag, ":"); i > 0 {
+			metric[tagPrefix+tag[:i]] = tag[i+1:]
+		} else {
+			metric[tagPrefix+tag] = "true"
+		}
+	}
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process statsd line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				metrics, timestamp, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				if timestamp.IsZero() {
+					timestamp = p.nower.Now()
+				}
+				for _, metric := range metrics {
+					// merge the prefix fields and the parsed line contents
+					for k, v := range prefixFields {
+						metric[k] = v
+					}
+					send <- event.Event{
+						Timestamp: timestamp,
+						Data:      metric,
+					}
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending statsd processor")
+}
@@ -0,0 +1,147 @@
+package statsd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	return time.Date(2017, 5, 1, 10, 0, 0, 0, time.UTC)
+}
+
+type testLineMap struct {
+	line     string
+	expected []map[string]interface{}
+	ts       time.Time
+}
+
+var tlms = []testLineMap{
+	{
+		line: "page.views:1|c",
+		expected: []map[string]interface{}{{
+			"name":  "page.views",
+			"value": float64(1),
+			"type":  "counter",
+		}},
+	},
+	{
+		line: "api.latency:32.5|ms|@0.5|#env:prod,route:/users,canary",
+		expected: []map[string]interface{}{{
+			"name":        "api.latency",
+			"value":       32.5,
+			"type":        "timer",
+			"sample_rate": 0.5,
+			"tag.env":     "prod",
+			"tag.route":   "/users",
+			"tag.canary":  "true",
+		}},
+	},
+	{
+		line: "users.online:alice|s",
+		expected: []map[string]interface{}{{
+			"name":  "users.online",
+			"value": "alice",
+			"type":  "set",
+		}},
+	},
+	{
+		line: "queue.depth:-3|g|#name:jobs|c:4f1d2a|T1493632800",
+		expected: []map[string]interface{}{{
+			"name":         "queue.depth",
+			"value":        float64(-3),
+			"type":         "gauge",
+			"tag.name":     "jobs",
+			"container_id": "4f1d2a",
+		}},
+		ts: time.Date(2017, 5, 1, 10, 0, 0, 0, time.UTC),
+	},
+	{
+		line: "req.size:10:20|d",
+		expected: []map[string]interface{}{
+			{
+				"name":  "req.size",
+				"value": float64(10),
+				"type":  "distribution",
+			},
+			{
+				"name":  "req.size",
+				"value": float64(20),
+				"type":  "distribution",
+			},
+		},
+	},
+}
+
+func TestParseLine(t *testing.T) {
+	p := &StatsdLineParser{}
+	for _, tlm := range tlms {
+		resp, ts, err := p.ParseLine(tlm.line)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tlm.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(resp, tlm.expected) {
+			t.Errorf("response from ParseLine(%q)\n\t%+v\ndid not match expected\n\t%+v", tlm.line, resp, tlm.expected)
+		}
+		if !ts.Equal(tlm.ts) {
+			t.Errorf("timestamp from ParseLine(%q) was %s, expected %s", tlm.line, ts, tlm.ts)
+		}
+	}
+}
+
+func TestParseLineErrors(t *testing.T) {
+	p := &StatsdLineParser{}
+	for _, line := range []string{
+		"",
+		"no.type:1",
+		"bad.type:1|x",
+		"bad.value:abc|c",
+		"bad.rate:1|c|@2",
+		"_e{5,4}:title|text",
+		"_sc|redis.up|0",
+	} {
+		if _, _, err := p.ParseLine(line); err == nil {
+			t.Errorf("expected an error parsing %q", line)
+		}
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{
+		conf:       Options{NumParsers: 1},
+		lineParser: &StatsdLineParser{},
+		nower:      &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		for _, line := range []string{"req.size:10:20|d", "garbage", "page.views:1|c"} {
+			lines <- line
+		}
+		close(lines)
+	}()
+	go func() {
+		p.ProcessLines(lines, send, nil)
+		close(send)
+	}()
+	var evs []event.Event
+	for ev := range send {
+		evs = append(evs, ev)
+	}
+	if len(evs) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(evs))
+	}
+	for _, ev := range evs {
+		if !ev.Timestamp.Equal((&FakeNower{}).Now()) {
+			t.Errorf("event timestamp %s should have come from the nower", ev.Timestamp)
+		}
+	}
+	if evs[2].Data["name"] != "page.views" {
+		t.Errorf("unexpected last event %+v", evs[2].Data)
+	}
+}
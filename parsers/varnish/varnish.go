@@ -0,0 +1,359 @@
+// Package varnish parses the access logs written by varnishncsa.
+//
+// The format string given to varnishncsa with -F (by default
+// `%h %l %u %t "%r" %s %b "%{Referer}i" "%{User-agent}i"`) is turned into a
+// regular expression, so each format specifier becomes a field. Fields are
+// named like nginx's variables where the two overlap, eg %{Referer}i becomes
+// http_referer and %b body_bytes_sent, and Varnish's own %{Varnish:...}x
+// details get their own: hit_miss, handling, side, time_firstbyte and vxid.
+// The backend name can be logged with %{VCL_Log:backend}x, after
+// std.log("backend:" + beresp.backend.name) in VCL, or with
+// %{VSL:BackendOpen[2]}x.
+package varnish
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	timestampKey = "timestamp"
+	backendKey   = "backend"
+
+	// %t writes the time like a common log format line
+	commonLogTimeLayout = "[02/Jan/2006:15:04:05 -0700]"
+)
+
+type valueKind int
+
+const (
+	stringValue valueKind = iota
+	intValue
+	floatValue
+	timeValue
+	// skipValue is matched but not kept
+	skipValue
+)
+
+// specifier is how a format specifier is matched and which field it fills
+type specifier struct {
+	field   string
+	pattern string
+	kind    valueKind
+	// layout is the time layout of timeValues
+	layout string
+}
+
+// specifiers are the format specifiers without a {option}
+var specifiers = map[string]specifier{
+	"b": {field: "body_bytes_sent", pattern: `\d+|-`, kind: intValue},
+	"D": {field: "duration_us", pattern: `\d+`, kind: intValue},
+	"H": {field: "protocol", pattern: `\S*`},
+	"h": {field: "remote_addr", pattern: `\S+`},
+	"I": {field: "request_length", pattern: `\d+|-`, kind: intValue},
+	"l": {pattern: `\S+`, kind: skipValue},
+	"m": {field: "method", pattern: `\S*`},
+	"O": {field: "bytes_sent", pattern: `\d+|-`, kind: intValue},
+	"q": {field: "query_string", pattern: `\S*`},
+	"r": {field: "request", pattern: `.*?`},
+	"s": {field: "status", pattern: `\d+`, kind: intValue},
+	"t": {field: timestampKey, pattern: `\[[^\]]*\]`, kind: timeValue, layout: commonLogTimeLayout},
+	"T": {field: "request_time", pattern: `\d+`, kind: intValue},
+	"U": {field: "path", pattern: `[^?\s]*`},
+	"u": {field: "remote_user", pattern: `\S*`},
+}
+
+// varnishDetails are the %{Varnish:...}x specifiers
+var varnishDetails = map[string]specifier{
+	"hitmiss":        {field: "hit_miss", pattern: `\S*`},
+	"handling":       {field: "handling", pattern: `\S*`},
+	"side":           {field: "side", pattern: `\S*`},
+	"time_firstbyte": {field: "time_firstbyte", pattern: `[\d.]+|-`, kind: floatValue},
+	"vxid":           {field: "vxid", pattern: `\d+|-`, kind: intValue},
+}
+
+// formatSpecifier matches a %% or a format specifier with its {option}
+var formatSpecifier = regexp.MustCompile(`%(?:\{([^}]*)\})?([a-zA-Z%])`)
+
+type Options struct {
+	Format string `long:"format" description:"The format string varnishncsa was run with, as given to -F" default:"%h %l %u %t \"%r\" %s %b \"%{Referer}i\" \"%{User-agent}i\""`
+
+	NumParsers int `hidden:"true" description:"number of varnish parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string) (map[string]interface{}, error)
+}
+
+// FormatLineParser parses lines written with a varnishncsa format string
+type FormatLineParser struct {
+	re *regexp.Regexp
+	// fields are the specifiers filling each group of re
+	fields []specifier
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	lp, err := NewFormatLineParser(p.conf.Format)
+	if err != nil {
+		return err
+	}
+	p.lineParser = lp
+	return nil
+}
+
+// NewFormatLineParser builds a parser for lines written with format
+func NewFormatLineParser(format string) (*FormatLineParser, error) {
+	lp := &FormatLineParser{}
+	expr := "^"
+	last := 0
+	seen := make(map[string]bool)
+	for _, m := range formatSpecifier.FindAllStringSubmatchIndex(format, -1) {
+		expr += regexp.QuoteMeta(format[last:m[0]])
+		last = m[1]
+		letter := format[m[4]:m[5]]
+		if letter == "%" {
+			expr += "%"
+			continue
+		}
+		var option string
+		if m[2] >= 0 {
+			option = format[m[2]:m[3]]
+		}
+		spec, err := newSpecifier(letter, option)
+		if err != nil {
+			return nil, err
+		}
+		if spec.kind == skipValue || seen[spec.field] {
+			// the first occurrence is enough to capture it
+			expr += "(?:" + spec.pattern + ")"
+			continue
+		}
+		seen[spec.field] = true
+		expr += "(" + spec.pattern + ")"
+		lp.fields = append(lp.fields, spec)
+	}
+	expr += regexp.QuoteMeta(format[last:]) + "$"
+	if len(lp.fields) == 0 {
+		return nil, fmt.Errorf("format %q has no format specifiers in it", format)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("format %q: %s", format, err)
+	}
+	lp.re = re
+	return lp, nil
+}
+
+// newSpecifier returns how to match the specifier %{option}letter
+func newSpecifier(letter, option string) (specifier, error) {
+	switch letter {
+	case "i":
+		// request headers are named like nginx's $http_ variables
+		return specifier{field: "http_" + headerField(option), pattern: `.*?`}, nil
+	case "o":
+		return specifier{field: "sent_http_" + headerField(option), pattern: `.*?`}, nil
+	case "t":
+		if option == "" {
+			break
+		}
+		layout, err := strftimeLayout(option)
+		if err != nil {
+			return specifier{}, err
+		}
+		return specifier{field: timestampKey, pattern: `.*?`, kind: timeValue, layout: layout}, nil
+	case "x":
+		return extendedSpecifier(option), nil
+	}
+	spec, ok := specifiers[letter]
+	if !ok {
+		return specifier{}, fmt.Errorf("unknown format specifier %%%s", letter)
+	}
+	return spec, nil
+}
+
+// extendedSpecifier returns how to match %{option}x, which adds details
+// from Varnish itself or from the shared memory log
+func extendedSpecifier(option string) specifier {
+	parts := strings.SplitN(option, ":", 2)
+	if len(parts) != 2 {
+		return specifier{pattern: `.*?`, kind: skipValue}
+	}
+	switch parts[0] {
+	case "Varnish":
+		if spec, ok := varnishDetails[parts[1]]; ok {
+			return spec
+		}
+	case "VCL_Log":
+		return specifier{field: parts[1], pattern: `.*?`}
+	case "VSL":
+		// eg VSL:BackendOpen[2], the second word of the BackendOpen record,
+		// which names the backend
+		tag := parts[1]
+		if tag == "BackendOpen[2]" {
+			return specifier{field: backendKey, pattern: `\S*`}
+		}
+		tag = strings.NewReplacer("[", ".", "]", "").Replace(tag)
+		return specifier{field: "vsl." + strings.ToLower(tag), pattern: `.*?`}
+	}
+	return specifier{pattern: `.*?`, kind: skipValue}
+}
+
+// headerField turns a header name into a field name, eg User-agent becomes
+// user_agent
+func headerField(name string) string {
+	return strings.Replace(strings.ToLower(name), "-", "_", -1)
+}
+
+// strftimeDirectives are the strftime directives that map onto Go's time
+// layout
+var strftimeDirectives = map[byte]string{
+	'a': "Mon", 'A': "Monday", 'b': "Jan", 'B': "January", 'h': "Jan",
+	'd': "02", 'e': "_2", 'H': "15", 'I': "03", 'm': "01", 'M': "04",
+	'p': "PM", 'S': "05", 'y': "06", 'Y': "2006", 'z': "-0700", 'Z': "MST",
+	'T': "15:04:05", 'F': "2006-01-02", 'D': "01/02/06", 'R': "15:04",
+	'%': "%",
+}
+
+// strftimeLayout converts a strftime format, as used by %{format}t, to a Go
+// time layout
+func strftimeLayout(format string) (string, error) {
+	var layout []string
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			layout = append(layout, format[i:i+1])
+			continue
+		}
+		i++
+		if i == len(format) {
+			return "", fmt.Errorf("time format %q ends with %%", format)
+		}
+		l, ok := strftimeDirectives[format[i]]
+		if !ok {
+			return "", fmt.Errorf("time format %q: unsupported directive %%%c", format, format[i])
+		}
+		layout = append(layout, l)
+	}
+	return strings.Join(layout, ""), nil
+}
+
+// ParseLine returns the fields in a line, or an error if the line doesn't
+// match the format
+func (lp *FormatLineParser) ParseLine(line string) (map[string]interface{}, error) {
+	m := lp.re.FindStringSubmatch(line)
+	if m == nil {
+		return nil, errors.New("line doesn't match the format")
+	}
+	parsed := make(map[string]interface{})
+	for i, spec := range lp.fields {
+		val := m[i+1]
+		switch spec.kind {
+		case intValue:
+			if val == "-" {
+				// varnishncsa writes - for a missing size or id
+				if spec.field == "body_bytes_sent" {
+					parsed[spec.field] = 0
+				}
+				continue
+			}
+			if n, err := strconv.Atoi(val); err == nil {
+				parsed[spec.field] = n
+				continue
+			}
+		case floatValue:
+			if val == "-" {
+				continue
+			}
+			if f, err := strconv.ParseFloat(val, 64); err == nil {
+				parsed[spec.field] = f
+				continue
+			}
+		case timeValue:
+			if t, err := time.Parse(spec.layout, val); err == nil {
+				parsed[spec.field] = t
+				continue
+			}
+		}
+		if val == "-" || val == "" {
+			// an empty header or field
+			continue
+		}
+		parsed[spec.field] = val
+	}
+	return parsed, nil
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for line := range lines {
+				logrus.WithFields(logrus.Fields{
+					"line": line,
+				}).Debug("Attempting to process varnish log line")
+
+				// take care of any headers on the line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, err := p.lineParser.ParseLine(line)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+				timestamp := p.nower.Now()
+				if t, ok := parsedLine[timestampKey].(time.Time); ok {
+					timestamp = t.UTC()
+					delete(parsedLine, timestampKey)
+				}
+
+				send <- event.Event{
+					Timestamp: timestamp,
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending varnish processor")
+}
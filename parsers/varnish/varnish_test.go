@@ -0,0 +1,119 @@
+package varnish
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+func processLines(p *Parser, lines []string, prefixRegex *parsers.ExtRegexp) []event.Event {
+	linesChan := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		for _, line := range lines {
+			linesChan <- line
+		}
+		close(linesChan)
+	}()
+	go func() {
+		p.ProcessLines(linesChan, send, prefixRegex)
+		close(send)
+	}()
+	var events []event.Event
+	for ev := range send {
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestDefaultFormat(t *testing.T) {
+	p := &Parser{}
+	if err := p.Init(&Options{Format: `%h %l %u %t "%r" %s %b "%{Referer}i" "%{User-agent}i"`, NumParsers: 1}); err != nil {
+		t.Fatal(err)
+	}
+	p.nower = &FakeNower{}
+	events := processLines(p, []string{
+		`192.0.2.1 - - [21/Jun/2010:10:00:00 -0700] "GET http://example.com/a?b=1 HTTP/1.1" 200 1234 "-" "curl/7.50"`,
+		`192.0.2.2 - bob [21/Jun/2010:10:00:01 +0000] "POST http://example.com/login HTTP/1.1" 304 - "http://example.com/" "Mozilla/5.0 (X11; Linux)"`,
+		`not a varnish line`,
+	}, nil)
+	expected := []event.Event{
+		{
+			Timestamp: time.Date(2010, 6, 21, 17, 0, 0, 0, time.UTC),
+			Data: map[string]interface{}{
+				"remote_addr":     "192.0.2.1",
+				"request":         "GET http://example.com/a?b=1 HTTP/1.1",
+				"status":          200,
+				"body_bytes_sent": 1234,
+				"http_user_agent": "curl/7.50",
+			},
+		},
+		{
+			Timestamp: time.Date(2010, 6, 21, 10, 0, 1, 0, time.UTC),
+			Data: map[string]interface{}{
+				"remote_addr":     "192.0.2.2",
+				"remote_user":     "bob",
+				"request":         "POST http://example.com/login HTTP/1.1",
+				"status":          304,
+				"body_bytes_sent": 0,
+				"http_referer":    "http://example.com/",
+				"http_user_agent": "Mozilla/5.0 (X11; Linux)",
+			},
+		},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("got events %+v, expected %+v", events, expected)
+	}
+}
+
+func TestCustomFormat(t *testing.T) {
+	lp, err := NewFormatLineParser(`%{%Y-%m-%dT%H:%M:%S%z}t %m %U%q %s %D %{Varnish:hitmiss}x %{Varnish:handling}x %{Varnish:time_firstbyte}x %{VCL_Log:backend}x %{X-Cache}o %{Varnish:vxid}x 100%%`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := lp.ParseLine(`2010-06-21T10:00:00+0000 GET /a?b=1 200 1500 miss pass 0.001234 app_1 MISS 32770 100%`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"method":            "GET",
+		"path":              "/a",
+		"query_string":      "?b=1",
+		"status":            200,
+		"duration_us":       1500,
+		"hit_miss":          "miss",
+		"handling":          "pass",
+		"time_firstbyte":    0.001234,
+		"backend":           "app_1",
+		"sent_http_x_cache": "MISS",
+		"vxid":              32770,
+	}
+	if ts, _ := parsed["timestamp"].(time.Time); !ts.Equal(time.Date(2010, 6, 21, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("got timestamp %v, expected 2010-06-21 10:00:00 UTC", parsed["timestamp"])
+	}
+	delete(parsed, "timestamp")
+	if !reflect.DeepEqual(parsed, expected) {
+		t.Errorf("got %+v, expected %+v", parsed, expected)
+	}
+	if _, err := lp.ParseLine("nope"); err == nil {
+		t.Error("expected error parsing a line not in the format")
+	}
+}
+
+func TestBadFormats(t *testing.T) {
+	for _, format := range []string{"no specifiers", "%h %Q", "%{%Y %k}t"} {
+		if _, err := NewFormatLineParser(format); err == nil {
+			t.Errorf("expected error building a parser for %q", format)
+		}
+	}
+}
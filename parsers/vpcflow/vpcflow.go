@@ -0,0 +1,228 @@
+// Package vpcflow parses AWS VPC Flow Log records.
+//
+// Records are space separated. The default (version 2) format is used unless
+// --vpcflow.fields lists a custom format, or the log has a header line naming
+// its fields, as flow logs delivered to S3 do. Field names have dashes
+// replaced with underscores, so log-status becomes log_status.
+// https://docs.aws.amazon.com/vpc/latest/userguide/flow-logs.html
+package vpcflow
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	startKey    = "start"
+	endKey      = "end"
+	durationKey = "duration_sec"
+)
+
+// defaultFields is the version 2 default format
+const defaultFields = "version account-id interface-id srcaddr dstaddr srcport dstport protocol packets bytes start end action log-status"
+
+// knownFields are all the fields flow logs may contain, used to recognize
+// header lines
+var knownFields = map[string]bool{}
+
+func init() {
+	for _, f := range strings.Fields(defaultFields + ` vpc-id subnet-id
+		instance-id tcp-flags type pkt-srcaddr pkt-dstaddr region az-id
+		sublocation-type sublocation-id pkt-src-aws-service pkt-dst-aws-service
+		flow-direction traffic-path`) {
+		knownFields[f] = true
+		knownFields[strings.Replace(f, "-", "_", -1)] = true
+	}
+}
+
+// intFields are the fields that aren't strings
+var intFields = map[string]bool{
+	"version":      true,
+	"srcport":      true,
+	"dstport":      true,
+	"protocol":     true,
+	"packets":      true,
+	"bytes":        true,
+	"start":        true,
+	"end":          true,
+	"tcp_flags":    true,
+	"traffic_path": true,
+}
+
+type Options struct {
+	Fields string `long:"fields" description:"Space separated list of the fields in each record, for flow logs with a custom format. Either aws-format (${srcaddr}) or plain names may be used. A header line naming the fields takes precedence" default:"version account-id interface-id srcaddr dstaddr srcport dstport protocol packets bytes start end action log-status"`
+
+	NumParsers int `hidden:"true" description:"number of vpcflow parsers to spin up"`
+}
+
+type Parser struct {
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+	fields     []string
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+type LineParser interface {
+	ParseLine(line string, fields []string) (map[string]interface{}, error)
+}
+
+type VPCFlowLineParser struct{}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	p.lineParser = &VPCFlowLineParser{}
+	p.fields = parseFields(p.conf.Fields)
+	if len(p.fields) == 0 {
+		p.fields = parseFields(defaultFields)
+	}
+	for _, f := range p.fields {
+		if !knownFields[f] {
+			return errors.New("unknown vpc flow log field " + f)
+		}
+	}
+	return nil
+}
+
+// parseFields turns a format like "${version} ${vpc-id}" into field names
+func parseFields(format string) []string {
+	var fields []string
+	for _, f := range strings.Fields(format) {
+		f = strings.TrimSuffix(strings.TrimPrefix(f, "${"), "}")
+		fields = append(fields, strings.Replace(f, "-", "_", -1))
+	}
+	return fields
+}
+
+// isHeader returns true if the line names fields rather than holding values
+func isHeader(line string) bool {
+	tokens := strings.Fields(line)
+	return len(tokens) > 0 && knownFields[tokens[0]]
+}
+
+// ParseLine maps the values in line to the named fields
+func (v *VPCFlowLineParser) ParseLine(line string, fields []string) (map[string]interface{}, error) {
+	values := strings.Fields(line)
+	if len(values) != len(fields) {
+		return nil, errors.New("number of values doesn't match the number of fields")
+	}
+	parsed := make(map[string]interface{}, len(values))
+	for i, val := range values {
+		name := fields[i]
+		// - means the value doesn't apply, eg for NODATA records
+		if val == "-" {
+			continue
+		}
+		if intFields[name] {
+			if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+				parsed[name] = n
+				continue
+			}
+		}
+		parsed[name] = val
+	}
+	return parsed, nil
+}
+
+// fieldsLine is a line along with the fields in effect when it was read
+type fieldsLine struct {
+	line   string
+	fields []string
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	// headers have to be read in order, so track them here and hand each line
+	// to the workers along with the fields it should be parsed with
+	toParse := make(chan fieldsLine)
+	go func() {
+		fields := p.fields
+		for line := range lines {
+			logrus.WithFields(logrus.Fields{
+				"line": line,
+			}).Debug("Attempting to process vpcflow log line")
+
+			// take care of any headers on the line
+			var prefix string
+			if prefixRegex != nil {
+				prefix, _ = prefixRegex.FindStringSubmatchMap(line)
+			}
+			content := strings.TrimPrefix(line, prefix)
+			if isHeader(content) {
+				fields = parseFields(content)
+				continue
+			}
+			toParse <- fieldsLine{line: line, fields: fields}
+		}
+		close(toParse)
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for fl := range toParse {
+				line := fl.line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, err := p.lineParser.ParseLine(line, fl.fields)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp: p.getTimestamp(parsedLine),
+					Data:      parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending vpcflow processor")
+}
+
+// getTimestamp uses the start of the capture window. The end of the window
+// is kept as a time, along with the window's length.
+func (p *Parser) getTimestamp(m map[string]interface{}) time.Time {
+	start, ok := m[startKey].(int64)
+	if !ok {
+		return p.nower.Now()
+	}
+	delete(m, startKey)
+	if end, ok := m[endKey].(int64); ok {
+		m[endKey] = time.Unix(end, 0).UTC().Format(time.RFC3339)
+		m[durationKey] = end - start
+	}
+	return time.Unix(start, 0).UTC()
+}
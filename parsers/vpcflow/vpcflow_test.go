@@ -0,0 +1,128 @@
+package vpcflow
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+func TestParseFields(t *testing.T) {
+	got := parseFields("${version} ${vpc-id} ${log-status}")
+	expected := []string{"version", "vpc_id", "log_status"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+}
+
+func TestInit(t *testing.T) {
+	p := &Parser{}
+	if err := p.Init(&Options{Fields: "${version} ${srcaddr}"}); err != nil {
+		t.Error(err)
+	}
+	if err := p.Init(&Options{Fields: "version favorite-color"}); err == nil {
+		t.Error("expected error for unknown field")
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	vfp := VPCFlowLineParser{}
+	fields := parseFields(defaultFields)
+	testCases := []struct {
+		input    string
+		expected map[string]interface{}
+	}{
+		{
+			input: "2 123456789010 eni-1235b8ca123456789 172.31.16.139 172.31.16.21 20641 22 6 20 4249 1418530010 1418530070 ACCEPT OK",
+			expected: map[string]interface{}{
+				"version":      int64(2),
+				"account_id":   "123456789010",
+				"interface_id": "eni-1235b8ca123456789",
+				"srcaddr":      "172.31.16.139",
+				"dstaddr":      "172.31.16.21",
+				"srcport":      int64(20641),
+				"dstport":      int64(22),
+				"protocol":     int64(6),
+				"packets":      int64(20),
+				"bytes":        int64(4249),
+				"start":        int64(1418530010),
+				"end":          int64(1418530070),
+				"action":       "ACCEPT",
+				"log_status":   "OK",
+			},
+		},
+		{
+			input: "2 123456789010 eni-1235b8ca123456789 - - - - - - - 1431280876 1431280934 - NODATA",
+			expected: map[string]interface{}{
+				"version":      int64(2),
+				"account_id":   "123456789010",
+				"interface_id": "eni-1235b8ca123456789",
+				"start":        int64(1431280876),
+				"end":          int64(1431280934),
+				"log_status":   "NODATA",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		resp, err := vfp.ParseLine(tc.input, fields)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tc.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(resp, tc.expected) {
+			t.Errorf("response %+v didn't match expected %+v", resp, tc.expected)
+		}
+	}
+	if _, err := vfp.ParseLine("2 123456789010", fields); err == nil {
+		t.Error("expected error parsing short line")
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{
+		conf:       Options{NumParsers: 1},
+		lineParser: &VPCFlowLineParser{},
+		nower:      &FakeNower{},
+		fields:     parseFields(defaultFields),
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		lines <- "2 123456789010 eni-1235b8ca123456789 172.31.16.139 172.31.16.21 20641 22 6 20 4249 1418530010 1418530070 ACCEPT OK"
+		// a header switches to a custom format
+		lines <- "version vpc-id start end tcp-flags"
+		lines <- "3 vpc-abcdefab012345678 1566848875 1566848933 2"
+		close(lines)
+	}()
+	go p.ProcessLines(lines, send, nil)
+
+	ev := <-send
+	if !ev.Timestamp.Equal(time.Unix(1418530010, 0)) {
+		t.Errorf("got timestamp %v", ev.Timestamp)
+	}
+	if ev.Data["end"] != "2014-12-14T04:07:50Z" || ev.Data["duration_sec"] != int64(60) {
+		t.Errorf("unexpected data %+v", ev.Data)
+	}
+	ev = <-send
+	expected := event.Event{
+		Timestamp: time.Unix(1566848875, 0).UTC(),
+		Data: map[string]interface{}{
+			"version":      int64(3),
+			"vpc_id":       "vpc-abcdefab012345678",
+			"end":          "2019-08-26T19:48:53Z",
+			"duration_sec": int64(58),
+			"tcp_flags":    int64(2),
+		},
+	}
+	if !reflect.DeepEqual(ev, expected) {
+		t.Errorf("got event %+v, expected %+v", ev, expected)
+	}
+}
@@ -0,0 +1,250 @@
+// Package winevent parses Windows Event Log records rendered as XML, such as
+// the output of `wevtutil qe /f:xml` or events forwarded by a collector.
+package winevent
+
+import (
+	"encoding/xml"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	providerKey  = "provider"
+	eventIDKey   = "event_id"
+	versionKey   = "version"
+	levelKey     = "level"
+	levelNameKey = "level_name"
+	taskKey      = "task"
+	opcodeKey    = "opcode"
+	keywordsKey  = "keywords"
+	recordIDKey  = "record_id"
+	processIDKey = "process_id"
+	threadIDKey  = "thread_id"
+	channelKey   = "channel"
+	computerKey  = "computer"
+	userIDKey    = "user_id"
+	messageKey   = "message"
+
+	eventEndTag = "</Event>"
+)
+
+// levelNames maps the numeric Level from the System block to the names shown
+// by Event Viewer.
+var levelNames = map[int64]string{
+	0: "LogAlways",
+	1: "Critical",
+	2: "Error",
+	3: "Warning",
+	4: "Information",
+	5: "Verbose",
+}
+
+type Options struct {
+	DataPrefix string `long:"data_prefix" description:"Prefix to add to the names of fields extracted from EventData to prevent collisions with the System fields"`
+
+	NumParsers int `hidden:"true" description:"number of winevent parsers to spin up"`
+}
+
+type Parser struct {
+	// set SampleRate to cause the parser to drop records once their lines
+	// have been gathered
+	SampleRate int
+
+	conf  Options
+	nower Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+// xmlEvent mirrors the parts of the Windows event schema we extract.
+// http://schemas.microsoft.com/win/2004/08/events/event
+type xmlEvent struct {
+	System struct {
+		Provider struct {
+			Name string `xml:"Name,attr"`
+		} `xml:"Provider"`
+		EventID     string `xml:"EventID"`
+		Version     string `xml:"Version"`
+		Level       string `xml:"Level"`
+		Task        string `xml:"Task"`
+		Opcode      string `xml:"Opcode"`
+		Keywords    string `xml:"Keywords"`
+		TimeCreated struct {
+			SystemTime string `xml:"SystemTime,attr"`
+		} `xml:"TimeCreated"`
+		EventRecordID string `xml:"EventRecordID"`
+		Execution     struct {
+			ProcessID string `xml:"ProcessID,attr"`
+			ThreadID  string `xml:"ThreadID,attr"`
+		} `xml:"Execution"`
+		Channel  string `xml:"Channel"`
+		Computer string `xml:"Computer"`
+		Security struct {
+			UserID string `xml:"UserID,attr"`
+		} `xml:"Security"`
+	} `xml:"System"`
+	EventData struct {
+		Data []xmlData `xml:"Data"`
+	} `xml:"EventData"`
+	RenderingInfo struct {
+		Message string `xml:"Message"`
+	} `xml:"RenderingInfo"`
+}
+
+type xmlData struct {
+	Name  string `xml:"Name,attr"`
+	Value string `xml:",chardata"`
+}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	return nil
+}
+
+// ProcessLines collects lines until a closing </Event> tag is seen, so that
+// both one-record-per-line and pretty-printed XML are accepted, then hands the
+// complete records off to be decoded.
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	records := make(chan string)
+	wg := sync.WaitGroup{}
+	numParsers := p.conf.NumParsers
+	if numParsers < 1 {
+		numParsers = 1
+	}
+	for i := 0; i < numParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for record := range records {
+				data, err := p.parseRecord(record)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"record": record,
+						"error":  err,
+					}).Debug("skipping record; failed to parse.")
+					continue
+				}
+				send <- event.Event{
+					Timestamp:  p.getTimestamp(data),
+					SampleRate: p.SampleRate,
+					Data:       data,
+				}
+			}
+			wg.Done()
+		}()
+	}
+
+	var buf []string
+	for line := range lines {
+		// the prefix can only be stripped; it's not possible to attribute the
+		// fields of each line's prefix to the event.
+		if prefixRegex != nil {
+			line = strings.TrimPrefix(line, prefixRegex.FindString(line))
+		}
+		buf = append(buf, line)
+		if strings.Contains(line, eventEndTag) {
+			// if sampling is disabled or sampler says keep, pass along this record.
+			if p.SampleRate <= 1 || rand.Intn(p.SampleRate) == 0 {
+				records <- strings.Join(buf, "\n")
+			}
+			buf = nil
+		}
+	}
+	if len(buf) != 0 {
+		logrus.WithField("record", strings.Join(buf, "\n")).Debug(
+			"lines channel closed with an incomplete record; dropping it")
+	}
+	close(records)
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending winevent processor")
+}
+
+// parseRecord decodes a single <Event> element into a flat map
+func (p *Parser) parseRecord(record string) (map[string]interface{}, error) {
+	var ev xmlEvent
+	if err := xml.Unmarshal([]byte(record), &ev); err != nil {
+		return nil, err
+	}
+	sys := ev.System
+	data := make(map[string]interface{})
+	setString(data, providerKey, sys.Provider.Name)
+	setInt(data, eventIDKey, sys.EventID)
+	setInt(data, versionKey, sys.Version)
+	if setInt(data, levelKey, sys.Level) {
+		if name, ok := levelNames[data[levelKey].(int64)]; ok {
+			data[levelNameKey] = name
+		}
+	}
+	setInt(data, taskKey, sys.Task)
+	setInt(data, opcodeKey, sys.Opcode)
+	setString(data, keywordsKey, sys.Keywords)
+	setInt(data, recordIDKey, sys.EventRecordID)
+	setInt(data, processIDKey, sys.Execution.ProcessID)
+	setInt(data, threadIDKey, sys.Execution.ThreadID)
+	setString(data, channelKey, sys.Channel)
+	setString(data, computerKey, sys.Computer)
+	setString(data, userIDKey, sys.Security.UserID)
+	setString(data, messageKey, strings.TrimSpace(ev.RenderingInfo.Message))
+	if sys.TimeCreated.SystemTime != "" {
+		data["timestamp"] = sys.TimeCreated.SystemTime
+	}
+
+	for i, d := range ev.EventData.Data {
+		name := d.Name
+		if name == "" {
+			// unnamed data items are positional
+			name = "data_" + strconv.Itoa(i)
+		}
+		setString(data, p.conf.DataPrefix+name, strings.TrimSpace(d.Value))
+	}
+	return data, nil
+}
+
+// getTimestamp pulls the SystemTime out of the event, falling back to now
+func (p *Parser) getTimestamp(data map[string]interface{}) time.Time {
+	defer delete(data, "timestamp")
+	if raw, ok := data["timestamp"].(string); ok {
+		if ts, err := time.Parse(time.RFC3339Nano, raw); err == nil {
+			return ts
+		}
+		logrus.WithField("timestamp", raw).Debug("unable to parse SystemTime")
+	}
+	return p.nower.Now()
+}
+
+func setString(data map[string]interface{}, key, val string) {
+	if val != "" && val != "-" {
+		data[key] = val
+	}
+}
+
+// setInt stores val as an int64 if it's numeric, or as the raw string if not.
+// It reports whether an integer was stored.
+func setInt(data map[string]interface{}, key, val string) bool {
+	if val == "" {
+		return false
+	}
+	i, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		data[key] = val
+		return false
+	}
+	data[key] = i
+	return true
+}
@@ -0,0 +1,162 @@
+package winevent
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+const logonEvent = `<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'><System><Provider Name='Microsoft-Windows-Security-Auditing' Guid='{54849625-5478-4994-A5BA-3E3B0328C30D}'/><EventID>4624</EventID><Version>2</Version><Level>0</Level><Task>12544</Task><Opcode>0</Opcode><Keywords>0x8020000000000000</Keywords><TimeCreated SystemTime='2017-06-12T18:21:47.1234567Z'/><EventRecordID>84207</EventRecordID><Correlation/><Execution ProcessID='628' ThreadID='3244'/><Channel>Security</Channel><Computer>dc01.example.com</Computer><Security/></System><EventData><Data Name='TargetUserName'>alice</Data><Data Name='LogonType'>3</Data><Data Name='IpAddress'>10.0.0.5</Data><Data Name='IpPort'>-</Data></EventData></Event>`
+
+var prettyEvent = []string{
+	`<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'>`,
+	`  <System>`,
+	`    <Provider Name='Service Control Manager'/>`,
+	`    <EventID Qualifiers='16384'>7036</EventID>`,
+	`    <Level>4</Level>`,
+	`    <TimeCreated SystemTime='2017-06-12T18:22:00.000Z'/>`,
+	`    <EventRecordID>1001</EventRecordID>`,
+	`    <Channel>System</Channel>`,
+	`    <Computer>web01</Computer>`,
+	`    <Security UserID='S-1-5-18'/>`,
+	`  </System>`,
+	`  <EventData>`,
+	`    <Data>Windows Update</Data>`,
+	`    <Data>running</Data>`,
+	`  </EventData>`,
+	`</Event>`,
+}
+
+func TestProcessLines(t *testing.T) {
+	t1, _ := time.Parse(time.RFC3339Nano, "2017-06-12T18:21:47.1234567Z")
+	t2, _ := time.Parse(time.RFC3339Nano, "2017-06-12T18:22:00.000Z")
+	expected := []event.Event{
+		{
+			Timestamp: t1,
+			Data: map[string]interface{}{
+				"provider":       "Microsoft-Windows-Security-Auditing",
+				"event_id":       int64(4624),
+				"version":        int64(2),
+				"level":          int64(0),
+				"level_name":     "LogAlways",
+				"task":           int64(12544),
+				"opcode":         int64(0),
+				"keywords":       "0x8020000000000000",
+				"record_id":      int64(84207),
+				"process_id":     int64(628),
+				"thread_id":      int64(3244),
+				"channel":        "Security",
+				"computer":       "dc01.example.com",
+				"TargetUserName": "alice",
+				"LogonType":      "3",
+				"IpAddress":      "10.0.0.5",
+			},
+		},
+		{
+			Timestamp: t2,
+			Data: map[string]interface{}{
+				"provider":   "Service Control Manager",
+				"event_id":   int64(7036),
+				"level":      int64(4),
+				"level_name": "Information",
+				"record_id":  int64(1001),
+				"channel":    "System",
+				"computer":   "web01",
+				"user_id":    "S-1-5-18",
+				"data_0":     "Windows Update",
+				"data_1":     "running",
+			},
+		},
+	}
+
+	p := &Parser{
+		conf:  Options{NumParsers: 1},
+		nower: &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		lines <- logonEvent
+		for _, line := range prettyEvent {
+			lines <- line
+		}
+		// an incomplete trailing record should be dropped
+		lines <- `<Event><System>`
+		close(lines)
+	}()
+	go func() {
+		p.ProcessLines(lines, send, nil)
+		close(send)
+	}()
+	for _, exp := range expected {
+		ev := <-send
+		if !ev.Timestamp.Equal(exp.Timestamp) {
+			t.Errorf("timestamp %v didn't match expected %v", ev.Timestamp, exp.Timestamp)
+		}
+		if !reflect.DeepEqual(ev.Data, exp.Data) {
+			t.Errorf("data %+v didn't match expected %+v", ev.Data, exp.Data)
+		}
+	}
+	if ev, ok := <-send; ok {
+		t.Errorf("unexpected extra event %+v", ev)
+	}
+}
+
+func TestDataPrefix(t *testing.T) {
+	p := &Parser{conf: Options{DataPrefix: "data."}, nower: &FakeNower{}}
+	data, err := p.parseRecord(logonEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data["data.TargetUserName"] != "alice" {
+		t.Errorf("expected prefixed EventData field, got %+v", data)
+	}
+	if _, ok := data["TargetUserName"]; ok {
+		t.Errorf("unexpected unprefixed EventData field in %+v", data)
+	}
+}
+
+func TestProcessLinesSampled(t *testing.T) {
+	p := &Parser{
+		SampleRate: 10,
+		conf:       Options{NumParsers: 2},
+		nower:      &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			for _, line := range prettyEvent {
+				lines <- line
+			}
+		}
+		close(lines)
+	}()
+	go func() {
+		p.ProcessLines(lines, send, nil)
+		close(send)
+	}()
+	// records are sampled whole, so every one kept parses
+	var kept int
+	for ev := range send {
+		if ev.SampleRate != 10 {
+			t.Errorf("expected sample rate 10, got %d", ev.SampleRate)
+		}
+		if ev.Data["event_id"] != int64(7036) || ev.Data["data_1"] != "running" {
+			t.Errorf("unexpected data %+v", ev.Data)
+		}
+		kept++
+	}
+	if kept < 30 || kept > 300 {
+		t.Errorf("expected about 100 records to be kept, got %d", kept)
+	}
+}
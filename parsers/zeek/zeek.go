@@ -0,0 +1,293 @@
+// Package zeek parses the tab separated logs written by Zeek (formerly Bro),
+// such as conn.log and http.log.
+//
+// Each log starts with headers naming and typing its columns:
+//
+//	#separator \x09
+//	#set_separator	,
+//	#empty_field	(empty)
+//	#unset_field	-
+//	#path	conn
+//	#fields	ts	uid	id.orig_h	id.orig_p	id.resp_h	id.resp_p	proto	duration	orig_bytes
+//	#types	time	string	addr	port	addr	port	enum	interval	count
+//
+// and the values on each line after them are typed to match: counts, ints
+// and ports become integers, doubles and intervals floats, and bools true or
+// false. Sets and vectors are kept as they were written, separated by the
+// set separator. Unset fields are left out, as are empty sets. The ts
+// column is used as the event's time, and the log's path is kept as _path,
+// as it is in Zeek's JSON logs.
+//
+// Lines before the first #fields header can't be parsed, so when a file is
+// read from part way through, the headers at its start are read first.
+// Lines are sampled after the headers have been taken care of, so sampling
+// never drops a header.
+package zeek
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const (
+	pathKey = "_path"
+	tsKey   = "ts"
+)
+
+type Options struct {
+	NumParsers int `hidden:"true" description:"number of zeek parsers to spin up"`
+}
+
+type Parser struct {
+	// set SampleRate to cause the parser to drop lines after the headers
+	// before them have been read
+	SampleRate int
+
+	conf       Options
+	lineParser LineParser
+	nower      Nower
+}
+
+type Nower interface {
+	Now() time.Time
+}
+
+type RealNower struct{}
+
+func (r *RealNower) Now() time.Time {
+	return time.Now().UTC()
+}
+
+// header holds what the headers so far have said about the lines after
+// them. It's replaced rather than changed when a header is read, so it can
+// be handed to the workers.
+type header struct {
+	separator    string
+	setSeparator string
+	emptyField   string
+	unsetField   string
+	path         string
+	fields       []string
+	types        []string
+}
+
+// defaultHeader has Zeek's defaults, for logs whose headers leave them out
+var defaultHeader = header{
+	separator:    "\t",
+	setSeparator: ",",
+	emptyField:   "(empty)",
+	unsetField:   "-",
+}
+
+// withHeader returns a copy of h with the header line applied
+func (h *header) withHeader(line string) *header {
+	n := *h
+	// the separator header is itself separated by a space, as the separator
+	// isn't known yet
+	if strings.HasPrefix(line, "#separator ") {
+		n.separator = unescape(strings.TrimPrefix(line, "#separator "))
+		return &n
+	}
+	parts := strings.Split(line, h.separator)
+	name, values := parts[0], parts[1:]
+	if len(values) == 0 {
+		return h
+	}
+	switch name {
+	case "#set_separator":
+		n.setSeparator = unescape(values[0])
+	case "#empty_field":
+		n.emptyField = values[0]
+	case "#unset_field":
+		n.unsetField = values[0]
+	case "#path":
+		n.path = values[0]
+	case "#fields":
+		n.fields = values
+	case "#types":
+		n.types = values
+	default:
+		// #open and #close
+		return h
+	}
+	return &n
+}
+
+type LineParser interface {
+	ParseLine(line string, h *header) (map[string]interface{}, error)
+}
+
+type ZeekLineParser struct{}
+
+func (p *Parser) Init(options interface{}) error {
+	p.conf = *options.(*Options)
+	p.nower = &RealNower{}
+	p.lineParser = &ZeekLineParser{}
+	return nil
+}
+
+// ParseLine types the values in line by the columns in h
+func (z *ZeekLineParser) ParseLine(line string, h *header) (map[string]interface{}, error) {
+	if h.fields == nil {
+		return nil, errors.New("no #fields header has been read")
+	}
+	values := strings.Split(line, h.separator)
+	if len(values) != len(h.fields) {
+		return nil, errors.New("number of values doesn't match the number of fields")
+	}
+	parsed := make(map[string]interface{}, len(values)+1)
+	if h.path != "" {
+		parsed[pathKey] = h.path
+	}
+	for i, val := range values {
+		if val == h.unsetField || val == h.emptyField {
+			continue
+		}
+		var typ string
+		if i < len(h.types) {
+			typ = h.types[i]
+		}
+		parsed[h.fields[i]] = typeValue(val, typ)
+	}
+	return parsed, nil
+}
+
+// typeValue converts val to the Zeek type typ, or leaves it as a string if
+// it's not a number or bool, or doesn't convert
+func typeValue(val, typ string) interface{} {
+	switch typ {
+	case "count", "int", "port":
+		if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return n
+		}
+	case "double", "interval", "time":
+		if f, err := strconv.ParseFloat(val, 64); err == nil {
+			return f
+		}
+	case "bool":
+		switch val {
+		case "T":
+			return true
+		case "F":
+			return false
+		}
+	}
+	return unescape(val)
+}
+
+// unescape decodes the \xHH escapes Zeek writes for separators and
+// unprintable characters
+func unescape(s string) string {
+	if !strings.Contains(s, `\x`) {
+		return s
+	}
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if b, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				out = append(out, byte(b))
+				i += 3
+				continue
+			}
+		}
+		out = append(out, s[i])
+	}
+	return string(out)
+}
+
+// headerLine is a line along with the headers in effect when it was read
+type headerLine struct {
+	line   string
+	header *header
+}
+
+func (p *Parser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	// headers have to be read in order, so track them here and hand each line
+	// to the workers along with the headers it should be parsed with
+	toParse := make(chan headerLine)
+	go func() {
+		h := &defaultHeader
+		for line := range lines {
+			logrus.WithFields(logrus.Fields{
+				"line": line,
+			}).Debug("Attempting to process zeek log line")
+
+			// take care of any headers on the line
+			var prefix string
+			if prefixRegex != nil {
+				prefix, _ = prefixRegex.FindStringSubmatchMap(line)
+			}
+			content := strings.TrimPrefix(line, prefix)
+			if strings.HasPrefix(content, "#") {
+				h = h.withHeader(content)
+				continue
+			}
+			// if sampling is disabled or sampler says keep, pass along this line.
+			if p.SampleRate > 1 && rand.Intn(p.SampleRate) != 0 {
+				continue
+			}
+			toParse <- headerLine{line: line, header: h}
+		}
+		close(toParse)
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < p.conf.NumParsers; i++ {
+		wg.Add(1)
+		go func() {
+			for hl := range toParse {
+				line := hl.line
+				var prefixFields map[string]string
+				if prefixRegex != nil {
+					var prefix string
+					prefix, prefixFields = prefixRegex.FindStringSubmatchMap(line)
+					line = strings.TrimPrefix(line, prefix)
+				}
+
+				parsedLine, err := p.lineParser.ParseLine(line, hl.header)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"line":  line,
+						"error": err,
+					}).Debug("skipping line; failed to parse.")
+					continue
+				}
+				// merge the prefix fields and the parsed line contents
+				for k, v := range prefixFields {
+					parsedLine[k] = v
+				}
+
+				send <- event.Event{
+					Timestamp:  p.getTimestamp(parsedLine),
+					SampleRate: p.SampleRate,
+					Data:       parsedLine,
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	logrus.Debug("lines channel is closed, ending zeek processor")
+}
+
+// getTimestamp uses the ts column, the time the connection or request
+// started
+func (p *Parser) getTimestamp(m map[string]interface{}) time.Time {
+	ts, ok := m[tsKey].(float64)
+	if !ok {
+		return p.nower.Now()
+	}
+	delete(m, tsKey)
+	sec, frac := math.Modf(ts)
+	return time.Unix(int64(sec), int64(math.Floor(frac*1e6+0.5))*int64(time.Microsecond)).UTC()
+}
@@ -0,0 +1,228 @@
+package zeek
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type FakeNower struct{}
+
+func (f *FakeNower) Now() time.Time {
+	fakeTime, _ := time.Parse(time.RFC3339, "2010-06-21T15:04:05Z")
+	return fakeTime
+}
+
+var connHeaders = []string{
+	`#separator \x09`,
+	"#set_separator\t,",
+	"#empty_field\t(empty)",
+	"#unset_field\t-",
+	"#path\tconn",
+	"#open\t2019-08-26-19-00-00",
+	"#fields\tts\tuid\tid.orig_h\tid.orig_p\tid.resp_h\tid.resp_p\tproto\tservice\tduration\torig_bytes\tlocal_orig\ttunnel_parents",
+	"#types\ttime\tstring\taddr\tport\taddr\tport\tenum\tstring\tinterval\tcount\tbool\tset[string]",
+}
+
+func TestHeaders(t *testing.T) {
+	h := &defaultHeader
+	for _, line := range connHeaders {
+		h = h.withHeader(line)
+	}
+	if h.separator != "\t" || h.path != "conn" || len(h.fields) != 12 || len(h.types) != 12 {
+		t.Errorf("unexpected header %+v", h)
+	}
+	if defaultHeader.path != "" {
+		t.Error("reading headers changed the defaults")
+	}
+	h = h.withHeader(`#separator \x7c`)
+	if h.separator != "|" || h.path != "conn" {
+		t.Errorf("unexpected header %+v", h)
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	testCases := map[string]string{
+		`plain`:            "plain",
+		`a\x09b`:           "a\tb",
+		`caf\xc3\xa9`:      "café",
+		`trailing\x`:       `trailing\x`,
+		`not\xzzhex`:       `not\xzzhex`,
+		`\x2c\x2c`:         ",,",
+		`GET /a\x20b HTTP`: "GET /a b HTTP",
+		`end\x7`:           `end\x7`,
+	}
+	for input, expected := range testCases {
+		if got := unescape(input); got != expected {
+			t.Errorf("unescape(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	zlp := ZeekLineParser{}
+	h := &defaultHeader
+	for _, line := range connHeaders {
+		h = h.withHeader(line)
+	}
+	testCases := []struct {
+		input    string
+		expected map[string]interface{}
+	}{
+		{
+			input: "1566848875.123456\tCHhAvVGS1DHFjwGM9\t192.0.2.7\t51234\t198.51.100.1\t443\ttcp\tssl\t0.25\t1024\tT\t(empty)",
+			expected: map[string]interface{}{
+				"_path":      "conn",
+				"ts":         1566848875.123456,
+				"uid":        "CHhAvVGS1DHFjwGM9",
+				"id.orig_h":  "192.0.2.7",
+				"id.orig_p":  int64(51234),
+				"id.resp_h":  "198.51.100.1",
+				"id.resp_p":  int64(443),
+				"proto":      "tcp",
+				"service":    "ssl",
+				"duration":   0.25,
+				"orig_bytes": int64(1024),
+				"local_orig": true,
+			},
+		},
+		{
+			input: "1566848876.000000\tC4J4Th3PJpwUYZZ6gc\t192.0.2.8\t53\t198.51.100.2\t53\tudp\t-\t-\t-\tF\tCHhAvVGS1DHFjwGM9,ClEkJM2Vm5giqnMf4h",
+			expected: map[string]interface{}{
+				"_path":          "conn",
+				"ts":             1566848876.0,
+				"uid":            "C4J4Th3PJpwUYZZ6gc",
+				"id.orig_h":      "192.0.2.8",
+				"id.orig_p":      int64(53),
+				"id.resp_h":      "198.51.100.2",
+				"id.resp_p":      int64(53),
+				"proto":          "udp",
+				"local_orig":     false,
+				"tunnel_parents": "CHhAvVGS1DHFjwGM9,ClEkJM2Vm5giqnMf4h",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		resp, err := zlp.ParseLine(tc.input, h)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tc.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(resp, tc.expected) {
+			t.Errorf("response %+v didn't match expected %+v", resp, tc.expected)
+		}
+	}
+	if _, err := zlp.ParseLine("1566848875.123456\tCHhAvVGS1DHFjwGM9", h); err == nil {
+		t.Error("expected error parsing short line")
+	}
+	if _, err := zlp.ParseLine("1566848875.123456", &defaultHeader); err == nil {
+		t.Error("expected error parsing line without a #fields header")
+	}
+}
+
+func TestProcessLines(t *testing.T) {
+	p := &Parser{
+		conf:       Options{NumParsers: 1},
+		lineParser: &ZeekLineParser{},
+		nower:      &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		// lines before the headers can't be parsed
+		lines <- "1566848874.000000\tCxxxxxxxxxxxxxxxx"
+		for _, line := range connHeaders {
+			lines <- line
+		}
+		lines <- "1566848875.123456\tCHhAvVGS1DHFjwGM9\t192.0.2.7\t51234\t198.51.100.1\t443\ttcp\tssl\t0.25\t1024\tT\t(empty)"
+		lines <- "#close\t2019-08-26-20-00-00"
+		// a rotated log starts with its own headers
+		lines <- "#path\thttp"
+		lines <- "#fields\tuid\tmethod\turi\tstatus_code"
+		lines <- "#types\tstring\tstring\tstring\tcount"
+		lines <- "CHhAvVGS1DHFjwGM9\tGET\t/search?q=a\\x09b\t200"
+		close(lines)
+	}()
+	go p.ProcessLines(lines, send, nil)
+
+	ev := <-send
+	if !ev.Timestamp.Equal(time.Date(2019, 8, 26, 19, 47, 55, 123456000, time.UTC)) {
+		t.Errorf("got timestamp %v", ev.Timestamp)
+	}
+	if _, ok := ev.Data["ts"]; ok || ev.Data["uid"] != "CHhAvVGS1DHFjwGM9" {
+		t.Errorf("unexpected data %+v", ev.Data)
+	}
+	ev = <-send
+	expected := event.Event{
+		Timestamp: (&FakeNower{}).Now(),
+		Data: map[string]interface{}{
+			"_path":       "http",
+			"uid":         "CHhAvVGS1DHFjwGM9",
+			"method":      "GET",
+			"uri":         "/search?q=a\tb",
+			"status_code": int64(200),
+		},
+	}
+	if !reflect.DeepEqual(ev, expected) {
+		t.Errorf("got event %+v, expected %+v", ev, expected)
+	}
+}
+
+func TestProcessLinesSampled(t *testing.T) {
+	p := &Parser{
+		SampleRate: 10,
+		conf:       Options{NumParsers: 2},
+		lineParser: &ZeekLineParser{},
+		nower:      &FakeNower{},
+	}
+	lines := make(chan string)
+	send := make(chan event.Event)
+	go func() {
+		for _, line := range connHeaders {
+			lines <- line
+		}
+		for i := 0; i < 1000; i++ {
+			lines <- "1566848875.123456\tCHhAvVGS1DHFjwGM9\t192.0.2.7\t51234\t198.51.100.1\t443\ttcp\tssl\t0.25\t1024\tT\t(empty)"
+		}
+		// the headers are kept however many lines are dropped, so the
+		// lines after them are parsed with the new columns
+		lines <- "#path\thttp"
+		lines <- "#fields\tuid\tmethod\turi\tstatus_code"
+		lines <- "#types\tstring\tstring\tstring\tcount"
+		for i := 0; i < 1000; i++ {
+			lines <- "CHhAvVGS1DHFjwGM9\tGET\t/\t200"
+		}
+		close(lines)
+	}()
+	go func() {
+		p.ProcessLines(lines, send, nil)
+		close(send)
+	}()
+
+	counts := map[interface{}]int{}
+	for ev := range send {
+		if ev.SampleRate != 10 {
+			t.Errorf("expected sample rate 10, got %d", ev.SampleRate)
+		}
+		switch ev.Data["_path"] {
+		case "conn":
+			if ev.Data["proto"] != "tcp" {
+				t.Errorf("unexpected conn data %+v", ev.Data)
+			}
+		case "http":
+			if ev.Data["status_code"] != int64(200) {
+				t.Errorf("unexpected http data %+v", ev.Data)
+			}
+		default:
+			t.Errorf("unexpected data %+v", ev.Data)
+		}
+		counts[ev.Data["_path"]]++
+	}
+	for _, path := range []string{"conn", "http"} {
+		if counts[path] < 30 || counts[path] > 300 {
+			t.Errorf("expected about 100 %s events to be kept, got %d", path, counts[path])
+		}
+	}
+}
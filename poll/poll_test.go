@@ -0,0 +1,120 @@
+package poll
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+type fakeSource struct {
+	snapshots []map[string]*digestStats
+}
+
+func (f *fakeSource) snapshot() (map[string]*digestStats, error) {
+	s := f.snapshots[0]
+	if len(f.snapshots) > 1 {
+		f.snapshots = f.snapshots[1:]
+	}
+	return s, nil
+}
+
+func (f *fakeSource) Close() error {
+	return nil
+}
+
+func TestDiff(t *testing.T) {
+	now := time.Date(2010, 6, 21, 15, 4, 5, 0, time.UTC)
+	prev := map[string]*digestStats{
+		"shop/abc": {database: "shop", digest: "abc", text: "SELECT * FROM `orders` WHERE `id` = ?", count: 10, timerWait: 5e12, rowsSent: 10, rowsExamined: 100},
+		"shop/def": {database: "shop", digest: "def", text: "UPDATE `carts` SET `n` = ?", count: 7, timerWait: 7e12},
+		"shop/ghi": {database: "shop", digest: "ghi", text: "SELECT ?", count: 50, timerWait: 1e12},
+	}
+	cur := map[string]*digestStats{
+		"shop/abc": {database: "shop", digest: "abc", text: "SELECT * FROM `orders` WHERE `id` = ?", count: 14, timerWait: 7e12, lockTime: 4e9, rowsSent: 14, rowsExamined: 140, noIndexUsed: 1},
+		"shop/def": {database: "shop", digest: "def", text: "UPDATE `carts` SET `n` = ?", count: 7, timerWait: 7e12},
+		// reset since the last read
+		"shop/ghi": {database: "shop", digest: "ghi", text: "SELECT ?", count: 2, timerWait: 1e12},
+	}
+	events := diff(prev, cur, now)
+	expected := []event.Event{
+		{
+			Timestamp: now,
+			Data: map[string]interface{}{
+				"database":         "shop",
+				"digest":           "abc",
+				"query":            "SELECT * FROM `orders` WHERE `id` = ?",
+				"normalized_query": "select * from `orders` where `id` = ?",
+				"statement":        "select",
+				"tables":           "orders",
+				"count":            uint64(4),
+				"query_time":       0.5,
+				"query_time_total": 2.0,
+				"lock_time":        0.001,
+				"rows_sent":        1.0,
+				"rows_examined":    10.0,
+				"rows_affected":    0.0,
+				"errors":           uint64(0),
+				"no_index_used":    uint64(1),
+				"tmp_disk_tables":  uint64(0),
+			},
+		},
+		{
+			Timestamp: now,
+			Data: map[string]interface{}{
+				"database":         "shop",
+				"digest":           "ghi",
+				"query":            "SELECT ?",
+				"normalized_query": "select ?",
+				"statement":        "select",
+				"count":            uint64(2),
+				"query_time":       0.5,
+				"query_time_total": 1.0,
+				"lock_time":        0.0,
+				"rows_sent":        0.0,
+				"rows_examined":    0.0,
+				"rows_affected":    0.0,
+				"errors":           uint64(0),
+				"no_index_used":    uint64(0),
+				"tmp_disk_tables":  uint64(0),
+			},
+		},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("got events %+v, expected %+v", events, expected)
+	}
+}
+
+func TestPoll(t *testing.T) {
+	src := &fakeSource{snapshots: []map[string]*digestStats{
+		{"/abc": {digest: "abc", count: 10, timerWait: 1e12}},
+		{"/abc": {digest: "abc", count: 11, timerWait: 2e12}},
+	}}
+	events := make(chan event.Event)
+	abort := make(chan struct{})
+	go poll(src, Config{Interval: time.Millisecond, SampleRate: 1}, events, abort)
+	ev := <-events
+	close(abort)
+	if ev.Data["count"] != uint64(1) || ev.Data["query_time"] != 1.0 || ev.SampleRate != 1 {
+		t.Errorf("got event %+v, expected a single statement taking 1s", ev)
+	}
+}
+
+func TestGetEventsUnsupported(t *testing.T) {
+	_, err := GetEvents(Config{Addr: "postgres://user:secret@db:5432", Interval: time.Second}, nil)
+	if err == nil {
+		t.Fatal("expected an error polling postgres")
+	}
+	if testing.Verbose() {
+		t.Log(err)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	u, _ := url.Parse("mysql://user:secret@db:3306")
+	if r := redact(u); r != "mysql://user@db:3306" {
+		t.Errorf("got %s, expected the password to be removed", r)
+	}
+}
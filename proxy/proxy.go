@@ -0,0 +1,261 @@
+// Package proxy accepts Honeycomb API traffic so that honeytail can act as a
+// local gateway for SDKs running on hosts without direct egress.
+//
+// Events posted to /1/events/<dataset> and /1/batch/<dataset> are handed to
+// honeytail's processing pipeline (scrubbing, sampling, added fields and so
+// on) on the channel returned by GetEvents, carrying the write key and
+// dataset of the original request so they are forwarded to the right place.
+// Requests are acknowledged once the events are queued; errors sending them
+// upstream are logged but not reported back to the client.
+package proxy
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/listen"
+	"github.com/honeycombio/honeytail/ratelimit"
+)
+
+const (
+	eventsPath = "/1/events/"
+	batchPath  = "/1/batch/"
+
+	writeKeyHeader   = "X-Honeycomb-Team"
+	eventTimeHeader  = "X-Honeycomb-Event-Time"
+	sampleRateHeader = "X-Honeycomb-Samplerate"
+
+	// maxBodySize matches the largest batch the Honeycomb API accepts
+	maxBodySize = 5 * 1024 * 1024
+)
+
+type Config struct {
+	// Addr is the host:port on which to accept requests
+	Addr string
+	// SampleRate keeps 1/SampleRate of the incoming events. The sample rate
+	// sent by the client is multiplied in so the stored rate stays correct.
+	SampleRate uint
+	// RateLimit limits how many events each client may send
+	RateLimit ratelimit.Config
+	// ReusePort sets SO_REUSEPORT on the listening socket
+	ReusePort bool
+}
+
+// batchEvent is a single entry in a /1/batch request body
+type batchEvent struct {
+	Data       map[string]interface{} `json:"data"`
+	Time       string                 `json:"time"`
+	SampleRate int                    `json:"samplerate"`
+}
+
+// batchResponse is the per-event status returned from /1/batch
+type batchResponse struct {
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type proxy struct {
+	conf    Config
+	limiter *ratelimit.Limiter
+	events  chan event.Event
+	abort   <-chan struct{}
+}
+
+// GetEvents starts the proxy listening on conf.Addr and returns the channel
+// on which received events are sent. The channel is closed once abort is
+// closed and all in-flight requests have finished.
+func GetEvents(conf Config, abort <-chan struct{}) (chan event.Event, error) {
+	if conf.SampleRate == 0 {
+		conf.SampleRate = 1
+	}
+	limiter, err := ratelimit.New("proxy "+conf.Addr, conf.RateLimit)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := listen.ListenTCP(conf.Addr, conf.ReusePort)
+	if err != nil {
+		return nil, err
+	}
+	logrus.WithField("address", ln.Addr()).Info("Listening for Honeycomb API requests")
+
+	p := &proxy{
+		conf:    conf,
+		limiter: limiter,
+		events:  make(chan event.Event),
+		abort:   abort,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(eventsPath, p.handleEvent)
+	mux.HandleFunc(batchPath, p.handleBatch)
+	srv := &http.Server{Handler: mux}
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			logrus.WithError(err).Warn("Error serving proxy requests, shutting it down")
+		}
+	}()
+	go func() {
+		<-abort
+		// Shutdown waits for handlers to return, so nobody is left sending on
+		// the channel when we close it
+		srv.Shutdown(context.Background())
+		close(p.events)
+	}()
+	return p.events, nil
+}
+
+// handleEvent accepts a single event as a JSON object
+func (p *proxy) handleEvent(w http.ResponseWriter, r *http.Request) {
+	dataset, ok := p.checkRequest(w, r, eventsPath)
+	if !ok {
+		return
+	}
+	var data map[string]interface{}
+	if err := decodeBody(r, &data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sampleRate, _ := strconv.Atoi(r.Header.Get(sampleRateHeader))
+	ev, keep := p.newEvent(r, dataset, data, r.Header.Get(eventTimeHeader), sampleRate)
+	if !p.allow(r, &ev) {
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+		return
+	}
+	if keep && !p.send(ev) {
+		http.Error(w, "honeytail is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleBatch accepts a JSON array of events
+func (p *proxy) handleBatch(w http.ResponseWriter, r *http.Request) {
+	dataset, ok := p.checkRequest(w, r, batchPath)
+	if !ok {
+		return
+	}
+	var batch []batchEvent
+	if err := decodeBody(r, &batch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	responses := make([]batchResponse, len(batch))
+	for i, be := range batch {
+		if be.Data == nil {
+			responses[i] = batchResponse{Status: http.StatusBadRequest, Error: "event has no data"}
+			continue
+		}
+		ev, keep := p.newEvent(r, dataset, be.Data, be.Time, be.SampleRate)
+		if !p.allow(r, &ev) {
+			responses[i] = batchResponse{Status: http.StatusTooManyRequests, Error: "rate limited"}
+			continue
+		}
+		if keep && !p.send(ev) {
+			responses[i] = batchResponse{Status: http.StatusServiceUnavailable, Error: "honeytail is shutting down"}
+			continue
+		}
+		responses[i] = batchResponse{Status: http.StatusAccepted}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responses)
+}
+
+// checkRequest validates the method and extracts the dataset from the path
+func (p *proxy) checkRequest(w http.ResponseWriter, r *http.Request, prefix string) (string, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return "", false
+	}
+	dataset, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), prefix))
+	if err != nil || dataset == "" {
+		http.Error(w, "missing or invalid dataset", http.StatusBadRequest)
+		return "", false
+	}
+	return dataset, true
+}
+
+// decodeBody reads the (possibly gzipped) JSON request body into v
+func decodeBody(r *http.Request, v interface{}) error {
+	var body io.Reader = http.MaxBytesReader(nil, r.Body, maxBodySize)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		// a small compressed body can inflate to far more than maxBodySize
+		body = http.MaxBytesReader(nil, gz, maxBodySize)
+	}
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		return errors.New("failed to decode request body: " + err.Error())
+	}
+	return nil
+}
+
+// newEvent builds the event handed to the pipeline, returning false if it
+// was sampled away. Dropped events are still acknowledged to the client.
+func (p *proxy) newEvent(r *http.Request, dataset string, data map[string]interface{},
+	timestamp string, sampleRate int) (event.Event, bool) {
+	if sampleRate < 1 {
+		sampleRate = 1
+	}
+	ev := event.Event{
+		Timestamp:  parseTime(timestamp),
+		SampleRate: sampleRate * int(p.conf.SampleRate),
+		Data:       data,
+		WriteKey:   r.Header.Get(writeKeyHeader),
+		Dataset:    dataset,
+	}
+	keep := p.conf.SampleRate <= 1 || rand.Intn(int(p.conf.SampleRate)) == 0
+	return ev, keep
+}
+
+// allow applies the per-client rate limit, adjusting the event's sample
+// rate if it was kept by sampling
+func (p *proxy) allow(r *http.Request, ev *event.Event) bool {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	ok, rate := p.limiter.Allow(client)
+	ev.SampleRate *= rate
+	return ok
+}
+
+// send queues the event, giving up if we're shutting down
+func (p *proxy) send(ev event.Event) bool {
+	select {
+	case p.events <- ev:
+		return true
+	case <-p.abort:
+		return false
+	}
+}
+
+// parseTime accepts the RFC3339 timestamps the SDKs send as well as
+// seconds since the epoch, falling back to now
+func parseTime(s string) time.Time {
+	if s == "" {
+		return time.Now().UTC()
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t
+	}
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(secs*float64(time.Second))).UTC()
+	}
+	return time.Now().UTC()
+}
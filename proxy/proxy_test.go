@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/ratelimit"
+)
+
+func newTestProxy(sampleRate uint) (*proxy, chan struct{}) {
+	abort := make(chan struct{})
+	return &proxy{
+		conf:   Config{SampleRate: sampleRate},
+		events: make(chan event.Event, 10),
+		abort:  abort,
+	}, abort
+}
+
+func TestHandleEvent(t *testing.T) {
+	p, _ := newTestProxy(1)
+	req := httptest.NewRequest("POST", "/1/events/my%20data", bytes.NewBufferString(`{"a":1,"b":"two"}`))
+	req.Header.Set(writeKeyHeader, "abc123")
+	req.Header.Set(eventTimeHeader, "2010-06-21T15:04:05Z")
+	req.Header.Set(sampleRateHeader, "4")
+	w := httptest.NewRecorder()
+	p.handleEvent(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+	}
+	expected := event.Event{
+		Timestamp:  time.Date(2010, 6, 21, 15, 4, 5, 0, time.UTC),
+		SampleRate: 4,
+		Data:       map[string]interface{}{"a": float64(1), "b": "two"},
+		WriteKey:   "abc123",
+		Dataset:    "my data",
+	}
+	if ev := <-p.events; !reflect.DeepEqual(ev, expected) {
+		t.Errorf("got event %+v, expected %+v", ev, expected)
+	}
+
+	for _, tc := range []struct {
+		method, path, body string
+		code               int
+	}{
+		{"GET", "/1/events/ds", `{}`, http.StatusMethodNotAllowed},
+		{"POST", "/1/events/", `{}`, http.StatusBadRequest},
+		{"POST", "/1/events/ds", `not json`, http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		p.handleEvent(w, httptest.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.body)))
+		if w.Code != tc.code {
+			t.Errorf("%s %s got status %d, expected %d", tc.method, tc.path, w.Code, tc.code)
+		}
+	}
+}
+
+func TestHandleBatch(t *testing.T) {
+	p, _ := newTestProxy(1)
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	gz.Write([]byte(`[{"data":{"a":1},"time":"2010-06-21T15:04:05Z","samplerate":2},{"time":"2010-06-21T15:04:05Z"},{"data":{"b":2}}]`))
+	gz.Close()
+	req := httptest.NewRequest("POST", "/1/batch/ds", &body)
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	p.handleBatch(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+	}
+	expectedBody := `[{"status":202},{"status":400,"error":"event has no data"},{"status":202}]` + "\n"
+	if w.Body.String() != expectedBody {
+		t.Errorf("got response %s, expected %s", w.Body.String(), expectedBody)
+	}
+	first := <-p.events
+	if first.SampleRate != 2 || first.Dataset != "ds" || first.Data["a"] != float64(1) {
+		t.Errorf("unexpected first event %+v", first)
+	}
+	second := <-p.events
+	if second.SampleRate != 1 || second.Data["b"] != float64(2) {
+		t.Errorf("unexpected second event %+v", second)
+	}
+}
+
+func TestHandleBatchTooLarge(t *testing.T) {
+	p, _ := newTestProxy(1)
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	gz.Write([]byte(`[{"data":{"a":"`))
+	gz.Write(bytes.Repeat([]byte{'a'}, maxBodySize))
+	gz.Write([]byte(`"}}]`))
+	gz.Close()
+	req := httptest.NewRequest("POST", "/1/batch/ds", &body)
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	p.handleBatch(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for a body inflating past the limit, expected %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	p, _ := newTestProxy(1)
+	p.limiter, _ = ratelimit.New("test", ratelimit.Config{Rate: 1, Burst: 2})
+	req := httptest.NewRequest("POST", "/1/batch/ds", bytes.NewBufferString(`[{"data":{"a":1}},{"data":{"a":2}},{"data":{"a":3}}]`))
+	w := httptest.NewRecorder()
+	p.handleBatch(w, req)
+	expectedBody := `[{"status":202},{"status":202},{"status":429,"error":"rate limited"}]` + "\n"
+	if w.Body.String() != expectedBody {
+		t.Errorf("got response %s, expected %s", w.Body.String(), expectedBody)
+	}
+
+	w = httptest.NewRecorder()
+	p.handleEvent(w, httptest.NewRequest("POST", "/1/events/ds", bytes.NewBufferString(`{"a":4}`)))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected single event to be rate limited, got status %d", w.Code)
+	}
+
+	// sampled events have their sample rate adjusted
+	p.limiter, _ = ratelimit.New("test", ratelimit.Config{Rate: 0.001, Burst: 1, Policy: ratelimit.PolicySample, SampleRate: 2})
+	first := event.Event{SampleRate: 3}
+	if !p.allow(req, &first) || first.SampleRate != 3 {
+		t.Errorf("event under the limit should keep its sample rate, got %d", first.SampleRate)
+	}
+	for i := 0; i < 100; i++ {
+		ev := event.Event{SampleRate: 3}
+		if p.allow(req, &ev) {
+			if ev.SampleRate != 6 {
+				t.Errorf("event over the limit should have sample rate 6, got %d", ev.SampleRate)
+			}
+			return
+		}
+	}
+	t.Error("no events over the limit were kept by sampling")
+}
+
+func TestSampling(t *testing.T) {
+	p, _ := newTestProxy(5)
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		ev, keep := p.newEvent(httptest.NewRequest("POST", "/1/events/ds", nil), "ds", map[string]interface{}{}, "", 2)
+		if ev.SampleRate != 10 {
+			t.Fatalf("sample rate %d should combine the client and proxy rates", ev.SampleRate)
+		}
+		if keep {
+			kept++
+		}
+	}
+	if kept < 100 || kept > 300 {
+		t.Errorf("kept %d of 1000 events at a sample rate of 5", kept)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	expected := time.Date(2010, 6, 21, 15, 4, 5, 500000000, time.UTC)
+	for _, s := range []string{"2010-06-21T15:04:05.5Z", "1277132645.5"} {
+		if ts := parseTime(s); !ts.Equal(expected) {
+			t.Errorf("parsing %q got %v, expected %v", s, ts, expected)
+		}
+	}
+}
+
+func TestGetEventsClosesOnAbort(t *testing.T) {
+	abort := make(chan struct{})
+	events, err := GetEvents(Config{Addr: "127.0.0.1:0"}, abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(abort)
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("expected the events channel to be closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("events channel wasn't closed after abort")
+	}
+}
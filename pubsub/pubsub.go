@@ -0,0 +1,481 @@
+// Package pubsub reads log entries from a Google Pub/Sub subscription, such
+// as one to the topic of a Cloud Logging export sink.
+//
+// Unlike the other inputs that need a parser, pubsub provides events rather
+// than lines: the data of each message is handed to the configured parser
+// here, so that the message can be acknowledged once every event parsed
+// from it has been handed to libhoney, dropped by sampling, or held by
+// maintenance mode. Messages honeytail stops before sending are left
+// unacknowledged, to be delivered again. At most MaxOutstanding messages
+// are held without having been acknowledged, and their ack deadlines are
+// extended for as long as they're held.
+package pubsub
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/gcpapi"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+type Options struct {
+	CredentialsFile string `long:"credentials_file" description:"Service account key, in JSON, to authorize requests with. Defaults to GOOGLE_APPLICATION_CREDENTIALS, or else the service account of the instance honeytail runs on"`
+	Endpoint        string `long:"endpoint" description:"URL of the Pub/Sub API, to use an emulator" default:"https://pubsub.googleapis.com"`
+	MaxOutstanding  int    `long:"max_outstanding" description:"Most messages to hold at once without having acknowledged them. No more are pulled until the events of those held have been sent" default:"1000"`
+	AckDeadline     int    `long:"ack_deadline" description:"Seconds, from 10 to 600, before Pub/Sub delivers a message again if it hasn't been acknowledged. It's extended for as long as the message's events are being sent" default:"60"`
+}
+
+type Config struct {
+	// Subscription is the subscription to pull from, in the form
+	// projects/<project>/subscriptions/<subscription>
+	Subscription string
+	// Pub/Sub specific options
+	Options Options
+	// Parser, already initialized, turns the lines of each message into
+	// events, stripping PrefixRegex from them if it's set
+	Parser      parsers.Parser
+	PrefixRegex *parsers.ExtRegexp
+	// SampleRate keeps 1/SampleRate of the lines, before they're parsed
+	SampleRate uint
+}
+
+var (
+	// ackInterval is how often to acknowledge the messages whose events
+	// have all been sent
+	ackInterval = 100 * time.Millisecond
+	// retryWait is how long to wait after failing to pull messages
+	retryWait = 10 * time.Second
+	// maxHold is how long a message is kept from being delivered again
+	// before giving up on it
+	maxHold = time.Hour
+)
+
+const (
+	pubsubScope = "https://www.googleapis.com/auth/pubsub"
+	// maxPull is the most messages a pull returns
+	maxPull = 1000
+	// maxAckIDs is the most ack IDs sent in one request
+	maxAckIDs = 1000
+)
+
+var subscriptionPattern = regexp.MustCompile(`^projects/[^/]+/subscriptions/[^/]+$`)
+
+// receivedMessage is a message in a pull response
+type receivedMessage struct {
+	AckID   string `json:"ackId"`
+	Message struct {
+		Data      string `json:"data"`
+		MessageID string `json:"messageId"`
+	} `json:"message"`
+}
+
+// pending counts the events of a message yet to be sent, plus one while
+// they're still being parsed
+type pending struct {
+	ackID string
+	count int32
+}
+
+// Subscriber pulls messages from a subscription and sends events parsed
+// from them on Events, acknowledging each message once they've been sent
+type Subscriber struct {
+	// Events receives the events parsed from each message. It's closed once
+	// abort is closed.
+	Events chan event.Event
+
+	conf     Config
+	endpoint string
+	tokens   *gcpapi.TokenSource
+	http     *http.Client
+	abort    <-chan struct{}
+	stop     chan struct{}
+	stopped  chan struct{}
+
+	lock sync.Mutex
+	// room is signalled when messages stop being outstanding
+	room *sync.Cond
+	// outstanding holds when each message not yet acknowledged was pulled
+	outstanding map[string]time.Time
+	// acks are the messages whose events have all been sent, to
+	// acknowledge
+	acks []string
+}
+
+// Subscribe starts pulling messages from conf.Subscription, sending the
+// events parsed from them on the returned Subscriber's Events. Once abort
+// has been closed and those events handed on, Close must be called to
+// acknowledge the last of the messages.
+func Subscribe(conf Config, abort <-chan struct{}) (*Subscriber, error) {
+	if !subscriptionPattern.MatchString(conf.Subscription) {
+		return nil, errors.New("the Pub/Sub subscription must be given as projects/<project>/subscriptions/<subscription>")
+	}
+	if conf.Options.MaxOutstanding < 1 {
+		return nil, errors.New("--pubsub.max_outstanding must be at least 1")
+	}
+	if conf.Options.AckDeadline < 10 || conf.Options.AckDeadline > 600 {
+		return nil, errors.New("--pubsub.ack_deadline must be from 10 to 600 seconds")
+	}
+	if conf.SampleRate == 0 {
+		conf.SampleRate = 1
+	}
+	tokens, err := gcpapi.NewTokenSource(conf.Options.CredentialsFile, pubsubScope, &http.Client{Timeout: 30 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	endpoint := conf.Options.Endpoint
+	if endpoint == "" {
+		endpoint = "https://pubsub.googleapis.com"
+	}
+	s := &Subscriber{
+		Events:   make(chan event.Event),
+		conf:     conf,
+		endpoint: strings.TrimRight(endpoint, "/"),
+		tokens:   tokens,
+		// pulls wait for messages to arrive, for up to a minute or so
+		http:        &http.Client{Timeout: 2 * time.Minute},
+		abort:       abort,
+		stop:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+		outstanding: make(map[string]time.Time),
+	}
+	s.room = sync.NewCond(&s.lock)
+	// check the subscription can be read now, so a missing subscription or
+	// bad credentials are reported at startup
+	if err := s.call("GET", "", nil, nil); err != nil {
+		return nil, err
+	}
+	go func() {
+		<-abort
+		s.lock.Lock()
+		s.room.Broadcast()
+		s.lock.Unlock()
+	}()
+	go s.keepAlive()
+	go s.run()
+	return s, nil
+}
+
+// Close acknowledges the messages whose events have all been sent, and has
+// the rest delivered again. Call it once abort has been closed and the
+// events sent on Events have been handed to libhoney.
+func (s *Subscriber) Close() {
+	close(s.stop)
+	<-s.stopped
+	s.flush()
+	s.lock.Lock()
+	unsent := make([]string, 0, len(s.outstanding))
+	for id := range s.outstanding {
+		unsent = append(unsent, id)
+	}
+	s.outstanding = make(map[string]time.Time)
+	s.lock.Unlock()
+	s.modifyAckDeadline(unsent, 0)
+}
+
+// call sends a request for action on the subscription, decoding the
+// response into out if it's set
+func (s *Subscriber) call(method, action string, in, out interface{}) error {
+	token, err := s.tokens.Token()
+	if err != nil {
+		return err
+	}
+	var body io.Reader
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, s.endpoint+"/v1/"+s.conf.Subscription+action, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return gcpapi.ResponseError(resp)
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// run pulls messages and sends their events until abort is closed
+func (s *Subscriber) run() {
+	defer close(s.Events)
+	for {
+		n := s.waitForRoom()
+		if n == 0 {
+			return
+		}
+		messages, err := s.pull(n)
+		select {
+		case <-s.abort:
+			return
+		default:
+		}
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"subscription": s.conf.Subscription,
+				"err":          err,
+			}).Warn("Failed to pull Pub/Sub messages, trying again")
+			select {
+			case <-time.After(retryWait):
+			case <-s.abort:
+				return
+			}
+			continue
+		}
+		ids := make([]string, 0, len(messages))
+		now := time.Now()
+		s.lock.Lock()
+		for _, m := range messages {
+			s.outstanding[m.AckID] = now
+			ids = append(ids, m.AckID)
+		}
+		s.lock.Unlock()
+		// the subscription's own deadline may be too short to parse
+		// and send everything pulled
+		s.modifyAckDeadline(ids, s.conf.Options.AckDeadline)
+		for _, m := range messages {
+			if !s.handle(m) {
+				return
+			}
+		}
+	}
+}
+
+// waitForRoom waits until fewer than MaxOutstanding messages are held,
+// returning how many more to pull, or 0 if abort was closed first
+func (s *Subscriber) waitForRoom() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for {
+		select {
+		case <-s.abort:
+			return 0
+		default:
+		}
+		if n := s.conf.Options.MaxOutstanding - len(s.outstanding); n > 0 {
+			if n > maxPull {
+				n = maxPull
+			}
+			return n
+		}
+		s.room.Wait()
+	}
+}
+
+// pull waits for up to n messages, returning early if abort is closed.
+// Messages pulled after that are left to be delivered again.
+func (s *Subscriber) pull(n int) ([]receivedMessage, error) {
+	type result struct {
+		messages []receivedMessage
+		err      error
+	}
+	results := make(chan result, 1)
+	go func() {
+		var out struct {
+			ReceivedMessages []receivedMessage `json:"receivedMessages"`
+		}
+		err := s.call("POST", ":pull", map[string]interface{}{"maxMessages": n}, &out)
+		results <- result{out.ReceivedMessages, err}
+	}()
+	select {
+	case res := <-results:
+		return res.messages, res.err
+	case <-s.abort:
+		return nil, nil
+	}
+}
+
+// handle parses the data of m and sends its events. It returns false if
+// abort was closed first, leaving m outstanding.
+func (s *Subscriber) handle(m receivedMessage) bool {
+	p := &pending{ackID: m.AckID, count: 1}
+	data, err := base64.StdEncoding.DecodeString(m.Message.Data)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"subscription": s.conf.Subscription,
+			"message_id":   m.Message.MessageID,
+			"err":          err,
+		}).Warn("Skipping Pub/Sub message whose data couldn't be decoded")
+		s.release(p)
+		return true
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			continue
+		}
+		if s.conf.SampleRate > 1 && rand.Intn(int(s.conf.SampleRate)) != 0 {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	linesChan := make(chan string, len(lines))
+	for _, line := range lines {
+		linesChan <- line
+	}
+	close(linesChan)
+	parsed := make(chan event.Event)
+	go func() {
+		s.conf.Parser.ProcessLines(linesChan, parsed, s.conf.PrefixRegex)
+		close(parsed)
+	}()
+	aborted := false
+	for ev := range parsed {
+		if aborted {
+			continue
+		}
+		atomic.AddInt32(&p.count, 1)
+		ev.Done = s.doneFunc(p)
+		select {
+		case s.Events <- ev:
+		case <-s.abort:
+			aborted = true
+		}
+	}
+	if aborted {
+		return false
+	}
+	s.release(p)
+	return true
+}
+
+// doneFunc returns the Done of an event parsed from the message p counts
+// the events of
+func (s *Subscriber) doneFunc(p *pending) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() { s.release(p) })
+	}
+}
+
+// release counts off one of p's events, queueing its message to be
+// acknowledged once they've all been sent
+func (s *Subscriber) release(p *pending) {
+	if atomic.AddInt32(&p.count, -1) != 0 {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.outstanding[p.ackID]; !ok {
+		// given up on
+		return
+	}
+	delete(s.outstanding, p.ackID)
+	s.acks = append(s.acks, p.ackID)
+	s.room.Signal()
+}
+
+// keepAlive acknowledges messages as their events are sent, and extends
+// the ack deadlines of those still held, until Close is called
+func (s *Subscriber) keepAlive() {
+	defer close(s.stopped)
+	ackTicker := time.NewTicker(ackInterval)
+	defer ackTicker.Stop()
+	extendTicker := time.NewTicker(time.Duration(s.conf.Options.AckDeadline) * time.Second / 2)
+	defer extendTicker.Stop()
+	for {
+		select {
+		case <-ackTicker.C:
+			s.flush()
+		case <-extendTicker.C:
+			s.extend()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// flush acknowledges the messages whose events have all been sent
+func (s *Subscriber) flush() {
+	s.lock.Lock()
+	ids := s.acks
+	s.acks = nil
+	s.lock.Unlock()
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > maxAckIDs {
+			n = maxAckIDs
+		}
+		err := s.call("POST", ":acknowledge", map[string]interface{}{"ackIds": ids[:n]}, nil)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"subscription": s.conf.Subscription,
+				"messages":     n,
+				"err":          err,
+			}).Warn("Failed to acknowledge Pub/Sub messages; they will be delivered again")
+		}
+		ids = ids[n:]
+	}
+}
+
+// extend pushes back the ack deadlines of the messages still held, giving
+// up on those held for longer than maxHold so they're delivered again
+func (s *Subscriber) extend() {
+	var ids []string
+	s.lock.Lock()
+	for id, pulled := range s.outstanding {
+		if time.Since(pulled) > maxHold {
+			logrus.WithFields(logrus.Fields{
+				"subscription": s.conf.Subscription,
+				"held":         time.Since(pulled).String(),
+			}).Warn("Giving up on sending the events of a Pub/Sub message; it will be delivered again")
+			delete(s.outstanding, id)
+			s.room.Signal()
+			continue
+		}
+		ids = append(ids, id)
+	}
+	s.lock.Unlock()
+	s.modifyAckDeadline(ids, s.conf.Options.AckDeadline)
+}
+
+// modifyAckDeadline sets the ack deadlines of the messages ids to seconds
+// from now. A deadline of 0 has them delivered again straight away.
+func (s *Subscriber) modifyAckDeadline(ids []string, seconds int) {
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > maxAckIDs {
+			n = maxAckIDs
+		}
+		err := s.call("POST", ":modifyAckDeadline", map[string]interface{}{
+			"ackIds":             ids[:n],
+			"ackDeadlineSeconds": seconds,
+		}, nil)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"subscription": s.conf.Subscription,
+				"messages":     n,
+				"err":          err,
+			}).Warn("Failed to modify Pub/Sub ack deadlines")
+		}
+		ids = ids[n:]
+	}
+}
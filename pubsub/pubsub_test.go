@@ -0,0 +1,265 @@
+package pubsub
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/event"
+	"github.com/honeycombio/honeytail/parsers"
+)
+
+const subscription = "projects/proj/subscriptions/logs"
+
+// fakePubSub serves a token endpoint and a subscription whose messages are
+// each delivered once, recording acknowledgements and deadline changes
+type fakePubSub struct {
+	lock      sync.Mutex
+	messages  []string
+	delivered int
+	pulls     []int
+	acked     []string
+	deadlines map[string]int
+}
+
+func (f *fakePubSub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		fmt.Fprint(w, `{"access_token":"let-me-in","expires_in":3600,"token_type":"Bearer"}`)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer let-me-in" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":{"code":401,"message":"Invalid Credentials"}}`)
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var in struct {
+		MaxMessages        int      `json:"maxMessages"`
+		AckIDs             []string `json:"ackIds"`
+		AckDeadlineSeconds int      `json:"ackDeadlineSeconds"`
+	}
+	json.NewDecoder(r.Body).Decode(&in)
+	switch r.URL.Path {
+	case "/v1/" + subscription:
+		fmt.Fprintf(w, `{"name":%q,"ackDeadlineSeconds":10}`, subscription)
+	case "/v1/" + subscription + ":pull":
+		f.pulls = append(f.pulls, in.MaxMessages)
+		var received []map[string]interface{}
+		for f.delivered < len(f.messages) && len(received) < in.MaxMessages {
+			received = append(received, map[string]interface{}{
+				"ackId": fmt.Sprintf("ack%d", f.delivered),
+				"message": map[string]string{
+					"data":      base64.StdEncoding.EncodeToString([]byte(f.messages[f.delivered])),
+					"messageId": fmt.Sprint(f.delivered),
+				},
+			})
+			f.delivered++
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"receivedMessages": received})
+	case "/v1/" + subscription + ":acknowledge":
+		f.acked = append(f.acked, in.AckIDs...)
+		fmt.Fprint(w, `{}`)
+	case "/v1/" + subscription + ":modifyAckDeadline":
+		for _, id := range in.AckIDs {
+			f.deadlines[id] = in.AckDeadlineSeconds
+		}
+		fmt.Fprint(w, `{}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":{"code":404,"message":"Resource not found"}}`)
+	}
+}
+
+func (f *fakePubSub) getAcked() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	acked := append([]string(nil), f.acked...)
+	sort.Strings(acked)
+	return acked
+}
+
+// lineParser makes an event of each line
+type lineParser struct{}
+
+func (lineParser) Init(options interface{}) error { return nil }
+
+func (lineParser) ProcessLines(lines <-chan string, send chan<- event.Event, prefixRegex *parsers.ExtRegexp) {
+	for line := range lines {
+		send <- event.Event{Data: map[string]interface{}{"line": line}}
+	}
+}
+
+func writeCredentials(t *testing.T, dir, tokenURI string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	creds, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "honeytail@proj.iam.gserviceaccount.com",
+		"private_key":  string(keyPEM),
+		"token_uri":    tokenURI,
+	})
+	path := filepath.Join(dir, "key.json")
+	if err := ioutil.WriteFile(path, creds, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSubscribe(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	defer func(d time.Duration) { ackInterval = d }(ackInterval)
+	ackInterval = 10 * time.Millisecond
+	dir, err := ioutil.TempDir("", "pubsub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fake := &fakePubSub{
+		messages: []string{
+			`{"a":1}`,
+			`{"a":2}` + "\n" + `{"a":3}` + "\n",
+			"",
+			`{"a":4}`,
+		},
+		deadlines: map[string]int{},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	abort := make(chan struct{})
+	sub, err := Subscribe(Config{
+		Subscription: subscription,
+		Options: Options{
+			CredentialsFile: writeCredentials(t, dir, server.URL+"/token"),
+			Endpoint:        server.URL,
+			MaxOutstanding:  2,
+			AckDeadline:     60,
+		},
+		Parser: lineParser{},
+	}, abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := func() event.Event {
+		select {
+		case ev := <-sub.Events:
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an event")
+		}
+		return event.Event{}
+	}
+	waitForAcks := func(expected []string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !reflect.DeepEqual(fake.getAcked(), expected) {
+			if time.Now().After(deadline) {
+				t.Fatalf("acked %v, expected %v", fake.getAcked(), expected)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	first := next()
+	second := next()
+	third := next()
+	if first.Data["line"] != `{"a":1}` || second.Data["line"] != `{"a":2}` || third.Data["line"] != `{"a":3}` {
+		t.Fatalf("got events %v, %v, %v", first.Data, second.Data, third.Data)
+	}
+	// nothing is acknowledged before its events are sent
+	time.Sleep(50 * time.Millisecond)
+	if acked := fake.getAcked(); len(acked) != 0 {
+		t.Errorf("acked %v before any events were sent", acked)
+	}
+	// acknowledging the first makes room to pull the empty message, which
+	// is acknowledged straight away
+	first.Done()
+	first.Done()
+	waitForAcks([]string{"ack0", "ack2"})
+	// a message is acknowledged once all its events are sent
+	second.Done()
+	time.Sleep(50 * time.Millisecond)
+	waitForAcks([]string{"ack0", "ack2"})
+	third.Done()
+	waitForAcks([]string{"ack0", "ack1", "ack2"})
+
+	last := next()
+	if last.Data["line"] != `{"a":4}` {
+		t.Errorf("got event %v, expected a:4", last.Data)
+	}
+	fake.lock.Lock()
+	pulls := fake.pulls
+	deadline := fake.deadlines["ack3"]
+	fake.lock.Unlock()
+	if pulls[0] != 2 {
+		t.Errorf("first pull asked for %d messages, expected max_outstanding of 2", pulls[0])
+	}
+	if deadline != 60 {
+		t.Errorf("got ack deadline %d for a message pulled, expected 60", deadline)
+	}
+
+	// the message whose event wasn't sent is delivered again
+	close(abort)
+	for range sub.Events {
+	}
+	sub.Close()
+	waitForAcks([]string{"ack0", "ack1", "ack2"})
+	fake.lock.Lock()
+	deadline = fake.deadlines["ack3"]
+	fake.lock.Unlock()
+	if deadline != 0 {
+		t.Errorf("got ack deadline %d for an unsent message after closing, expected 0", deadline)
+	}
+}
+
+func TestSubscribeErrors(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	dir, err := ioutil.TempDir("", "pubsub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := httptest.NewServer(&fakePubSub{deadlines: map[string]int{}})
+	defer server.Close()
+	opts := Options{
+		CredentialsFile: writeCredentials(t, dir, server.URL+"/token"),
+		Endpoint:        server.URL,
+		MaxOutstanding:  1000,
+		AckDeadline:     60,
+	}
+
+	_, err = Subscribe(Config{Subscription: "logs", Options: opts, Parser: lineParser{}}, nil)
+	if err == nil || !strings.Contains(err.Error(), "projects/<project>") {
+		t.Errorf("got %v for a bare subscription name, expected the form to use", err)
+	}
+	_, err = Subscribe(Config{Subscription: "projects/proj/subscriptions/missing", Options: opts, Parser: lineParser{}}, nil)
+	if err == nil || !strings.Contains(err.Error(), "Resource not found") {
+		t.Errorf("got %v for a missing subscription, expected not found", err)
+	}
+	opts.AckDeadline = 5
+	_, err = Subscribe(Config{Subscription: subscription, Options: opts, Parser: lineParser{}}, nil)
+	if err == nil {
+		t.Error("expected an error for a 5 second ack deadline")
+	}
+}
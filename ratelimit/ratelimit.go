@@ -0,0 +1,145 @@
+// Package ratelimit limits how fast individual senders may send to the
+// network inputs, so that a single misbehaving sender can't overwhelm
+// honeytail.
+package ratelimit
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// PolicyDrop drops everything over the limit
+	PolicyDrop = "drop"
+	// PolicySample keeps 1 / SampleRate of what's over the limit
+	PolicySample = "sample"
+
+	// how often to log how much was dropped
+	reportInterval = time.Minute
+	// buckets that have been full for this long are forgotten
+	idleTimeout = 10 * time.Minute
+)
+
+type Config struct {
+	// Rate is the number of messages per second each sender may send. Zero
+	// disables limiting.
+	Rate float64
+	// Burst is how many messages a sender may send at once
+	Burst int
+	// Policy says what to do with messages over the limit
+	Policy string
+	// SampleRate is used by PolicySample
+	SampleRate int
+}
+
+// bucket is a token bucket for a single sender
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter keeps a token bucket per sender
+type Limiter struct {
+	conf    Config
+	name    string
+	buckets map[string]*bucket
+	dropped map[string]uint64
+	// lastReport is when dropped counts were last logged
+	lastReport time.Time
+	lock       sync.Mutex
+	now        func() time.Time
+}
+
+// New returns a limiter for the named input, or nil if conf doesn't limit
+// anything. A nil *Limiter allows everything.
+func New(name string, conf Config) (*Limiter, error) {
+	if conf.Rate == 0 {
+		return nil, nil
+	}
+	if conf.Rate < 0 {
+		return nil, errors.New("rate limit must not be negative")
+	}
+	if conf.Burst < 1 {
+		conf.Burst = 1
+	}
+	switch conf.Policy {
+	case "", PolicyDrop:
+		conf.Policy = PolicyDrop
+	case PolicySample:
+		if conf.SampleRate < 1 {
+			return nil, errors.New("over limit sample rate must be at least 1")
+		}
+	default:
+		return nil, errors.New("unknown over limit policy " + conf.Policy)
+	}
+	return &Limiter{
+		conf:    conf,
+		name:    name,
+		buckets: make(map[string]*bucket),
+		dropped: make(map[string]uint64),
+		now:     time.Now,
+	}, nil
+}
+
+// Allow reports whether a message from sender should be kept. When the
+// sender is over its limit and the policy is to sample, the returned sample
+// rate is the rate at which the kept message was sampled; it is 1 otherwise.
+func (l *Limiter) Allow(sender string) (bool, int) {
+	if l == nil {
+		return true, 1
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+	l.maybeReport(now)
+
+	b, ok := l.buckets[sender]
+	if !ok {
+		b = &bucket{tokens: float64(l.conf.Burst), last: now}
+		l.buckets[sender] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.conf.Rate
+	if b.tokens > float64(l.conf.Burst) {
+		b.tokens = float64(l.conf.Burst)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 1
+	}
+	if l.conf.Policy == PolicySample && rand.Intn(l.conf.SampleRate) == 0 {
+		return true, l.conf.SampleRate
+	}
+	l.dropped[sender]++
+	return false, 0
+}
+
+// maybeReport logs how much was dropped from each sender since the last
+// report and forgets senders that have gone quiet
+func (l *Limiter) maybeReport(now time.Time) {
+	if l.lastReport.IsZero() {
+		l.lastReport = now
+	}
+	if now.Sub(l.lastReport) < reportInterval {
+		return
+	}
+	for sender, count := range l.dropped {
+		logrus.WithFields(logrus.Fields{
+			"input":   l.name,
+			"sender":  sender,
+			"dropped": count,
+			"policy":  l.conf.Policy,
+		}).Warn("Sender exceeded its rate limit")
+	}
+	l.dropped = make(map[string]uint64)
+	for sender, b := range l.buckets {
+		if now.Sub(b.last) > idleTimeout {
+			delete(l.buckets, sender)
+		}
+	}
+	l.lastReport = now
+}
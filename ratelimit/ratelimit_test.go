@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	l, err := New("test", Config{Rate: 2, Burst: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2010, 6, 21, 15, 4, 5, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	// the burst is allowed straight away, then we're out of tokens
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Errorf("message %d should have been allowed", i)
+		}
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("message over the burst should have been dropped")
+	}
+	// other senders have their own bucket
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("a different sender should be allowed")
+	}
+	// half a second later there's one more token
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("message should be allowed once the bucket refills")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("message should have been dropped")
+	}
+	if l.dropped["a"] != 2 {
+		t.Errorf("expected 2 dropped messages, counted %d", l.dropped["a"])
+	}
+
+	// reporting resets the counts and forgets idle senders
+	now = now.Add(time.Hour)
+	l.Allow("c")
+	if len(l.dropped) != 0 {
+		t.Errorf("dropped counts should have been reset, got %v", l.dropped)
+	}
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("idle sender should have been forgotten")
+	}
+}
+
+func TestSamplePolicy(t *testing.T) {
+	rand.Seed(1)
+	l, err := New("test", Config{Rate: 1, Burst: 1, Policy: PolicySample, SampleRate: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	l.Allow("a")
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		if ok, rate := l.Allow("a"); ok {
+			kept++
+			if rate != 4 {
+				t.Errorf("sampled message should have a rate of 4, got %d", rate)
+			}
+		}
+	}
+	if kept < 150 || kept > 350 {
+		t.Errorf("kept %d of 1000 messages sampled at 1/4", kept)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if l, err := New("test", Config{}); l != nil || err != nil {
+		t.Error("no rate should mean no limiter")
+	}
+	var l *Limiter
+	if ok, rate := l.Allow("a"); !ok || rate != 1 {
+		t.Error("a nil limiter should allow everything")
+	}
+	for _, bad := range []Config{
+		{Rate: -1},
+		{Rate: 1, Policy: "bogus"},
+		{Rate: 1, Policy: PolicySample},
+	} {
+		if _, err := New("test", bad); err == nil {
+			t.Errorf("expected error for config %+v", bad)
+		}
+	}
+}
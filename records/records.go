@@ -0,0 +1,178 @@
+// Package records splits input into records separated by a delimiter other
+// than a newline, for appliances and tools that separate records with a
+// NUL byte, an ASCII record separator or a sentinel string.
+//
+// Records are split as bytes are read, so a record is complete as soon as
+// the delimiter after it is, whether or not a newline follows. Newlines and
+// whitespace within a record are kept.
+package records
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// readSize is how much is read from the input at a time
+const readSize = 32 * 1024
+
+// SplitFunc returns a bufio.SplitFunc splitting records separated by delim,
+// a string in which escapes such as \0 and \x1e are interpreted, or by
+// matches of delimRegex. It returns nil if neither is set, as records are
+// then newline delimited as usual. name is the option the delimiters were
+// given with, for errors.
+func SplitFunc(name, delim, delimRegex string) (bufio.SplitFunc, error) {
+	switch {
+	case delim != "" && delimRegex != "":
+		return nil, errors.New("only one of --" + name + "_delimiter and --" + name + "_delimiter_regex may be set")
+	case delim != "":
+		sep, err := Unescape(delim)
+		if err != nil {
+			return nil, errors.New("unable to interpret --" + name + "_delimiter: " + err.Error())
+		}
+		return func(data []byte, atEOF bool) (int, []byte, error) {
+			if i := bytes.Index(data, []byte(sep)); i >= 0 {
+				return i + len(sep), data[:i], nil
+			}
+			return atEnd(data, atEOF)
+		}, nil
+	case delimRegex != "":
+		re, err := regexp.Compile(delimRegex)
+		if err != nil {
+			return nil, err
+		}
+		return func(data []byte, atEOF bool) (int, []byte, error) {
+			// a match reaching the end of what's been read so far might go
+			// on further once more is read
+			if loc := re.FindIndex(data); loc != nil && loc[1] > loc[0] && (loc[1] < len(data) || atEOF) {
+				return loc[1], data[:loc[0]], nil
+			}
+			return atEnd(data, atEOF)
+		}, nil
+	}
+	return nil, nil
+}
+
+// atEnd returns what's left at the end of the input as the last record, or
+// asks for more if the input hasn't ended
+func atEnd(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// Reader reads the records in an input that may still be being written,
+// such as a followed file. Reaching the end of what's been written so far
+// isn't taken to end the record being read.
+type Reader struct {
+	r       io.Reader
+	split   bufio.SplitFunc
+	maxSize int
+	buf     bytes.Buffer
+	chunk   []byte
+	// offset counts the bytes read up to the end of the last record
+	// returned or dropped, including the delimiter after it
+	offset int64
+	// set while dropping a record longer than maxSize, until the delimiter
+	// after it is read
+	dropping bool
+}
+
+// NewReader reads records from r separated by split. Records longer than
+// maxSize bytes are dropped.
+func NewReader(r io.Reader, split bufio.SplitFunc, maxSize int) *Reader {
+	return &Reader{r: r, split: split, maxSize: maxSize}
+}
+
+// Next returns the next complete record, skipping any that are only
+// whitespace. Once what's been written to the input so far has been read it
+// returns io.EOF, keeping the start of any record that isn't complete yet;
+// Next may be called again once more has been written.
+func (r *Reader) Next() (string, error) {
+	if r.chunk == nil {
+		r.chunk = make([]byte, readSize)
+	}
+	for {
+		if advance, record, _ := r.split(r.buf.Bytes(), false); advance > 0 {
+			skip := r.dropping || len(bytes.TrimSpace(record)) == 0 || (r.maxSize > 0 && len(record) > r.maxSize)
+			s := string(record)
+			r.buf.Next(advance)
+			r.offset += int64(advance)
+			r.dropping = false
+			if skip {
+				continue
+			}
+			return s, nil
+		}
+		if r.maxSize > 0 && r.buf.Len() > r.maxSize {
+			// forget what's been read, and the rest of the record up to
+			// the next delimiter
+			r.offset += int64(r.buf.Len())
+			r.buf.Reset()
+			r.dropping = true
+			continue
+		}
+		n, err := r.r.Read(r.chunk)
+		r.buf.Write(r.chunk[:n])
+		if n == 0 && err != nil {
+			return "", err
+		}
+	}
+}
+
+// Rest returns the record left at the end of the input once it's finished
+// with, which may have no delimiter after it, or "" if there's none or it's
+// only whitespace
+func (r *Reader) Rest() string {
+	_, record, _ := r.split(r.buf.Bytes(), true)
+	rest := string(record)
+	if r.dropping || len(bytes.TrimSpace(record)) == 0 || (r.maxSize > 0 && len(record) > r.maxSize) {
+		rest = ""
+	}
+	r.offset += int64(r.buf.Len())
+	r.buf.Reset()
+	r.dropping = false
+	return rest
+}
+
+// Offset returns how many bytes of the input have been read up to the end
+// of the last record returned or dropped
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// Reset forgets anything read and starts reading records from in, with
+// the offset counted from 0
+func (r *Reader) Reset(in io.Reader) {
+	r.r = in
+	r.buf.Reset()
+	r.offset = 0
+	r.dropping = false
+}
+
+// Unescape interprets Go string escapes like \x1e and \t so that
+// unprintable delimiters can be given on the command line. A bare \0 is
+// accepted as shorthand for the NUL byte.
+func Unescape(s string) (string, error) {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			b.WriteString(`\"`)
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == '0' && (i+2 == len(s) || s[i+2] < '0' || s[i+2] > '7'):
+			b.WriteString(`\x00`)
+			i++
+		case s[i] == '\\' && i+1 < len(s):
+			b.WriteByte(s[i])
+			b.WriteByte(s[i+1])
+			i++
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return strconv.Unquote(`"` + b.String() + `"`)
+}
@@ -0,0 +1,118 @@
+package records
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	tests := []struct {
+		delim, delimRegex string
+		input             string
+		expected          []string
+	}{
+		{`\0`, "", "one\x00two\x00thr\nee\x00", []string{"one", "two", "thr\nee"}},
+		{`\x1e`, "", "\x1e{\"a\":1}\n\x1e{\"b\":2}\n", []string{"{\"a\":1}\n", "{\"b\":2}\n"}},
+		{"--END--", "", "first\nrecord--END--second", []string{"first\nrecord", "second"}},
+		// whitespace in records is kept, but records of only whitespace
+		// are skipped
+		{`\0`, "", "  padded  \x00\n\x00", []string{"  padded  "}},
+		// records separated by blank lines
+		{"", `\n\n+`, "a=1\nb=2\n\n\nc=3", []string{"a=1\nb=2", "c=3"}},
+		// records longer than the limit are dropped
+		{`\0`, "", strings.Repeat("x", 40) + "\x00short\x00", []string{"short"}},
+	}
+	for _, tt := range tests {
+		split, err := SplitFunc("tail.record", tt.delim, tt.delimRegex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// read a byte at a time, so records and delimiters are split across
+		// reads
+		r := NewReader(&oneByteReader{strings.NewReader(tt.input)}, split, 32)
+		var records []string
+		for {
+			record, err := r.Next()
+			if err == io.EOF {
+				if rest := r.Rest(); rest != "" {
+					records = append(records, rest)
+				}
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, record)
+		}
+		if !reflect.DeepEqual(records, tt.expected) {
+			t.Errorf("delimiter %q %q got records %q, expected %q", tt.delim, tt.delimRegex, records, tt.expected)
+		}
+		if r.Offset() != int64(len(tt.input)) {
+			t.Errorf("delimiter %q %q read to offset %d, expected %d", tt.delim, tt.delimRegex, r.Offset(), len(tt.input))
+		}
+	}
+}
+
+func TestReaderFollow(t *testing.T) {
+	split, _ := SplitFunc("tail.record", `\0`, "")
+	var input strings.Builder
+	src := &growingReader{&input, 0}
+	r := NewReader(src, split, 0)
+	input.WriteString("one\x00tw")
+	if record, err := r.Next(); record != "one" || err != nil {
+		t.Errorf("got %q, %v", record, err)
+	}
+	// the end of what's written so far doesn't end a record
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+	if r.Offset() != 4 {
+		t.Errorf("expected offset 4, got %d", r.Offset())
+	}
+	input.WriteString("o\x00")
+	if record, err := r.Next(); record != "two" || err != nil {
+		t.Errorf("got %q, %v", record, err)
+	}
+}
+
+func TestSplitFuncErrors(t *testing.T) {
+	if split, err := SplitFunc("tail.record", "", ""); split != nil || err != nil {
+		t.Error("expected no split func when no delimiter is set")
+	}
+	for _, bad := range [][2]string{{"x", "y"}, {"", "("}, {`\q`, ""}} {
+		if _, err := SplitFunc("tail.record", bad[0], bad[1]); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+// oneByteReader reads a byte at a time
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (o *oneByteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return o.r.Read(p[:1])
+}
+
+// growingReader reads what's been written to b so far, like a file that's
+// being appended to
+type growingReader struct {
+	b   *strings.Builder
+	off int
+}
+
+func (g *growingReader) Read(p []byte) (int, error) {
+	s := g.b.String()
+	if g.off >= len(s) {
+		return 0, io.EOF
+	}
+	n := copy(p, s[g.off:])
+	g.off += n
+	return n, nil
+}
@@ -0,0 +1,108 @@
+// Package s3backfill reads the log files already in an S3 bucket, for
+// backfilling the logs that AWS services such as ALB, CloudFront and
+// CloudTrail have delivered there.
+//
+// Like the tail package, s3backfill provides a channel on which lines are
+// sent as strings, to be handed to the configured parser. The objects under
+// each prefix given are read once by the bucket package, which keeps track
+// of those already read so a rerun skips them.
+package s3backfill
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/honeycombio/honeytail/awsapi"
+	"github.com/honeycombio/honeytail/bucket"
+	"github.com/honeycombio/honeytail/tail"
+)
+
+type Options struct {
+	Region    string `long:"region" description:"AWS region of the buckets. Defaults to the AWS_REGION or AWS_DEFAULT_REGION environment variable"`
+	Profile   string `long:"profile" description:"Profile in the AWS shared credentials file to use when AWS_ACCESS_KEY_ID isn't set. Defaults to AWS_PROFILE, or else default"`
+	Endpoint  string `long:"endpoint" description:"URL of the S3 API, to use a VPC endpoint or a local emulator. Objects are fetched with path-style URLs"`
+	StateFile string `long:"statefile" description:"File listing the objects already read, which are skipped. Defaults to honeytail.s3backfill.state in the system temp directory"`
+	Start     string `long:"start" description:"Only read objects from this date (YYYY-MM-DD) on. An object's date is the first date in its key, as in .../2017/01/02/... or ...2017-01-02..., or else when it was last modified"`
+	End       string `long:"end" description:"Only read objects up to and including this date (YYYY-MM-DD)"`
+}
+
+type Config struct {
+	// URLs are where to read objects from, in the form s3://bucket/prefix
+	URLs []string
+	// S3 backfill specific options
+	Options Options
+}
+
+// store lists and fetches objects from S3
+type store struct {
+	client *awsapi.Client
+}
+
+func (s store) List(bucketName, prefix, token string) ([]bucket.Object, string, error) {
+	listed, next, err := s.client.ListObjects(bucketName, prefix, token)
+	if err != nil {
+		return nil, "", err
+	}
+	objects := make([]bucket.Object, 0, len(listed))
+	for _, obj := range listed {
+		objects = append(objects, bucket.Object{Key: obj.Key, Modified: obj.LastModified})
+	}
+	return objects, next, nil
+}
+
+func (s store) Get(bucketName, key string) (io.ReadCloser, error) {
+	return s.client.GetObject(bucketName, key)
+}
+
+// GetSampledEntries wraps GetEntries and returns a list of channels that
+// provide sampled entries
+func GetSampledEntries(conf Config, sampleRate uint, abort <-chan struct{}) ([]chan string, error) {
+	linesChans, err := GetEntries(conf, abort)
+	if err != nil {
+		return nil, err
+	}
+	return tail.SampleAll(linesChans, sampleRate), nil
+}
+
+// GetEntries starts reading the objects under conf.URLs and returns a
+// channel on which their lines are sent. The channel is closed once they've
+// all been read, or abort is closed.
+func GetEntries(conf Config, abort <-chan struct{}) ([]chan string, error) {
+	bc := bucket.Config{StateFile: conf.Options.StateFile}
+	for _, u := range conf.URLs {
+		loc, err := bucket.ParseLocation(u, "s3")
+		if err != nil {
+			return nil, err
+		}
+		bc.Locations = append(bc.Locations, loc)
+	}
+	if len(bc.Locations) == 0 {
+		return nil, errors.New("no S3 locations to backfill from")
+	}
+	var err error
+	if bc.Start, err = bucket.ParseDate(conf.Options.Start); err != nil {
+		return nil, err
+	}
+	if bc.End, err = bucket.ParseDate(conf.Options.End); err != nil {
+		return nil, err
+	}
+	region := awsapi.Region(conf.Options.Region)
+	if region == "" {
+		return nil, errors.New("no AWS region given for the S3 backfill; set --s3_backfill.region or AWS_REGION")
+	}
+	creds, err := awsapi.LoadCredentials(conf.Options.Profile)
+	if err != nil {
+		return nil, err
+	}
+	bc.Store = store{awsapi.NewClient("s3", region, conf.Options.Endpoint, "", "", creds)}
+	if bc.StateFile == "" {
+		bc.StateFile = filepath.Join(os.TempDir(), "honeytail.s3backfill.state")
+	}
+	lines, err := bucket.GetEntries(bc, abort)
+	if err != nil {
+		return nil, err
+	}
+	return []chan string{lines}, nil
+}
@@ -0,0 +1,128 @@
+package s3backfill
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// fakeS3 serves a bucket, listing it a page of two keys at a time
+type fakeS3 struct {
+	objects map[string]string
+	gets    []string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/logs" {
+		query := r.URL.Query()
+		var keys []string
+		for key := range f.objects {
+			if strings.HasPrefix(key, query.Get("prefix")) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		start, _ := strconv.Atoi(query.Get("continuation-token"))
+		end := start + 2
+		truncated := end < len(keys)
+		if !truncated {
+			end = len(keys)
+		}
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult>`)
+		for _, key := range keys[start:end] {
+			fmt.Fprintf(w, `<Contents><Key>%s</Key><LastModified>2017-01-05T10:00:00.000Z</LastModified><Size>%d</Size></Contents>`,
+				key, len(f.objects[key]))
+		}
+		fmt.Fprintf(w, `<IsTruncated>%t</IsTruncated><NextContinuationToken>%d</NextContinuationToken></ListBucketResult>`, truncated, end)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/logs/")
+	f.gets = append(f.gets, key)
+	contents, ok := f.objects[key]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `<Error><Code>NoSuchKey</Code></Error>`)
+		return
+	}
+	fmt.Fprint(w, contents)
+}
+
+func TestGetEntries(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	fake := &fakeS3{objects: map[string]string{
+		"elb/2017/01/01/a.log": "too early",
+		"elb/2017/01/02/a.log": "a one\na two\n",
+		"elb/2017/01/02/b.log": "b one",
+		"elb/2017/01/03/":      "",
+		"elb/2017/01/03/c.log": "c one\r\n",
+		"elb/2017/01/04/d.log": "too late",
+		"other/2017/01/02.log": "not under the prefix",
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "s3backfill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := Config{
+		URLs: []string{"s3://logs/elb/"},
+		Options: Options{
+			Region:    "us-east-1",
+			Endpoint:  server.URL,
+			StateFile: filepath.Join(dir, "state"),
+			Start:     "2017-01-02",
+			End:       "2017-01-03",
+		},
+	}
+	read := func() []string {
+		chans, err := GetEntries(conf, make(chan struct{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		for line := range chans[0] {
+			lines = append(lines, line)
+		}
+		return lines
+	}
+	expected := []string{"a one", "a two", "b one", "c one"}
+	if lines := read(); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("read %q, expected %q", lines, expected)
+	}
+
+	// a rerun only reads what's new
+	fake.objects["elb/2017/01/03/e.log"] = "e one"
+	fake.gets = nil
+	if lines := read(); !reflect.DeepEqual(lines, []string{"e one"}) {
+		t.Errorf("read %q on rerunning, expected only the new object", lines)
+	}
+	if expected := []string{"elb/2017/01/03/e.log"}; !reflect.DeepEqual(fake.gets, expected) {
+		t.Errorf("fetched %q on rerunning, expected %q", fake.gets, expected)
+	}
+
+	for _, bad := range []Config{
+		{URLs: []string{"https://logs/elb"}, Options: conf.Options},
+		{URLs: conf.URLs, Options: Options{Region: "us-east-1", Start: "01/02/2017"}},
+	} {
+		if _, err := GetEntries(bad, make(chan struct{})); err == nil {
+			t.Errorf("expected an error for %+v", bad)
+		}
+	}
+}
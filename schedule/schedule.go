@@ -0,0 +1,183 @@
+// Package schedule decides whether honeytail may do work at a given time,
+// based on cron-style expressions.
+//
+// Rather than naming the moments a job starts, as in crontab, an expression
+// here names the minutes during which work is allowed. Each expression has
+// five space separated fields:
+//
+//	minute hour day-of-month month day-of-week
+//
+// Each field is *, a number, a range (1-5), a list (1,3,5) or any of those
+// with a step (*/15, 0-30/10). Months and days of the week may be given by
+// their first three letters. Sunday is both 0 and 7. As in cron, when both
+// day-of-month and day-of-week are restricted a day matching either is
+// allowed.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type field struct {
+	min, max int
+	names    map[string]int
+}
+
+var fields = []field{
+	{min: 0, max: 59},
+	{min: 0, max: 23},
+	{min: 1, max: 31},
+	{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}},
+	{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}},
+}
+
+// Window is a parsed expression
+type Window struct {
+	expr   string
+	minute map[int]bool
+	hour   map[int]bool
+	dom    map[int]bool
+	month  map[int]bool
+	dow    map[int]bool
+	// domAny and dowAny record unrestricted day fields, for cron's either-day
+	// rule
+	domAny bool
+	dowAny bool
+}
+
+// Parse parses an expression
+func Parse(expr string) (*Window, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("schedule %q should have %d fields: minute hour day-of-month month day-of-week", expr, len(fields))
+	}
+	sets := make([]map[int]bool, len(fields))
+	for i, part := range parts {
+		set, err := parseField(strings.ToLower(part), fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %s", expr, err)
+		}
+		sets[i] = set
+	}
+	// 7 is another name for Sunday
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+	return &Window{
+		expr:   expr,
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: parts[2] == "*",
+		dowAny: parts[4] == "*",
+	}, nil
+}
+
+// parseField returns the values a single field matches
+func parseField(s string, f field) (map[int]bool, error) {
+	set := make(map[int]bool)
+	for _, item := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(item[i+1:])
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("bad step in %q", item)
+			}
+			item = item[:i]
+		}
+		lo, hi := f.min, f.max
+		if item != "*" {
+			bounds := strings.SplitN(item, "-", 2)
+			var err error
+			if lo, err = f.value(bounds[0]); err != nil {
+				return nil, err
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = f.value(bounds[1]); err != nil {
+					return nil, err
+				}
+			}
+			if hi < lo {
+				return nil, fmt.Errorf("range %q is backwards", item)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// value converts a number or name to a value in range for the field
+func (f field) value(s string) (int, error) {
+	if v, ok := f.names[s]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("%d is out of range %d-%d", v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Contains returns true if work is allowed at t
+func (w *Window) Contains(t time.Time) bool {
+	if !w.minute[t.Minute()] || !w.hour[t.Hour()] || !w.month[int(t.Month())] {
+		return false
+	}
+	dom := w.dom[t.Day()]
+	dow := w.dow[int(t.Weekday())]
+	if w.domAny || w.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (w *Window) String() string {
+	return w.expr
+}
+
+// Schedule is a set of windows, any of which allows work
+type Schedule []*Window
+
+// New parses each of the expressions into a Schedule
+func New(exprs []string) (Schedule, error) {
+	s := make(Schedule, 0, len(exprs))
+	for _, expr := range exprs {
+		w, err := Parse(expr)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, w)
+	}
+	return s, nil
+}
+
+// Open returns true if work is allowed at t. An empty schedule is always
+// open.
+func (s Schedule) Open(t time.Time) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, w := range s {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
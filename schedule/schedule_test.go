@@ -0,0 +1,84 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, bad := range []string{
+		"* * * *",
+		"60 * * * *",
+		"* 5-1 * * *",
+		"* * * foo *",
+		"*/0 * * * *",
+		"* * 0 * *",
+	} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	// Monday 2019-01-07
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2019, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+	testCases := []struct {
+		expr     string
+		t        time.Time
+		expected bool
+	}{
+		{"* * * * *", at(7, 12, 0), true},
+		// weekday nights
+		{"* 0-6,20-23 * * mon-fri", at(7, 21, 30), true},
+		{"* 0-6,20-23 * * mon-fri", at(7, 12, 0), false},
+		{"* 0-6,20-23 * * mon-fri", at(12, 21, 30), false},
+		// weekends, with Sunday as 7
+		{"* * * * 6-7", at(13, 12, 0), true},
+		{"* * * * sat,sun", at(13, 12, 0), true},
+		{"* * * * 6-7", at(11, 12, 0), false},
+		// steps
+		{"*/15 * * * *", at(7, 3, 45), true},
+		{"*/15 * * * *", at(7, 3, 46), false},
+		{"0-30/10 * * * *", at(7, 3, 20), true},
+		// months
+		{"* * * jan *", at(7, 3, 20), true},
+		{"* * * feb-dec *", at(7, 3, 20), false},
+		// with both day fields restricted, either may match
+		{"* * 1 * mon", at(7, 3, 20), true},
+		{"* * 1 * tue", at(1, 3, 20), true},
+		{"* * 2 * tue", at(7, 3, 20), false},
+	}
+	for _, tc := range testCases {
+		w, err := Parse(tc.expr)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tc.expr, err)
+			continue
+		}
+		if got := w.Contains(tc.t); got != tc.expected {
+			t.Errorf("%q at %s: got %v, expected %v", tc.expr, tc.t.Format(time.RFC1123), got, tc.expected)
+		}
+	}
+}
+
+func TestScheduleOpen(t *testing.T) {
+	var empty Schedule
+	if !empty.Open(time.Now()) {
+		t.Error("an empty schedule should always be open")
+	}
+	s, err := New([]string{"* 0-6 * * *", "* * * * sat,sun"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Open(time.Date(2019, 1, 7, 5, 0, 0, 0, time.UTC)) || !s.Open(time.Date(2019, 1, 12, 12, 0, 0, 0, time.UTC)) {
+		t.Error("schedule should be open in either window")
+	}
+	if s.Open(time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)) {
+		t.Error("schedule should be closed outside its windows")
+	}
+	if _, err := New([]string{"* * * * *", "bad"}); err == nil {
+		t.Error("expected error for bad expression")
+	}
+}
@@ -0,0 +1,229 @@
+// Package session groups events into per-user sessions and summarizes each
+// session once it ends, for product analytics straight from access logs.
+//
+// A session is the run of events sharing a key (eg user_id or client_ip)
+// with no gap between them longer than the configured inactivity gap. Gaps
+// are measured both in event time, so backfills sessionize the same way live
+// logs do, and in wall clock time, so sessions end even when traffic stops.
+package session
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+// prefix is added to the summary fields
+const prefix = "session."
+
+type Config struct {
+	// KeyFields are the fields that identify whose session an event is in
+	KeyFields []string
+	// Gap is how long a session may be idle before it ends
+	Gap time.Duration
+	// PathField is the field holding the request path, for counting distinct
+	// paths and recording the entry and exit pages
+	PathField string
+	// Dataset is where to send summaries; empty means the events' dataset
+	Dataset string
+}
+
+// session is a session in progress
+type session struct {
+	keys      map[string]interface{}
+	start     time.Time
+	last      time.Time
+	lastWall  time.Time
+	count     int
+	paths     map[string]bool
+	firstPath string
+	lastPath  string
+	dataset   string
+}
+
+// Sessionizer tracks the sessions in progress
+type Sessionizer struct {
+	conf     Config
+	sessions map[string]*session
+	// latest is the latest event time seen
+	latest time.Time
+	// inputs is the number of pipelines feeding the sessionizer
+	inputs int
+	lock   sync.Mutex
+	now    func() time.Time
+}
+
+// New returns a Sessionizer, or nil if conf has no key fields
+func New(conf Config) *Sessionizer {
+	if len(conf.KeyFields) == 0 {
+		return nil
+	}
+	return &Sessionizer{
+		conf:     conf,
+		sessions: make(map[string]*session),
+		now:      time.Now,
+	}
+}
+
+// Add records ev in its session, returning the summary of the session it
+// ended, if any
+func (s *Sessionizer) Add(ev event.Event) []event.Event {
+	keys := make(map[string]interface{}, len(s.conf.KeyFields))
+	parts := make([]string, 0, len(s.conf.KeyFields))
+	for _, f := range s.conf.KeyFields {
+		v, ok := ev.Data[f]
+		if !ok {
+			// events without the key aren't part of a session
+			return nil
+		}
+		keys[f] = v
+		parts = append(parts, fmt.Sprintf("%v", v))
+	}
+	key := strings.Join(parts, "\x00")
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var ended []event.Event
+	if ev.Timestamp.After(s.latest) {
+		s.latest = ev.Timestamp
+	}
+	sess, ok := s.sessions[key]
+	if ok && ev.Timestamp.Sub(sess.last) > s.conf.Gap {
+		ended = append(ended, s.summarize(sess))
+		ok = false
+	}
+	if !ok {
+		sess = &session{
+			keys:    keys,
+			start:   ev.Timestamp,
+			paths:   make(map[string]bool),
+			dataset: ev.Dataset,
+		}
+		s.sessions[key] = sess
+	}
+	if ev.Timestamp.After(sess.last) {
+		sess.last = ev.Timestamp
+	}
+	if ev.Timestamp.Before(sess.start) {
+		sess.start = ev.Timestamp
+	}
+	sess.lastWall = s.now()
+	// events that were sampled stand for several
+	if ev.SampleRate > 1 {
+		sess.count += ev.SampleRate
+	} else {
+		sess.count++
+	}
+	if path, ok := ev.Data[s.conf.PathField].(string); ok && path != "" {
+		if sess.firstPath == "" {
+			sess.firstPath = path
+		}
+		sess.lastPath = path
+		sess.paths[path] = true
+	}
+	return ended
+}
+
+// Expire ends the sessions that have been idle for longer than the gap,
+// returning their summaries
+func (s *Sessionizer) Expire() []event.Event {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now()
+	var ended []event.Event
+	for key, sess := range s.sessions {
+		if s.latest.Sub(sess.last) > s.conf.Gap || now.Sub(sess.lastWall) > s.conf.Gap {
+			ended = append(ended, s.summarize(sess))
+			delete(s.sessions, key)
+		}
+	}
+	return ended
+}
+
+// Flush ends all the sessions in progress, returning their summaries
+func (s *Sessionizer) Flush() []event.Event {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ended := make([]event.Event, 0, len(s.sessions))
+	for key, sess := range s.sessions {
+		ended = append(ended, s.summarize(sess))
+		delete(s.sessions, key)
+	}
+	return ended
+}
+
+// summarize builds the summary event for a session
+func (s *Sessionizer) summarize(sess *session) event.Event {
+	data := make(map[string]interface{}, len(sess.keys)+6)
+	for k, v := range sess.keys {
+		data[k] = v
+	}
+	data[prefix+"duration_sec"] = sess.last.Sub(sess.start).Seconds()
+	data[prefix+"event_count"] = sess.count
+	data[prefix+"distinct_paths"] = len(sess.paths)
+	if sess.firstPath != "" {
+		data[prefix+"entry_path"] = sess.firstPath
+		data[prefix+"exit_path"] = sess.lastPath
+	}
+	dataset := s.conf.Dataset
+	if dataset == "" {
+		dataset = sess.dataset
+	}
+	return event.Event{
+		Timestamp: sess.start,
+		Dataset:   dataset,
+		// the summary covers every event, whether or not it was sent
+		SampleRate: 1,
+		Data:       data,
+	}
+}
+
+// Sessionize passes through the events read from in, adding session summary
+// events as sessions end. Several inputs may share a Sessionizer, so that
+// sessions span them; summaries of the sessions still in progress are sent
+// once the last of them is closed.
+func Sessionize(in chan event.Event, s *Sessionizer) chan event.Event {
+	if s == nil {
+		return in
+	}
+	s.lock.Lock()
+	s.inputs++
+	s.lock.Unlock()
+	out := make(chan event.Event, cap(in))
+	go func() {
+		defer close(out)
+		// check for idle sessions a few times per gap
+		ticker := time.NewTicker(s.conf.Gap / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case ev, ok := <-in:
+				if !ok {
+					s.lock.Lock()
+					s.inputs--
+					last := s.inputs == 0
+					s.lock.Unlock()
+					if last {
+						for _, summary := range s.Flush() {
+							out <- summary
+						}
+					}
+					return
+				}
+				summaries := s.Add(ev)
+				out <- ev
+				for _, summary := range summaries {
+					out <- summary
+				}
+			case <-ticker.C:
+				for _, summary := range s.Expire() {
+					out <- summary
+				}
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,123 @@
+package session
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+var start = time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)
+
+func hit(user, path string, offset time.Duration) event.Event {
+	return event.Event{
+		Timestamp: start.Add(offset),
+		Data:      map[string]interface{}{"user_id": user, "request_path": path},
+	}
+}
+
+func TestAdd(t *testing.T) {
+	s := New(Config{KeyFields: []string{"user_id"}, Gap: 30 * time.Minute, PathField: "request_path"})
+	wall := start
+	s.now = func() time.Time { return wall }
+
+	for _, ev := range []event.Event{
+		hit("alice", "/", 0),
+		hit("alice", "/cart", time.Minute),
+		hit("bob", "/", 2*time.Minute),
+		hit("alice", "/cart", 10*time.Minute),
+	} {
+		if ended := s.Add(ev); len(ended) != 0 {
+			t.Fatalf("no sessions should have ended yet, got %+v", ended)
+		}
+	}
+	// events without the key are ignored
+	s.Add(event.Event{Timestamp: start, Data: map[string]interface{}{"request_path": "/"}})
+
+	// alice comes back after the gap, which ends her first session
+	ended := s.Add(hit("alice", "/checkout", time.Hour))
+	expected := []event.Event{{
+		Timestamp:  start,
+		SampleRate: 1,
+		Data: map[string]interface{}{
+			"user_id":                "alice",
+			"session.duration_sec":   float64(600),
+			"session.event_count":    3,
+			"session.distinct_paths": 2,
+			"session.entry_path":     "/",
+			"session.exit_path":      "/cart",
+		},
+	}}
+	if !reflect.DeepEqual(ended, expected) {
+		t.Errorf("got %+v, expected %+v", ended, expected)
+	}
+
+	// bob's session has been idle for longer than the gap in event time
+	ended = s.Expire()
+	if len(ended) != 1 || ended[0].Data["user_id"] != "bob" {
+		t.Errorf("expected bob's session to expire, got %+v", ended)
+	}
+
+	// alice's second session expires once the wall clock moves on
+	if ended = s.Expire(); len(ended) != 0 {
+		t.Errorf("expected no sessions to expire, got %+v", ended)
+	}
+	wall = wall.Add(31 * time.Minute)
+	if ended = s.Expire(); len(ended) != 1 || ended[0].Data["session.entry_path"] != "/checkout" {
+		t.Errorf("expected alice's second session to expire, got %+v", ended)
+	}
+}
+
+func TestSampledEventsCount(t *testing.T) {
+	s := New(Config{KeyFields: []string{"user_id"}, Gap: time.Minute, Dataset: "sessions"})
+	ev := hit("alice", "/", 0)
+	ev.SampleRate = 10
+	ev.Dataset = "events"
+	s.Add(ev)
+	dropped := hit("alice", "/", time.Second)
+	dropped.SampleRate = -1
+	s.Add(dropped)
+	ended := s.Flush()
+	if len(ended) != 1 || ended[0].Data["session.event_count"] != 11 || ended[0].Dataset != "sessions" {
+		t.Errorf("unexpected summary %+v", ended)
+	}
+}
+
+func TestSessionize(t *testing.T) {
+	if New(Config{}) != nil {
+		t.Error("expected no sessionizer without key fields")
+	}
+	s := New(Config{KeyFields: []string{"user_id"}, Gap: time.Hour, PathField: "request_path"})
+	// sessions span both inputs
+	in1 := make(chan event.Event, 10)
+	in2 := make(chan event.Event, 10)
+	out1 := Sessionize(in1, s)
+	out2 := Sessionize(in2, s)
+	in1 <- hit("alice", "/", 0)
+	in1 <- hit("bob", "/", time.Second)
+	close(in1)
+	var evs []event.Event
+	for ev := range out1 {
+		evs = append(evs, ev)
+	}
+	if len(evs) != 2 {
+		t.Fatalf("sessions shouldn't end while another input is open, got %+v", evs)
+	}
+	in2 <- hit("alice", "/about", 2*time.Second)
+	close(in2)
+	for ev := range out2 {
+		evs = append(evs, ev)
+	}
+	if len(evs) != 5 {
+		t.Fatalf("expected 3 events and 2 summaries, got %+v", evs)
+	}
+	summaries := evs[3:]
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Data["user_id"].(string) < summaries[j].Data["user_id"].(string)
+	})
+	if summaries[0].Data["session.distinct_paths"] != 2 || summaries[1].Data["session.event_count"] != 1 {
+		t.Errorf("unexpected summaries %+v", summaries)
+	}
+}
@@ -0,0 +1,94 @@
+package sqs
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// object is an S3 object named in a notification
+type object struct {
+	Bucket string
+	Key    string
+}
+
+// notification holds the fields of the notifications understood, which are
+// sent by S3 directly, wrapped by SNS, or by EventBridge, along with those
+// CloudTrail sends to SNS when it delivers a log file
+type notification struct {
+	// SNS
+	Type    string
+	Message string
+
+	// S3 event notifications
+	Event   string
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	}
+
+	// EventBridge
+	DetailType string `json:"detail-type"`
+	Detail     struct {
+		Bucket struct {
+			Name string `json:"name"`
+		} `json:"bucket"`
+		Object struct {
+			Key string `json:"key"`
+		} `json:"object"`
+	} `json:"detail"`
+
+	// CloudTrail
+	S3Bucket    string   `json:"s3Bucket"`
+	S3ObjectKey []string `json:"s3ObjectKey"`
+}
+
+// parseNotification returns the objects created that a message's body
+// tells of. Notifications of other events, and the test event S3 sends
+// when notifications are set up, have none.
+func parseNotification(body string) ([]object, error) {
+	var n notification
+	if err := json.Unmarshal([]byte(body), &n); err != nil {
+		return nil, errors.New("message is not a JSON S3 notification: " + err.Error())
+	}
+	switch {
+	case n.Type == "Notification" && n.Message != "":
+		return parseNotification(n.Message)
+	case n.Event == "s3:TestEvent":
+		return nil, nil
+	case n.Records != nil:
+		var objects []object
+		for _, r := range n.Records {
+			if !strings.HasPrefix(r.EventName, "ObjectCreated:") {
+				continue
+			}
+			// keys are URL encoded, with spaces as +
+			key, err := url.QueryUnescape(r.S3.Object.Key)
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, object{Bucket: r.S3.Bucket.Name, Key: key})
+		}
+		return objects, nil
+	case n.DetailType != "":
+		if n.DetailType != "Object Created" {
+			return nil, nil
+		}
+		return []object{{Bucket: n.Detail.Bucket.Name, Key: n.Detail.Object.Key}}, nil
+	case n.S3Bucket != "":
+		objects := make([]object, 0, len(n.S3ObjectKey))
+		for _, key := range n.S3ObjectKey {
+			objects = append(objects, object{Bucket: n.S3Bucket, Key: key})
+		}
+		return objects, nil
+	}
+	return nil, errors.New("message is not an S3 notification")
+}
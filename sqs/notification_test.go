@@ -0,0 +1,42 @@
+package sqs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseNotification(t *testing.T) {
+	s3Event := `{"Records":[{"eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"logs"},` +
+		`"object":{"key":"AWSLogs/elb/2017/01/a+b%3D1.log.gz","size":100}}},` +
+		`{"eventName":"ObjectRemoved:Delete","s3":{"bucket":{"name":"logs"},"object":{"key":"gone"}}}]}`
+	tests := []struct {
+		body     string
+		expected []object
+	}{
+		{s3Event, []object{{"logs", "AWSLogs/elb/2017/01/a b=1.log.gz"}}},
+		// wrapped by SNS
+		{`{"Type":"Notification","MessageId":"1","Message":` + quote(s3Event) + `}`,
+			[]object{{"logs", "AWSLogs/elb/2017/01/a b=1.log.gz"}}},
+		{`{"Service":"Amazon S3","Event":"s3:TestEvent","Bucket":"logs"}`, nil},
+		{`{"detail-type":"Object Created","source":"aws.s3","detail":{"bucket":{"name":"logs"},"object":{"key":"cf/E1.gz"}}}`,
+			[]object{{"logs", "cf/E1.gz"}}},
+		{`{"detail-type":"Object Deleted","detail":{"bucket":{"name":"logs"},"object":{"key":"cf/E1.gz"}}}`, nil},
+		{`{"s3Bucket":"trail","s3ObjectKey":["AWSLogs/1/CloudTrail/a.json.gz","AWSLogs/1/CloudTrail/b.json.gz"]}`,
+			[]object{{"trail", "AWSLogs/1/CloudTrail/a.json.gz"}, {"trail", "AWSLogs/1/CloudTrail/b.json.gz"}}},
+	}
+	for _, tt := range tests {
+		got, err := parseNotification(tt.body)
+		if err != nil {
+			t.Errorf("parseNotification(%s) returned %v", tt.body, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("parseNotification(%s) = %+v, expected %+v", tt.body, got, tt.expected)
+		}
+	}
+	for _, body := range []string{"not json", `{"hello":"world"}`} {
+		if _, err := parseNotification(body); err == nil {
+			t.Errorf("expected an error parsing %s", body)
+		}
+	}
+}
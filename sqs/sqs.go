@@ -0,0 +1,256 @@
+// Package sqs reads the log files that AWS services deliver to S3, finding
+// them from the notifications sent to an SQS queue as they're written.
+//
+// Like the tail and listen packages, sqs provides a channel on which lines
+// are sent as strings, to be handed to the configured parser. Each object
+// a message tells of is downloaded, decompressed if it's gzipped or
+// bzipped, and sent a line at a time. The message is deleted only once
+// every line of its objects has been sent; if an object can't be read, or
+// honeytail stops first, the message becomes visible again for another try.
+// Messages kept in flight while their objects are read have their
+// visibility timeout extended, so they aren't handed out again meanwhile.
+package sqs
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/awsapi"
+	"github.com/honeycombio/honeytail/bucket"
+	"github.com/honeycombio/honeytail/tail"
+)
+
+type Options struct {
+	Region            string `long:"region" description:"AWS region of the queue and buckets. Defaults to the region in the queue URL"`
+	Profile           string `long:"profile" description:"Profile in the AWS shared credentials file to use when AWS_ACCESS_KEY_ID isn't set. Defaults to AWS_PROFILE, or else default"`
+	S3Endpoint        string `long:"s3_endpoint" description:"URL of the S3 API, to use a VPC endpoint or a local emulator. Objects are fetched with path-style URLs"`
+	VisibilityTimeout int    `long:"visibility_timeout" description:"Seconds a message is hidden from other readers of the queue while its objects are read. It's extended for as long as reading takes" default:"300"`
+}
+
+type Config struct {
+	// Queue is the URL of the queue to read notifications from
+	Queue string
+	// SQS specific options
+	Options Options
+}
+
+var (
+	// waitTime is how long ReceiveMessage waits for messages, in seconds
+	waitTime = 20
+	// retryWait is how long to wait after failing to receive messages
+	retryWait = 10 * time.Second
+)
+
+// message is a message in a ReceiveMessage response
+type message struct {
+	MessageId     string
+	ReceiptHandle string
+	Body          string
+}
+
+// reader reads the objects that the messages in a queue tell of
+type reader struct {
+	conf  Config
+	sqs   *awsapi.Client
+	s3    *awsapi.Client
+	lines chan string
+	abort <-chan struct{}
+}
+
+// GetSampledEntries wraps GetEntries and returns a list of channels that
+// provide sampled entries
+func GetSampledEntries(conf Config, sampleRate uint, abort <-chan struct{}) ([]chan string, error) {
+	linesChans, err := GetEntries(conf, abort)
+	if err != nil {
+		return nil, err
+	}
+	return tail.SampleAll(linesChans, sampleRate), nil
+}
+
+// GetEntries starts reading the queue and returns a channel on which the
+// lines of the objects it tells of are sent. The channel is closed once
+// abort is closed.
+func GetEntries(conf Config, abort <-chan struct{}) ([]chan string, error) {
+	queue, err := url.Parse(conf.Queue)
+	if err != nil || queue.Host == "" {
+		return nil, errors.New("the SQS queue must be given as its URL, eg https://sqs.us-east-1.amazonaws.com/123456789012/logs")
+	}
+	region := conf.Options.Region
+	if region == "" {
+		// queue URLs look like sqs.<region>.amazonaws.com
+		if parts := strings.Split(queue.Host, "."); len(parts) == 4 && parts[0] == "sqs" {
+			region = parts[1]
+		}
+	}
+	if region = awsapi.Region(region); region == "" {
+		return nil, errors.New("no AWS region given for the SQS queue; set --sqs.region or AWS_REGION")
+	}
+	if conf.Options.VisibilityTimeout <= 0 {
+		return nil, errors.New("--sqs.visibility_timeout must be at least 1 second")
+	}
+	creds, err := awsapi.LoadCredentials(conf.Options.Profile)
+	if err != nil {
+		return nil, err
+	}
+	r := &reader{
+		conf: conf,
+		sqs: awsapi.NewClient("sqs", region, queue.Scheme+"://"+queue.Host,
+			"AmazonSQS", "application/x-amz-json-1.0", creds),
+		s3:    awsapi.NewClient("s3", region, conf.Options.S3Endpoint, "", "", creds),
+		lines: make(chan string),
+		abort: abort,
+	}
+	// check the queue can be read now, so a missing queue or bad
+	// credentials are reported at startup
+	err = r.sqs.Call("GetQueueAttributes", map[string]interface{}{
+		"QueueUrl":       conf.Queue,
+		"AttributeNames": []string{"QueueArn"},
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	go r.run()
+	return []chan string{r.lines}, nil
+}
+
+// run receives messages and reads their objects until abort is closed
+func (r *reader) run() {
+	defer close(r.lines)
+	for {
+		messages, err := r.receive()
+		select {
+		case <-r.abort:
+			return
+		default:
+		}
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"queue": r.conf.Queue,
+				"err":   err,
+			}).Warn("Failed to receive SQS messages, trying again")
+			select {
+			case <-time.After(retryWait):
+			case <-r.abort:
+				return
+			}
+			continue
+		}
+		for _, m := range messages {
+			if !r.handle(m) {
+				return
+			}
+		}
+	}
+}
+
+// receive waits for messages from the queue, returning early if abort is
+// closed. Messages received after that are left to become visible again.
+func (r *reader) receive() ([]message, error) {
+	type result struct {
+		messages []message
+		err      error
+	}
+	results := make(chan result, 1)
+	go func() {
+		var out struct {
+			Messages []message
+		}
+		err := r.sqs.Call("ReceiveMessage", map[string]interface{}{
+			"QueueUrl":            r.conf.Queue,
+			"MaxNumberOfMessages": 10,
+			"WaitTimeSeconds":     waitTime,
+			"VisibilityTimeout":   r.conf.Options.VisibilityTimeout,
+		}, &out)
+		results <- result{out.Messages, err}
+	}()
+	select {
+	case res := <-results:
+		return res.messages, res.err
+	case <-r.abort:
+		return nil, nil
+	}
+}
+
+// handle sends the lines of the objects message tells of, then deletes it.
+// It returns false if abort was closed first.
+func (r *reader) handle(m message) bool {
+	logger := logrus.WithFields(logrus.Fields{"queue": r.conf.Queue, "message_id": m.MessageId})
+	objects, err := parseNotification(m.Body)
+	if err != nil {
+		// leave it to be moved to the queue's dead letter queue, if it
+		// has one
+		logger.WithField("err", err).Warn("Skipping SQS message")
+		return true
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go r.keepHidden(m, done)
+	for _, obj := range objects {
+		ok, err := r.readObject(obj)
+		if !ok {
+			return false
+		}
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"bucket": obj.Bucket,
+				"key":    obj.Key,
+				"err":    err,
+			}).Warn("Failed to read S3 object, leaving its message to be received again")
+			return true
+		}
+	}
+	err = r.sqs.Call("DeleteMessage", map[string]interface{}{
+		"QueueUrl":      r.conf.Queue,
+		"ReceiptHandle": m.ReceiptHandle,
+	}, nil)
+	if err != nil {
+		logger.WithField("err", err).Warn("Failed to delete SQS message; its objects will be read again")
+	}
+	return true
+}
+
+// keepHidden extends the visibility timeout of message m until done is
+// closed, so it isn't received again while its objects are being read
+func (r *reader) keepHidden(m message, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Duration(r.conf.Options.VisibilityTimeout) * time.Second / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := r.sqs.Call("ChangeMessageVisibility", map[string]interface{}{
+				"QueueUrl":          r.conf.Queue,
+				"ReceiptHandle":     m.ReceiptHandle,
+				"VisibilityTimeout": r.conf.Options.VisibilityTimeout,
+			}, nil)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"queue":      r.conf.Queue,
+					"message_id": m.MessageId,
+					"err":        err,
+				}).Warn("Failed to extend SQS message visibility timeout")
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// readObject downloads obj and sends its lines. It returns false if abort
+// was closed first.
+func (r *reader) readObject(obj object) (bool, error) {
+	body, err := r.s3.GetObject(obj.Bucket, obj.Key)
+	if err != nil {
+		return true, err
+	}
+	defer body.Close()
+	rd, err := bucket.Decompress(body)
+	if err != nil {
+		return true, err
+	}
+	return bucket.SendLines(rd, r.lines, r.abort)
+}
@@ -0,0 +1,160 @@
+package sqs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func quote(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
+// fakeAWS serves a queue and the S3 objects its messages tell of
+type fakeAWS struct {
+	objects map[string][]byte
+
+	lock     sync.Mutex
+	messages []message
+	received bool
+	deleted  []string
+}
+
+func (f *fakeAWS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		if r.Header.Get("X-Amz-Content-Sha256") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		contents, ok := f.objects[r.URL.EscapedPath()]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+			return
+		}
+		w.Write(contents)
+		return
+	}
+	var in map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&in)
+	if in["QueueUrl"] != "http://"+r.Host+"/123456789012/logs" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"__type":"com.amazonaws.sqs#QueueDoesNotExist","message":"no queue"}`)
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	switch r.Header.Get("X-Amz-Target") {
+	case "AmazonSQS.GetQueueAttributes":
+		fmt.Fprint(w, `{"Attributes":{"QueueArn":"arn:aws:sqs:us-east-1:123456789012:logs"}}`)
+	case "AmazonSQS.ReceiveMessage":
+		if f.received {
+			// long polling, but not for long
+			time.Sleep(10 * time.Millisecond)
+			fmt.Fprint(w, `{}`)
+			return
+		}
+		f.received = true
+		json.NewEncoder(w).Encode(map[string]interface{}{"Messages": f.messages})
+	case "AmazonSQS.DeleteMessage":
+		f.deleted = append(f.deleted, in["ReceiptHandle"].(string))
+		fmt.Fprint(w, `{}`)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func s3Notification(bucket, key string) string {
+	return `{"Records":[{"eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"` + bucket +
+		`"},"object":{"key":"` + key + `"}}}]}`
+}
+
+func TestGetEntries(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	var zipped bytes.Buffer
+	gz := gzip.NewWriter(&zipped)
+	gz.Write([]byte("zipped one\nzipped two\n"))
+	gz.Close()
+	fake := &fakeAWS{
+		objects: map[string][]byte{
+			"/logs/elb/a%20b.log.gz": zipped.Bytes(),
+			"/logs/plain.log":        []byte("plain one\r\nplain two"),
+		},
+		messages: []message{
+			{"1", "receipt-1", s3Notification("logs", "elb/a+b.log.gz")},
+			{"2", "receipt-2", `{"Type":"Notification","Message":` + quote(s3Notification("logs", "plain.log")) + `}`},
+			{"3", "receipt-3", `{"Service":"Amazon S3","Event":"s3:TestEvent"}`},
+			// left for another try
+			{"4", "receipt-4", s3Notification("logs", "missing.log")},
+			{"5", "receipt-5", "not a notification"},
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	abort := make(chan struct{})
+	conf := Config{
+		Queue: server.URL + "/123456789012/logs",
+		Options: Options{
+			Region:            "us-east-1",
+			S3Endpoint:        server.URL,
+			VisibilityTimeout: 300,
+		},
+	}
+	chans, err := GetEntries(conf, abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for len(lines) < 4 {
+		lines = append(lines, <-chans[0])
+	}
+	expected := []string{"zipped one", "zipped two", "plain one", "plain two"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("read %q, expected %q", lines, expected)
+	}
+
+	// wait for the messages read to be deleted
+	var deleted []string
+	for i := 0; i < 100; i++ {
+		fake.lock.Lock()
+		deleted = append([]string(nil), fake.deleted...)
+		fake.lock.Unlock()
+		if len(deleted) == 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	sort.Strings(deleted)
+	if expected := []string{"receipt-1", "receipt-2", "receipt-3"}; !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("deleted %q, expected %q", deleted, expected)
+	}
+	close(abort)
+	for line := range chans[0] {
+		t.Errorf("unexpected line %q", line)
+	}
+
+	conf.Queue = strings.Replace(conf.Queue, "logs", "missing", 1)
+	if _, err := GetEntries(conf, make(chan struct{})); err == nil || !strings.Contains(err.Error(), "QueueDoesNotExist") {
+		t.Errorf("got %v for a missing queue, expected QueueDoesNotExist", err)
+	}
+}
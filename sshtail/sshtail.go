@@ -0,0 +1,543 @@
+// Package sshtail follows log files on remote hosts over SSH, for
+// appliances and other hosts where honeytail can't be installed.
+//
+// Like the tail package, sshtail provides a channel for each file on which
+// its lines are sent as strings. Each file is read by running tail on the
+// remote host with the ssh command, so hosts, keys and known hosts are set
+// up as for any other use of ssh. The remote file is checked for rotation
+// or truncation while it's read, upon which the session is restarted to
+// read the new file from its start. The inode and offset reached in each
+// file are kept in a local statefile, so --tail.read_from=last carries on
+// from there when honeytail restarts.
+package sshtail
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/atomicfile"
+	"github.com/honeycombio/honeytail/tail"
+)
+
+type Options struct {
+	Command       string   `long:"command" description:"ssh client to run" default:"ssh"`
+	IdentityFile  string   `long:"identity_file" description:"Private key to authenticate with, passed to ssh -i. Defaults to those ssh uses"`
+	SSHOptions    []string `long:"option" description:"Option to pass to ssh -o, such as StrictHostKeyChecking=yes. May be specified multiple times"`
+	CheckInterval uint     `long:"check_interval" description:"How often, in seconds, to check each remote file for rotation or truncation" default:"5"`
+	StateFile     string   `long:"statefile" description:"File keeping the inode and offset reached in each remote file, for --tail.read_from=last. Defaults to honeytail.ssh.state in the system temp directory"`
+}
+
+type Config struct {
+	// Targets are the remote files to read, as [user@]host:/path or
+	// ssh://[user@]host[:port]/path
+	Targets []string
+	// SSH specific options
+	Options Options
+	// ReadFrom is where to start reading each file, as for
+	// --tail.read_from: beginning, end or last. Last carries on from the
+	// offset reached if the file's in the statefile and hasn't been
+	// rotated since, or starts at the end.
+	ReadFrom string
+	// Stop stops reading each file once its end is reached, rather than
+	// following it
+	Stop bool
+}
+
+// position is how far a remote file has been read
+type position struct {
+	Inode  string `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+// target is a remote file to read
+type target struct {
+	// name is the target as given, which keys its position in the
+	// statefile
+	name string
+	dest string
+	port string
+	path string
+}
+
+var (
+	// saveInterval is how often the statefile is written
+	saveInterval = 5 * time.Second
+	// retryWait is how long to wait before reconnecting after a session
+	// fails
+	retryWait = 10 * time.Second
+)
+
+// exitRotated is the status the remote script exits with when the file
+// has been rotated or truncated
+const exitRotated = 3
+
+// headerPrefix starts the line the remote script writes before the file's
+// contents, giving its inode and the offset reading starts from
+const headerPrefix = "honeytail-ssh "
+
+// parseTarget splits a target into the host to connect to and the path to
+// read there
+func parseTarget(name string) (target, error) {
+	t := target{name: name}
+	if strings.HasPrefix(name, "ssh://") {
+		u, err := url.Parse(name)
+		if err != nil {
+			return t, err
+		}
+		t.dest = u.Hostname()
+		if u.User != nil {
+			t.dest = u.User.Username() + "@" + t.dest
+		}
+		t.port = u.Port()
+		t.path = u.Path
+	} else if i := strings.Index(name, ":"); i > 0 {
+		t.dest, t.path = name[:i], name[i+1:]
+	}
+	if t.dest == "" || t.path == "" {
+		return t, fmt.Errorf("remote file %q should be of the form [user@]host:/path or ssh://[user@]host[:port]/path", name)
+	}
+	return t, nil
+}
+
+// reader reads the remote files in conf
+type reader struct {
+	conf  Config
+	abort <-chan struct{}
+
+	lock sync.Mutex
+	// positions holds the position reached in each file, and dirty whether
+	// they've changed since they were saved
+	positions map[string]position
+	dirty     bool
+}
+
+// GetSampledEntries wraps GetEntries and returns a list of channels that
+// provide sampled entries
+func GetSampledEntries(conf Config, sampleRate uint, abort <-chan struct{}) ([]chan string, error) {
+	linesChans, err := GetEntries(conf, abort)
+	if err != nil {
+		return nil, err
+	}
+	return tail.SampleAll(linesChans, sampleRate), nil
+}
+
+// GetEntries starts reading each of the remote files in conf, returning a
+// channel per file on which their lines are sent. The channels are closed
+// once abort is closed, or once the files have been read to their end if
+// conf.Stop is set.
+func GetEntries(conf Config, abort <-chan struct{}) ([]chan string, error) {
+	if len(conf.Targets) == 0 {
+		return nil, errors.New("no remote files to read")
+	}
+	if conf.Options.Command == "" {
+		conf.Options.Command = "ssh"
+	}
+	if conf.Options.CheckInterval == 0 {
+		conf.Options.CheckInterval = 5
+	}
+	if conf.Options.StateFile == "" {
+		conf.Options.StateFile = filepath.Join(os.TempDir(), "honeytail.ssh.state")
+	}
+	switch conf.ReadFrom {
+	case "", "beginning", "end", "last":
+	default:
+		return nil, fmt.Errorf("unknown option to --read_from: %s", conf.ReadFrom)
+	}
+	var targets []target
+	for _, name := range conf.Targets {
+		t, err := parseTarget(name)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	if _, err := exec.LookPath(conf.Options.Command); err != nil {
+		return nil, fmt.Errorf("can't find the ssh client to run: %v", err)
+	}
+
+	r := &reader{
+		conf:      conf,
+		abort:     abort,
+		positions: make(map[string]position),
+	}
+	// only the files read this time are kept in the statefile
+	saved := readState(conf.Options.StateFile)
+	for _, t := range targets {
+		if pos, ok := saved[t.name]; ok {
+			r.positions[t.name] = pos
+		}
+	}
+
+	var wg sync.WaitGroup
+	linesChans := make([]chan string, 0, len(targets))
+	for _, t := range targets {
+		lines := make(chan string)
+		linesChans = append(linesChans, lines)
+		wg.Add(1)
+		go func(t target) {
+			defer wg.Done()
+			defer close(lines)
+			r.follow(t, lines)
+			// saved before lines is closed, so it's done by the time
+			// honeytail stops
+			r.saveState()
+		}(t)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	go func() {
+		ticker := time.NewTicker(saveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.saveState()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return linesChans, nil
+}
+
+// follow reads t, reconnecting whenever the session ends until abort is
+// closed, or the end of the file is reached if conf.Stop is set
+func (r *reader) follow(t target, lines chan<- string) {
+	readFrom := r.conf.ReadFrom
+	if readFrom == "" {
+		readFrom = "last"
+	}
+	for {
+		if readFrom == "last" {
+			r.lock.Lock()
+			_, ok := r.positions[t.name]
+			r.lock.Unlock()
+			if !ok {
+				readFrom = "end"
+			}
+		}
+		err := r.session(t, readFrom, lines)
+		select {
+		case <-r.abort:
+			return
+		default:
+		}
+		// carry on from where this session got to
+		readFrom = "last"
+		if err == nil && r.conf.Stop {
+			return
+		}
+		if exitStatus(err) == exitRotated {
+			logrus.WithFields(logrus.Fields{
+				"file": t.name,
+			}).Info("remote file has been rotated, reading it again from the start")
+			continue
+		}
+		if err == nil {
+			err = errors.New("tail exited")
+		}
+		if r.conf.Stop {
+			logrus.WithFields(logrus.Fields{
+				"file": t.name,
+				"err":  err,
+			}).Warn("Failed to read remote file")
+			return
+		}
+		logrus.WithFields(logrus.Fields{
+			"file": t.name,
+			"err":  err,
+		}).Warn("Lost the ssh session reading remote file, reconnecting")
+		select {
+		case <-time.After(retryWait):
+		case <-r.abort:
+			return
+		}
+	}
+}
+
+// session runs tail on t's host, sending the lines read until the session
+// ends or abort is closed
+func (r *reader) session(t target, readFrom string, lines chan<- string) error {
+	r.lock.Lock()
+	pos := r.positions[t.name]
+	r.lock.Unlock()
+	cmd := exec.Command(r.conf.Options.Command, r.sshArgs(t, readFrom, pos)...)
+	stderr := &lastBytes{}
+	cmd.Stderr = stderr
+	// the remote script stops once stdin is closed
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-r.abort:
+			stdin.Close()
+			cmd.Process.Kill()
+		case <-finished:
+		}
+	}()
+
+	input := bufio.NewReader(stdout)
+	header, err := input.ReadString('\n')
+	if err == nil {
+		pos, err = parseHeader(header)
+	}
+	if err != nil {
+		// the remote script failed, or ssh couldn't connect
+		io.Copy(ioutil.Discard, stdout)
+		return sessionError(cmd.Wait(), stderr)
+	}
+	r.setPosition(t.name, pos)
+	logrus.WithFields(logrus.Fields{
+		"file":   t.name,
+		"offset": pos.Offset,
+	}).Debug("reading remote file")
+	for {
+		line, err := input.ReadString('\n')
+		if line != "" {
+			select {
+			case lines <- strings.TrimSpace(line):
+			case <-r.abort:
+				stdin.Close()
+				cmd.Process.Kill()
+				cmd.Wait()
+				return nil
+			}
+			pos.Offset += int64(len(line))
+			r.setPosition(t.name, pos)
+		}
+		if err != nil {
+			break
+		}
+	}
+	return sessionError(cmd.Wait(), stderr)
+}
+
+// sshArgs returns the arguments to run ssh with to read t from readFrom,
+// given the position reached before
+func (r *reader) sshArgs(t target, readFrom string, pos position) []string {
+	// never stop to ask for a password or passphrase
+	args := []string{"-T", "-o", "BatchMode=yes"}
+	if r.conf.Options.IdentityFile != "" {
+		args = append(args, "-i", r.conf.Options.IdentityFile)
+	}
+	if t.port != "" {
+		args = append(args, "-p", t.port)
+	}
+	for _, opt := range r.conf.Options.SSHOptions {
+		args = append(args, "-o", opt)
+	}
+	script := remoteScript(t.path, readFrom, pos, r.conf.Stop, r.conf.Options.CheckInterval)
+	// the login shell may not be a POSIX one
+	return append(args, t.dest, "sh -c "+shellQuote(script))
+}
+
+// remoteScript returns the shell script run on the remote host to read
+// path. It writes a header giving the file's inode and the offset reading
+// starts from, found from readFrom and the position reached before, then
+// the rest of the file. If following the file, it's checked for rotation
+// or truncation every interval seconds, and the script exits with
+// exitRotated when it is. tail is stopped when the script's stdin is
+// closed, as it is when ssh exits, so it doesn't linger until it next
+// writes.
+func remoteScript(path, readFrom string, pos position, stop bool, interval uint) string {
+	script := fmt.Sprintf(`f=%s
+set -- $(ls -di -- "$f")
+[ -n "$1" ] || exit 2
+ino=$1
+size=$(wc -c < "$f")
+case %s in
+beginning) start=0 ;;
+end) start=$size ;;
+*) if [ "$ino" = %s ] && [ "$size" -ge %d ]; then start=%d; else start=0; fi ;;
+esac
+echo "%s$ino $start"
+`, shellQuote(path), readFrom, shellQuote(pos.Inode), pos.Offset, pos.Offset, headerPrefix)
+	if stop {
+		return script + `exec tail -c +$((start + 1)) -- "$f"` + "\n"
+	}
+	return script + fmt.Sprintf(`tail -c +$((start + 1)) -f -- "$f" &
+t=$!
+# background jobs read from /dev/null unless told otherwise, so the
+# watcher reads the script's stdin through another descriptor
+exec 3<&0
+(cat <&3 > /dev/null 2>&1; kill $t 2>/dev/null) &
+w=$!
+last=$size
+while kill -0 $t 2>/dev/null; do
+	sleep %d
+	set -- $(ls -di -- "$f" 2>/dev/null)
+	now=$(wc -c < "$f" 2>/dev/null)
+	if [ "$1" != "$ino" ] || [ "${now:-0}" -lt "$last" ]; then
+		# let tail finish reading the rotated file
+		sleep 1
+		kill $t $w
+		exit %d
+	fi
+	last=$now
+done
+kill $w 2>/dev/null
+exit 1
+`, interval, exitRotated)
+}
+
+// parseHeader reads the inode and starting offset from the line the
+// remote script writes before the file's contents
+func parseHeader(header string) (position, error) {
+	fields := strings.Fields(strings.TrimPrefix(header, headerPrefix))
+	if !strings.HasPrefix(header, headerPrefix) || len(fields) != 2 {
+		return position{}, fmt.Errorf("unexpected output from the remote host: %q", header)
+	}
+	offset, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return position{}, err
+	}
+	return position{Inode: fields[0], Offset: offset}, nil
+}
+
+// sessionError adds what ssh wrote to stderr to err
+func sessionError(err error, stderr *lastBytes) error {
+	if err == nil {
+		return nil
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return &exitError{err: err, msg: msg}
+	}
+	return err
+}
+
+// exitError is the error from a session that failed, with what it wrote to
+// stderr
+type exitError struct {
+	err error
+	msg string
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error() + ": " + e.msg
+}
+
+// exitStatus returns the status a session exited with, or -1 if it didn't
+// exit
+func exitStatus(err error) int {
+	if e, ok := err.(*exitError); ok {
+		err = e.err
+	}
+	if e, ok := err.(*exec.ExitError); ok {
+		if status, ok := e.Sys().(syscall.WaitStatus); ok && status.Exited() {
+			return status.ExitStatus()
+		}
+	}
+	return -1
+}
+
+// shellQuote quotes s for the shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// lastBytes keeps the last of what's written to it, to report why a
+// session failed
+type lastBytes struct {
+	lock sync.Mutex
+	buf  []byte
+}
+
+// maxStderr is how much of a session's stderr is kept
+const maxStderr = 1024
+
+func (b *lastBytes) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > maxStderr {
+		b.buf = b.buf[len(b.buf)-maxStderr:]
+	}
+	return len(p), nil
+}
+
+func (b *lastBytes) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return string(b.buf)
+}
+
+// setPosition records the position reached in the file named name
+func (r *reader) setPosition(name string, pos position) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.positions[name] = pos
+	r.dirty = true
+}
+
+// readState reads the positions reached in each file from the statefile
+func readState(path string) map[string]position {
+	state := make(map[string]position)
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.WithFields(logrus.Fields{
+				"file": path,
+				"err":  err,
+			}).Warn("Failed to read the SSH statefile")
+		}
+		return state
+	}
+	if err := json.Unmarshal(contents, &state); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"file": path,
+			"err":  err,
+		}).Warn("Failed to read the SSH statefile")
+	}
+	return state
+}
+
+// saveState writes the positions reached in each file to the statefile, if
+// they've changed
+func (r *reader) saveState() {
+	r.lock.Lock()
+	if !r.dirty {
+		r.lock.Unlock()
+		return
+	}
+	contents, err := json.Marshal(r.positions)
+	r.dirty = false
+	r.lock.Unlock()
+	if err == nil {
+		err = atomicfile.Write(r.conf.Options.StateFile, contents)
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"file": r.conf.Options.StateFile,
+			"err":  err,
+		}).Warn("Failed to save the SSH statefile")
+	}
+}
@@ -0,0 +1,219 @@
+package sshtail
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// fakeSSH writes a script standing in for ssh, which runs the remote
+// command locally after noting its arguments in args. The remote command
+// finds a sleep standing in for the real one, which waits for tick rather
+// than for time to pass, so each check for rotation happens when a test
+// says.
+func fakeSSH(t *testing.T, dir string) string {
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(filepath.Join(dir, "tick"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sleep := fmt.Sprintf(`#!/bin/sh
+exec > /dev/null 2>&1
+: > %s
+`, shellQuote(filepath.Join(dir, "tick")))
+	if err := ioutil.WriteFile(filepath.Join(bin, "sleep"), []byte(sleep), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "ssh")
+	script := fmt.Sprintf(`#!/bin/sh
+echo "$@" >> %s
+PATH=%s:$PATH
+for last; do :; done
+eval "exec $last"
+`, shellQuote(filepath.Join(dir, "args")), shellQuote(bin))
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// tick ends the sleep the remote command in dir is waiting in, or the next
+// one it starts. Each sleep opens the tick pipe to write to it, which waits
+// for it to be opened to read, so that one tick ends one sleep.
+func tick(t *testing.T, dir string) {
+	done := make(chan error, 1)
+	go func() {
+		f, err := os.Open(filepath.Join(dir, "tick"))
+		if err == nil {
+			_, err = ioutil.ReadAll(f)
+			f.Close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the remote command to sleep")
+	}
+}
+
+// releaseSleeps ends any sleep left waiting for a tick in dir once the
+// remote command's gone
+func releaseSleeps(dir string) {
+	// opening without blocking doesn't wait for a sleep to be waiting
+	if f, err := os.OpenFile(filepath.Join(dir, "tick"), os.O_RDONLY|syscall.O_NONBLOCK, 0); err == nil {
+		f.Close()
+	}
+}
+
+func setup(t *testing.T) (string, Options) {
+	logrus.SetOutput(ioutil.Discard)
+	dir, err := ioutil.TempDir("", "sshtail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, Options{
+		Command:       fakeSSH(t, dir),
+		IdentityFile:  "/keys/appliance",
+		SSHOptions:    []string{"StrictHostKeyChecking=yes"},
+		CheckInterval: 1,
+		StateFile:     filepath.Join(dir, "state"),
+	}
+}
+
+func readLines(t *testing.T, lines chan string, n int) []string {
+	var got []string
+	for len(got) < n {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return got
+			}
+			got = append(got, line)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out after reading %q", got)
+		}
+	}
+	return got
+}
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected target
+	}{
+		{"admin@fw1:/var/log/messages", target{dest: "admin@fw1", path: "/var/log/messages"}},
+		{"ssh://admin@fw1:2222/var/log/messages", target{dest: "admin@fw1", port: "2222", path: "/var/log/messages"}},
+		{"fw1:app.log", target{dest: "fw1", path: "app.log"}},
+	}
+	for _, tt := range tests {
+		tt.expected.name = tt.name
+		got, err := parseTarget(tt.name)
+		if err != nil || got != tt.expected {
+			t.Errorf("parseTarget(%q) = %+v, %v", tt.name, got, err)
+		}
+	}
+	for _, bad := range []string{"/var/log/messages", "fw1:", "ssh://fw1"} {
+		if _, err := parseTarget(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestGetEntries(t *testing.T) {
+	dir, opts := setup(t)
+	defer os.RemoveAll(dir)
+	logfile := filepath.Join(dir, "it's.log")
+	ioutil.WriteFile(logfile, []byte("first\r\nsecond\n"), 0644)
+	target := "admin@fw1:" + logfile
+
+	read := func(readFrom string) []string {
+		chans, err := GetEntries(Config{
+			Targets:  []string{target},
+			Options:  opts,
+			ReadFrom: readFrom,
+			Stop:     true,
+		}, make(chan struct{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return readLines(t, chans[0], 10)
+	}
+	if got := read("beginning"); !reflect.DeepEqual(got, []string{"first", "second"}) {
+		t.Errorf("got lines %q", got)
+	}
+	args, _ := ioutil.ReadFile(filepath.Join(dir, "args"))
+	if !strings.HasPrefix(string(args), "-T -o BatchMode=yes -i /keys/appliance -o StrictHostKeyChecking=yes admin@fw1 sh -c") {
+		t.Errorf("ran ssh with %s", args)
+	}
+	state := readState(opts.StateFile)
+	if state[target].Offset != 14 || state[target].Inode == "" {
+		t.Errorf("got state %+v, expected the end of the file", state)
+	}
+
+	// carry on from there
+	f, _ := os.OpenFile(logfile, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString("third\n")
+	f.Close()
+	if got := read("last"); !reflect.DeepEqual(got, []string{"third"}) {
+		t.Errorf("got lines %q, expected those written since", got)
+	}
+	if got := read("end"); len(got) != 0 {
+		t.Errorf("got lines %q reading from the end", got)
+	}
+}
+
+func TestFollowRotation(t *testing.T) {
+	dir, opts := setup(t)
+	defer os.RemoveAll(dir)
+	logfile := filepath.Join(dir, "app.log")
+	ioutil.WriteFile(logfile, []byte("old\n"), 0644)
+
+	abort := make(chan struct{})
+	chans, err := GetEntries(Config{
+		Targets:  []string{"fw1:" + logfile},
+		Options:  opts,
+		ReadFrom: "beginning",
+	}, abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readLines(t, chans[0], 1); !reflect.DeepEqual(got, []string{"old"}) {
+		t.Errorf("got lines %q", got)
+	}
+	os.Rename(logfile, logfile+".1")
+	ioutil.WriteFile(logfile, []byte("new\n"), 0644)
+	// the check that notices the rotation, then the wait for tail to finish
+	// reading the rotated file
+	tick(t, dir)
+	tick(t, dir)
+	if got := readLines(t, chans[0], 1); !reflect.DeepEqual(got, []string{"new"}) {
+		t.Errorf("got lines %q after rotation", got)
+	}
+	close(abort)
+	select {
+	case _, ok := <-chans[0]:
+		if ok {
+			t.Error("got another line")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("lines not closed after aborting")
+	}
+	releaseSleeps(dir)
+	if state := readState(opts.StateFile); state["fw1:"+logfile].Offset != 4 {
+		t.Errorf("got state %+v, expected the end of the new file", state)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/honeycombio/honeytail/maintenance"
+	"github.com/honeycombio/honeytail/tail"
+)
+
+// sampleReadSize is how much of the end of each file is read to find the
+// sample lines for a support bundle
+const sampleReadSize = 64 * 1024
+
+// urlPassword matches the password in a URL, eg in --poll
+var urlPassword = regexp.MustCompile(`(://[^:/@\s]*:)[^@\s]*@`)
+
+// recentLogs remembers the last few log entries, so a running honeytail can
+// include them in support bundles
+type recentLogs struct {
+	lock    sync.Mutex
+	entries []string
+	max     int
+}
+
+func newRecentLogs(max int) *recentLogs {
+	return &recentLogs{max: max}
+}
+
+func (r *recentLogs) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (r *recentLogs) Fire(entry *logrus.Entry) error {
+	line, err := entry.String()
+	if err != nil {
+		return err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.entries = append(r.entries, line)
+	if len(r.entries) > r.max {
+		r.entries = r.entries[len(r.entries)-r.max:]
+	}
+	return nil
+}
+
+func (r *recentLogs) String() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return strings.Join(r.entries, "")
+}
+
+// snapshot returns the counts of events sent so far, as JSON
+func (r *responseStats) snapshot() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	statusCodes := make(map[string]int)
+	for code, count := range r.totalStatusCodes {
+		statusCodes[fmt.Sprint(code)] += count
+	}
+	for code, count := range r.statusCodes {
+		statusCodes[fmt.Sprint(code)] += count
+	}
+	out, _ := json.MarshalIndent(map[string]interface{}{
+		"lifetime_count":            r.totalCount + r.count,
+		"lifetime_count_per_status": statusCodes,
+		"count":                     r.count,
+		"errors":                    r.errors,
+		"response_bodies":           r.bodies,
+	}, "", "  ")
+	return string(out)
+}
+
+// scrubConfig hides the secrets configINI doesn't know about: passwords in
+// URLs
+func scrubConfig(ini string) string {
+	return urlPassword.ReplaceAllString(ini, "${1}*@")
+}
+
+// writeSupportBundle writes a gzipped tarball to path describing this
+// honeytail and its inputs, for attaching to bug reports: the version, the
+// config with secrets hidden, the statefile of each file tailed and its last
+// few lines, and, if one is listening on --control_socket, the running
+// honeytail's config, recent logs and counts of events sent.
+func writeSupportBundle(path string, options GlobalOptions, config string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	write := func(name, contents string) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    "honeytail-support/" + name,
+			Mode:    0600,
+			Size:    int64(len(contents)),
+			ModTime: now,
+		}); err != nil {
+			return err
+		}
+		_, err := io.WriteString(tw, contents)
+		return err
+	}
+
+	files := map[string]string{
+		"version.txt": fmt.Sprintf("honeytail %s\ngo %s %s/%s\ncollected %s\n",
+			version, runtime.Version(), runtime.GOOS, runtime.GOARCH, now.UTC().Format(time.RFC3339)),
+		"config.ini": scrubConfig(config),
+	}
+	if options.ControlSocket != "" {
+		for name, cmd := range map[string]string{
+			"running/config.ini": "config",
+			"running/logs.txt":   "logs",
+			"running/stats.json": "stats",
+		} {
+			reply, err := maintenance.Send(options.ControlSocket, cmd)
+			if err != nil {
+				reply = "error: " + err.Error() + "\n"
+			}
+			if name == "running/config.ini" {
+				reply = scrubConfig(reply)
+			}
+			files[name] = reply
+		}
+	}
+	stateFiles, err := tail.StateFiles(tail.Config{
+		Paths:     options.Reqs.LogFiles,
+		Dirs:      options.Reqs.Dirs,
+		Recursive: options.Recursive,
+		Exclude:   options.Exclude,
+		Options:   options.Tail,
+	})
+	if err != nil {
+		files["inputs/error.txt"] = err.Error() + "\n"
+	}
+	for file, stateFile := range stateFiles {
+		name := "inputs/" + strings.TrimPrefix(filepath.ToSlash(file), "/")
+		state, err := ioutil.ReadFile(stateFile)
+		if err != nil {
+			state = []byte("error: " + err.Error() + "\n")
+		}
+		files[name+".state"] = stateFile + "\n" + string(state)
+		if options.SupportBundleLines > 0 {
+			lines, err := lastLines(file, int(options.SupportBundleLines))
+			if err != nil {
+				lines = "error: " + err.Error() + "\n"
+			}
+			files[name+".sample"] = lines
+		}
+	}
+	// write the files in a stable order
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := write(name, files[name]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// lastLines returns the last n lines of the file at path
+func lastLines(path string, n int) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	offset := info.Size() - sampleReadSize
+	if offset < 0 {
+		offset = 0
+	}
+	buf := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(buf, offset); err != nil && err != io.EOF {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimRight(string(buf), "\n"), "\n")
+	if offset > 0 && len(lines) > 1 {
+		// the first line is probably cut short
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
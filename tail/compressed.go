@@ -0,0 +1,213 @@
+package tail
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hpcloud/tail"
+)
+
+// magic numbers at the start of compressed files
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+)
+
+// detectCompression returns "gzip" or "bzip2" if file is compressed, going
+// by its first bytes rather than its name, or "" if it's not
+func detectCompression(file string) (string, error) {
+	fh, err := tail.OpenFile(file)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+	head := make([]byte, 3)
+	n, err := io.ReadFull(fh, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	head = head[:n]
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return "gzip", nil
+	case bytes.HasPrefix(head, bzip2Magic):
+		return "bzip2", nil
+	}
+	return "", nil
+}
+
+// compressedStartOffset returns the offset in the decompressed contents of
+// file to carry on reading from: the one in the statefile if it was saved
+// for this file, 0 if it was saved for another, or -1 to read nothing if
+// there's no statefile. The offset can't be compared with the file's size,
+// so the checksum of its start tells if it's been replaced.
+func compressedStartOffset(stateFile string, file string) int64 {
+	state, err := readState(stateFile)
+	if err != nil {
+		return -1
+	}
+	id, _, err := statID(file)
+	if err != nil {
+		return -1
+	}
+	if !state.matches(id) || !state.sameStart(file) {
+		return 0
+	}
+	return state.Offset
+}
+
+// decompress wraps r in a reader of the given compression
+func decompress(r io.Reader, compression string) (io.Reader, error) {
+	switch compression {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "bzip2":
+		return bzip2.NewReader(r), nil
+	}
+	return nil, fmt.Errorf("unknown compression %s", compression)
+}
+
+// tailCompressed reads a compressed file, such as a rotated log, from start
+// to end. It's never followed, as compressed files aren't written to once
+// they've been rotated. The offset kept in the statefile counts
+// decompressed bytes, as that's all that can be skipped to when reading
+// from the last position.
+func tailCompressed(conf Config, file string, compression string, stateFile string, d *delimiter, abort <-chan struct{}) (chan string, error) {
+	var offset int64
+	switch conf.Options.ReadFrom {
+	case "start", "beginning":
+	case "end":
+		// there's nothing more to come
+		offset = -1
+	case "last":
+		offset = compressedStartOffset(stateFile, file)
+	default:
+		return nil, fmt.Errorf("unknown option to --read_from: %s", conf.Options.ReadFrom)
+	}
+	fh, err := tail.OpenFile(file)
+	if err != nil {
+		return nil, err
+	}
+	r, err := decompress(fh, compression)
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+
+	stateFh, err := os.OpenFile(stateFile, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"logfile":   file,
+			"statefile": stateFile,
+		}).Warn("Failed to open statefile for writing. File location will not be saved.")
+	}
+	id, size, _ := statID(file)
+	state := State{INode: id.ino, Device: id.dev}
+	// the checksum covers the compressed bytes, which are all there is
+	if size > checksumSize {
+		size = checksumSize
+	}
+	if sum, err := checksumFile(file, size); err == nil {
+		state.Checksum, state.ChecksumLength = sum, size
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		defer fh.Close()
+		defer stateFh.Close()
+		if offset < 0 {
+			return
+		}
+		logrus.WithFields(logrus.Fields{
+			"logfile":     file,
+			"compression": compression,
+			"offset":      offset,
+		}).Debug("reading compressed file")
+		if _, err := io.CopyN(ioutil.Discard, r, offset); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"logfile": file,
+				"err":     err,
+			}).Warn("Failed to skip to the last position read in compressed file")
+			return
+		}
+		state.Offset = offset
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		if d != nil {
+			rr := d.reader(r)
+		ReadRecords:
+			for {
+				record, err := rr.Next()
+				if err == io.EOF {
+					record = rr.Rest()
+				} else if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"logfile": file,
+						"err":     err,
+					}).Warn("Failed to read compressed file")
+					break
+				}
+				if record != "" {
+					select {
+					case lines <- record:
+					case <-abort:
+						break ReadRecords
+					}
+				}
+				state.Offset = offset + rr.Offset()
+				if err != nil {
+					break
+				}
+				select {
+				case <-ticker.C:
+					writeState(&state, stateFh)
+				default:
+				}
+			}
+			writeState(&state, stateFh)
+			return
+		}
+		input := bufio.NewReader(r)
+	ReadLines:
+		for {
+			line, err := input.ReadString('\n')
+			if line != "" {
+				select {
+				case lines <- strings.TrimSpace(line):
+					state.Offset += int64(len(line))
+				case <-abort:
+					break ReadLines
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					logrus.WithFields(logrus.Fields{
+						"logfile": file,
+						"err":     err,
+					}).Warn("Failed to read compressed file")
+				}
+				break
+			}
+			select {
+			case <-ticker.C:
+				writeState(&state, stateFh)
+			default:
+			}
+		}
+		writeState(&state, stateFh)
+	}()
+	if offset > 0 && conf.HeaderPrefix != "" {
+		return withHeader(readHeader(file, compression, conf.HeaderPrefix), lines, abort), nil
+	}
+	return lines, nil
+}
@@ -0,0 +1,189 @@
+package tail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// listDir returns the files in dir, and in its subdirectories if recursive
+// is set, leaving out those matching one of the exclude patterns.
+// Subdirectories matching a pattern are skipped entirely. Symlinks are
+// followed to files, as in /var/log/containers, but not to directories.
+func listDir(dir string, recursive bool, exclude []string) ([]string, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	var files []string
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// carry on with the rest of the tree
+			logrus.WithFields(logrus.Fields{
+				"path": path,
+				"err":  err,
+			}).Debug("skipping path that can't be read")
+			return nil
+		}
+		if info.IsDir() {
+			if path == dir {
+				return nil
+			}
+			if !recursive || excluded(path, exclude) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if info, err = os.Stat(path); err != nil {
+				return nil
+			}
+		}
+		if info.Mode().IsRegular() && !excluded(path, exclude) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// excluded returns true if path matches one of the exclude patterns.
+// Patterns with a path separator are matched against the whole path, others
+// against its last element.
+func excluded(path string, exclude []string) bool {
+	for _, pattern := range exclude {
+		name := filepath.Base(path)
+		if strings.ContainsRune(pattern, filepath.Separator) {
+			name = path
+		}
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// removeExcluded removes the files matching one of the exclude patterns
+func removeExcluded(files []string, exclude []string) []string {
+	if len(exclude) == 0 {
+		return files
+	}
+	kept := files[:0]
+	for _, file := range files {
+		if excluded(file, exclude) {
+			logrus.WithFields(logrus.Fields{
+				"file": file,
+			}).Debug("skipping tailing file because it matches an exclude pattern")
+			continue
+		}
+		kept = append(kept, file)
+	}
+	return kept
+}
+
+// skipped returns true if file is a regular file that hasn't been modified
+// within conf.IgnoreOlder, or is bigger than conf.MaxFileSize, so as not
+// to read old archives a glob or directory happens to include. Files named
+// outright are never skipped.
+func skipped(file string, conf Config) bool {
+	if conf.IgnoreOlder == 0 && conf.MaxFileSize == 0 {
+		return false
+	}
+	for _, path := range conf.Paths {
+		if path == file {
+			return false
+		}
+	}
+	info, err := os.Stat(file)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	if conf.IgnoreOlder != 0 && time.Since(info.ModTime()) > conf.IgnoreOlder {
+		logrus.WithFields(logrus.Fields{
+			"file":     file,
+			"modified": info.ModTime(),
+		}).Debug("skipping tailing file because it hasn't been modified recently")
+		return true
+	}
+	if conf.MaxFileSize != 0 && info.Size() > conf.MaxFileSize {
+		logrus.WithFields(logrus.Fields{
+			"file": file,
+			"size": info.Size(),
+		}).Debug("skipping tailing file because it's too big")
+		return true
+	}
+	return false
+}
+
+// removeSkipped removes the files skipped for their age or size
+func removeSkipped(files []string, conf Config) []string {
+	if conf.IgnoreOlder == 0 && conf.MaxFileSize == 0 {
+		return files
+	}
+	var kept []string
+	for _, file := range files {
+		if !skipped(file, conf) {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
+
+// inDirs returns true if file was found in one of conf's directories
+func inDirs(conf Config, file string) bool {
+	for _, dir := range conf.Dirs {
+		rel, err := filepath.Rel(dir, file)
+		if err == nil && !strings.HasPrefix(rel, "..") {
+			return true
+		}
+	}
+	return false
+}
+
+// stateFileFor returns the statefile for file. Files in a directory are
+// often named alike, so their statefiles are named after their whole path.
+func stateFileFor(conf Config, file string, numFiles int) string {
+	if !inDirs(conf, file) {
+		return getStateFile(conf, file, numFiles)
+	}
+	file = filepath.Clean(file)
+	vol := filepath.VolumeName(file)
+	name := strings.TrimPrefix(filepath.ToSlash(file[len(vol):]), "/")
+	if vol != "" {
+		// a drive such as C: or a share such as \\server\share on Windows
+		vol = strings.Trim(strings.Replace(filepath.ToSlash(vol), ":", "", -1), "/")
+		name = vol + "/" + name
+	}
+	name = strings.Replace(name, "/", "_", -1)
+	// counted as one of several files, as files in a directory never take
+	// over a statefile given for a single file
+	return getStateFile(conf, name, 2)
+}
+
+// localPaths converts the paths, directories and exclude patterns in conf
+// to use the platform's separator, so that on Windows, where they may be
+// given with either / or \, they match the paths found
+func localPaths(conf Config) Config {
+	conf.Paths = fromSlash(conf.Paths)
+	conf.Dirs = fromSlash(conf.Dirs)
+	conf.Exclude = fromSlash(conf.Exclude)
+	return conf
+}
+
+func fromSlash(paths []string) []string {
+	if paths == nil {
+		return nil
+	}
+	converted := make([]string, len(paths))
+	for i, path := range paths {
+		converted[i] = filepath.FromSlash(path)
+	}
+	return converted
+}
@@ -0,0 +1,116 @@
+package tail
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// isFIFO returns whether file is a named pipe
+func isFIFO(file string) bool {
+	info, err := os.Stat(file)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// tailFIFO reads lines from the named pipe file, or records if d is set.
+// When the writer closes it
+// the pipe is reopened, waiting for the next writer, unless stop is set.
+// There's no position to keep in a pipe, so it has no statefile.
+func tailFIFO(file string, stop bool, d *delimiter, abort <-chan struct{}) chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		for {
+			fh, err := openFIFO(file, abort)
+			select {
+			case <-abort:
+				if err == nil {
+					fh.Close()
+				}
+				return
+			default:
+			}
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"file": file,
+					"err":  err,
+				}).Warn("failed to open named pipe")
+				return
+			}
+			eof := readFIFO(fh, d, lines, abort)
+			fh.Close()
+			if !eof || stop {
+				return
+			}
+			logrus.WithFields(logrus.Fields{
+				"file": file,
+			}).Debug("named pipe closed by its writer, reopening it")
+		}
+	}()
+	return lines
+}
+
+// openFIFO opens file for reading, which blocks until something opens it
+// for writing. If abort is closed first, the pipe is opened for writing
+// here to let the open return.
+func openFIFO(file string, abort <-chan struct{}) (*os.File, error) {
+	opened := make(chan struct{})
+	defer close(opened)
+	go func() {
+		select {
+		case <-abort:
+		case <-opened:
+			return
+		}
+		for {
+			// fails until our open is waiting for a writer
+			if w, err := os.OpenFile(file, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+				w.Close()
+			}
+			select {
+			case <-opened:
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}()
+	return os.Open(file)
+}
+
+// readFIFO sends the lines, or records, read from fh until the writer
+// closes it, when it returns true, or abort is closed.
+func readFIFO(fh *os.File, d *delimiter, lines chan<- string, abort <-chan struct{}) bool {
+	// closing fh stops a read waiting for the writer
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-abort:
+			fh.Close()
+		case <-done:
+		}
+	}()
+	if d != nil {
+		ok, err := sendRecords(d.reader(fh), lines, abort)
+		return ok && err == nil
+	}
+	input := bufio.NewReader(fh)
+	for {
+		line, err := input.ReadString('\n')
+		if line != "" {
+			select {
+			case lines <- strings.TrimSpace(line):
+			case <-abort:
+				return false
+			}
+		}
+		if err != nil {
+			return err == io.EOF
+		}
+	}
+}
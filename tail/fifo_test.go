@@ -0,0 +1,71 @@
+//go:build !windows
+// +build !windows
+
+package tail
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestTailFIFO(t *testing.T) {
+	ts := &testSetup{}
+	ts.start(t)
+	defer ts.stop()
+
+	fifo := ts.tmpdir + "/pipe"
+	if err := syscall.Mkfifo(fifo, 0600); err != nil {
+		t.Fatal(err)
+	}
+	conf := Config{
+		Options: TailOptions{ReadFrom: "last"},
+		Paths:   []string{fifo},
+	}
+	stateFiles, err := StateFiles(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stateFiles) != 0 {
+		t.Errorf("got statefiles %v for a named pipe", stateFiles)
+	}
+	lineChans, err := GetEntries(conf, ts.abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each writer closing the pipe is followed by the next
+	for i := 0; i < 2; i++ {
+		w, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(w, "{\"writer\":%d}\n", i)
+		checkLine(t, lineChans[0], fmt.Sprintf("{\"writer\":%d}", i))
+		w.Close()
+	}
+	close(ts.abort)
+	checkLinesChanClosed(t, lineChans[0])
+	if _, err := os.Stat(getStateFile(conf, fifo, 1)); !os.IsNotExist(err) {
+		t.Error("expected no statefile to be written for a named pipe")
+	}
+}
+
+func TestTailFIFOStop(t *testing.T) {
+	ts := &testSetup{}
+	ts.start(t)
+	defer ts.stop()
+
+	fifo := ts.tmpdir + "/pipe"
+	if err := syscall.Mkfifo(fifo, 0600); err != nil {
+		t.Fatal(err)
+	}
+	lines := tailFIFO(fifo, true, nil, ts.abort)
+	w, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(w, "{\"a\":1}\n{\"b\":2}")
+	w.Close()
+	checkLinesChan(t, lines, []string{"{\"a\":1}", "{\"b\":2}"})
+}
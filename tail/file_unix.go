@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package tail
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// statID returns the ID and size of the file at path
+func statID(path string) (fileID, int64, error) {
+	st := unix.Stat_t{}
+	if err := unix.Stat(path, &st); err != nil {
+		return fileID{}, 0, err
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, st.Size, nil
+}
+
+// isLocked returns true if err is from opening a file another process
+// holds exclusively, which only happens on Windows
+func isLocked(err error) bool {
+	return false
+}
@@ -0,0 +1,51 @@
+//go:build windows
+// +build windows
+
+package tail
+
+import (
+	"os"
+	"syscall"
+)
+
+// errors opening a file another process holds without sharing it
+const (
+	errSharingViolation syscall.Errno = 32
+	errLockViolation    syscall.Errno = 33
+)
+
+// statID returns the ID and size of the file at path. NTFS keeps a file's
+// index when it's renamed, as other filesystems keep its inode.
+func statID(path string) (fileID, int64, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return fileID{}, 0, err
+	}
+	// opened without access to the contents, and shared so as not to stop
+	// the file being written, renamed or deleted meanwhile
+	h, err := syscall.CreateFile(name, 0,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return fileID{}, 0, &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	defer syscall.CloseHandle(h)
+	var info syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(h, &info); err != nil {
+		return fileID{}, 0, &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	id := fileID{
+		dev: uint64(info.VolumeSerialNumber),
+		ino: uint64(info.FileIndexHigh)<<32 | uint64(info.FileIndexLow),
+	}
+	return id, int64(info.FileSizeHigh)<<32 | int64(info.FileSizeLow), nil
+}
+
+// isLocked returns true if err is from opening a file another process
+// holds exclusively, as some Windows programs do while writing logs
+func isLocked(err error) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		err = pathErr.Err
+	}
+	return err == errSharingViolation || err == errLockViolation
+}
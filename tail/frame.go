@@ -0,0 +1,113 @@
+package tail
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// maxFrame is the longest length-prefixed record accepted from STDIN
+const maxFrame = 64 * 1024 * 1024
+
+// frameReader reads the next record from r, returning io.EOF once there
+// are none left
+type frameReader func(r *bufio.Reader) (string, error)
+
+// getFrameReader returns the reader for records on STDIN framed as frame:
+// line (the default) for newline-delimited lines, null for NUL-delimited
+// records such as find -print0 writes, or varint for records each preceded
+// by their length as an unsigned varint
+func getFrameReader(frame string) (frameReader, error) {
+	switch frame {
+	case "", "line":
+		return readLine, nil
+	case "null":
+		return readNullFramed, nil
+	case "varint":
+		return readVarintFramed, nil
+	}
+	return nil, fmt.Errorf("unknown --tail.frame %q, expected line, null or varint", frame)
+}
+
+// readLine reads a newline-delimited line, however long
+func readLine(r *bufio.Reader) (string, error) {
+	line, partialLine, err := r.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	parts := []string{string(line)}
+	for partialLine {
+		line, partialLine, _ = r.ReadLine()
+		parts = append(parts, string(line))
+	}
+	return strings.Join(parts, ""), nil
+}
+
+// readNullFramed reads a record ended by a NUL byte, or by the end of the
+// input. Newlines in the record are kept.
+func readNullFramed(r *bufio.Reader) (string, error) {
+	record, err := r.ReadString(0)
+	if err == io.EOF && record != "" {
+		// the last record needn't end with a NUL
+		err = nil
+	}
+	return strings.TrimSuffix(record, "\x00"), err
+}
+
+// readVarintFramed reads a record preceded by its length in bytes, encoded
+// as an unsigned varint
+func readVarintFramed(r *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > maxFrame {
+		return "", fmt.Errorf("record length %d is more than the %d allowed", n, maxFrame)
+	}
+	record := make([]byte, n)
+	if _, err := io.ReadFull(r, record); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return string(record), nil
+}
+
+// readFramed sends each record read from input with read until the input
+// ends or abort is closed
+func readFramed(input io.Reader, read frameReader, abort <-chan struct{}) chan string {
+	lines := make(chan string)
+	r := bufio.NewReader(input)
+	go func() {
+		defer close(lines)
+		for {
+			// check for signal triggered exit
+			select {
+			case <-abort:
+				return
+			default:
+			}
+			record, err := read(r)
+			if err != nil {
+				if err == io.EOF {
+					logrus.Debug("stdin is closed")
+				} else {
+					logrus.WithError(err).Warn("failed to read a record from stdin, no longer reading it")
+				}
+				// bail when STDIN closes
+				return
+			}
+			select {
+			case lines <- record:
+			case <-abort:
+				return
+			}
+		}
+	}()
+	return lines
+}
@@ -0,0 +1,64 @@
+package tail
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/hpcloud/tail"
+)
+
+// maxHeaderLines is the most leading lines of a file kept as its header
+const maxHeaderLines = 1000
+
+// readHeader returns the lines at the start of file that begin with prefix,
+// up to the first that doesn't. Compressed files are decompressed first.
+func readHeader(file string, compression string, prefix string) []string {
+	fh, err := tail.OpenFile(file)
+	if err != nil {
+		return nil
+	}
+	defer fh.Close()
+	var r io.Reader = fh
+	if compression != "" {
+		if r, err = decompress(fh, compression); err != nil {
+			return nil
+		}
+	}
+	var header []string
+	input := bufio.NewReader(r)
+	for len(header) < maxHeaderLines {
+		line, err := input.ReadString('\n')
+		if !strings.HasPrefix(line, prefix) || (err != nil && err != io.EOF) {
+			break
+		}
+		header = append(header, strings.TrimSpace(line))
+		if err != nil {
+			break
+		}
+	}
+	return header
+}
+
+// withHeader sends the header lines before those from lines
+func withHeader(header []string, lines chan string, abort <-chan struct{}) chan string {
+	if len(header) == 0 {
+		return lines
+	}
+	headed := make(chan string)
+	go func() {
+		defer close(headed)
+	SendHeader:
+		for _, line := range header {
+			select {
+			case headed <- line:
+			case <-abort:
+				break SendHeader
+			}
+		}
+		for line := range lines {
+			headed <- line
+		}
+	}()
+	return headed
+}
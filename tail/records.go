@@ -0,0 +1,338 @@
+package tail
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hpcloud/tail"
+	"gopkg.in/fsnotify.v1"
+
+	"github.com/honeycombio/honeytail/records"
+)
+
+// pollInterval is how often a file whose records are delimited is checked
+// for changes when polling
+const pollInterval = 250 * time.Millisecond
+
+// delimiter says how to split records that aren't one per line
+type delimiter struct {
+	split   bufio.SplitFunc
+	maxSize int
+}
+
+// getDelimiter builds a delimiter from the delimiter options, returning
+// nil if records are newline delimited as usual
+func getDelimiter(opts TailOptions) (*delimiter, error) {
+	split, err := records.SplitFunc("tail.record", opts.RecordDelimiter, opts.RecordDelimiterRegex)
+	if err != nil || split == nil {
+		return nil, err
+	}
+	if opts.Frame != "" && opts.Frame != "line" {
+		return nil, errors.New("--tail.frame may not be combined with --tail.record_delimiter or --tail.record_delimiter_regex")
+	}
+	return &delimiter{split: split, maxSize: int(opts.RecordMaxSize)}, nil
+}
+
+// reader reads the records in r
+func (d *delimiter) reader(r io.Reader) *records.Reader {
+	return records.NewReader(r, d.split, d.maxSize)
+}
+
+// sendRecords sends the records read by rr until the end of its input,
+// including the last record even if there's no delimiter after it. It
+// returns false if abort was closed first, along with any error reading
+// other than reaching the end.
+func sendRecords(rr *records.Reader, lines chan<- string, abort <-chan struct{}) (bool, error) {
+	for {
+		record, err := rr.Next()
+		if err == io.EOF {
+			record = rr.Rest()
+			if record == "" {
+				return true, nil
+			}
+		} else if err != nil {
+			return true, err
+		}
+		select {
+		case lines <- record:
+		case <-abort:
+			return false, nil
+		}
+		if err == io.EOF {
+			return true, nil
+		}
+	}
+}
+
+// readRecords sends the records read from input until it ends or abort is
+// closed
+func readRecords(input io.Reader, d *delimiter, abort <-chan struct{}) chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		if _, err := sendRecords(d.reader(input), lines, abort); err != nil {
+			logrus.WithError(err).Warn("failed to read a record from stdin, no longer reading it")
+		}
+	}()
+	return lines
+}
+
+// tailDelimited follows file as tailFile does, but sends the records in it
+// separated by d rather than its lines. It reads the file itself rather
+// than with a tailer, which only passes on what's read once a newline
+// follows it, so that each record is sent as soon as the delimiter after it
+// is written. Changes to the file are noticed with inotify (or kqueue),
+// unless --tail.poll is set, and looked for every second regardless.
+func tailDelimited(conf Config, file string, stateFile string, d *delimiter, abort <-chan struct{}) (chan string, *follower, error) {
+	// if the file was renamed while we were stopped, finish reading it first
+	var rotated string
+	var rotatedOffset int64
+	if conf.Options.ReadFrom == "last" {
+		rotated, rotatedOffset = rotatedRemainder(stateFile, file)
+	}
+	loc, err := startLocation(conf, file, stateFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	var fh *os.File
+	err = retryLocked(file, func(target string) (err error) {
+		fh, err = tail.OpenFile(target)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	var offset int64
+	if loc != nil {
+		offset, err = fh.Seek(loc.Offset, loc.Whence)
+		if err != nil {
+			fh.Close()
+			return nil, nil, err
+		}
+	}
+
+	stateFh, err := os.OpenFile(stateFile, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"logfile":   file,
+			"statefile": stateFile,
+		}).Warn("Failed to open statefile for writing. File location will not be saved.")
+	}
+	df := &delimitedFile{
+		pos:         filePosition{file: file},
+		d:           d,
+		follow:      !conf.Options.Stop,
+		followLinks: conf.Options.Symlinks != "inode",
+		poll:        conf.Options.Poll,
+		f:           &follower{eof: make(chan struct{})},
+		abort:       abort,
+	}
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		defer stateFh.Close()
+		if rotated != "" && !drainFile(rotated, rotatedOffset, d, lines, abort) {
+			fh.Close()
+			return
+		}
+		df.read(fh, offset, lines, stateFh)
+	}()
+	return lines, df.f, nil
+}
+
+// delimitedFile is a file being followed by tailDelimited
+type delimitedFile struct {
+	pos         filePosition
+	d           *delimiter
+	follow      bool
+	followLinks bool
+	poll        bool
+	f           *follower
+	abort       <-chan struct{}
+	// notify reports changes to the file being read on changed, unless
+	// polling
+	notify  *fsnotify.Watcher
+	changed chan struct{}
+}
+
+// read sends the records in fh from offset on, keeping the statefile up to
+// date, until abort is closed, or the end of the file is reached if it's
+// not being followed. The file is reopened from its start when it's
+// rotated, once what's left of it has been read.
+func (df *delimitedFile) read(fh *os.File, offset int64, lines chan<- string, stateFh *os.File) {
+	if df.follow && !df.poll {
+		if notify, err := fsnotify.NewWatcher(); err == nil {
+			df.notify = notify
+			defer notify.Close()
+			// the watcher's events have to be read as they come for it to
+			// carry on, so they're only kept until we next wait
+			df.changed = make(chan struct{}, 1)
+			go func() {
+				for range notify.Events {
+					select {
+					case df.changed <- struct{}{}:
+					default:
+					}
+				}
+			}()
+			go func() {
+				for range notify.Errors {
+				}
+			}()
+		}
+	}
+	df.open(fh, offset)
+	rr := df.d.reader(fh)
+	start := offset
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	// set once the file's been rotated, while what was written to it before
+	// then is read
+	var moved bool
+ReadRecords:
+	for {
+		record, err := rr.Next()
+		df.pos.offset = start + rr.Offset()
+		if err == nil {
+			select {
+			case lines <- record:
+			case <-df.abort:
+				break ReadRecords
+			}
+			select {
+			case <-ticker.C:
+				df.pos.save(stateFh)
+			default:
+			}
+			continue
+		}
+		if err != io.EOF {
+			logrus.WithFields(logrus.Fields{
+				"logfile": df.pos.file,
+				"err":     err,
+			}).Warn("Failed to read logfile")
+			break
+		}
+		// everything written so far has been read
+		if !df.follow || df.f.isStopped() || moved {
+			// the record being read ends with the file
+			if record := rr.Rest(); record != "" {
+				select {
+				case lines <- record:
+				case <-df.abort:
+					break ReadRecords
+				}
+			}
+			df.pos.offset = start + rr.Offset()
+			if !moved {
+				break
+			}
+			moved = false
+			df.pos.save(stateFh)
+			fh.Close()
+			if fh = df.reopen(); fh == nil {
+				return
+			}
+			rr.Reset(fh)
+			start = 0
+			continue
+		}
+		if read, err := fh.Seek(0, io.SeekCurrent); err == nil {
+			if info, err := fh.Stat(); err == nil && info.Size() < read {
+				// the file's been truncated, eg by copytruncate
+				logrus.WithFields(logrus.Fields{
+					"logfile": df.pos.file,
+				}).Debug("logfile has been truncated, reading it again from the start")
+				fh.Seek(0, io.SeekStart)
+				rr.Reset(fh)
+				start = 0
+				df.pos.offset = 0
+				df.pos.sum, df.pos.sumLength = 0, 0
+				continue
+			}
+		}
+		if df.pos.moved(df.followLinks) {
+			logrus.WithFields(logrus.Fields{
+				"logfile": df.pos.file,
+			}).Info("file has been rotated, finishing reading it before reopening")
+			// read anything written before the file was moved
+			moved = true
+			continue
+		}
+		if !df.wait(ticker.C, stateFh) {
+			break
+		}
+	}
+	fh.Close()
+	df.pos.save(stateFh)
+}
+
+// open starts keeping track of the position in fh, which is read from
+// offset
+func (df *delimitedFile) open(fh *os.File, offset int64) {
+	df.pos.target = fh.Name()
+	df.pos.id, _, _ = statID(df.pos.target)
+	df.pos.offset = offset
+	df.pos.sum, df.pos.sumLength = 0, 0
+	if df.notify != nil {
+		df.notify.Add(df.pos.target)
+	}
+}
+
+// wait waits for the file to change, saving the position reached each
+// tick, returning false if abort is closed first
+func (df *delimitedFile) wait(tick <-chan time.Time, stateFh *os.File) bool {
+	var poll <-chan time.Time
+	if df.notify == nil {
+		poll = time.After(pollInterval)
+	}
+	select {
+	case <-df.changed:
+	case <-poll:
+	case <-tick:
+		df.pos.save(stateFh)
+	case <-df.f.eof:
+	case <-df.abort:
+		return false
+	}
+	return true
+}
+
+// reopen opens whatever's at the file's path now, once it exists, to read
+// it from its start. It returns nil if the follower's been stopped or abort
+// is closed first.
+func (df *delimitedFile) reopen() *os.File {
+	if df.notify != nil {
+		df.notify.Remove(df.pos.target)
+	}
+	for {
+		var fh *os.File
+		err := retryLocked(df.pos.file, func(target string) (err error) {
+			fh, err = tail.OpenFile(target)
+			return err
+		})
+		if err == nil {
+			df.open(fh, 0)
+			return fh
+		}
+		if !os.IsNotExist(err) {
+			logrus.WithFields(logrus.Fields{
+				"logfile": df.pos.file,
+				"err":     err,
+			}).Warn("Failed to reopen rotated file")
+			return nil
+		}
+		// wait for the file to be created
+		select {
+		case <-time.After(time.Second):
+		case <-df.f.eof:
+			return nil
+		case <-df.abort:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package tail
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hpcloud/tail"
+)
+
+// fileID identifies a file, whatever it's been renamed to
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// findRotated looks for the file with the given ID alongside file, where
+// it's been moved to by a rename style rotation, eg to file.1. It returns
+// "" if there's none, or it's been compressed since.
+func findRotated(file string, id fileID) string {
+	dir := filepath.Dir(file)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, info := range infos {
+		path := filepath.Join(dir, info.Name())
+		if path == file || !info.Mode().IsRegular() {
+			continue
+		}
+		if other, _, err := statID(path); err == nil && other == id {
+			if compression, err := detectCompression(path); err != nil || compression != "" {
+				return ""
+			}
+			return path
+		}
+	}
+	return ""
+}
+
+// rotatedRemainder finds the part of file's previous incarnation that
+// wasn't read before stopping, if it's been rotated since by renaming. It
+// returns the rotated file's path and the offset to read from, or "" if
+// there's nothing to read.
+func rotatedRemainder(stateFile string, file string) (string, int64) {
+	state, err := readState(stateFile)
+	if err != nil {
+		return "", 0
+	}
+	id, _, err := statID(file)
+	if err != nil || state.matches(id) {
+		return "", 0
+	}
+	rotated := findRotated(file, state.previousID(id))
+	if rotated == "" || !state.sameStart(rotated) {
+		return "", 0
+	}
+	if _, size, err := statID(rotated); err != nil || size <= state.Offset {
+		return "", 0
+	}
+	return rotated, state.Offset
+}
+
+// drainFile sends the lines in file from offset to its end, or its
+// records if d is set, returning false if abort was closed first
+func drainFile(file string, offset int64, d *delimiter, lines chan<- string, abort <-chan struct{}) bool {
+	fh, err := tail.OpenFile(file)
+	if err != nil {
+		return true
+	}
+	defer fh.Close()
+	if _, err := fh.Seek(offset, io.SeekStart); err != nil {
+		return true
+	}
+	logrus.WithFields(logrus.Fields{
+		"logfile": file,
+		"offset":  offset,
+	}).Debug("finishing reading rotated file")
+	if d != nil {
+		ok, _ := sendRecords(d.reader(fh), lines, abort)
+		return ok
+	}
+	input := bufio.NewReader(fh)
+	for {
+		line, err := input.ReadString('\n')
+		if line != "" {
+			select {
+			case lines <- strings.TrimSpace(line):
+			case <-abort:
+				return false
+			}
+		}
+		if err != nil {
+			return true
+		}
+	}
+}
+
+// follower holds the tailer reading a file, which is replaced each time
+// the file is rotated. Files whose records are delimited are read without a
+// tailer, and eof is closed instead to stop them.
+type follower struct {
+	mu      sync.Mutex
+	tailer  *tail.Tail
+	eof     chan struct{}
+	stopped bool
+}
+
+// current returns the tailer reading the file now
+func (f *follower) current() *tail.Tail {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.tailer
+}
+
+// replace switches to a new tailer after a rotation, returning false if
+// the follower's been stopped in the meantime
+func (f *follower) replace(t *tail.Tail) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stopped {
+		return false
+	}
+	f.tailer = t
+	return true
+}
+
+// StopAtEOF stops following the file once the end of it has been read
+func (f *follower) StopAtEOF() {
+	f.mu.Lock()
+	wasStopped := f.stopped
+	f.stopped = true
+	t := f.tailer
+	f.mu.Unlock()
+	if t == nil {
+		if !wasStopped {
+			close(f.eof)
+		}
+		return
+	}
+	t.StopAtEOF()
+}
+
+// isStopped returns true once StopAtEOF has been called
+func (f *follower) isStopped() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stopped
+}
+
+// filePosition tracks the position reached in a file being tailed
+type filePosition struct {
+	file string
+	// the file read, which differs from file if it's a symlink
+	target string
+	id     fileID
+	offset int64
+	// the checksum of the first sumLength bytes read
+	sum       uint32
+	sumLength int64
+}
+
+// reset starts counting from where t starts reading
+func (p *filePosition) reset(t *tail.Tail) {
+	// the file may not exist yet, in which case its ID is found once lines
+	// are read from it
+	p.target = t.Filename
+	p.id, _, _ = statID(p.target)
+	p.offset = 0
+	p.sum, p.sumLength = 0, 0
+	if loc := t.Config.Location; loc != nil {
+		p.offset = loc.Offset
+	}
+}
+
+// advance moves past a line read by the tailer
+func (p *filePosition) advance(text string) {
+	if p.id == (fileID{}) {
+		p.id, _, _ = statID(p.target)
+	}
+	p.offset += int64(len(text)) + 1
+}
+
+// check catches up with the tailer if the file's been truncated, eg by
+// copytruncate, in which case the tailer reads it again from the start
+func (p *filePosition) check(t *tail.Tail) {
+	id, size, err := statID(p.target)
+	if err != nil || id != p.id || size >= p.offset {
+		return
+	}
+	// until the tailer notices, it's still past the end
+	if offset, err := t.Tell(); err == nil && offset <= size {
+		p.offset = offset
+		p.sum, p.sumLength = 0, 0
+	}
+}
+
+// clamp keeps the position within the file, as the last line read may not
+// have ended with a newline
+func (p *filePosition) clamp() {
+	if id, size, err := statID(p.target); err == nil && id == p.id && size < p.offset {
+		p.offset = size
+		p.sum, p.sumLength = 0, 0
+	}
+}
+
+// retargeted returns true if the file is a symlink that now points at a
+// different file from the one being read
+func (p *filePosition) retargeted() bool {
+	if p.id == (fileID{}) {
+		return false
+	}
+	info, err := os.Lstat(p.file)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return false
+	}
+	id, _, err := statID(p.file)
+	return err == nil && id != p.id
+}
+
+// moved returns true once the file at the path being followed is no longer
+// the one being read, as it's been renamed or deleted. A symlink that's
+// been repointed only counts if followLinks is set; otherwise it's not
+// moved until the file it pointed at is deleted.
+func (p *filePosition) moved(followLinks bool) bool {
+	if info, err := os.Lstat(p.file); err == nil && info.Mode()&os.ModeSymlink != 0 && !followLinks {
+		_, _, err := statID(p.target)
+		return err != nil
+	}
+	id, _, err := statID(p.file)
+	return err != nil || id != p.id
+}
+
+// checksum extends the checksum to cover as much of the start of the file
+// as has been read, up to checksumSize bytes
+func (p *filePosition) checksum() {
+	length := p.offset
+	if length > checksumSize {
+		length = checksumSize
+	}
+	if length <= p.sumLength {
+		return
+	}
+	if id, _, err := statID(p.target); err != nil || id != p.id {
+		return
+	}
+	if sum, err := checksumFile(p.target, length); err == nil {
+		p.sum, p.sumLength = sum, length
+	}
+}
+
+// save writes the position to the state file
+func (p *filePosition) save(stateFh *os.File) {
+	if p.id == (fileID{}) {
+		return
+	}
+	p.checksum()
+	writeState(&State{
+		INode:          p.id.ino,
+		Device:         p.id.dev,
+		Offset:         p.offset,
+		Checksum:       p.sum,
+		ChecksumLength: p.sumLength,
+	}, stateFh)
+}
+
+// reopen finishes reading the file old was following, which has been
+// renamed or deleted, then starts a new tailer reading whatever's at the
+// path now from its start, once it exists. It returns nil if the follower's
+// been stopped or abort is closed.
+func (p *filePosition) reopen(f *follower, old *tail.Tail, lines chan<- string, abort <-chan struct{}) *tail.Tail {
+	if rotated := findRotated(p.target, p.id); rotated != "" {
+		logrus.WithFields(logrus.Fields{
+			"logfile": p.file,
+			"rotated": rotated,
+		}).Info("file has been rotated, finishing reading it before reopening")
+		if !drainFile(rotated, p.offset, nil, lines, abort) {
+			return nil
+		}
+	}
+	conf := old.Config
+	conf.Location = nil
+	conf.MustExist = true
+	t, err := openTailer(p.file, conf)
+	if os.IsNotExist(err) {
+		// wait for the file to be created
+		conf.MustExist = false
+		t, err = tail.TailFile(p.file, conf)
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"logfile": p.file,
+			"err":     err,
+		}).Warn("Failed to reopen rotated file")
+		return nil
+	}
+	if !f.replace(t) {
+		t.Stop()
+		return nil
+	}
+	p.reset(t)
+	return t
+}
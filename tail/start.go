@@ -0,0 +1,48 @@
+package tail
+
+import (
+	"github.com/hpcloud/tail"
+)
+
+// lookBackChunk is how much of a file is read at a time looking back from
+// its end for the start of its last lines
+const lookBackChunk = 64 * 1024
+
+// lastLinesOffset returns the offset in file at which its last n lines
+// start, or 0 if it has no more than n. A last line without a newline
+// counts as one.
+func lastLinesOffset(file string, n uint) (int64, error) {
+	fh, err := tail.OpenFile(file)
+	if err != nil {
+		return 0, err
+	}
+	defer fh.Close()
+	info, err := fh.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	buf := make([]byte, lookBackChunk)
+	var found uint
+	for end := size; end > 0; {
+		start := end - lookBackChunk
+		if start < 0 {
+			start = 0
+		}
+		chunk := buf[:end-start]
+		if _, err := fh.ReadAt(chunk, start); err != nil {
+			return 0, err
+		}
+		for i := len(chunk) - 1; i >= 0; i-- {
+			// the newline ending the file doesn't start a line
+			if chunk[i] != '\n' || start+int64(i) == size-1 {
+				continue
+			}
+			if found++; found == n {
+				return start + int64(i) + 1, nil
+			}
+		}
+		end = start
+	}
+	return 0, nil
+}
@@ -1,14 +1,17 @@
 // Package tail implements tailing a log file.
 //
 // tail provides a channel on which log lines will be sent as string messages.
-// one line in the log file is one message on the channel
+// one line in the log file is one message on the channel, unless a record
+// delimiter is configured, in which case each record is one message.
 package tail
 
 import (
-	"bufio"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -17,7 +20,6 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/hpcloud/tail"
-	"golang.org/x/sys/unix"
 )
 
 type RotateStyle int
@@ -33,8 +35,18 @@ const (
 type TailOptions struct {
 	ReadFrom  string `long:"read_from" description:"Location in the file from which to start reading. Values: beginning, end, last. Last picks up where it left off, if the file has not been rotated, otherwise beginning. When --backfill is set, it will override this option=beginning" default:"last"`
 	Stop      bool   `long:"stop" description:"Stop reading the file after reaching the end rather than continuing to tail. When --backfill is set, it will override this option=true"`
-	Poll      bool   `long:"poll" description:"use poll instead of inotify to tail files"`
+	Poll      bool   `long:"poll" description:"use poll instead of inotify to tail files, and to notice new files matching --file globs or in --dir. Needed on filesystems that don't report changes, such as NFS, and on platforms without inotify or kqueue"`
 	StateFile string `long:"statefile" description:"File in which to store the last read position. Defaults to a file in /tmp named $logfile.leash.state. If tailing multiple files, default is forced."`
+	Lines     uint   `long:"lines" description:"Start reading each file this many lines before its end, in place of where --tail.read_from says. With read_from last, only files without a saved position start there. Compressed files and files found after starting are read from the beginning. 0 disables" default:"0"`
+
+	RecordDelimiter      string `long:"record_delimiter" description:"String that separates records in files, STDIN and named pipes, for logs that aren't one record per line. Escapes such as \\0 and \\x1e are interpreted. Each record is sent once the delimiter after it is written, whether or not a newline follows"`
+	RecordDelimiterRegex string `long:"record_delimiter_regex" description:"Regular expression matching the separator between records in files, STDIN and named pipes, for logs that aren't one record per line"`
+	RecordMaxSize        uint   `long:"record_max_size" description:"Longest record, in bytes, read with --tail.record_delimiter or --tail.record_delimiter_regex. Longer records are dropped. 0 means no limit" default:"1048576"`
+	Frame                string `long:"frame" description:"How records read from STDIN are framed: line, null for NUL-delimited records such as find -print0 writes, or varint for records each preceded by their length in bytes as an unsigned varint. Records framed by null or varint may hold newlines" default:"line"`
+
+	Symlinks string `long:"symlinks" description:"How to follow a file given as a symlink that's repointed on rotation, as svlogd, cronolog and alternatives do. follow switches to the file the link points at once the old one has been read; inode keeps reading the file first opened until it's deleted" default:"follow"`
+
+	RescanInterval uint `long:"rescan_interval" description:"How often, in seconds, to look for new files matching glob patterns given to --file or in --dir, and to stop tailing files that have been deleted. Unless --tail.poll is set, changes are also noticed as they happen and this is a fallback. 0 only expands the patterns and directories at startup" default:"5"`
 }
 
 // Statefile mechanics when ReadFrom is 'last'
@@ -42,53 +54,163 @@ type TailOptions struct {
 // empty statefile => ReadFrom = end
 // permission denied => WARN and ReadFrom = end
 // invalid location (aka logfile's been rotated) => ReadFrom = beginning
+// logfile's start doesn't match the statefile's checksum => ReadFrom = beginning
 
 type Config struct {
 	// Path to the log file to tail
 	Paths []string
+	// Directories whose files should all be tailed, and whether to include
+	// the files in their subdirectories
+	Dirs      []string
+	Recursive bool
+	// Glob patterns matching files to leave out when expanding Paths and
+	// Dirs
+	Exclude []string
+	// Files found by expanding Paths and Dirs that haven't been modified
+	// for IgnoreOlder, or are bigger than MaxFileSize bytes, are left out
+	// when first found. Files already being tailed carry on. Zero leaves
+	// out none.
+	IgnoreOlder time.Duration
+	MaxFileSize int64
 	// Type of log rotation we expect on this file
 	Type RotateStyle
 	// Tail specific options
 	Options TailOptions
+	// If set, files read from part way through, such as when carrying on
+	// from a statefile, first have the lines at their start beginning with
+	// HeaderPrefix sent again, for logs whose header names the columns of
+	// the lines after it
+	HeaderPrefix string
+	// If set, glob patterns in Paths and Dirs are rescanned while tailing and a
+	// channel of lines for each file that starts matching is sent on
+	// NewFiles. It's closed once rescanning stops, or straight away if
+	// there's nothing to rescan.
+	NewFiles chan<- chan string
 }
 
+// lockedRetries is how many times to retry opening a file locked by
+// another process, lockedWait apart
+const (
+	lockedRetries = 30
+	lockedWait    = time.Second
+)
+
+// stateVersion is the version of the statefile format written. Version 1
+// statefiles, written before the format was versioned, have no version,
+// device or checksum, and are read as if the file's start matches.
+const stateVersion = 2
+
+// checksumSize is how many of a file's leading bytes are checksummed
+const checksumSize = 1024
+
 // State is what's stored in a statefile
 type State struct {
-	INode  uint64 // the inode
-	Offset int64
+	Version int    `json:",omitempty"`
+	INode   uint64 // the inode
+	Offset  int64
+	// the device holding the inode; statefiles written by older versions
+	// don't have it
+	Device uint64 `json:",omitempty"`
+	// the CRC-32 of the first ChecksumLength bytes of the file, to tell it
+	// from one that's been truncated and rewritten, or a new file that's
+	// been given the same inode
+	Checksum       uint32 `json:",omitempty"`
+	ChecksumLength int64  `json:",omitempty"`
+}
+
+// matches returns true if the state was saved for the file with the given
+// ID
+func (s State) matches(id fileID) bool {
+	return s.INode == id.ino && (s.Device == 0 || s.Device == id.dev)
+}
+
+// previousID returns the ID of the file the state was saved for, given the
+// ID of the file at its path now
+func (s State) previousID(current fileID) fileID {
+	id := fileID{dev: s.Device, ino: s.INode}
+	if id.dev == 0 {
+		// statefiles written before the device was kept
+		id.dev = current.dev
+	}
+	return id
+}
+
+// sameStart returns false if the leading bytes of file aren't the ones
+// checksummed when the state was saved
+func (s State) sameStart(file string) bool {
+	if s.ChecksumLength == 0 {
+		return true
+	}
+	sum, err := checksumFile(file, s.ChecksumLength)
+	return err == nil && sum == s.Checksum
+}
+
+// checksumFile returns the CRC-32 of the first length bytes of file. It's
+// an error if the file's shorter than that.
+func checksumFile(file string, length int64) (uint32, error) {
+	fh, err := tail.OpenFile(file)
+	if err != nil {
+		return 0, err
+	}
+	defer fh.Close()
+	h := crc32.NewIEEE()
+	if _, err := io.CopyN(h, fh, length); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
 }
 
 // GetSampledEntries wraps GetEntries and returns a list of channels that
 // provide sampled entries
 func GetSampledEntries(conf Config, sampleRate uint, abort <-chan struct{}) ([]chan string, error) {
+	// files found while tailing need sampling too
+	if newFiles := conf.NewFiles; newFiles != nil && sampleRate != 1 {
+		unsampledFiles := make(chan chan string)
+		conf.NewFiles = unsampledFiles
+		go func() {
+			defer close(newFiles)
+			for lines := range unsampledFiles {
+				newFiles <- SampleLines(lines, sampleRate)
+			}
+		}()
+	}
 	unsampledLinesChans, err := GetEntries(conf, abort)
 	if err != nil {
 		return nil, err
 	}
+	return SampleAll(unsampledLinesChans, sampleRate), nil
+}
+
+// SampleAll samples each of linesChans, as the other inputs' GetSampledEntries
+// do. They're returned as they are if sampleRate is 1.
+func SampleAll(linesChans []chan string, sampleRate uint) []chan string {
 	if sampleRate == 1 {
-		return unsampledLinesChans, nil
+		return linesChans
 	}
+	sampledLinesChans := make([]chan string, 0, len(linesChans))
+	for _, lines := range linesChans {
+		sampledLinesChans = append(sampledLinesChans, SampleLines(lines, sampleRate))
+	}
+	return sampledLinesChans
+}
 
-	sampledLinesChans := make([]chan string, 0, len(unsampledLinesChans))
-
-	for _, lines := range unsampledLinesChans {
-		sampledLines := make(chan string)
-		go func(pLines chan string) {
-			defer close(sampledLines)
-			for line := range pLines {
-				if shouldDrop(sampleRate) {
-					logrus.WithFields(logrus.Fields{
-						"line":       line,
-						"samplerate": sampleRate,
-					}).Debug("Sampler says skip this line")
-				} else {
-					sampledLines <- line
-				}
+// SampleLines passes along the lines from lines that the sampler keeps
+func SampleLines(lines chan string, sampleRate uint) chan string {
+	sampledLines := make(chan string)
+	go func() {
+		defer close(sampledLines)
+		for line := range lines {
+			if shouldDrop(sampleRate) {
+				logrus.WithFields(logrus.Fields{
+					"line":       line,
+					"samplerate": sampleRate,
+				}).Debug("Sampler says skip this line")
+			} else {
+				sampledLines <- line
 			}
-		}(lines)
-		sampledLinesChans = append(sampledLinesChans, sampledLines)
-	}
-	return sampledLinesChans, nil
+		}
+	}()
+	return sampledLines
 }
 
 // shouldDrop returns true if the line should be dropped
@@ -102,41 +224,85 @@ func shouldDrop(rate uint) bool {
 // GetEntries sets up a list of channels that get one line at a time from each
 // file down each channel.
 func GetEntries(conf Config, abort <-chan struct{}) ([]chan string, error) {
-	if conf.Type != RotateStyleSyslog {
-		return nil, errors.New("Only Syslog style rotation currently supported")
+	conf = localPaths(conf)
+	var watcher *fileWatcher
+	if conf.NewFiles != nil {
+		watcher = newFileWatcher(conf, abort)
+		if watcher == nil {
+			close(conf.NewFiles)
+		}
 	}
-	// expand any globs in the list of files so our list all represents real files
-	var filenames []string
-	for _, filePath := range conf.Paths {
-		if filePath == "-" {
-			filenames = append(filenames, filePath)
+	linesChans, err := getEntries(conf, watcher, abort)
+	if watcher != nil {
+		if err != nil {
+			close(conf.NewFiles)
 		} else {
-			files, err := filepath.Glob(filePath)
-			if err != nil {
-				return nil, err
-			}
-			files = removeStateFiles(files, conf)
-			filenames = append(filenames, files...)
+			go watcher.run(time.Duration(conf.Options.RescanInterval) * time.Second)
 		}
 	}
-	if len(filenames) == 0 {
+	return linesChans, err
+}
+
+// getEntries starts tailing each of the files in conf, adding those
+// matching glob patterns or in directories to watcher if it's not nil
+func getEntries(conf Config, watcher *fileWatcher, abort <-chan struct{}) ([]chan string, error) {
+	if conf.Type != RotateStyleSyslog {
+		return nil, errors.New("Only Syslog style rotation currently supported")
+	}
+	filenames, err := listFiles(conf)
+	if err != nil {
+		return nil, err
+	}
+	filenames = removeSkipped(filenames, conf)
+	// files matching a watched pattern or in a watched directory may turn
+	// up later
+	if len(filenames) == 0 && watcher == nil {
 		return nil, errors.New("After removing missing files and state files from the list, there are no files left to tail")
 	}
+	delim, err := getDelimiter(conf.Options)
+	if err != nil {
+		return nil, err
+	}
+	switch conf.Options.Symlinks {
+	case "", "follow", "inode":
+	default:
+		return nil, fmt.Errorf("unknown option to --tail.symlinks: %s, expected follow or inode", conf.Options.Symlinks)
+	}
+	if watcher != nil {
+		watcher.delim = delim
+	}
 
 	// make our lines channel list; we'll get one channel for each file
 	linesChans := make([]chan string, 0, len(filenames))
 	numFiles := len(filenames)
 	for _, file := range filenames {
 		var lines chan string
-		if file == "-" {
-			lines = tailStdIn(abort)
+		if file == "-" && delim != nil {
+			lines = readRecords(os.Stdin, delim, abort)
+		} else if file == "-" {
+			read, err := getFrameReader(conf.Options.Frame)
+			if err != nil {
+				return nil, err
+			}
+			lines = tailStdIn(read, abort)
+		} else if isFIFO(file) {
+			lines = tailFIFO(file, conf.Options.Stop, delim, abort)
 		} else {
-			stateFile := getStateFile(conf, file, numFiles)
-			tailer, err := getTailer(conf, file, stateFile)
+			stateFile := stateFileFor(conf, file, numFiles)
+			// read before tailing starts saving to it, for the file it was
+			// saved for
+			var state State
+			if conf.Options.ReadFrom == "last" {
+				state, _ = readState(stateFile)
+			}
+			var f *follower
+			lines, f, err = tailFile(conf, file, stateFile, delim, abort)
 			if err != nil {
 				return nil, err
 			}
-			lines = tailSingleFile(tailer, file, stateFile, abort)
+			if watcher != nil && watcher.watches(file) {
+				watcher.add(file, f, state)
+			}
 		}
 		linesChans = append(linesChans, lines)
 	}
@@ -144,6 +310,61 @@ func GetEntries(conf Config, abort <-chan struct{}) ([]chan string, error) {
 	return linesChans, nil
 }
 
+// StateFiles returns the statefile used for each file tailed with conf, by
+// the file's path. STDIN and named pipes have no statefile.
+func StateFiles(conf Config) (map[string]string, error) {
+	conf = localPaths(conf)
+	filenames, err := listFiles(conf)
+	if err != nil {
+		return nil, err
+	}
+	filenames = removeSkipped(filenames, conf)
+	// STDIN counts towards the number of files, as it does in GetEntries
+	numFiles := len(filenames)
+	stateFiles := make(map[string]string, len(filenames))
+	for _, file := range filenames {
+		if file != "-" && !isFIFO(file) {
+			stateFiles[file] = stateFileFor(conf, file, numFiles)
+		}
+	}
+	return stateFiles, nil
+}
+
+// listFiles expands any globs in the list of files, and the directories to
+// tail, so our list all represents real files. STDIN is listed as "-". If
+// some can't be expanded, the rest are still listed along with the first
+// error.
+func listFiles(conf Config) ([]string, error) {
+	var filenames []string
+	var firstErr error
+	for _, filePath := range conf.Paths {
+		if filePath == "-" {
+			filenames = append(filenames, filePath)
+		} else {
+			files, err := filepath.Glob(filePath)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			files = removeStateFiles(files, conf)
+			filenames = append(filenames, removeExcluded(files, conf.Exclude)...)
+		}
+	}
+	for _, dir := range conf.Dirs {
+		files, err := listDir(dir, conf.Recursive, conf.Exclude)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		filenames = append(filenames, removeStateFiles(files, conf)...)
+	}
+	return filenames, firstErr
+}
+
 // removeStateFiles goes through the list of files and removes any that appear
 // to be statefiles to avoid .leash.state.leash.state.leash.state from appearing
 // when you use an overly permissive glob
@@ -168,7 +389,56 @@ func removeStateFiles(files []string, conf Config) []string {
 	return newFiles
 }
 
-func tailSingleFile(tailer *tail.Tail, file string, stateFile string, abort <-chan struct{}) chan string {
+// tailFile starts reading file, returning its follower, or nil if it's
+// compressed and so read once rather than followed. If d is set, records
+// separated by it are read rather than lines.
+func tailFile(conf Config, file string, stateFile string, d *delimiter, abort <-chan struct{}) (chan string, *follower, error) {
+	compression, err := detectCompression(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	if compression != "" {
+		lines, err := tailCompressed(conf, file, compression, stateFile, d, abort)
+		return lines, nil, err
+	}
+	if d != nil {
+		return tailDelimited(conf, file, stateFile, d, abort)
+	}
+	// if the file was renamed while we were stopped, finish reading it first
+	var rotated string
+	var rotatedOffset int64
+	if conf.Options.ReadFrom == "last" {
+		rotated, rotatedOffset = rotatedRemainder(stateFile, file)
+	}
+	tailer, err := getTailer(conf, file, stateFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	lines, f := tailSingleFile(tailer, file, stateFile, abort, rotated, rotatedOffset, conf.Options.Symlinks != "inode")
+	// the rest of a rotated file is read first, and the file itself from
+	// its start after that
+	if conf.HeaderPrefix != "" {
+		if rotated != "" {
+			lines = withHeader(readHeader(rotated, "", conf.HeaderPrefix), lines, abort)
+		} else if loc := tailer.Config.Location; loc != nil && (loc.Offset > 0 || loc.Whence != 0) {
+			lines = withHeader(readHeader(file, "", conf.HeaderPrefix), lines, abort)
+		}
+	}
+	return lines, f, nil
+}
+
+// tailSingleFile sends the lines read by tailer, and keeps the state file
+// up to date with the position in file reached. If rotated is set, the
+// lines from offset to the end of it are sent first.
+//
+// When file is rotated by renaming it, the rest of the renamed file is read
+// before reopening file and reading it from the start. A rotation by
+// truncating the file, as logrotate's copytruncate does, is followed by the
+// tailer. If file is a symlink and followLinks is set, it's reopened the
+// same way once the link points at a different file, after the rest of the
+// one it pointed at is read; otherwise that file is read until it's
+// deleted.
+func tailSingleFile(tailer *tail.Tail, file string, stateFile string, abort <-chan struct{}, rotated string, rotatedOffset int64, followLinks bool) (chan string, *follower) {
 	lines := make(chan string)
 	// TODO report some metric to indicate whether we're keeping up with the
 	// front of the file, of if it's being written faster than we can send
@@ -183,70 +453,99 @@ func tailSingleFile(tailer *tail.Tail, file string, stateFile string, abort <-ch
 	}
 
 	ticker := time.NewTicker(time.Second)
-	state := State{}
-	go func() {
-		for range ticker.C {
-			updateStateFile(&state, tailer, file, stateFh)
-		}
-	}()
+	f := &follower{tailer: tailer}
+	// the position reached is counted from the lines read, as the tailer's
+	// own is only approximate
+	pos := filePosition{file: file}
+	pos.reset(tailer)
 
 	go func() {
+		if rotated != "" && !drainFile(rotated, rotatedOffset, nil, lines, abort) {
+			close(lines)
+			ticker.Stop()
+			stateFh.Close()
+			return
+		}
+		// set once the link file has been repointed, or the file renamed,
+		// while the tailer finishes the file it was reading
+		var moved bool
 	ReadLines:
 		for {
 			select {
+			case <-ticker.C:
+				pos.check(tailer)
+				pos.save(stateFh)
+				if moved || !tailer.Config.Follow || pos.id == (fileID{}) {
+					break
+				}
+				if followLinks && pos.retargeted() {
+					logrus.WithFields(logrus.Fields{
+						"logfile": file,
+					}).Info("symlink points at a different file, finishing reading the old one before switching")
+					moved = true
+					go tailer.StopAtEOF()
+				} else if pos.moved(followLinks) {
+					// the tailer misses a rotation that happens just as it
+					// reaches the end of the file
+					logrus.WithFields(logrus.Fields{
+						"logfile": file,
+					}).Info("file has been moved, finishing reading it before reopening")
+					moved = true
+					go tailer.StopAtEOF()
+				}
 			case line, ok := <-tailer.Lines:
+				if !ok && moved {
+					moved = false
+					if tailer = pos.reopen(f, tailer, lines, abort); tailer == nil {
+						break ReadLines
+					}
+					continue
+				}
 				if !ok {
-					// tailer.Lines is closed
-					break ReadLines
+					// tailer.Lines is closed. If it was following the file,
+					// the file's been renamed or deleted, which the tailer
+					// reports as an error if it happens as it first reaches
+					// the end of the file. It closes Lines just before it's
+					// done, so wait for it to be.
+					if err := tailer.Wait(); !tailer.Config.Follow || (err != nil && !os.IsNotExist(err)) {
+						break ReadLines
+					}
+					if tailer = pos.reopen(f, tailer, lines, abort); tailer == nil {
+						break ReadLines
+					}
+					continue
 				}
 				if line.Err != nil {
 					// skip errored lines
 					continue
 				}
+				pos.advance(line.Text)
 				lines <- strings.TrimSpace(line.Text)
 			case <-abort:
 				// will only trigger when abort is closed
 				break ReadLines
 			}
 		}
-		close(lines)
 		ticker.Stop()
-		updateStateFile(&state, tailer, file, stateFh)
+		if tailer == nil {
+			tailer = f.current()
+		}
+		if tailer.Config.Follow {
+			pos.check(tailer)
+		} else {
+			pos.clamp()
+		}
+		pos.save(stateFh)
 		stateFh.Close()
+		close(lines)
 	}()
-	return lines
+	return lines, f
 }
 
 // tailStdIn is a special case to tail STDIN without any of the
 // fancy stuff that the tail module provides
-func tailStdIn(abort <-chan struct{}) chan string {
-	lines := make(chan string)
-	input := bufio.NewReader(os.Stdin)
-	go func() {
-		defer close(lines)
-		for {
-			// check for signal triggered exit
-			select {
-			case <-abort:
-				return
-			default:
-			}
-			line, partialLine, err := input.ReadLine()
-			if err != nil {
-				logrus.Debug("stdin is closed")
-				// bail when STDIN closes
-				return
-			}
-			var parts []string
-			parts = append(parts, string(line))
-			for partialLine {
-				line, partialLine, _ = input.ReadLine()
-				parts = append(parts, string(line))
-			}
-			lines <- strings.Join(parts, "")
-		}
-	}()
-	return lines
+func tailStdIn(read frameReader, abort <-chan struct{}) chan string {
+	return readFramed(os.Stdin, read, abort)
 }
 
 // getStartLocation reads the state file and creates an appropriate start
@@ -254,48 +553,44 @@ func tailStdIn(abort <-chan struct{}) chan string {
 func getStartLocation(stateFile string, logfile string) *tail.SeekInfo {
 	beginning := &tail.SeekInfo{}
 	end := &tail.SeekInfo{0, 2}
-	fh, err := os.Open(stateFile)
+	state, err := readState(stateFile)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"starting at": "end", "error": err,
-		}).Debug("getStartLocation failed to open the statefile")
-		return end
-	}
-	defer fh.Close()
-	// read the contents of the state file (JSON)
-	content := make([]byte, 1024)
-	bytesRead, err := fh.Read(content)
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"starting at": "end", "error": err,
-		}).Debug("getStartLocation failed to read the statefile contents")
-		return end
-	}
-	content = content[:bytesRead]
-	// decode the contents of the statefile
-	state := State{}
-	if err := json.Unmarshal(content, &state); err != nil {
-		logrus.WithFields(logrus.Fields{
-			"starting at": "end", "error": err,
-		}).Debug("getStartLocation failed to json decode the statefile")
+		}).Debug("getStartLocation failed to read the statefile")
 		return end
 	}
 	// get the details of the existing log file
-	logStat := unix.Stat_t{}
-	if err := unix.Stat(logfile, &logStat); err != nil {
+	logID, logSize, err := statID(logfile)
+	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"starting at": "end", "error": err,
-		}).Debug("getStartLocation failed to get unix.stat() on the logfile")
+		}).Debug("getStartLocation failed to stat the logfile")
 		return end
 	}
 	// compare inode numbers of the last-seen and existing log files
-	if state.INode != logStat.Ino {
+	if !state.matches(logID) {
 		logrus.WithFields(logrus.Fields{
 			"starting at": "beginning", "error": err,
 		}).Debug("getStartLocation found a different inode number for the logfile")
 		// file's been rotated
 		return beginning
 	}
+	// a file that's shrunk has been truncated, eg by copytruncate
+	if logSize < state.Offset {
+		logrus.WithFields(logrus.Fields{
+			"starting at": "beginning",
+		}).Debug("getStartLocation found the logfile is shorter than the offset")
+		return beginning
+	}
+	// one that's grown again since, or a new file reusing the inode, starts
+	// differently
+	if !state.sameStart(logfile) {
+		logrus.WithFields(logrus.Fields{
+			"starting at": "beginning",
+		}).Debug("getStartLocation found the start of the logfile has changed")
+		return beginning
+	}
 	logrus.WithFields(logrus.Fields{
 		"starting at": state.Offset,
 	}).Debug("getStartLocation seeking to offset in logfile")
@@ -306,12 +601,62 @@ func getStartLocation(stateFile string, logfile string) *tail.SeekInfo {
 	}
 }
 
+// readState reads and decodes a statefile. Statefiles in older formats are
+// converted to the current one, and those in newer ones are an error.
+func readState(stateFile string) (State, error) {
+	state := State{}
+	content, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(content, &state); err != nil {
+		return state, err
+	}
+	switch {
+	case state.Version == 0:
+		// version 1 only had the inode and offset, which are still where
+		// they were
+		state.Version = stateVersion
+	case state.Version > stateVersion:
+		return State{}, fmt.Errorf("statefile %s has version %d, newer than the version %d this honeytail understands", stateFile, state.Version, stateVersion)
+	}
+	return state, nil
+}
+
 // getTailer configures the *tail.Tail correctly to begin actually tailing the
 // specified file.
 func getTailer(conf Config, file string, stateFile string) (*tail.Tail, error) {
-	// tail a real file
+	loc, err := startLocation(conf, file, stateFile)
+	if err != nil {
+		return nil, err
+	}
+	var follow bool = true
+	if conf.Options.Stop {
+		follow = false
+	}
+	tailConf := tail.Config{
+		Location: loc,
+		// tailSingleFile reopens rotated files itself, once it's finished
+		// reading them, aka tail -F
+		ReOpen:    false,
+		MustExist: true,   // fail if log file doesn't exist
+		Follow:    follow, // don't stop at EOF, aka tail -f
+		Logger:    tail.DiscardingLogger,
+		Poll:      conf.Options.Poll, // use poll instead of inotify
+	}
+	logrus.WithFields(logrus.Fields{
+		"tailConf":  tailConf,
+		"conf":      conf,
+		"statefile": stateFile,
+		"location":  loc,
+	}).Debug("about to call tail.TailFile")
+	return openTailer(file, tailConf)
+}
+
+// startLocation returns where in file to start reading, as set by
+// --tail.read_from and --tail.lines. nil is its beginning.
+func startLocation(conf Config, file string, stateFile string) (*tail.SeekInfo, error) {
 	var loc *tail.SeekInfo // 0 value means start at beginning
-	var reOpen, follow bool = true, true
 	switch conf.Options.ReadFrom {
 	case "start", "beginning":
 		// 0 value for tail.SeekInfo means start at beginning
@@ -327,25 +672,61 @@ func getTailer(conf Config, file string, stateFile string) (*tail.Tail, error) {
 			conf.Options.ReadFrom)
 		return nil, errors.New(errMsg)
 	}
-	if conf.Options.Stop {
-		reOpen = false
-		follow = false
+	// start at the last lines of the file in place of its beginning or end,
+	// or where there's no saved position to carry on from
+	if conf.Options.Lines != 0 {
+		if _, err := readState(stateFile); conf.Options.ReadFrom != "last" || err != nil {
+			offset, err := lastLinesOffset(file, conf.Options.Lines)
+			if err != nil {
+				return nil, err
+			}
+			loc = &tail.SeekInfo{Offset: offset}
+		}
 	}
-	tailConf := tail.Config{
-		Location:  loc,
-		ReOpen:    reOpen, // keep reading on rotation, aka tail -F
-		MustExist: true,   // fail if log file doesn't exist
-		Follow:    follow, // don't stop at EOF, aka tail -f
-		Logger:    tail.DiscardingLogger,
-		Poll:      conf.Options.Poll, // use poll instead of inotify
+	// the position reached is counted from where reading starts, so it has
+	// to be known
+	if loc != nil && loc.Whence == 2 {
+		if _, size, err := statID(file); err == nil {
+			loc = &tail.SeekInfo{
+				Offset: size,
+				Whence: 0,
+			}
+		}
+	}
+	return loc, nil
+}
+
+// openTailer starts tailing file, which must exist. A file held
+// exclusively by the process writing it, as some do on Windows, is retried
+// for a while in the hope it's let go. If file is a symlink, the file it
+// points at is tailed, leaving tailSingleFile to notice it's repointed.
+func openTailer(file string, conf tail.Config) (*tail.Tail, error) {
+	var t *tail.Tail
+	err := retryLocked(file, func(target string) (err error) {
+		t, err = tail.TailFile(target, conf)
+		return err
+	})
+	return t, err
+}
+
+// retryLocked calls open with file, or the file it points at if it's a
+// symlink, retrying for a while if it's locked by another process
+func retryLocked(file string, open func(target string) error) error {
+	if info, err := os.Lstat(file); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if target, err := filepath.EvalSymlinks(file); err == nil {
+			file = target
+		}
+	}
+	for i := 0; ; i++ {
+		err := open(file)
+		if err == nil || !isLocked(err) || i == lockedRetries {
+			return err
+		}
+		logrus.WithFields(logrus.Fields{
+			"logfile": file,
+		}).Debug("logfile is locked by another process, waiting to open it")
+		time.Sleep(lockedWait)
 	}
-	logrus.WithFields(logrus.Fields{
-		"tailConf":  tailConf,
-		"conf":      conf,
-		"statefile": stateFile,
-		"location":  loc,
-	}).Debug("about to call tail.TailFile")
-	return tail.TailFile(file, tailConf)
 }
 
 // getStateFile returns the filename to use to track honeytail state.
@@ -384,17 +765,9 @@ func getStateFile(conf Config, filename string, numFiles int) string {
 	return filepath.Join(confStateFile, stateFileName)
 }
 
-// updateStateFile updates the state file once per second with the current
-// values for the logfile's inode number and offset
-func updateStateFile(state *State, t *tail.Tail, file string, stateFh *os.File) {
-	logStat := unix.Stat_t{}
-	unix.Stat(file, &logStat)
-	currentPos, err := t.Tell()
-	if err != nil {
-		return
-	}
-	state.INode = logStat.Ino
-	state.Offset = currentPos
+// writeState replaces the contents of the state file with state
+func writeState(state *State, stateFh *os.File) {
+	state.Version = stateVersion
 	out, err := json.Marshal(state)
 	if err != nil {
 		return
@@ -1,17 +1,23 @@
 package tail
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/hpcloud/tail"
 )
 
 var tailOpts = TailOptions{
@@ -36,10 +42,30 @@ func TestTailSingleFile(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	lines := tailSingleFile(tailer, filename, statefilename, ts.abort)
+	lines, _ := tailSingleFile(tailer, filename, statefilename, ts.abort, "", 0, true)
 	checkLinesChan(t, lines, jsonLines)
 }
 
+func TestTailCRLF(t *testing.T) {
+	ts := &testSetup{}
+	ts.start(t)
+	defer ts.stop()
+
+	filename := ts.tmpdir + "/windows.log"
+	statefilename := filename + ".mystate"
+	ts.writeFile(t, filename, "{\"a\":1}\r\n{\"b\":2}\r\n")
+	tailer, err := getTailer(Config{Options: tailOpts}, filename, statefilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, _ := tailSingleFile(tailer, filename, statefilename, ts.abort, "", 0, true)
+	checkLinesChan(t, lines, []string{`{"a":1}`, `{"b":2}`})
+	// the carriage returns are counted in the position reached
+	if state, err := readState(statefilename); err != nil || state.Offset != 18 {
+		t.Errorf("got state %+v, %v, expected offset 18", state, err)
+	}
+}
+
 func TestTailSTDIN(t *testing.T) {
 	ts := &testSetup{}
 	ts.start(t)
@@ -194,6 +220,889 @@ func TestAbortChannel(t *testing.T) {
 	}
 }
 
+func TestGlobWatcher(t *testing.T) {
+	ts := &testSetup{}
+	ts.start(t)
+	defer ts.stop()
+
+	newFiles := make(chan chan string)
+	conf := Config{
+		Paths: []string{ts.tmpdir + "/*.log"},
+		Options: TailOptions{
+			ReadFrom:       "start",
+			Poll:           true,
+			StateFile:      ts.tmpdir,
+			RescanInterval: 1,
+		},
+		NewFiles: newFiles,
+	}
+	// nothing to rescan without a pattern, or when stopping at the end
+	if newFileWatcher(Config{Paths: []string{"/var/log/app.log"}, Options: conf.Options}, ts.abort) != nil {
+		t.Error("expected no watcher without a glob pattern")
+	}
+	stopConf := conf
+	stopConf.Options.Stop = true
+	if newFileWatcher(stopConf, ts.abort) != nil {
+		t.Error("expected no watcher when stopping at the end of each file")
+	}
+
+	first := ts.tmpdir + "/first.log"
+	ts.writeFile(t, first, "one\n")
+	w := newFileWatcher(conf, ts.abort)
+	if w == nil {
+		t.Fatal("expected a watcher for a glob pattern")
+	}
+	chanArr, err := getEntries(conf, w, ts.abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chanArr) != 1 || w.following()[first] == nil {
+		t.Fatalf("expected to be tailing %s, got %d channels", first, len(chanArr))
+	}
+	checkLine(t, chanArr[0], "one")
+
+	// a new file is tailed from its beginning
+	ts.writeFile(t, ts.tmpdir+"/second.log", "two\n")
+	rescanned := make(chan struct{})
+	go func() {
+		w.rescan()
+		close(rescanned)
+	}()
+	var second chan string
+	select {
+	case second = <-newFiles:
+	case <-time.After(5 * time.Second):
+		t.Fatal("new file wasn't found")
+	}
+	checkLine(t, second, "two")
+	<-rescanned
+
+	// and a deleted file is no longer tailed
+	os.Remove(first)
+	w.rescan()
+	if _, ok := w.following()[first]; ok {
+		t.Error("deleted file is still being tailed")
+	}
+	checkLinesChanClosed(t, chanArr[0])
+
+	close(ts.abort)
+	checkLinesChanClosed(t, second)
+}
+
+func TestWatcherNotify(t *testing.T) {
+	ts := &testSetup{}
+	ts.start(t)
+	defer ts.stop()
+
+	dir := ts.tmpdir + "/logs"
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	newFiles := make(chan chan string)
+	conf := Config{
+		Paths: []string{dir + "/*.log"},
+		Options: TailOptions{
+			ReadFrom:  "start",
+			StateFile: ts.tmpdir,
+			// long enough that only a notification finds the new file
+			RescanInterval: 3600,
+		},
+		NewFiles: newFiles,
+	}
+	if _, err := GetEntries(conf, ts.abort); err != nil {
+		t.Fatal(err)
+	}
+	// give the watcher a moment to start watching
+	time.Sleep(100 * time.Millisecond)
+	ts.writeFile(t, dir+"/new.log", "one\n")
+	select {
+	case lines := <-newFiles:
+		checkLine(t, lines, "one")
+	case <-time.After(5 * time.Second):
+		t.Fatal("new file wasn't found")
+	}
+	close(ts.abort)
+	select {
+	case _, ok := <-newFiles:
+		if ok {
+			t.Error("unexpected new file")
+		}
+	case <-time.After(time.Second):
+		t.Error("NewFiles wasn't closed")
+	}
+}
+
+func TestWatcherRotation(t *testing.T) {
+	for _, byDir := range []bool{false, true} {
+		ts := &testSetup{}
+		ts.start(t)
+
+		dir := ts.tmpdir + "/logs"
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		newFiles := make(chan chan string)
+		conf := Config{
+			Options: TailOptions{
+				ReadFrom:       "start",
+				Poll:           true,
+				StateFile:      ts.tmpdir,
+				RescanInterval: 1,
+			},
+			NewFiles: newFiles,
+		}
+		if byDir {
+			conf.Dirs = []string{dir}
+		} else {
+			// a pattern that matches rotated names too
+			conf.Paths = []string{dir + "/*"}
+		}
+		logfile := dir + "/app.log"
+		ts.writeFile(t, logfile, "one\n")
+		w := newFileWatcher(conf, ts.abort)
+		chanArr, err := getEntries(conf, w, ts.abort)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkLine(t, chanArr[0], "one")
+
+		// the renamed file has been read, and the new one is read by the
+		// follower of its path, so neither is new
+		if err := os.Rename(logfile, logfile+".1"); err != nil {
+			t.Fatal(err)
+		}
+		ts.writeFile(t, logfile, "two\n")
+		rescanned := make(chan struct{})
+		go func() {
+			w.rescan()
+			close(rescanned)
+		}()
+		select {
+		case lines := <-newFiles:
+			t.Errorf("byDir=%v: rotated file tailed again, starting with %q", byDir, <-lines)
+		case <-rescanned:
+		}
+		checkLine(t, chanArr[0], "two")
+
+		// another rescan once the rotation's been followed finds nothing new
+		rescanned = make(chan struct{})
+		go func() {
+			w.rescan()
+			close(rescanned)
+		}()
+		select {
+		case <-newFiles:
+			t.Errorf("byDir=%v: rotated file tailed again", byDir)
+		case <-rescanned:
+		}
+
+		// while a file that's really new is
+		ts.writeFile(t, dir+"/other.log", "three\n")
+		go w.rescan()
+		select {
+		case lines := <-newFiles:
+			checkLine(t, lines, "three")
+		case <-time.After(5 * time.Second):
+			t.Fatalf("byDir=%v: new file wasn't found", byDir)
+		}
+		close(ts.abort)
+		ts.stop()
+	}
+}
+
+func TestGetEntriesClosesNewFiles(t *testing.T) {
+	ts := &testSetup{}
+	ts.start(t)
+	defer ts.stop()
+
+	filename := ts.tmpdir + "/first.log"
+	ts.writeFile(t, filename, "one")
+	newFiles := make(chan chan string)
+	conf := Config{
+		Paths:    []string{filename},
+		Options:  tailOpts,
+		NewFiles: newFiles,
+	}
+	if _, err := GetEntries(conf, ts.abort); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-newFiles; ok {
+		t.Error("expected NewFiles to be closed when there's nothing to rescan")
+	}
+}
+
+func TestListDir(t *testing.T) {
+	ts := &testSetup{}
+	ts.start(t)
+	defer ts.stop()
+
+	for _, dir := range []string{"/a", "/a/b", "/archive"} {
+		if err := os.Mkdir(ts.tmpdir+dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"/top.log", "/top.log.1.gz", "/a/one.log", "/a/b/two.log", "/archive/old.log"} {
+		ts.writeFile(t, ts.tmpdir+file, "line")
+	}
+	if err := os.Symlink(ts.tmpdir+"/a/one.log", ts.tmpdir+"/link.log"); err != nil {
+		t.Fatal(err)
+	}
+	tlms := []struct {
+		recursive bool
+		exclude   []string
+		expected  []string
+	}{
+		{
+			expected: []string{"/link.log", "/top.log", "/top.log.1.gz"},
+		},
+		{
+			exclude:  []string{"*.gz"},
+			expected: []string{"/link.log", "/top.log"},
+		},
+		{
+			recursive: true,
+			exclude:   []string{"*.gz", "archive"},
+			expected:  []string{"/a/b/two.log", "/a/one.log", "/link.log", "/top.log"},
+		},
+		{
+			recursive: true,
+			exclude:   []string{ts.tmpdir + "/a/*"},
+			expected:  []string{"/archive/old.log", "/link.log", "/top.log", "/top.log.1.gz"},
+		},
+	}
+	for _, tlm := range tlms {
+		files, err := listDir(ts.tmpdir, tlm.recursive, tlm.exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range files {
+			files[i] = strings.TrimPrefix(files[i], ts.tmpdir)
+		}
+		if !reflect.DeepEqual(files, tlm.expected) {
+			t.Errorf("recursive %v exclude %v got files %v, expected %v", tlm.recursive, tlm.exclude, files, tlm.expected)
+		}
+	}
+	if _, err := listDir(ts.tmpdir+"/top.log", false, nil); err == nil {
+		t.Error("expected error listing a file")
+	}
+	if _, err := listDir(ts.tmpdir+"/missing", false, nil); err == nil {
+		t.Error("expected error listing a missing directory")
+	}
+}
+
+func TestSkipOldAndLargeFiles(t *testing.T) {
+	ts := &testSetup{}
+	ts.start(t)
+	defer ts.stop()
+
+	ts.writeFile(t, ts.tmpdir+"/new.log", "{\"a\":1}\n")
+	ts.writeFile(t, ts.tmpdir+"/old.log", "{\"b\":2}\n")
+	ts.writeFile(t, ts.tmpdir+"/big.log", strings.Repeat("x", 100)+"\n")
+	lastYear := time.Now().AddDate(-1, 0, 0)
+	if err := os.Chtimes(ts.tmpdir+"/old.log", lastYear, lastYear); err != nil {
+		t.Fatal(err)
+	}
+	conf := Config{
+		Paths:       []string{ts.tmpdir + "/*.log"},
+		IgnoreOlder: 24 * time.Hour,
+		MaxFileSize: 50,
+		Options: TailOptions{
+			ReadFrom: "beginning",
+			Stop:     true,
+		},
+	}
+	stateFiles, err := StateFiles(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stateFiles[ts.tmpdir+"/new.log"]; !ok || len(stateFiles) != 1 {
+		t.Errorf("got statefiles %v, expected one for new.log alone", stateFiles)
+	}
+	chanArr, err := GetEntries(conf, ts.abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chanArr) != 1 {
+		t.Fatalf("got %d channels, expected only new.log to be tailed", len(chanArr))
+	}
+	checkLinesChan(t, chanArr[0], []string{"{\"a\":1}"})
+
+	// files named outright are read whatever their age
+	conf.Paths = []string{ts.tmpdir + "/old.log"}
+	chanArr, err = GetEntries(conf, ts.abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLinesChan(t, chanArr[0], []string{"{\"b\":2}"})
+}
+
+func TestStateFileFor(t *testing.T) {
+	conf := Config{
+		Dirs: []string{"/var/log/containers"},
+		Options: TailOptions{
+			StateFile: "/tmp/app.state",
+		},
+	}
+	// files in a directory are named after their whole path
+	expected := filepath.Join(os.TempDir(), "var_log_containers_web_0.leash.state")
+	if got := stateFileFor(conf, "/var/log/containers/web/0.log", 1); got != expected {
+		t.Errorf("got statefile %s, expected %s", got, expected)
+	}
+	if got := stateFileFor(conf, "/var/log/app.log", 1); got != "/tmp/app.state" {
+		t.Errorf("got statefile %s, expected /tmp/app.state", got)
+	}
+}
+
+func TestWindowsPaths(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		t.Skip("paths with drives are only found on Windows")
+	}
+	conf := localPaths(Config{
+		Dirs:    []string{"C:/logs"},
+		Exclude: []string{"C:/logs/old/*"},
+	})
+	expected := filepath.Join(os.TempDir(), "C_logs_web_0.leash.state")
+	if got := stateFileFor(conf, `C:\logs\web\0.log`, 1); got != expected {
+		t.Errorf("got statefile %s, expected %s", got, expected)
+	}
+	if !excluded(`C:\logs\old\1.log`, conf.Exclude) {
+		t.Error("expected a pattern given with / to exclude a path with \\")
+	}
+}
+
+func TestDirWatcher(t *testing.T) {
+	ts := &testSetup{}
+	ts.start(t)
+	defer ts.stop()
+
+	dir := ts.tmpdir + "/logs"
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	newFiles := make(chan chan string)
+	conf := Config{
+		Dirs:      []string{dir},
+		Recursive: true,
+		Exclude:   []string{"*.gz"},
+		Options: TailOptions{
+			ReadFrom:       "start",
+			Poll:           true,
+			StateFile:      ts.tmpdir,
+			RescanInterval: 1,
+		},
+		NewFiles: newFiles,
+	}
+	w := newFileWatcher(conf, ts.abort)
+	if w == nil {
+		t.Fatal("expected a watcher for a directory")
+	}
+	// an empty directory may have files added later
+	chanArr, err := getEntries(conf, w, ts.abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chanArr) != 0 {
+		t.Fatalf("expected no channels, got %d", len(chanArr))
+	}
+
+	if err := os.Mkdir(dir+"/web", 0755); err != nil {
+		t.Fatal(err)
+	}
+	ts.writeFile(t, dir+"/web/0.log", "one\n")
+	ts.writeFile(t, dir+"/web/0.log.1.gz", "compressed")
+	rescanned := make(chan struct{})
+	go func() {
+		w.rescan()
+		close(rescanned)
+	}()
+	var lines chan string
+	select {
+	case lines = <-newFiles:
+	case <-time.After(5 * time.Second):
+		t.Fatal("new file wasn't found")
+	}
+	checkLine(t, lines, "one")
+	<-rescanned
+	if followed := w.following(); len(followed) != 1 || followed[dir+"/web/0.log"] == nil {
+		t.Errorf("expected to be tailing only %s/web/0.log, got %v", dir, followed)
+	}
+	if _, err := os.Stat(filepath.Join(ts.tmpdir, strings.Replace(strings.TrimPrefix(dir, "/"), "/", "_", -1)+"_web_0.leash.state")); err != nil {
+		t.Errorf("expected a statefile named after the file's path: %s", err)
+	}
+	close(ts.abort)
+	checkLinesChanClosed(t, lines)
+}
+
+// bzip2Lines is "first\nsecond\n" compressed with bzip2
+var bzip2Lines = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x67, 0x62,
+	0xd4, 0x8d, 0x00, 0x00, 0x02, 0xc1, 0x80, 0x00, 0x10, 0x0f, 0x21, 0x9c,
+	0x00, 0x20, 0x00, 0x22, 0x00, 0x69, 0x90, 0x80, 0x69, 0xa6, 0x89, 0x56,
+	0x16, 0x03, 0xc6, 0xd6, 0xf8, 0xbb, 0x92, 0x29, 0xc2, 0x84, 0x83, 0x3b,
+	0x16, 0xa4, 0x68,
+}
+
+func TestTailCompressed(t *testing.T) {
+	ts := &testSetup{}
+	ts.start(t)
+	defer ts.stop()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	fmt.Fprint(gz, "first\nsecond\n")
+	gz.Close()
+	gzFile := ts.tmpdir + "/access.log.2.gz"
+	ts.writeFile(t, gzFile, buf.String())
+	bz2File := ts.tmpdir + "/access.log.3.bz2"
+	ts.writeFile(t, bz2File, string(bzip2Lines))
+	plainFile := ts.tmpdir + "/access.log"
+	ts.writeFile(t, plainFile, "B")
+
+	for file, expected := range map[string]string{gzFile: "gzip", bz2File: "bzip2", plainFile: ""} {
+		if got, err := detectCompression(file); err != nil || got != expected {
+			t.Errorf("detected compression %q for %s, expected %q (err %v)", got, file, expected, err)
+		}
+	}
+
+	// rotated files are read once, whatever they're named
+	conf := Config{
+		Paths:   []string{gzFile, bz2File},
+		Options: TailOptions{ReadFrom: "beginning"},
+	}
+	chanArr, err := GetEntries(conf, ts.abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ch := range chanArr {
+		checkLinesChan(t, ch, []string{"first", "second"})
+	}
+
+	// the state file holds the offset into the decompressed contents
+	stateFile := ts.tmpdir + "/access.leash.state"
+	conf.Options.StateFile = stateFile
+	conf.Paths = []string{gzFile}
+	conf.Options.ReadFrom = "start"
+	chanArr, err = GetEntries(conf, ts.abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLinesChan(t, chanArr[0], []string{"first", "second"})
+	body, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `"Offset":13`) {
+		t.Errorf("unexpected state %s", body)
+	}
+	// so reading from the last position can skip to it
+	ts.writeFile(t, stateFile, strings.Replace(string(body), `"Offset":13`, `"Offset":6`, 1))
+	conf.Options.ReadFrom = "last"
+	chanArr, err = GetEntries(conf, ts.abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLinesChan(t, chanArr[0], []string{"second"})
+
+	// even when it's past the end of the compressed file
+	buf.Reset()
+	gz = gzip.NewWriter(&buf)
+	fmt.Fprint(gz, strings.Repeat("x\n", checksumSize)+"last\n")
+	gz.Close()
+	ts.writeFile(t, gzFile, buf.String())
+	os.Remove(stateFile)
+	conf.Options.ReadFrom = "start"
+	chanArr, err = GetEntries(conf, ts.abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range chanArr[0] {
+	}
+	state, err := readState(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Offset -= 5
+	body, _ = json.Marshal(state)
+	ts.writeFile(t, stateFile, string(body))
+	conf.Options.ReadFrom = "last"
+	chanArr, err = GetEntries(conf, ts.abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLinesChan(t, chanArr[0], []string{"last"})
+}
+
+func TestGetStartLocation(t *testing.T) {
+	ts := &testSetup{}
+	ts.start(t)
+	defer ts.stop()
+
+	filename := ts.tmpdir + "/app.log"
+	stateFile := ts.tmpdir + "/app.leash.state"
+	ts.writeFile(t, filename, "first\nsecond\n")
+	id, _, err := statID(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := checksumFile(filename, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeStateFile := func(state State) {
+		body, _ := json.Marshal(state)
+		ts.writeFile(t, stateFile, string(body))
+	}
+	tlms := []struct {
+		state    State
+		expected tail.SeekInfo
+	}{
+		{State{INode: id.ino, Device: id.dev, Offset: 6}, tail.SeekInfo{Offset: 6}},
+		// statefiles from before the device was kept
+		{State{INode: id.ino, Offset: 6}, tail.SeekInfo{Offset: 6}},
+		// a different file
+		{State{INode: id.ino + 1, Device: id.dev, Offset: 6}, tail.SeekInfo{}},
+		{State{INode: id.ino, Device: id.dev + 1, Offset: 6}, tail.SeekInfo{}},
+		// the file's been truncated
+		{State{INode: id.ino, Device: id.dev, Offset: 20}, tail.SeekInfo{}},
+		// and rewritten, or the inode's been reused
+		{State{INode: id.ino, Device: id.dev, Offset: 6, Checksum: sum, ChecksumLength: 6}, tail.SeekInfo{Offset: 6}},
+		{State{INode: id.ino, Device: id.dev, Offset: 6, Checksum: sum + 1, ChecksumLength: 6}, tail.SeekInfo{}},
+		{State{INode: id.ino, Device: id.dev, Offset: 6, Checksum: sum, ChecksumLength: 20}, tail.SeekInfo{}},
+	}
+	for _, tlm := range tlms {
+		writeStateFile(tlm.state)
+		if loc := getStartLocation(stateFile, filename); *loc != tlm.expected {
+			t.Errorf("state %+v got location %+v, expected %+v", tlm.state, *loc, tlm.expected)
+		}
+	}
+	os.Remove(stateFile)
+	if loc := getStartLocation(stateFile, filename); *loc != (tail.SeekInfo{Whence: 2}) {
+		t.Errorf("got location %+v without a statefile, expected the end", *loc)
+	}
+}
+
+func TestLastLinesOffset(t *testing.T) {
+	ts := &testSetup{}
+	ts.start(t)
+	defer ts.stop()
+
+	filename := ts.tmpdir + "/app.log"
+	long := strings.Repeat("x", lookBackChunk)
+	tlos := []struct {
+		body     string
+		n        uint
+		expected int64
+	}{
+		{"first\nsecond\nthird\n", 1, 13},
+		{"first\nsecond\nthird\n", 2, 6},
+		{"first\nsecond\nthird\n", 3, 0},
+		{"first\nsecond\nthird\n", 10, 0},
+		// a last line without a newline
+		{"first\nsecond\nthird", 1, 13},
+		{"first\n\nthird\n", 2, 6},
+		// lines spanning the chunks read
+		{"first\n" + long + "\nthird\n", 2, 6},
+		{"", 1, 0},
+	}
+	for _, tlo := range tlos {
+		ts.writeFile(t, filename, tlo.body)
+		offset, err := lastLinesOffset(filename, tlo.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offset != tlo.expected {
+			t.Errorf("last %d lines of %.20q start at %d, expected %d", tlo.n, tlo.body, offset, tlo.expected)
+		}
+	}
+}
+
+func TestReadLastLines(t *testing.T) {
+	ts := &testSetup{}
+	ts.start(t)
+	defer ts.stop()
+
+	filename := ts.tmpdir + "/app.log"
+	stateFile := ts.tmpdir + "/app.leash.state"
+	ts.writeFile(t, filename, "first\nsecond\nthird\n")
+	conf := Config{
+		Paths: []string{filename},
+		Options: TailOptions{
+			ReadFrom:  "last",
+			Stop:      true,
+			StateFile: stateFile,
+			Lines:     2,
+		},
+	}
+	chanArr, err := GetEntries(conf, ts.abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLinesChan(t, chanArr[0], []string{"second", "third"})
+
+	// with a saved position, reading carries on from it
+	f, _ := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("fourth\n")
+	f.Close()
+	chanArr, err = GetEntries(conf, ts.abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLinesChan(t, chanArr[0], []string{"fourth"})
+}
+
+func TestReplayHeader(t *testing.T) {
+	ts := &testSetup{}
+	ts.start(t)
+	defer ts.stop()
+
+	filename := ts.tmpdir + "/conn.log"
+	stateFile := ts.tmpdir + "/conn.leash.state"
+	ts.writeFile(t, filename, "#fields\ta\tb\n#types\tcount\tcount\n1\t2\n")
+	conf := Config{
+		Paths:        []string{filename},
+		HeaderPrefix: "#",
+		Options: TailOptions{
+			ReadFrom:  "beginning",
+			Stop:      true,
+			StateFile: stateFile,
+		},
+	}
+	chanArr, err := GetEntries(conf, ts.abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLinesChan(t, chanArr[0], []string{"#fields\ta\tb", "#types\tcount\tcount", "1\t2"})
+
+	// carrying on from the saved position, the header is read again first
+	f, _ := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("3\t4\n")
+	f.Close()
+	conf.Options.ReadFrom = "last"
+	chanArr, err = GetEntries(conf, ts.abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLinesChan(t, chanArr[0], []string{"#fields\ta\tb", "#types\tcount\tcount", "3\t4"})
+
+	// as it is for the rest of a file rotated while stopped
+	if err := os.Rename(filename, filename+".1"); err != nil {
+		t.Fatal(err)
+	}
+	f, _ = os.OpenFile(filename+".1", os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("5\t6\n")
+	f.Close()
+	ts.writeFile(t, filename, "#fields\tc\n7\n")
+	chanArr, err = GetEntries(conf, ts.abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLinesChan(t, chanArr[0], []string{"#fields\ta\tb", "#types\tcount\tcount", "5\t6", "#fields\tc", "7"})
+}
+
+func TestReadState(t *testing.T) {
+	ts := &testSetup{}
+	ts.start(t)
+	defer ts.stop()
+
+	stateFile := ts.tmpdir + "/app.leash.state"
+	// version 1 statefiles are read as the current version
+	ts.writeFile(t, stateFile, `{"INode":1,"Offset":4}`)
+	state, err := readState(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != (State{Version: stateVersion, INode: 1, Offset: 4}) {
+		t.Errorf("unexpected state %+v", state)
+	}
+	// and written back in it
+	fh, err := os.OpenFile(stateFile, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeState(&State{INode: 1, Offset: 6, Checksum: 7, ChecksumLength: 6}, fh)
+	fh.Close()
+	state, err = readState(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != (State{Version: stateVersion, INode: 1, Offset: 6, Checksum: 7, ChecksumLength: 6}) {
+		t.Errorf("unexpected state %+v", state)
+	}
+	// newer versions can't be read
+	ts.writeFile(t, stateFile, `{"Version":3,"INode":1,"Offset":4}`)
+	if _, err := readState(stateFile); err == nil {
+		t.Error("expected an error reading a newer statefile")
+	}
+}
+
+func TestRotatedWhileStopped(t *testing.T) {
+	ts := &testSetup{}
+	ts.start(t)
+	defer ts.stop()
+
+	filename := ts.tmpdir + "/app.log"
+	stateFile := ts.tmpdir + "/app.leash.state"
+	ts.writeFile(t, filename, "first\nsecond\nthird\n")
+	id, _, err := statID(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(State{INode: id.ino, Device: id.dev, Offset: 6})
+	ts.writeFile(t, stateFile, string(body))
+	// the file is renamed and a new one started while we're stopped
+	if err := os.Rename(filename, filename+".1"); err != nil {
+		t.Fatal(err)
+	}
+	ts.writeFile(t, filename, "fourth\n")
+
+	conf := Config{
+		Paths: []string{filename},
+		Options: TailOptions{
+			ReadFrom:  "last",
+			Stop:      true,
+			StateFile: stateFile,
+		},
+	}
+	chanArr, err := GetEntries(conf, ts.abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLinesChan(t, chanArr[0], []string{"second", "third", "fourth"})
+}
+
+func TestFollowRotation(t *testing.T) {
+	ts := &testSetup{}
+	ts.start(t)
+	defer ts.stop()
+
+	filename := ts.tmpdir + "/app.log"
+	ts.writeFile(t, filename, "first\n")
+	conf := Config{
+		Paths: []string{filename},
+		Options: TailOptions{
+			ReadFrom:  "beginning",
+			Poll:      true,
+			StateFile: ts.tmpdir + "/app.leash.state",
+		},
+	}
+	chanArr, err := GetEntries(conf, ts.abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := chanArr[0]
+	checkLine(t, lines, "first")
+	// give the tailer a moment to reach the end and start watching for changes
+	time.Sleep(100 * time.Millisecond)
+
+	// the file's renamed, and written to again before the writer reopens it
+	if err := os.Rename(filename, filename+".1"); err != nil {
+		t.Fatal(err)
+	}
+	fh, err := os.OpenFile(filename+".1", os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(fh, "second\n")
+	fh.Close()
+	ts.writeFile(t, filename, "third\n")
+	checkLine(t, lines, "second")
+	checkLine(t, lines, "third")
+
+	// and then truncated after being copied, and written to again, though
+	// less than was read before, or the truncation can't be noticed
+	if err := os.Truncate(filename, 0); err != nil {
+		t.Fatal(err)
+	}
+	fh, err = os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(fh, "4th\n")
+	fh.Close()
+	checkLine(t, lines, "4th")
+
+	close(ts.abort)
+	checkLinesChanClosed(t, lines)
+	state, err := readState(conf.Options.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _, _ := statID(filename)
+	if !state.matches(id) || state.Offset != 4 || state.ChecksumLength != 4 {
+		t.Errorf("unexpected state %+v", state)
+	}
+}
+
+func TestFollowSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+	for _, mode := range []string{"follow", "inode"} {
+		ts := &testSetup{}
+		ts.start(t)
+
+		link := ts.tmpdir + "/current"
+		ts.writeFile(t, ts.tmpdir+"/app.1.log", "first\n")
+		if err := os.Symlink("app.1.log", link); err != nil {
+			t.Fatal(err)
+		}
+		conf := Config{
+			Paths: []string{link},
+			Options: TailOptions{
+				ReadFrom:  "beginning",
+				Poll:      true,
+				StateFile: ts.tmpdir + "/current.leash.state",
+				Symlinks:  mode,
+			},
+		}
+		chanArr, err := GetEntries(conf, ts.abort)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := chanArr[0]
+		checkLine(t, lines, "first")
+
+		// the link's repointed at a new file, while the old one's written to
+		// once more
+		ts.writeFile(t, ts.tmpdir+"/app.2.log", "new\n")
+		os.Symlink("app.2.log", link+".tmp")
+		if err := os.Rename(link+".tmp", link); err != nil {
+			t.Fatal(err)
+		}
+		fh, err := os.OpenFile(ts.tmpdir+"/app.1.log", os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprint(fh, "second\n")
+		fh.Close()
+		checkLine(t, lines, "second")
+		if mode == "follow" {
+			checkLine(t, lines, "new")
+		} else {
+			select {
+			case line := <-lines:
+				t.Errorf("got line %q from the file newly linked to", line)
+			case <-time.After(2 * time.Second):
+			}
+		}
+
+		close(ts.abort)
+		checkLinesChanClosed(t, lines)
+		ts.stop()
+	}
+	ts := &testSetup{}
+	ts.start(t)
+	defer ts.stop()
+	ts.writeFile(t, ts.tmpdir+"/app.log", "")
+	conf := Config{
+		Paths:   []string{ts.tmpdir + "/app.log"},
+		Options: TailOptions{Symlinks: "both"},
+	}
+	if _, err := GetEntries(conf, ts.abort); err == nil {
+		t.Error("expected error for an unknown --tail.symlinks")
+	}
+}
+
 func TestRemoveStateFiles(t *testing.T) {
 	files := []string{
 		"foo.bar",
@@ -316,6 +1225,17 @@ func checkLinesChan(t *testing.T, actual chan string, expected []string) {
 	}
 }
 
+func checkLine(t *testing.T, actual chan string, expected string) {
+	select {
+	case line := <-actual:
+		if line != expected {
+			t.Errorf("got line '%s', expected line '%s'", line, expected)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("timed out waiting for line '%s'", expected)
+	}
+}
+
 func checkLinesChanClosed(t *testing.T, actual chan string) {
 	// this will block if actual never gets closed
 	for {
@@ -330,3 +1250,126 @@ func checkLinesChanClosed(t *testing.T, actual chan string) {
 		}
 	}
 }
+
+func TestGetDelimiter(t *testing.T) {
+	if d, err := getDelimiter(TailOptions{}); d != nil || err != nil {
+		t.Error("expected no delimiter when none is set")
+	}
+	for _, bad := range []TailOptions{
+		{RecordDelimiter: "x", RecordDelimiterRegex: "y"},
+		{RecordDelimiterRegex: "("},
+		{RecordDelimiter: `\q`},
+		{RecordDelimiter: `\0`, Frame: "null"},
+	} {
+		if _, err := getDelimiter(bad); err == nil {
+			t.Errorf("expected error for options %+v", bad)
+		}
+	}
+
+	// STDIN is split the same way
+	d, err := getDelimiter(TailOptions{RecordDelimiter: `\x1e`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLinesChan(t, readRecords(strings.NewReader("\x1e{\"a\":1}\n\x1e{\"b\":2}"), d, nil), []string{"{\"a\":1}\n", `{"b":2}`})
+}
+
+func TestTailDelimited(t *testing.T) {
+	for _, poll := range []bool{false, true} {
+		testTailDelimited(t, poll)
+	}
+}
+
+func testTailDelimited(t *testing.T, poll bool) {
+	ts := &testSetup{}
+	ts.start(t)
+	defer ts.stop()
+
+	// records with no newlines between them are sent as they're written
+	filename := ts.tmpdir + "/appliance.log"
+	stateFile := ts.tmpdir + "/appliance.leash.state"
+	ts.writeFile(t, filename, "one\x00two\x00thr")
+	conf := Config{
+		Paths: []string{filename},
+		Options: TailOptions{
+			ReadFrom:        "beginning",
+			Poll:            poll,
+			StateFile:       stateFile,
+			RecordDelimiter: `\0`,
+			RecordMaxSize:   16,
+		},
+	}
+	chanArr, err := GetEntries(conf, ts.abort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := chanArr[0]
+	checkLine(t, lines, "one")
+	checkLine(t, lines, "two")
+	// the record being written is sent once the delimiter after it is,
+	// keeping any whitespace in it
+	f, _ := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("ee\x00  four\n  \x00")
+	checkLine(t, lines, "three")
+	checkLine(t, lines, "  four\n  ")
+	// records that are too long are dropped
+	f.WriteString(strings.Repeat("x", 20))
+	f.WriteString(strings.Repeat("x", 20) + "\x00five\x00")
+	checkLine(t, lines, "five")
+	// once the file's rotated, the record at its end is complete
+	f.WriteString("six")
+	f.Close()
+	if err := os.Rename(filename, filename+".1"); err != nil {
+		t.Fatal(err)
+	}
+	ts.writeFile(t, filename, "seven\x00")
+	checkLine(t, lines, "six")
+	checkLine(t, lines, "seven")
+	close(ts.abort)
+	checkLinesChanClosed(t, lines)
+
+	// the position reached is kept in the statefile
+	state, err := readState(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Offset != 6 {
+		t.Errorf("expected offset 6, got %+v", state)
+	}
+}
+
+func TestReadFramed(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	varint := func(s string) string {
+		buf := make([]byte, binary.MaxVarintLen64)
+		return string(buf[:binary.PutUvarint(buf, uint64(len(s)))]) + s
+	}
+	long := strings.Repeat("x", 200)
+	tests := []struct {
+		frame    string
+		input    string
+		expected []string
+	}{
+		{"line", "one\ntwo\n", []string{"one", "two"}},
+		{"null", "./a file\x00./with\nnewline\x00./last", []string{"./a file", "./with\nnewline", "./last"}},
+		{"varint", varint("multi\nline") + varint(long) + varint(""), []string{"multi\nline", long, ""}},
+		// a truncated record is dropped
+		{"varint", varint("whole") + varint("truncated")[:4], []string{"whole"}},
+	}
+	for _, tt := range tests {
+		read, err := getFrameReader(tt.frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var records []string
+		for record := range readFramed(strings.NewReader(tt.input), read, nil) {
+			records = append(records, record)
+		}
+		if !reflect.DeepEqual(records, tt.expected) {
+			t.Errorf("frame %s got records %q, expected %q", tt.frame, records, tt.expected)
+		}
+	}
+	if _, err := getFrameReader("json"); err == nil {
+		t.Error("expected error for an unknown frame")
+	}
+}
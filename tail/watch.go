@@ -0,0 +1,346 @@
+package tail
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"gopkg.in/fsnotify.v1"
+)
+
+// fileWatcher rescans glob patterns and directories while tailing, to start
+// tailing files that appear in them and stop tailing files that have been
+// deleted
+type fileWatcher struct {
+	// conf lists only the patterns and directories to rescan
+	conf  Config
+	delim *delimiter
+	abort <-chan struct{}
+	// tailers holds the files being tailed because they match a pattern or
+	// are in a directory, or that have been read, by device and inode. A
+	// file turning up under another name, as it does when it's rotated by
+	// renaming, has been read already and isn't read again.
+	tailers map[fileID]*tailed
+	// notify reports changes to the directories in watched, unless polling
+	notify  *fsnotify.Watcher
+	watched map[string]bool
+}
+
+// newFileWatcher returns a watcher for the glob patterns and directories in
+// conf, or nil if there are none or they shouldn't be rescanned
+func newFileWatcher(conf Config, abort <-chan struct{}) *fileWatcher {
+	if conf.Options.Stop || conf.Options.RescanInterval == 0 {
+		return nil
+	}
+	var patterns []string
+	for _, filePath := range conf.Paths {
+		if isGlob(filePath) {
+			patterns = append(patterns, filePath)
+		}
+	}
+	if len(patterns) == 0 && len(conf.Dirs) == 0 {
+		return nil
+	}
+	conf.Paths = patterns
+	return &fileWatcher{
+		conf:    conf,
+		abort:   abort,
+		tailers: make(map[fileID]*tailed),
+		watched: make(map[string]bool),
+	}
+}
+
+// tailed is a file being tailed because it matches a pattern or is in a
+// directory
+type tailed struct {
+	// file is the path followed, which the file first read may since have
+	// been renamed away from; "" once it's no longer followed
+	file string
+	// f stops following file. Compressed files, which aren't followed, and
+	// named pipes have no follower.
+	f *follower
+}
+
+// add records that file is being tailed by f. If state was saved for a
+// previous incarnation of file that's since been rotated, that's been read
+// too.
+func (w *fileWatcher) add(file string, f *follower, state State) {
+	t := &tailed{file: file, f: f}
+	id, _, err := statID(file)
+	if err != nil {
+		return
+	}
+	w.tailers[id] = t
+	if state.INode != 0 {
+		w.tailers[state.previousID(id)] = t
+	}
+}
+
+// following returns the files followed, by path
+func (w *fileWatcher) following() map[string]*tailed {
+	followed := make(map[string]*tailed)
+	for _, t := range w.tailers {
+		if t.file != "" {
+			followed[t.file] = t
+		}
+	}
+	return followed
+}
+
+// isGlob returns true if path has any of the special characters used by
+// filepath.Match
+func isGlob(path string) bool {
+	for _, c := range path {
+		switch c {
+		case '*', '?', '[', '\\':
+			return true
+		}
+	}
+	return false
+}
+
+// watches returns true if file matches one of the watched patterns or is in
+// one of the watched directories
+func (w *fileWatcher) watches(file string) bool {
+	if inDirs(w.conf, file) {
+		return true
+	}
+	for _, pattern := range w.conf.Paths {
+		if ok, _ := filepath.Match(pattern, file); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// rescanDelay is how long to wait for a burst of changes to end before
+// rescanning
+const rescanDelay = 100 * time.Millisecond
+
+// run rescans the patterns and directories every interval until abort is
+// closed, then closes NewFiles. Unless polling, they're also rescanned as
+// soon as inotify (or kqueue) reports a file being created or removed in a
+// directory they cover; interval is then just a fallback.
+func (w *fileWatcher) run(interval time.Duration) {
+	defer close(w.conf.NewFiles)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	if !w.conf.Options.Poll {
+		notify, err := fsnotify.NewWatcher()
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"err": err,
+			}).Warn("failed to watch directories for new files; rescanning every --tail.rescan_interval instead")
+		} else {
+			defer notify.Close()
+			w.notify = notify
+			w.watchDirs()
+			events, errs = notify.Events, notify.Errors
+		}
+	}
+	// a burst of changes, such as a rotation, leads to a single rescan
+	var pending <-chan time.Time
+	for {
+		select {
+		case <-ticker.C:
+			w.rescan()
+		case ev := <-events:
+			if ev.Op&(fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 && pending == nil {
+				pending = time.After(rescanDelay)
+			}
+		case <-pending:
+			pending = nil
+			w.rescan()
+		case err := <-errs:
+			logrus.WithFields(logrus.Fields{
+				"err": err,
+			}).Debug("error watching directories for new files")
+		case <-w.abort:
+			return
+		}
+	}
+}
+
+// watchDirs adds a watch for each directory a new file could appear in:
+// the directory part of each pattern, if it has no wildcards, and the
+// directories of the files being tailed, as well as the directories to
+// tail and, if recursive, their subdirectories. Watches for directories
+// that are removed go with them.
+func (w *fileWatcher) watchDirs() {
+	if w.notify == nil {
+		return
+	}
+	dirs := make(map[string]bool)
+	for _, pattern := range w.conf.Paths {
+		if dir := filepath.Dir(pattern); !isGlob(dir) {
+			dirs[dir] = true
+		}
+	}
+	for file := range w.following() {
+		dirs[filepath.Dir(file)] = true
+	}
+	for _, dir := range w.conf.Dirs {
+		dirs[dir] = true
+		if !w.conf.Recursive {
+			continue
+		}
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.IsDir() {
+				if path != dir && excluded(path, w.conf.Exclude) {
+					return filepath.SkipDir
+				}
+				dirs[path] = true
+			}
+			return nil
+		})
+	}
+	for dir := range dirs {
+		if w.watched[dir] {
+			continue
+		}
+		if err := w.notify.Add(dir); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"dir": dir,
+				"err": err,
+			}).Debug("failed to watch directory for new files")
+			continue
+		}
+		w.watched[dir] = true
+	}
+	// forget removed directories so they're watched again if they return
+	for dir := range w.watched {
+		if !dirs[dir] {
+			w.notify.Remove(dir)
+			delete(w.watched, dir)
+		}
+	}
+}
+
+// rescan starts tailing new matches from their beginning, as they were
+// written after we started, and stops tailing files that no longer match
+// once what's left of them has been read. Matches that have already been
+// read under another name, such as rotated copies of files being tailed,
+// are left alone.
+func (w *fileWatcher) rescan() {
+	files, err := listFiles(w.conf)
+	if err != nil {
+		// a directory may have been removed; the files in it are stopped
+		// below
+		logrus.WithFields(logrus.Fields{
+			"err": err,
+		}).Debug("failed to list some files to tail")
+	}
+	matches := make(map[string]fileID, len(files))
+	current := make(map[fileID]bool, len(files))
+	for _, file := range files {
+		// files removed since they were listed are left for the next rescan
+		if id, _, err := statID(file); err == nil {
+			matches[file] = id
+			current[id] = true
+		}
+	}
+	for file, t := range w.following() {
+		if id, ok := matches[file]; ok {
+			// the file at a followed path may have been replaced by a
+			// rotation, and is read by the same follower
+			w.tailers[id] = t
+			continue
+		}
+		logrus.WithFields(logrus.Fields{
+			"file": file,
+		}).Info("file has been deleted, no longer tailing it")
+		t.file = ""
+		if t.f != nil {
+			go t.f.StopAtEOF()
+		}
+	}
+	// forget files that are gone, as their inodes may be reused
+	for id := range w.tailers {
+		if !current[id] {
+			delete(w.tailers, id)
+		}
+	}
+	for file, id := range matches {
+		if t, ok := w.tailers[id]; ok {
+			if t.file != file {
+				logrus.WithFields(logrus.Fields{
+					"file": file,
+				}).Debug("skipping file that's already been read under another name")
+			}
+			continue
+		}
+		// files skipped for their age or size are looked at again, as
+		// they may have been written to since
+		if skipped(file, w.conf) {
+			continue
+		}
+		if isFIFO(file) {
+			logrus.WithFields(logrus.Fields{
+				"file": file,
+			}).Info("found new named pipe to tail")
+			// there's nothing to stop following a pipe when it's deleted
+			w.tailers[id] = &tailed{file: file}
+			if !w.send(tailFIFO(file, w.conf.Options.Stop, w.delim, w.abort)) {
+				return
+			}
+			continue
+		}
+		// compressed files turning up are rotated copies of files already
+		// read, and may still be being written
+		if compression, err := detectCompression(file); err == nil && compression != "" {
+			logrus.WithFields(logrus.Fields{
+				"file": file,
+			}).Debug("skipping new compressed file")
+			w.tailers[id] = &tailed{}
+			continue
+		}
+		conf := w.conf
+		conf.Options.ReadFrom = "beginning"
+		conf.Options.Lines = 0
+		// count the new file as one of several so it doesn't take over a
+		// statefile given for a single file
+		stateFile := stateFileFor(conf, file, len(w.tailers)+2)
+		lines, f, err := w.tail(conf, file, stateFile)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"file": file,
+				"err":  err,
+			}).Warn("failed to start tailing new file")
+			continue
+		}
+		logrus.WithFields(logrus.Fields{
+			"file": file,
+		}).Info("found new file to tail")
+		w.add(file, f, State{})
+		if !w.send(lines) {
+			return
+		}
+	}
+	w.watchDirs()
+}
+
+// tail starts following a new file from its start
+func (w *fileWatcher) tail(conf Config, file string, stateFile string) (chan string, *follower, error) {
+	if w.delim != nil {
+		return tailDelimited(conf, file, stateFile, w.delim, w.abort)
+	}
+	tailer, err := getTailer(conf, file, stateFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	lines, f := tailSingleFile(tailer, file, stateFile, w.abort, "", 0, conf.Options.Symlinks != "inode")
+	return lines, f, nil
+}
+
+// send passes on the lines of a new file, returning false if aborted first
+func (w *fileWatcher) send(lines chan string) bool {
+	select {
+	case w.conf.NewFiles <- lines:
+		return true
+	case <-w.abort:
+		return false
+	}
+}
@@ -0,0 +1,229 @@
+// Package throttle limits the bandwidth honeytail uses sending to Honeycomb,
+// for hosts on constrained links where it must never saturate the uplink,
+// and how fast lines are read when backfilling.
+package throttle
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// units are the multipliers for the supported bandwidth units, in bytes
+var units = map[string]float64{
+	"b":    1,
+	"kb":   1000,
+	"mb":   1000 * 1000,
+	"gb":   1000 * 1000 * 1000,
+	"kib":  1024,
+	"mib":  1024 * 1024,
+	"gib":  1024 * 1024 * 1024,
+	"bit":  1.0 / 8,
+	"kbit": 1000.0 / 8,
+	"mbit": 1000 * 1000.0 / 8,
+	"gbit": 1000 * 1000 * 1000.0 / 8,
+}
+
+// ParseBandwidth parses a rate like 5MB/s, 512KiB/s or 10Mbit/s into bytes
+// per second. The /s is optional, and a bare number is in bytes.
+func ParseBandwidth(s string) (int64, error) {
+	str := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "/s")
+	i := strings.IndexFunc(str, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	num, unit := str, "b"
+	if i >= 0 {
+		num, unit = str[:i], strings.TrimSpace(str[i:])
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bandwidth %q should be a number followed by a unit, eg 5MB/s", s)
+	}
+	mult, ok := units[unit]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q in bandwidth %q", unit, s)
+	}
+	rate := int64(n * mult)
+	if rate < 1 {
+		return 0, fmt.Errorf("bandwidth %q must be at least one byte per second", s)
+	}
+	return rate, nil
+}
+
+// Rate is a limit on reading lines, counting either lines or their bytes
+type Rate struct {
+	PerSec int64
+	// Lines is set if PerSec counts lines rather than bytes
+	Lines bool
+}
+
+// ParseRate parses a rate like 500 lines/s, or a bandwidth as
+// ParseBandwidth does, eg 5MB/s
+func ParseRate(s string) (Rate, error) {
+	str := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "/s")
+	for _, unit := range []string{"lines", "line"} {
+		if !strings.HasSuffix(str, unit) {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(strings.TrimSuffix(str, unit)), 10, 64)
+		if err != nil || n < 1 {
+			return Rate{}, fmt.Errorf("rate %q should be a whole number of lines, eg 500 lines/s", s)
+		}
+		return Rate{PerSec: n, Lines: true}, nil
+	}
+	n, err := ParseBandwidth(s)
+	if err != nil {
+		return Rate{}, err
+	}
+	return Rate{PerSec: n}, nil
+}
+
+// String formats the rate as ParseRate reads it
+func (r Rate) String() string {
+	if r.Lines {
+		return fmt.Sprintf("%d lines/s", r.PerSec)
+	}
+	return fmt.Sprintf("%dB/s", r.PerSec)
+}
+
+// LineLimiter paces lines to a Rate
+type LineLimiter struct {
+	limiter *Limiter
+	lines   bool
+}
+
+// NewLineLimiter returns a limiter allowing lines at rate, in bursts of up
+// to a second's worth
+func NewLineLimiter(rate Rate) *LineLimiter {
+	return &LineLimiter{limiter: NewLimiter(rate.PerSec), lines: rate.Lines}
+}
+
+// Wait blocks until line may be read. Its size counts its newline.
+func (l *LineLimiter) Wait(line string) {
+	if l.lines {
+		l.limiter.wait(1)
+	} else {
+		l.limiter.wait(len(line) + 1)
+	}
+}
+
+// Lines passes on the lines read from lines no faster than all of limiters
+// allow, slowing reading from lines to match. Once abort is closed the rest
+// of lines is discarded.
+func Lines(lines chan string, limiters []*LineLimiter, abort <-chan struct{}) chan string {
+	limited := make(chan string)
+	go func() {
+		defer close(limited)
+		for line := range lines {
+			for _, l := range limiters {
+				l.Wait(line)
+			}
+			select {
+			case limited <- line:
+			case <-abort:
+				// let the reader finish up without us
+				go func() {
+					for range lines {
+					}
+				}()
+				return
+			}
+		}
+	}()
+	return limited
+}
+
+// Limiter is a token bucket of bytes, shared by everything it throttles
+type Limiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	lock   sync.Mutex
+	now    func() time.Time
+	sleep  func(time.Duration)
+}
+
+// NewLimiter returns a limiter allowing bytesPerSec, in bursts of up to a
+// second's worth
+func NewLimiter(bytesPerSec int64) *Limiter {
+	return &Limiter{
+		rate:   float64(bytesPerSec),
+		burst:  float64(bytesPerSec),
+		tokens: float64(bytesPerSec),
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// maxChunk is the most that may be waited for at once
+func (l *Limiter) maxChunk() int {
+	return int(l.burst)
+}
+
+// wait blocks until n bytes may be sent. Waiters reserve their bytes in
+// turn, so a large write can't starve smaller ones.
+func (l *Limiter) wait(n int) {
+	l.lock.Lock()
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.lock.Unlock()
+	if deficit > 0 {
+		l.sleep(time.Duration(deficit / l.rate * float64(time.Second)))
+	}
+}
+
+// Transport throttles the request bodies sent through it
+type Transport struct {
+	Base    http.RoundTripper
+	Limiter *Limiter
+}
+
+// NewTransport returns a RoundTripper that sends requests through base no
+// faster than bytesPerSec, in total
+func NewTransport(base http.RoundTripper, bytesPerSec int64) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{Base: base, Limiter: NewLimiter(bytesPerSec)}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		// RoundTrippers mustn't modify the request they're given
+		r := new(http.Request)
+		*r = *req
+		r.Body = &body{ReadCloser: req.Body, limiter: t.Limiter}
+		req = r
+	}
+	return t.Base.RoundTrip(req)
+}
+
+// body waits for the limiter before handing back what it reads
+type body struct {
+	io.ReadCloser
+	limiter *Limiter
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if max := b.limiter.maxChunk(); len(p) > max {
+		p = p[:max]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.limiter.wait(n)
+	}
+	return n, err
+}
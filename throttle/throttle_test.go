@@ -0,0 +1,167 @@
+package throttle
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected int64
+	}{
+		{"5MB/s", 5000000},
+		{"5mb", 5000000},
+		{"512KiB/s", 524288},
+		{"10Mbit/s", 1250000},
+		{"1.5 KB/s", 1500},
+		{"100", 100},
+	}
+	for _, tc := range testCases {
+		got, err := ParseBandwidth(tc.input)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tc.input, err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("parsing %q: got %d, expected %d", tc.input, got, tc.expected)
+		}
+	}
+	for _, bad := range []string{"", "fast", "5 furlongs/s", "1bit/s", "MB/s"} {
+		if _, err := ParseBandwidth(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected Rate
+	}{
+		{"500 lines/s", Rate{PerSec: 500, Lines: true}},
+		{"1line/s", Rate{PerSec: 1, Lines: true}},
+		{"20Lines", Rate{PerSec: 20, Lines: true}},
+		{"5MB/s", Rate{PerSec: 5000000}},
+		{"100", Rate{PerSec: 100}},
+	}
+	for _, tc := range testCases {
+		got, err := ParseRate(tc.input)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tc.input, err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("parsing %q: got %+v, expected %+v", tc.input, got, tc.expected)
+		}
+		if again, _ := ParseRate(got.String()); again != got {
+			t.Errorf("%q parsed back as %+v, expected %+v", got.String(), again, got)
+		}
+	}
+	for _, bad := range []string{"", "lines/s", "1.5 lines/s", "0 lines/s", "5 furlongs/s"} {
+		if _, err := ParseRate(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestLineLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	var slept time.Duration
+	fake := func(l *LineLimiter) *LineLimiter {
+		l.limiter.now = func() time.Time { return now }
+		l.limiter.sleep = func(d time.Duration) {
+			slept += d
+			now = now.Add(d)
+		}
+		return l
+	}
+	// a file's limited to 2 lines a second, and everything to 10 bytes
+	limiters := []*LineLimiter{
+		fake(NewLineLimiter(Rate{PerSec: 2, Lines: true})),
+		fake(NewLineLimiter(Rate{PerSec: 10})),
+	}
+	input := make(chan string)
+	go func() {
+		for _, line := range []string{"a", "b", "c", "0123456789"} {
+			input <- line
+		}
+		close(input)
+	}()
+	var got []string
+	for line := range Lines(input, limiters, nil) {
+		got = append(got, line)
+	}
+	if len(got) != 4 {
+		t.Errorf("got lines %q", got)
+	}
+	// c and the long line each wait half a second for a line, by when the
+	// bytes have refilled but for the one the long line's over by
+	if slept != 1100*time.Millisecond {
+		t.Errorf("slept %s, expected 1.1s", slept)
+	}
+}
+
+func TestLimiterWait(t *testing.T) {
+	now := time.Unix(0, 0)
+	var slept time.Duration
+	l := NewLimiter(1000)
+	l.now = func() time.Time { return now }
+	l.sleep = func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+	// the first second's worth goes straight through
+	l.wait(1000)
+	if slept != 0 {
+		t.Errorf("slept %s within the burst", slept)
+	}
+	// then each byte costs a millisecond
+	l.wait(500)
+	if slept != 500*time.Millisecond {
+		t.Errorf("slept %s, expected 500ms", slept)
+	}
+	// idle time refills the bucket, up to the burst
+	now = now.Add(time.Hour)
+	slept = 0
+	l.wait(1000)
+	l.wait(250)
+	if slept != 250*time.Millisecond {
+		t.Errorf("slept %s, expected 250ms", slept)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var received []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+
+	tr := NewTransport(nil, 1000)
+	now := time.Unix(0, 0)
+	var slept time.Duration
+	tr.Limiter.now = func() time.Time { return now }
+	tr.Limiter.sleep = func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+	client := &http.Client{Transport: tr}
+	payload := bytes.Repeat([]byte("x"), 3000)
+	resp, err := client.Post(ts.URL, "text/plain", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !bytes.Equal(received, payload) {
+		t.Errorf("server received %d bytes, expected %d", len(received), len(payload))
+	}
+	// 2000 bytes over the burst at 1000 bytes a second
+	if slept != 2*time.Second {
+		t.Errorf("slept %s sending 3000 bytes at 1000 bytes/s", slept)
+	}
+}
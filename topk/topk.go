@@ -0,0 +1,275 @@
+// Package topk keeps track of the most frequent values of a field, such as
+// the busiest client IPs, and summarizes them once per window. The counts
+// cover every event, so they stay accurate when the events themselves are
+// sampled aggressively.
+//
+// Counting uses the space-saving sketch (Metwally et al.), which keeps a
+// fixed number of counters however many distinct values there are. A value
+// that takes over a counter inherits its count as a possible overcount, which
+// is reported as topk.error.
+package topk
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+// prefix is added to the summary fields
+const prefix = "topk."
+
+// countersPerItem is how many counters the sketch keeps for each of the top
+// K values it reports; more counters make the counts more accurate
+const countersPerItem = 10
+
+type Config struct {
+	// Fields are the fields to find the most frequent values of, each
+	// counted separately
+	Fields []string
+	// K is how many values to report for each field
+	K int
+	// Window is how much event time each summary covers
+	Window time.Duration
+	// Dataset is where to send summaries; empty means the events' dataset
+	Dataset string
+}
+
+// Counter is a value's count in a Sketch
+type Counter struct {
+	Value interface{}
+	Count int
+	// Error is the most Count may be over by
+	Error int
+}
+
+// Sketch counts the most frequent values seen, in fixed space
+type Sketch struct {
+	capacity int
+	counters map[string]*Counter
+}
+
+// NewSketch returns a Sketch with room for capacity counters
+func NewSketch(capacity int) *Sketch {
+	return &Sketch{
+		capacity: capacity,
+		counters: make(map[string]*Counter, capacity),
+	}
+}
+
+// Add counts weight occurrences of value
+func (s *Sketch) Add(value interface{}, weight int) {
+	key := fmt.Sprintf("%v", value)
+	if c, ok := s.counters[key]; ok {
+		c.Count += weight
+		return
+	}
+	if len(s.counters) < s.capacity {
+		s.counters[key] = &Counter{Value: value, Count: weight}
+		return
+	}
+	// replace the smallest counter, whose count becomes the new value's
+	// possible overcount. Finding it is linear, but the sketch is small.
+	var minKey string
+	var min *Counter
+	for k, c := range s.counters {
+		if min == nil || c.Count < min.Count || (c.Count == min.Count && k < minKey) {
+			minKey, min = k, c
+		}
+	}
+	delete(s.counters, minKey)
+	s.counters[key] = &Counter{Value: value, Count: min.Count + weight, Error: min.Count}
+}
+
+// Top returns the k largest counters, largest first
+func (s *Sketch) Top(k int) []Counter {
+	keys := make([]string, 0, len(s.counters))
+	for key := range s.counters {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ci, cj := s.counters[keys[i]], s.counters[keys[j]]
+		if ci.Count != cj.Count {
+			return ci.Count > cj.Count
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > k {
+		keys = keys[:k]
+	}
+	top := make([]Counter, 0, len(keys))
+	for _, key := range keys {
+		top = append(top, *s.counters[key])
+	}
+	return top
+}
+
+// Tracker counts the values of each field over the current window
+type Tracker struct {
+	conf     Config
+	sketches map[string]*Sketch
+	// totals are the number of events in the window with each field
+	totals map[string]int
+	// window is the start of the current window
+	window   time.Time
+	lastWall time.Time
+	dataset  string
+	// inputs is the number of pipelines feeding the tracker
+	inputs int
+	lock   sync.Mutex
+	now    func() time.Time
+}
+
+// New returns a Tracker, or nil if conf has no fields
+func New(conf Config) *Tracker {
+	if len(conf.Fields) == 0 {
+		return nil
+	}
+	t := &Tracker{
+		conf: conf,
+		now:  time.Now,
+	}
+	t.reset()
+	return t
+}
+
+func (t *Tracker) reset() {
+	t.sketches = make(map[string]*Sketch, len(t.conf.Fields))
+	t.totals = make(map[string]int, len(t.conf.Fields))
+	for _, f := range t.conf.Fields {
+		t.sketches[f] = NewSketch(t.conf.K * countersPerItem)
+	}
+}
+
+// Add counts ev, returning the summaries of the window it ended, if any.
+// Events from before the current window are counted in it.
+func (t *Tracker) Add(ev event.Event) []event.Event {
+	window := ev.Timestamp.Truncate(t.conf.Window)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var summaries []event.Event
+	if window.After(t.window) {
+		summaries = t.summarize()
+		t.reset()
+		t.window = window
+	}
+	if t.dataset == "" {
+		t.dataset = ev.Dataset
+	}
+	t.lastWall = t.now()
+	// events that were sampled stand for several
+	weight := 1
+	if ev.SampleRate > 1 {
+		weight = ev.SampleRate
+	}
+	for _, f := range t.conf.Fields {
+		v, ok := ev.Data[f]
+		if !ok {
+			continue
+		}
+		t.sketches[f].Add(v, weight)
+		t.totals[f] += weight
+	}
+	return summaries
+}
+
+// Expire ends the current window if no events have arrived for a window's
+// worth of time, returning its summaries
+func (t *Tracker) Expire() []event.Event {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.now().Sub(t.lastWall) <= t.conf.Window {
+		return nil
+	}
+	summaries := t.summarize()
+	t.reset()
+	return summaries
+}
+
+// Flush ends the current window, returning its summaries
+func (t *Tracker) Flush() []event.Event {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	summaries := t.summarize()
+	t.reset()
+	return summaries
+}
+
+// summarize builds an event for each of the top values of each field
+func (t *Tracker) summarize() []event.Event {
+	dataset := t.conf.Dataset
+	if dataset == "" {
+		dataset = t.dataset
+	}
+	var summaries []event.Event
+	for _, f := range t.conf.Fields {
+		for i, c := range t.sketches[f].Top(t.conf.K) {
+			summaries = append(summaries, event.Event{
+				Timestamp: t.window,
+				Dataset:   dataset,
+				// the summary covers every event, whether or not it was sent
+				SampleRate: 1,
+				Data: map[string]interface{}{
+					f:                     c.Value,
+					prefix + "field":      f,
+					prefix + "rank":       i + 1,
+					prefix + "count":      c.Count,
+					prefix + "error":      c.Error,
+					prefix + "total":      t.totals[f],
+					prefix + "window_sec": t.conf.Window.Seconds(),
+				},
+			})
+		}
+	}
+	return summaries
+}
+
+// Track passes through the events read from in, adding summary events as
+// windows end. Several inputs may share a Tracker, so that the counts span
+// them; the summaries of the last window are sent once the last of them is
+// closed.
+func Track(in chan event.Event, t *Tracker) chan event.Event {
+	if t == nil {
+		return in
+	}
+	t.lock.Lock()
+	t.inputs++
+	t.lock.Unlock()
+	out := make(chan event.Event, cap(in))
+	go func() {
+		defer close(out)
+		// check for idle windows a few times per window
+		ticker := time.NewTicker(t.conf.Window / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case ev, ok := <-in:
+				if !ok {
+					t.lock.Lock()
+					t.inputs--
+					last := t.inputs == 0
+					t.lock.Unlock()
+					if last {
+						for _, summary := range t.Flush() {
+							out <- summary
+						}
+					}
+					return
+				}
+				summaries := t.Add(ev)
+				out <- ev
+				for _, summary := range summaries {
+					out <- summary
+				}
+			case <-ticker.C:
+				for _, summary := range t.Expire() {
+					out <- summary
+				}
+			}
+		}
+	}()
+	return out
+}
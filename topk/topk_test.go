@@ -0,0 +1,127 @@
+package topk
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeycombio/honeytail/event"
+)
+
+var start = time.Date(2019, 1, 7, 12, 0, 0, 0, time.UTC)
+
+func hit(ip string, offset time.Duration) event.Event {
+	return event.Event{
+		Timestamp: start.Add(offset),
+		Data:      map[string]interface{}{"client_ip": ip},
+	}
+}
+
+func TestSketch(t *testing.T) {
+	s := NewSketch(2)
+	s.Add("a", 3)
+	s.Add("b", 1)
+	// c takes over b's counter, inheriting its count as the error
+	s.Add("c", 1)
+	expected := []Counter{
+		{Value: "a", Count: 3},
+		{Value: "c", Count: 2, Error: 1},
+	}
+	if top := s.Top(5); !reflect.DeepEqual(top, expected) {
+		t.Errorf("got %+v, expected %+v", top, expected)
+	}
+	if top := s.Top(1); len(top) != 1 || top[0].Value != "a" {
+		t.Errorf("expected only a, got %+v", top)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	tr := New(Config{Fields: []string{"client_ip"}, K: 2, Window: time.Minute})
+	wall := start
+	tr.now = func() time.Time { return wall }
+
+	sampled := hit("10.0.0.2", 20*time.Second)
+	sampled.SampleRate = 5
+	for _, ev := range []event.Event{
+		hit("10.0.0.1", 0),
+		hit("10.0.0.1", 10*time.Second),
+		sampled,
+		hit("10.0.0.3", 30*time.Second),
+		// events without the field are ignored
+		{Timestamp: start, Data: map[string]interface{}{"status": 200}},
+	} {
+		if summaries := tr.Add(ev); len(summaries) != 0 {
+			t.Fatalf("no window should have ended yet, got %+v", summaries)
+		}
+	}
+
+	// the next minute ends the window
+	summaries := tr.Add(hit("10.0.0.3", time.Minute))
+	expected := []event.Event{
+		{
+			Timestamp:  start,
+			SampleRate: 1,
+			Data: map[string]interface{}{
+				"client_ip":       "10.0.0.2",
+				"topk.field":      "client_ip",
+				"topk.rank":       1,
+				"topk.count":      5,
+				"topk.error":      0,
+				"topk.total":      8,
+				"topk.window_sec": float64(60),
+			},
+		},
+		{
+			Timestamp:  start,
+			SampleRate: 1,
+			Data: map[string]interface{}{
+				"client_ip":       "10.0.0.1",
+				"topk.field":      "client_ip",
+				"topk.rank":       2,
+				"topk.count":      2,
+				"topk.error":      0,
+				"topk.total":      8,
+				"topk.window_sec": float64(60),
+			},
+		},
+	}
+	if !reflect.DeepEqual(summaries, expected) {
+		t.Errorf("got %+v, expected %+v", summaries, expected)
+	}
+
+	// the window isn't idle yet
+	if summaries := tr.Expire(); len(summaries) != 0 {
+		t.Errorf("expected no summaries, got %+v", summaries)
+	}
+	wall = wall.Add(2 * time.Minute)
+	summaries = tr.Expire()
+	if len(summaries) != 1 || summaries[0].Data["client_ip"] != "10.0.0.3" || summaries[0].Timestamp != start.Add(time.Minute) {
+		t.Errorf("expected the idle window to end, got %+v", summaries)
+	}
+	if summaries := tr.Flush(); len(summaries) != 0 {
+		t.Errorf("expected nothing left to flush, got %+v", summaries)
+	}
+}
+
+func TestTrack(t *testing.T) {
+	if New(Config{}) != nil {
+		t.Error("expected no tracker without fields")
+	}
+	tr := New(Config{Fields: []string{"client_ip"}, K: 20, Window: time.Minute, Dataset: "top_ips"})
+	in := make(chan event.Event, 3)
+	in <- hit("10.0.0.1", 0)
+	in <- hit("10.0.0.1", time.Second)
+	close(in)
+
+	var got []event.Event
+	for ev := range Track(in, tr) {
+		got = append(got, ev)
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected both events and a summary, got %+v", got)
+	}
+	summary := got[2]
+	if summary.Dataset != "top_ips" || summary.Data["topk.count"] != 2 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+}